@@ -0,0 +1,154 @@
+package otf
+
+import (
+	"fmt"
+)
+
+// Tools that work from the log rather than through a client
+// (linters, exporters, auditors) read its entries as LogEntry and
+// their actions as Action, the same types the client reads them as,
+// rather than reverse-engineer the JSON. A log iterator reads the
+// entries of a store in order, and an action visitor calls back for
+// each action of an entry by its kind.
+//
+// What's guaranteed, for entries of a given LOG_ENTRY_VERSION:
+//
+//   - Fields of the entry and action types are only ever added,
+//     never renamed, removed or given another meaning. Fields a tool
+//     doesn't know about are left unset by entries written before
+//     they were added.
+//   - New kinds of action may be added. Action has one field set,
+//     named by its kind, and ActionKind returns "" for kinds it
+//     doesn't know about, which a visitor skips; tools built against
+//     an older version of this package see an Action with none of
+//     the fields they know set, and should skip it too.
+//   - Anything that would make a tool misread an entry, rather than
+//     miss something in it, bumps LOG_ENTRY_VERSION, and entries of a
+//     later version than the package fail to decode with
+//     errLogVersion, unless they list features the package knows
+//     (see compat.go).
+//
+// Entries coalescing several transactions (see coalesce.go) are read
+// as one entry, of the last of them.
+
+const (
+	ACTION_ADD_DATAOBJECT    = "AddDataobject"
+	ACTION_CHANGE_METADATA   = "ChangeMetadata"
+	ACTION_DELETE_ROWS       = "DeleteRows"
+	ACTION_REMOVE_DATAOBJECT = "RemoveDataobject"
+	ACTION_PURGE             = "Purge"
+	ACTION_LINEAGE           = "Lineage"
+)
+
+// The kind of action, the name of its field that's set, or "" if
+// none are.
+func actionKind(action Action) string {
+	switch {
+	case action.AddDataobject != nil:
+		return ACTION_ADD_DATAOBJECT
+	case action.ChangeMetadata != nil:
+		return ACTION_CHANGE_METADATA
+	case action.DeleteRows != nil:
+		return ACTION_DELETE_ROWS
+	case action.RemoveDataobject != nil:
+		return ACTION_REMOVE_DATAOBJECT
+	case action.Purge != nil:
+		return ACTION_PURGE
+	case action.Lineage != nil:
+		return ACTION_LINEAGE
+	}
+
+	return ""
+}
+
+// Callbacks for each kind of action, any of which can be nil to skip
+// that kind. An error from one stops the visit and is returned.
+type ActionVisitor struct {
+	AddDataobject    func(entry *logEntry, action *DataobjectAction) error
+	ChangeMetadata   func(entry *logEntry, action *ChangeMetadataAction) error
+	DeleteRows       func(entry *logEntry, action *DeleteAction) error
+	RemoveDataobject func(entry *logEntry, action *RemoveAction) error
+	Purge            func(entry *logEntry, action *PurgeAction) error
+	Lineage          func(entry *logEntry, action *LineageAction) error
+}
+
+// Calls back for each of entry's actions, table by table in order
+// of name and each table's in the order they were taken.
+func (v *ActionVisitor) visit(entry *logEntry) error {
+	for _, table := range sortedKeys(entry.Actions) {
+		for _, action := range entry.Actions[table] {
+			var err error
+			switch actionKind(action) {
+			case ACTION_ADD_DATAOBJECT:
+				if v.AddDataobject != nil {
+					err = v.AddDataobject(entry, action.AddDataobject)
+				}
+			case ACTION_CHANGE_METADATA:
+				if v.ChangeMetadata != nil {
+					err = v.ChangeMetadata(entry, action.ChangeMetadata)
+				}
+			case ACTION_DELETE_ROWS:
+				if v.DeleteRows != nil {
+					err = v.DeleteRows(entry, action.DeleteRows)
+				}
+			case ACTION_REMOVE_DATAOBJECT:
+				if v.RemoveDataobject != nil {
+					err = v.RemoveDataobject(entry, action.RemoveDataobject)
+				}
+			case ACTION_PURGE:
+				if v.Purge != nil {
+					err = v.Purge(entry, action.Purge)
+				}
+			case ACTION_LINEAGE:
+				if v.Lineage != nil {
+					err = v.Lineage(entry, action.Lineage)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("transaction %d: %w", entry.Id, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Log entries committed after a transaction, in order.
+type logIterator struct {
+	d     *client
+	names []string
+	read  int
+}
+
+// The log entries of transactions committed after txId, -1 for all
+// of them, as of now.
+func (d *client) logSince(txId int) (*logIterator, error) {
+	names, err := d.listLog()
+	if err != nil {
+		return nil, err
+	}
+
+	it := &logIterator{d: d}
+	for _, name := range names {
+		if id, _ := parseLogEntryId(name); id > txId {
+			it.names = append(it.names, name)
+		}
+	}
+
+	return it, nil
+}
+
+// Returns (nil, nil) when done.
+func (it *logIterator) next() (*logEntry, error) {
+	if it.read == len(it.names) {
+		return nil, nil
+	}
+
+	entry, err := it.d.readLogEntry(it.names[it.read])
+	if err != nil {
+		return nil, err
+	}
+
+	it.read++
+	return entry, nil
+}
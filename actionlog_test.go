@@ -0,0 +1,91 @@
+package otf
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestLogIterator(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.deleteRows("x", where("a", OP_EQ, 1))
+	assertEq(err, nil, "could not delete")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	var kinds []string
+	v := &ActionVisitor{
+		ChangeMetadata: func(entry *logEntry, action *ChangeMetadataAction) error {
+			kinds = append(kinds, fmt.Sprint(entry.Id, ":", action.Table))
+			return nil
+		},
+		AddDataobject: func(entry *logEntry, action *DataobjectAction) error {
+			kinds = append(kinds, fmt.Sprint(entry.Id, ":", action.Rows))
+			return nil
+		},
+		DeleteRows: func(entry *logEntry, action *DeleteAction) error {
+			kinds = append(kinds, fmt.Sprint(entry.Id, ":", action.Rows))
+			return nil
+		},
+	}
+
+	it, err := c.logSince(-1)
+	assertEq(err, nil, "could not list log")
+	for {
+		entry, err := it.next()
+		assertEq(err, nil, "could not read entry")
+		if entry == nil {
+			break
+		}
+
+		err = v.visit(entry)
+		assertEq(err, nil, "could not visit")
+	}
+	assertEq(fmt.Sprint(kinds), "[0:x 0:1 1:[0]]", "actions visited")
+
+	it, err = c.logSince(0)
+	assertEq(err, nil, "could not list log")
+	entry, err := it.next()
+	assertEq(err, nil, "could not read entry")
+	assertEq(entry.Id, 1, "first entry after 0")
+	entry, err = it.next()
+	assert(entry == nil && err == nil, "read past the end")
+
+	// Tools reading objects themselves decode them the same.
+	bytes, err := mos.read(context.Background(), logEntryName(1))
+	assertEq(err, nil, "could not read entry")
+	decoded, err := decodeStoredLogEntry(logEntryName(1), bytes)
+	assertEq(err, nil, "could not decode entry")
+	assertEq(actionKind(decoded.Actions["x"][0]), ACTION_DELETE_ROWS, "kind")
+}
+
+// Every kind of action has a kind, and a callback to visit it by.
+func TestActionKinds(t *testing.T) {
+	assertEq(actionKind(Action{}), "", "kind of no action")
+
+	visitor := reflect.TypeOf(ActionVisitor{})
+	fields := reflect.VisibleFields(reflect.TypeOf(Action{}))
+	assertEq(visitor.NumField(), len(fields), "visitor callbacks")
+	for _, field := range fields {
+		var action Action
+		v := reflect.ValueOf(&action).Elem().FieldByIndex(field.Index)
+		v.Set(reflect.New(field.Type.Elem()))
+		assertEq(actionKind(action), field.Name, "kind")
+
+		_, ok := visitor.FieldByName(field.Name)
+		assert(ok, "no callback for "+field.Name)
+	}
+}
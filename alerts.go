@@ -0,0 +1,174 @@
+package otf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Commit hooks are called after each transaction a client commits
+// with what it changed. Hooks run before commitTx returns, once the
+// log entry is written, so they shouldn't take long; they can't fail
+// the commit, which has already happened.
+//
+// The built-in alerting hook checks each commit against simple
+// rules, a table's row count changing by more than a fraction, its
+// schema changing and more than some number of its rows deleted, and
+// POSTs any alerts to a webhook as JSON. Internal tables, the ones
+// starting with _ like _stats, aren't checked. Webhook failures are
+// logged and otherwise ignored.
+
+// What a commit changed in a table. Row counts are as the
+// transaction saw the table, so don't include rows committed
+// concurrently.
+type tableCommit struct {
+	RowsBefore    int
+	RowsAfter     int
+	SchemaChanged bool
+	// Rows violating advisory foreign keys, see foreignkey.go.
+	ForeignKeyViolations int
+}
+
+type commitEvent struct {
+	TxId       int
+	CommitInfo *CommitInfo
+	Tables     map[string]tableCommit
+
+	// The committing client's, for hooks to log to.
+	logger *slog.Logger
+}
+
+type commitHook func(event *commitEvent)
+
+func withCommitHook(hook commitHook) clientOption {
+	return func(c *client) {
+		c.commitHooks = append(c.commitHooks, hook)
+	}
+}
+
+// Rows of table not deleted after actions.
+func liveRows(actions []Action) int {
+	actions = liveActions(actions)
+	rows := 0
+	for _, action := range actions {
+		if action.AddDataobject != nil {
+			rows += action.AddDataobject.Rows
+		}
+	}
+	for _, deleted := range deletedRows(actions) {
+		rows -= len(deleted)
+	}
+
+	return rows
+}
+
+// What tx changed, once its rows are flushed.
+func (tx *transaction) commitEvent() *commitEvent {
+	event := &commitEvent{Tables: map[string]tableCommit{}}
+	for table, actions := range tx.Actions {
+		if len(actions) == 0 {
+			continue
+		}
+
+		tc := tableCommit{
+			RowsBefore: liveRows(tx.previousActions[table]),
+			RowsAfter:  liveRows(append(slices.Clone(tx.previousActions[table]), actions...)),
+		}
+		for _, action := range actions {
+			if action.ChangeMetadata != nil && action.ChangeMetadata.Op != "" {
+				tc.SchemaChanged = true
+			}
+		}
+		event.Tables[table] = tc
+	}
+
+	return event
+}
+
+type AlertRules struct {
+	// Alert when a commit changes a table's row count by more than
+	// this fraction of it, 0 to not. Empty tables filling up don't
+	// count.
+	RowChangeFraction float64
+	// Alert when a commit changes a table's schema.
+	SchemaChanges bool
+	// Alert when a commit leaves a table with more than this many
+	// fewer rows, 0 to not.
+	MaxDeletedRows int
+}
+
+type alert struct {
+	TxId    int
+	Table   string
+	Rule    string
+	Message string
+}
+
+const (
+	ALERT_ROW_CHANGE    = "row_change"
+	ALERT_SCHEMA_CHANGE = "schema_change"
+	ALERT_LARGE_DELETE  = "large_delete"
+)
+
+const ALERT_WEBHOOK_TIMEOUT = 10 * time.Second
+
+// The alerts event breaks rules.
+func (rules AlertRules) check(event *commitEvent) []alert {
+	var alerts []alert
+	for _, table := range sortedKeys(event.Tables) {
+		if strings.HasPrefix(table, "_") {
+			continue
+		}
+
+		tc := event.Tables[table]
+		change := tc.RowsAfter - tc.RowsBefore
+		if rules.RowChangeFraction > 0 && tc.RowsBefore > 0 && math.Abs(float64(change)) > rules.RowChangeFraction*float64(tc.RowsBefore) {
+			alerts = append(alerts, alert{event.TxId, table, ALERT_ROW_CHANGE, fmt.Sprintf("rows went from %d to %d", tc.RowsBefore, tc.RowsAfter)})
+		}
+
+		if rules.SchemaChanges && tc.SchemaChanged {
+			alerts = append(alerts, alert{event.TxId, table, ALERT_SCHEMA_CHANGE, "schema changed"})
+		}
+
+		if rules.MaxDeletedRows > 0 && -change > rules.MaxDeletedRows {
+			alerts = append(alerts, alert{event.TxId, table, ALERT_LARGE_DELETE, fmt.Sprintf("%d rows deleted", -change)})
+		}
+	}
+
+	return alerts
+}
+
+// A commit hook POSTing the alerts each commit breaks rules with to
+// url, as a JSON object with an Alerts array.
+func alertHook(url string, rules AlertRules) commitHook {
+	client := &http.Client{Timeout: ALERT_WEBHOOK_TIMEOUT}
+	return func(event *commitEvent) {
+		alerts := rules.check(event)
+		if len(alerts) == 0 {
+			return
+		}
+
+		body, err := json.Marshal(map[string][]alert{"Alerts": alerts})
+		if err != nil {
+			logTo(event.logger, slog.LevelWarn, "alerts", "could not encode alerts", "err", err)
+			return
+		}
+
+		res, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			logTo(event.logger, slog.LevelWarn, "alerts", "could not send alerts", "url", url, "err", err)
+			return
+		}
+		res.Body.Close()
+
+		if res.StatusCode/100 != 2 {
+			logTo(event.logger, slog.LevelWarn, "alerts", "webhook failed", "url", url, "status", res.Status)
+		}
+	}
+}
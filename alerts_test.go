@@ -0,0 +1,72 @@
+package otf
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestAlerts(t *testing.T) {
+	var mu sync.Mutex
+	var received []alert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Alerts []alert }
+		err := json.NewDecoder(r.Body).Decode(&body)
+		assertEq(err, nil, "could not decode alerts")
+		mu.Lock()
+		received = append(received, body.Alerts...)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	var events []*commitEvent
+	rules := AlertRules{RowChangeFraction: 0.5, SchemaChanges: true, MaxDeletedRows: 3}
+	c := newClient(newMemoryObjectStorage(), withCommitHook(alertHook(server.URL, rules)), withCommitHook(func(event *commitEvent) {
+		events = append(events, event)
+	}), withCommitStats())
+	commit := func(f func()) {
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+		f()
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+
+	// Filling an empty table doesn't alert, nor do internal tables.
+	commit(func() {
+		err := c.createTable("x", []string{"a"})
+		assertEq(err, nil, "could not create x")
+		for i := 0; i < 10; i++ {
+			err = c.writeRow("x", []any{i})
+			assertEq(err, nil, "could not write row")
+		}
+	})
+	assertEq(len(received), 0, "alerted")
+	assertEq(events[0].TxId, 0, "wrong tx")
+	assertEq(events[0].Tables["x"], tableCommit{RowsBefore: 0, RowsAfter: 10}, "wrong table commit")
+
+	// Small changes don't alert either.
+	commit(func() {
+		err := c.writeRow("x", []any{10})
+		assertEq(err, nil, "could not write row")
+		_, err = c.deleteRows("x", where("a", OP_EQ, 0))
+		assertEq(err, nil, "could not delete")
+	})
+	assertEq(len(received), 0, "alerted")
+	assertEq(events[1].Tables["x"], tableCommit{RowsBefore: 10, RowsAfter: 10}, "wrong table commit")
+
+	commit(func() {
+		_, err := c.deleteRows("x", where("a", OP_LT, 7))
+		assertEq(err, nil, "could not delete")
+		err = c.addColumn("x", "b")
+		assertEq(err, nil, "could not add column")
+	})
+	assertEq(fmt.Sprint(received), fmt.Sprint([]alert{
+		{2, "x", ALERT_ROW_CHANGE, "rows went from 10 to 4"},
+		{2, "x", ALERT_SCHEMA_CHANGE, "schema changed"},
+		{2, "x", ALERT_LARGE_DELETE, "6 rows deleted"},
+	}), "wrong alerts")
+}
@@ -0,0 +1,1267 @@
+package otf
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// The exported API. Everything else in the package is unexported and
+// used through these: a Client opens transactions (Tx) against a
+// Storage, and scanning a table in a transaction returns an
+// Iterator. Options, predicates and errors are re-exported so
+// callers can configure, filter and check errors with errors.Is.
+//
+//	c := otf.NewClient(otf.NewFileStorage("/tmp/db"))
+//	tx, err := c.Begin()
+//	...
+//	err = tx.CreateTable("x", []string{"a", "b"})
+//	err = tx.WriteRow("x", []any{"a1", 23})
+//	err = tx.Commit()
+
+var (
+	ErrExistingTx   = errExistingTx
+	ErrNoTx         = errNoTx
+	ErrTableExists  = errTableExists
+	ErrNoTable      = errNoTable
+	ErrInvalidRow   = errInvalidRow
+	ErrNoColumn     = errNoColumn
+	ErrConflict     = errConflict
+	ErrTypeMismatch = errTypeMismatch
+	ErrNoVersion    = errNoVersion
+	ErrHistoricalTx = errHistoricalTx
+	ErrReadOnly     = errReadOnly
+	ErrDeltaLog     = errDeltaLog
+	ErrLogVersion   = errLogVersion
+	ErrCatalog      = errCatalog
+
+	ErrReadOnlyCatalog = errReadOnlyCatalog
+	ErrMaskedColumn    = errMaskedColumn
+	ErrIncomplete      = errIncomplete
+	ErrCorrupt         = errCorrupt
+	ErrDecrypt         = errDecrypt
+	ErrForeignKey      = errForeignKey
+	ErrNotNull         = errNotNull
+	ErrPrimaryKey      = errPrimaryKey
+	ErrColumnDefault   = errColumnDefault
+	ErrStorageCanary   = errStorageCanary
+	ErrUnsafeStorage   = errUnsafeStorage
+	ErrReadOnlyVersion = errReadOnlyVersion
+	ErrReadOnlyTx      = errReadOnlyTx
+	ErrNoRef           = errNoRef
+	ErrRefExists       = errRefExists
+	ErrScript          = errScript
+)
+
+// Stands for a column's default in a row passed to WriteRow, see
+// defaults.go.
+var Default any = useDefault
+
+// Where a store keeps its log and dataobjects. PutIfAbsent must be
+// atomic: of two concurrent puts of the same name exactly one
+// succeeds, and the other fails with an error wrapping fs.ErrExist.
+// ListPrefix returns names in order. Read of a missing name fails
+// with an error wrapping fs.ErrNotExist.
+type Storage interface {
+	PutIfAbsent(name string, bytes []byte) error
+	ListPrefix(prefix string) ([]string, error)
+	Read(name string) ([]byte, error)
+	Delete(name string) error
+}
+
+// One of the storages in this package, which may also support range
+// reads and stat (see rowgroup.go and vacuum.go).
+type builtinStorage struct {
+	os objectStorage
+}
+
+func (bs builtinStorage) PutIfAbsent(name string, bytes []byte) error {
+	return bs.os.putIfAbsent(context.Background(), name, bytes)
+}
+
+func (bs builtinStorage) ListPrefix(prefix string) ([]string, error) {
+	return bs.os.listPrefix(context.Background(), prefix)
+}
+
+func (bs builtinStorage) Read(name string) ([]byte, error) {
+	return bs.os.read(context.Background(), name)
+}
+
+func (bs builtinStorage) Delete(name string) error {
+	return bs.os.delete(context.Background(), name)
+}
+
+// A Storage implemented outside this package. Storage methods don't
+// take a context, so calls can only be canceled before they start.
+type externalStorage struct {
+	s Storage
+}
+
+func (es externalStorage) putIfAbsent(ctx context.Context, name string, bytes []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return es.s.PutIfAbsent(name, bytes)
+}
+
+func (es externalStorage) listPrefix(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return es.s.ListPrefix(prefix)
+}
+
+func (es externalStorage) read(ctx context.Context, name string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return es.s.Read(name)
+}
+
+func (es externalStorage) delete(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return es.s.Delete(name)
+}
+
+func toObjectStorage(s Storage) objectStorage {
+	if bs, ok := s.(builtinStorage); ok {
+		return bs.os
+	}
+
+	return externalStorage{s}
+}
+
+// Stores objects as files under dir.
+func NewFileStorage(dir string) Storage {
+	return builtinStorage{newFileObjectStorage(dir)}
+}
+
+// Stores objects in memory, for tests and scratch work.
+func NewMemoryStorage() Storage {
+	return builtinStorage{newMemoryObjectStorage()}
+}
+
+type S3Config = s3Config
+
+func NewS3Storage(cfg S3Config) Storage {
+	return builtinStorage{newS3ObjectStorage(cfg)}
+}
+
+type GCSConfig = gcsConfig
+
+func NewGCSStorage(cfg GCSConfig) Storage {
+	return builtinStorage{newGCSObjectStorage(cfg)}
+}
+
+// Encrypts objects stored in s with keys from keys, see
+// encryption.go.
+func NewEncryptedStorage(s Storage, keys KeyProvider) Storage {
+	return builtinStorage{newEncryptedObjectStorage(toObjectStorage(s), keys)}
+}
+
+// Keys held in memory, mapping id to key, encrypting new objects
+// with the key current.
+func NewStaticKeyProvider(keys map[string][]byte, current string) KeyProvider {
+	return staticKeyProvider{keys, current}
+}
+
+// Opens storage by URL: a path, file://, s3://, gs:// or http(s)://
+// (read-only).
+func OpenStorage(url string) (Storage, error) {
+	os, err := openObjectStorage(url)
+	if err != nil {
+		return nil, err
+	}
+
+	return builtinStorage{os}, nil
+}
+
+type Option = clientOption
+
+func WithCodec(codec string) Option {
+	return withCodec(codec)
+}
+
+func WithTableCache(maxRows int) Option {
+	return withTableCache(maxRows)
+}
+
+func WithDecodeParallelism(n int) Option {
+	return withDecodeParallelism(n)
+}
+
+// Decoded dataobjects kept in memory for reuse, see
+// dataobjectcache.go.
+type DataobjectCache = dataobjectCache
+
+// A cache of the most recently read dataobjects, up to budget bytes
+// of them as stored, which any number of clients can share.
+func NewDataobjectCache(budget int64) *DataobjectCache {
+	return newDataobjectCache(budget)
+}
+
+func WithDataobjectCache(cache *DataobjectCache) Option {
+	return withDataobjectCache(cache)
+}
+
+// Scans read up to n dataobjects ahead, downloading up to n at once
+// across the client's scans without waiting for a decoder, see
+// prefetch.go.
+func WithPrefetch(n int) Option {
+	return withPrefetch(n)
+}
+
+func WithCheckpointInterval(n int) Option {
+	return withCheckpointInterval(n)
+}
+
+func WithSnapshotRetention(keep int, retention time.Duration) Option {
+	return withSnapshotRetention(keep, retention)
+}
+
+func WithRowGroupSize(n int) Option {
+	return withRowGroupSize(n)
+}
+
+func WithCommitRetries(n int, backoff time.Duration) Option {
+	return withCommitRetries(n, backoff)
+}
+
+// Acts as identity, only seeing the rows it can, see rowsecurity.go.
+func WithIdentity(identity string) Option {
+	return withIdentity(identity)
+}
+
+// Restricts the rows an identity sees of a table to those matching
+// the predicate hook returns, if not nil, on top of the table's row
+// policies, see rowsecurity.go.
+func WithRowFilter(hook func(identity, table string) (*Predicate, error)) Option {
+	return withRowFilter(hook)
+}
+
+type CommitEvent = commitEvent
+type TableCommit = tableCommit
+
+// Calls hook after each commit with what it changed, before Commit
+// returns. See alerts.go.
+func WithCommitHook(hook func(*CommitEvent)) Option {
+	return withCommitHook(hook)
+}
+
+// POSTs alerts to url when commits break rules, see alerts.go.
+func WithAlerts(url string, rules AlertRules) Option {
+	return withCommitHook(alertHook(url, rules))
+}
+
+// Transactions conflict with ones that changed the dataobjects they
+// read rather than with ones that changed the tables they read.
+func WithReadValidation() Option {
+	return withReadValidation()
+}
+
+// Transactions also conflict with ones that added rows their scans
+// would have returned, so they're serializable rather than snapshot
+// isolated.
+func WithSerializable() Option {
+	return withSerializable()
+}
+
+// Transactions see and commit to branch rather than main, see
+// branch.go.
+func WithBranch(branch string) Option {
+	return withBranch(branch)
+}
+
+func WithCommitStats() Option {
+	return withCommitStats()
+}
+
+// Records hybrid logical clock commit times, see hlc.go.
+func WithHybridLogicalClock() Option {
+	return withCommitClock(hybridLogicalClock{})
+}
+
+func WithLogMirror(path string) Option {
+	return withLogMirror(path)
+}
+
+// Keeps the log as a Delta Lake table's, see delta.go.
+func WithDeltaLog() Option {
+	return withDeltaLog()
+}
+
+// An Iceberg REST catalog tables are registered with, see
+// restcatalog.go.
+type Catalog struct {
+	c *restCatalog
+}
+
+func NewRESTCatalog(baseURL, namespace, token string) *Catalog {
+	return &Catalog{newRESTCatalog(baseURL, namespace, token)}
+}
+
+type CatalogTable = catalogTable
+
+// Every table registered under the catalog's namespace.
+func (c *Catalog) Tables() ([]string, error) {
+	return c.c.listTables()
+}
+
+func (c *Catalog) Table(name string) (*CatalogTable, error) {
+	return c.c.loadTable(name)
+}
+
+// Serves a read-only view of catalogs, one per namespace, showing
+// and masking what config says, see catalogview.go. Requests must
+// carry token as a bearer token unless it's empty.
+func NewCatalogView(catalogs []*Catalog, config CatalogViewConfig, token string) http.Handler {
+	var sources []*restCatalog
+	for _, cat := range catalogs {
+		sources = append(sources, cat.c)
+	}
+
+	return newCatalogView(sources, config, token)
+}
+
+// Registers tables committed to with cat as held by the store at
+// the storage URL store, and resolves their locations from it.
+func WithCatalog(cat *Catalog, store string) Option {
+	return withCatalog(cat.c, store)
+}
+
+// Acquires credentials for roles tables are stored as, see
+// WithTableLocation.
+type Credentials = credentials
+
+func WithCredentialProvider(p func(role string) (Credentials, error)) Option {
+	return withCredentialProvider(p)
+}
+
+// Rewrites a column's values in rows compaction finds old enough,
+// see WithColumnTransform.
+type ColumnTransform = columnTransform
+
+func HashTransform() ColumnTransform {
+	return hashTransform()
+}
+
+func TruncateIPTransform() ColumnTransform {
+	return truncateIPTransform()
+}
+
+func NullTransform() ColumnTransform {
+	return nullTransform()
+}
+
+// Applies t to column of table's rows once compaction finds them at
+// least after old, see Tx.Compact.
+func WithColumnTransform(table, column string, after time.Duration, t ColumnTransform) Option {
+	return withColumnTransform(table, column, after, t)
+}
+
+type TableOption = tableOption
+
+func WithPartitionColumns(columns ...string) TableOption {
+	return withPartitionColumns(columns...)
+}
+
+// Maps columns to one of the COLUMN_ types.
+func WithColumnTypes(types map[string]string) TableOption {
+	return withColumnTypes(types)
+}
+
+// Stores the table's dataobjects in the storage at url rather than
+// with the log, accessed as role if not empty.
+func WithTableLocation(url, role string) TableOption {
+	return withTableLocation(url, role)
+}
+
+// Drops rows whose columns equal those of a row written earlier in
+// the transaction or committed less than window ago, see dedupe.go.
+func WithDedupe(window time.Duration, columns ...string) TableOption {
+	return withDedupe(window, columns...)
+}
+
+// Sorts the table's rows by columns in turn whenever they're
+// written, see cluster.go.
+func WithSortOrder(columns ...string) TableOption {
+	return withSortOrder(columns...)
+}
+
+// Z-orders the table's rows by columns whenever they're written,
+// see cluster.go.
+func WithZOrder(columns ...string) TableOption {
+	return withZOrder(columns...)
+}
+
+// Compresses each column with its own codec, "delta" or any codec
+// WithCodec takes, storing the table's dataobjects column by column,
+// see columncodec.go.
+func WithColumnCodecs(codecs map[string]string) TableOption {
+	return withColumnCodecs(codecs)
+}
+
+// Stores each group of columns as an object of its own per
+// dataobject so scans of other columns don't read it, see
+// families.go.
+func WithColumnFamilies(families ...[]string) TableOption {
+	return withColumnFamilies(families...)
+}
+
+// Checks at commit that the table's values of fk's columns are
+// values of the columns it references, see foreignkey.go.
+func WithForeignKey(fk ForeignKey) TableOption {
+	return withForeignKey(fk)
+}
+
+// Restricts the rows identities see of the table, see rowsecurity.go.
+func WithRowPolicies(policies ...RowPolicy) TableOption {
+	return withRowPolicies(policies...)
+}
+
+// Mapping column name to its default, which WriteRow fills in where
+// rows have Default or leave columns off their end, see defaults.go.
+func WithColumnDefaults(defaults map[string]ColumnDefault) TableOption {
+	return withColumnDefaults(defaults)
+}
+
+// Rejects rows with a null in any of columns, see constraints.go.
+func WithNotNull(columns ...string) TableOption {
+	return withNotNull(columns...)
+}
+
+// Checks at commit that no two rows of the table have the same
+// values of columns, which can't be null, see constraints.go.
+func WithPrimaryKey(columns ...string) TableOption {
+	return withPrimaryKey(columns...)
+}
+
+// Keeps a bloom filter of each of columns per dataobject so scans
+// filtering on one equal to a value skip dataobjects without it, see
+// bloomindex.go.
+func WithBloomFilters(columns ...string) TableOption {
+	return withBloomFilters(columns...)
+}
+
+// Keeps the table's data from being vacuumed until it has been
+// added for window.
+func WithComplianceWindow(window time.Duration) TableOption {
+	return withComplianceWindow(window)
+}
+
+type ScanOption = scanOption
+
+func WithColumns(columns ...string) ScanOption {
+	return withColumns(columns...)
+}
+
+func WithFilter(p *Predicate) ScanOption {
+	return withFilter(p)
+}
+
+// Appends the given METADATA_ columns, e.g. the transaction that
+// added each row, see metadatacolumns.go.
+func WithMetadataColumns(columns ...string) ScanOption {
+	return withMetadataColumns(columns...)
+}
+
+// Compares column to value with one of the OP_ operators.
+type Predicate = predicate
+
+func Where(column, op string, value any) *Predicate {
+	return where(column, op, value)
+}
+
+func And(ps ...*Predicate) *Predicate {
+	return and(ps...)
+}
+
+func Or(ps ...*Predicate) *Predicate {
+	return or(ps...)
+}
+
+// A column, a literal or an operator (OP_) applied to expressions,
+// e.g. for a column computed from others, see ColumnDefault.
+type Expr = expr
+
+func Col(column string) *Expr {
+	return colExpr(column)
+}
+
+func Lit(value any) *Expr {
+	return litExpr(value)
+}
+
+func Op(op string, args ...*Expr) *Expr {
+	return opExpr(op, args...)
+}
+
+// Safe for use by one goroutine at a time, with at most one
+// transaction open. Clients sharing storage, in one process or many,
+// are isolated from each other by snapshot isolation.
+type Client struct {
+	c client
+}
+
+func NewClient(s Storage, opts ...Option) *Client {
+	return &Client{newClient(toObjectStorage(s), opts...)}
+}
+
+// Starts a transaction seeing the latest committed version.
+func (c *Client) Begin() (*Tx, error) {
+	return c.begin(c.c.newTx())
+}
+
+// Like Begin but the transaction reads and writes object storage
+// with ctx, so canceling ctx stops it, see context.go.
+func (c *Client) BeginContext(ctx context.Context) (*Tx, error) {
+	return c.begin(c.c.newTxContext(ctx))
+}
+
+// Starts a transaction seeing the latest committed version that
+// can't write, failing writes with ErrReadOnlyTx, see readtx.go.
+func (c *Client) BeginRead() (*Tx, error) {
+	return c.begin(c.c.newReadTx())
+}
+
+// Like BeginRead but with ctx, as BeginContext.
+func (c *Client) BeginReadContext(ctx context.Context) (*Tx, error) {
+	return c.begin(c.c.newReadTxContext(ctx))
+}
+
+// Starts a read-only transaction seeing the store as of the
+// committed transaction txId.
+func (c *Client) BeginAt(txId int) (*Tx, error) {
+	return c.begin(c.c.newTxAt(txId))
+}
+
+// Starts a read-only transaction seeing the store as of t.
+func (c *Client) BeginAsOf(t time.Time) (*Tx, error) {
+	return c.begin(c.c.newTxAsOf(t))
+}
+
+// Starts a read-only transaction seeing the store as of the
+// transaction tag names, see branch.go.
+func (c *Client) BeginAtTag(tag string) (*Tx, error) {
+	return c.begin(c.c.newTxAtTag(tag))
+}
+
+func (c *Client) begin(err error) (*Tx, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{c: &c.c, tx: c.c.tx}, nil
+}
+
+// Scans only the rows of table added by transactions after fromTx,
+// -1 for all of them, up to and including toTx, as of toTx. See
+// incremental.go.
+func (c *Client) ScanIncremental(table string, fromTx, toTx int, opts ...ScanOption) (*Iterator, error) {
+	it, err := c.c.scanIncremental(table, fromTx, toTx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Iterator{it}, nil
+}
+
+type BackfillProgress = backfillProgress
+
+// Calls fn for each chunk [lo, hi) of [from, to), no more than chunk
+// long, in its own transaction along with recording the job's
+// progress, skipping chunks job already committed. fn must not
+// commit or abort tx. See backfill.go.
+func (c *Client) Backfill(job string, from, to, chunk int64, fn func(tx *Tx, lo, hi int64) error, progress func(BackfillProgress)) (BackfillProgress, error) {
+	return c.c.backfill(job, from, to, chunk, func(lo, hi int64) error {
+		return fn(&Tx{c: &c.c, tx: c.c.tx}, lo, hi)
+	}, progress)
+}
+
+type ParallelCompactResult = parallelCompactResult
+
+// Compacts table in jobs committed one by one, parallelism at a
+// time, recording run's plan and progress so a later call for the
+// same run, in this process or another, resumes it. The client must
+// not have a transaction open. See parallelcompact.go.
+func (c *Client) CompactParallel(ctx context.Context, table, run string, parallelism int, progress func(ParallelCompactResult)) (ParallelCompactResult, error) {
+	return c.c.compactParallel(ctx, table, run, parallelism, progress)
+}
+
+type SQLResult = sqlResult
+
+// Runs a SQL statement, see sql.go, in the open transaction if
+// there is one and otherwise in one of its own.
+func (c *Client) Exec(text string, args ...any) (*SQLResult, error) {
+	return c.c.execSQL(text, args...)
+}
+
+type ScriptOption = scriptOption
+type ScriptResult = scriptResult
+
+// Runs the semicolon separated SQL statements of text in order, with
+// BEGIN and COMMIT for transactions and SET for variables, stopping
+// at the first that fails unless WithContinueOnError, see script.go.
+func (c *Client) ExecuteScript(text string, opts ...ScriptOption) (*ScriptResult, error) {
+	return c.c.execScript(context.Background(), text, opts...)
+}
+
+// Like ExecuteScript but transactions make their storage calls with
+// ctx, and the script stops before its next statement once ctx is
+// done.
+func (c *Client) ExecuteScriptContext(ctx context.Context, text string, opts ...ScriptOption) (*ScriptResult, error) {
+	return c.c.execScript(ctx, text, opts...)
+}
+
+func WithContinueOnError() ScriptOption {
+	return withContinueOnError()
+}
+
+// Sets :name to value for the script, see script.go.
+func WithScriptVar(name string, value any) ScriptOption {
+	return withScriptVar(name, value)
+}
+
+type LineageRecord = lineageRecord
+
+// Every committed derivation of table's rows from other tables, see
+// Tx.RecordLineage.
+func (c *Client) Lineage(table string) ([]LineageRecord, error) {
+	return c.c.lineage(table)
+}
+
+type RowChange = rowChange
+
+// Rows inserted and deleted by transactions committed after txId,
+// see cdc.go.
+type ChangeIterator struct {
+	it *changeIterator
+}
+
+// Returns (nil, nil) when done.
+func (it *ChangeIterator) Next() (*RowChange, error) {
+	return it.it.next()
+}
+
+// Every change committed after txId, -1 for all of them, up to the
+// end of the log.
+func (c *Client) ChangesSince(txId int) (*ChangeIterator, error) {
+	it, err := c.c.changesSince(txId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChangeIterator{it}, nil
+}
+
+// Like ChangesSince but once caught up waits for more changes,
+// polling every interval, until stop is closed.
+func (c *Client) TailChanges(txId int, interval time.Duration, stop <-chan struct{}) (*ChangeIterator, error) {
+	it, err := c.c.tailChanges(txId, interval, stop)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChangeIterator{it}, nil
+}
+
+// Where SyncTable replicates a table to, see sync.go.
+type SyncDestination = syncDestination
+
+// Replicates into table of the store dst.
+func NewOtfSyncDestination(dst *Client, table string) SyncDestination {
+	return &otfSyncDestination{&dst.c, table}
+}
+
+// Replicates into table of db, through whichever Postgres driver db
+// was opened with, upserting rows on the key columns. Fails with
+// ErrNoColumn if a key isn't one of columns.
+func NewPostgresSyncDestination(db *sql.DB, table string, columns, keys []string) (SyncDestination, error) {
+	psd, err := newPostgresSyncDestination(db, table, columns, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	return psd, nil
+}
+
+// Applies every change to table committed since dst last applied
+// one, a transaction at a time. Returns how many transactions were
+// applied.
+func (c *Client) SyncTable(table string, dst SyncDestination) (int, error) {
+	return c.c.syncTable(table, dst)
+}
+
+type (
+	LogEntry         = logEntry
+	ColumnStats      = columnStats
+	RowGroup         = rowGroup
+	BloomFilter      = bloomFilter
+	DataobjectFamily = dataobjectFamily
+)
+
+// Decodes bytes, read from the log entry object name of a store in
+// either log format, see actionlog.go.
+func DecodeLogEntry(name string, bytes []byte) (*LogEntry, error) {
+	return decodeStoredLogEntry(name, bytes)
+}
+
+// One of the ACTION_ constants, or "" for a kind of action this
+// package doesn't know about.
+func ActionKind(action Action) string {
+	return actionKind(action)
+}
+
+// Calls back for each of entry's actions by their kind, see
+// actionlog.go.
+func (v *ActionVisitor) Visit(entry *LogEntry) error {
+	return v.visit(entry)
+}
+
+// Log entries in the order they were committed.
+type LogIterator struct {
+	it *logIterator
+}
+
+// Returns (nil, nil) when done.
+func (it *LogIterator) Next() (*LogEntry, error) {
+	return it.it.next()
+}
+
+// The log entries of transactions committed after txId, -1 for all
+// of them, up to the end of the log.
+func (c *Client) LogSince(txId int) (*LogIterator, error) {
+	it, err := c.c.logSince(txId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LogIterator{it}, nil
+}
+
+type VacuumResult = vacuumResult
+
+// Deletes dataobjects no version newer than retention ago can see,
+// or only reports them if dryRun.
+func (c *Client) Vacuum(retention time.Duration, dryRun bool) (*VacuumResult, error) {
+	return c.c.vacuum(retention, dryRun)
+}
+
+// Like Vacuum but stops deleting once ctx is done, reporting what's
+// left, see bulk.go.
+func (c *Client) VacuumContext(ctx context.Context, retention time.Duration, dryRun bool) (*VacuumResult, error) {
+	return c.c.vacuumContext(ctx, retention, dryRun)
+}
+
+type CoalesceResult = coalesceResult
+
+// Rewrites runs of small log entries behind the latest checkpoint
+// committed more than retention ago into one entry each, see
+// coalesce.go.
+func (c *Client) CoalesceLog(retention time.Duration) (*CoalesceResult, error) {
+	return c.c.coalesceLog(retention)
+}
+
+type TableManifest = tableManifest
+
+// table's latest manifest, written along with checkpoints, or nil if
+// it has none yet, see manifest.go.
+func (c *Client) TableManifest(table string) (*TableManifest, error) {
+	return c.c.readManifest(table)
+}
+
+type ManifestCheck = manifestCheck
+
+// table's dataobjects as of its manifest, or its latest version if
+// it has none yet, cross-checking the manifest against the log as
+// WithManifestVerification says, see manifestverify.go.
+func (c *Client) PlanTable(table string) (*TableManifest, error) {
+	return c.c.planTable(table)
+}
+
+// Cross-checks table's latest manifest against the log, replacing it
+// if it drifted, see manifestverify.go.
+func (c *Client) VerifyManifest(table string) (*ManifestCheck, error) {
+	return c.c.verifyLatestManifest(table)
+}
+
+// Cross-checks rate of PlanTable's manifests against the log, 1 for
+// all of them, see manifestverify.go.
+func WithManifestVerification(rate float64) Option {
+	return withManifestVerification(rate)
+}
+
+type ExpireResult = expireResult
+
+// Replaces the log through the latest checkpoint before the latest
+// keep transactions and committed more than retention ago with one
+// entry, see expire.go.
+func (c *Client) ExpireSnapshots(keep int, retention time.Duration) (*ExpireResult, error) {
+	return c.c.expireSnapshots(keep, retention)
+}
+
+type Ref = ref
+type MergeResult = mergeResult
+
+// Starts branch after the committed transaction at of main, or the
+// latest if at is -1, see branch.go.
+func (c *Client) CreateBranch(branch string, at int) (*Ref, error) {
+	return c.c.createBranch(branch, at)
+}
+
+// Names the committed transaction at of branch, or its latest if at
+// is -1, see branch.go.
+func (c *Client) CreateTag(tag, branch string, at int) (*Ref, error) {
+	return c.c.createTag(tag, branch, at)
+}
+
+func (c *Client) Branches() ([]Ref, error) {
+	return c.c.listRefs(REF_BRANCH)
+}
+
+func (c *Client) Tags() ([]Ref, error) {
+	return c.c.listRefs(REF_TAG)
+}
+
+func (c *Client) DeleteBranch(branch string) error {
+	return c.c.deleteRef(REF_BRANCH, branch)
+}
+
+func (c *Client) DeleteTag(tag string) error {
+	return c.c.deleteRef(REF_TAG, tag)
+}
+
+// Moves the client to branch, MAIN_BRANCH for main, for the
+// transactions it starts after, see branch.go.
+func (c *Client) Checkout(branch string) error {
+	return c.c.checkout(branch)
+}
+
+// Commits branch's changes since it was last merged to main, failing
+// with ErrConflict if main's since conflict with them, see
+// branch.go.
+func (c *Client) Merge(branch string) (*MergeResult, error) {
+	return c.c.mergeBranch(branch)
+}
+
+type CanaryResult = canaryResult
+
+// Probes s once with an object of its own, timing each step and
+// checking its conditional puts are, see canary.go. The result's
+// Error is empty if the probe found nothing wrong.
+func ProbeStorage(ctx context.Context, s Storage) CanaryResult {
+	return probeStorage(ctx, toObjectStorage(s))
+}
+
+type StorageVerification = storageVerification
+
+// Checks with concurrent calls that s's conditional puts are atomic,
+// reads see writes and listings see every object, see
+// storageverify.go.
+func VerifyStorage(ctx context.Context, s Storage) StorageVerification {
+	return verifyStorage(ctx, toObjectStorage(s), STORAGE_VERIFY_CONCURRENCY, nil)
+}
+
+// Reports what the client does against storage, scans, commits and
+// flushes to m, see metrics.go.
+func WithMetrics(m Metrics) Option {
+	return withMetrics(m)
+}
+
+// Logs what the client does to l, at debug level, and failures it
+// carries on after at warn level, see logging.go. Without it the
+// client logs nothing.
+func WithLogger(l *slog.Logger) Option {
+	return withLogger(l)
+}
+
+// Metrics kept in memory, served as an http.Handler in Prometheus'
+// text format.
+type PrometheusMetrics = prometheusMetrics
+
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return newPrometheusMetrics()
+}
+
+// Verifies the storage as VerifyStorage does before creating the
+// client's first table, failing with ErrUnsafeStorage if it fails.
+func WithStorageVerification() Option {
+	return withStorageVerification()
+}
+
+// Serves the store over HTTP, see server.go. Requests must carry
+// token as a bearer token unless it's empty.
+func NewServer(s Storage, token string, opts ...Option) http.Handler {
+	return newServer(toObjectStorage(s), token, opts...)
+}
+
+// Like NewServer but also accepts the tokens of identities, whose
+// transactions only see the rows the identity can, see
+// rowsecurity.go.
+func NewServerWithIdentities(s Storage, token string, identities map[string]string, opts ...Option) http.Handler {
+	srv := newServer(toObjectStorage(s), token, opts...)
+	srv.identities = identities
+	return srv
+}
+
+// Calls the gRPC service of a server, see grpc.go. Transactions are
+// referred to by the ids BeginTx returns.
+type GRPCClient struct {
+	gc *grpcClient
+}
+
+// hc must speak HTTP/2 to baseURL, e.g. an http.Client with TLS
+// configured and a transport with ForceAttemptHTTP2 set.
+func NewGRPCClient(baseURL, token string, hc *http.Client) *GRPCClient {
+	return &GRPCClient{newGRPCClient(baseURL, token, hc)}
+}
+
+func (c *GRPCClient) BeginTx() (string, error) {
+	tx, _, err := c.gc.beginTx(nil)
+	return tx, err
+}
+
+// Begins a read-only transaction as of the committed transaction
+// txId.
+func (c *GRPCClient) BeginTxAt(txId int) (string, error) {
+	tx, _, err := c.gc.beginTx(&txId)
+	return tx, err
+}
+
+func (c *GRPCClient) CreateTable(tx, table string, columns []string, types map[string]string, partitionBy []string) error {
+	return c.gc.createTable(tx, table, columns, types, partitionBy)
+}
+
+func (c *GRPCClient) WriteRows(tx, table string, rows [][]any) (int, error) {
+	return c.gc.writeRows(tx, table, rows)
+}
+
+// Streams columns of table's rows, all columns if nil, stopping
+// after limit rows if it's positive. The scan must be read to the
+// end.
+func (c *GRPCClient) Scan(tx, table string, columns []string, limit int) (*GRPCScan, error) {
+	it, err := c.gc.scan(tx, table, columns, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GRPCScan{it}, nil
+}
+
+// Returns the id the transaction committed as.
+func (c *GRPCClient) Commit(tx string) (int, error) {
+	return c.gc.commit(tx)
+}
+
+func (c *GRPCClient) Abort(tx string) error {
+	return c.gc.abort(tx)
+}
+
+type GRPCScan struct {
+	it *grpcScanIterator
+}
+
+func (s *GRPCScan) Columns() []string {
+	return s.it.columns
+}
+
+// Returns (nil, nil) when done.
+func (s *GRPCScan) Next() ([]any, error) {
+	return s.it.next()
+}
+
+// A transaction opened by a Client. Once committed or aborted it
+// can't be used again.
+type Tx struct {
+	c  *client
+	tx *transaction
+}
+
+// Whether tx is still the client's open transaction.
+func (tx *Tx) open() error {
+	if tx.c.tx == nil || tx.c.tx != tx.tx {
+		return errNoTx
+	}
+
+	return nil
+}
+
+// Why the transaction can't commit writes, because the log has
+// entries of a later version, nil if it can, see compat.go.
+func (tx *Tx) Restrictions() ([]string, error) {
+	if err := tx.open(); err != nil {
+		return nil, err
+	}
+
+	return tx.c.restrictions()
+}
+
+// The id of the log entry the transaction will commit as, or the
+// version a historical transaction sees.
+func (tx *Tx) ID() int {
+	return tx.tx.Id
+}
+
+func (tx *Tx) CreateTable(table string, columns []string, opts ...TableOption) error {
+	if err := tx.open(); err != nil {
+		return err
+	}
+
+	return tx.c.createTable(table, columns, opts...)
+}
+
+func (tx *Tx) AddColumn(table, column string) error {
+	if err := tx.open(); err != nil {
+		return err
+	}
+
+	return tx.c.addColumn(table, column)
+}
+
+func (tx *Tx) DropColumn(table, column string) error {
+	if err := tx.open(); err != nil {
+		return err
+	}
+
+	return tx.c.dropColumn(table, column)
+}
+
+func (tx *Tx) RenameColumn(table, column, newName string) error {
+	if err := tx.open(); err != nil {
+		return err
+	}
+
+	return tx.c.renameColumn(table, column, newName)
+}
+
+// Lengthens table's compliance window, see WithComplianceWindow.
+func (tx *Tx) SetComplianceWindow(table string, window time.Duration) error {
+	if err := tx.open(); err != nil {
+		return err
+	}
+
+	return tx.c.setComplianceWindow(table, window)
+}
+
+// Replaces table's row policies, see WithRowPolicies.
+func (tx *Tx) SetRowPolicies(table string, policies []RowPolicy) error {
+	if err := tx.open(); err != nil {
+		return err
+	}
+
+	return tx.c.setRowPolicies(table, policies)
+}
+
+func (tx *Tx) WriteRow(table string, row []any) error {
+	if err := tx.open(); err != nil {
+		return err
+	}
+
+	return tx.c.writeRow(table, row)
+}
+
+// Writes rows read from r, CSV with a header or JSON lines, to table
+// and returns how many it wrote, see import.go.
+func (tx *Tx) Import(table string, r io.Reader, format string) (int, error) {
+	if err := tx.open(); err != nil {
+		return 0, err
+	}
+
+	return tx.c.importFrom(table, r, format)
+}
+
+type ImportResult = importResult
+
+// Like Import but skips the first skip rows of r and stops once ctx
+// is done, reporting how far it got, see bulk.go.
+func (tx *Tx) ImportContext(ctx context.Context, table string, r io.Reader, format string, skip int) (*ImportResult, error) {
+	if err := tx.open(); err != nil {
+		return nil, err
+	}
+
+	return tx.c.importContext(ctx, table, r, format, skip)
+}
+
+// Writes the rows of a scan of table with opts to w as CSV, JSON
+// lines or Parquet and returns how many it wrote, see export.go.
+func (tx *Tx) Export(table string, w io.Writer, format string, opts ...ScanOption) (int, error) {
+	if err := tx.open(); err != nil {
+		return 0, err
+	}
+
+	return tx.c.export(table, w, format, opts...)
+}
+
+// How many rows written to table in the transaction were dropped as
+// duplicates, see WithDedupe.
+func (tx *Tx) DedupedRows(table string) int {
+	return tx.c.dedupedRows(table)
+}
+
+// Deletes rows matching p, returning how many.
+func (tx *Tx) Delete(table string, p *Predicate) (int, error) {
+	if err := tx.open(); err != nil {
+		return 0, err
+	}
+
+	return tx.c.deleteRows(table, p)
+}
+
+// Sets columns of rows matching p to the given values, returning
+// how many rows changed.
+func (tx *Tx) Update(table string, p *Predicate, set map[string]any) (int, error) {
+	if err := tx.open(); err != nil {
+		return 0, err
+	}
+
+	assignments := map[string]*expr{}
+	for column, v := range set {
+		assignments[column] = litExpr(v)
+	}
+	return tx.c.updateRows(table, p, assignments)
+}
+
+// Upserts rows by keyColumns, returning how many were inserted and
+// how many updated.
+func (tx *Tx) Merge(table string, keyColumns []string, rows [][]any) (int, int, error) {
+	if err := tx.open(); err != nil {
+		return 0, 0, err
+	}
+
+	return tx.c.merge(table, keyColumns, rows)
+}
+
+// Removes rows matching p from table for good, see purge.go.
+func (tx *Tx) Purge(table string, p *Predicate, deleteNow bool) (*PurgeAction, error) {
+	if err := tx.open(); err != nil {
+		return nil, err
+	}
+
+	return tx.c.purge(table, p, deleteNow)
+}
+
+type ForeignKeyViolation = foreignKeyViolation
+
+// Checks every row of table against its foreign keys, including
+// deferred ones, see foreignkey.go.
+func (tx *Tx) CheckForeignKeys(table string) ([]ForeignKeyViolation, error) {
+	if err := tx.open(); err != nil {
+		return nil, err
+	}
+
+	return tx.c.checkForeignKeys(table)
+}
+
+type CompactResult = compactResult
+
+// Rewrites table's small dataobjects and ones with deleted rows or
+// rows due for a column transform, see compact.go.
+func (tx *Tx) Compact(table string) (*CompactResult, error) {
+	if err := tx.open(); err != nil {
+		return nil, err
+	}
+
+	return tx.c.compact(table)
+}
+
+// Like Compact but stops once ctx is done, keeping the rewrites done
+// so far and reporting what's left, see bulk.go.
+func (tx *Tx) CompactContext(ctx context.Context, table string) (*CompactResult, error) {
+	if err := tx.open(); err != nil {
+		return nil, err
+	}
+
+	return tx.c.compactContext(ctx, table)
+}
+
+// Records that table's rows written in the transaction were derived
+// by query from sources, mapping each derived column to the source
+// columns (as table.column) it was computed from.
+func (tx *Tx) RecordLineage(table string, sources []string, columns []ColumnLineage, query string) error {
+	if err := tx.open(); err != nil {
+		return err
+	}
+
+	return tx.c.recordLineage(table, sources, columns, query)
+}
+
+func (tx *Tx) Scan(table string, opts ...ScanOption) (*Iterator, error) {
+	if err := tx.open(); err != nil {
+		return nil, err
+	}
+
+	it, err := tx.c.scan(table, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Iterator{it}, nil
+}
+
+// Like Scan but reads table with ctx rather than the transaction's
+// context, so canceling ctx stops just the scan.
+func (tx *Tx) ScanContext(ctx context.Context, table string, opts ...ScanOption) (*Iterator, error) {
+	if err := tx.open(); err != nil {
+		return nil, err
+	}
+
+	it, err := tx.c.scanContext(ctx, table, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Iterator{it}, nil
+}
+
+// Estimates how many rows of table match p without reading any
+// rows.
+func (tx *Tx) EstimateRows(table string, p *Predicate) (float64, error) {
+	if err := tx.open(); err != nil {
+		return 0, err
+	}
+
+	return tx.c.estimateRows(table, p)
+}
+
+func (tx *Tx) Commit() error {
+	if err := tx.open(); err != nil {
+		return err
+	}
+
+	return tx.c.commitTx()
+}
+
+// Discards the transaction and any dataobjects it wrote.
+func (tx *Tx) Abort() error {
+	if err := tx.open(); err != nil {
+		return err
+	}
+
+	return tx.c.abortTx()
+}
+
+// Rows of a scan, valid only until its transaction ends.
+type Iterator struct {
+	it *scanIterator
+}
+
+// Returns (nil, nil) when done.
+func (it *Iterator) Next() ([]any, error) {
+	return it.it.next()
+}
+
+type ScanCost = scanCost
+
+// What the scan has read from storage so far, and what it skipped.
+func (it *Iterator) Cost() ScanCost {
+	return it.it.cost()
+}
@@ -0,0 +1,160 @@
+package otf_test
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eatonphil/otf"
+)
+
+func TestAPI(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := otf.NewClient(otf.NewFileStorage(dir))
+	tx, err := c.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Begin()
+	if !errors.Is(err, otf.ErrExistingTx) {
+		t.Fatalf("expected existing transaction, got %v", err)
+	}
+
+	err = tx.CreateTable("x", []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		err = tx.WriteRow("x", []any{fmt.Sprintf("a%d", i), i})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A finished transaction can't be reused.
+	err = tx.WriteRow("x", []any{"a", 1})
+	if !errors.Is(err, otf.ErrNoTx) {
+		t.Fatalf("expected no transaction, got %v", err)
+	}
+
+	tx, err = c.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := tx.Scan("x", otf.WithColumns("b"), otf.WithFilter(otf.Where("b", otf.OP_GTE, 7)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []any
+	for {
+		row, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row == nil {
+			break
+		}
+		got = append(got, row[0])
+	}
+	if fmt.Sprint(got) != "[7 8 9]" {
+		t.Fatalf("unexpected rows %v", got)
+	}
+	err = tx.Abort()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Storage implemented outside the package.
+type mapStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (ms *mapStorage) PutIfAbsent(name string, bytes []byte) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.objects[name]; ok {
+		return fs.ErrExist
+	}
+	ms.objects[name] = bytes
+	return nil
+}
+
+func (ms *mapStorage) ListPrefix(prefix string) ([]string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var names []string
+	for name := range ms.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func (ms *mapStorage) Read(name string) ([]byte, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	bytes, ok := ms.objects[name]
+	if !ok {
+		return nil, fs.ErrNotExist
+	}
+	return bytes, nil
+}
+
+func (ms *mapStorage) Delete(name string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.objects, name)
+	return nil
+}
+
+func TestAPIExternalStorage(t *testing.T) {
+	s := &mapStorage{objects: map[string][]byte{}}
+	c1 := otf.NewClient(s)
+	c2 := otf.NewClient(s)
+
+	tx1, err := c1.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx2, err := c2.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx1.CreateTable("x", []string{"a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx2.CreateTable("x", []string{"a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = tx1.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tx2.Commit()
+	if !errors.Is(err, otf.ErrConflict) {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+}
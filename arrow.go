@@ -0,0 +1,505 @@
+package otf
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Writes scans as an Arrow IPC stream so they can be handed to
+// Arrow-based tooling (pyarrow, DuckDB, Polars, ...) without a
+// row-by-row conversion.
+//
+// https://arrow.apache.org/docs/format/Columnar.html#serialization-and-interprocess-communication-ipc
+//
+// Only a handful of types are supported, matching what rows can
+// hold: 64-bit integers, doubles, booleans and UTF-8 strings.
+// Anything else is written as its JSON encoding in a string column.
+
+type arrowType int
+
+const (
+	arrowInt64 arrowType = iota
+	arrowFloat64
+	arrowBool
+	arrowUtf8
+)
+
+type arrowField struct {
+	Name string
+	Type arrowType
+}
+
+// Infers each column's type from the values in b. A column that is
+// entirely null becomes a string column.
+func inferArrowSchema(columns []string, b *batch) []arrowField {
+	fields := make([]arrowField, len(columns))
+	for i, name := range columns {
+		fields[i] = arrowField{name, inferArrowType(b.Columns[i])}
+	}
+
+	return fields
+}
+
+func inferArrowType(values []any) arrowType {
+	t, seen := widenArrowType(0, false, values)
+	if !seen {
+		return arrowUtf8
+	}
+
+	return t
+}
+
+// Widens t, if any type has been seen yet, so that it can also hold
+// values. Lets a type be inferred across many batches.
+func widenArrowType(t arrowType, seen bool, values []any) (arrowType, bool) {
+	for _, v := range values {
+		var vt arrowType
+		switch n := v.(type) {
+		case nil:
+			continue
+		case int, int64:
+			vt = arrowInt64
+		case float64:
+			vt = arrowFloat64
+			// Integers that went through JSON are still
+			// integers.
+			if n == math.Trunc(n) && math.Abs(n) < 1<<53 {
+				vt = arrowInt64
+			}
+		case bool:
+			vt = arrowBool
+		default:
+			vt = arrowUtf8
+		}
+
+		if !seen {
+			t, seen = vt, true
+			continue
+		}
+
+		if t == vt {
+			continue
+		}
+
+		if (t == arrowInt64 && vt == arrowFloat64) || (t == arrowFloat64 && vt == arrowInt64) {
+			t = arrowFloat64
+			continue
+		}
+
+		return arrowUtf8, true
+	}
+
+	return t, seen
+}
+
+// A minimal FlatBuffers encoder, just enough for Arrow's Schema and
+// RecordBatch messages. Unlike the official builders this lays the
+// buffer out front to back: every object is written after the
+// object referring to it so all uoffsets are positive, and each
+// table's vtable is written right before the table.
+//
+// https://flatbuffers.dev/internals/
+
+// A table's fields indexed by field id; nil means absent. Values
+// are fbScalar or something fbWriter.object accepts.
+type fbTable []any
+
+// A little-endian scalar.
+type fbScalar []byte
+
+// A vector of tables.
+type fbTables []fbTable
+
+// A vector of structs, already encoded.
+type fbStructs struct {
+	data  []byte
+	count int
+	align int
+}
+
+type fbWriter struct {
+	buf []byte
+}
+
+func fbInt8(v uint8) fbScalar { return fbScalar{v} }
+func fbInt16(v int16) fbScalar {
+	return binary.LittleEndian.AppendUint16(nil, uint16(v))
+}
+func fbInt32(v int32) fbScalar {
+	return binary.LittleEndian.AppendUint32(nil, uint32(v))
+}
+func fbInt64(v int64) fbScalar {
+	return binary.LittleEndian.AppendUint64(nil, uint64(v))
+}
+func fbBool(v bool) fbScalar {
+	if v {
+		return fbScalar{1}
+	}
+	return fbScalar{0}
+}
+
+func (w *fbWriter) pad(align int) {
+	for len(w.buf)%align != 0 {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+func (w *fbWriter) patchOffset(at, target int) {
+	binary.LittleEndian.PutUint32(w.buf[at:], uint32(target-at))
+}
+
+func fbEncode(root fbTable) []byte {
+	w := &fbWriter{}
+	w.buf = make([]byte, 4)
+	w.patchOffset(0, w.object(root))
+	w.pad(8)
+	return w.buf
+}
+
+// Writes o and returns its position.
+func (w *fbWriter) object(o any) int {
+	switch o := o.(type) {
+	case fbTable:
+		return w.table(o)
+	case string:
+		w.pad(4)
+		start := len(w.buf)
+		w.buf = binary.LittleEndian.AppendUint32(w.buf, uint32(len(o)))
+		w.buf = append(w.buf, o...)
+		w.buf = append(w.buf, 0)
+		return start
+	case fbTables:
+		w.pad(4)
+		start := len(w.buf)
+		w.buf = binary.LittleEndian.AppendUint32(w.buf, uint32(len(o)))
+		slots := len(w.buf)
+		w.buf = append(w.buf, make([]byte, 4*len(o))...)
+		for i, t := range o {
+			w.patchOffset(slots+4*i, w.table(t))
+		}
+		return start
+	case fbStructs:
+		// The length prefix sits right before the (aligned)
+		// elements.
+		for (len(w.buf)+4)%o.align != 0 {
+			w.buf = append(w.buf, 0)
+		}
+		start := len(w.buf)
+		w.buf = binary.LittleEndian.AppendUint32(w.buf, uint32(o.count))
+		w.buf = append(w.buf, o.data...)
+		return start
+	}
+
+	panic(fmt.Sprintf("unsupported flatbuffer object: %T", o))
+}
+
+func (w *fbWriter) table(t fbTable) int {
+	// Lay out fields after the table's leading soffset, each
+	// aligned to its size. Offsets to other objects are 4 bytes.
+	fieldOffsets := make([]int, len(t))
+	size := 4
+	for i, f := range t {
+		if f == nil {
+			continue
+		}
+
+		fieldSize := 4
+		if s, ok := f.(fbScalar); ok {
+			fieldSize = len(s)
+		}
+
+		for size%fieldSize != 0 {
+			size++
+		}
+		fieldOffsets[i] = size
+		size += fieldSize
+	}
+
+	w.pad(2)
+	vtable := len(w.buf)
+	w.buf = binary.LittleEndian.AppendUint16(w.buf, uint16(4+2*len(t)))
+	w.buf = binary.LittleEndian.AppendUint16(w.buf, uint16(size))
+	for _, offset := range fieldOffsets {
+		w.buf = binary.LittleEndian.AppendUint16(w.buf, uint16(offset))
+	}
+
+	w.pad(8)
+	start := len(w.buf)
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, uint32(start-vtable))
+	w.buf = append(w.buf, make([]byte, size-4)...)
+
+	var children []int
+	for i, f := range t {
+		if s, ok := f.(fbScalar); ok {
+			copy(w.buf[start+fieldOffsets[i]:], s)
+		} else if f != nil {
+			children = append(children, i)
+		}
+	}
+
+	for _, i := range children {
+		at := start + fieldOffsets[i]
+		w.patchOffset(at, w.object(t[i]))
+	}
+
+	return start
+}
+
+const (
+	arrowMetadataV5 = 4
+
+	arrowHeaderSchema      = 1
+	arrowHeaderRecordBatch = 3
+
+	arrowTypeInt           = 2
+	arrowTypeFloatingPoint = 3
+	arrowTypeUtf8          = 5
+	arrowTypeBool          = 6
+
+	arrowPrecisionDouble = 2
+)
+
+func arrowSchemaMessage(fields []arrowField) fbTable {
+	var fbFields fbTables
+	for _, f := range fields {
+		var typeType uint8
+		var typ fbTable
+		switch f.Type {
+		case arrowInt64:
+			typeType = arrowTypeInt
+			typ = fbTable{fbInt32(64), fbBool(true)}
+		case arrowFloat64:
+			typeType = arrowTypeFloatingPoint
+			typ = fbTable{fbInt16(arrowPrecisionDouble)}
+		case arrowBool:
+			typeType = arrowTypeBool
+			typ = fbTable{}
+		case arrowUtf8:
+			typeType = arrowTypeUtf8
+			typ = fbTable{}
+		}
+
+		// name, nullable, type_type, type, dictionary, children
+		fbFields = append(fbFields, fbTable{f.Name, fbBool(true), fbInt8(typeType), typ, nil, fbTables{}})
+	}
+
+	// endianness (little), fields
+	schema := fbTable{fbInt16(0), fbFields}
+	// version, header_type, header, bodyLength
+	return fbTable{fbInt16(arrowMetadataV5), fbInt8(arrowHeaderSchema), schema, fbInt64(0)}
+}
+
+// Accumulates the body of a record batch along with the
+// FieldNode and Buffer metadata describing it.
+type arrowBody struct {
+	data    []byte
+	nodes   []byte
+	buffers []byte
+	count   int
+}
+
+func (ab *arrowBody) buffer(b []byte) {
+	ab.buffers = binary.LittleEndian.AppendUint64(ab.buffers, uint64(len(ab.data)))
+	ab.buffers = binary.LittleEndian.AppendUint64(ab.buffers, uint64(len(b)))
+	ab.data = append(ab.data, b...)
+	for len(ab.data)%8 != 0 {
+		ab.data = append(ab.data, 0)
+	}
+}
+
+func arrowValue(t arrowType, v any) (any, bool) {
+	if v == nil {
+		return nil, false
+	}
+
+	switch t {
+	case arrowInt64:
+		switch n := v.(type) {
+		case int:
+			return int64(n), true
+		case int64:
+			return n, true
+		case float64:
+			// Only integers that went through JSON, as
+			// inferred, rather than truncate.
+			if n == math.Trunc(n) && math.Abs(n) < 1<<53 {
+				return int64(n), true
+			}
+		}
+	case arrowFloat64:
+		switch n := v.(type) {
+		case int:
+			return float64(n), true
+		case int64:
+			return float64(n), true
+		case float64:
+			return n, true
+		}
+	case arrowBool:
+		b, ok := v.(bool)
+		return b, ok
+	case arrowUtf8:
+		if s, ok := v.(string); ok {
+			return s, true
+		}
+
+		bytes, err := json.Marshal(v)
+		assert(err == nil, fmt.Sprintf("could not marshal value: %s", err))
+		return string(bytes), true
+	}
+
+	return nil, false
+}
+
+func arrowRecordBatchMessage(fields []arrowField, b *batch) (fbTable, []byte, error) {
+	var body arrowBody
+	for i, f := range fields {
+		values := b.Columns[i]
+		validity := make([]byte, (b.Len+7)/8)
+		nulls := 0
+
+		var data []byte
+		var offsets []byte
+		if f.Type == arrowUtf8 {
+			offsets = binary.LittleEndian.AppendUint32(nil, 0)
+		}
+		bits := make([]byte, (b.Len+7)/8)
+
+		for j, raw := range values {
+			v, ok := arrowValue(f.Type, raw)
+			if !ok && raw != nil {
+				return nil, nil, fmt.Errorf("%w: column %s: expected a value of arrow type %d, got %T %v", errTypeMismatch, f.Name, f.Type, raw, raw)
+			}
+
+			if ok {
+				validity[j/8] |= 1 << (j % 8)
+			} else {
+				nulls++
+			}
+
+			switch f.Type {
+			case arrowInt64:
+				n, _ := v.(int64)
+				data = binary.LittleEndian.AppendUint64(data, uint64(n))
+			case arrowFloat64:
+				n, _ := v.(float64)
+				data = binary.LittleEndian.AppendUint64(data, math.Float64bits(n))
+			case arrowBool:
+				if v == true {
+					bits[j/8] |= 1 << (j % 8)
+				}
+			case arrowUtf8:
+				s, _ := v.(string)
+				data = append(data, s...)
+				offsets = binary.LittleEndian.AppendUint32(offsets, uint32(len(data)))
+			}
+		}
+
+		body.nodes = binary.LittleEndian.AppendUint64(body.nodes, uint64(b.Len))
+		body.nodes = binary.LittleEndian.AppendUint64(body.nodes, uint64(nulls))
+		body.count++
+
+		body.buffer(validity)
+		switch f.Type {
+		case arrowBool:
+			body.buffer(bits)
+		case arrowUtf8:
+			body.buffer(offsets)
+			body.buffer(data)
+		default:
+			body.buffer(data)
+		}
+	}
+
+	// length, nodes, buffers
+	recordBatch := fbTable{
+		fbInt64(int64(b.Len)),
+		fbStructs{body.nodes, body.count, 8},
+		fbStructs{body.buffers, len(body.buffers) / 16, 8},
+	}
+	message := fbTable{fbInt16(arrowMetadataV5), fbInt8(arrowHeaderRecordBatch), recordBatch, fbInt64(int64(len(body.data)))}
+	return message, body.data, nil
+}
+
+func writeArrowMessage(w io.Writer, message fbTable, body []byte) error {
+	metadata := fbEncode(message)
+	// The continuation marker and length prefix plus metadata
+	// must end on an 8-byte boundary.
+	for (8+len(metadata))%8 != 0 {
+		metadata = append(metadata, 0)
+	}
+
+	prefix := binary.LittleEndian.AppendUint32(nil, 0xFFFFFFFF)
+	prefix = binary.LittleEndian.AppendUint32(prefix, uint32(len(metadata)))
+	for _, b := range [][]byte{prefix, metadata, body} {
+		_, err := w.Write(b)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Drives the scan of table to completion, writing it to w as an
+// Arrow IPC stream with one record batch per (up to) batchRows
+// rows. Column types are inferred from the first batch, since the
+// schema leads the stream; a later value that doesn't fit its
+// column's type fails the scan with errTypeMismatch.
+func (d *client) scanArrow(table string, w io.Writer, batchRows int) error {
+	if d.tx == nil {
+		return errNoTx
+	}
+
+	columns, ok := d.tx.tables[table]
+	if !ok {
+		return errNoTable
+	}
+
+	it, err := d.scan(table)
+	if err != nil {
+		return err
+	}
+
+	var fields []arrowField
+	for {
+		b, err := it.nextBatch(batchRows)
+		if err != nil {
+			return err
+		}
+
+		if fields == nil {
+			first := b
+			if first == nil {
+				first = newBatch(len(columns))
+			}
+
+			fields = inferArrowSchema(columns, first)
+			err = writeArrowMessage(w, arrowSchemaMessage(fields), nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		if b == nil {
+			break
+		}
+
+		message, body, err := arrowRecordBatchMessage(fields, b)
+		if err != nil {
+			return err
+		}
+
+		err = writeArrowMessage(w, message, body)
+		if err != nil {
+			return err
+		}
+	}
+
+	// End of stream.
+	_, err = w.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0})
+	return err
+}
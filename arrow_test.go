@@ -0,0 +1,85 @@
+package otf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestInferArrowType(t *testing.T) {
+	assertEq(inferArrowType([]any{1, 2.0, nil}), arrowInt64, "integers")
+	assertEq(inferArrowType([]any{1, 2.5}), arrowFloat64, "mixed numbers")
+	assertEq(inferArrowType([]any{true, nil}), arrowBool, "booleans")
+	assertEq(inferArrowType([]any{"a", 1}), arrowUtf8, "mixed types")
+	assertEq(inferArrowType([]any{nil}), arrowUtf8, "all null")
+}
+
+func TestScanArrowStream(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b"})
+	assertEq(err, nil, "could not create x")
+	for i := 0; i < 5; i++ {
+		err = c.writeRow("x", []any{"Joey", i})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{"Yue", 5})
+	assertEq(err, nil, "could not write row")
+
+	var buf bytes.Buffer
+	err = c.scanArrow("x", &buf, 2)
+	assertEq(err, nil, "could not scan")
+
+	// Walk the encapsulated messages: the schema, then one
+	// record batch for the unflushed row and three for the
+	// five flushed rows, then the end-of-stream marker.
+	stream := buf.Bytes()
+	messages := 0
+	for {
+		assertEq(binary.LittleEndian.Uint32(stream), uint32(0xFFFFFFFF), "continuation marker")
+		metadataLen := int(binary.LittleEndian.Uint32(stream[4:]))
+		if metadataLen == 0 {
+			assertEq(len(stream), 8, "trailing bytes after end of stream")
+			break
+		}
+		assertEq(metadataLen%8, 0, "metadata padding")
+
+		messages++
+		metadata := stream[8 : 8+metadataLen]
+		stream = stream[8+metadataLen:]
+
+		// Message.bodyLength is field 3 of the root table.
+		table := int(binary.LittleEndian.Uint32(metadata))
+		vtable := table - int(int32(binary.LittleEndian.Uint32(metadata[table:])))
+		field := int(binary.LittleEndian.Uint16(metadata[vtable+4+2*3:]))
+		bodyLen := int(binary.LittleEndian.Uint64(metadata[table+field:]))
+		assertEq(bodyLen%8, 0, "body padding")
+		stream = stream[bodyLen:]
+	}
+	assertEq(messages, 5, "messages")
+}
+
+func TestScanArrowTypeMismatch(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	for _, a := range []any{1, 2, 3.5} {
+		err = c.writeRow("x", []any{a})
+		assertEq(err, nil, "could not write row")
+	}
+
+	// The first batch infers a as an integer; 3.5 must not be
+	// truncated to 3.
+	var buf bytes.Buffer
+	err = c.scanArrow("x", &buf, 2)
+	assert(errors.Is(err, errTypeMismatch), "expected a type mismatch")
+}
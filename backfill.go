@@ -0,0 +1,121 @@
+package otf
+
+import (
+	"fmt"
+)
+
+// Backfills process a large source range, like ids or timestamps,
+// in bounded chunks, each in its own transaction. Like the outbox
+// relay (see outbox.go), each chunk's transaction also records in a
+// control table how far the job got, so a backfill restarted after
+// a crash or deploy resumes after the last committed chunk rather
+// than from the start, and no chunk is committed twice even if two
+// runs of the same job race.
+
+const BACKFILL_PROGRESS_TABLE = "_backfill_progress"
+
+var backfillProgressColumns = []string{"job", "next"}
+
+type backfillProgress struct {
+	Job string
+	// Where the next chunk starts.
+	Next   int64
+	Chunks int
+}
+
+// Where job's next chunk starts, or -1 if it hasn't committed any.
+func (d *client) backfillNext(job string) (int64, error) {
+	if _, ok := d.tx.tables[BACKFILL_PROGRESS_TABLE]; !ok {
+		return -1, nil
+	}
+
+	it, err := d.scan(BACKFILL_PROGRESS_TABLE, withFilter(where("job", OP_EQ, job)))
+	if err != nil {
+		return -1, err
+	}
+
+	next := int64(-1)
+	for {
+		row, err := it.next()
+		if err != nil {
+			return -1, err
+		}
+
+		if row == nil {
+			break
+		}
+
+		n, ok := toInt64(row[1])
+		assert(ok, fmt.Sprintf("invalid backfill position: %v", row[1]))
+		next = max(next, n)
+	}
+
+	return next, nil
+}
+
+// Calls fn for each chunk [lo, hi) of [from, to), no more than
+// chunk long, each in its own transaction that fn writes the chunk's
+// rows in. Chunks job already committed are skipped, so calling it
+// again after a failure picks up where the last call left off; fn
+// may be called again for a chunk whose transaction didn't commit.
+// progress, if not nil, is called after every committed chunk.
+func (d *client) backfill(job string, from, to, chunk int64, fn func(lo, hi int64) error, progress func(backfillProgress)) (backfillProgress, error) {
+	assert(chunk > 0, "chunk size must be positive")
+
+	p := backfillProgress{Job: job, Next: from}
+	for {
+		err := d.newTx()
+		if err != nil {
+			return p, err
+		}
+
+		next, err := d.backfillNext(job)
+		if err != nil {
+			d.abortTx()
+			return p, err
+		}
+		lo := max(from, next)
+		p.Next = lo
+
+		if lo >= to {
+			// Nothing to do, read-only commit.
+			return p, d.commitTx()
+		}
+
+		if _, ok := d.tx.tables[BACKFILL_PROGRESS_TABLE]; !ok {
+			err = d.createTable(BACKFILL_PROGRESS_TABLE, backfillProgressColumns, withColumnTypes(map[string]string{
+				"job":  COLUMN_STRING,
+				"next": COLUMN_INT,
+			}))
+			if err != nil {
+				d.abortTx()
+				return p, err
+			}
+		}
+
+		hi := min(lo+chunk, to)
+		err = fn(lo, hi)
+		if err != nil {
+			d.abortTx()
+			return p, err
+		}
+
+		err = d.writeRow(BACKFILL_PROGRESS_TABLE, []any{job, hi})
+		if err != nil {
+			d.abortTx()
+			return p, err
+		}
+
+		err = d.commitTx()
+		if err != nil {
+			return p, err
+		}
+
+		p.Next = hi
+		p.Chunks++
+		d.debug("backfill", "committed chunk", "job", job, "from", lo, "to", hi)
+		if progress != nil {
+			progress(p)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package otf
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBackfillResumes(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	var chunks []string
+	fail := int64(40)
+	fill := func(lo, hi int64) error {
+		chunks = append(chunks, fmt.Sprintf("%d-%d", lo, hi))
+		for i := lo; i < hi; i++ {
+			err := c.writeRow("x", []any{i})
+			if err != nil {
+				return err
+			}
+		}
+		if hi > fail {
+			return fmt.Errorf("interrupted")
+		}
+		return nil
+	}
+
+	var seen []int64
+	p, err := c.backfill("fill", 0, 100, 15, fill, func(p backfillProgress) {
+		seen = append(seen, p.Next)
+	})
+	assertEq(fmt.Sprint(err), "interrupted", "expected failure")
+	assertEq(p.Next, int64(30), "wrong position")
+	assertEq(p.Chunks, 2, "wrong chunks")
+	assertEq(fmt.Sprint(seen), "[15 30]", "wrong progress")
+	assertEq(c.tx, (*transaction)(nil), "left tx open")
+	assertEq(countRows(&c, "x"), 30, "wrong rows")
+
+	// Picks up after the last committed chunk.
+	fail = 100
+	chunks = nil
+	p, err = c.backfill("fill", 0, 100, 15, fill, nil)
+	assertEq(err, nil, "could not backfill")
+	assertEq(p.Next, int64(100), "wrong position")
+	assertEq(p.Chunks, 5, "wrong chunks")
+	assertEq(fmt.Sprint(chunks), "[30-45 45-60 60-75 75-90 90-100]", "wrong chunks")
+	assertEq(countRows(&c, "x"), 100, "wrong rows")
+
+	// Finished jobs do nothing, other jobs start over.
+	chunks = nil
+	p, err = c.backfill("fill", 0, 100, 15, fill, nil)
+	assertEq(err, nil, "could not backfill")
+	assertEq(p.Chunks, 0, "redid chunks")
+	p, err = c.backfill("other", 90, 100, 15, fill, nil)
+	assertEq(err, nil, "could not backfill")
+	assertEq(fmt.Sprint(chunks), "[90-100]", "wrong chunks")
+	assertEq(countRows(&c, "x"), 110, "wrong rows")
+}
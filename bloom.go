@@ -0,0 +1,57 @@
+package otf
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// A bloom filter: a set that may say it contains a key it doesn't,
+// but never the other way around, in about 10 bits per key for a 1%
+// false positive rate.
+type bloomFilter struct {
+	Bits   []byte
+	Hashes int
+}
+
+const BLOOM_FALSE_POSITIVE_RATE = 0.01
+
+// A filter sized for n keys.
+func newBloomFilter(n int) *bloomFilter {
+	n = max(n, 1)
+	bits := math.Ceil(-float64(n) * math.Log(BLOOM_FALSE_POSITIVE_RATE) / (math.Ln2 * math.Ln2))
+	hashes := int(math.Round(bits / float64(n) * math.Ln2))
+	return &bloomFilter{
+		Bits:   make([]byte, (int(bits)+7)/8),
+		Hashes: max(hashes, 1),
+	}
+}
+
+// The bits of key, by double hashing.
+func (bf *bloomFilter) positions(key string, each func(bit uint64)) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h1 := h.Sum64()
+	h2 := h1>>32 | h1<<32 | 1
+
+	m := uint64(len(bf.Bits) * 8)
+	for i := 0; i < bf.Hashes; i++ {
+		each((h1 + uint64(i)*h2) % m)
+	}
+}
+
+func (bf *bloomFilter) add(key string) {
+	bf.positions(key, func(bit uint64) {
+		bf.Bits[bit/8] |= 1 << (bit % 8)
+	})
+}
+
+func (bf *bloomFilter) mayContain(key string) bool {
+	contains := true
+	bf.positions(key, func(bit uint64) {
+		if bf.Bits[bit/8]&(1<<(bit%8)) == 0 {
+			contains = false
+		}
+	})
+
+	return contains
+}
@@ -0,0 +1,104 @@
+package otf
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// Min/max statistics (see stats.go) can't rule out a dataobject for
+// a point lookup on a column whose values are spread evenly, like
+// ids or UUIDs: every dataobject's range covers almost every value.
+// So tables can name columns to keep a bloom filter (see bloom.go)
+// of per dataobject, recorded inline in its AddDataobject action,
+// and scans filtering on a column equal to a value skip dataobjects
+// whose filter rules it out.
+//
+// Values are hashed as they compare (see compareValues), so ints
+// and floats that are equal hash the same. Filters are only checked
+// for numbers, bools and strings that aren't timestamps; timestamps
+// compare equal to strings in several formats.
+
+var errBloomColumn = fmt.Errorf("Bloom Filter Column")
+
+// Keeps a bloom filter of each of columns per dataobject.
+func withBloomFilters(columns ...string) tableOption {
+	return func(o *tableOptions) {
+		o.bloomColumns = columns
+	}
+}
+
+// The key v is hashed as, or false if filters don't hold it.
+func bloomKey(v any) (string, bool) {
+	if f, ok := toFloat64(v); ok {
+		return "n" + strconv.FormatFloat(f, 'g', -1, 64), true
+	}
+
+	switch v := v.(type) {
+	case bool:
+		return "b" + strconv.FormatBool(v), true
+	case string:
+		return "s" + v, true
+	}
+
+	return "", false
+}
+
+// The filters of rows' values in table's bloom filter columns for a
+// new dataobject, nil if it has none.
+func (d *client) bloomFilters(table string, rows *batch) map[string]*bloomFilter {
+	columns := d.tx.bloomColumns[table]
+	if len(columns) == 0 {
+		return nil
+	}
+
+	filters := map[string]*bloomFilter{}
+	for i, column := range d.tx.tables[table] {
+		if !slices.Contains(columns, column) {
+			continue
+		}
+
+		bf := newBloomFilter(rows.Len)
+		for _, v := range rows.Columns[i] {
+			if key, ok := bloomKey(v); ok {
+				bf.add(key)
+			}
+		}
+		filters[column] = bf
+	}
+
+	return filters
+}
+
+// Whether a row of a dataobject with filters may match p.
+func bloomMayMatch(filters map[string]*bloomFilter, p *predicate) bool {
+	if p.And != nil {
+		for _, c := range p.And {
+			if !bloomMayMatch(filters, c) {
+				return false
+			}
+		}
+		return true
+	}
+
+	if p.Or != nil {
+		for _, c := range p.Or {
+			if bloomMayMatch(filters, c) {
+				return true
+			}
+		}
+		return false
+	}
+
+	bf := filters[p.Column]
+	if bf == nil || p.Call != nil || p.Op != OP_EQ {
+		return true
+	}
+
+	if _, isTime := toTime(p.Value); isTime {
+		return true
+	}
+
+	key, ok := bloomKey(p.Value)
+	return !ok || bf.mayContain(key)
+}
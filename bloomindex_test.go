@@ -0,0 +1,79 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBloomFiltersSkipDataobjects(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"id", "name"}, withBloomFilters("id", "name"))
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Every dataobject's ids span about the same range.
+	for k := 0; k < 4; k++ {
+		err = c.newTx()
+		assertEq(err, nil, "could not start tx")
+		for i := 0; i < 25; i++ {
+			id := i*4 + k
+			err = c.writeRow("x", []any{id, fmt.Sprint("name", id)})
+			assertEq(err, nil, "could not write row")
+		}
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+
+	lookup := func(p *predicate) (string, int) {
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+		it, err := c.scan("x", withFilter(p))
+		assertEq(err, nil, "could not scan")
+
+		var rows [][]any
+		for {
+			row, err := it.next()
+			assertEq(err, nil, "could not iterate scan")
+			if row == nil {
+				break
+			}
+			rows = append(rows, row)
+		}
+		skipped := it.cost().DataobjectsSkipped
+		err = c.abortTx()
+		assertEq(err, nil, "could not abort")
+		return fmt.Sprint(rows), skipped
+	}
+
+	rows, skipped := lookup(where("id", OP_EQ, 42))
+	assertEq(rows, "[[42 name42]]", "wrong rows")
+	assertEq(skipped, 3, "didn't skip by bloom filter")
+
+	// Equal floats hash like ints.
+	rows, skipped = lookup(where("id", OP_EQ, 42.0))
+	assertEq(rows, "[[42 name42]]", "wrong rows")
+	assertEq(skipped, 3, "didn't skip by bloom filter")
+
+	rows, skipped = lookup(or(where("id", OP_EQ, 1), where("name", OP_EQ, "name2")))
+	assertEq(rows, "[[1 name1] [2 name2]]", "wrong rows")
+	assertEq(skipped, 2, "didn't skip by bloom filter")
+
+	rows, skipped = lookup(and(where("id", OP_GT, 0), where("name", OP_EQ, "missing")))
+	assertEq(rows, "[]", "wrong rows")
+	assertEq(skipped, 4, "didn't skip by bloom filter")
+
+	// Only equality can be ruled out.
+	_, skipped = lookup(where("id", OP_NE, 42))
+	assertEq(skipped, 0, "skipped by bloom filter")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.dropColumn("x", "id")
+	assert(errors.Is(err, errBloomColumn), "dropped a bloom filter column")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+}
@@ -0,0 +1,551 @@
+package otf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Branches and tags name versions of the store, like git's, so
+// changes can be tried out on production data without touching it.
+// Both are small pointer objects under REFS_PREFIX:
+//
+//   - a branch starts after a transaction of main, the log every
+//     client uses by default, and has a log of its own under
+//     BRANCH_LOG_PREFIX whose entries continue main's numbering from
+//     there. A client on a branch (see withBranch and checkout)
+//     replays main through that transaction and then the branch's
+//     entries, and commits to the branch's log, so neither main nor
+//     other branches see its writes. Dataobjects are shared, not
+//     copied;
+//   - a tag names a transaction of main or of a branch, and can be
+//     opened like any past version (see timetravel.go).
+//
+// Merging a branch commits its changes since it started, or since
+// it was last merged, to main as one transaction, as if that
+// transaction had started when the branch did: it fails if main
+// changed the same tables since in ways that conflict (see
+// conflict.go), and otherwise replays the branch's actions after
+// them.
+//
+// Checkpoints (see checkpoint.go) are only written on main.
+// Expiring snapshots and coalescing (see expire.go and coalesce.go)
+// leave main's log alone from the earliest transaction a branch
+// starts after or a tag names, and vacuum (see vacuum.go) keeps
+// every dataobject of a branch's latest version or a tagged one.
+// Branches aren't supported with a Delta log, see delta.go.
+
+const (
+	MAIN_BRANCH       = "main"
+	REFS_PREFIX       = "_refs/"
+	BRANCH_LOG_PREFIX = "_branches/"
+
+	REF_BRANCH = "branches"
+	REF_TAG    = "tags"
+)
+
+var (
+	errNoRef     = fmt.Errorf("No Such Branch Or Tag")
+	errRefExists = fmt.Errorf("Branch Or Tag Exists")
+)
+
+// A branch or a tag.
+type ref struct {
+	Name string
+	// The branch a tag names a transaction of, empty for main.
+	Branch string `json:",omitempty"`
+	// The transaction of main a branch starts after, or the
+	// transaction a tag names.
+	Id      int
+	Created time.Time
+	// A branch's last transaction merged into main, and the
+	// transaction of main it was merged as, Id for both until it's
+	// merged.
+	Merged   int `json:",omitempty"`
+	MergedAs int `json:",omitempty"`
+}
+
+type mergeResult struct {
+	Branch string
+	// The transaction of main the branch's changes committed as,
+	// and the last of the branch's transactions they include, or
+	// -1 for both if there was nothing to merge.
+	Id      int
+	Through int
+	Tables  []string
+}
+
+// Transactions of the client see and commit to branch rather than
+// main, see above.
+func withBranch(branch string) clientOption {
+	return func(c *client) {
+		if branch == MAIN_BRANCH {
+			branch = ""
+		}
+		c.branch = branch
+	}
+}
+
+func refName(kind, name string) string {
+	return REFS_PREFIX + kind + "/" + name
+}
+
+func branchLogPrefix(branch string) string {
+	return BRANCH_LOG_PREFIX + branch + "/"
+}
+
+func validRefName(name string) error {
+	if name == "" || name == MAIN_BRANCH || strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("%w: invalid branch or tag name: %q", errBadRequest, name)
+	}
+
+	return nil
+}
+
+// A copy of the client on branch, "" for main, outside of any
+// transaction.
+func (d *client) onBranch(branch string) *client {
+	b := *d
+	b.tx = nil
+	b.branch = branch
+	return &b
+}
+
+func (d *client) readRef(kind, name string) (*ref, error) {
+	bytes, err := d.os.read(d.context(), refName(kind, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", errNoRef, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var r ref
+	err = json.Unmarshal(bytes, &r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: ref %s: %s", errCorrupt, name, err)
+	}
+	return &r, nil
+}
+
+// Writes r, failing with errRefExists if it exists unless replace.
+func (d *client) writeRef(kind string, r *ref, replace bool) error {
+	bytes, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	name := refName(kind, r.Name)
+	if replace {
+		err = d.os.delete(d.context(), name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	err = d.os.putIfAbsent(d.context(), name, bytes)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%w: %s", errRefExists, r.Name)
+	}
+	return err
+}
+
+// Every branch or every tag, by name.
+func (d *client) listRefs(kind string) ([]ref, error) {
+	names, err := d.os.listPrefix(d.context(), REFS_PREFIX+kind+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []ref
+	for _, name := range names {
+		r, err := d.readRef(kind, strings.TrimPrefix(name, REFS_PREFIX+kind+"/"))
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, *r)
+	}
+
+	slices.SortFunc(refs, func(a, b ref) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return refs, nil
+}
+
+// Starts branch after the transaction at of main, or after the
+// latest if at is -1. Doesn't need a transaction.
+func (d *client) createBranch(branch string, at int) (*ref, error) {
+	if d.logFormat == LOG_FORMAT_DELTA {
+		return nil, fmt.Errorf("%w: a Delta log has no branches", errDeltaLog)
+	}
+	err := validRefName(branch)
+	if err != nil {
+		return nil, err
+	}
+
+	main := d.onBranch("")
+	names, err := main.listLog()
+	if err != nil {
+		return nil, err
+	}
+	if at == -1 && len(names) > 0 {
+		at = logEntryId(names[len(names)-1])
+	} else if logEntryIndex(names, at) == -1 {
+		return nil, fmt.Errorf("%w: %d", errNoVersion, at)
+	}
+
+	r := &ref{Name: branch, Id: at, Created: time.Now().UTC(), Merged: at, MergedAs: at}
+	err = d.writeRef(REF_BRANCH, r, false)
+	if err != nil {
+		return nil, err
+	}
+
+	d.debug("branch", "created", "branch", branch, "after", at)
+	return r, nil
+}
+
+// Names the transaction at of branch, "" for main, or its latest
+// if at is -1. Doesn't need a transaction.
+func (d *client) createTag(tag, branch string, at int) (*ref, error) {
+	err := validRefName(tag)
+	if err != nil {
+		return nil, err
+	}
+	if branch == MAIN_BRANCH {
+		branch = ""
+	}
+
+	names, err := d.onBranch(branch).listLog()
+	if err != nil {
+		return nil, err
+	}
+	if at == -1 && len(names) > 0 {
+		at = logEntryId(names[len(names)-1])
+	} else if logEntryIndex(names, at) == -1 {
+		return nil, fmt.Errorf("%w: %d", errNoVersion, at)
+	}
+
+	r := &ref{Name: tag, Branch: branch, Id: at, Created: time.Now().UTC()}
+	err = d.writeRef(REF_TAG, r, false)
+	if err != nil {
+		return nil, err
+	}
+
+	d.debug("branch", "tagged", "tag", tag, "branch", branch, "id", at)
+	return r, nil
+}
+
+// Deletes a branch, along with its log, or a tag. A deleted
+// branch's dataobjects are left for vacuum, see vacuum.go.
+func (d *client) deleteRef(kind, name string) error {
+	_, err := d.readRef(kind, name)
+	if err != nil {
+		return err
+	}
+
+	err = d.os.delete(d.context(), refName(kind, name))
+	if err != nil || kind != REF_BRANCH {
+		return err
+	}
+
+	names, err := d.listBranchLog(name)
+	if err != nil {
+		return err
+	}
+	for _, entry := range names {
+		err = d.os.delete(d.context(), entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Moves the client to branch, MAIN_BRANCH for main, for its next
+// transactions.
+func (d *client) checkout(branch string) error {
+	if d.tx != nil {
+		return errExistingTx
+	}
+
+	if branch == MAIN_BRANCH || branch == "" {
+		d.branch = ""
+		return nil
+	}
+
+	if d.logFormat == LOG_FORMAT_DELTA {
+		return fmt.Errorf("%w: a Delta log has no branches", errDeltaLog)
+	}
+	_, err := d.readRef(REF_BRANCH, branch)
+	if err != nil {
+		return err
+	}
+
+	d.branch = branch
+	return nil
+}
+
+// Starts a transaction seeing the store as of the transaction tag
+// names, on whichever branch.
+func (d *client) newTxAtTag(tag string) error {
+	if d.tx != nil {
+		return errExistingTx
+	}
+
+	r, err := d.readRef(REF_TAG, tag)
+	if err != nil {
+		return err
+	}
+
+	b := d.onBranch(r.Branch)
+	err = b.newTxAt(r.Id)
+	if err != nil {
+		return err
+	}
+
+	d.tx = b.tx
+	return nil
+}
+
+// The names of branch's own log entries, without main's before it.
+func (d *client) listBranchLog(branch string) ([]string, error) {
+	names, err := d.os.listPrefix(d.context(), branchLogPrefix(branch)+"_log_")
+	if err != nil {
+		return nil, err
+	}
+
+	return slices.DeleteFunc(names, func(name string) bool {
+		_, ok := parseLogEntryId(name)
+		return !ok
+	}), nil
+}
+
+// Main's log through the transaction the client's branch starts
+// after, followed by the branch's own log.
+func (d *client) listBranchedLog(main []string) ([]string, error) {
+	r, err := d.readRef(REF_BRANCH, d.branch)
+	if err != nil {
+		return nil, err
+	}
+
+	// Main's entries are in order.
+	i := slices.IndexFunc(main, func(name string) bool {
+		return logEntryId(name) > r.Id
+	})
+	if i != -1 {
+		main = main[:i]
+	}
+
+	own, err := d.listBranchLog(d.branch)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Concat(main, own), nil
+}
+
+// Whether name is an entry of a branch's own log.
+func isBranchLogEntry(name string) bool {
+	return strings.HasPrefix(name, BRANCH_LOG_PREFIX)
+}
+
+// The earliest transaction of main a branch starts after or a tag
+// names, which expiring and coalescing leave alone, or -1 if none.
+func (d *client) earliestPinned() (int, error) {
+	pinned := -1
+	for _, kind := range []string{REF_BRANCH, REF_TAG} {
+		refs, err := d.listRefs(kind)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, r := range refs {
+			id := r.Id
+			if kind == REF_TAG && r.Branch != "" {
+				b, err := d.readRef(REF_BRANCH, r.Branch)
+				if errors.Is(err, errNoRef) {
+					continue
+				}
+				if err != nil {
+					return 0, err
+				}
+				id = b.Id
+			}
+
+			if pinned == -1 || id < pinned {
+				pinned = id
+			}
+		}
+	}
+
+	return pinned, nil
+}
+
+// Keys of dataobjects of each branch's latest version and each
+// tagged version, which vacuum keeps, and of any ever added on a
+// branch.
+func (d *client) branchReferences() (map[string]bool, map[string]bool, error) {
+	referenced := map[string]bool{}
+	added := map[string]bool{}
+	reference := func(tx *transaction) {
+		for _, actions := range tx.previousActions {
+			for _, action := range liveActions(actions) {
+				if action.AddDataobject != nil {
+					for _, key := range dataobjectKeys(action.AddDataobject) {
+						referenced[key] = true
+					}
+				}
+			}
+		}
+	}
+
+	branches, err := d.listRefs(REF_BRANCH)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, r := range branches {
+		b := d.onBranch(r.Name)
+		err = b.newReadTx()
+		if err != nil {
+			return nil, nil, err
+		}
+		reference(b.tx)
+		b.tx = nil
+
+		names, err := b.listBranchLog(r.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, name := range names {
+			entry, err := b.readLogEntry(name)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, actions := range entry.Actions {
+				for _, action := range actions {
+					if action.AddDataobject != nil {
+						for _, key := range dataobjectKeys(action.AddDataobject) {
+							added[key] = true
+						}
+					}
+				}
+			}
+		}
+	}
+
+	tags, err := d.listRefs(REF_TAG)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, r := range tags {
+		b := d.onBranch("")
+		err = b.newTxAtTag(r.Name)
+		if errors.Is(err, errNoRef) || errors.Is(err, errNoVersion) {
+			// Of a deleted branch, or expired.
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		reference(b.tx)
+	}
+
+	return referenced, added, nil
+}
+
+// Commits branch's changes since it was last merged to main as one
+// transaction, see above. The client must be on main and not in a
+// transaction.
+func (d *client) mergeBranch(branch string) (*mergeResult, error) {
+	if d.tx != nil {
+		return nil, errExistingTx
+	}
+	if d.branch != "" {
+		return nil, fmt.Errorf("%w: branches merge into %s, not %s", errBadRequest, MAIN_BRANCH, d.branch)
+	}
+
+	r, err := d.readRef(REF_BRANCH, branch)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &mergeResult{Branch: branch, Id: -1, Through: -1}
+	names, err := d.listBranchLog(branch)
+	if err != nil {
+		return nil, err
+	}
+
+	actions := map[string][]Action{}
+	for _, name := range names {
+		if logEntryId(name) <= r.Merged {
+			continue
+		}
+
+		entry, err := d.readLogEntry(name)
+		if err != nil {
+			return nil, err
+		}
+		if restriction := entry.restriction(); restriction != "" {
+			return nil, fmt.Errorf("%w: %s", errReadOnlyVersion, restriction)
+		}
+
+		for table, theirs := range entry.Actions {
+			actions[table] = append(actions[table], theirs...)
+		}
+		result.Through = entry.Id
+	}
+	if result.Through == -1 {
+		return result, nil
+	}
+
+	err = d.newTx()
+	if err != nil {
+		return nil, err
+	}
+	merging := d.tx
+
+	for _, table := range sortedKeys(actions) {
+		d.tx.Actions[table] = append(d.tx.Actions[table], actions[table]...)
+		result.Tables = append(result.Tables, table)
+
+		// Tables created on the branch, so committing sees
+		// their writes.
+		if _, ok := d.tx.tables[table]; !ok {
+			for _, action := range actions[table] {
+				if action.ChangeMetadata != nil {
+					d.tx.tables[table] = action.ChangeMetadata.Columns
+				}
+			}
+		}
+	}
+
+	// As if the transaction started when the branch did, or was
+	// last merged, so it conflicts with main's changes since.
+	d.tx.Id = r.MergedAs + 1
+	d.tx.lastCommit = nil
+	err = d.rebase()
+	if err != nil {
+		d.abortTx()
+		return nil, err
+	}
+
+	err = d.commitTx()
+	if err != nil {
+		return nil, err
+	}
+
+	result.Id = merging.Id
+	r.Merged = result.Through
+	r.MergedAs = merging.Id
+	err = d.writeRef(REF_BRANCH, r, true)
+	if err != nil {
+		return nil, err
+	}
+
+	d.debug("branch", "merged", "branch", branch, "through", result.Through, "as", result.Id)
+	return result, nil
+}
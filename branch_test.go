@@ -0,0 +1,168 @@
+package otf
+
+import (
+	"errors"
+	"testing"
+)
+
+func writeTx(c *client, table string, rows ...[]any) {
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	for _, row := range rows {
+		err = c.writeRow(table, row)
+		assertEq(err, nil, "could not write")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+}
+
+func rowsOn(c *client, table string) int {
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	defer c.abortTx()
+	if _, ok := c.tx.tables[table]; !ok {
+		return -1
+	}
+	return len(scanAll(c, table))
+}
+
+func TestBranches(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "p"}, withPartitionColumns("p"))
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	writeTx(&c, "x", []any{1, "main"})
+
+	_, err = c.createBranch(MAIN_BRANCH, -1)
+	assert(errors.Is(err, errBadRequest), "expected invalid name")
+	r, err := c.createBranch("dev", -1)
+	assertEq(err, nil, "could not branch")
+	assertEq(r.Id, 1, "branched after")
+	_, err = c.createBranch("dev", -1)
+	assert(errors.Is(err, errRefExists), "expected existing branch")
+	_, err = c.createTag("v1", MAIN_BRANCH, -1)
+	assertEq(err, nil, "could not tag")
+
+	// Writes on the branch aren't seen on main.
+	dev := newClient(mos, withBranch("dev"))
+	writeTx(&dev, "x", []any{2, "dev"}, []any{3, "dev"})
+	err = dev.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = dev.createTable("y", []string{"b"})
+	assertEq(err, nil, "could not create y")
+	err = dev.commitTx()
+	assertEq(err, nil, "could not commit")
+	assertEq(rowsOn(&dev, "x"), 3, "rows on dev")
+	assertEq(rowsOn(&c, "x"), 1, "rows on main")
+	assertEq(rowsOn(&c, "y"), -1, "y on main")
+
+	// Nor writes on main on the branch.
+	writeTx(&c, "x", []any{4, "main"})
+	assertEq(rowsOn(&dev, "x"), 3, "rows on dev after main wrote")
+
+	err = c.newTxAtTag("v1")
+	assertEq(err, nil, "could not open tag")
+	assertEq(len(scanAll(&c, "x")), 1, "rows at tag")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+	_, err = c.createTag("dev-1", "dev", -1)
+	assertEq(err, nil, "could not tag dev")
+	err = c.newTxAtTag("dev-1")
+	assertEq(err, nil, "could not open tag")
+	assertEq(len(scanAll(&c, "x")), 3, "rows at dev tag")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+
+	// Vacuum keeps what the branch uses.
+	_, err = c.vacuum(0, false)
+	assertEq(err, nil, "could not vacuum")
+	assertEq(rowsOn(&dev, "x"), 3, "rows on dev after vacuum")
+
+	result, err := c.mergeBranch("dev")
+	assertEq(err, nil, "could not merge")
+	assertEq(result.Through, 3, "merged through")
+	assertEq(result.Id, 3, "merged as")
+	assertEq(rowsOn(&c, "x"), 4, "rows on main after merge")
+	assertEq(rowsOn(&c, "y"), 0, "y on main after merge")
+
+	result, err = c.mergeBranch("dev")
+	assertEq(err, nil, "could not merge again")
+	assertEq(result.Id, -1, "nothing to merge")
+
+	// Merged again only what's new since.
+	writeTx(&dev, "x", []any{5, "dev"})
+	result, err = c.mergeBranch("dev")
+	assertEq(err, nil, "could not merge new writes")
+	assertEq(result.Through, 4, "merged through after new writes")
+	assertEq(rowsOn(&c, "x"), 5, "rows on main after second merge")
+
+	// Both changing the same partition conflicts.
+	_, err = c.createBranch("fix", -1)
+	assertEq(err, nil, "could not branch")
+	fix := newClient(mos)
+	err = fix.checkout("fix")
+	assertEq(err, nil, "could not checkout")
+	writeTx(&fix, "x", []any{6, "main"})
+	writeTx(&c, "x", []any{7, "main"})
+	_, err = c.mergeBranch("fix")
+	assert(errors.Is(err, errConflict), "expected merge conflict")
+	assertEq(rowsOn(&c, "x"), 6, "rows on main after conflict")
+
+	err = fix.checkout("nope")
+	assert(errors.Is(err, errNoRef), "expected no such branch")
+	_, err = fix.mergeBranch("dev")
+	assert(errors.Is(err, errBadRequest), "expected merge from a branch")
+
+	err = c.deleteRef(REF_BRANCH, "fix")
+	assertEq(err, nil, "could not delete")
+	names, err := c.listBranchLog("fix")
+	assertEq(err, nil, "could not list")
+	assertEq(len(names), 0, "log of deleted branch")
+	branches, err := c.listRefs(REF_BRANCH)
+	assertEq(err, nil, "could not list branches")
+	assertEq(len(branches), 1, "branches")
+}
+
+func TestBranchPinsLog(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos, withCheckpointInterval(1))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	writeTx(&c, "x", []any{1})
+	_, err = c.createBranch("dev", -1)
+	assertEq(err, nil, "could not branch")
+	writeTx(&c, "x", []any{2})
+	writeTx(&c, "x", []any{3})
+
+	result, err := c.expireSnapshots(0, 0)
+	assertEq(err, nil, "could not expire")
+	assertEq(result.Through, 1, "expired through the branch")
+
+	dev := newClient(mos, withBranch("dev"))
+	assertEq(rowsOn(&dev, "x"), 1, "rows on dev after expiry")
+	err = dev.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = dev.createTable("y", []string{"b"})
+	assertEq(err, nil, "could not create y")
+	err = dev.writeRow("y", []any{1})
+	assertEq(err, nil, "could not write")
+	err = dev.commitTx()
+	assertEq(err, nil, "could not commit")
+	_, err = dev.expireSnapshots(0, 0)
+	assert(errors.Is(err, errBadRequest), "expected expiry on a branch")
+
+	// Main's entries since the branch started are still there to
+	// check for conflicts.
+	_, err = c.mergeBranch("dev")
+	assertEq(err, nil, "could not merge")
+	assertEq(rowsOn(&c, "x"), 3, "rows after merge")
+	assertEq(rowsOn(&c, "y"), 1, "rows of y after merge")
+}
@@ -0,0 +1,36 @@
+package otf
+
+import (
+	"context"
+	"fmt"
+)
+
+// Bulk operations (importing, compacting and vacuuming) can run for
+// a long time, so each can be given a context whose deadline, or
+// cancelation, stops it part way. Rather than failing outright they
+// then return what they got done along with what remains, so whoever
+// runs them can carry on from there rather than start over:
+//
+//   - an import reports the rows it wrote, and can be started again
+//     skipping that many rows of the same input;
+//   - a compaction keeps the rewrites it finished in the transaction
+//     and reports the dataobjects it didn't get to, which the next
+//     compaction will pick up once the transaction commits;
+//   - a parallel compaction (see parallelcompact.go) stops starting
+//     jobs and reports how many it didn't start, which the next call
+//     for the same run picks up;
+//   - a vacuum reports the dataobjects it didn't get to delete, which
+//     the next vacuum will find again.
+//
+// The error returned wraps both errIncomplete and the context's
+// error. Work already started when the context ends is finished, so
+// the deadline is when to stop starting new work, not when the
+// operation must have returned by.
+
+var errIncomplete = fmt.Errorf("Incomplete")
+
+// Wraps the error of ctx, which is done, as having stopped an
+// operation with left of what still to do, e.g. 3 dataobjects.
+func incomplete(ctx context.Context, left int, what string) error {
+	return fmt.Errorf("%w: %d %s left: %w", errIncomplete, left, what, ctx.Err())
+}
@@ -0,0 +1,117 @@
+package otf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Done once checked more than checks times.
+type stopAfter struct {
+	context.Context
+	checks int
+}
+
+func (s *stopAfter) Err() error {
+	if s.checks <= 0 {
+		return context.DeadlineExceeded
+	}
+
+	s.checks--
+	return nil
+}
+
+func TestImportIncomplete(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+
+	input := "a\n1\n2\n3\n4\n5\n"
+	result, err := c.importContext(&stopAfter{context.Background(), 2}, "x", strings.NewReader(input), IMPORT_CSV, 0)
+	assert(errors.Is(err, errIncomplete), "import completed")
+	assert(errors.Is(err, context.DeadlineExceeded), "not past the deadline")
+	assertEq(result.Rows, 2, "rows imported")
+	assertEq(result.Read, 2, "rows read")
+
+	// Carries on where it stopped.
+	result, err = c.importContext(context.Background(), "x", strings.NewReader(input), IMPORT_CSV, result.Read)
+	assertEq(err, nil, "could not import")
+	assertEq(result.Rows, 3, "rows imported")
+	assertEq(result.Read, 5, "rows read")
+	assertEq(fmt.Sprint(scanAll(&c, "x")), "[[1] [2] [3] [4] [5]]", "rows")
+}
+
+func TestCompactIncomplete(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	for i := 1; i <= 4; i++ {
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+		err = c.flushRows("x")
+		assertEq(err, nil, "could not flush")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	result, err := c.compactContext(&stopAfter{context.Background(), 2}, "x")
+	assert(errors.Is(err, errIncomplete), "compaction completed")
+	assertEq(len(result.Removed), 2, "removed")
+	assertEq(len(result.Added), 1, "added")
+	assertEq(len(result.Remaining), 2, "remaining")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// The next compaction picks up the rest.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	result, err = c.compact("x")
+	assertEq(err, nil, "could not compact")
+	assertEq(len(result.Removed), 3, "removed")
+	assertEq(len(result.Remaining), 0, "remaining")
+	assertEq(len(scanAll(&c, "x")), 4, "rows")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+}
+
+func TestVacuumIncomplete(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	for i := 1; i <= 3; i++ {
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+		err = c.flushRows("x")
+		assertEq(err, nil, "could not flush")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Leaves the three dataobjects unreferenced.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.compact("x")
+	assertEq(err, nil, "could not compact")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	result, err := c.vacuumContext(&stopAfter{context.Background(), 1}, 0, false)
+	assert(errors.Is(err, errIncomplete), "vacuum completed")
+	assertEq(len(result.Deleted), 1, "deleted")
+	assertEq(len(result.Remaining), 2, "remaining")
+
+	// The next vacuum finds the rest.
+	result, err = c.vacuum(0, false)
+	assertEq(err, nil, "could not vacuum")
+	assertEq(len(result.Deleted), 2, "deleted")
+}
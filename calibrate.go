@@ -0,0 +1,186 @@
+package otf
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+)
+
+// Measures how far estimateRows (see estimate.go) is from the truth.
+// Calibration writes tables whose values follow known distributions
+// to in-memory storage, estimates how many rows a fixed set of
+// predicates match, counts how many really do by scanning, and
+// reports the q-error of each: max(estimate/actual, actual/estimate),
+// with both at least one row. A q-error of 1 is a perfect estimate.
+// Since the data is generated from a seed, results are reproducible
+// and can be compared before and after changing the estimator or
+// the statistics it relies on.
+
+type calibrationDistribution struct {
+	Name string
+	// The value of row i of n, in [0, CALIBRATION_DOMAIN) or nil.
+	Value func(r *rand.Rand, i, n int) any
+}
+
+const CALIBRATION_DOMAIN = 1000
+
+var calibrationDistributions = []calibrationDistribution{
+	{"uniform", func(r *rand.Rand, i, n int) any {
+		return r.Intn(CALIBRATION_DOMAIN)
+	}},
+	// Written in order, so dataobjects don't overlap.
+	{"sorted", func(r *rand.Rand, i, n int) any {
+		return i * CALIBRATION_DOMAIN / n
+	}},
+	{"normal", func(r *rand.Rand, i, n int) any {
+		v := int(r.NormFloat64()*CALIBRATION_DOMAIN/8 + CALIBRATION_DOMAIN/2)
+		return min(max(v, 0), CALIBRATION_DOMAIN-1)
+	}},
+	{"zipf", func(r *rand.Rand, i, n int) any {
+		return int(zipfValue(r))
+	}},
+	{"nulls", func(r *rand.Rand, i, n int) any {
+		if r.Intn(2) == 0 {
+			return nil
+		}
+		return r.Intn(CALIBRATION_DOMAIN)
+	}},
+}
+
+// Skewed towards 0, mostly the first few values.
+func zipfValue(r *rand.Rand) uint64 {
+	return rand.NewZipf(r, 1.2, 1, CALIBRATION_DOMAIN-1).Uint64()
+}
+
+// Predicates on the column v of a calibration table.
+func calibrationPredicates() []*predicate {
+	return []*predicate{
+		where("v", OP_LT, CALIBRATION_DOMAIN/100),
+		where("v", OP_LT, CALIBRATION_DOMAIN/10),
+		where("v", OP_LT, CALIBRATION_DOMAIN/2),
+		where("v", OP_GTE, CALIBRATION_DOMAIN*9/10),
+		where("v", OP_EQ, CALIBRATION_DOMAIN/4),
+		where("v", OP_NE, CALIBRATION_DOMAIN/4),
+		and(where("v", OP_GTE, CALIBRATION_DOMAIN/4), where("v", OP_LT, CALIBRATION_DOMAIN*3/4)),
+		or(where("v", OP_LT, CALIBRATION_DOMAIN/10), where("v", OP_GTE, CALIBRATION_DOMAIN*9/10)),
+	}
+}
+
+type calibrationResult struct {
+	Distribution string
+	Predicate    string
+	Estimated    float64
+	Actual       int
+}
+
+func (r calibrationResult) qError() float64 {
+	estimated := max(r.Estimated, 1)
+	actual := max(float64(r.Actual), 1)
+	return max(estimated/actual, actual/estimated)
+}
+
+// Writes rows rows of each distribution, rowsPerDataobject to a
+// dataobject, and compares estimated to actual matches of each
+// calibration predicate.
+func calibrate(rows, rowsPerDataobject int, seed int64) ([]calibrationResult, error) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	if err != nil {
+		return nil, err
+	}
+
+	r := rand.New(rand.NewSource(seed))
+	for _, dist := range calibrationDistributions {
+		err = c.createTable(dist.Name, []string{"i", "v"})
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < rows; i++ {
+			err = c.writeRow(dist.Name, []any{i, dist.Value(r, i, rows)})
+			if err != nil {
+				return nil, err
+			}
+
+			if (i+1)%rowsPerDataobject == 0 {
+				err = c.flushRows(dist.Name)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		err = c.flushRows(dist.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var results []calibrationResult
+	for _, dist := range calibrationDistributions {
+		for _, p := range calibrationPredicates() {
+			estimated, err := c.estimateRows(dist.Name, p)
+			if err != nil {
+				return nil, err
+			}
+
+			it, err := c.scan(dist.Name, withFilter(p))
+			if err != nil {
+				return nil, err
+			}
+
+			actual := 0
+			for {
+				b, err := it.nextBatch(DATAOBJECT_SIZE)
+				if err != nil {
+					return nil, err
+				}
+				if b == nil {
+					break
+				}
+				actual += b.Len
+			}
+
+			results = append(results, calibrationResult{dist.Name, p.String(), estimated, actual})
+		}
+	}
+
+	return results, c.abortTx()
+}
+
+// A table of results followed by the median and worst q-error of
+// each distribution.
+func calibrationReport(results []calibrationResult) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-8s  %-30s  %10s  %10s  %7s\n", "dist", "predicate", "estimated", "actual", "q-error")
+
+	errors := map[string][]float64{}
+	var order []string
+	for _, r := range results {
+		fmt.Fprintf(&b, "%-8s  %-30s  %10.0f  %10d  %7.2f\n", r.Distribution, r.Predicate, r.Estimated, r.Actual, r.qError())
+		if _, ok := errors[r.Distribution]; !ok {
+			order = append(order, r.Distribution)
+		}
+		errors[r.Distribution] = append(errors[r.Distribution], r.qError())
+	}
+
+	b.WriteString("\n")
+	for _, dist := range order {
+		qs := errors[dist]
+		slices.Sort(qs)
+		fmt.Fprintf(&b, "%-8s  median q-error %.2f, worst %.2f\n", dist, qs[len(qs)/2], qs[len(qs)-1])
+	}
+
+	return b.String()
+}
+
+// The worst q-error of results, or 1 if there are none.
+func worstQError(results []calibrationResult) float64 {
+	worst := 1.0
+	for _, r := range results {
+		worst = max(worst, r.qError())
+	}
+
+	return worst
+}
@@ -0,0 +1,69 @@
+package otf
+
+import (
+	"testing"
+)
+
+func TestCalibration(t *testing.T) {
+	results, err := calibrate(20_000, 2_000, 1)
+	assertEq(err, nil, "could not calibrate")
+	assertEq(len(results), len(calibrationDistributions)*len(calibrationPredicates()), "results")
+	t.Log("\n" + calibrationReport(results))
+
+	byDistribution := map[string][]calibrationResult{}
+	for _, r := range results {
+		byDistribution[r.Distribution] = append(byDistribution[r.Distribution], r)
+	}
+
+	// Stats describe evenly spread values well, whether or not
+	// dataobjects overlap.
+	for _, dist := range []string{"uniform", "sorted", "nulls"} {
+		assert(worstQError(byDistribution[dist]) < 1.5, "estimates of "+dist+" are off")
+	}
+
+	// Min and max can't tell skewed values from evenly spread
+	// ones, which calibration should show.
+	assert(worstQError(byDistribution["zipf"]) > 2, "expected skew to throw off estimates")
+}
+
+func TestEstimateRows(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b"})
+	assertEq(err, nil, "could not create x")
+	for i := 0; i < 100; i++ {
+		err = c.writeRow("x", []any{i, "b"})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.flushRows("x")
+	assertEq(err, nil, "could not flush")
+
+	// Unflushed rows are counted exactly.
+	err = c.writeRow("x", []any{1000, "b"})
+	assertEq(err, nil, "could not write row")
+
+	for _, test := range []struct {
+		p        *predicate
+		estimate float64
+	}{
+		{where("a", OP_LT, 50), 50},
+		{where("a", OP_EQ, 10), 1},
+		{where("a", OP_GTE, 500), 1},
+		{where("a", OP_GT, 5000), 0},
+		{where("a", OP_EQ, "x"), 0},
+		{and(where("a", OP_LT, 50), where("a", OP_GTE, 0)), 50},
+		{where("b", OP_EQ, "b"), 101},
+	} {
+		estimate, err := c.estimateRows("x", test.p)
+		assertEq(err, nil, "could not estimate")
+		assert(estimate > test.estimate-1 && estimate < test.estimate+1, test.p.String())
+	}
+
+	n, err := c.deleteRows("x", where("a", OP_LT, 50))
+	assertEq(err, nil, "could not delete")
+	assertEq(n, 50, "deleted")
+	estimate, err := c.estimateRows("x", where("a", OP_GTE, 0))
+	assertEq(err, nil, "could not estimate")
+	assert(estimate > 50 && estimate < 52, "estimate after delete")
+}
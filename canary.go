@@ -0,0 +1,242 @@
+package otf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Commits are only safe on storage whose putIfAbsent really is
+// conditional: two transactions writing the same log entry must not
+// both succeed. A misconfigured backend (a proxy dropping the
+// condition, a bucket without conditional writes) doesn't fail
+// loudly, it silently lets a commit overwrite another.
+//
+// A storage canary probes the backend with an object of its own,
+// named CANARY_PREFIX and a random suffix: it puts it, reads it back,
+// lists it, conditionally puts it again, which must fail with
+// fs.ErrExist and leave it as it was, and deletes it, timing each
+// step. `otf doctor` runs a few probes before anything is written,
+// and `otf serve --canary <interval>` keeps probing in the
+// background, serving the results at GET /canary. Once a probe sees
+// a conditional put overwrite an object the server refuses commits
+// with errUnsafeStorage rather than risk corrupting the log.
+
+const CANARY_PREFIX = "_canary_"
+
+var (
+	errStorageCanary = fmt.Errorf("Storage Canary Failed")
+	errUnsafeStorage = fmt.Errorf("Unsafe Storage")
+)
+
+type canaryResult struct {
+	Time   time.Time
+	Put    time.Duration
+	Get    time.Duration
+	List   time.Duration
+	Delete time.Duration
+	// What the probe found wrong, empty if nothing.
+	Error string `json:",omitempty"`
+	// Whether a conditional put overwrote the probe's object.
+	Unsafe bool `json:",omitempty"`
+}
+
+func (r canaryResult) err() error {
+	if r.Error == "" {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", errStorageCanary, r.Error)
+}
+
+// Probes os once, see above.
+func probeStorage(ctx context.Context, os objectStorage) (r canaryResult) {
+	r.Time = time.Now()
+	timed := func(d *time.Duration, f func() error) error {
+		start := time.Now()
+		err := f()
+		*d = time.Since(start)
+		return err
+	}
+	fail := func(format string, args ...any) canaryResult {
+		r.Error = fmt.Sprintf(format, args...)
+		return r
+	}
+
+	name := CANARY_PREFIX + uuidv4()
+	body := []byte(r.Time.Format(time.RFC3339Nano))
+	err := timed(&r.Put, func() error {
+		return os.putIfAbsent(ctx, name, body)
+	})
+	if err != nil {
+		return fail("put: %s", err)
+	}
+	defer func() {
+		err := timed(&r.Delete, func() error {
+			return os.delete(ctx, name)
+		})
+		if err != nil && r.Error == "" {
+			r.Error = fmt.Sprintf("delete: %s", err)
+		}
+	}()
+
+	var read []byte
+	err = timed(&r.Get, func() error {
+		read, err = os.read(ctx, name)
+		return err
+	})
+	if err != nil {
+		return fail("read after put: %s", err)
+	}
+	if !bytes.Equal(read, body) {
+		return fail("read %d bytes back after putting %d", len(read), len(body))
+	}
+
+	var names []string
+	err = timed(&r.List, func() error {
+		names, err = os.listPrefix(ctx, CANARY_PREFIX)
+		return err
+	})
+	if err != nil {
+		return fail("list: %s", err)
+	}
+	if !slices.Contains(names, name) {
+		return fail("list after put doesn't have %s", name)
+	}
+
+	err = os.putIfAbsent(ctx, name, []byte("overwritten"))
+	if err == nil {
+		r.Unsafe = true
+		return fail("conditional put of %s succeeded though it exists", name)
+	}
+	if !errors.Is(err, fs.ErrExist) {
+		return fail("conditional put of an existing object: %s, expected fs.ErrExist", err)
+	}
+
+	read, err = os.read(ctx, name)
+	if err != nil {
+		return fail("read after conditional put: %s", err)
+	}
+	if !bytes.Equal(read, body) {
+		r.Unsafe = true
+		return fail("conditional put of %s failed but changed it", name)
+	}
+
+	return r
+}
+
+// Probes storage in the background and keeps what it found.
+type storageCanary struct {
+	os objectStorage
+	// Reported to if not nil, see metrics.go.
+	metrics Metrics
+	// Logged to if not nil, see logging.go.
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	latest   *canaryResult
+	probes   int
+	failures int
+	unsafe   bool
+}
+
+func newStorageCanary(os objectStorage) *storageCanary {
+	return &storageCanary{os: os}
+}
+
+func (sc *storageCanary) probe(ctx context.Context) canaryResult {
+	r := probeStorage(ctx, sc.os)
+	if r.Error != "" {
+		logTo(sc.logger, slog.LevelWarn, "canary", "probe failed", "err", r.Error, "unsafe", r.Unsafe)
+	}
+	if sc.metrics != nil {
+		sc.report(r)
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.latest = &r
+	sc.probes++
+	if r.Error != "" {
+		sc.failures++
+	}
+	// Once unsafe, always: the backend can't be trusted again
+	// until someone looks at it.
+	sc.unsafe = sc.unsafe || r.Unsafe
+	return r
+}
+
+func (sc *storageCanary) report(r canaryResult) {
+	sc.metrics.Add(METRIC_CANARY_PROBES, 1)
+	if r.Error != "" {
+		sc.metrics.Add(METRIC_CANARY_FAILED, 1)
+	}
+	if r.Unsafe {
+		sc.metrics.Add(METRIC_CANARY_UNSAFE, 1)
+	}
+	for _, step := range []struct {
+		name string
+		d    time.Duration
+	}{{"put", r.Put}, {"get", r.Get}, {"list", r.List}, {"delete", r.Delete}} {
+		if step.d > 0 {
+			sc.metrics.Observe(METRIC_CANARY_SECONDS, step.d.Seconds(), "step", step.name)
+		}
+	}
+}
+
+// Probes every interval until ctx is done.
+func (sc *storageCanary) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		sc.probe(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type canaryStatus struct {
+	// nil before the first probe.
+	Latest   *canaryResult
+	Probes   int
+	Failures int
+	Unsafe   bool
+}
+
+func (sc *storageCanary) status() canaryStatus {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	return canaryStatus{sc.latest, sc.probes, sc.failures, sc.unsafe}
+}
+
+// Fails once the canary has seen storage that isn't safe to commit
+// to.
+func (sc *storageCanary) checkSafe() error {
+	if sc == nil || !sc.status().Unsafe {
+		return nil
+	}
+
+	return fmt.Errorf("%w: a conditional put overwrote an object, see GET /canary", errUnsafeStorage)
+}
+
+// The minimum, median and maximum of durations.
+func latencySummary(durations []time.Duration) (time.Duration, time.Duration, time.Duration) {
+	if len(durations) == 0 {
+		return 0, 0, 0
+	}
+
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	return sorted[0], sorted[len(sorted)/2], sorted[len(sorted)-1]
+}
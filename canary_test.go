@@ -0,0 +1,73 @@
+package otf
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Overwrites objects that exist rather than failing.
+type unconditionalStorage struct {
+	*memoryObjectStorage
+}
+
+func (us unconditionalStorage) putIfAbsent(ctx context.Context, name string, bytes []byte) error {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+
+	us.objects[name] = bytes
+	return nil
+}
+
+func TestProbeStorage(t *testing.T) {
+	os := newMemoryObjectStorage()
+	r := probeStorage(context.Background(), os)
+	assertEq(r.err(), nil, "probe of memory storage")
+	assert(!r.Unsafe, "memory storage unsafe")
+	names, err := os.listPrefix(context.Background(), CANARY_PREFIX)
+	assertEq(err, nil, "could not list")
+	assertEq(len(names), 0, "probe objects left behind")
+
+	r = probeStorage(context.Background(), unconditionalStorage{newMemoryObjectStorage()})
+	assert(errors.Is(r.err(), errStorageCanary), "probe of unconditional storage")
+	assert(r.Unsafe, "unconditional storage safe")
+}
+
+func TestCanaryServer(t *testing.T) {
+	os := unconditionalStorage{newMemoryObjectStorage()}
+	s := newServer(os, "secret")
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	status, out := post(srv, "secret", "/tx", nil)
+	assertEq(status, http.StatusOK, "begin status")
+	tx := out["Tx"].(string)
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/canary", nil)
+	assertEq(err, nil, "could not build request")
+	req.Header.Set("Authorization", "Bearer secret")
+	res, err := srv.Client().Do(req)
+	assertEq(err, nil, "could not get")
+	res.Body.Close()
+	assertEq(res.StatusCode, http.StatusBadRequest, "status without a canary")
+
+	s.canary = newStorageCanary(os)
+	s.canary.probe(context.Background())
+
+	res, err = srv.Client().Do(req)
+	assertEq(err, nil, "could not get")
+	defer res.Body.Close()
+	assertEq(res.StatusCode, http.StatusOK, "canary status")
+	var got canaryStatus
+	err = json.NewDecoder(res.Body).Decode(&got)
+	assertEq(err, nil, "could not decode status")
+	assertEq(got.Probes, 1, "probes")
+	assertEq(got.Failures, 1, "failures")
+	assert(got.Unsafe, "status safe")
+
+	status, _ = post(srv, "secret", "/tx/"+tx+"/commit", nil)
+	assertEq(status, http.StatusServiceUnavailable, "commit to unsafe storage")
+}
@@ -0,0 +1,229 @@
+package otf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Analyst tooling can be pointed at a read-only view of the REST
+// catalogs (see restcatalog.go) rather than at the catalogs
+// themselves. The view is served as a REST catalog of its own,
+// generated from the source catalogs on each request, showing only
+// some of their namespaces and tables and refusing anything but
+// reads.
+//
+// Tables can have columns masked. The view lists them in a table's
+// otf.masked property and marks every table otf.read-only. Clients
+// using the view as their catalog scan masked columns as nulls,
+// can't filter on them, and can't commit. Other tooling is trusted
+// to do the same: the view is a surface to bind to, not access
+// control over the storage behind it, which is what credentials are
+// for (see credentials.go).
+
+const (
+	CATALOG_MASKED    = "otf.masked"
+	CATALOG_READ_ONLY = "otf.read-only"
+)
+
+var (
+	errReadOnlyCatalog = fmt.Errorf("Read-Only Catalog")
+	errMaskedColumn    = fmt.Errorf("Masked Column")
+)
+
+// What a catalog view shows of its source catalogs.
+type CatalogViewConfig struct {
+	// Mapping namespace to the tables in it shown, or to nil
+	// for all of them. Namespaces not here are hidden.
+	Tables map[string][]string
+	// Mapping namespace and table to columns masked.
+	Masked map[string]map[string][]string
+}
+
+type catalogView struct {
+	// Mapping namespace to the catalog holding it.
+	sources map[string]*restCatalog
+	config  CatalogViewConfig
+	token   string
+	mux     *http.ServeMux
+}
+
+// Serves a view of sources, one catalog per namespace, as config
+// says. Requests must carry token as a bearer token unless it's
+// empty.
+func newCatalogView(sources []*restCatalog, config CatalogViewConfig, token string) *catalogView {
+	v := &catalogView{
+		sources: map[string]*restCatalog{},
+		config:  config,
+		token:   token,
+		mux:     http.NewServeMux(),
+	}
+	for _, cat := range sources {
+		v.sources[cat.namespace] = cat
+	}
+
+	v.mux.HandleFunc("GET /v1/config", v.handleConfig)
+	v.mux.HandleFunc("GET /v1/namespaces", v.handleListNamespaces)
+	v.mux.HandleFunc("GET /v1/namespaces/{namespace}", v.withNamespace(v.handleLoadNamespace))
+	v.mux.HandleFunc("GET /v1/namespaces/{namespace}/tables", v.withNamespace(v.handleListTables))
+	v.mux.HandleFunc("GET /v1/namespaces/{namespace}/tables/{table}", v.withNamespace(v.handleLoadTable))
+	return v
+}
+
+func (v *catalogView) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if v.token != "" && r.Header.Get("Authorization") != "Bearer "+v.token {
+		writeCatalogError(w, http.StatusUnauthorized, "NotAuthorizedException", "bad token")
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeCatalogError(w, http.StatusForbidden, "ForbiddenException", "read-only catalog")
+		return
+	}
+
+	v.mux.ServeHTTP(w, r)
+}
+
+func writeCatalogError(w http.ResponseWriter, status int, typ, message string) {
+	var e catalogErrorResponse
+	e.Error.Message = message
+	e.Error.Type = typ
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e)
+}
+
+func writeCatalogJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// Whether the view shows namespace, and table in it if not empty.
+func (v *catalogView) shows(namespace, table string) bool {
+	tables, ok := v.config.Tables[namespace]
+	if !ok || v.sources[namespace] == nil {
+		return false
+	}
+
+	return table == "" || tables == nil || slices.Contains(tables, table)
+}
+
+func (v *catalogView) withNamespace(handler func(http.ResponseWriter, *http.Request, *restCatalog)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		namespace := r.PathValue("namespace")
+		if !v.shows(namespace, r.PathValue("table")) {
+			writeCatalogError(w, http.StatusNotFound, "NoSuchTableException", "not in this catalog")
+			return
+		}
+
+		handler(w, r, v.sources[namespace])
+	}
+}
+
+func (v *catalogView) handleConfig(w http.ResponseWriter, r *http.Request) {
+	writeCatalogJSON(w, map[string]any{
+		"defaults":  map[string]string{},
+		"overrides": map[string]string{CATALOG_READ_ONLY: "true"},
+	})
+}
+
+func (v *catalogView) handleListNamespaces(w http.ResponseWriter, r *http.Request) {
+	namespaces := [][]string{}
+	for _, namespace := range sortedKeys(v.sources) {
+		if v.shows(namespace, "") {
+			namespaces = append(namespaces, []string{namespace})
+		}
+	}
+
+	writeCatalogJSON(w, map[string]any{"namespaces": namespaces})
+}
+
+func (v *catalogView) handleLoadNamespace(w http.ResponseWriter, r *http.Request, cat *restCatalog) {
+	writeCatalogJSON(w, map[string]any{
+		"namespace":  []string{cat.namespace},
+		"properties": map[string]string{CATALOG_READ_ONLY: "true"},
+	})
+}
+
+func (v *catalogView) handleListTables(w http.ResponseWriter, r *http.Request, cat *restCatalog) {
+	names, err := cat.listTables()
+	if err != nil {
+		writeCatalogError(w, http.StatusBadGateway, "ServiceUnavailableException", err.Error())
+		return
+	}
+
+	ids := []catalogIdentifier{}
+	for _, name := range names {
+		if v.shows(cat.namespace, name) {
+			ids = append(ids, catalogIdentifier{[]string{cat.namespace}, name})
+		}
+	}
+
+	writeCatalogJSON(w, map[string]any{"identifiers": ids})
+}
+
+func (v *catalogView) handleLoadTable(w http.ResponseWriter, r *http.Request, cat *restCatalog) {
+	table := r.PathValue("table")
+	// Passed on as is but for its properties.
+	var result map[string]any
+	err := cat.do(http.MethodGet, "/tables/"+url.PathEscape(table), nil, &result)
+	if errors.Is(err, errCatalogNoTable) {
+		writeCatalogError(w, http.StatusNotFound, "NoSuchTableException", err.Error())
+		return
+	}
+	if err != nil {
+		writeCatalogError(w, http.StatusBadGateway, "ServiceUnavailableException", err.Error())
+		return
+	}
+
+	metadata, _ := result["metadata"].(map[string]any)
+	if metadata == nil {
+		metadata = map[string]any{}
+		result["metadata"] = metadata
+	}
+	properties, _ := metadata["properties"].(map[string]any)
+	if properties == nil {
+		properties = map[string]any{}
+		metadata["properties"] = properties
+	}
+
+	properties[CATALOG_READ_ONLY] = "true"
+	if masked := v.config.Masked[cat.namespace][table]; len(masked) > 0 {
+		properties[CATALOG_MASKED] = strings.Join(masked, ",")
+	}
+
+	writeCatalogJSON(w, result)
+}
+
+// b with the given columns all null.
+func (b *batch) masked(columns []int) *batch {
+	m := &batch{Columns: slices.Clone(b.Columns), Len: b.Len}
+	for _, i := range columns {
+		m.Columns[i] = make([]any, b.Len)
+	}
+
+	return m
+}
+
+// Fails if p filters on a column of table masked by the catalog.
+func (d *client) checkUnmasked(table string, p *predicate) error {
+	masked := d.tx.masked[table]
+	if masked == nil {
+		return nil
+	}
+
+	if slices.Contains(masked, p.Column) {
+		return fmt.Errorf("%w: %s.%s", errMaskedColumn, table, p.Column)
+	}
+	for _, q := range slices.Concat(p.And, p.Or) {
+		if err := d.checkUnmasked(table, q); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
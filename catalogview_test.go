@@ -0,0 +1,78 @@
+package otf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCatalogView(t *testing.T) {
+	_, source := newFakeCatalog("")
+	defer source.Close()
+
+	mos := newMemoryObjectStorage()
+	cat := newRESTCatalog(source.URL, "ns", "")
+	c := newClient(mos, withCatalog(cat, "s3://bucket/store"))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "secret"})
+	assertEq(err, nil, "could not create x")
+	err = c.createTable("y", []string{"a"})
+	assertEq(err, nil, "could not create y")
+	err = c.writeRow("x", []any{1, "s"})
+	assertEq(err, nil, "could not write row")
+	err = c.writeRow("y", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	view := httptest.NewServer(newCatalogView([]*restCatalog{cat}, CatalogViewConfig{
+		Tables: map[string][]string{"ns": {"x"}},
+		Masked: map[string]map[string][]string{"ns": {"x": {"secret"}}},
+	}, "analyst"))
+	defer view.Close()
+
+	viewCat := newRESTCatalog(view.URL, "ns", "analyst")
+	names, err := viewCat.listTables()
+	assertEq(err, nil, "could not list tables")
+	assertEq(fmt.Sprint(names), "[x]", "tables")
+	_, err = viewCat.loadTable("y")
+	assert(errors.Is(err, errCatalogNoTable), "loaded a hidden table")
+	x, err := viewCat.loadTable("x")
+	assertEq(err, nil, "could not load x")
+	assertEq(x.Snapshot, 0, "snapshot")
+	assertEq(fmt.Sprint(x.Masked), "[secret]", "masked columns")
+	assert(x.ReadOnly, "x is writable")
+
+	err = viewCat.updateTable(*x)
+	assert(errors.Is(err, errCatalog), "updated through the view")
+	_, err = newRESTCatalog(view.URL, "ns", "").listTables()
+	assert(errors.Is(err, errCatalog), "listed without the token")
+	_, err = newRESTCatalog(view.URL, "other", "analyst").listTables()
+	assert(errors.Is(err, errCatalogNoTable), "listed a hidden namespace")
+
+	req, _ := http.NewRequest(http.MethodGet, view.URL+"/v1/namespaces", nil)
+	req.Header.Set("Authorization", "Bearer analyst")
+	res, err := http.DefaultClient.Do(req)
+	assertEq(err, nil, "could not list namespaces")
+	var namespaces struct{ Namespaces [][]string }
+	err = json.NewDecoder(res.Body).Decode(&namespaces)
+	res.Body.Close()
+	assertEq(err, nil, "could not decode namespaces")
+	assertEq(fmt.Sprint(namespaces.Namespaces), "[[ns]]", "namespaces")
+
+	// Clients bound to the view honor it.
+	analyst := newClient(mos, withCatalog(viewCat, "s3://bucket/store"))
+	err = analyst.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(fmt.Sprint(scanAll(&analyst, "x")), "[[1 <nil>]]", "masked rows")
+	_, err = analyst.scan("x", withFilter(where("secret", OP_EQ, "s")))
+	assert(errors.Is(err, errMaskedColumn), "filtered on a masked column")
+	err = analyst.writeRow("x", []any{2, "t"})
+	assertEq(err, nil, "could not write row")
+	err = analyst.commitTx()
+	assert(errors.Is(err, errReadOnlyCatalog), "committed through the view")
+}
@@ -0,0 +1,305 @@
+package otf
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Change data capture: every row inserted into or deleted from any
+// table by transactions committed after a given one, as a stream in
+// commit order. The log is read as the stream is consumed, and a
+// tailing stream polls for new log entries once it has caught up.
+// Syncing and watching a table (see sync.go and watch.go) read a
+// stream of just that table's changes.
+//
+// Rows are in their table's schema as of the transaction that
+// changed them, which the change carries, since later schema changes
+// aren't known yet when it is read. Rows of a dataobject removed
+// (e.g. by an update, see update.go) are deleted, those written in
+// its place inserted. A dataobject with a deterministic name (see
+// naming.go) may be added more than once, and deleting rows of it
+// or removing it deletes them from every add before (see delete.go).
+
+type rowChange struct {
+	TxId  int
+	Table string
+	// CHANGE_INSERT or CHANGE_DELETE.
+	Op      string
+	Columns []string
+	Row     []any
+
+	// The version of the table's schema Columns are, see schema.go.
+	version int
+}
+
+// What a change stream knows about a table so far.
+type changeTable struct {
+	history  schemaHistory
+	location *TableLocation
+	// The adds of each live dataobject by name, and their deleted
+	// rows.
+	dataobjects map[string][]*DataobjectAction
+	deleted     map[*DataobjectAction]map[int]bool
+}
+
+type changeIterator struct {
+	d     *client
+	after int
+	// Log entries, how many of them have been read and the id of
+	// the last one read, -1 before any.
+	names []string
+	read  int
+	last  int
+	// Changes of the last entry read not yet returned.
+	pending []rowChange
+	tables  map[string]*changeTable
+	// Only changes to this table, if not empty.
+	only string
+
+	// Whether to wait for more entries once caught up, how often
+	// to look for them and until when.
+	tail     bool
+	interval time.Duration
+	stop     <-chan struct{}
+}
+
+// Changes committed by transactions after the one with id after, -1
+// for every change. The stream ends once it has caught up with the
+// log.
+func (d *client) changesSince(after int) (*changeIterator, error) {
+	names, err := d.listLog()
+	if err != nil {
+		return nil, err
+	}
+
+	return &changeIterator{d: d, after: after, names: names, last: -1, tables: map[string]*changeTable{}}, nil
+}
+
+// Like changesSince but once caught up waits for more, polling the
+// log every interval, until stop is closed.
+func (d *client) tailChanges(after int, interval time.Duration, stop <-chan struct{}) (*changeIterator, error) {
+	it, err := d.changesSince(after)
+	if err != nil {
+		return nil, err
+	}
+
+	it.tail = true
+	it.interval = interval
+	it.stop = stop
+	return it, nil
+}
+
+// Returns (nil, nil) when done.
+func (it *changeIterator) next() (*rowChange, error) {
+	err := it.fill()
+	if err != nil || len(it.pending) == 0 {
+		return nil, err
+	}
+
+	change := it.pending[0]
+	it.pending = it.pending[1:]
+	return &change, nil
+}
+
+// The changes of the next transaction that made any not yet
+// returned, nil when done.
+func (it *changeIterator) nextTx() ([]rowChange, error) {
+	err := it.fill()
+	if err != nil || len(it.pending) == 0 {
+		return nil, err
+	}
+
+	changes := it.pending
+	it.pending = nil
+	return changes, nil
+}
+
+// Reads entries until one has changes pending or the stream is done.
+func (it *changeIterator) fill() error {
+	for len(it.pending) == 0 {
+		if it.read < len(it.names) {
+			err := it.readEntry(it.names[it.read])
+			if err != nil {
+				return err
+			}
+
+			it.last = logEntryId(it.names[it.read])
+			it.read++
+			continue
+		}
+
+		if !it.tail {
+			return nil
+		}
+
+		select {
+		case <-it.stop:
+			return nil
+		case <-time.After(it.interval):
+		}
+
+		// Entries read so far may have been coalesced (see
+		// coalesce.go) since, so carry on after the last one read
+		// by id.
+		names, err := it.d.listLog()
+		if err != nil {
+			return err
+		}
+		names = slices.DeleteFunc(names, func(name string) bool {
+			return logEntryId(name) <= it.last
+		})
+		it.names = names
+		it.read = 0
+	}
+
+	return nil
+}
+
+func (it *changeIterator) table(name string) *changeTable {
+	t, ok := it.tables[name]
+	if !ok {
+		t = &changeTable{dataobjects: map[string][]*DataobjectAction{}, deleted: map[*DataobjectAction]map[int]bool{}}
+		it.tables[name] = t
+	}
+
+	return t
+}
+
+// Reads the entry with the given name, queueing its changes if it
+// committed after the stream started.
+func (it *changeIterator) readEntry(name string) error {
+	err := checkNotCoalesced(name, it.after)
+	if err != nil {
+		return err
+	}
+
+	entry, err := it.d.readLogEntry(name)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range sortedKeys(entry.Actions) {
+		if it.only != "" && table != it.only {
+			continue
+		}
+
+		t := it.table(table)
+		for _, action := range entry.Actions[table] {
+			if mtd := action.ChangeMetadata; mtd != nil {
+				t.history = t.history.record(mtd)
+				if mtd.Location != nil {
+					t.location = mtd.Location
+				}
+			}
+
+			err = it.apply(entry.Id, table, t, action)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Tracks the dataobjects action adds, deletes rows of or removes,
+// queueing the rows it inserted or deleted if it committed after the
+// stream started.
+func (it *changeIterator) apply(txId int, table string, t *changeTable, action Action) error {
+	queue := txId > it.after
+	read := func(do *DataobjectAction) (*dataobject, error) {
+		storage, err := it.d.storageAt(t.location)
+		if err != nil {
+			return nil, err
+		}
+
+		return it.d.readDataobjectFrom(it.d.context(), storage, do, t.history.converter(do.SchemaVersion))
+	}
+
+	emit := func(op string, row []any) {
+		columns := t.history[len(t.history)-1].Columns
+		it.pending = append(it.pending, rowChange{txId, table, op, columns, row, t.history.version()})
+	}
+
+	switch {
+	case action.AddDataobject != nil:
+		do := action.AddDataobject
+		t.dataobjects[do.Name] = append(t.dataobjects[do.Name], do)
+		if !queue {
+			return nil
+		}
+
+		o, err := read(do)
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < o.Len; i++ {
+			emit(CHANGE_INSERT, o.row(i))
+		}
+	case action.DeleteRows != nil:
+		name := action.DeleteRows.Name
+		adds := t.dataobjects[name]
+		if queue && len(adds) == 0 {
+			return fmt.Errorf("change to unknown dataobject: %s", name)
+		}
+
+		for _, do := range adds {
+			deleted := t.deleted[do]
+			if deleted == nil {
+				deleted = map[int]bool{}
+				t.deleted[do] = deleted
+			}
+
+			var o *dataobject
+			for _, i := range action.DeleteRows.Rows {
+				if deleted[i] {
+					continue
+				}
+				deleted[i] = true
+				if !queue {
+					continue
+				}
+
+				if o == nil {
+					var err error
+					o, err = read(do)
+					if err != nil {
+						return err
+					}
+				}
+				emit(CHANGE_DELETE, o.row(i))
+			}
+		}
+	case action.RemoveDataobject != nil:
+		// Every row still in it is deleted.
+		name := action.RemoveDataobject.Name
+		adds := t.dataobjects[name]
+		if queue && len(adds) == 0 {
+			return fmt.Errorf("change to unknown dataobject: %s", name)
+		}
+
+		delete(t.dataobjects, name)
+		for _, do := range adds {
+			deleted := t.deleted[do]
+			delete(t.deleted, do)
+			if !queue {
+				continue
+			}
+
+			o, err := read(do)
+			if err != nil {
+				return err
+			}
+
+			for i := 0; i < o.Len; i++ {
+				if !deleted[i] {
+					emit(CHANGE_DELETE, o.row(i))
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package otf
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func readChanges(it *changeIterator) []string {
+	var changes []string
+	for {
+		change, err := it.next()
+		assertEq(err, nil, "could not read change")
+		if change == nil {
+			return changes
+		}
+		changes = append(changes, fmt.Sprintf("%d %s %s %v %v", change.TxId, change.Table, change.Op, change.Columns, change.Row))
+	}
+}
+
+func TestChangesSince(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.createTable("y", []string{"a"})
+	assertEq(err, nil, "could not create y")
+	for i := 0; i < 2; i++ {
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.writeRow("y", []any{"y"})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.deleteRows("x", where("a", OP_EQ, 1))
+	assertEq(err, nil, "could not delete")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Rows written before a column was added don't have it.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.addColumn("x", "b")
+	assertEq(err, nil, "could not add column")
+	err = c.writeRow("x", []any{2, "b"})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	it, err := c.changesSince(-1)
+	assertEq(err, nil, "could not read changes")
+	assertEq(fmt.Sprint(readChanges(it)), fmt.Sprint([]string{
+		"0 x insert [a] [0]",
+		"0 x insert [a] [1]",
+		"0 y insert [a] [y]",
+		"1 x delete [a] [1]",
+		"2 x insert [a b] [2 b]",
+	}), "wrong changes")
+
+	it, err = c.changesSince(0)
+	assertEq(err, nil, "could not read changes")
+	assertEq(fmt.Sprint(readChanges(it)), fmt.Sprint([]string{
+		"1 x delete [a] [1]",
+		"2 x insert [a b] [2 b]",
+	}), "wrong changes since 0")
+
+	it, err = c.changesSince(2)
+	assertEq(err, nil, "could not read changes")
+	assertEq(len(readChanges(it)), 0, "changes after the last transaction")
+}
+
+func TestTailChanges(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	stop := make(chan struct{})
+	it, err := c.tailChanges(-1, time.Millisecond, stop)
+	assertEq(err, nil, "could not tail changes")
+
+	changes := make(chan *rowChange)
+	go func() {
+		defer close(changes)
+		for {
+			change, err := it.next()
+			assertEq(err, nil, "could not read change")
+			if change == nil {
+				return
+			}
+			changes <- change
+		}
+	}()
+
+	w := newClient(c.os)
+	err = w.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = w.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = w.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	change := <-changes
+	assertEq(change.TxId, 1, "wrong tx")
+	assertEq(fmt.Sprint(change.Row), "[1]", "wrong row")
+
+	close(stop)
+	_, ok := <-changes
+	assert(!ok, "didn't stop")
+}
+
+func TestChangesContentHashNamed(t *testing.T) {
+	c := newClient(newMemoryObjectStorage(), withNaming(contentHashNaming{}))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Two adds of one dataobject, both deleted from, then added
+	// again.
+	writeTx(&c, "x", []any{1})
+	writeTx(&c, "x", []any{1})
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.deleteRows("x", nil)
+	assertEq(err, nil, "could not delete")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	writeTx(&c, "x", []any{1})
+
+	changes, err := c.tableChangesSince("x", -1)
+	assertEq(err, nil, "could not read changes")
+	var ops []string
+	for _, txChanges := range changes {
+		for _, change := range txChanges {
+			ops = append(ops, fmt.Sprintf("%d %s", change.TxId, change.Op))
+		}
+	}
+	assertEq(fmt.Sprint(ops), "[1 insert 2 insert 3 delete 3 delete 4 insert]", "changes")
+}
@@ -0,0 +1,154 @@
+package otf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Every CHECKPOINT_INTERVAL commits the committing client writes a
+// checkpoint: every table's schema and live dataobjects as of a
+// transaction, in one object. Starting a transaction loads the
+// latest checkpoint and only replays log entries after it, rather
+// than every log entry ever written.
+//
+// Checkpoints are only an optimization. Log entries are still the
+// source of truth and a missing or failed checkpoint only means
+// replaying more of them. Tables' manifests are written along with
+// them, see manifest.go.
+
+const CHECKPOINT_INTERVAL = 100
+
+type checkpoint struct {
+	// Of the latest entry included, see compat.go. Zero in
+	// checkpoints from before it was recorded.
+	Version  int      `json:",omitempty"`
+	Features []string `json:",omitempty"`
+	// The last transaction included.
+	Id int
+	// Mapping table name to schema versions followed by live
+	// actions.
+	Actions map[string][]Action
+	// See transaction.tableVersions.
+	TableVersions map[string]int
+}
+
+// Writes a checkpoint every n commits, or never if n is 0.
+func withCheckpointInterval(n int) clientOption {
+	return func(c *client) {
+		c.checkpointInterval = n
+	}
+}
+
+func checkpointName(id int) string {
+	return fmt.Sprintf("_checkpoint_%020d", id)
+}
+
+// The latest checkpoint including no transaction after upTo, or nil.
+func (d *client) latestCheckpoint(upTo int) (*checkpoint, error) {
+	names, err := d.os.listPrefix(d.context(), "_checkpoint_")
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(names) - 1; i >= 0; i-- {
+		id, err := strconv.Atoi(strings.TrimPrefix(names[i], "_checkpoint_"))
+		if err != nil || id > upTo {
+			continue
+		}
+
+		bytes, err := d.os.read(d.context(), names[i])
+		if err != nil {
+			return nil, err
+		}
+
+		var cp checkpoint
+		err = json.Unmarshal(bytes, &cp)
+		if err != nil {
+			return nil, err
+		}
+
+		return &cp, checkLogVersion(names[i], cp.entry())
+	}
+
+	return nil, nil
+}
+
+// An entry with just the checkpoint's version, for compat.go.
+func (cp *checkpoint) entry() *logEntry {
+	return &logEntry{Version: cp.Version, Id: cp.Id, Features: cp.Features}
+}
+
+// Writes a checkpoint of the latest committed state. d must not be
+// in a transaction.
+func (d *client) writeCheckpoint() (int, error) {
+	if d.branch != "" {
+		return 0, fmt.Errorf("%w: only %s's log is checkpointed", errBadRequest, MAIN_BRANCH)
+	}
+
+	err := d.newTx()
+	if err != nil {
+		return 0, err
+	}
+	err = d.checkLogWritable()
+	tx := d.tx
+	d.tx = nil
+	if err != nil {
+		return 0, err
+	}
+
+	cp := checkpoint{
+		Version:       LOG_ENTRY_VERSION,
+		Id:            tx.Id - 1,
+		Actions:       map[string][]Action{},
+		TableVersions: tx.tableVersions,
+	}
+	for table := range tx.tables {
+		cp.Actions[table] = append(tx.schemaActions(table), tx.previousActions[table]...)
+	}
+
+	bytes, err := json.Marshal(cp)
+	if err != nil {
+		return 0, err
+	}
+
+	err = d.os.putIfAbsent(d.context(), checkpointName(cp.Id), bytes)
+	if errors.Is(err, fs.ErrExist) {
+		// Someone else got there first, and checkpoints of
+		// the same transaction are the same.
+		err = nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	// See manifest.go.
+	err = d.writeManifests(tx)
+	if err != nil {
+		d.warn("checkpoint", "could not write manifests", "err", err)
+	}
+
+	return cp.Id, nil
+}
+
+// Failing to checkpoint must not fail the commit, so errors are
+// only logged.
+func (d *client) checkpointAfterCommit() {
+	id, err := d.writeCheckpoint()
+	if err != nil {
+		d.warn("checkpoint", "could not checkpoint", "err", err)
+		return
+	}
+
+	d.debug("checkpoint", "checkpointed", "through", id)
+
+	if r := d.snapshotRetention; r != nil {
+		_, err = d.expireSnapshots(r.Keep, r.Retention)
+		if err != nil {
+			d.warn("checkpoint", "could not expire snapshots", "err", err)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package otf
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// Counts log entries read.
+type countingLogReads struct {
+	objectStorage
+	reads int
+}
+
+func (cr *countingLogReads) read(ctx context.Context, name string) ([]byte, error) {
+	if strings.HasPrefix(name, "_log_") {
+		cr.reads++
+	}
+	return cr.objectStorage.read(ctx, name)
+}
+
+func TestCheckpoints(t *testing.T) {
+	cr := &countingLogReads{objectStorage: newMemoryObjectStorage()}
+	c := newClient(cr, withCheckpointInterval(5))
+	for i := 0; i < 12; i++ {
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+		switch i {
+		case 0:
+			err = c.createTable("x", []string{"a"})
+		case 3:
+			_, err = c.deleteRows("x", where("a", OP_EQ, 1))
+		case 6:
+			_, err = c.updateRows("x", where("a", OP_EQ, 2), map[string]*expr{"a": litExpr(20)})
+		case 7:
+			err = c.addColumn("x", "b")
+		}
+		assertEq(err, nil, "could not change x")
+
+		row := []any{i}
+		if i >= 7 {
+			row = append(row, "b")
+		}
+		err = c.writeRow("x", row)
+		assertEq(err, nil, "could not write row")
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+
+	names, err := cr.listPrefix(context.Background(), "_checkpoint_")
+	assertEq(err, nil, "could not list checkpoints")
+	assertEq(strings.Join(names, ","), checkpointName(4)+","+checkpointName(9), "checkpoints")
+
+	cr.reads = 0
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(cr.reads, 2, "log entries replayed")
+	assertEq(c.tx.Id, 12, "tx id")
+
+	rows := scanAll(&c, "x")
+	assertEq(len(rows), 11, "rows")
+	sum := 0.0
+	for _, row := range rows {
+		sum += row[0].(float64)
+	}
+	// 0 through 11, less the deleted 1 and with 2 updated to 20.
+	assertEq(sum, 83.0, "sum")
+	assertEq(c.tx.tables["x"][1], "b", "schema")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Before the first checkpoint.
+	err = c.newTxAt(2)
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(scanAll(&c, "x")), 3, "rows as of tx 2")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+}
@@ -0,0 +1,71 @@
+package otf
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+)
+
+// Object storage is trusted to return what was put, but disks, caches
+// and proxies in between aren't always, so objects are checksummed
+// when written and checked when read. A dataobject's checksum, and
+// each of its row groups' and families' (see rowgroup.go and
+// families.go), is recorded in the action adding it; reads of one
+// that doesn't match fail with errCorrupt rather than decode
+// garbage, or worse, decode the wrong rows.
+//
+// Log entries checksum themselves: an entry ends with a Checksum
+// field of the bytes before it. Entries are only ever decoded with
+// encoding/json, which ignores the field, so clients that don't know
+// about it read them as before. Entries, dataobjects and parts
+// without a checksum, e.g. from before they were recorded, aren't
+// checked.
+//
+// Checksums are CRC32C: they're for catching accidents, not
+// tampering.
+
+var errCorrupt = fmt.Errorf("Corrupt Object")
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func checksum(bs []byte) string {
+	return fmt.Sprintf("%08x", crc32.Checksum(bs, castagnoli))
+}
+
+// Fails if bs, read from name, don't have the expected checksum,
+// unless none was recorded.
+func verifyChecksum(name string, bs []byte, expected string) error {
+	if expected == "" {
+		return nil
+	}
+
+	if actual := checksum(bs); actual != expected {
+		return fmt.Errorf("%w: %s has checksum %s, expected %s", errCorrupt, name, actual, expected)
+	}
+
+	return nil
+}
+
+const logChecksumKey = `,"Checksum":"`
+
+// Ends the encoded entry entry, without its closing brace, with its
+// checksum.
+func appendLogChecksum(entry []byte) []byte {
+	sum := checksum(entry)
+	entry = append(entry, logChecksumKey...)
+	entry = append(entry, sum...)
+	return append(entry, '"', '}')
+}
+
+// Fails if the log entry entry, read from name, ends with a checksum
+// it doesn't match.
+func verifyLogChecksum(name string, entry []byte) error {
+	// E.g. ,"Checksum":"0123abcd"}
+	n := len(logChecksumKey) + 8 + 2
+	if len(entry) < n || !bytes.HasPrefix(entry[len(entry)-n:], []byte(logChecksumKey)) {
+		return nil
+	}
+
+	sum := entry[len(entry)-n+len(logChecksumKey) : len(entry)-2]
+	return verifyChecksum(name, entry[:len(entry)-n], string(sum))
+}
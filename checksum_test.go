@@ -0,0 +1,89 @@
+package otf
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// Flips a bit of name's byte at i.
+func corrupt(mos *memoryObjectStorage, name string, i int) {
+	mos.mu.Lock()
+	defer mos.mu.Unlock()
+	object := bytes.Clone(mos.objects[name])
+	object[i] ^= 1
+	mos.objects[name] = object
+}
+
+func TestChecksumDataobject(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos, withRowGroupSize(1))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b"}, withColumnFamilies([]string{"b"}))
+	assertEq(err, nil, "could not create x")
+	err = c.createTable("y", []string{"a"})
+	assertEq(err, nil, "could not create y")
+	for _, table := range []string{"x", "y"} {
+		for i := 0; i < 2; i++ {
+			err = c.writeRow(table, []any{i, "b"}[:len(c.tx.tables[table])])
+			assertEq(err, nil, "could not write row")
+		}
+		err = c.flushRows(table)
+		assertEq(err, nil, "could not flush")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	added := func(table string) *DataobjectAction {
+		for _, action := range c.tx.previousActions[table] {
+			if action.AddDataobject != nil {
+				return action.AddDataobject
+			}
+		}
+		return nil
+	}
+	x := added("x")
+	assert(x.Checksum != "" && x.Families[0].Checksum != "", "no checksums recorded")
+	y := added("y")
+	assertEq(len(y.RowGroups), 2, "row groups")
+	assert(y.RowGroups[1].Checksum != "", "no row group checksum recorded")
+	assertEq(len(scanAll(&c, "x")), 2, "rows")
+
+	corrupt(mos, familyKey(dataobjectKey("x", x.Name), 0), 0)
+	_, err = c.readDataobject(x)
+	assert(errors.Is(err, errCorrupt), "read a corrupt family")
+	_, err = c.readDataobject(&DataobjectAction{Table: "x", Name: x.Name, Checksum: x.Checksum})
+	assertEq(err, nil, "could not read the rest")
+
+	// Only the group read is checked.
+	corrupt(mos, dataobjectKey("y", y.Name), int(y.RowGroups[1].Offset))
+	_, err = c.readRowGroups(c.context(), y, []int{0}, nil, nil, nil)
+	assertEq(err, nil, "could not read an intact row group")
+	_, err = c.readRowGroups(c.context(), y, []int{1}, nil, nil, nil)
+	assert(errors.Is(err, errCorrupt), "read a corrupt row group")
+	_, err = c.readDataobject(y)
+	assert(errors.Is(err, errCorrupt), "read a corrupt dataobject")
+}
+
+func TestChecksumLogEntry(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	name := logEntryName(0)
+	entry := mos.objects[name]
+	assert(verifyLogChecksum(name, entry) == nil, "entry doesn't match its checksum")
+	mos.objects[name] = bytes.Replace(entry, []byte(`"Rows":1`), []byte(`"Rows":2`), 1)
+	err = c.newTx()
+	assert(errors.Is(err, errCorrupt), "read a corrupt entry")
+}
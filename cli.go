@@ -0,0 +1,1160 @@
+package otf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Storage arguments are URLs understood by openObjectStorage.
+// Commands taking --storage default to $OTF_STORAGE.
+const USAGE = `usage: otf <command> [arguments] [--debug]
+
+commands:
+  calibrate [rows]                 report how well row estimates match generated data
+  publish <source> <destination>   publish the latest snapshot as a static bundle
+  tail <source> <table>            print changes to a table as they are committed
+
+  create-table --storage <url> --table <table> [--partition-by <columns>] [--skip-verify] <column[:type]>...
+                                   create a table, types are int, float, string, bool or timestamp,
+                                   once the storage passes the checks of doctor unless skipped
+  insert --storage <url> --table <table> --json <file>
+                                   insert rows from a JSON array of arrays or objects, - for stdin
+  import --storage <url> --table <table> --format <csv|jsonl> <file>
+                                   load rows from a CSV file with a header or from JSON
+                                   lines, - for stdin
+  scan --storage <url> --table <table> [--columns <columns>] [--metadata <columns>] [--tx <id>]
+                                   print a table's rows as JSON, one per line, followed
+                                   by metadata columns, e.g. _tx_id,_dataobject,_commit_time
+  export --storage <url> --table <table> --format <csv|jsonl|parquet> [--columns <columns>] [--tx <id>]
+                                   write a table's rows as CSV with a header, JSON lines
+                                   or a Parquet file
+  compact --storage <url> --table <table> --run <name> [--parallelism <n>]
+                                   compact a table in jobs, 4 at a time by default, each
+                                   committed on its own; running it again with the same
+                                   run resumes it (see parallelcompact.go)
+  log show --storage <url>         print each committed transaction and what it changed
+  log coalesce --storage <url> [--retention <duration>]
+                                   coalesce runs of small log entries behind a checkpoint
+                                   committed more than retention, 24h by default, ago
+  log expire --storage <url> [--keep <n>] [--retention <duration>]
+                                   expire snapshots before the latest n transactions, 100
+                                   by default, and committed more than retention ago
+  manifest --storage <url> --table <table> [--verify]
+                                   print the table's latest manifest as JSON (see manifest.go), with
+                                   --verify cross-check it against the log, replacing it if it
+                                   drifted, and print what that found (see manifestverify.go)
+  branch create --storage <url> [--at <id>] <name>
+                                   start a branch after a transaction of main, the latest
+                                   by default; commands work on the branch named by
+                                   $OTF_BRANCH, main if unset (see branch.go)
+  branch list|delete|merge --storage <url> [name]
+                                   list or delete branches, or commit a branch's changes
+                                   since it was last merged to main
+  tag create --storage <url> [--branch <name>] [--at <id>] <name>
+                                   name a transaction of a branch, main's latest by default
+  tag list|delete --storage <url> [name]
+  changes --storage <url> [--since <id>] [--follow]
+                                   print rows inserted and deleted after a transaction as
+                                   JSON, one per line, and with --follow as they're committed
+  sql --storage <url> <statement> [parameter]...
+                                   run a SQL statement (see sql.go), parameters as JSON
+  script --storage <url> [--continue] [--var <name=value>]... <file>
+                                   run a file of SQL statements, - for stdin, with BEGIN and
+                                   COMMIT for transactions and SET or --var for variables used
+                                   as :name, stopping at the first that fails unless --continue
+                                   (see script.go)
+  serve --storage <url> [--addr <addr>] [--token <token>] [--identities <file>] [--canary <interval>] [--metrics] [--tls-cert <file> --tls-key <file>]
+                                   serve the store over HTTP (see server.go), and over
+                                   gRPC with TLS, the token defaults to $OTF_SERVE_TOKEN,
+                                   identities are lines of a token and the identity it
+                                   authenticates (see rowsecurity.go), with --canary
+                                   probe the storage every interval (see canary.go), and
+                                   with --metrics serve metrics at /metrics (see metrics.go)
+  doctor --storage <url> [--probes <n>]
+                                   probe the storage n times, 5 by default, report
+                                   latencies, and check with concurrent calls that its
+                                   conditional puts are atomic, reads see writes and
+                                   listings see every object (see storageverify.go)
+`
+
+var commands = map[string]func(args []string, w io.Writer) error{
+	"calibrate":    calibrateCommand,
+	"publish":      publishCommand,
+	"tail":         tailCommand,
+	"create-table": createTableCommand,
+	"insert":       insertCommand,
+	"import":       importCommand,
+	"scan":         scanCommand,
+	"export":       exportCommand,
+	"compact":      compactCommand,
+	"log":          logCommand,
+	"changes":      changesCommand,
+	"manifest":     manifestCommand,
+	"branch":       branchCommand,
+	"tag":          tagCommand,
+	"sql":          sqlCommand,
+	"script":       scriptCommand,
+	"serve":        serveCommand,
+	"doctor":       doctorCommand,
+}
+
+// What commands log to, everything as text to stderr with --debug.
+var cliLogger = discardLogger
+
+// Runs the command line args (without the program name) and
+// returns the process exit code, see cmd/otf.
+func Main(args []string) int {
+	cliLogger = discardLogger
+	if slices.Contains(args, "--debug") {
+		cliLogger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+	args = slices.DeleteFunc(slices.Clone(args), func(arg string) bool {
+		return arg == "--debug"
+	})
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, USAGE)
+		return 2
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n%s", args[0], USAGE)
+		return 2
+	}
+
+	err := command(args[1:], os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "otf:", err)
+		return 1
+	}
+
+	return 0
+}
+
+func calibrateCommand(args []string, w io.Writer) error {
+	rows := 100_000
+	if len(args) > 1 {
+		return fmt.Errorf("usage: otf calibrate [rows]")
+	}
+	if len(args) == 1 {
+		var err error
+		rows, err = strconv.Atoi(args[0])
+		if err != nil || rows <= 0 {
+			return fmt.Errorf("invalid number of rows: %s", args[0])
+		}
+	}
+
+	results, err := calibrate(rows, max(rows/10, 1), 1)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(w, calibrationReport(results))
+	return nil
+}
+
+func publishCommand(args []string, w io.Writer) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: otf publish <source> <destination>")
+	}
+
+	src, err := openObjectStorage(args[0])
+	if err != nil {
+		return err
+	}
+
+	dst, err := openObjectStorage(args[1])
+	if err != nil {
+		return err
+	}
+
+	c := newClient(src)
+	manifest, err := c.publish(dst)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "published snapshot %d (%d tables, %d objects)\n", manifest.Snapshot, len(manifest.Tables), len(manifest.Objects))
+	return nil
+}
+
+func tailCommand(args []string, w io.Writer) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: otf tail <source> <table>")
+	}
+
+	src, err := openObjectStorage(args[0])
+	if err != nil {
+		return err
+	}
+
+	c := newClient(src)
+	// Runs until interrupted.
+	return c.tailTable(args[1], w, WATCH_INTERVAL, nil)
+}
+
+// Flags shared by commands working on a table in --storage.
+func tableFlags(name string) (*flag.FlagSet, *string, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	storage := fs.String("storage", os.Getenv("OTF_STORAGE"), "")
+	table := fs.String("table", "", "")
+	return fs, storage, table
+}
+
+func openClient(rawURL string) (*client, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("no storage: pass --storage or set OTF_STORAGE")
+	}
+
+	storage, err := openObjectStorage(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// So a new store can be started in a new directory, and so
+	// requests are logged with --debug.
+	inner := storage
+	if eos, ok := storage.(*encryptedObjectStorage); ok {
+		inner = eos.os
+	}
+	switch inner := inner.(type) {
+	case *fileObjectStorage:
+		err = os.MkdirAll(inner.basedir, 0755)
+		if err != nil {
+			return nil, err
+		}
+	case *s3ObjectStorage:
+		inner.cfg.Logger = cliLogger
+	case *gcsObjectStorage:
+		inner.cfg.Logger = cliLogger
+	}
+
+	// Commands work on $OTF_BRANCH, main if unset, see branch.go.
+	c := newClient(storage, withLogger(cliLogger), withBranch(os.Getenv("OTF_BRANCH")))
+	return &c, nil
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(s, ",")
+}
+
+func createTableCommand(args []string, w io.Writer) error {
+	usage := fmt.Errorf("usage: otf create-table --storage <url> --table <table> [--partition-by <columns>] [--skip-verify] <column[:type]>...")
+	fs, storage, table := tableFlags("create-table")
+	partitionBy := fs.String("partition-by", "", "")
+	skipVerify := fs.Bool("skip-verify", false, "")
+	if fs.Parse(args) != nil || *table == "" || fs.NArg() == 0 {
+		return usage
+	}
+
+	var columns []string
+	types := map[string]string{}
+	for _, arg := range fs.Args() {
+		column, typ, _ := strings.Cut(arg, ":")
+		columns = append(columns, column)
+		if typ != "" {
+			types[column] = typ
+		}
+	}
+
+	c, err := openClient(*storage)
+	if err != nil {
+		return err
+	}
+	c.verifyStorage = !*skipVerify
+
+	err = c.newTx()
+	if err != nil {
+		return err
+	}
+
+	opts := []tableOption{withPartitionColumns(splitList(*partitionBy)...)}
+	if len(types) > 0 {
+		opts = append(opts, withColumnTypes(types))
+	}
+	err = c.createTable(*table, columns, opts...)
+	if err != nil {
+		c.abortTx()
+		return err
+	}
+
+	id := c.tx.Id
+	err = c.commitTx()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "created %s in transaction %d\n", *table, id)
+	return nil
+}
+
+// JSON numbers as ints where they're whole, otherwise floats.
+func jsonValue(v any) any {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+
+	if i, err := n.Int64(); err == nil {
+		return int(i)
+	}
+
+	f, _ := n.Float64()
+	return f
+}
+
+// Rows from a JSON array whose elements are either arrays of values
+// in column order or objects mapping column names to values.
+// Columns missing from an object are null.
+func decodeJSONRows(r io.Reader, columns []string) ([][]any, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	var raw []json.RawMessage
+	err := dec.Decode(&raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]any
+	for i, element := range raw {
+		row, err := decodeJSONRow(element, columns)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i, err)
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// A row from either an array of values in column order or an object
+// mapping column names to values, see decodeJSONRows.
+func decodeJSONRow(element []byte, columns []string) ([]any, error) {
+	dec := json.NewDecoder(bytes.NewReader(element))
+	dec.UseNumber()
+
+	var row []any
+	if bytes.HasPrefix(bytes.TrimSpace(element), []byte("{")) {
+		var object map[string]any
+		err := dec.Decode(&object)
+		if err != nil {
+			return nil, err
+		}
+
+		// Columns left out get their defaults, see defaults.go.
+		row = make([]any, len(columns))
+		for i := range row {
+			row[i] = useDefault
+		}
+		for name, v := range object {
+			j := slices.Index(columns, name)
+			if j == -1 {
+				return nil, fmt.Errorf("%w: %s", errNoColumn, name)
+			}
+			row[j] = v
+		}
+	} else {
+		err := dec.Decode(&row)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for j := range row {
+		row[j] = jsonValue(row[j])
+	}
+	return row, nil
+}
+
+func insertCommand(args []string, w io.Writer) error {
+	usage := fmt.Errorf("usage: otf insert --storage <url> --table <table> --json <file>")
+	fs, storage, table := tableFlags("insert")
+	file := fs.String("json", "", "")
+	if fs.Parse(args) != nil || *table == "" || *file == "" || fs.NArg() != 0 {
+		return usage
+	}
+
+	var r io.Reader = os.Stdin
+	if *file != "-" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	c, err := openClient(*storage)
+	if err != nil {
+		return err
+	}
+
+	err = c.newTx()
+	if err != nil {
+		return err
+	}
+
+	columns, ok := c.tx.tables[*table]
+	if !ok {
+		c.abortTx()
+		return fmt.Errorf("%w: %s", errNoTable, *table)
+	}
+
+	rows, err := decodeJSONRows(r, columns)
+	if err == nil {
+		for _, row := range rows {
+			err = c.writeRow(*table, row)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		c.abortTx()
+		return err
+	}
+
+	id := c.tx.Id
+	err = c.commitTx()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "inserted %d rows into %s in transaction %d\n", len(rows), *table, id)
+	return nil
+}
+
+func importCommand(args []string, w io.Writer) error {
+	usage := fmt.Errorf("usage: otf import --storage <url> --table <table> --format <csv|jsonl> <file>")
+	fs, storage, table := tableFlags("import")
+	format := fs.String("format", "", "")
+	if fs.Parse(args) != nil || *table == "" || *format == "" || fs.NArg() != 1 {
+		return usage
+	}
+
+	var r io.Reader = os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	c, err := openClient(*storage)
+	if err != nil {
+		return err
+	}
+
+	err = c.newTx()
+	if err != nil {
+		return err
+	}
+
+	n, err := c.importFrom(*table, r, *format)
+	if err != nil {
+		c.abortTx()
+		return err
+	}
+
+	id := c.tx.Id
+	err = c.commitTx()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "imported %d rows into %s in transaction %d\n", n, *table, id)
+	return nil
+}
+
+func scanCommand(args []string, w io.Writer) error {
+	usage := fmt.Errorf("usage: otf scan --storage <url> --table <table> [--columns <columns>] [--metadata <columns>] [--tx <id>]")
+	fs, storage, table := tableFlags("scan")
+	columns := fs.String("columns", "", "")
+	metadata := fs.String("metadata", "", "")
+	txId := fs.Int("tx", -1, "")
+	if fs.Parse(args) != nil || *table == "" || fs.NArg() != 0 {
+		return usage
+	}
+
+	c, err := openClient(*storage)
+	if err != nil {
+		return err
+	}
+
+	if *txId >= 0 {
+		err = c.newTxAt(*txId)
+	} else {
+		err = c.newReadTx()
+	}
+	if err != nil {
+		return err
+	}
+	defer c.abortTx()
+
+	var opts []scanOption
+	if *columns != "" {
+		opts = append(opts, withColumns(splitList(*columns)...))
+	}
+	if *metadata != "" {
+		opts = append(opts, withMetadataColumns(splitList(*metadata)...))
+	}
+	it, err := c.scan(*table, opts...)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	for {
+		row, err := it.next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			return nil
+		}
+
+		err = enc.Encode(row)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func exportCommand(args []string, w io.Writer) error {
+	usage := fmt.Errorf("usage: otf export --storage <url> --table <table> --format <csv|jsonl|parquet> [--columns <columns>] [--tx <id>]")
+	fs, storage, table := tableFlags("export")
+	format := fs.String("format", "", "")
+	columns := fs.String("columns", "", "")
+	txId := fs.Int("tx", -1, "")
+	if fs.Parse(args) != nil || *table == "" || *format == "" || fs.NArg() != 0 {
+		return usage
+	}
+
+	c, err := openClient(*storage)
+	if err != nil {
+		return err
+	}
+
+	if *txId >= 0 {
+		err = c.newTxAt(*txId)
+	} else {
+		err = c.newReadTx()
+	}
+	if err != nil {
+		return err
+	}
+	defer c.abortTx()
+
+	var opts []scanOption
+	if *columns != "" {
+		opts = append(opts, withColumns(splitList(*columns)...))
+	}
+	_, err = c.export(*table, w, *format, opts...)
+	return err
+}
+
+// What a committed transaction did to each table, e.g. "x: 1
+// metadata, 2 added".
+func summarizeActions(actions map[string][]Action) string {
+	var tables []string
+	for table := range actions {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+
+	var summaries []string
+	for _, table := range tables {
+		counts := map[string]int{}
+		for _, action := range actions[table] {
+			switch {
+			case action.ChangeMetadata != nil:
+				counts["metadata"]++
+			case action.AddDataobject != nil:
+				counts["added"]++
+			case action.DeleteRows != nil:
+				counts["deleted from"]++
+			case action.RemoveDataobject != nil:
+				counts["removed"]++
+			case action.Purge != nil:
+				counts["purged"]++
+			case action.Lineage != nil:
+				counts["lineage"]++
+			}
+		}
+
+		var parts []string
+		for _, kind := range []string{"metadata", "added", "deleted from", "removed", "purged", "lineage"} {
+			if counts[kind] > 0 {
+				parts = append(parts, fmt.Sprintf("%d %s", counts[kind], kind))
+			}
+		}
+		summaries = append(summaries, fmt.Sprintf("%s: %s", table, strings.Join(parts, ", ")))
+	}
+
+	return strings.Join(summaries, "; ")
+}
+
+func changesCommand(args []string, w io.Writer) error {
+	usage := fmt.Errorf("usage: otf changes --storage <url> [--since <id>] [--follow]")
+	fs, storage, _ := tableFlags("changes")
+	since := fs.Int("since", -1, "")
+	follow := fs.Bool("follow", false, "")
+	if fs.Parse(args) != nil || fs.NArg() != 0 {
+		return usage
+	}
+
+	c, err := openClient(*storage)
+	if err != nil {
+		return err
+	}
+
+	var it *changeIterator
+	if *follow {
+		// Runs until interrupted.
+		it, err = c.tailChanges(*since, WATCH_INTERVAL, nil)
+	} else {
+		it, err = c.changesSince(*since)
+	}
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	for {
+		change, err := it.next()
+		if err != nil || change == nil {
+			return err
+		}
+
+		err = encoder.Encode(change)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func logCommand(args []string, w io.Writer) error {
+	usage := fmt.Errorf("usage: otf log show --storage <url>\n       otf log coalesce --storage <url> [--retention <duration>]\n       otf log expire --storage <url> [--keep <n>] [--retention <duration>]")
+	if len(args) > 0 && args[0] == "coalesce" {
+		return logCoalesceCommand(args[1:], w, usage)
+	}
+	if len(args) > 0 && args[0] == "expire" {
+		return logExpireCommand(args[1:], w, usage)
+	}
+	if len(args) == 0 || args[0] != "show" {
+		return usage
+	}
+
+	fs, storage, _ := tableFlags("log show")
+	if fs.Parse(args[1:]) != nil || fs.NArg() != 0 {
+		return usage
+	}
+
+	c, err := openClient(*storage)
+	if err != nil {
+		return err
+	}
+
+	names, err := c.listLog()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		tx, err := c.readLogEntry(name)
+		if err != nil {
+			return err
+		}
+
+		committed := "-"
+		if tx.CommitInfo != nil {
+			committed = tx.CommitInfo.Timestamp.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d  %s  %s\n", tx.Id, committed, summarizeActions(tx.Actions))
+		if restriction := tx.restriction(); restriction != "" {
+			fmt.Fprintf(w, "   read-only: %s\n", restriction)
+		}
+	}
+
+	return nil
+}
+
+func logCoalesceCommand(args []string, w io.Writer, usage error) error {
+	fs, storage, _ := tableFlags("log coalesce")
+	retention := fs.Duration("retention", 24*time.Hour, "")
+	if fs.Parse(args) != nil || fs.NArg() != 0 {
+		return usage
+	}
+
+	c, err := openClient(*storage)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.coalesceLog(*retention)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "coalesced %d log entries into %d\n", result.Replaced, len(result.Coalesced))
+	return nil
+}
+
+func logExpireCommand(args []string, w io.Writer, usage error) error {
+	fs, storage, _ := tableFlags("log expire")
+	keep := fs.Int("keep", 100, "")
+	retention := fs.Duration("retention", 24*time.Hour, "")
+	if fs.Parse(args) != nil || fs.NArg() != 0 {
+		return usage
+	}
+
+	c, err := openClient(*storage)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.expireSnapshots(*keep, *retention)
+	if err != nil {
+		return err
+	}
+
+	if result.Expired == "" {
+		fmt.Fprintln(w, "nothing to expire")
+		return nil
+	}
+	fmt.Fprintf(w, "expired through transaction %d, deleted %d objects, %d dataobjects left for vacuum\n", result.Through, len(result.Deleted), len(result.Unreferenced))
+	return nil
+}
+
+func sqlCommand(args []string, w io.Writer) error {
+	usage := fmt.Errorf("usage: otf sql --storage <url> <statement> [parameter]...")
+	fs, storage, _ := tableFlags("sql")
+	if fs.Parse(args) != nil || fs.NArg() == 0 {
+		return usage
+	}
+
+	var params []any
+	for _, arg := range fs.Args()[1:] {
+		params = append(params, parseParameter(arg))
+	}
+
+	c, err := openClient(*storage)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.execSQL(fs.Arg(0), params...)
+	if err != nil {
+		return err
+	}
+
+	if result.Columns == nil {
+		fmt.Fprintf(w, "%d rows affected\n", result.RowsAffected)
+		return nil
+	}
+
+	enc := json.NewEncoder(w)
+	for _, row := range result.Rows {
+		err = enc.Encode(row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Parameters are JSON values, or if they aren't, strings.
+func parseParameter(arg string) any {
+	dec := json.NewDecoder(strings.NewReader(arg))
+	dec.UseNumber()
+	var v any
+	if dec.Decode(&v) != nil || dec.More() {
+		v = arg
+	}
+	return jsonValue(v)
+}
+
+// Repeated --var name=value flags.
+type scriptVars []scriptOption
+
+func (vs *scriptVars) String() string {
+	return ""
+}
+
+func (vs *scriptVars) Set(s string) error {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=value: %s", s)
+	}
+
+	*vs = append(*vs, withScriptVar(name, parseParameter(value)))
+	return nil
+}
+
+func scriptCommand(args []string, w io.Writer) error {
+	usage := fmt.Errorf("usage: otf script --storage <url> [--continue] [--var <name=value>]... <file>")
+	fs, storage, _ := tableFlags("script")
+	continueOnError := fs.Bool("continue", false, "")
+	var opts scriptVars
+	fs.Var(&opts, "var", "")
+	if fs.Parse(args) != nil || fs.NArg() != 1 {
+		return usage
+	}
+	if *continueOnError {
+		opts = append(opts, withContinueOnError())
+	}
+
+	var r io.Reader = os.Stdin
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	text, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	c, err := openClient(*storage)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.execScript(context.Background(), string(text), opts...)
+	enc := json.NewEncoder(w)
+	for _, s := range result.Statements {
+		switch {
+		case s.Skipped:
+			fmt.Fprintf(w, "line %d: skipped\n", s.Line)
+		case s.Error != "":
+			// Otherwise it's the error returned.
+			if *continueOnError {
+				fmt.Fprintf(w, "line %d: %s\n", s.Line, s.Error)
+			}
+		case s.Result.Columns != nil:
+			for _, row := range s.Result.Rows {
+				encErr := enc.Encode(row)
+				if encErr != nil {
+					return encErr
+				}
+			}
+		case s.Result.RowsAffected > 0:
+			fmt.Fprintf(w, "line %d: %d rows affected\n", s.Line, s.Result.RowsAffected)
+		}
+	}
+
+	return err
+}
+
+func serveCommand(args []string, w io.Writer) error {
+	usage := fmt.Errorf("usage: otf serve --storage <url> [--addr <addr>] [--token <token>] [--identities <file>] [--canary <interval>] [--metrics] [--tls-cert <file> --tls-key <file>]")
+	fs, storage, _ := tableFlags("serve")
+	addr := fs.String("addr", "localhost:8080", "")
+	token := fs.String("token", os.Getenv("OTF_SERVE_TOKEN"), "")
+	identityFile := fs.String("identities", "", "")
+	canary := fs.Duration("canary", 0, "")
+	metrics := fs.Bool("metrics", false, "")
+	cert := fs.String("tls-cert", "", "")
+	key := fs.String("tls-key", "", "")
+	if fs.Parse(args) != nil || fs.NArg() != 0 || (*cert == "") != (*key == "") || *canary < 0 {
+		return usage
+	}
+
+	c, err := openClient(*storage)
+	if err != nil {
+		return err
+	}
+
+	opts := []clientOption{withLogger(c.logger)}
+	var pm *prometheusMetrics
+	if *metrics {
+		pm = newPrometheusMetrics()
+		opts = append(opts, withMetrics(pm))
+	}
+
+	s := newServer(c.os, *token, opts...)
+	s.metrics = pm
+	if *identityFile != "" {
+		s.identities, err = readIdentityFile(*identityFile)
+		if err != nil {
+			return err
+		}
+	}
+	if *canary > 0 {
+		s.canary = newStorageCanary(c.os)
+		if pm != nil {
+			s.canary.metrics = pm
+		}
+		s.canary.logger = s.logger
+		go s.canary.run(context.Background(), *canary)
+	}
+
+	fmt.Fprintf(w, "serving %s on %s\n", *storage, *addr)
+	if *cert != "" {
+		// gRPC needs HTTP/2, which Go only serves over TLS.
+		return http.ListenAndServeTLS(*addr, *cert, *key, s)
+	}
+	return http.ListenAndServe(*addr, s)
+}
+
+func doctorCommand(args []string, w io.Writer) error {
+	usage := fmt.Errorf("usage: otf doctor --storage <url> [--probes <n>]")
+	fs, storage, _ := tableFlags("doctor")
+	probes := fs.Int("probes", 5, "")
+	if fs.Parse(args) != nil || fs.NArg() != 0 || *probes < 1 {
+		return usage
+	}
+
+	c, err := openClient(*storage)
+	if err != nil {
+		return err
+	}
+
+	var failed error
+	var put, get, list, del []time.Duration
+	for i := 0; i < *probes; i++ {
+		r := probeStorage(context.Background(), c.os)
+		if err := r.err(); err != nil {
+			fmt.Fprintf(w, "probe %d failed: %s\n", i+1, r.Error)
+			failed = err
+			continue
+		}
+		put = append(put, r.Put)
+		get = append(get, r.Get)
+		list = append(list, r.List)
+		del = append(del, r.Delete)
+	}
+
+	for _, step := range []struct {
+		name      string
+		durations []time.Duration
+	}{{"put", put}, {"get", get}, {"list", list}, {"delete", del}} {
+		if len(step.durations) == 0 {
+			continue
+		}
+		low, median, high := latencySummary(step.durations)
+		fmt.Fprintf(w, "%-6s  min %s  median %s  max %s\n", step.name, low, median, high)
+	}
+
+	sv := verifyStorage(context.Background(), c.os, STORAGE_VERIFY_CONCURRENCY, c.logger)
+	for _, check := range sv.Checks {
+		if check.Error == "" {
+			fmt.Fprintf(w, "%s: ok\n", check.Name)
+			continue
+		}
+		fmt.Fprintf(w, "%s: failed: %s\n", check.Name, check.Error)
+	}
+	if err := sv.err(); err != nil && failed == nil {
+		failed = err
+	}
+
+	if failed != nil {
+		return failed
+	}
+	fmt.Fprintln(w, "ok")
+	return nil
+}
+
+func compactCommand(args []string, w io.Writer) error {
+	usage := fmt.Errorf("usage: otf compact --storage <url> --table <table> --run <name> [--parallelism <n>]")
+	fs, storage, table := tableFlags("compact")
+	run := fs.String("run", "", "")
+	parallelism := fs.Int("parallelism", 4, "")
+	if fs.Parse(args) != nil || *table == "" || *run == "" || *parallelism < 1 || fs.NArg() != 0 {
+		return usage
+	}
+
+	c, err := openClient(*storage)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.compactParallel(context.Background(), *table, *run, *parallelism, func(r parallelCompactResult) {
+		fmt.Fprintf(w, "%d of %d jobs done\n", r.Skipped+r.Done, r.Jobs)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "compacted %d dataobjects into %d (%d jobs, %d already done)\n", result.Removed, result.Added, result.Jobs, result.Skipped)
+	return nil
+}
+
+func manifestCommand(args []string, w io.Writer) error {
+	usage := fmt.Errorf("usage: otf manifest --storage <url> --table <table> [--verify]")
+	fs, storage, table := tableFlags("manifest")
+	verify := fs.Bool("verify", false, "")
+	if fs.Parse(args) != nil || *table == "" || fs.NArg() != 0 {
+		return usage
+	}
+
+	c, err := openClient(*storage)
+	if err != nil {
+		return err
+	}
+
+	m, err := c.readManifest(*table)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return fmt.Errorf("no manifest of %s yet, one is written with each checkpoint", *table)
+	}
+
+	if *verify {
+		check, err := c.verifyManifest(m)
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(w).Encode(check)
+	}
+	return json.NewEncoder(w).Encode(m)
+}
+
+func branchCommand(args []string, w io.Writer) error {
+	usage := fmt.Errorf("usage: otf branch create --storage <url> [--at <id>] <name>\n       otf branch list --storage <url>\n       otf branch delete --storage <url> <name>\n       otf branch merge --storage <url> <name>")
+	if len(args) == 0 {
+		return usage
+	}
+
+	fs, storage, _ := tableFlags("branch " + args[0])
+	at := fs.Int("at", -1, "")
+	if fs.Parse(args[1:]) != nil {
+		return usage
+	}
+
+	c, err := openClient(*storage)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "list" && fs.NArg() == 0:
+		branches, err := c.listRefs(REF_BRANCH)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(w, MAIN_BRANCH)
+		for _, b := range branches {
+			fmt.Fprintf(w, "%s  after %d", b.Name, b.Id)
+			if b.Merged != b.Id {
+				fmt.Fprintf(w, ", merged through %d as %d", b.Merged, b.MergedAs)
+			}
+			fmt.Fprintln(w)
+		}
+		return nil
+	case fs.NArg() != 1:
+		return usage
+	case args[0] == "create":
+		b, err := c.createBranch(fs.Arg(0), *at)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "created branch %s after transaction %d\n", b.Name, b.Id)
+		return nil
+	case args[0] == "delete":
+		err = c.deleteRef(REF_BRANCH, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "deleted branch %s\n", fs.Arg(0))
+		return nil
+	case args[0] == "merge":
+		result, err := c.onBranch("").mergeBranch(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+
+		if result.Id == -1 {
+			fmt.Fprintf(w, "nothing to merge from %s\n", result.Branch)
+			return nil
+		}
+		fmt.Fprintf(w, "merged %s through transaction %d as transaction %d (%s)\n", result.Branch, result.Through, result.Id, strings.Join(result.Tables, ", "))
+		return nil
+	}
+
+	return usage
+}
+
+func tagCommand(args []string, w io.Writer) error {
+	usage := fmt.Errorf("usage: otf tag create --storage <url> [--branch <name>] [--at <id>] <name>\n       otf tag list --storage <url>\n       otf tag delete --storage <url> <name>")
+	if len(args) == 0 {
+		return usage
+	}
+
+	fs, storage, _ := tableFlags("tag " + args[0])
+	branch := fs.String("branch", MAIN_BRANCH, "")
+	at := fs.Int("at", -1, "")
+	if fs.Parse(args[1:]) != nil {
+		return usage
+	}
+
+	c, err := openClient(*storage)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case args[0] == "list" && fs.NArg() == 0:
+		tags, err := c.listRefs(REF_TAG)
+		if err != nil {
+			return err
+		}
+
+		for _, t := range tags {
+			on := t.Branch
+			if on == "" {
+				on = MAIN_BRANCH
+			}
+			fmt.Fprintf(w, "%s  %s@%d\n", t.Name, on, t.Id)
+		}
+		return nil
+	case fs.NArg() != 1:
+		return usage
+	case args[0] == "create":
+		t, err := c.createTag(fs.Arg(0), *branch, *at)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "tagged transaction %d of %s as %s\n", t.Id, *branch, t.Name)
+		return nil
+	case args[0] == "delete":
+		err = c.deleteRef(REF_TAG, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "deleted tag %s\n", fs.Arg(0))
+		return nil
+	}
+
+	return usage
+}
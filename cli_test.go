@@ -0,0 +1,139 @@
+package otf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestCLI(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-cli")
+	assertEq(err, nil, "could not make dir")
+	defer os.RemoveAll(dir)
+
+	storage := "file://" + path.Join(dir, "store")
+	run := func(args ...string) (string, error) {
+		var out bytes.Buffer
+		err := commands[args[0]](args[1:], &out)
+		return out.String(), err
+	}
+
+	_, err = run("create-table", "--storage", storage, "--table", "x", "a:int", "b")
+	assertEq(err, nil, "could not create table")
+	_, err = run("create-table", "--storage", storage, "--table", "x", "a")
+	assert(err != nil, "created x twice")
+	_, err = run("create-table", "--table", "y", "a")
+	assert(err != nil, "created table without storage")
+
+	rows := path.Join(dir, "rows.json")
+	err = os.WriteFile(rows, []byte(`[[1, "one"], {"b": "two", "a": 2}, {"a": 3}]`), 0644)
+	assertEq(err, nil, "could not write rows")
+	out, err := run("insert", "--storage", storage, "--table", "x", "--json", rows)
+	assertEq(err, nil, "could not insert")
+	assertEq(out, "inserted 3 rows into x in transaction 1\n", "insert output")
+
+	err = os.WriteFile(rows, []byte(`[{"c": 1}]`), 0644)
+	assertEq(err, nil, "could not write rows")
+	_, err = run("insert", "--storage", storage, "--table", "x", "--json", rows)
+	assert(err != nil, "inserted unknown column")
+
+	out, err = run("scan", "--storage", storage, "--table", "x")
+	assertEq(err, nil, "could not scan")
+	assertEq(out, "[1,\"one\"]\n[2,\"two\"]\n[3,null]\n", "scan output")
+
+	out, err = run("scan", "--storage", storage, "--table", "x", "--columns", "b", "--tx", "0")
+	assertEq(err, nil, "could not scan")
+	assertEq(out, "", "scan output as of creation")
+
+	out, err = run("log", "show", "--storage", storage)
+	assertEq(err, nil, "could not show log")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	assertEq(len(lines), 2, "log entries")
+	assert(strings.HasPrefix(lines[0], "0  ") && strings.HasSuffix(lines[0], "  x: 1 metadata"), "first log entry: "+lines[0])
+	assert(strings.HasPrefix(lines[1], "1  ") && strings.HasSuffix(lines[1], "  x: 1 added"), "second log entry: "+lines[1])
+
+	out, err = run("log", "expire", "--storage", storage, "--keep", "0", "--retention", "0s")
+	assertEq(err, nil, "could not expire")
+	assertEq(out, "nothing to expire\n", "expire output without a checkpoint")
+
+	out, err = run("doctor", "--storage", storage, "--probes", "3")
+	assertEq(err, nil, "could not probe storage")
+	assert(strings.HasSuffix(out, "ok\n"), "doctor output: "+out)
+
+	out, err = run("changes", "--storage", storage, "--since", "0")
+	assertEq(err, nil, "could not print changes")
+	assertEq(strings.Count(out, `"Op":"insert"`), 3, "changes output: "+out)
+
+	out, err = run("sql", "--storage", storage, "SELECT a FROM x WHERE a > $1", "1")
+	assertEq(err, nil, "could not run sql")
+	assertEq(out, "[2]\n[3]\n", "sql output")
+	out, err = run("sql", "--storage", storage, "INSERT INTO x VALUES ($1, $2)", "4", "four")
+	assertEq(err, nil, "could not run sql")
+	assertEq(out, "1 rows affected\n", "sql output")
+
+	csv := path.Join(dir, "rows.csv")
+	err = os.WriteFile(csv, []byte("b,a\nfive,5\nsix,6\n"), 0644)
+	assertEq(err, nil, "could not write rows")
+	out, err = run("import", "--storage", storage, "--table", "x", "--format", "csv", csv)
+	assertEq(err, nil, "could not import")
+	assertEq(out, "imported 2 rows into x in transaction 3\n", "import output")
+
+	out, err = run("export", "--storage", storage, "--table", "x", "--format", "csv", "--columns", "b,a", "--tx", "2")
+	assertEq(err, nil, "could not export")
+	assertEq(out, "b,a\none,1\ntwo,2\n,3\nfour,4\n", "export output")
+
+	out, err = run("compact", "--storage", storage, "--table", "x", "--run", "r", "--parallelism", "2")
+	assertEq(err, nil, "could not compact")
+	assertEq(out, "1 of 1 jobs done\ncompacted 3 dataobjects into 1 (1 jobs, 0 already done)\n", "compact output")
+	out, err = run("compact", "--storage", storage, "--table", "x", "--run", "r")
+	assertEq(err, nil, "could not compact")
+	assertEq(out, "compacted 0 dataobjects into 0 (1 jobs, 1 already done)\n", "compact output again")
+	out, err = run("scan", "--storage", storage, "--table", "x", "--columns", "a")
+	assertEq(err, nil, "could not scan")
+	assertEq(strings.Count(out, "\n"), 6, "rows after compaction: "+out)
+
+	out, err = run("branch", "create", "--storage", storage, "dev")
+	assertEq(err, nil, "could not branch")
+	assert(strings.HasPrefix(out, "created branch dev after transaction "), "branch output: "+out)
+	t.Setenv("OTF_BRANCH", "dev")
+	_, err = run("sql", "--storage", storage, "INSERT INTO x VALUES ($1, $2)", "8", "eight")
+	assertEq(err, nil, "could not insert on dev")
+	out, err = run("scan", "--storage", storage, "--table", "x", "--columns", "a")
+	assertEq(err, nil, "could not scan dev")
+	assertEq(strings.Count(out, "\n"), 7, "rows on dev: "+out)
+	t.Setenv("OTF_BRANCH", "")
+	out, err = run("scan", "--storage", storage, "--table", "x", "--columns", "a")
+	assertEq(err, nil, "could not scan")
+	assertEq(strings.Count(out, "\n"), 6, "rows on main: "+out)
+	out, err = run("branch", "merge", "--storage", storage, "dev")
+	assertEq(err, nil, "could not merge")
+	assert(strings.HasPrefix(out, "merged dev through transaction "), "merge output: "+out)
+	out, err = run("scan", "--storage", storage, "--table", "x", "--columns", "a")
+	assertEq(err, nil, "could not scan")
+	assertEq(strings.Count(out, "\n"), 7, "rows after merge: "+out)
+	out, err = run("branch", "list", "--storage", storage)
+	assertEq(err, nil, "could not list branches")
+	assert(strings.HasPrefix(out, "main\ndev  after "), "branches: "+out)
+
+	script := path.Join(dir, "runbook.sql")
+	err = os.WriteFile(script, []byte(`-- retire a row
+BEGIN;
+DELETE FROM x WHERE a = :a;
+SELECT b FROM x WHERE a = :a;
+COMMIT;
+INSERT INTO z VALUES (1);
+INSERT INTO x VALUES (9, 'nine');
+`), 0644)
+	assertEq(err, nil, "could not write script")
+	out, err = run("script", "--storage", storage, "--var", "a=8", script)
+	assert(err != nil && strings.HasPrefix(err.Error(), "line 6: "), fmt.Sprint("expected script to stop: ", err))
+	assertEq(out, "line 3: 1 rows affected\n", "script output")
+	out, err = run("script", "--storage", storage, "--var", "a=9", "--continue", script)
+	assert(errors.Is(err, errScript), "expected failed statements")
+	assert(strings.HasPrefix(out, "line 6: No Such Table"), "continued output: "+out)
+	assert(strings.HasSuffix(out, "line 7: 1 rows affected\n"), "continued output: "+out)
+}
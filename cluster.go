@@ -0,0 +1,159 @@
+package otf
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// A table can be given a sort order its rows are clustered by
+// whenever they're written to dataobjects, when flushed and when
+// compacted (see compact.go). Rows with close values end up in the
+// same dataobjects, so each dataobject's min/max statistics (see
+// stats.go) cover a narrow range and range predicates skip most
+// dataobjects.
+//
+// Sorting by several columns in turn only clusters by the first
+// well. Z-ordering instead interleaves the bits of each row's rank
+// in every column, so rows close in all of them end up together and
+// predicates on any of the columns skip dataobjects, if fewer.
+//
+// Rows are only sorted within what's written at once: a flush, or
+// the dataobjects a compaction merges. Nulls sort first, and values
+// that don't compare (see compareValues) by type.
+
+type SortOrder struct {
+	Columns []string
+	// Interleave the columns rather than sort by each in turn.
+	ZOrder bool
+}
+
+var errSortColumn = fmt.Errorf("Sort Column")
+
+// Sorts the table's rows by columns in turn whenever written.
+func withSortOrder(columns ...string) tableOption {
+	return func(o *tableOptions) {
+		o.sortOrder = &SortOrder{Columns: columns}
+	}
+}
+
+// Z-orders the table's rows by columns whenever written.
+func withZOrder(columns ...string) tableOption {
+	return func(o *tableOptions) {
+		o.sortOrder = &SortOrder{Columns: columns, ZOrder: true}
+	}
+}
+
+// Orders any two values.
+func compareSortValues(a, b any) int {
+	if a == nil || b == nil {
+		return cmp.Compare(boolRank(a != nil), boolRank(b != nil))
+	}
+
+	if c, ok := compareValues(a, b); ok {
+		return c
+	}
+
+	return cmp.Compare(fmt.Sprintf("%T", a), fmt.Sprintf("%T", b))
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// For each row of column, the rank of its value among distinct ones.
+func ranks(column []any) ([]uint64, uint64) {
+	order := make([]int, len(column))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(i, j int) int {
+		return compareSortValues(column[i], column[j])
+	})
+
+	ranks := make([]uint64, len(column))
+	rank := uint64(0)
+	for k, i := range order {
+		if k > 0 && compareSortValues(column[order[k-1]], column[i]) != 0 {
+			rank++
+		}
+		ranks[i] = rank
+	}
+
+	return ranks, rank
+}
+
+// Interleaves the bits of each row's rank in every column, scaled
+// to the bits each column gets.
+func zValues(columns [][]any, rows int) []uint64 {
+	bits := min(64/len(columns), 32)
+	scaled := make([][]uint64, len(columns))
+	for c, column := range columns {
+		r, most := ranks(column)
+		for i := range r {
+			if most > 0 {
+				// Spread ranks over the column's bits.
+				r[i] = uint64(float64(r[i]) / float64(most) * float64(uint64(1)<<bits-1))
+			}
+		}
+		scaled[c] = r
+	}
+
+	z := make([]uint64, rows)
+	for i := range z {
+		for bit := bits - 1; bit >= 0; bit-- {
+			for c := range columns {
+				z[i] = z[i]<<1 | scaled[c][i]>>bit&1
+			}
+		}
+	}
+
+	return z
+}
+
+// rows sorted by table's sort order, or rows if it has none.
+func (d *client) clusterRows(table string, rows *batch) *batch {
+	order := d.tx.sortOrders[table]
+	if order == nil || rows.Len < 2 {
+		return rows
+	}
+
+	var keys [][]any
+	for _, column := range order.Columns {
+		keys = append(keys, rows.Columns[slices.Index(d.tx.tables[table], column)])
+	}
+
+	positions := make([]int, rows.Len)
+	for i := range positions {
+		positions[i] = i
+	}
+
+	if order.ZOrder {
+		z := zValues(keys, rows.Len)
+		slices.SortStableFunc(positions, func(i, j int) int {
+			return cmp.Compare(z[i], z[j])
+		})
+	} else {
+		slices.SortStableFunc(positions, func(i, j int) int {
+			for _, key := range keys {
+				if c := compareSortValues(key[i], key[j]); c != 0 {
+					return c
+				}
+			}
+			return 0
+		})
+	}
+
+	sorted := newBatch(len(rows.Columns))
+	for c, column := range rows.Columns {
+		sorted.Columns[c] = make([]any, rows.Len)
+		for k, i := range positions {
+			sorted.Columns[c][k] = column[i]
+		}
+	}
+	sorted.Len = rows.Len
+	return sorted
+}
@@ -0,0 +1,76 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestSortOrderOnFlushAndCompaction(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b"}, withSortOrder("b", "a"))
+	assertEq(err, nil, "could not create x")
+	for _, row := range [][]any{{3, "y"}, {1, "z"}, {2, nil}, {1, "y"}, {4, 1}} {
+		err = c.writeRow("x", row)
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(fmt.Sprint(scanAll(&c, "x")), "[[2 <nil>] [4 1] [1 y] [3 y] [1 z]]", "rows not sorted")
+	err = c.writeRow("x", []any{0, "a"})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Compaction sorts the rows it merges.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	result, err := c.compact("x")
+	assertEq(err, nil, "could not compact")
+	assertEq(len(result.Added), 1, "didn't merge")
+	assertEq(fmt.Sprint(scanAll(&c, "x")), "[[2 <nil>] [4 1] [0 a] [1 y] [3 y] [1 z]]", "rows not sorted")
+	err = c.dropColumn("x", "b")
+	assert(errors.Is(err, errSortColumn), "dropped a sort column")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+}
+
+func TestZOrderClustersEveryColumn(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b"}, withZOrder("a", "b"))
+	assertEq(err, nil, "could not create x")
+
+	var rows [][]any
+	for a := 0; a < 8; a++ {
+		for b := 0; b < 8; b++ {
+			rows = append(rows, []any{a, b})
+		}
+	}
+	rand.Shuffle(len(rows), func(i, j int) { rows[i], rows[j] = rows[j], rows[i] })
+	for _, row := range rows {
+		err = c.writeRow("x", row)
+		assertEq(err, nil, "could not write row")
+	}
+
+	// Each quarter of the rows is one quadrant, so both a and b
+	// span half their range in it.
+	sorted := c.clusterRows("x", c.tx.unflushedData["x"])
+	for q := 0; q < 4; q++ {
+		quarter := sorted.slice(q*16, (q+1)*16)
+		stats := batchStats(c.tx.tables["x"], quarter)
+		for _, column := range []string{"a", "b"} {
+			span := stats[column].Max.(int) - stats[column].Min.(int)
+			assertEq(span, 3, fmt.Sprintf("quarter %d spans %d of %s", q, span, column))
+		}
+	}
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+}
@@ -0,0 +1,13 @@
+// Command otf is a thin wrapper around the otf package, see its
+// USAGE for the commands.
+package main
+
+import (
+	"os"
+
+	"github.com/eatonphil/otf"
+)
+
+func main() {
+	os.Exit(otf.Main(os.Args[1:]))
+}
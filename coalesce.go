@@ -0,0 +1,278 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Workloads committing many tiny transactions, e.g. a row at a time,
+// grow the log by an entry each, and everything reading it (starting
+// transactions, time travel, changes, vacuum) slows with it.
+// Coalescing rewrites runs of consecutive tiny entries into one
+// entry with all their actions in order, as of the run's last
+// transaction, then deletes the entries it replaced.
+//
+// A coalesced entry is named _log_<first>_<last> for the
+// transactions it covers, which sorts it where its first entry was.
+// listLog leaves out entries a coalesced one covers, so a coalesce
+// that fails before deleting them all is harmless and can be run
+// again.
+//
+// Only entries through the latest checkpoint (see checkpoint.go)
+// committed more than a retention ago are coalesced. Starting a
+// transaction replays from the checkpoint so is only sped up by
+// listing fewer entries, and like vacuum (see vacuum.go) it's assumed
+// no transaction stays open longer than the retention. Versions
+// inside a run can no longer be opened or have changes read after
+// them, only the run's last. Only one coalesce should run at a time.
+
+const (
+	// Entries with at most this many actions are tiny.
+	COALESCE_TINY_ACTIONS = 8
+	// A coalesced entry holds at most this many actions.
+	COALESCE_MAX_ACTIONS = 1000
+)
+
+var errCoalescedLog = fmt.Errorf("Coalesced Log Entry")
+
+type coalesceResult struct {
+	// Names of the coalesced entries written.
+	Coalesced []string
+	// How many entries they replaced.
+	Replaced int
+}
+
+func coalescedLogEntryName(first, last int) string {
+	return fmt.Sprintf("_log_%020d_%020d", first, last)
+}
+
+// The first and last transactions of the coalesced entry named name.
+func parseCoalescedName(name string) (int, int, bool) {
+	digits, ok := strings.CutPrefix(name, "_log_")
+	firstDigits, lastDigits, found := strings.Cut(digits, "_")
+	if !ok || !found || len(firstDigits) != 20 || len(lastDigits) != 20 {
+		return 0, 0, false
+	}
+
+	first, err := strconv.Atoi(firstDigits)
+	if err != nil {
+		return 0, 0, false
+	}
+	last, err := strconv.Atoi(lastDigits)
+	return first, last, err == nil
+}
+
+// Fails if the log entry named name coalesced the transaction after,
+// so changes after it can't be told apart from ones before.
+func checkNotCoalesced(name string, after int) error {
+	first, last, ok := parseCoalescedName(name)
+	if ok && first <= after && after < last {
+		return fmt.Errorf("%w: transaction %d is inside %s", errCoalescedLog, after, name)
+	}
+
+	return nil
+}
+
+// Leaves out of names, sorted log entry names, entries a coalesced
+// entry among them covers, including narrower coalesced entries
+// (see expire.go).
+func dropCoalesced(names []string) ([]string, error) {
+	type run struct {
+		name        string
+		first, last int
+	}
+	var runs []run
+	for _, name := range names {
+		first, last, ok := parseCoalescedName(name)
+		if !ok {
+			continue
+		}
+
+		if len(runs) > 0 && first <= runs[len(runs)-1].last {
+			previous := runs[len(runs)-1]
+			switch {
+			case last <= previous.last:
+				// Covered by previous.
+				continue
+			case first == previous.first:
+				// Covers previous.
+				runs = runs[:len(runs)-1]
+			default:
+				return nil, fmt.Errorf("%w: %s overlaps %s", errCoalescedLog, name, previous.name)
+			}
+		}
+		runs = append(runs, run{name, first, last})
+	}
+	if runs == nil {
+		return names, nil
+	}
+
+	kept := names[:0]
+	for _, name := range names {
+		id := logEntryId(name)
+		covered := false
+		for _, r := range runs {
+			if r.name != name && r.first <= id && id <= r.last {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			kept = append(kept, name)
+		}
+	}
+
+	return kept, nil
+}
+
+// One entry equivalent to run's entries, in order.
+func coalesceEntries(run []*logEntry) *logEntry {
+	last := run[len(run)-1]
+	coalesced := &logEntry{
+		Version:       LOG_ENTRY_VERSION,
+		Id:            last.Id,
+		CommitInfo:    last.CommitInfo,
+		Actions:       map[string][]Action{},
+		TableVersions: map[string]int{},
+	}
+	for _, entry := range run {
+		coalesced.carryVersion(entry)
+		for table, actions := range entry.Actions {
+			coalesced.Actions[table] = append(coalesced.Actions[table], actions...)
+			coalesced.TableVersions[table] = entry.Id
+			if v, ok := entry.TableVersions[table]; ok {
+				coalesced.TableVersions[table] = v
+			}
+		}
+	}
+
+	return coalesced
+}
+
+// Coalesces runs of tiny log entries through the latest checkpoint
+// committed more than retention ago. Doesn't need a transaction.
+func (d *client) coalesceLog(retention time.Duration) (*coalesceResult, error) {
+	if d.logFormat == LOG_FORMAT_DELTA {
+		return nil, fmt.Errorf("%w: a Delta log isn't coalesced", errDeltaLog)
+	}
+	if d.branch != "" {
+		return nil, fmt.Errorf("%w: only %s's log is coalesced", errBadRequest, MAIN_BRANCH)
+	}
+
+	err := d.checkLogWritable()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &coalesceResult{}
+	names, err := d.listLog()
+	if err != nil || len(names) == 0 {
+		return result, err
+	}
+
+	// Branches need main's entries after they start to merge, see
+	// branch.go.
+	upTo := logEntryId(names[len(names)-1])
+	pinned, err := d.earliestPinned()
+	if err != nil {
+		return nil, err
+	}
+	if pinned != -1 {
+		upTo = min(upTo, pinned)
+	}
+
+	cp, err := d.latestCheckpoint(upTo)
+	if err != nil || cp == nil {
+		return result, err
+	}
+
+	cutoff := time.Now().Add(-retention)
+	var run []*logEntry
+	var runNames []string
+	runActions := 0
+	flush := func() error {
+		defer func() {
+			run, runNames, runActions = nil, nil, 0
+		}()
+		if len(run) < 2 {
+			return nil
+		}
+
+		bytes, err := encodeLogEntry(coalesceEntries(run))
+		if err != nil {
+			return err
+		}
+
+		name := coalescedLogEntryName(run[0].Id, run[len(run)-1].Id)
+		err = d.os.putIfAbsent(d.context(), name, bytes)
+		if err != nil && !errors.Is(err, fs.ErrExist) {
+			return err
+		}
+
+		for _, replaced := range runNames {
+			err = d.os.delete(d.context(), replaced)
+			if err != nil {
+				return err
+			}
+		}
+
+		d.debug("coalesce", "coalesced log entries", "entries", len(runNames), "into", name)
+		result.Coalesced = append(result.Coalesced, name)
+		result.Replaced += len(runNames)
+		return nil
+	}
+
+	for _, name := range names {
+		if logEntryId(name) > cp.Id {
+			break
+		}
+
+		// Coalesced entries end runs rather than growing.
+		if _, _, ok := parseCoalescedName(name); ok {
+			err = flush()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		entry, err := d.readLogEntry(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry.CommitInfo != nil && entry.CommitInfo.time().After(cutoff) {
+			break
+		}
+
+		actions := 0
+		for _, tableActions := range entry.Actions {
+			actions += len(tableActions)
+		}
+
+		if actions > COALESCE_TINY_ACTIONS || runActions+actions > COALESCE_MAX_ACTIONS {
+			err = flush()
+			if err != nil {
+				return nil, err
+			}
+		}
+		if actions > COALESCE_TINY_ACTIONS {
+			continue
+		}
+
+		run = append(run, entry)
+		runNames = append(runNames, name)
+		runActions += actions
+	}
+
+	err = flush()
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,92 @@
+package otf
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCoalesceLog(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos, withCheckpointInterval(10))
+	for i := 0; i < 22; i++ {
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+		if i == 0 {
+			err = c.createTable("x", []string{"a"})
+			assertEq(err, nil, "could not create x")
+		}
+		if i == 4 {
+			err = c.createTable("y", []string{"a"})
+			assertEq(err, nil, "could not create y")
+		}
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+
+	// Nothing is old enough.
+	result, err := c.coalesceLog(time.Hour)
+	assertEq(err, nil, "could not coalesce")
+	assertEq(result.Replaced, 0, "coalesced recent entries")
+
+	replaced, err := mos.read(context.Background(), logEntryName(3))
+	assertEq(err, nil, "could not read entry")
+	result, err = c.coalesceLog(0)
+	assertEq(err, nil, "could not coalesce")
+	assertEq(result.Replaced, 20, "entries coalesced")
+	assertEq(len(result.Coalesced), 1, "coalesced entries")
+	names, err := c.listLog()
+	assertEq(err, nil, "could not list log")
+	assertEq(len(names), 3, "log entries")
+	assertEq(names[0], coalescedLogEntryName(0, 19), "coalesced entry")
+
+	// Entries left behind by a coalesce that failed are ignored.
+	err = mos.putIfAbsent(context.Background(), logEntryName(3), replaced)
+	assertEq(err, nil, "could not restore entry")
+	names, err = c.listLog()
+	assertEq(err, nil, "could not list log")
+	assertEq(len(names), 3, "log entries")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(c.tx.Id, 22, "tx id")
+	assertEq(len(scanAll(&c, "x")), 22, "rows")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Versions before the checkpoint replay the coalesced entry.
+	err = c.newTxAt(19)
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(scanAll(&c, "x")), 20, "rows as of tx 19")
+	assertEq(c.tx.tableVersions["x"], 19, "version of x")
+	assertEq(c.tx.tableVersions["y"], 4, "version of y")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	err = c.newTxAt(5)
+	assert(errors.Is(err, errNoVersion), "opened a coalesced version")
+
+	it, err := c.changesSince(5)
+	assertEq(err, nil, "could not read changes")
+	_, err = it.next()
+	assert(errors.Is(err, errCoalescedLog), "read changes inside a coalesced entry")
+	it, err = c.changesSince(19)
+	assertEq(err, nil, "could not read changes")
+	changes := 0
+	for {
+		change, err := it.next()
+		assertEq(err, nil, "could not read change")
+		if change == nil {
+			break
+		}
+		changes++
+	}
+	assertEq(changes, 2, "changes after the coalesced entry")
+
+	// Already coalesced.
+	result, err = c.coalesceLog(0)
+	assertEq(err, nil, "could not coalesce")
+	assertEq(result.Replaced, 0, "coalesced again")
+}
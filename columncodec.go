@@ -0,0 +1,393 @@
+package otf
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"slices"
+	"time"
+)
+
+// A table can choose a codec per column: none for columns of data
+// that's already compressed, zstd for text, delta for integers and
+// timestamps. Dataobjects of such tables (and each of their row
+// groups, see rowgroup.go) are then stored columnar, each column
+// encoded on its own with its codec, and recorded with
+// CODEC_COLUMNAR. Columns without a codec of their own use the
+// client's (see compression.go).
+//
+// The delta codec stores the first of a column's values and then the
+// difference between each value and the one before, bit-packed as
+// narrowly as the largest difference allows. Sorted or slowly
+// changing columns, like ids and event times (see cluster.go), take
+// a few bits a row. Columns that turn out to hold anything other
+// than integers or UTC timestamps fall back to zstd.
+//
+// Values read back are what reading them back from JSON gives:
+// numbers as float64 and timestamps as RFC 3339 strings. Codecs are
+// keyed by column name, so a renamed column goes back to the
+// client's codec.
+//
+// Layout, with integers as uvarints:
+//
+//	rows, columns
+//	for each column: length of codec name, codec name, length of data
+//	for each column: data
+
+const (
+	CODEC_COLUMNAR = "columnar"
+	CODEC_DELTA    = "delta"
+)
+
+var errUnknownCodec = fmt.Errorf("Unknown Codec")
+
+// Encodes columns with codecs, by column name.
+func withColumnCodecs(codecs map[string]string) tableOption {
+	return func(o *tableOptions) {
+		o.columnCodecs = codecs
+	}
+}
+
+func checkColumnCodecs(table string, columns []string, codecs map[string]string) error {
+	for _, column := range sortedKeys(codecs) {
+		if !slices.Contains(columns, column) {
+			return fmt.Errorf("%w: %s.%s", errNoColumn, table, column)
+		}
+
+		switch codecs[column] {
+		case CODEC_NONE, CODEC_GZIP, CODEC_ZSTD, CODEC_DELTA:
+		default:
+			return fmt.Errorf("%w: %s for %s.%s", errUnknownCodec, codecs[column], table, column)
+		}
+	}
+
+	return nil
+}
+
+// The codec of each of table's columns, nil if it has no codecs of
+// its own.
+func (d *client) columnCodecs(table string) []string {
+	codecs := d.tx.columnCodecs[table]
+	if len(codecs) == 0 {
+		return nil
+	}
+
+	var byColumn []string
+	for _, column := range d.tx.tables[table] {
+		codec, ok := codecs[column]
+		if !ok {
+			codec = d.codec
+		}
+		byColumn = append(byColumn, codec)
+	}
+
+	return byColumn
+}
+
+func encodeColumnar(codecs []string, rows *batch) ([]byte, error) {
+	header := binary.AppendUvarint(nil, uint64(rows.Len))
+	header = binary.AppendUvarint(header, uint64(len(rows.Columns)))
+	var data []byte
+	for i, column := range rows.Columns {
+		codec := codecs[i]
+		var encoded []byte
+		var ok bool
+		if codec == CODEC_DELTA {
+			encoded, ok = deltaEncode(column)
+			if !ok {
+				codec = CODEC_ZSTD
+			}
+		}
+
+		if codec != CODEC_DELTA {
+			bytes, err := json.Marshal(column)
+			if err != nil {
+				return nil, err
+			}
+
+			encoded, err = compressBytes(codec, bytes)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		header = binary.AppendUvarint(header, uint64(len(codec)))
+		header = append(header, codec...)
+		header = binary.AppendUvarint(header, uint64(len(encoded)))
+		data = append(data, encoded...)
+	}
+
+	return append(header, data...), nil
+}
+
+// Reads uvarints and byte strings off the front of b.
+type columnarReader struct {
+	b   []byte
+	err error
+}
+
+func (r *columnarReader) uvarint() int {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 || v > math.MaxInt32 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+
+	r.b = r.b[n:]
+	return int(v)
+}
+
+func (r *columnarReader) bytes(n int) []byte {
+	if r.err != nil || n > len(r.b) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func decodeColumnar(b []byte) (*batch, error) {
+	rows, columns, err := columnarColumns(b)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded := newBatch(len(columns))
+	decoded.Len = rows
+	for i, decode := range columns {
+		decoded.Columns[i], err = decode()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return decoded, nil
+}
+
+// The number of rows of a columnar dataobject and a function
+// decoding each of its columns, for decoding only some of them.
+func columnarColumns(b []byte) (int, []func() ([]any, error), error) {
+	r := &columnarReader{b: b}
+	rows := r.uvarint()
+	columns := r.uvarint()
+	if r.err != nil || columns > len(b) {
+		return 0, nil, fmt.Errorf("corrupt columnar dataobject: %w", io.ErrUnexpectedEOF)
+	}
+
+	codecs := make([]string, columns)
+	lengths := make([]int, columns)
+	for i := range codecs {
+		codecs[i] = string(r.bytes(r.uvarint()))
+		lengths[i] = r.uvarint()
+	}
+
+	var decoders []func() ([]any, error)
+	for i, codec := range codecs {
+		data := r.bytes(lengths[i])
+		if r.err != nil {
+			return 0, nil, fmt.Errorf("corrupt columnar dataobject: %w", r.err)
+		}
+
+		decoders = append(decoders, func() ([]any, error) {
+			var column []any
+			var err error
+			if codec == CODEC_DELTA {
+				column, err = deltaDecode(data, rows)
+			} else {
+				var decompressed []byte
+				decompressed, err = decompressBytes(codec, data)
+				if err == nil {
+					err = json.Unmarshal(decompressed, &column)
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+			if len(column) != rows {
+				return nil, fmt.Errorf("corrupt columnar dataobject: column %d has %d rows, not %d", i, len(column), rows)
+			}
+
+			return column, nil
+		})
+	}
+
+	return rows, decoders, nil
+}
+
+const (
+	deltaInts       = 0
+	deltaTimestamps = 1
+)
+
+// v as an integer the delta codec stores losslessly, if it is one.
+func deltaInt(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), n >= -1<<53 && n <= 1<<53
+	case int64:
+		return n, n >= -1<<53 && n <= 1<<53
+	case float64:
+		return int64(n), n == math.Trunc(n) && math.Abs(n) <= 1<<53
+	}
+
+	return 0, false
+}
+
+// v as nanoseconds since the epoch, if it's a timestamp JSON gives
+// back unchanged as UTC.
+func deltaTimestamp(v any) (int64, bool) {
+	var t time.Time
+	var text string
+	switch ts := v.(type) {
+	case time.Time:
+		t, text = ts, ts.Format(time.RFC3339Nano)
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return 0, false
+		}
+		t, text = parsed, ts
+	default:
+		return 0, false
+	}
+
+	if t.Year() < 1678 || t.Year() > 2261 || t.UTC().Format(time.RFC3339Nano) != text {
+		// Outside what nanoseconds since the epoch hold, or not
+		// UTC.
+		return 0, false
+	}
+
+	return t.UnixNano(), true
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+// Encodes column with the delta codec, if every value is null or
+// every one an integer, or every one a timestamp.
+func deltaEncode(column []any) ([]byte, bool) {
+	var values []int64
+	var nulls []byte
+	kind := -1
+	for i, v := range column {
+		if v == nil {
+			if nulls == nil {
+				nulls = make([]byte, (len(column)+7)/8)
+			}
+			nulls[i/8] |= 1 << (i % 8)
+			continue
+		}
+
+		n, ok := deltaInt(v)
+		k := deltaInts
+		if !ok {
+			n, ok = deltaTimestamp(v)
+			k = deltaTimestamps
+		}
+		if !ok || (kind != -1 && k != kind) {
+			return nil, false
+		}
+
+		kind = k
+		values = append(values, n)
+	}
+
+	b := []byte{byte(max(kind, deltaInts)), 0}
+	if nulls != nil {
+		b[1] = 1
+		b = append(b, nulls...)
+	}
+	if len(values) == 0 {
+		return b, true
+	}
+
+	b = binary.AppendVarint(b, values[0])
+	deltas := make([]uint64, len(values)-1)
+	width := 0
+	for i := 1; i < len(values); i++ {
+		// Wraps around for huge differences, and back when read.
+		deltas[i-1] = zigzag(int64(uint64(values[i]) - uint64(values[i-1])))
+		width = max(width, bits.Len64(deltas[i-1]))
+	}
+
+	b = append(b, byte(width))
+	packed := make([]byte, (len(deltas)*width+7)/8)
+	bit := 0
+	for _, delta := range deltas {
+		for j := 0; j < width; j++ {
+			if delta>>j&1 == 1 {
+				packed[bit/8] |= 1 << (bit % 8)
+			}
+			bit++
+		}
+	}
+
+	return append(b, packed...), true
+}
+
+func deltaDecode(b []byte, rows int) ([]any, error) {
+	corrupt := fmt.Errorf("corrupt delta column: %w", io.ErrUnexpectedEOF)
+	if len(b) < 2 {
+		return nil, corrupt
+	}
+
+	kind, hasNulls := b[0], b[1] == 1
+	b = b[2:]
+	isNull := func(int) bool { return false }
+	if hasNulls {
+		if len(b) < (rows+7)/8 {
+			return nil, corrupt
+		}
+		nulls := b[:(rows+7)/8]
+		b = b[(rows+7)/8:]
+		isNull = func(i int) bool { return nulls[i/8]&(1<<(i%8)) != 0 }
+	}
+
+	column := make([]any, rows)
+	var value int64
+	width, bit, seen := 0, 0, 0
+	for i := range column {
+		if isNull(i) {
+			continue
+		}
+
+		if seen == 0 {
+			first, n := binary.Varint(b)
+			if n <= 0 || n >= len(b) {
+				return nil, corrupt
+			}
+			value = first
+			width = int(b[n])
+			b = b[n+1:]
+		} else {
+			var delta uint64
+			for j := 0; j < width; j++ {
+				if bit/8 >= len(b) {
+					return nil, corrupt
+				}
+				delta |= uint64(b[bit/8]>>(bit%8)&1) << j
+				bit++
+			}
+			value = int64(uint64(value) + uint64(unzigzag(delta)))
+		}
+		seen++
+
+		if kind == deltaTimestamps {
+			column[i] = time.Unix(0, value).UTC().Format(time.RFC3339Nano)
+		} else {
+			column[i] = float64(value)
+		}
+	}
+
+	return column, nil
+}
@@ -0,0 +1,106 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestColumnCodecsReadBackAsJSON(t *testing.T) {
+	for _, rowGroupSize := range []int{ROW_GROUP_SIZE, 3} {
+		c := newClient(newMemoryObjectStorage(), withRowGroupSize(rowGroupSize), withCodec(CODEC_GZIP))
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+		columns := []string{"id", "at", "blob", "text", "mixed"}
+		codecs := map[string]string{"id": CODEC_DELTA, "at": CODEC_DELTA, "blob": CODEC_NONE, "mixed": CODEC_DELTA}
+		err = c.createTable("x", columns, withColumnCodecs(codecs), withColumnTypes(map[string]string{"at": COLUMN_TIMESTAMP}))
+		assertEq(err, nil, "could not create x")
+		err = c.createTable("y", columns, withColumnTypes(map[string]string{"at": COLUMN_TIMESTAMP}))
+		assertEq(err, nil, "could not create y")
+
+		start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		for i := 0; i < 10; i++ {
+			row := []any{i * 1000, start.Add(time.Duration(i) * time.Second), "\x00\x01", fmt.Sprint("row ", i), i}
+			if i == 4 {
+				row = []any{nil, nil, nil, nil, "not a number"}
+			}
+			if i == 7 {
+				row[0] = -1 << 40
+			}
+			for _, table := range []string{"x", "y"} {
+				err = c.writeRow(table, row)
+				assertEq(err, nil, "could not write row")
+			}
+		}
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+
+		err = c.newTx()
+		assertEq(err, nil, "could not start tx")
+		x := scanAll(&c, "x")
+		assertEq(fmt.Sprint(x), fmt.Sprint(scanAll(&c, "y")), "columnar rows differ")
+		assertEq(fmt.Sprintf("%T %v", x[3][0], x[3][0]), "float64 3000", "wrong id")
+		assertEq(c.tx.previousActions["x"][0].AddDataobject.Codec, CODEC_COLUMNAR, "not columnar")
+		assertEq(c.tx.previousActions["y"][0].AddDataobject.Codec, CODEC_GZIP, "columnar")
+
+		// Decoding the filter's columns first, see
+		// latematerialize.go.
+		filter := withFilter(where("mixed", OP_EQ, "not a number"))
+		assertEq(fmt.Sprint(scanAll(&c, "x", filter)), fmt.Sprint(scanAll(&c, "y", filter)), "filtered columnar rows differ")
+		rows, err := c.execSQL("SELECT text FROM x WHERE id = 9000")
+		assertEq(err, nil, "could not select")
+		assertEq(fmt.Sprint(rows.Rows), "[[row 9]]", "wrong rows")
+		err = c.abortTx()
+		assertEq(err, nil, "could not abort")
+	}
+}
+
+func TestDeltaCodec(t *testing.T) {
+	var sorted []any
+	for i := 0; i < 1000; i++ {
+		sorted = append(sorted, float64(1_000_000+i*3))
+	}
+	encoded, ok := deltaEncode(sorted)
+	assert(ok, "could not encode")
+	// Each difference takes 3 bits.
+	assert(len(encoded) < 400, fmt.Sprint("encoded to ", len(encoded), " bytes"))
+	decoded, err := deltaDecode(encoded, len(sorted))
+	assertEq(err, nil, "could not decode")
+	assertEq(fmt.Sprint(decoded), fmt.Sprint(sorted), "decoded differently")
+
+	for _, column := range [][]any{
+		{nil, nil},
+		{nil, float64(-5), nil, float64(1 << 53), float64(-1 << 53)},
+		{"2024-01-01T00:00:00.5Z", nil, "1999-12-31T23:59:59Z"},
+	} {
+		encoded, ok := deltaEncode(column)
+		assert(ok, fmt.Sprint("could not encode ", column))
+		decoded, err := deltaDecode(encoded, len(column))
+		assertEq(err, nil, "could not decode")
+		assertEq(fmt.Sprint(decoded), fmt.Sprint(column), "decoded differently")
+	}
+
+	for _, column := range [][]any{
+		{1, 2.5},
+		{1, "2024-01-01T00:00:00Z"},
+		{"2024-01-01T00:00:00+01:00"},
+		{float64(1 << 60)},
+	} {
+		_, ok := deltaEncode(column)
+		assert(!ok, fmt.Sprint("encoded ", column))
+	}
+
+	_, err = deltaDecode(encoded[:len(encoded)-1], len(sorted))
+	assert(err != nil, "decoded a truncated column")
+}
+
+func TestUnknownColumnCodec(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"}, withColumnCodecs(map[string]string{"a": "lz4"}))
+	assert(errors.Is(err, errUnknownCodec), "created with an unknown codec")
+	err = c.createTable("x", []string{"a"}, withColumnCodecs(map[string]string{"b": CODEC_ZSTD}))
+	assert(errors.Is(err, errNoColumn), "created with a codec for a missing column")
+}
@@ -0,0 +1,243 @@
+package otf
+
+import (
+	"fmt"
+	"time"
+)
+
+// When enabled, every commit attempt appends one row per table it
+// wrote to this table: successful commits in the same transaction,
+// conflicting ones in a best-effort follow-up transaction.
+const STATS_TABLE = "_stats"
+
+var statsColumns = []string{"table", "time", "committed", "rows", "dataobjects", "small_dataobjects"}
+
+// Dataobjects with fewer rows than this are worth compacting.
+const SMALL_DATAOBJECT_ROWS = DATAOBJECT_SIZE / 8
+
+func withCommitStats() clientOption {
+	return func(c *client) {
+		c.collectStats = true
+	}
+}
+
+func (d *client) commitStatsRows(committed bool) [][]any {
+	now := time.Now().UnixMilli()
+
+	var rows [][]any
+	for table := range d.tx.tables {
+		if table == STATS_TABLE {
+			continue
+		}
+
+		dataobjects := 0
+		for _, action := range d.tx.Actions[table] {
+			if action.AddDataobject != nil {
+				dataobjects++
+			}
+		}
+		if d.tx.unflushedLen(table) > 0 {
+			dataobjects++
+		}
+
+		if dataobjects == 0 && len(d.tx.Actions[table]) == 0 {
+			// Not touched by this transaction.
+			continue
+		}
+
+		small := 0
+		allActions := append(d.tx.previousActions[table], d.tx.Actions[table]...)
+		for _, action := range allActions {
+			if action.AddDataobject != nil && action.AddDataobject.Rows < SMALL_DATAOBJECT_ROWS {
+				small++
+			}
+		}
+		if pointer := d.tx.unflushedLen(table); pointer > 0 && pointer < SMALL_DATAOBJECT_ROWS {
+			small++
+		}
+
+		rows = append(rows, []any{table, now, committed, d.tx.rowsWritten[table], dataobjects, small})
+	}
+
+	return rows
+}
+
+func (d *client) writeCommitStats(committed bool) error {
+	rows := d.commitStatsRows(committed)
+	if len(rows) == 0 {
+		return nil
+	}
+
+	if _, ok := d.tx.tables[STATS_TABLE]; !ok {
+		err := d.createTable(STATS_TABLE, statsColumns)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, row := range rows {
+		err := d.writeRow(STATS_TABLE, row)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Called with the commitStatsRows of a transaction that lost the
+// race for its log id. Failing to record the conflict must not hide
+// the conflict itself, so errors here are only logged.
+func (d *client) recordConflictStats(rows [][]any) {
+	// Don't record conflicts of the stats transaction itself.
+	recorder := newClient(d.os)
+	err := recorder.newTx()
+	if err == nil {
+		if _, ok := recorder.tx.tables[STATS_TABLE]; !ok {
+			err = recorder.createTable(STATS_TABLE, statsColumns)
+		}
+	}
+
+	for _, row := range rows {
+		if err != nil {
+			break
+		}
+		err = recorder.writeRow(STATS_TABLE, row)
+	}
+
+	if err == nil {
+		err = recorder.commitTx()
+	}
+
+	if err != nil {
+		d.warn("stats", "could not record conflict", "err", err)
+	}
+}
+
+type tableStats struct {
+	Table            string
+	Attempts         int
+	Conflicts        int
+	AvgCommitRows    float64
+	Dataobjects      int
+	SmallDataobjects int
+}
+
+func (ts tableStats) conflictRate() float64 {
+	if ts.Attempts == 0 {
+		return 0
+	}
+
+	return float64(ts.Conflicts) / float64(ts.Attempts)
+}
+
+// Aggregates the recorded commit statistics for table, considering
+// only attempts at or after since.
+func (d *client) tableStats(table string, since time.Time) (tableStats, error) {
+	ts := tableStats{Table: table}
+	if d.tx == nil {
+		return ts, errNoTx
+	}
+
+	if _, ok := d.tx.tables[STATS_TABLE]; !ok {
+		return ts, nil
+	}
+
+	it, err := d.scan(STATS_TABLE)
+	if err != nil {
+		return ts, err
+	}
+
+	committedRows := 0
+	latest := int64(-1)
+	for {
+		row, err := it.next()
+		if err != nil {
+			return ts, err
+		}
+
+		if row == nil {
+			break
+		}
+
+		t, _ := toInt64(row[1])
+		if row[0] != table || t < since.UnixMilli() {
+			continue
+		}
+
+		ts.Attempts++
+		if row[2] != true {
+			ts.Conflicts++
+			continue
+		}
+
+		rows, _ := toInt64(row[3])
+		committedRows += int(rows)
+
+		// Small-file counts are a point in time, so only the
+		// latest one is interesting.
+		if t >= latest {
+			latest = t
+			small, _ := toInt64(row[5])
+			ts.SmallDataobjects = int(small)
+		}
+	}
+
+	if committed := ts.Attempts - ts.Conflicts; committed > 0 {
+		ts.AvgCommitRows = float64(committedRows) / float64(committed)
+	}
+
+	for _, action := range d.tx.previousActions[table] {
+		if action.AddDataobject != nil {
+			ts.Dataobjects++
+		}
+	}
+
+	return ts, nil
+}
+
+type advice struct {
+	Table  string
+	Kind   string
+	Reason string
+}
+
+const (
+	ADVICE_COMPACT      = "compact"
+	ADVICE_BATCH        = "larger-batches"
+	ADVICE_PARTITION    = "partition"
+	adviceMinAttempts   = 10
+	adviceConflictRate  = 0.2
+	adviceSmallFraction = 0.5
+)
+
+// Recommends maintenance or write pattern changes for table based
+// on statistics recorded since the given time.
+func (d *client) adviseTable(table string, since time.Time) ([]advice, error) {
+	ts, err := d.tableStats(table, since)
+	if err != nil {
+		return nil, err
+	}
+
+	var as []advice
+	if ts.Dataobjects > 1 && float64(ts.SmallDataobjects) > adviceSmallFraction*float64(ts.Dataobjects) {
+		as = append(as, advice{table, ADVICE_COMPACT, fmt.Sprintf(
+			"%d of %d dataobjects have fewer than %d rows", ts.SmallDataobjects, ts.Dataobjects, SMALL_DATAOBJECT_ROWS)})
+	}
+
+	if ts.Attempts < adviceMinAttempts {
+		return as, nil
+	}
+
+	if ts.AvgCommitRows < float64(SMALL_DATAOBJECT_ROWS) {
+		as = append(as, advice{table, ADVICE_BATCH, fmt.Sprintf(
+			"commits average %.1f rows", ts.AvgCommitRows)})
+	}
+
+	if rate := ts.conflictRate(); rate > adviceConflictRate {
+		as = append(as, advice{table, ADVICE_PARTITION, fmt.Sprintf(
+			"%.0f%% of %d commit attempts conflicted", rate*100, ts.Attempts)})
+	}
+
+	return as, nil
+}
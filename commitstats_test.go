@@ -0,0 +1,138 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCommitStatsAdvisor(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c1 := newClient(mos, withCommitStats())
+	c2 := newClient(mos, withCommitStats())
+	start := time.Now()
+
+	err := c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c1.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c1.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	for i := 0; i < 12; i++ {
+		err = c1.newTx()
+		assertEq(err, nil, "could not start tx")
+
+		// Every third commit races with another writer.
+		if i%3 == 0 {
+			err = c2.newTx()
+			assertEq(err, nil, "could not start c2 tx")
+			err = c2.writeRow("x", []any{i})
+			assertEq(err, nil, "could not write c2 row")
+			err = c2.commitTx()
+			assertEq(err, nil, "could not commit c2 tx")
+		}
+
+		err = c1.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+		err = c1.commitTx()
+		if i%3 == 0 {
+			assert(errors.Is(err, errConflict), "expected conflict")
+		} else {
+			assertEq(err, nil, "could not commit")
+		}
+	}
+
+	err = c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	ts, err := c1.tableStats("x", start)
+	assertEq(err, nil, "could not read stats")
+	// 1 create + 12 writes from c1 + 4 writes from c2.
+	assertEq(ts.Attempts, 17, "attempts")
+	assertEq(ts.Conflicts, 4, "conflicts")
+	assertEq(ts.Dataobjects, 12, "dataobjects")
+	assertEq(ts.SmallDataobjects, 12, "small dataobjects")
+
+	as, err := c1.adviseTable("x", start)
+	assertEq(err, nil, "could not advise")
+	var kinds []string
+	for _, a := range as {
+		kinds = append(kinds, a.Kind)
+	}
+	assertEq(len(kinds), 3, "number of recommendations")
+	assertEq(kinds[0], ADVICE_COMPACT, "first recommendation")
+	assertEq(kinds[1], ADVICE_BATCH, "second recommendation")
+	assertEq(kinds[2], ADVICE_PARTITION, "third recommendation")
+}
+
+func TestCommitStatsDontConflict(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c1 := newClient(mos, withCommitStats())
+	c2 := newClient(mos, withCommitStats())
+
+	err := c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c1.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c1.createTable("y", []string{"a"})
+	assertEq(err, nil, "could not create y")
+	err = c1.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Writes to different tables only meet in the stats table.
+	err = c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c2.newTx()
+	assertEq(err, nil, "could not start c2 tx")
+	err = c2.writeRow("y", []any{1})
+	assertEq(err, nil, "could not write c2 row")
+	err = c2.commitTx()
+	assertEq(err, nil, "could not commit c2 tx")
+	err = c1.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c1.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	assertEq(countRows(&c1, STATS_TABLE), 4, "stats rows")
+}
+
+func TestConflictStatsCountSmallDataobjects(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c1 := newClient(mos, withCommitStats())
+	c2 := newClient(mos, withCommitStats())
+
+	err := c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c1.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c1.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c1.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c2.newTx()
+	assertEq(err, nil, "could not start c2 tx")
+	err = c2.writeRow("x", []any{2})
+	assertEq(err, nil, "could not write c2 row")
+	err = c2.commitTx()
+	assertEq(err, nil, "could not commit c2 tx")
+	err = c1.writeRow("x", []any{3})
+	assertEq(err, nil, "could not write row")
+	err = c1.commitTx()
+	assert(errors.Is(err, errConflict), "expected conflict")
+
+	// The committed dataobject c1 saw and the one it would have
+	// added.
+	err = c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	var small []any
+	for _, row := range scanAll(&c1, STATS_TABLE) {
+		if row[2] == false {
+			small = append(small, row[5])
+		}
+	}
+	assertEq(fmt.Sprint(small), "[2]", "small dataobjects of the conflict")
+}
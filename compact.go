@@ -0,0 +1,352 @@
+package otf
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Compacting a table rewrites its small dataobjects, and ones with
+// deleted rows, into as few as possible without the deleted rows.
+// Like an update (see update.go) the old dataobjects are removed in
+// the same transaction and left for vacuum.
+//
+// Column transforms registered with withColumnTransform let tables
+// age into anonymized form: once a dataobject's rows are older than
+// a transform's threshold, the next compaction rewrites them with
+// the transform applied to the column, e.g. hashing emails or
+// truncating IP addresses. Each dataobject records when its rows
+// were first written (kept across rewrites) and the transforms
+// already applied, so a transform is applied to a row only once.
+// Rows are only merged with rows that had the same transforms
+// applied. Merged dataobjects keep the oldest creation time of the
+// ones they replace, so rows are transformed early rather than
+// late. Dataobjects written before creation times were recorded are
+// treated as old.
+//
+// Transforms only change the latest version; past versions keep the
+// original rows until vacuum or a purge (see purge.go) deletes them.
+
+type columnTransform struct {
+	Column string
+	// Identifies the transform in dataobjects it was applied to,
+	// along with the column.
+	Name string
+	// Applied to rows at least this old.
+	After time.Duration
+	// Applied to every non-null value of the column.
+	Apply func(v any) any
+}
+
+// The key recorded in dataobjects the transform was applied to.
+func (t columnTransform) key() string {
+	return t.Column + ":" + t.Name
+}
+
+// Applies transform t to column of table's rows once they are older
+// than after.
+func withColumnTransform(table, column string, after time.Duration, t columnTransform) clientOption {
+	return func(c *client) {
+		if c.transforms == nil {
+			c.transforms = map[string][]columnTransform{}
+		}
+
+		t.Column = column
+		t.After = after
+		c.transforms[table] = append(c.transforms[table], t)
+	}
+}
+
+// Replaces values with the hex SHA-256 of their text.
+func hashTransform() columnTransform {
+	return columnTransform{
+		Name: "sha256",
+		Apply: func(v any) any {
+			sum := sha256.Sum256([]byte(fmt.Sprint(v)))
+			return hex.EncodeToString(sum[:])
+		},
+	}
+}
+
+// Zeroes all but the first bits of IP addresses: 24 of IPv4 and 48
+// of IPv6 addresses. Values that aren't IP addresses become null.
+func truncateIPTransform() columnTransform {
+	return columnTransform{
+		Name: "truncate-ip",
+		Apply: func(v any) any {
+			ip := net.ParseIP(fmt.Sprint(v))
+			if ip == nil {
+				return nil
+			}
+
+			if ip4 := ip.To4(); ip4 != nil {
+				return ip4.Mask(net.CIDRMask(24, 32)).String()
+			}
+			return ip.Mask(net.CIDRMask(48, 128)).String()
+		},
+	}
+}
+
+// Replaces values with null.
+func nullTransform() columnTransform {
+	return columnTransform{
+		Name: "null",
+		Apply: func(any) any {
+			return nil
+		},
+	}
+}
+
+type compactResult struct {
+	Removed []string
+	Added   []string
+	// Rows written to the added dataobjects.
+	Rows int
+	// Rows transforms were applied to.
+	Transformed int
+	// Dataobjects due a rewrite left as they were because the
+	// compaction was stopped, see bulk.go.
+	Remaining []string
+}
+
+// A live dataobject compaction rewrites.
+type compactInput struct {
+	action  *DataobjectAction
+	deleted map[int]bool
+	// Transforms to apply to it now.
+	due []columnTransform
+	// When its rows were first written, zero if unknown.
+	created time.Time
+}
+
+// Rewrites table's small dataobjects, ones with deleted rows and
+// ones with rows old enough for a transform, as of the transaction.
+func (d *client) compact(table string) (*compactResult, error) {
+	return d.compactContext(context.Background(), table)
+}
+
+// Like compact but stops reading dataobjects to rewrite once ctx is
+// done, keeping the rewrites done so far, see bulk.go.
+func (d *client) compactContext(ctx context.Context, table string) (*compactResult, error) {
+	return d.compactDataobjects(ctx, table, nil)
+}
+
+// Like compactContext but only rewrites the dataobjects named in
+// only, if not nil, see parallelcompact.go.
+func (d *client) compactDataobjects(ctx context.Context, table string, only map[string]bool) (*compactResult, error) {
+	if err := d.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := d.tx.tables[table]; !ok {
+		return nil, errNoTable
+	}
+
+	d.tx.markRead(table)
+	groups := d.compactGroups(table, only)
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	result := &compactResult{}
+	rewrite := func(key string, inputs []compactInput) error {
+		if !inputs[0].rewritten(len(inputs)) {
+			return nil
+		}
+
+		rows := newBatch(len(d.tx.tables[table]))
+		created := inputs[0].created
+		// Once cancelled, only the other adds of dataobjects
+		// already removed are still rewritten.
+		removed := map[string]bool{}
+		var done []compactInput
+		for _, in := range inputs {
+			if !removed[in.action.Name] && ctx.Err() != nil {
+				if !slices.Contains(result.Remaining, in.action.Name) {
+					result.Remaining = append(result.Remaining, in.action.Name)
+				}
+				continue
+			}
+
+			o, err := d.readDataobject(in.action)
+			if err != nil {
+				return err
+			}
+
+			kept := o.batch.without(in.deleted)
+			for _, t := range in.due {
+				column := kept.Columns[slices.Index(d.tx.tables[table], t.Column)]
+				for i, v := range column {
+					if v != nil {
+						column[i] = t.Apply(v)
+					}
+				}
+			}
+			if len(in.due) > 0 {
+				result.Transformed += kept.Len
+			}
+			rows.concat(kept)
+
+			// Unknown is oldest.
+			if in.created.IsZero() || in.created.Before(created) {
+				created = in.created
+			}
+
+			done = append(done, in)
+			if removed[in.action.Name] {
+				continue
+			}
+
+			d.tx.Actions[table] = append(d.tx.Actions[table], Action{
+				RemoveDataobject: &RemoveAction{
+					Table: table,
+					Name:  in.action.Name,
+				},
+			})
+			result.Removed = append(result.Removed, in.action.Name)
+			removed[in.action.Name] = true
+		}
+
+		if len(done) == 0 {
+			return nil
+		}
+
+		rows = d.clusterRows(table, rows)
+
+		var transforms []string
+		if key != "" {
+			transforms = strings.Split(key, ",")
+		}
+
+		for from := 0; from < rows.Len; from += DATAOBJECT_SIZE {
+			added := len(d.tx.Actions[table])
+			err := d.writeBatch(table, rows.slice(from, min(from+DATAOBJECT_SIZE, rows.Len)))
+			if err != nil {
+				return err
+			}
+
+			for _, action := range d.tx.Actions[table][added:] {
+				action.AddDataobject.Created = &created
+				if created.IsZero() {
+					action.AddDataobject.Created = nil
+				}
+				action.AddDataobject.Transforms = transforms
+				result.Added = append(result.Added, action.AddDataobject.Name)
+			}
+		}
+		result.Rows += rows.Len
+
+		return nil
+	}
+
+	err := d.replaceDataobjects(table, func() error {
+		for _, key := range keys {
+			err := rewrite(key, groups[key])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d.debug("compact", "rewrote dataobjects", "table", table, "removed", len(result.Removed), "added", len(result.Added))
+	if len(result.Remaining) > 0 {
+		return result, incomplete(ctx, len(result.Remaining), "dataobjects")
+	}
+
+	return result, nil
+}
+
+// Whether an input in a group of n needs rewriting: if it's alone
+// and has no deleted rows or transforms due, it's already as
+// compact as it gets.
+func (in compactInput) rewritten(n int) bool {
+	return n > 1 || len(in.deleted) > 0 || len(in.due) > 0
+}
+
+// The live dataobjects of table compaction rewrites, of those in
+// only if not nil, by the transforms their rows will have had
+// applied once rewritten.
+func (d *client) compactGroups(table string, only map[string]bool) map[string][]compactInput {
+	transforms := slices.Clone(d.transforms[table])
+	for i, t := range transforms {
+		if !slices.Contains(d.tx.tables[table], t.Column) {
+			// Dropped, or not added yet.
+			transforms[i].Apply = nil
+		}
+	}
+
+	now := time.Now()
+	actions := liveActions(slices.Concat(d.tx.previousActions[table], d.tx.Actions[table]))
+	deleted := deletedRows(actions)
+
+	// Inputs by the transforms their rows will have had applied
+	// once rewritten. Removing a dataobject drops every add of it
+	// (see addsByName), so if one is an input they all are, rewritten
+	// like the first since they hold the same rows.
+	type candidate struct {
+		in  compactInput
+		key string
+	}
+	var candidates []candidate
+	first := map[string]candidate{}
+	selected := map[string]bool{}
+	for _, action := range actions {
+		if action.AddDataobject == nil || (only != nil && !only[action.AddDataobject.Name]) {
+			continue
+		}
+
+		in := compactInput{action: action.AddDataobject, deleted: deleted[action.AddDataobject]}
+		if action.AddDataobject.Created != nil {
+			in.created = *action.AddDataobject.Created
+		}
+
+		applied := slices.Clone(action.AddDataobject.Transforms)
+		for _, t := range transforms {
+			if t.Apply == nil || slices.Contains(applied, t.key()) {
+				continue
+			}
+
+			if in.created.IsZero() || !in.created.After(now.Add(-t.After)) {
+				in.due = append(in.due, t)
+				applied = append(applied, t.key())
+			}
+		}
+
+		small := action.AddDataobject.Rows-len(in.deleted) < SMALL_DATAOBJECT_ROWS
+		if small || len(in.deleted) > 0 || len(in.due) > 0 {
+			selected[action.AddDataobject.Name] = true
+		}
+
+		slices.Sort(applied)
+		c := candidate{in, strings.Join(applied, ",")}
+		candidates = append(candidates, c)
+		if _, ok := first[in.action.Name]; !ok {
+			first[in.action.Name] = c
+		}
+	}
+
+	groups := map[string][]compactInput{}
+	for _, c := range candidates {
+		if !selected[c.in.action.Name] {
+			continue
+		}
+
+		like := first[c.in.action.Name]
+		c.in.due = like.in.due
+		groups[like.key] = append(groups[like.key], c.in)
+	}
+
+	return groups
+}
@@ -0,0 +1,115 @@
+package otf
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompact(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	for i := 1; i <= 6; i++ {
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+		err = c.flushRows("x")
+		assertEq(err, nil, "could not flush")
+	}
+	_, err = c.deleteRows("x", where("a", OP_EQ, 3))
+	assertEq(err, nil, "could not delete")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	result, err := c.compact("x")
+	assertEq(err, nil, "could not compact")
+	assertEq(len(result.Removed), 6, "removed")
+	assertEq(len(result.Added), 1, "added")
+	assertEq(result.Rows, 5, "rows")
+	assertEq(result.Transformed, 0, "transformed")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	var seen []any
+	for _, row := range scanAll(&c, "x") {
+		seen = append(seen, row[0])
+	}
+	slices.SortFunc(seen, func(a, b any) int { return strings.Compare(fmt.Sprint(a), fmt.Sprint(b)) })
+	assertEq(fmt.Sprint(seen), fmt.Sprint([]any{1, 2, 4, 5, 6}), "rows after compaction")
+
+	// Nothing left to do.
+	result, err = c.compact("x")
+	assertEq(err, nil, "could not compact")
+	assertEq(len(result.Removed), 0, "removed")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+}
+
+func TestCompactTransforms(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos,
+		withColumnTransform("x", "email", 0, hashTransform()),
+		withColumnTransform("x", "ip", time.Hour, truncateIPTransform()))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"email", "ip"})
+	assertEq(err, nil, "could not create x")
+	err = c.writeRow("x", []any{"a@example.com", "10.1.2.3"})
+	assertEq(err, nil, "could not write row")
+	err = c.writeRow("x", []any{nil, "10.1.2.4"})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Only the email is old enough.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	result, err := c.compact("x")
+	assertEq(err, nil, "could not compact")
+	assertEq(result.Transformed, 2, "transformed")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	hashed := hashTransform().Apply("a@example.com")
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	rows := scanAll(&c, "x")
+	assertEq(len(rows), 2, "rows")
+	assertEq(fmt.Sprint(rows[0]), fmt.Sprint([]any{hashed, "10.1.2.3"}), "hashed email")
+	assertEq(fmt.Sprint(rows[1]), fmt.Sprint([]any{nil, "10.1.2.4"}), "null email")
+
+	// Hashes aren't hashed again.
+	result, err = c.compact("x")
+	assertEq(err, nil, "could not compact")
+	assertEq(result.Transformed, 0, "transformed again")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+
+	// An hour later the IPs are truncated too.
+	c.transforms["x"][1].After = -time.Hour
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	result, err = c.compact("x")
+	assertEq(err, nil, "could not compact")
+	assertEq(result.Transformed, 2, "transformed")
+	rows = scanAll(&c, "x")
+	assertEq(fmt.Sprint(rows[0]), fmt.Sprint([]any{hashed, "10.1.2.0"}), "truncated ip")
+	assertEq(fmt.Sprint(rows[1]), fmt.Sprint([]any{nil, "10.1.2.0"}), "truncated ip")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+}
+
+func TestTruncateIPTransform(t *testing.T) {
+	truncate := truncateIPTransform().Apply
+	assertEq(truncate("192.168.7.42"), "192.168.7.0", "ipv4")
+	assertEq(truncate("2001:db8:1:2:3:4:5:6"), "2001:db8:1::", "ipv6")
+	assertEq(truncate("not an ip"), nil, "not an ip")
+}
@@ -0,0 +1,130 @@
+package otf
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Entries of a later LOG_ENTRY_VERSION than this package's can list
+// the features they use. A feature is something an entry's reader has
+// to know about to read it right, e.g. that dataobjects may be split
+// into row groups. If this package knows every feature such an entry
+// lists it can read the store, but not write to it: writers of the
+// later version may rely on more than the features of the entries
+// they wrote, so transactions that read one are read-only and fail to
+// commit with errReadOnlyVersion, which says what forced it. Entries
+// listing a feature this package doesn't know, or of a later version
+// and listing none, fail to decode with errLogVersion as before.
+//
+// Entries standing in for others, coalesced or expired (see
+// coalesce.go and expire.go), and checkpoints keep the latest version
+// and every feature of what they stand in for, so the store stays
+// read-only. Maintenance that would rewrite a later version's
+// entries or delete what they refer to fails while it is.
+//
+// `otf log show` marks the entries that make the store read-only.
+
+var errReadOnlyVersion = fmt.Errorf("Read Only Version")
+
+// The features this package reads.
+var logFeatures = []string{
+	"partitions",      // partition.go
+	"schemaEvolution", // schema.go
+	"columnTypes",     // types.go
+	"deletes",         // delete.go
+	"updates",         // update.go
+	"rowGroups",       // rowgroup.go
+	"columnFamilies",  // families.go
+	"columnCodecs",    // columncodec.go
+	"checksums",       // checksum.go
+	"bloomFilters",    // bloomindex.go
+	"dedupe",          // dedupe.go
+	"sortOrder",       // cluster.go
+	"tableLocations",  // credentials.go
+	"compliance",      // compliance.go
+	"purge",           // purge.go
+	"lineage",         // lineage.go
+	"coalesce",        // coalesce.go
+	"foreignKeys",     // foreignkey.go
+	"constraints",     // constraints.go
+	"rowPolicies",     // rowsecurity.go
+	"columnDefaults",  // defaults.go
+	"metadataColumns", // metadatacolumns.go
+}
+
+// Fails if entry, read from name, can't be read, see above.
+func checkLogVersion(name string, entry *logEntry) error {
+	if entry.Version <= LOG_ENTRY_VERSION {
+		return nil
+	}
+
+	if entry.Features == nil {
+		return fmt.Errorf("%w: %s is version %d, only up to %d is supported", errLogVersion, name, entry.Version, LOG_ENTRY_VERSION)
+	}
+
+	var unknown []string
+	for _, feature := range entry.Features {
+		if !slices.Contains(logFeatures, feature) {
+			unknown = append(unknown, feature)
+		}
+	}
+	if unknown != nil {
+		return fmt.Errorf("%w: %s is version %d and uses %s, which only later versions than %d support", errLogVersion, name, entry.Version, strings.Join(unknown, ", "), LOG_ENTRY_VERSION)
+	}
+
+	return nil
+}
+
+// Why entry, of a later version, makes transactions reading it
+// read-only, "" if it doesn't.
+func (entry *logEntry) restriction() string {
+	if entry.Version <= LOG_ENTRY_VERSION {
+		return ""
+	}
+
+	return fmt.Sprintf("transaction %d is version %d (using %s), later than %d", entry.Id, entry.Version, strings.Join(entry.Features, ", "), LOG_ENTRY_VERSION)
+}
+
+// Raises entry's version to from's, if later, along with the
+// features from uses, for entries standing in for others.
+func (entry *logEntry) carryVersion(from *logEntry) {
+	entry.Version = max(entry.Version, from.Version)
+	for _, feature := range from.Features {
+		if !slices.Contains(entry.Features, feature) {
+			entry.Features = append(entry.Features, feature)
+		}
+	}
+}
+
+// Fails if the latest version is read-only, for maintenance outside
+// a transaction that rewrites or deletes what a later version's
+// entries may rely on: checkpoints, coalescing, expiring snapshots
+// and vacuum.
+func (d *client) checkLogWritable() error {
+	tx := d.tx
+	if tx == nil {
+		ctx := d.ctx
+		err := d.newReadTxContext(d.context())
+		tx = d.tx
+		d.tx, d.ctx = nil, ctx
+		if err != nil {
+			return err
+		}
+	}
+
+	if tx.restrictions != nil {
+		return fmt.Errorf("%w: %s", errReadOnlyVersion, strings.Join(tx.restrictions, "; "))
+	}
+
+	return nil
+}
+
+// Why the transaction is read-only, nil if it isn't.
+func (d *client) restrictions() ([]string, error) {
+	if d.tx == nil {
+		return nil, errNoTx
+	}
+
+	return d.tx.restrictions, nil
+}
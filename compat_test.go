@@ -0,0 +1,108 @@
+package otf
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLaterVersionReadOnly(t *testing.T) {
+	store := newMemoryObjectStorage()
+	c := newClient(store)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// A later version's writer starts a transaction, which a
+	// commit of ours has to rebase past.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	later := `{"Version":2,"Id":1,"Features":["rowGroups","dedupe"],"Actions":{"x":[{"DeleteRows":{"Table":"x","Name":"%s","Rows":[0]}}]}}`
+	name := c.tx.previousActions["x"][0].AddDataobject.Name
+	err = store.putIfAbsent(context.Background(), logEntryName(1), []byte(fmt.Sprintf(later, name)))
+	assertEq(err, nil, "could not write entry")
+	err = c.writeRow("x", []any{2})
+	assertEq(err, nil, "could not write")
+	err = c.commitTx()
+	assert(errors.Is(err, errReadOnlyVersion), "rebased past a later version")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	restrictions, err := c.restrictions()
+	assertEq(err, nil, "could not get restrictions")
+	assertEq(fmt.Sprint(restrictions), "[transaction 1 is version 2 (using rowGroups, dedupe), later than 1]", "restrictions")
+	assertEq(len(scanAll(&c, "x")), 0, "rows after the later version's delete")
+	err = c.writeRow("x", []any{3})
+	assertEq(err, nil, "could not write")
+	err = c.commitTx()
+	assert(errors.Is(err, errReadOnlyVersion), "committed to a later version's log")
+
+	entry, err := c.readLogEntry(logEntryName(0))
+	assertEq(err, nil, "could not read entry")
+	assertEq(entry.restriction(), "", "restriction of a current entry")
+
+	unknown := `{"Version":2,"Id":2,"Features":["rowGroups","vectors"],"Actions":{}}`
+	err = store.putIfAbsent(context.Background(), logEntryName(2), []byte(unknown))
+	assertEq(err, nil, "could not write entry")
+	err = c.newTx()
+	assert(errors.Is(err, errLogVersion), "read an unknown feature")
+	assert(strings.Contains(err.Error(), "uses vectors,"), err.Error())
+}
+
+func TestLaterVersionCheckpoint(t *testing.T) {
+	store := newMemoryObjectStorage()
+	c := newClient(store)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	later := `{"Version":2,"Id":1,"Features":["dedupe"],"Actions":{}}`
+	err = store.putIfAbsent(context.Background(), logEntryName(1), []byte(later))
+	assertEq(err, nil, "could not write entry")
+
+	// Nothing may rewrite or drop the later entry.
+	_, err = c.writeCheckpoint()
+	assert(errors.Is(err, errReadOnlyVersion), "checkpointed a later version")
+	_, err = c.coalesceLog(0)
+	assert(errors.Is(err, errReadOnlyVersion), "coalesced a later version")
+	_, err = c.expireSnapshots(0, 0)
+	assert(errors.Is(err, errReadOnlyVersion), "expired a later version")
+	_, err = c.vacuum(0, true)
+	assert(errors.Is(err, errReadOnlyVersion), "vacuumed a later version")
+
+	coalesced := coalesceEntries([]*logEntry{{Version: LOG_ENTRY_VERSION, Id: 0}, {Version: 2, Id: 1, Features: []string{"dedupe"}}})
+	assertEq(coalesced.restriction(), "transaction 1 is version 2 (using dedupe), later than 1", "coalesced restriction")
+
+	// A checkpoint the later version wrote keeps the store
+	// read-only though replay skips the entry.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	cp := checkpoint{Version: 2, Features: []string{"dedupe"}, Id: 1, Actions: map[string][]Action{"x": c.tx.schemaActions("x")}, TableVersions: c.tx.tableVersions}
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+	bytes, err := json.Marshal(cp)
+	assertEq(err, nil, "could not encode checkpoint")
+	err = store.putIfAbsent(context.Background(), checkpointName(1), bytes)
+	assertEq(err, nil, "could not write checkpoint")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	restrictions, err := c.restrictions()
+	assertEq(err, nil, "could not get restrictions")
+	assertEq(len(restrictions), 1, "restrictions")
+	err = c.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write")
+	err = c.commitTx()
+	assert(errors.Is(err, errReadOnlyVersion), "committed past a later checkpoint")
+}
@@ -0,0 +1,84 @@
+package otf
+
+import (
+	"fmt"
+	"time"
+)
+
+// Tables under regulatory retention can be given a compliance
+// window: vacuum (see vacuum.go) won't delete any of the table's
+// dataobjects added less than the window ago, whatever retention it
+// is asked for, even once no retained version references them. The
+// window can be set when the table is created or later but only
+// ever increased, so nobody can shorten it to get rid of data
+// early. It protects data against this client only; storage with
+// its own object lock (e.g. S3 Object Lock in compliance mode)
+// protects it against everyone else.
+//
+// The window is recorded in the table's ChangeMetadata actions
+// and, since it can only increase, the largest one recorded
+// applies.
+
+const SCHEMA_SET_COMPLIANCE = "SetCompliance"
+
+var errComplianceWindow = fmt.Errorf("Compliance Window")
+
+func withComplianceWindow(window time.Duration) tableOption {
+	return func(o *tableOptions) {
+		o.complianceWindow = window
+	}
+}
+
+// Sets table's compliance window, which must be at least as long as
+// its current one.
+func (d *client) setComplianceWindow(table string, window time.Duration) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+
+	history, ok := d.tx.schemas[table]
+	if !ok {
+		return errNoTable
+	}
+
+	current := d.tx.complianceWindows[table]
+	if window < current {
+		return fmt.Errorf("%w: %s is %s, can't shorten it to %s", errComplianceWindow, table, current, window)
+	}
+
+	// The schema is unchanged, so recorded as the same version.
+	latest := history[len(history)-1]
+	mtd := &ChangeMetadataAction{
+		Table:            table,
+		Columns:          latest.Columns,
+		PartitionColumns: d.tx.partitions[table],
+		Op:               SCHEMA_SET_COMPLIANCE,
+		SchemaVersion:    history.version(),
+		ColumnTypes:      latest.Types,
+		ComplianceWindow: window,
+	}
+	if history.version() > 0 {
+		mtd.ColumnIds = latest.Ids
+	}
+	d.tx.complianceWindows[table] = window
+	d.tx.schemas[table] = history.record(mtd)
+	d.tx.Actions[table] = append(d.tx.Actions[table], Action{ChangeMetadata: mtd})
+
+	d.debug("compliance", "set window", "table", table, "window", window)
+	return nil
+}
+
+// Whether data added at added (or if unknown, last modified at
+// modified) is still within window. Data of unknown age is assumed
+// to be.
+func withinComplianceWindow(window time.Duration, added, modified time.Time) bool {
+	if window <= 0 {
+		return false
+	}
+
+	if added.IsZero() {
+		added = modified
+	}
+
+	return added.IsZero() || added.After(time.Now().Add(-window))
+}
@@ -0,0 +1,80 @@
+package otf
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestComplianceWindow(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"}, withComplianceWindow(time.Hour))
+	assertEq(err, nil, "could not create x")
+	err = c.createTable("y", []string{"a"})
+	assertEq(err, nil, "could not create y")
+	for _, table := range []string{"x", "y"} {
+		err = c.writeRow(table, []any{1})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Replaces the first dataobject of each.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	for _, table := range []string{"x", "y"} {
+		_, err = c.updateRows(table, where("a", OP_EQ, 1), map[string]*expr{"a": litExpr(2)})
+		assertEq(err, nil, "could not update")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Only y's is deleted.
+	result, err := c.vacuum(0, false)
+	assertEq(err, nil, "could not vacuum")
+	assertEq(len(result.Deleted), 1, "deleted")
+	assertEq(result.Deleted[0][:len(dataobjectKey("y", ""))], dataobjectKey("y", ""), "deleted")
+	assertEq(len(result.Protected), 1, "protected")
+	assertEq(result.Protected[0][:len(dataobjectKey("x", ""))], dataobjectKey("x", ""), "protected")
+	_, err = mos.read(context.Background(), result.Protected[0])
+	assertEq(err, nil, "protected dataobject was deleted")
+
+	// The window can only grow.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.setComplianceWindow("x", time.Minute)
+	assert(errors.Is(err, errComplianceWindow), "shortened compliance window")
+	err = c.setComplianceWindow("y", 2*time.Hour)
+	assertEq(err, nil, "could not set compliance window")
+	err = c.writeRow("y", []any{3})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(c.tx.complianceWindows["x"], time.Hour, "x window")
+	assertEq(c.tx.complianceWindows["y"], 2*time.Hour, "y window")
+	// Setting the window isn't a new schema version.
+	assertEq(c.tx.schemas["y"].version(), 0, "y schema version")
+	err = c.setComplianceWindow("y", time.Hour)
+	assert(errors.Is(err, errComplianceWindow), "shortened compliance window")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+	assertEq(countRows(&c, "y"), 2, "rows of y")
+
+	// Nothing left that vacuum may delete.
+	result, err = c.vacuum(0, false)
+	assertEq(err, nil, "could not vacuum")
+	assertEq(len(result.Deleted), 0, "deleted")
+	assertEq(len(result.Protected), 1, "protected")
+
+	// Once the window is over the data can go.
+	assert(!withinComplianceWindow(time.Hour, time.Now().Add(-2*time.Hour), time.Time{}), "outside window")
+	assert(withinComplianceWindow(time.Hour, time.Time{}, time.Time{}), "unknown age")
+	assert(!withinComplianceWindow(0, time.Now(), time.Time{}), "no window")
+}
@@ -0,0 +1,124 @@
+package otf
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+// Splits storage in two: dataobjects go to a (typically nearby,
+// regional) data store while everything else, in particular the
+// transaction log, goes to a strongly consistent primary. Since
+// dataobjects are immutable and uniquely named, only the primary's
+// putIfAbsent needs to be atomic for commits to stay serialized;
+// the primary is the single global commit point.
+//
+// Replicas are other copies of the data store (e.g. kept in sync
+// by bucket replication). They are never written to but dataobject
+// reads fall back to them, in order, when the data store fails.
+type compositeObjectStorage struct {
+	primary  objectStorage
+	data     objectStorage
+	replicas []objectStorage
+	// Falling back to a replica is logged here if not nil, see
+	// logging.go.
+	logger *slog.Logger
+}
+
+func newCompositeObjectStorage(primary, data objectStorage, replicas ...objectStorage) *compositeObjectStorage {
+	return &compositeObjectStorage{primary: primary, data: data, replicas: replicas}
+}
+
+const DATAOBJECT_PREFIX = "_table_"
+
+func isDataobjectKey(name string) bool {
+	return strings.HasPrefix(name, DATAOBJECT_PREFIX)
+}
+
+func (cos *compositeObjectStorage) route(name string) objectStorage {
+	if isDataobjectKey(name) {
+		return cos.data
+	}
+
+	return cos.primary
+}
+
+func (cos *compositeObjectStorage) putIfAbsent(ctx context.Context, name string, bytes []byte) error {
+	return cos.route(name).putIfAbsent(ctx, name, bytes)
+}
+
+// Replicas are left to whatever keeps them in sync.
+func (cos *compositeObjectStorage) delete(ctx context.Context, name string) error {
+	return cos.route(name).delete(ctx, name)
+}
+
+func (cos *compositeObjectStorage) stat(ctx context.Context, name string) (objectInfo, error) {
+	return statObject(ctx, cos.route(name), name)
+}
+
+func (cos *compositeObjectStorage) listPrefix(ctx context.Context, prefix string) ([]string, error) {
+	// A prefix like "_ta" could match names in either store.
+	if isDataobjectKey(prefix) {
+		return cos.data.listPrefix(ctx, prefix)
+	}
+
+	names, err := cos.primary.listPrefix(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(DATAOBJECT_PREFIX, prefix) {
+		return names, nil
+	}
+
+	dataNames, err := cos.data.listPrefix(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		if !isDataobjectKey(name) {
+			dataNames = append(dataNames, name)
+		}
+	}
+	slices.Sort(dataNames)
+	return dataNames, nil
+}
+
+func (cos *compositeObjectStorage) read(ctx context.Context, name string) ([]byte, error) {
+	return cos.readWith(name, func(os objectStorage) ([]byte, error) {
+		return os.read(ctx, name)
+	})
+}
+
+func (cos *compositeObjectStorage) readRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	return cos.readWith(name, func(os objectStorage) ([]byte, error) {
+		return readRange(ctx, os, name, offset, length)
+	})
+}
+
+// Reads name with read from whichever store it is in, falling back
+// to replicas for dataobjects.
+func (cos *compositeObjectStorage) readWith(name string, read func(objectStorage) ([]byte, error)) ([]byte, error) {
+	bytes, err := read(cos.route(name))
+	if err == nil || !isDataobjectKey(name) {
+		return bytes, err
+	}
+
+	// Dataobjects are immutable so any copy is as good as the
+	// original.
+	errs := []error{err}
+	for i, replica := range cos.replicas {
+		bytes, err = read(replica)
+		if err == nil {
+			logTo(cos.logger, slog.LevelWarn, "composite", "read from replica", "name", name, "replica", i, "err", errs[0])
+			return bytes, nil
+		}
+
+		errs = append(errs, err)
+	}
+
+	return nil, errors.Join(errs...)
+}
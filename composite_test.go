@@ -0,0 +1,99 @@
+package otf
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestCompositeObjectStorageRoutesLogToPrimary(t *testing.T) {
+	primary := newMemoryObjectStorage()
+	// Two regions sharing one primary.
+	east := newCompositeObjectStorage(primary, newMemoryObjectStorage())
+	west := newCompositeObjectStorage(primary, newMemoryObjectStorage())
+
+	cEast := newClient(east)
+	cWest := newClient(west)
+
+	err := cEast.newTx()
+	assertEq(err, nil, "could not start east tx")
+	err = cWest.newTx()
+	assertEq(err, nil, "could not start west tx")
+
+	err = cEast.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = cEast.writeRow("x", []any{"Joey"})
+	assertEq(err, nil, "could not write row")
+	err = cEast.commitTx()
+	assertEq(err, nil, "could not commit east tx")
+
+	// The commit point is global even though data is regional.
+	err = cWest.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = cWest.commitTx()
+	assert(err != nil, "concurrent commit must fail")
+
+	logs, err := primary.listPrefix(context.Background(), "_log_")
+	assertEq(err, nil, "could not list primary")
+	assertEq(len(logs), 1, "logs in primary")
+	dataobjects, err := primary.listPrefix(context.Background(), DATAOBJECT_PREFIX)
+	assertEq(err, nil, "could not list primary")
+	assertEq(len(dataobjects), 0, "dataobjects in primary")
+
+	dataobjects, err = east.data.listPrefix(context.Background(), DATAOBJECT_PREFIX)
+	assertEq(err, nil, "could not list east")
+	assertEq(len(dataobjects), 1, "dataobjects in east")
+
+	all, err := east.listPrefix(context.Background(), "")
+	assertEq(err, nil, "could not list all")
+	assertEq(len(all), 2, "all objects")
+	assertEq(all[0], logs[0], "log sorts first")
+}
+
+// Simulates an outage of a store.
+type unavailableObjectStorage struct {
+	objectStorage
+}
+
+func (unavailableObjectStorage) read(ctx context.Context, name string) ([]byte, error) {
+	return nil, fmt.Errorf("unavailable: %s", name)
+}
+
+func TestCompositeObjectStorageReadFallsBackToReplicas(t *testing.T) {
+	primary := newMemoryObjectStorage()
+	data := newMemoryObjectStorage()
+	replica := newMemoryObjectStorage()
+
+	c := newClient(newCompositeObjectStorage(primary, data))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.writeRow("x", []any{"Joey"})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Replicate the data store.
+	names, err := data.listPrefix(context.Background(), "")
+	assertEq(err, nil, "could not list data")
+	for _, name := range names {
+		bytes, err := data.read(context.Background(), name)
+		assertEq(err, nil, "could not read data")
+		err = replica.putIfAbsent(context.Background(), name, bytes)
+		assertEq(err, nil, "could not replicate")
+	}
+
+	down := unavailableObjectStorage{data}
+	c = newClient(newCompositeObjectStorage(primary, down, unavailableObjectStorage{replica}, replica))
+	assertEq(countRows(&c, "x"), 1, "rows in x")
+
+	// Without a healthy replica the read fails.
+	c = newClient(newCompositeObjectStorage(primary, down))
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	it, err := c.scan("x")
+	assertEq(err, nil, "could not scan")
+	_, err = it.next()
+	assert(err != nil, "expected read to fail")
+}
@@ -0,0 +1,80 @@
+package otf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Dataobjects are compressed with the client's codec when flushed.
+// The codec is recorded in each AddDataobject action so readers
+// don't need to be configured the same way as the writer, and a
+// table can mix codecs over time.
+const (
+	CODEC_NONE = ""
+	CODEC_GZIP = "gzip"
+	CODEC_ZSTD = "zstd"
+)
+
+func withCodec(codec string) clientOption {
+	assert(codec == CODEC_NONE || codec == CODEC_GZIP || codec == CODEC_ZSTD, fmt.Sprintf("unknown codec: %s", codec))
+	return func(c *client) {
+		c.codec = codec
+	}
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Close()
+	return buf.Bytes(), err
+}
+
+func gunzipBytes(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(r)
+}
+
+// Safe for concurrent use and expensive to create, so shared.
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+func compressBytes(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case CODEC_NONE:
+		return data, nil
+	case CODEC_GZIP:
+		return gzipBytes(data)
+	case CODEC_ZSTD:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	}
+
+	return nil, fmt.Errorf("unknown codec: %s", codec)
+}
+
+func decompressBytes(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case CODEC_NONE:
+		return data, nil
+	case CODEC_GZIP:
+		return gunzipBytes(data)
+	case CODEC_ZSTD:
+		return zstdDecoder.DecodeAll(data, nil)
+	}
+
+	return nil, fmt.Errorf("unknown codec: %s", codec)
+}
@@ -0,0 +1,46 @@
+package otf
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestMixedCodecs(t *testing.T) {
+	os := newMemoryObjectStorage()
+
+	for _, codec := range []string{CODEC_NONE, CODEC_GZIP, CODEC_ZSTD} {
+		c := newClient(os, withCodec(codec))
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+
+		if codec == CODEC_NONE {
+			err = c.createTable("x", []string{"a", "b"})
+			assertEq(err, nil, "could not create x")
+		}
+
+		for i := 0; i < DATAOBJECT_SIZE+1; i++ {
+			err = c.writeRow("x", []any{codec, i})
+			assertEq(err, nil, "could not write row")
+		}
+
+		actions := c.tx.Actions["x"]
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+
+		for _, action := range actions {
+			if action.AddDataobject == nil {
+				continue
+			}
+
+			assertEq(action.AddDataobject.Codec, codec, "codec recorded")
+			raw, err := os.read(context.Background(), dataobjectKey("x", action.AddDataobject.Name))
+			assertEq(err, nil, "could not read dataobject")
+			assertEq(bytes.HasPrefix(raw, []byte("{")), codec == CODEC_NONE, "stored compressed")
+		}
+	}
+
+	// A reader with no codec configured reads all of them.
+	c := newClient(os)
+	assertEq(countRows(&c, "x"), 3*(DATAOBJECT_SIZE+1), "rows in x")
+}
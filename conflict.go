@@ -0,0 +1,191 @@
+package otf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"time"
+)
+
+// Transactions commit by writing the log entry after the last one
+// they saw. When another transaction got there first that doesn't
+// necessarily mean the two conflict: if every transaction committed
+// since touched tables this one neither read nor wrote, committing
+// it after them is the same as if it had started after them. So
+// rather than fail, commitTx rebases onto the new end of the log and
+// tries again.
+//
+// Two transactions conflict on a table when one wrote it and the
+// other read (or, with read validation, read what it changed, see
+// readset.go) or wrote it. Writes to a partitioned table (see
+// partition.go) only conflict when they touch the same partition,
+// or when either does more than add dataobjects (deleting rows,
+// changing the schema). With read validation, rewriting dataobjects
+// only conflicts with changes to them, see readset.go. Serializable
+// transactions also conflict with rows added that their scans would
+// have returned, see serializable.go.
+//
+// Under contention a commit may lose the race more than once, so
+// it is retried up to COMMIT_RETRIES times, waiting exponentially
+// longer (with jitter) before each retry so competing writers
+// spread out.
+
+const (
+	COMMIT_RETRIES = 10
+	COMMIT_BACKOFF = 10 * time.Millisecond
+)
+
+// Retries commits that lose the race for their log entry up to n
+// times, waiting around backoff before the first retry and twice
+// as long before each one after. n of 0 fails them right away, as
+// if they conflicted.
+func withCommitRetries(n int, backoff time.Duration) clientOption {
+	return func(c *client) {
+		c.commitRetries = max(n, 0)
+		c.commitBackoff = backoff
+	}
+}
+
+// How long to wait before retry attempt (from 0): base doubled
+// each attempt, capped at a second, and randomized by up to half
+// either way.
+func commitBackoff(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+
+	wait := min(base<<min(attempt, 20), time.Second)
+	return wait/2 + time.Duration(rand.Int64N(int64(wait)))
+}
+
+// Records that the transaction read table's rows.
+func (tx *transaction) markRead(table string) {
+	if tx.reads == nil {
+		tx.reads = map[string]bool{}
+	}
+	tx.reads[table] = true
+}
+
+// The partitions actions add dataobjects to, or false if they do
+// anything else or any isn't partitioned.
+func appendedPartitions(actions []Action) (map[string]bool, bool) {
+	partitions := map[string]bool{}
+	for _, action := range actions {
+		if action.AddDataobject == nil || action.AddDataobject.Partition == nil {
+			return nil, false
+		}
+
+		// Map keys are marshalled in order.
+		key, err := json.Marshal(action.AddDataobject.Partition)
+		if err != nil {
+			return nil, false
+		}
+		partitions[string(key)] = true
+	}
+
+	return partitions, true
+}
+
+func onlyAppends(actions []Action) bool {
+	for _, action := range actions {
+		if action.AddDataobject == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The table tx conflicts with committed on, if any.
+func (tx *transaction) conflictsWith(committed *logEntry) (string, bool) {
+	for table, theirs := range committed.Actions {
+		if len(theirs) == 0 {
+			continue
+		}
+
+		if tx.readDataobjects != nil {
+			if tx.readInvalidatedBy(table, theirs) {
+				return table, true
+			}
+		} else if tx.reads[table] {
+			return table, true
+		}
+
+		ours := tx.Actions[table]
+		if len(ours) == 0 || tx.rewroteRead(table) {
+			continue
+		}
+
+		// Every commit collecting stats appends to the stats
+		// table (see commitstats.go), and stats rows don't
+		// depend on each other, so appends go in either order.
+		if table == STATS_TABLE && onlyAppends(ours) && onlyAppends(theirs) {
+			continue
+		}
+
+		ourPartitions, ok := appendedPartitions(ours)
+		if !ok {
+			return table, true
+		}
+		theirPartitions, ok := appendedPartitions(theirs)
+		if !ok {
+			return table, true
+		}
+		for partition := range ourPartitions {
+			if theirPartitions[partition] {
+				return table, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Moves the transaction past every log entry committed since it
+// started, unless it conflicts with one of them.
+func (d *client) rebase() error {
+	for {
+		name := d.logName(d.tx.Id)
+		committed, err := d.readLogEntry(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Written by a later version, see compat.go. Retrying
+		// wouldn't help, unlike after a conflict.
+		if restriction := committed.restriction(); restriction != "" {
+			return fmt.Errorf("%w: %s", errReadOnlyVersion, restriction)
+		}
+
+		if table, ok := d.tx.conflictsWith(committed); ok {
+			return fmt.Errorf("%w: %s already exists and changed %s", errConflict, name, table)
+		}
+
+		table, ok, err := d.phantomIn(committed)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return fmt.Errorf("%w: %s already exists and added rows to %s this transaction scanned for", errConflict, name, table)
+		}
+
+		if d.tx.readDataobjects != nil {
+			table, ok, err = d.duplicateKeyIn(committed)
+			if err != nil {
+				return err
+			}
+			if ok {
+				return fmt.Errorf("%w: %s already exists and added primary key values to %s this transaction added", errConflict, name, table)
+			}
+		}
+
+		d.debug("conflict", "rebasing", "past", name)
+		d.tx.Id++
+		d.tx.lastCommit = committed.CommitInfo
+	}
+}
@@ -0,0 +1,181 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDisjointTablesCommitConcurrently(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c1 := newClient(mos)
+	c2 := newClient(mos)
+	err := c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c1.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c1.createTable("y", []string{"a"})
+	assertEq(err, nil, "could not create y")
+	err = c1.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c2.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c1.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c2.writeRow("y", []any{2})
+	assertEq(err, nil, "could not write row")
+	err = c1.commitTx()
+	assertEq(err, nil, "could not commit")
+	err = c2.commitTx()
+	assertEq(err, nil, "could not commit after rebasing")
+
+	err = c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(c1.tx.Id, 3, "next tx id")
+	assertEq(len(scanAll(&c1, "x")), 1, "rows in x")
+	assertEq(len(scanAll(&c1, "y")), 1, "rows in y")
+
+	// Reading a table another transaction wrote conflicts.
+	err = c2.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(scanAll(&c2, "x")), 1, "rows in x")
+	err = c2.writeRow("y", []any{3})
+	assertEq(err, nil, "could not write row")
+	err = c1.writeRow("x", []any{4})
+	assertEq(err, nil, "could not write row")
+	err = c1.commitTx()
+	assertEq(err, nil, "could not commit")
+	err = c2.commitTx()
+	assert(errors.Is(err, errConflict), "expected conflict")
+
+	// As does writing the same table.
+	err = c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c2.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c1.writeRow("x", []any{5})
+	assertEq(err, nil, "could not write row")
+	err = c2.writeRow("x", []any{6})
+	assertEq(err, nil, "could not write row")
+	err = c1.commitTx()
+	assertEq(err, nil, "could not commit")
+	err = c2.commitTx()
+	assert(errors.Is(err, errConflict), "expected conflict")
+}
+
+func TestDisjointPartitionsCommitConcurrently(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c1 := newClient(mos)
+	c2 := newClient(mos)
+	err := c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c1.createTable("x", []string{"region", "a"}, withPartitionColumns("region"))
+	assertEq(err, nil, "could not create x")
+	err = c1.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	for _, test := range []struct {
+		regions  [2]string
+		conflict bool
+	}{
+		{[2]string{"eu", "us"}, false},
+		{[2]string{"eu", "eu"}, true},
+	} {
+		err = c1.newTx()
+		assertEq(err, nil, "could not start tx")
+		err = c2.newTx()
+		assertEq(err, nil, "could not start tx")
+		err = c1.writeRow("x", []any{test.regions[0], 1})
+		assertEq(err, nil, "could not write row")
+		err = c2.writeRow("x", []any{test.regions[1], 2})
+		assertEq(err, nil, "could not write row")
+		err = c1.commitTx()
+		assertEq(err, nil, "could not commit")
+		err = c2.commitTx()
+		assertEq(errors.Is(err, errConflict), test.conflict, "conflict")
+	}
+
+	// Deletes conflict with any write to the table.
+	err = c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c2.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c1.writeRow("x", []any{"us", 3})
+	assertEq(err, nil, "could not write row")
+	_, err = c2.deleteRows("x", where("region", OP_EQ, "eu"))
+	assertEq(err, nil, "could not delete")
+	err = c1.commitTx()
+	assertEq(err, nil, "could not commit")
+	err = c2.commitTx()
+	assert(errors.Is(err, errConflict), "expected conflict")
+}
+
+func TestCommitRetries(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	for i := 0; i < 8; i++ {
+		err = c.createTable(fmt.Sprintf("t%d", i), []string{"a"})
+		assertEq(err, nil, "could not create table")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Writers of different tables all get their commits in
+	// eventually.
+	var wg sync.WaitGroup
+	errs := make(chan error, 8*5)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(table string) {
+			defer wg.Done()
+			c := newClient(mos, withCommitRetries(100, time.Millisecond))
+			for j := 0; j < 5; j++ {
+				err := c.newTx()
+				if err == nil {
+					err = c.writeRow(table, []any{j})
+				}
+				if err == nil {
+					err = c.commitTx()
+				}
+				errs <- err
+			}
+		}(fmt.Sprintf("t%d", i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assertEq(err, nil, "could not commit")
+	}
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(c.tx.Id, 41, "next tx id")
+	for i := 0; i < 8; i++ {
+		assertEq(len(scanAll(&c, fmt.Sprintf("t%d", i))), 5, "rows")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Without retries losing the race fails the commit.
+	c1 := newClient(mos)
+	c2 := newClient(mos, withCommitRetries(0, 0))
+	err = c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c2.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c1.writeRow("t0", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c2.writeRow("t1", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c1.commitTx()
+	assertEq(err, nil, "could not commit")
+	err = c2.commitTx()
+	assert(errors.Is(err, errConflict), "expected conflict")
+}
@@ -0,0 +1,183 @@
+package otf
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Tables can declare NOT NULL columns and a primary key. writeRow
+// (and updateRows) reject a null in a NOT NULL column, or in a
+// primary key column, which is NOT NULL too, with errNotNull.
+//
+// A primary key's values must be unique across the table's rows.
+// That's checked when a transaction that added rows to the table
+// commits, against the data as the transaction sees it: the table is
+// scanned filtering on the values the transaction added, so its stats
+// and bloom filters (see bloomindex.go) skip dataobjects without them,
+// past FOREIGN_KEY_LOOKUPS distinct values it's scanned whole, and a
+// value found more than once fails the commit with errPrimaryKey.
+// Since the check reads the table, a concurrent transaction adding the
+// same value conflicts (see conflict.go) rather than slip a duplicate
+// in. Transactions validating reads (see readset.go) don't conflict
+// with appends, so when rebasing they check the dataobjects added
+// since for values they added instead.
+//
+// Constrained columns can't be dropped or renamed.
+
+var (
+	errNotNull          = fmt.Errorf("Not Null Violation")
+	errPrimaryKey       = fmt.Errorf("Primary Key Violation")
+	errConstraintColumn = fmt.Errorf("Constraint Column")
+)
+
+// Declares columns NOT NULL.
+func withNotNull(columns ...string) tableOption {
+	return func(o *tableOptions) {
+		o.notNull = append(o.notNull, columns...)
+	}
+}
+
+// Declares columns, in order, the table's primary key.
+func withPrimaryKey(columns ...string) tableOption {
+	return func(o *tableOptions) {
+		o.primaryKey = columns
+	}
+}
+
+func checkConstraintDeclarations(table string, columns, notNull, primaryKey []string) error {
+	for _, column := range slices.Concat(notNull, primaryKey) {
+		if !slices.Contains(columns, column) {
+			return fmt.Errorf("%w: %s.%s", errNoColumn, table, column)
+		}
+	}
+
+	for i, column := range primaryKey {
+		if slices.Contains(primaryKey[:i], column) {
+			return fmt.Errorf("%w: %s.%s is in the primary key twice", errConstraintColumn, table, column)
+		}
+	}
+
+	return nil
+}
+
+// Whether column of table is NOT NULL or in its primary key.
+func (tx *transaction) constraintColumn(table, column string) bool {
+	return slices.Contains(tx.notNull[table], column) || slices.Contains(tx.primaryKeys[table], column)
+}
+
+// Fails if row has a null in a NOT NULL or primary key column.
+func (tx *transaction) checkNotNull(table string, row []any) error {
+	columns := tx.tables[table]
+	for _, column := range slices.Concat(tx.notNull[table], tx.primaryKeys[table]) {
+		if row[slices.Index(columns, column)] == nil {
+			return fmt.Errorf("%w: %s.%s", errNotNull, table, column)
+		}
+	}
+
+	return nil
+}
+
+// Checks the primary keys of tables the transaction added rows to.
+func (d *client) checkPrimaryKeysAtCommit() error {
+	for _, table := range sortedKeys(d.tx.primaryKeys) {
+		pk := d.tx.primaryKeys[table]
+		if !slices.ContainsFunc(d.tx.Actions[table], func(action Action) bool {
+			return action.AddDataobject != nil
+		}) {
+			continue
+		}
+
+		values, err := d.foreignKeyValues(table, pk, true)
+		if err != nil {
+			return err
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		// The transaction's rows are found too, so a value is
+		// duplicated if it's found more than once.
+		it, err := d.scan(table, keyLookup(pk, values)...)
+		if err != nil {
+			return err
+		}
+
+		found := map[string]int{}
+		for {
+			row, err := it.next()
+			if err != nil {
+				return err
+			}
+			if row == nil {
+				break
+			}
+
+			key, ok := foreignKeyValue(row)
+			if _, added := values[key]; !ok || !added {
+				continue
+			}
+
+			found[key]++
+			if found[key] > 1 {
+				return fmt.Errorf("%w: %s%v: duplicate %v", errPrimaryKey, table, pk, values[key])
+			}
+		}
+	}
+
+	return nil
+}
+
+// The table with a primary key to which both committed and the
+// transaction added a value, if any.
+func (d *client) duplicateKeyIn(committed *logEntry) (string, bool, error) {
+	for _, table := range sortedKeys(d.tx.primaryKeys) {
+		var theirs []*DataobjectAction
+		for _, action := range committed.Actions[table] {
+			if action.AddDataobject != nil {
+				theirs = append(theirs, action.AddDataobject)
+			}
+		}
+		if len(theirs) == 0 {
+			continue
+		}
+
+		pk := d.tx.primaryKeys[table]
+		values, err := d.foreignKeyValues(table, pk, true)
+		if err != nil {
+			return "", false, err
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		var positions []int
+		for _, column := range pk {
+			positions = append(positions, slices.Index(d.tx.tables[table], column))
+		}
+
+		filter := keyFilter(pk, values)
+		for _, action := range theirs {
+			if filter != nil && !d.mayMatch(table, action, filter) {
+				continue
+			}
+
+			convert := d.tx.schemas[table].converter(action.SchemaVersion)
+			o, err := d.readDataobjectWith(d.context(), action, convert)
+			if err != nil {
+				return "", false, fmt.Errorf("could not check primary key of %s: %w", table, err)
+			}
+
+			for i := 0; i < o.Len; i++ {
+				row := make([]any, len(positions))
+				for j, position := range positions {
+					row[j] = o.Columns[position][i]
+				}
+				if key, ok := foreignKeyValue(row); ok && values[key] != nil {
+					return table, true, nil
+				}
+			}
+		}
+	}
+
+	return "", false, nil
+}
@@ -0,0 +1,146 @@
+package otf
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNotNull(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b", "c"}, withNotNull("b"), withPrimaryKey("a"))
+	assertEq(err, nil, "could not create x")
+	err = c.writeRow("x", []any{1, "one", nil})
+	assertEq(err, nil, "could not write row")
+	err = c.writeRow("x", []any{2, nil, nil})
+	assert(errors.Is(err, errNotNull), "wrote a null b")
+	err = c.writeRow("x", []any{nil, "none", nil})
+	assert(errors.Is(err, errNotNull), "wrote a null primary key")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.updateRows("x", where("a", OP_EQ, 1), map[string]*expr{"b": litExpr(nil)})
+	assert(errors.Is(err, errNotNull), "updated b to null")
+	err = c.dropColumn("x", "b")
+	assert(errors.Is(err, errConstraintColumn), "dropped a NOT NULL column")
+	err = c.renameColumn("x", "a", "id")
+	assert(errors.Is(err, errConstraintColumn), "renamed a primary key column")
+	err = c.dropColumn("x", "c")
+	assertEq(err, nil, "could not drop an unconstrained column")
+	err = c.createTable("y", []string{"a"}, withPrimaryKey("a", "a"))
+	assert(errors.Is(err, errConstraintColumn), "declared a column in the primary key twice")
+	err = c.createTable("y", []string{"a"}, withNotNull("b"))
+	assert(errors.Is(err, errNoColumn), "declared a missing column NOT NULL")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+}
+
+func TestPrimaryKey(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b", "c"}, withPrimaryKey("a", "b"))
+	assertEq(err, nil, "could not create x")
+	for _, row := range [][]any{{1, "x", 1}, {1, "y", 2}, {2, "x", 3}} {
+		err = c.writeRow("x", row)
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// A duplicate of a committed row.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{1, "y", 4})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assert(errors.Is(err, errPrimaryKey), "committed a duplicate")
+	assertEq(err.Error(), "Primary Key Violation: x[a b]: duplicate [1 y]", "error")
+
+	// A duplicate within the transaction.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{3, "x", 5})
+	assertEq(err, nil, "could not write row")
+	err = c.writeRow("x", []any{3, "x", 6})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assert(errors.Is(err, errPrimaryKey), "committed a duplicate within the transaction")
+
+	// Replacing a row's key with a deleted one's is fine.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.deleteRows("x", where("a", OP_EQ, 2))
+	assertEq(err, nil, "could not delete")
+	err = c.writeRow("x", []any{2, "x", 7})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.updateRows("x", where("c", OP_EQ, 2), map[string]*expr{"b": litExpr("x")})
+	assertEq(err, nil, "could not update")
+	err = c.commitTx()
+	assert(errors.Is(err, errPrimaryKey), "updated a row into a duplicate")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(scanAll(&c, "x")), 3, "rows")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+}
+
+func TestSQLConstraints(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	_, err := c.execSQL("CREATE TABLE people (id INT PRIMARY KEY, name TEXT NOT NULL, zip)")
+	assertEq(err, nil, "could not create people")
+	_, err = c.execSQL("INSERT INTO people VALUES (1, 'Ann', NULL)")
+	assertEq(err, nil, "could not insert")
+	_, err = c.execSQL("INSERT INTO people VALUES (2, NULL, NULL)")
+	assert(errors.Is(err, errNotNull), "inserted a null name")
+	_, err = c.execSQL("INSERT INTO people VALUES (1, 'Bo', NULL)")
+	assert(errors.Is(err, errPrimaryKey), "inserted a duplicate id")
+	_, err = c.execSQL("CREATE TABLE t (a NOT)")
+	assert(errors.Is(err, errSQLSyntax), "parsed NOT without NULL")
+}
+
+func TestPrimaryKeyConcurrently(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b"}, withPrimaryKey("a"))
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	write := func(rows ...[]any) *client {
+		d := newClient(mos, withReadValidation())
+		err := d.newTx()
+		assertEq(err, nil, "could not start tx")
+		for _, row := range rows {
+			err = d.writeRow("x", row)
+			assertEq(err, nil, "could not write row")
+		}
+		return &d
+	}
+
+	first, second, third := write([]any{1, "a"}), write([]any{1, "b"}), write([]any{2, "c"})
+	err = first.commitTx()
+	assertEq(err, nil, "could not commit")
+	err = second.commitTx()
+	assert(errors.Is(err, errConflict), "committed a concurrent duplicate")
+	// Other values don't conflict.
+	err = third.commitTx()
+	assertEq(err, nil, "could not commit another value")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(scanAll(&c, "x")), 2, "rows")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+}
@@ -0,0 +1,39 @@
+package otf
+
+import (
+	"context"
+)
+
+// Object storage calls take a context so that slow or stuck calls
+// against remote storage can be canceled or given deadlines. A
+// transaction started with newTxContext makes all of its calls
+// with that context: reading the log to start it, flushing rows,
+// reading dataobjects and writing its log entry on commit. A scan
+// started with scanContext reads its dataobjects with its own
+// context instead, and stops between dataobjects once it's done.
+//
+// Vacuuming with vacuumContext outside of a transaction makes its
+// calls with its own context (see bulk.go). Other calls made outside
+// of a transaction (serving spooled scans and so on) aren't
+// cancelable yet and use context.Background().
+
+// The context of the current transaction, if any.
+func (d *client) context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+
+	return d.ctx
+}
+
+// Like scan but reads the table's dataobjects with ctx rather than
+// the transaction's context.
+func (d *client) scanContext(ctx context.Context, table string, opts ...scanOption) (*scanIterator, error) {
+	it, err := d.scan(table, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	it.ctx = ctx
+	return it, nil
+}
@@ -0,0 +1,121 @@
+package otf
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Memory storage that fails calls whose context is done, like
+// remote storage would.
+type contextStorage struct {
+	*memoryObjectStorage
+}
+
+func (cs contextStorage) putIfAbsent(ctx context.Context, name string, bytes []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return cs.memoryObjectStorage.putIfAbsent(ctx, name, bytes)
+}
+
+func (cs contextStorage) listPrefix(ctx context.Context, prefix string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return cs.memoryObjectStorage.listPrefix(ctx, prefix)
+}
+
+func (cs contextStorage) read(ctx context.Context, name string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return cs.memoryObjectStorage.read(ctx, name)
+}
+
+func TestTxContext(t *testing.T) {
+	c := newClient(contextStorage{newMemoryObjectStorage()})
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = c.newTxContext(ctx)
+	assert(errors.Is(err, context.Canceled), "started a canceled tx")
+	assert(c.tx == nil, "left a tx open")
+
+	ctx, cancel = context.WithCancel(context.Background())
+	err = c.newTxContext(ctx)
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	cancel()
+	err = c.commitTx()
+	assert(errors.Is(err, context.Canceled), "committed a canceled tx")
+
+	// Later transactions aren't canceled with it.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(scanAll(&c, "x")), 0, "canceled commit wrote rows")
+	err = c.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+}
+
+func TestScanContext(t *testing.T) {
+	c := newClient(contextStorage{newMemoryObjectStorage()})
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	for i := 0; i < 3; i++ {
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+		err = c.flushRows("x")
+		assertEq(err, nil, "could not flush")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	ctx, cancel := context.WithCancel(context.Background())
+	it, err := c.scanContext(ctx, "x")
+	assertEq(err, nil, "could not scan")
+	row, err := it.next()
+	assertEq(err, nil, "could not read row")
+	assert(row != nil, "no rows")
+
+	// Stops before the next dataobject.
+	cancel()
+	_, err = it.next()
+	assert(errors.Is(err, context.Canceled), "scanned past cancel")
+
+	// The transaction goes on.
+	assertEq(len(scanAll(&c, "x")), 3, "rows")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+}
+
+func TestHTTPStorageDeadline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := newHTTPObjectStorage(srv.URL).read(ctx, "x")
+	assert(errors.Is(err, context.DeadlineExceeded), "read past deadline")
+}
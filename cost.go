@@ -0,0 +1,125 @@
+package otf
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Object stores charge per request and per byte transferred, so
+// each scan keeps count of what it asked storage for, along with
+// how much it avoided asking for by skipping dataobjects and row
+// groups (see predicate.go and rowgroup.go). Starting a transaction
+// has a cost of its own, listing and reading the log, which is
+// shared by every scan in it.
+
+type readCost struct {
+	// listPrefix calls and the names they returned.
+	Lists  int
+	Listed int
+	// read and readRange calls and the bytes they returned.
+	Gets         int
+	BytesFetched int64
+}
+
+func (rc readCost) String() string {
+	return fmt.Sprintf("%d lists (%d objects), %d gets (%d bytes)", rc.Lists, rc.Listed, rc.Gets, rc.BytesFetched)
+}
+
+type scanCost struct {
+	readCost
+
+	// Dataobjects the scan had to consider, not counting ones
+	// served from the table cache, and ones it skipped.
+	Dataobjects        int
+	DataobjectsSkipped int
+	RowGroupsRead      int
+	RowGroupsSkipped   int
+
+	// Of the dataobjects considered, as recorded when they were
+	// written, and of the parts of them read after skipping.
+	Bytes             int64
+	BytesAfterPruning int64
+}
+
+func (sc scanCost) String() string {
+	return fmt.Sprintf("%s; %d of %d dataobjects skipped, %d of %d row groups skipped; %d of %d bytes after pruning",
+		sc.readCost, sc.DataobjectsSkipped, sc.Dataobjects, sc.RowGroupsSkipped, sc.RowGroupsRead+sc.RowGroupsSkipped, sc.BytesAfterPruning, sc.Bytes)
+}
+
+// Counts requests made through it, safe for concurrent use.
+type meteredObjectStorage struct {
+	objectStorage
+	mu   sync.Mutex
+	cost readCost
+}
+
+func (mos *meteredObjectStorage) listPrefix(ctx context.Context, prefix string) ([]string, error) {
+	names, err := mos.objectStorage.listPrefix(ctx, prefix)
+	mos.mu.Lock()
+	defer mos.mu.Unlock()
+	mos.cost.Lists++
+	mos.cost.Listed += len(names)
+	return names, err
+}
+
+func (mos *meteredObjectStorage) read(ctx context.Context, name string) ([]byte, error) {
+	bytes, err := mos.objectStorage.read(ctx, name)
+	mos.fetched(len(bytes))
+	return bytes, err
+}
+
+func (mos *meteredObjectStorage) readRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	rr, ok := mos.objectStorage.(rangeReader)
+	if !ok {
+		// Has to fetch all of it.
+		return readRange(ctx, struct{ objectStorage }{mos}, name, offset, length)
+	}
+
+	bytes, err := rr.readRange(ctx, name, offset, length)
+	mos.fetched(len(bytes))
+	return bytes, err
+}
+
+func (mos *meteredObjectStorage) stat(ctx context.Context, name string) (objectInfo, error) {
+	info, err := statObject(ctx, mos.objectStorage, name)
+	mos.fetched(0)
+	return info, err
+}
+
+func (mos *meteredObjectStorage) fetched(n int) {
+	mos.mu.Lock()
+	defer mos.mu.Unlock()
+	mos.cost.Gets++
+	mos.cost.BytesFetched += int64(n)
+}
+
+func (mos *meteredObjectStorage) total() readCost {
+	mos.mu.Lock()
+	defer mos.mu.Unlock()
+	return mos.cost
+}
+
+// A copy of d reading through metered storage.
+func (d *client) metered() (*client, *meteredObjectStorage) {
+	m := &meteredObjectStorage{objectStorage: d.os}
+	metered := *d
+	metered.os = m
+	return &metered, m
+}
+
+// What starting the current transaction read.
+func (d *client) openCost() (readCost, error) {
+	if d.tx == nil {
+		return readCost{}, errNoTx
+	}
+
+	return d.tx.openCost, nil
+}
+
+// What the scan has read so far and avoided reading.
+func (si *scanIterator) cost() scanCost {
+	c := si.pruning
+	c.readCost = si.storage.total()
+	return c
+}
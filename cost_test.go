@@ -0,0 +1,92 @@
+package otf
+
+import (
+	"testing"
+)
+
+func TestScanCost(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos, withRowGroupSize(100))
+	for i := 0; i < 3; i++ {
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+		if i == 0 {
+			err = c.createTable("x", []string{"a"})
+			assertEq(err, nil, "could not create x")
+		}
+		for j := 0; j < 10; j++ {
+			err = c.writeRow("x", []any{i*10 + j})
+			assertEq(err, nil, "could not write row")
+		}
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	opened, err := c.openCost()
+	assertEq(err, nil, "could not get open cost")
+	// The log and checkpoints.
+	assertEq(opened.Lists, 2, "lists")
+	assertEq(opened.Listed, 3, "listed")
+	assertEq(opened.Gets, 3, "gets")
+
+	var sizes []int64
+	for _, action := range c.tx.previousActions["x"] {
+		if action.AddDataobject != nil {
+			sizes = append(sizes, action.AddDataobject.Bytes)
+		}
+	}
+	assertEq(len(sizes), 3, "dataobjects")
+
+	it, err := c.scan("x", withFilter(where("a", OP_GTE, 25)))
+	assertEq(err, nil, "could not scan")
+	n := 0
+	for {
+		row, err := it.next()
+		assertEq(err, nil, "could not read row")
+		if row == nil {
+			break
+		}
+		n++
+	}
+	assertEq(n, 5, "rows")
+
+	cost := it.cost()
+	assertEq(cost.Dataobjects, 3, "dataobjects")
+	assertEq(cost.DataobjectsSkipped, 2, "skipped")
+	assertEq(cost.Gets, 1, "gets")
+	assertEq(cost.Bytes, sizes[0]+sizes[1]+sizes[2], "bytes")
+	assertEq(cost.BytesAfterPruning, sizes[2], "bytes after pruning")
+	assertEq(cost.BytesFetched, sizes[2], "bytes fetched")
+
+	// Row groups are read with ranges.
+	for i := 0; i < 1000; i++ {
+		err = c.writeRow("x", []any{100 + i})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.flushRows("x")
+	assertEq(err, nil, "could not flush")
+	actions := c.tx.Actions["x"]
+	groups := actions[len(actions)-1].AddDataobject.RowGroups
+	assertEq(len(groups), 10, "row groups")
+
+	it, err = c.scan("x", withFilter(where("a", OP_GTE, 1050)))
+	assertEq(err, nil, "could not scan")
+	for {
+		row, err := it.next()
+		assertEq(err, nil, "could not read row")
+		if row == nil {
+			break
+		}
+	}
+
+	cost = it.cost()
+	assertEq(cost.Dataobjects, 4, "dataobjects")
+	assertEq(cost.DataobjectsSkipped, 3, "skipped")
+	assertEq(cost.RowGroupsRead, 1, "row groups read")
+	assertEq(cost.RowGroupsSkipped, 9, "row groups skipped")
+	assertEq(cost.Gets, 1, "gets")
+	assertEq(cost.BytesFetched, groups[9].Length, "bytes fetched")
+	assertEq(cost.BytesAfterPruning, groups[9].Length, "bytes after pruning")
+}
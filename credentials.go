@@ -0,0 +1,194 @@
+package otf
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A table can keep its dataobjects in storage of its own rather
+// than the store's, e.g. a bucket owned by the team that owns the
+// table. The catalog (the table's metadata in the log) records
+// where, as a storage URL, and optionally a role to access it as.
+// The log itself stays in the store's storage so commits remain
+// serialized.
+//
+// Roles are turned into credentials by the client's
+// credentialProvider, e.g. by assuming an IAM role or impersonating
+// a service account, when the table's storage is first used and
+// again shortly before the credentials expire. Without a role the
+// storage finds credentials in its environment as usual.
+//
+// Vacuum (see vacuum.go) only collects dataobjects in the store's
+// own storage; tables stored elsewhere are left to their owners.
+
+type TableLocation struct {
+	// Understood by openObjectStorage.
+	URL  string
+	Role string `json:",omitempty"`
+}
+
+type credentials struct {
+	// For S3.
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	// An OAuth2 access token, for GCS.
+	Token string
+	// Zero if they don't expire.
+	Expires time.Time
+}
+
+type credentialProvider func(role string) (credentials, error)
+
+// Credentials are acquired again this long before they expire.
+const CREDENTIAL_REFRESH = time.Minute
+
+var errNoCredentials = fmt.Errorf("No Credentials")
+
+func withCredentialProvider(p credentialProvider) clientOption {
+	return func(c *client) {
+		c.credentials = p
+	}
+}
+
+// Stores the table's dataobjects at url, accessed as role if not
+// empty.
+func withTableLocation(url, role string) tableOption {
+	return func(o *tableOptions) {
+		o.location = &TableLocation{url, role}
+	}
+}
+
+// Storage opened for a location, with the credentials it was
+// opened with.
+type locatedStorage struct {
+	storage objectStorage
+	expires time.Time
+}
+
+// Shared by copies of a client (see cost.go) and by concurrent
+// decodes (see decode.go).
+type locatedStorages struct {
+	mu         sync.Mutex
+	byLocation map[TableLocation]*locatedStorage
+}
+
+func newLocatedStorages() *locatedStorages {
+	return &locatedStorages{byLocation: map[TableLocation]*locatedStorage{}}
+}
+
+// Returns os using creds instead of whatever it found in its
+// environment.
+func scopeStorage(os objectStorage, creds credentials) (objectStorage, error) {
+	switch s := os.(type) {
+	case *s3ObjectStorage:
+		cfg := s.cfg
+		cfg.AccessKeyId = creds.AccessKeyId
+		cfg.SecretAccessKey = creds.SecretAccessKey
+		cfg.SessionToken = creds.SessionToken
+		return newS3ObjectStorage(cfg), nil
+	case *gcsObjectStorage:
+		cfg := s.cfg
+		token := creds.Token
+		cfg.Token = func() (string, error) {
+			return token, nil
+		}
+		return newGCSObjectStorage(cfg), nil
+	case *fileObjectStorage:
+		// Nothing to authenticate.
+		return os, nil
+	case *encryptedObjectStorage:
+		inner, err := scopeStorage(s.os, creds)
+		if err != nil {
+			return nil, err
+		}
+		return newEncryptedObjectStorage(inner, s.keys), nil
+	}
+
+	return nil, fmt.Errorf("%w: %T doesn't take credentials", errNoCredentials, os)
+}
+
+// Storage for loc, acquiring credentials for its role if there are
+// none yet or they are about to expire. On an encrypted store (see
+// encryption.go) it's encrypted with the store's keys, unless loc
+// names keys of its own, so located dataobjects aren't stored in
+// plaintext either.
+func (d *client) openLocation(loc TableLocation) (objectStorage, error) {
+	d.located.mu.Lock()
+	defer d.located.mu.Unlock()
+
+	ls, ok := d.located.byLocation[loc]
+	if ok && (ls.expires.IsZero() || time.Until(ls.expires) > CREDENTIAL_REFRESH) {
+		return ls.storage, nil
+	}
+
+	os, err := openObjectStorage(loc.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	ls = &locatedStorage{storage: os}
+	if loc.Role != "" {
+		if d.credentials == nil {
+			return nil, fmt.Errorf("%w: for role %s", errNoCredentials, loc.Role)
+		}
+
+		d.debug("credentials", "acquiring", "role", loc.Role)
+		creds, err := d.credentials(loc.Role)
+		if err != nil {
+			return nil, fmt.Errorf("%w: for role %s: %s", errNoCredentials, loc.Role, err)
+		}
+
+		ls.storage, err = scopeStorage(os, creds)
+		if err != nil {
+			return nil, err
+		}
+		ls.expires = creds.Expires
+	}
+
+	if _, ok := ls.storage.(*encryptedObjectStorage); !ok && d.keys != nil {
+		ls.storage = newEncryptedObjectStorage(ls.storage, d.keys)
+	}
+
+	d.located.byLocation[loc] = ls
+	return ls.storage, nil
+}
+
+// Where dataobjects of a table with location loc, if any, are
+// stored.
+func (d *client) storageAt(loc *TableLocation) (objectStorage, error) {
+	if loc == nil {
+		return d.os, nil
+	}
+
+	return d.openLocation(*loc)
+}
+
+// Where table's dataobjects are stored as of the current
+// transaction, if any.
+func (d *client) tableStorage(table string) (objectStorage, error) {
+	if d.tx == nil {
+		return d.os, nil
+	}
+
+	return d.storageAt(d.tx.locations[table])
+}
+
+// Where the dataobject with key is stored as of the current
+// transaction. Table names may contain underscores so the longest
+// matching table wins.
+func (d *client) dataobjectStorage(key string) (objectStorage, error) {
+	table := ""
+	for t := range d.tx.tables {
+		if strings.HasPrefix(key, dataobjectKey(t, "")) && len(t) > len(table) {
+			table = t
+		}
+	}
+	if table == "" {
+		return d.os, nil
+	}
+
+	return d.tableStorage(table)
+}
@@ -0,0 +1,119 @@
+package otf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTableLocation(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-table-location")
+	assertEq(err, nil, "could not make dir")
+	defer os.RemoveAll(dir)
+
+	acquired := 0
+	provider := func(role string) (credentials, error) {
+		if role != "team-b" {
+			return credentials{}, fmt.Errorf("may not assume %s", role)
+		}
+
+		acquired++
+		return credentials{Token: "t", Expires: time.Now().Add(time.Hour)}, nil
+	}
+
+	mos := newMemoryObjectStorage()
+	c := newClient(mos, withCredentialProvider(provider), withCheckpointInterval(2))
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"}, withTableLocation(dir, "team-b"))
+	assertEq(err, nil, "could not create x")
+	err = c.createTable("x_y", []string{"a"})
+	assertEq(err, nil, "could not create x_y")
+	for i := 0; i < 3; i++ {
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+		err = c.writeRow("x_y", []any{i})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	assertEq(acquired, 1, "credentials acquired")
+
+	// Only x's dataobject is stored elsewhere.
+	located := newFileObjectStorage(dir)
+	names, err := located.listPrefix(context.Background(), DATAOBJECT_PREFIX)
+	assertEq(err, nil, "could not list")
+	assertEq(len(names), 1, "located dataobjects")
+	names, err = mos.listPrefix(context.Background(), DATAOBJECT_PREFIX)
+	assertEq(err, nil, "could not list")
+	assertEq(len(names), 1, "dataobjects with the log")
+	assertEq(names[0][:len(dataobjectKey("x_y", ""))], dataobjectKey("x_y", ""), "dataobject with the log")
+
+	// Past a checkpoint, which must keep the location.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{3})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	other := newClient(mos, withCredentialProvider(provider))
+	// Other clients find x where it was created.
+	assertEq(countRows(&other, "x"), 4, "rows of x")
+	assertEq(countRows(&other, "x_y"), 3, "rows of x_y")
+
+	// As do changes, read outside a transaction.
+	changes, err := other.tableChangesSince("x", -1)
+	assertEq(err, nil, "could not read changes")
+	assertEq(len(changes), 2, "transactions changing x")
+
+	// Aborting deletes from the table's storage.
+	err = other.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = other.writeRow("x", []any{4})
+	assertEq(err, nil, "could not write row")
+	err = other.flushRows("x")
+	assertEq(err, nil, "could not flush")
+	err = other.abortTx()
+	assertEq(err, nil, "could not abort")
+	names, err = located.listPrefix(context.Background(), DATAOBJECT_PREFIX)
+	assertEq(err, nil, "could not list")
+	assertEq(len(names), 2, "located dataobjects")
+
+	// Can't read x without being able to assume its role.
+	anonymous := newClient(mos)
+	err = anonymous.newTx()
+	assertEq(err, nil, "could not start tx")
+	it, err := anonymous.scan("x")
+	if err == nil {
+		_, err = it.next()
+	}
+	assert(errors.Is(err, errNoCredentials), fmt.Sprintf("expected no credentials, got %v", err))
+}
+
+func TestCredentialRefresh(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test-credential-refresh")
+	assertEq(err, nil, "could not make dir")
+	defer os.RemoveAll(dir)
+
+	acquired := 0
+	c := newClient(newMemoryObjectStorage(), withCredentialProvider(func(role string) (credentials, error) {
+		acquired++
+		// Already due for a refresh.
+		return credentials{Expires: time.Now().Add(CREDENTIAL_REFRESH / 2)}, nil
+	}))
+
+	loc := TableLocation{dir, "r"}
+	_, err = c.openLocation(loc)
+	assertEq(err, nil, "could not open")
+	_, err = c.openLocation(loc)
+	assertEq(err, nil, "could not open")
+	assertEq(acquired, 2, "credentials acquired")
+
+	// Credentials don't apply to storage that can't take them.
+	_, err = scopeStorage(newHTTPObjectStorage("http://localhost"), credentials{})
+	assert(errors.Is(err, errNoCredentials), "expected no credentials")
+}
@@ -0,0 +1,130 @@
+package otf
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// Dataobjects are never changed once written, so their decoded rows
+// can be kept around and reused by later scans, in later
+// transactions or by other clients, rather than downloaded and
+// decoded again. A dataobject cache holds the most recently read
+// ones up to a budget of bytes, evicting the least recently used. It
+// can be shared by any number of clients, e.g. every transaction of
+// a server (see server.go).
+//
+// The budget counts dataobjects' stored size, which decoded rows
+// take several times over in memory. Dataobjects are cached as
+// stored, before converting them to a later schema and without
+// deleted rows removed, by their key and which of their families
+// (see families.go) were read. Partial reads of row groups (see
+// rowgroup.go) aren't cached.
+
+type dataobjectCache struct {
+	budget int64
+
+	mu   sync.Mutex
+	size int64
+	// Most recently used first.
+	lru     *list.List
+	entries map[string]*list.Element
+
+	hits   int
+	misses int
+}
+
+type dataobjectCacheEntry struct {
+	key   string
+	rows  *batch
+	bytes int64
+}
+
+// A cache of decoded dataobjects of up to budget stored bytes.
+func newDataobjectCache(budget int64) *dataobjectCache {
+	return &dataobjectCache{budget: budget, lru: list.New(), entries: map[string]*list.Element{}}
+}
+
+// Reads dataobjects through cache, which other clients may share.
+func withDataobjectCache(cache *dataobjectCache) clientOption {
+	return func(c *client) {
+		c.dataobjectCache = cache
+	}
+}
+
+// What action's rows are cached as, with only the wanted families
+// read unless wanted is nil.
+func dataobjectCacheKey(action *DataobjectAction, wanted []bool) string {
+	key := dataobjectKey(action.Table, action.Name)
+	if wanted != nil {
+		key += fmt.Sprint(wanted)
+	}
+
+	return key
+}
+
+func (dc *dataobjectCache) lookup(action *DataobjectAction, wanted []bool) (*list.Element, bool) {
+	e, ok := dc.entries[dataobjectCacheKey(action, wanted)]
+	if !ok && wanted != nil {
+		// Every family will do.
+		e, ok = dc.entries[dataobjectCacheKey(action, nil)]
+	}
+
+	return e, ok
+}
+
+// Whether get would return action's rows, without counting it as a
+// use of them.
+func (dc *dataobjectCache) has(action *DataobjectAction, wanted []bool) bool {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	_, ok := dc.lookup(action, wanted)
+	return ok
+}
+
+// The rows cached for action with the wanted families, or nil. Rows
+// must not be modified.
+func (dc *dataobjectCache) get(action *DataobjectAction, wanted []bool) *batch {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	e, ok := dc.lookup(action, wanted)
+	if !ok {
+		dc.misses++
+		return nil
+	}
+
+	dc.hits++
+	dc.lru.MoveToFront(e)
+	return e.Value.(dataobjectCacheEntry).rows
+}
+
+// Caches rows, returning the keys of the entries evicted to make
+// room.
+func (dc *dataobjectCache) put(action *DataobjectAction, wanted []bool, rows *batch) (evicted []string) {
+	bytes := action.Bytes - skippedFamilyBytes(action, wanted)
+	if bytes > dc.budget {
+		return nil
+	}
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	key := dataobjectCacheKey(action, wanted)
+	if _, ok := dc.entries[key]; ok {
+		return nil
+	}
+
+	dc.entries[key] = dc.lru.PushFront(dataobjectCacheEntry{key, rows, bytes})
+	dc.size += bytes
+	for dc.size > dc.budget {
+		oldest := dc.lru.Back()
+		entry := oldest.Value.(dataobjectCacheEntry)
+		dc.lru.Remove(oldest)
+		delete(dc.entries, entry.key)
+		dc.size -= entry.bytes
+		evicted = append(evicted, entry.key)
+	}
+	return evicted
+}
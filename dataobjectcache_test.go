@@ -0,0 +1,91 @@
+package otf
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Counts reads of dataobjects.
+type dataobjectReads struct {
+	objectStorage
+	mu    sync.Mutex
+	reads int
+}
+
+func (dr *dataobjectReads) read(ctx context.Context, name string) ([]byte, error) {
+	if strings.HasPrefix(name, DATAOBJECT_PREFIX) {
+		dr.mu.Lock()
+		dr.reads++
+		dr.mu.Unlock()
+	}
+
+	return dr.objectStorage.read(ctx, name)
+}
+
+func TestDataobjectCache(t *testing.T) {
+	dr := &dataobjectReads{objectStorage: newMemoryObjectStorage()}
+	c := newClient(dr)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b"}, withColumnFamilies([]string{"b"}))
+	assertEq(err, nil, "could not create x")
+	for i := 0; i < 3; i++ {
+		err = c.writeRow("x", []any{i, "b"})
+		assertEq(err, nil, "could not write row")
+		err = c.flushRows("x")
+		assertEq(err, nil, "could not flush")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	cache := newDataobjectCache(1 << 20)
+	scan := func(opts ...scanOption) int {
+		c := newClient(dr, withDataobjectCache(cache))
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+		dr.reads = 0
+		assertEq(len(scanAll(&c, "x", opts...)), 3, "rows")
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+		return dr.reads
+	}
+
+	// Read once, then shared by later clients.
+	assertEq(scan(withColumns("a")), 3, "dataobject reads")
+	assertEq(scan(withColumns("a")), 0, "dataobject reads")
+	assertEq(scan(), 6, "dataobject reads with families")
+	assertEq(scan(), 0, "dataobject reads with families")
+	assertEq(cache.hits, 6, "hits")
+
+	// Cached rows are converted to later schemas.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.addColumn("x", "c")
+	assertEq(err, nil, "could not add column")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	assertEq(scan(withColumns("c", "b")), 0, "dataobject reads after evolving")
+}
+
+func TestDataobjectCacheEvicts(t *testing.T) {
+	cache := newDataobjectCache(10)
+	rows := newBatch(1)
+	x := &DataobjectAction{Table: "x", Name: "x", Bytes: 4}
+	y := &DataobjectAction{Table: "x", Name: "y", Bytes: 4}
+	z := &DataobjectAction{Table: "x", Name: "z", Bytes: 4}
+	cache.put(x, nil, rows)
+	cache.put(y, nil, rows)
+	assert(cache.get(x, nil) != nil, "x evicted")
+
+	// y is now the least recently used.
+	cache.put(z, nil, rows)
+	assert(cache.get(y, nil) == nil, "y kept over budget")
+	assert(cache.get(x, nil) != nil && cache.get(z, nil) != nil, "evicted more than needed")
+	assertEq(cache.size, int64(8), "size")
+
+	// Too big to cache at all.
+	cache.put(&DataobjectAction{Table: "x", Name: "big", Bytes: 11}, nil, rows)
+	assertEq(len(cache.entries), 2, "entries")
+}
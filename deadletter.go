@@ -0,0 +1,83 @@
+package otf
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// A row on its way into a table along with where it came from
+// (e.g. "events.csv:42") so that rejected rows can be traced back.
+type sourcedRow struct {
+	Row      []any
+	Location string
+}
+
+type bulkWriteResult struct {
+	Written  int
+	Rejected int
+}
+
+// Rejected rows are kept next to the table they were meant for. The
+// original row is stored as JSON since it may not even have the
+// right number of columns.
+func deadLetterTable(table string) string {
+	return "_deadletter_" + table
+}
+
+var deadLetterColumns = []string{"reason", "location", "row"}
+
+// Writes rows to table in the current transaction. Without
+// deadLetter the first row failing validation fails the write (rows
+// before it have still been written to the transaction). With
+// deadLetter, rows failing validation are instead written to the
+// table's dead-letter table, in the same transaction, along with
+// the reason they were rejected.
+func (d *client) writeRows(table string, rows []sourcedRow, deadLetter bool) (bulkWriteResult, error) {
+	var result bulkWriteResult
+	if d.tx == nil {
+		return result, errNoTx
+	}
+
+	if _, ok := d.tx.tables[table]; !ok {
+		return result, errNoTable
+	}
+
+	for _, r := range rows {
+		err := d.writeRow(table, r.Row)
+		if err == nil {
+			result.Written++
+			continue
+		}
+
+		if !deadLetter || !errors.Is(err, errInvalidRow) {
+			return result, err
+		}
+
+		err = d.writeDeadLetter(table, r, err)
+		if err != nil {
+			return result, err
+		}
+
+		result.Rejected++
+	}
+
+	return result, nil
+}
+
+func (d *client) writeDeadLetter(table string, r sourcedRow, reason error) error {
+	dlTable := deadLetterTable(table)
+	if _, ok := d.tx.tables[dlTable]; !ok {
+		err := d.createTable(dlTable, deadLetterColumns)
+		if err != nil {
+			return err
+		}
+	}
+
+	bytes, err := json.Marshal(r.Row)
+	if err != nil {
+		return err
+	}
+
+	d.debug("deadletter", "rejected row", "table", table, "location", r.Location, "reason", reason)
+	return d.writeRow(dlTable, []any{reason.Error(), r.Location, string(bytes)})
+}
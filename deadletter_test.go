@@ -0,0 +1,49 @@
+package otf
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWriteRowsDeadLetter(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b"})
+	assertEq(err, nil, "could not create x")
+
+	rows := []sourcedRow{
+		{Row: []any{"Joey", 1}, Location: "rows.json:1"},
+		{Row: []any{"Yue"}, Location: "rows.json:2"},
+		{Row: []any{"Ada", 3}, Location: "rows.json:3"},
+	}
+
+	// Without a dead-letter table the bad row fails the write.
+	_, err = c.writeRows("x", rows, false)
+	assert(errors.Is(err, errInvalidRow), "expected invalid row")
+	c.tx = nil
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b"})
+	assertEq(err, nil, "could not create x")
+	result, err := c.writeRows("x", rows, true)
+	assertEq(err, nil, "could not write rows")
+	assertEq(result.Written, 2, "written rows")
+	assertEq(result.Rejected, 1, "rejected rows")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	it, err := c.scan(deadLetterTable("x"))
+	assertEq(err, nil, "could not scan dead letters")
+	row, err := it.next()
+	assertEq(err, nil, "could not read dead letter")
+	assert(row != nil, "expected a dead letter")
+	assertEq(row[1], "rows.json:2", "dead letter location")
+	assertEq(row[2], `["Yue"]`, "dead letter row")
+	row, err = it.next()
+	assertEq(err, nil, "could not read dead letter")
+	assert(row == nil, "expected a single dead letter")
+}
@@ -0,0 +1,147 @@
+package otf
+
+import (
+	"context"
+	"runtime"
+)
+
+// Scans read dataobjects ahead of the one they are returning rows
+// from, decompressing and decoding several at once. Decoding is
+// CPU-bound so the number of dataobjects being decoded at any time
+// is limited per client, across all of its scans, by default to
+// GOMAXPROCS.
+
+// Decodes up to n dataobjects at once, at least 1.
+func withDecodeParallelism(n int) clientOption {
+	return func(c *client) {
+		c.decoders = make(chan struct{}, max(n, 1))
+	}
+}
+
+func defaultDecoders() chan struct{} {
+	return make(chan struct{}, runtime.GOMAXPROCS(0))
+}
+
+type decodedDataobject struct {
+	action *DataobjectAction
+	rows   *batch
+	err    error
+	// Bytes of families not read as no row matched, see
+	// latematerialize.go.
+	unread int64
+}
+
+// Reads action in the background once a decoder is free, without
+// deleted rows. Reads only the given row groups unless groups is nil,
+// see rowgroup.go, and only the wanted families unless wanted is
+// nil, see families.go. Decodes the filter's columns first unless
+// late is nil, see latematerialize.go.
+func (d *client) decodeAsync(ctx context.Context, action *DataobjectAction, groups []int, wanted []bool, late *lateRead, deleted map[int]bool) chan decodedDataobject {
+	result := make(chan decodedDataobject, 1)
+	// The transaction may be gone by the time this runs.
+	convert := d.tx.schemas[action.Table].converter(action.SchemaVersion)
+	go func() {
+		var storage, base objectStorage
+		var err error
+		if groups == nil {
+			base, err = d.tableStorage(action.Table)
+			storage = base
+			first := wanted
+			if late != nil && late.firstFamily != nil {
+				first = late.firstFamily
+			}
+			// Downloaded before taking a decoder, see
+			// prefetch.go.
+			if err == nil && d.fetchers != nil && (d.dataobjectCache == nil || !d.dataobjectCache.has(action, first)) {
+				storage, err = d.fetchDataobject(ctx, base, action, first)
+			}
+			if err != nil {
+				result <- decodedDataobject{action: action, err: err}
+				return
+			}
+		}
+
+		select {
+		case d.decoders <- struct{}{}:
+		case <-ctx.Done():
+			result <- decodedDataobject{action: action, err: ctx.Err()}
+			return
+		}
+		defer func() { <-d.decoders }()
+
+		if groups != nil {
+			rows, err := d.readRowGroups(ctx, action, groups, late, deleted, convert)
+			result <- decodedDataobject{action: action, rows: rows, err: err}
+			return
+		}
+
+		if late != nil && late.firstFamily != nil {
+			rows, unread, err := d.readFamiliesLate(ctx, storage, base, action, late, deleted, convert)
+			result <- decodedDataobject{action, rows, err, unread}
+			return
+		}
+
+		// Already decoded rows beat decoding only some of them.
+		if late != nil && (d.dataobjectCache == nil || !d.dataobjectCache.has(action, nil)) {
+			rows, err := d.readDataobjectLate(ctx, storage, action, late, deleted, convert)
+			result <- decodedDataobject{action: action, rows: rows, err: err}
+			return
+		}
+
+		o, err := d.readDataobjectFamilies(ctx, storage, action, wanted, convert)
+		if err != nil {
+			result <- decodedDataobject{action: action, err: err}
+			return
+		}
+
+		rows := &o.batch
+		if deleted != nil {
+			rows = rows.without(deleted)
+		}
+		result <- decodedDataobject{action: action, rows: rows}
+	}()
+
+	return result
+}
+
+// Starts reading the scan's next dataobjects, skipping ones (or
+// row groups of ones) its filter rules out, until as many are
+// pending as the client reads ahead, see prefetch.go.
+func (si *scanIterator) readAhead() {
+	for len(si.pending) < si.d.prefetchDepth() && si.dataobjectsPointer < len(si.dataobjects) {
+		action := si.dataobjects[si.dataobjectsPointer]
+		si.dataobjectsPointer++
+		if si.filter != nil && !si.d.mayMatch(si.table, action, si.filter) {
+			si.d.debug("scan", "skipping dataobject", "table", si.table, "dataobject", action.Name)
+			si.pruning.DataobjectsSkipped++
+			si.pruning.RowGroupsSkipped += len(action.RowGroups)
+			continue
+		}
+
+		var groups []int
+		if si.filter != nil {
+			groups = si.d.rowGroupsMayMatch(si.table, action, si.filter)
+			if groups != nil && len(groups) == 0 {
+				si.d.debug("scan", "skipping dataobject by its row groups", "table", si.table, "dataobject", action.Name)
+				si.pruning.DataobjectsSkipped++
+				si.pruning.RowGroupsSkipped += len(action.RowGroups)
+				continue
+			}
+		}
+
+		wanted := si.d.wantedFamilies(action, si.needed)
+		if groups == nil {
+			si.pruning.RowGroupsRead += len(action.RowGroups)
+			si.pruning.BytesAfterPruning += action.Bytes - skippedFamilyBytes(action, wanted)
+		} else {
+			si.pruning.RowGroupsRead += len(groups)
+			si.pruning.RowGroupsSkipped += len(action.RowGroups) - len(groups)
+			for _, i := range groups {
+				si.pruning.BytesAfterPruning += action.RowGroups[i].Length
+			}
+		}
+
+		si.d.tx.markDataobjectRead(action)
+		si.pending = append(si.pending, si.d.decodeAsync(si.ctx, action, groups, wanted, si.lateRead(action, wanted), si.deleted[action]))
+	}
+}
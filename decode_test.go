@@ -0,0 +1,112 @@
+package otf
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Tracks how many dataobjects are read at once.
+type concurrentReads struct {
+	objectStorage
+	mu      sync.Mutex
+	current int
+	most    int
+}
+
+func (cr *concurrentReads) read(ctx context.Context, name string) ([]byte, error) {
+	cr.mu.Lock()
+	cr.current++
+	cr.most = max(cr.most, cr.current)
+	cr.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+	defer func() {
+		cr.mu.Lock()
+		cr.current--
+		cr.mu.Unlock()
+	}()
+
+	return cr.objectStorage.read(ctx, name)
+}
+
+func TestDecodeParallelism(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	for i := 0; i < 20; i++ {
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+		if i == 0 {
+			err = c.createTable("x", []string{"i"})
+			assertEq(err, nil, "could not create x")
+		}
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+
+	for _, parallelism := range []int{0, 1, 4} {
+		cr := &concurrentReads{objectStorage: mos}
+		c := newClient(cr, withDecodeParallelism(parallelism))
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+		it, err := c.scan("x")
+		assertEq(err, nil, "could not scan")
+
+		// Rows still come back in order.
+		for i := 0; i < 20; i++ {
+			row, err := it.next()
+			assertEq(err, nil, "could not read row")
+			assertEq[any](row[0], float64(i), "row")
+		}
+		row, err := it.next()
+		assertEq(err, nil, "could not read row")
+		assert(row == nil, "no more rows")
+
+		assert(cr.most <= max(parallelism, 1), "too many concurrent reads")
+		if parallelism > 1 {
+			assert(cr.most > 1, "expected concurrent reads")
+		}
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	for i := 0; i < 20; i++ {
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+		if i == 0 {
+			err = c.createTable("x", []string{"i", "payload"}, withColumnFamilies([]string{"payload"}))
+			assertEq(err, nil, "could not create x")
+		}
+		err = c.writeRow("x", []any{i, "p"})
+		assertEq(err, nil, "could not write row")
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+
+	cr := &concurrentReads{objectStorage: mos}
+	c = newClient(cr, withDecodeParallelism(1), withPrefetch(4))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	it, err := c.scan("x")
+	assertEq(err, nil, "could not scan")
+	for i := 0; i < 20; i++ {
+		row, err := it.next()
+		assertEq(err, nil, "could not read row")
+		assertEq[any](row[0], float64(i), "row")
+		assertEq[any](row[1], "p", "family column")
+	}
+	row, err := it.next()
+	assertEq(err, nil, "could not read row")
+	assert(row == nil, "no more rows")
+
+	// Downloads aren't held to the one decoder.
+	assert(cr.most > 1 && cr.most <= 4, "concurrent reads")
+
+	// Families a scan leaves out aren't fetched for it.
+	assertEq(len(scanAll(&c, "x", withColumns("i"))), 20, "rows")
+}
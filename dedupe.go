@@ -0,0 +1,171 @@
+package otf
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Tables fed by at-least-once sources can be given a dedupe window:
+// rows whose key columns equal those of a row written earlier in
+// the same transaction, or committed less than the window ago, are
+// dropped rather than written. The window and key columns are
+// recorded in the table's ChangeMetadata actions like its
+// partitioning, so every client dedupes the same way, and key
+// columns can't be dropped or renamed.
+//
+// Each dataobject of such a table records a bloom filter (see
+// bloom.go) of its rows' keys. A row is checked against the filters
+// of dataobjects created within the window and only when one may
+// hold its key is that dataobject read, once per transaction, to
+// tell for sure. Rows count as written even if since deleted, and
+// rows written by concurrent transactions aren't seen.
+
+type DedupeConfig struct {
+	// Rows with equal values in these are duplicates.
+	Columns []string
+	// How long committed rows are remembered for.
+	Window time.Duration
+}
+
+var errDedupeColumn = fmt.Errorf("Dedupe Column")
+
+func withDedupe(window time.Duration, columns ...string) tableOption {
+	return func(o *tableOptions) {
+		o.dedupe = &DedupeConfig{columns, window}
+	}
+}
+
+// Deduplicating a table's writes in a transaction.
+type dedupeState struct {
+	// Keys written in the transaction.
+	keys map[string]bool
+	// Committed dataobjects created within the window, and the
+	// keys of those read so far by name.
+	recent []*DataobjectAction
+	read   map[string]map[string]bool
+	// Rows dropped.
+	dropped int
+}
+
+// Where the key columns are in table's rows.
+func (d *client) dedupeColumns(table string) ([]int, error) {
+	var positions []int
+	for _, column := range d.tx.dedupe[table].Columns {
+		i := slices.Index(d.tx.tables[table], column)
+		if i == -1 {
+			return nil, fmt.Errorf("%w: %s.%s", errNoColumn, table, column)
+		}
+		positions = append(positions, i)
+	}
+
+	return positions, nil
+}
+
+// Equal for rows with equal key values, whatever their Go types
+// after a round trip through storage.
+func dedupeKey(positions []int, row func(i int) any) string {
+	values := make([]any, len(positions))
+	for i, position := range positions {
+		values[i] = row(position)
+	}
+
+	key, err := json.Marshal(values)
+	assert(err == nil, fmt.Sprintf("could not marshal key: %s", err))
+	return string(key)
+}
+
+// The filter of rows' keys for a new dataobject of table, nil if the
+// table isn't deduped.
+func (d *client) dedupeFilter(table string, rows *batch) (*bloomFilter, error) {
+	if d.tx.dedupe[table] == nil {
+		return nil, nil
+	}
+
+	positions, err := d.dedupeColumns(table)
+	if err != nil {
+		return nil, err
+	}
+
+	bf := newBloomFilter(rows.Len)
+	for i := 0; i < rows.Len; i++ {
+		bf.add(dedupeKey(positions, func(j int) any { return rows.Columns[j][i] }))
+	}
+
+	return bf, nil
+}
+
+func (d *client) dedupeState(table string) *dedupeState {
+	state, ok := d.tx.dedupeStates[table]
+	if ok {
+		return state
+	}
+
+	state = &dedupeState{keys: map[string]bool{}, read: map[string]map[string]bool{}}
+	cutoff := time.Now().Add(-d.tx.dedupe[table].Window)
+	for _, action := range d.tx.previousActions[table] {
+		do := action.AddDataobject
+		if do != nil && do.KeyFilter != nil && do.Created != nil && do.Created.After(cutoff) {
+			state.recent = append(state.recent, do)
+		}
+	}
+
+	d.tx.dedupeStates[table] = state
+	return state
+}
+
+// Whether row, as checked by checkRow, duplicates one written earlier
+// in the transaction or committed within table's window. Remembers
+// it if not.
+func (d *client) isDuplicate(table string, row []any) (bool, error) {
+	positions, err := d.dedupeColumns(table)
+	if err != nil {
+		return false, err
+	}
+
+	state := d.dedupeState(table)
+	key := dedupeKey(positions, func(i int) any { return row[i] })
+	if state.keys[key] {
+		state.dropped++
+		return true, nil
+	}
+
+	for _, action := range state.recent {
+		if !action.KeyFilter.mayContain(key) {
+			continue
+		}
+
+		keys, ok := state.read[action.Name]
+		if !ok {
+			o, err := d.readDataobject(action)
+			if err != nil {
+				return false, err
+			}
+
+			keys = map[string]bool{}
+			for i := 0; i < o.Len; i++ {
+				keys[dedupeKey(positions, func(j int) any { return o.Columns[j][i] })] = true
+			}
+			state.read[action.Name] = keys
+		}
+
+		if keys[key] {
+			state.dropped++
+			return true, nil
+		}
+	}
+
+	state.keys[key] = true
+	return false, nil
+}
+
+// How many rows written to table in the transaction were dropped as
+// duplicates.
+func (d *client) dedupedRows(table string) int {
+	if d.tx == nil || d.tx.dedupeStates[table] == nil {
+		return 0
+	}
+
+	return d.tx.dedupeStates[table].dropped
+}
@@ -0,0 +1,89 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDedupe(t *testing.T) {
+	cr := &countingReads{objectStorage: newMemoryObjectStorage()}
+	c := newClient(cr)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"id", "source", "v"}, withDedupe(time.Hour, "id", "source"))
+	assertEq(err, nil, "could not create x")
+	for i := 0; i < 100; i++ {
+		err = c.writeRow("x", []any{i % 50, "a", i})
+		assertEq(err, nil, "could not write row")
+	}
+	assertEq(c.dedupedRows("x"), 50, "deduped in tx")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	cr.reads = 0
+	for i := 0; i < 100; i++ {
+		err = c.writeRow("x", []any{i, "a", i})
+		assertEq(err, nil, "could not write row")
+	}
+	assertEq(c.dedupedRows("x"), 50, "deduped against committed rows")
+	// The committed dataobject, once.
+	assertEq(cr.reads, 1, "reads")
+
+	// Different keys aren't duplicates.
+	err = c.writeRow("x", []any{1, "b", 1})
+	assertEq(err, nil, "could not write row")
+	assertEq(c.dedupedRows("x"), 50, "deduped")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	assertEq(countRows(&c, "x"), 101, "rows")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.dropColumn("x", "source")
+	assert(errors.Is(err, errDedupeColumn), fmt.Sprint("dropped key column: ", err))
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+}
+
+func TestDedupeWindow(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"id"}, withDedupe(time.Hour, "id"))
+	assertEq(err, nil, "could not create x")
+	err = c.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Pretend the window passed.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	c.tx.dedupe["x"] = &DedupeConfig{Columns: []string{"id"}, Window: 0}
+	err = c.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	assertEq(c.dedupedRows("x"), 0, "deduped outside the window")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	assertEq(countRows(&c, "x"), 2, "rows")
+}
+
+func TestBloomFilter(t *testing.T) {
+	bf := newBloomFilter(1000)
+	for i := 0; i < 1000; i++ {
+		bf.add(fmt.Sprint(i))
+	}
+
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		assert(bf.mayContain(fmt.Sprint(i)), "missing key")
+		if bf.mayContain(fmt.Sprint(-i - 1)) {
+			falsePositives++
+		}
+	}
+	assert(falsePositives < 30, fmt.Sprint("false positives: ", falsePositives))
+}
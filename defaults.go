@@ -0,0 +1,248 @@
+package otf
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Columns can have defaults, filled in by writeRow wherever a row
+// has useDefault instead of a value, and for the columns a row
+// leaves off its end. Rows of SQL INSERTs naming only some columns,
+// and JSON objects leaving some out, are partial in the same way. A
+// column without a default defaults to null. Defaults are, per
+// column, one of:
+//
+//   - a constant;
+//   - the transaction's time, the same for every row it writes;
+//   - the next value of the column's sequence, counting from 1;
+//   - an expression of the row's other columns (see expr.go), e.g.
+//     price * quantity, computed once the rest of the row is filled
+//     in. It can't refer to other columns computed from expressions.
+//
+// A generated column always gets its default: writing a value to it
+// fails with errColumnDefault.
+//
+// Sequences are persisted in the table's metadata: a transaction
+// that took values from one records where it got to with a
+// ChangeMetadata action when it commits. That action makes it
+// conflict (see conflict.go) with any concurrent transaction taking
+// values from the same table, so no value is handed out twice.
+//
+// Columns with defaults, or that expressions refer to, can't be
+// dropped or renamed.
+
+const SCHEMA_ADVANCE_SEQUENCES = "AdvanceSequences"
+
+var errColumnDefault = fmt.Errorf("Column Default")
+
+// Stands for a column's default in a row.
+type defaultMarker struct{}
+
+var useDefault = defaultMarker{}
+
+type ColumnDefault struct {
+	// A constant, unless one of the others is set.
+	Value any `json:",omitempty"`
+	// The transaction's time.
+	Now bool `json:",omitempty"`
+	// The next value of the column's sequence.
+	Sequence bool `json:",omitempty"`
+	// Computed from the row.
+	Expr *expr `json:",omitempty"`
+	// Rows can't give the column a value of their own.
+	Generated bool `json:",omitempty"`
+}
+
+// Mapping column name to its default.
+func withColumnDefaults(defaults map[string]ColumnDefault) tableOption {
+	return func(o *tableOptions) {
+		o.columnDefaults = defaults
+	}
+}
+
+// Columns e refers to.
+func (e *expr) columns() []string {
+	if e.Kind == EXPR_COLUMN {
+		return []string{e.Column}
+	}
+
+	var columns []string
+	for _, arg := range e.Args {
+		columns = append(columns, arg.columns()...)
+	}
+	return columns
+}
+
+func checkColumnDefaults(table string, columns, types []string, defaults map[string]ColumnDefault) error {
+	for _, column := range sortedKeys(defaults) {
+		def := defaults[column]
+		i := slices.Index(columns, column)
+		if i == -1 {
+			return fmt.Errorf("%w: %s.%s", errNoColumn, table, column)
+		}
+
+		kinds := 0
+		for _, set := range []bool{def.Value != nil, def.Now, def.Sequence, def.Expr != nil} {
+			if set {
+				kinds++
+			}
+		}
+		if kinds > 1 {
+			return fmt.Errorf("%w: %s.%s has more than one default", errColumnDefault, table, column)
+		}
+
+		if def.Sequence && types != nil && types[i] != COLUMN_ANY && types[i] != COLUMN_INT {
+			return fmt.Errorf("%w: %s.%s is %s, a sequence is int", errColumnDefault, table, column, types[i])
+		}
+
+		if def.Expr == nil {
+			continue
+		}
+		for _, ref := range def.Expr.columns() {
+			if !slices.Contains(columns, ref) {
+				return fmt.Errorf("%w: %s.%s", errNoColumn, table, ref)
+			}
+			if defaults[ref].Expr != nil {
+				return fmt.Errorf("%w: %s.%s refers to %s, also computed", errColumnDefault, table, column, ref)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Whether column of table has a default or one refers to it.
+func (tx *transaction) defaultColumn(table, column string) bool {
+	for name, def := range tx.columnDefaults[table] {
+		if name == column || (def.Expr != nil && slices.Contains(def.Expr.columns(), column)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The next value of table's sequence of column.
+func (tx *transaction) nextSequence(table, column string) int {
+	if tx.sequences[table] == nil {
+		tx.sequences[table] = map[string]int{}
+	}
+	tx.sequences[table][column]++
+
+	if !slices.Contains(tx.sequencesTaken, table) {
+		tx.sequencesTaken = append(tx.sequencesTaken, table)
+	}
+	return tx.sequences[table][column]
+}
+
+// Records where the sequences the transaction took values from got
+// to, before it commits.
+func (tx *transaction) recordSequences() {
+	for _, table := range tx.sequencesTaken {
+		history := tx.schemas[table]
+		latest := history[len(history)-1]
+		mtd := &ChangeMetadataAction{
+			Table:            table,
+			Columns:          latest.Columns,
+			PartitionColumns: tx.partitions[table],
+			Op:               SCHEMA_ADVANCE_SEQUENCES,
+			SchemaVersion:    history.version(),
+			ColumnTypes:      latest.Types,
+			Sequences:        tx.sequences[table],
+		}
+		if history.version() > 0 {
+			mtd.ColumnIds = latest.Ids
+		}
+		tx.Actions[table] = append(tx.Actions[table], Action{ChangeMetadata: mtd})
+	}
+
+	tx.sequencesTaken = nil
+}
+
+// Fills in row's defaults, padding it out to every column first.
+// Tables without defaults are left to checkRow.
+func (d *client) fillDefaults(table string, row []any) ([]any, error) {
+	defaults := d.tx.columnDefaults[table]
+	columns := d.tx.tables[table]
+	if len(defaults) == 0 && !slices.Contains(row, any(useDefault)) {
+		return row, nil
+	}
+
+	if len(row) > len(columns) {
+		return nil, fmt.Errorf("%w: expected %d columns, got %d", errInvalidRow, len(columns), len(row))
+	}
+	filled := make([]any, len(columns))
+	copy(filled, row)
+	for i := len(row); i < len(columns); i++ {
+		filled[i] = useDefault
+	}
+
+	var computed []int
+	for i, column := range columns {
+		def, ok := defaults[column]
+		if filled[i] != useDefault {
+			if ok && def.Generated {
+				return nil, fmt.Errorf("%w: %s.%s is generated", errColumnDefault, table, column)
+			}
+			continue
+		}
+
+		switch {
+		case !ok:
+			filled[i] = nil
+		case def.Now:
+			if d.tx.now.IsZero() {
+				d.tx.now = time.Now().UTC()
+			}
+			filled[i] = d.tx.now
+		case def.Sequence:
+			filled[i] = d.tx.nextSequence(table, column)
+		case def.Expr != nil:
+			computed = append(computed, i)
+		default:
+			filled[i] = def.Value
+		}
+	}
+
+	return filled, d.computeDefaults(table, filled, computed)
+}
+
+// Computes the expression defaults of the columns at positions in
+// row from the rest of it.
+func (d *client) computeDefaults(table string, row []any, positions []int) error {
+	if positions == nil {
+		return nil
+	}
+
+	columns := d.tx.tables[table]
+	b := newBatch(len(columns))
+	b.appendRow(row)
+	for _, i := range positions {
+		e := d.tx.columnDefaults[table][columns[i]].Expr
+		folded, err := e.fold(d.functions)
+		if err != nil {
+			return fmt.Errorf("%w: %s.%s: %w", errColumnDefault, table, columns[i], err)
+		}
+		compiled, err := folded.compile(d, table)
+		if err != nil {
+			return fmt.Errorf("%w: %s.%s: %w", errColumnDefault, table, columns[i], err)
+		}
+		row[i] = compiled(nil)(b, 0)
+	}
+
+	return nil
+}
+
+// Positions of table's generated columns computed from expressions,
+// which updates recompute.
+func (tx *transaction) generatedExprColumns(table string) []int {
+	var positions []int
+	for i, column := range tx.tables[table] {
+		if def, ok := tx.columnDefaults[table][column]; ok && def.Generated && def.Expr != nil {
+			positions = append(positions, i)
+		}
+	}
+
+	return positions
+}
@@ -0,0 +1,114 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newDefaultsClient(mos *memoryObjectStorage) client {
+	c := newClient(mos, withCheckpointInterval(2))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("items", []string{"id", "name", "price", "qty", "total", "created", "status"}, withColumnDefaults(map[string]ColumnDefault{
+		"id":      {Sequence: true, Generated: true},
+		"total":   {Expr: opExpr(OP_MUL, colExpr("price"), colExpr("qty")), Generated: true},
+		"created": {Now: true},
+		"status":  {Value: "new"},
+	}), withPartitionColumns("name"))
+	assertEq(err, nil, "could not create items")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	return c
+}
+
+func TestColumnDefaults(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newDefaultsClient(mos)
+
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("items", []any{useDefault, "a", 2, 3})
+	assertEq(err, nil, "could not write a partial row")
+	err = c.writeRow("items", []any{useDefault, "b", 1.5, 2, useDefault, useDefault, "old"})
+	assertEq(err, nil, "could not write a row with defaults")
+	err = c.writeRow("items", []any{7, "c", 1, 1})
+	assert(errors.Is(err, errColumnDefault), "wrote a generated column")
+	rows := scanAll(&c, "items")
+	assertEq(len(rows), 2, "rows")
+	assertEq(fmt.Sprint(rows[0][:5], rows[0][6]), "[1 a 2 3 6]new", "first row")
+	assertEq(fmt.Sprint(rows[1][:5], rows[1][6]), "[2 b 1.5 2 3]old", "second row")
+	created, ok := rows[0][5].(time.Time)
+	assert(ok, "created isn't a time")
+	assertEq(rows[1][5], any(created), "created differs within a transaction")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Sequences carry on in later transactions, through checkpoints.
+	for _, name := range []string{"d", "e"} {
+		c = newClient(mos, withCheckpointInterval(2))
+		err = c.newTx()
+		assertEq(err, nil, "could not start tx")
+		err = c.writeRow("items", []any{useDefault, name, 1, 1})
+		assertEq(err, nil, "could not write row")
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	ids := []any{}
+	for _, row := range scanAll(&c, "items") {
+		ids = append(ids, row[0])
+	}
+	assertEq(fmt.Sprint(ids), "[1 2 3 4]", "ids")
+
+	// Updates recompute generated columns and can't set them.
+	n, err := c.updateRows("items", where("name", OP_EQ, "a"), map[string]*expr{"qty": litExpr(10)})
+	assertEq(err, nil, "could not update")
+	assertEq(n, 1, "updated")
+	rows = scanAll(&c, "items", withFilter(where("name", OP_EQ, "a")))
+	assertEq(fmt.Sprint(rows[0][4]), "20", "recomputed total")
+	_, err = c.updateRows("items", where("name", OP_EQ, "a"), map[string]*expr{"total": litExpr(1)})
+	assert(errors.Is(err, errColumnDefault), "updated a generated column")
+	err = c.dropColumn("items", "price")
+	assert(errors.Is(err, errColumnDefault), "dropped a column a default refers to")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+}
+
+func TestSequenceConflict(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c1 := newDefaultsClient(mos)
+	c2 := newClient(mos)
+
+	// Adding to different partitions doesn't conflict, unless both
+	// take values from the same sequence.
+	for i, c := range []*client{&c1, &c2} {
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+		err = c.writeRow("items", []any{useDefault, fmt.Sprint(i), 1, 1})
+		assertEq(err, nil, "could not write row")
+	}
+	err := c1.commitTx()
+	assertEq(err, nil, "could not commit")
+	err = c2.commitTx()
+	assert(errors.Is(err, errConflict), "took the same sequence value twice")
+}
+
+func TestSQLDefaults(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	_, err := c.execSQL("CREATE TABLE t (id INT AUTOINCREMENT, name TEXT NOT NULL, status DEFAULT 'new', at TIMESTAMP DEFAULT NOW())")
+	assertEq(err, nil, "could not create t")
+	_, err = c.execSQL("INSERT INTO t (name) VALUES ('x')")
+	assertEq(err, nil, "could not insert")
+	_, err = c.execSQL("INSERT INTO t VALUES (DEFAULT, 'y', 'old', DEFAULT)")
+	assertEq(err, nil, "could not insert")
+	result, err := c.execSQL("SELECT id, name, status FROM t")
+	assertEq(err, nil, "could not select")
+	assertEq(fmt.Sprint(result.Rows), "[[1 x new] [2 y old]]", "rows")
+	result, err = c.execSQL("SELECT at FROM t")
+	assertEq(err, nil, "could not select")
+	_, ok := result.Rows[1][0].(time.Time)
+	assert(ok, "at isn't a time")
+}
@@ -0,0 +1,146 @@
+package otf
+
+import (
+	"slices"
+)
+
+// Deleting rows leaves dataobjects as they are. Instead a
+// DeleteAction records which rows of a dataobject are gone, by
+// position, and scans skip them. Deleting rows of a dataobject more
+// than once adds to its deleted rows.
+//
+// Deterministic names (see naming.go) mean a dataobject may be added
+// more than once, and added again after rows of it were deleted. So
+// a DeleteAction only applies to the dataobject's adds before it, as
+// a RemoveAction does (see liveActions), and rows inserted again
+// after being deleted are seen again.
+type DeleteAction struct {
+	Table string
+	// The dataobject rows were deleted from.
+	Name string
+	Rows []int
+}
+
+// Mapping each add of a dataobject to its deleted rows, as of
+// actions.
+func deletedRows(actions []Action) map[*DataobjectAction]map[int]bool {
+	deleted := map[*DataobjectAction]map[int]bool{}
+	added := map[string][]*DataobjectAction{}
+	for _, action := range actions {
+		if do := action.AddDataobject; do != nil {
+			added[do.Name] = append(added[do.Name], do)
+		}
+		if action.DeleteRows == nil {
+			continue
+		}
+
+		for _, do := range added[action.DeleteRows.Name] {
+			rows, ok := deleted[do]
+			if !ok {
+				rows = map[int]bool{}
+				deleted[do] = rows
+			}
+
+			for _, row := range action.DeleteRows.Rows {
+				rows[row] = true
+			}
+		}
+	}
+
+	return deleted
+}
+
+// The rows of b not in deleted, sharing nothing with b's column
+// slices.
+func (b *batch) without(deleted map[int]bool) *batch {
+	return b.filter(func(_ *batch, i int) bool {
+		return !deleted[i]
+	})
+}
+
+// Deletes the rows of table matching p, all of them if p is nil,
+// both ones committed before and ones written in this transaction.
+// Returns how many rows were deleted.
+func (d *client) deleteRows(table string, p *predicate) (int, error) {
+	return d.deleteRowsFunc(table, p, nil)
+}
+
+// Like deleteRows, but only deleting rows matching p that also, if
+// not nil, returns true for. It's called at most once per row, so it
+// may count them (see sync.go).
+func (d *client) deleteRowsFunc(table string, p *predicate, also func(b *batch, i int) bool) (int, error) {
+	if err := d.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	if _, ok := d.tx.tables[table]; !ok {
+		return 0, errNoTable
+	}
+
+	p, err := d.restrictRows(table, p)
+	if err != nil {
+		return 0, err
+	}
+
+	matches := func(*batch, int) bool { return true }
+	if p != nil {
+		matches, err = p.bind(d, table)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if also != nil {
+		bound := matches
+		matches = func(b *batch, i int) bool {
+			return bound(b, i) && also(b, i)
+		}
+	}
+
+	n := 0
+	if rows, ok := d.tx.unflushedData[table]; ok && rows.Len > 0 {
+		kept := rows.filter(func(b *batch, i int) bool {
+			return !matches(b, i)
+		})
+
+		// Scans only ever hold slices of the old batch.
+		d.tx.unflushedData[table] = kept
+		n += rows.Len - kept.Len
+	}
+
+	d.tx.markRead(table)
+	actions := liveActions(slices.Concat(d.tx.previousActions[table], d.tx.Actions[table]))
+	deleted := deletedRows(actions)
+	for _, action := range actions {
+		if action.AddDataobject == nil || (p != nil && !d.mayMatch(table, action.AddDataobject, p)) {
+			continue
+		}
+
+		o, err := d.readDataobject(action.AddDataobject)
+		if err != nil {
+			return 0, err
+		}
+
+		var rows []int
+		for i := 0; i < o.Len; i++ {
+			if !deleted[action.AddDataobject][i] && matches(&o.batch, i) {
+				rows = append(rows, i)
+			}
+		}
+
+		if len(rows) == 0 {
+			continue
+		}
+
+		d.tx.Actions[table] = append(d.tx.Actions[table], Action{
+			DeleteRows: &DeleteAction{
+				Table: table,
+				Name:  action.AddDataobject.Name,
+				Rows:  rows,
+			},
+		})
+		n += len(rows)
+	}
+
+	d.debug("delete", "deleted rows", "table", table, "rows", n)
+	return n, nil
+}
@@ -0,0 +1,165 @@
+package otf
+
+import (
+	"testing"
+)
+
+func TestDeleteRows(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	cached := newClient(mos, withTableCache(100))
+
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"name", "age"})
+	assertEq(err, nil, "could not create x")
+	for i := 0; i < 10; i++ {
+		err = c.writeRow("x", []any{"Joey", i})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	assertEq(countRows(&cached, "x"), 10, "rows in x")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{"Yue", 10})
+	assertEq(err, nil, "could not write row")
+	n, err := c.deleteRows("x", or(where("age", OP_LT, 3), where("age", OP_GTE, 10)))
+	assertEq(err, nil, "could not delete")
+	assertEq(n, 4, "deleted rows")
+
+	// Already deleted.
+	n, err = c.deleteRows("x", where("age", OP_LT, 3))
+	assertEq(err, nil, "could not delete")
+	assertEq(n, 0, "deleted rows")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	assertEq(countRows(&c, "x"), 7, "rows in x")
+	assertEq(countRows(&cached, "x"), 7, "rows in cached x")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	n, err = c.deleteRows("x", where("age", OP_EQ, 5))
+	assertEq(err, nil, "could not delete")
+	assertEq(n, 1, "deleted rows")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	assertEq(countRows(&c, "x"), 6, "rows in x")
+
+	changes, err := c.tableChangesSince("x", 0)
+	assertEq(err, nil, "could not read changes")
+	assertEq(len(changes), 2, "transactions with changes")
+	assertEq(len(changes[0]), 3, "committed rows deleted")
+	assertEq(changes[1][0].Op, CHANGE_DELETE, "change")
+	assertEq(changes[1][0].Row[1], 5.0, "deleted row")
+}
+
+func TestDeleteRowsInCachedTable(t *testing.T) {
+	c := newClient(newMemoryObjectStorage(), withTableCache(100))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"name", "age"})
+	assertEq(err, nil, "could not create x")
+	for i := 0; i < 3; i++ {
+		err = c.writeRow("x", []any{"Joey", i})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	assertEq(countRows(&c, "x"), 3, "rows in x")
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	n, err := c.deleteRows("x", where("age", OP_EQ, 1))
+	assertEq(err, nil, "could not delete")
+	assertEq(n, 1, "deleted rows")
+
+	// The transaction's own deletes aren't in the cache yet.
+	it, err := c.scan("x")
+	assertEq(err, nil, "could not scan")
+	seen := 0
+	for {
+		row, err := it.next()
+		assertEq(err, nil, "could not iterate scan")
+		if row == nil {
+			break
+		}
+		seen++
+	}
+	assertEq(seen, 2, "rows in x after delete")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	assertEq(countRows(&c, "x"), 2, "rows in x after commit")
+}
+
+func TestDeleteRowsContentHashNamed(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos, withNaming(contentHashNaming{}))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Written again after being deleted, to the same dataobject.
+	writeTx(&c, "x", []any{1, "a"}, []any{2, "a"})
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	n, err := c.deleteRows("x", where("a", OP_EQ, 1))
+	assertEq(err, nil, "could not delete")
+	assertEq(n, 1, "deleted rows")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	writeTx(&c, "x", []any{1, "a"}, []any{2, "a"})
+	assertEq(countRows(&c, "x"), 3, "rows after writing deleted rows again")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	n, err = c.updateRows("x", where("a", OP_EQ, 2), map[string]*expr{"b": litExpr("b")})
+	assertEq(err, nil, "could not update")
+	assertEq(n, 2, "updated rows")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	rows := scanAll(&c, "x")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+	assertEq(len(rows), 3, "rows after update")
+	updated := 0
+	for _, row := range rows {
+		if row[1] == "b" {
+			updated++
+		}
+	}
+	assertEq(updated, 2, "updated rows seen")
+
+	// Compacting one add of a dataobject compacts them all.
+	writeTx(&c, "x", []any{3, "a"})
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.deleteRows("x", where("a", OP_EQ, 3))
+	assertEq(err, nil, "could not delete")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	writeTx(&c, "x", []any{3, "a"})
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.compact("x")
+	assertEq(err, nil, "could not compact")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	assertEq(countRows(&c, "x"), 4, "rows after compaction")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	receipt, err := c.purge("x", where("a", OP_EQ, 2), false)
+	assertEq(err, nil, "could not purge")
+	assertEq(receipt.Rows, 2, "purged rows")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	assertEq(countRows(&c, "x"), 2, "rows after purge")
+}
@@ -0,0 +1,425 @@
+package otf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A store can keep its log in the layout of a Delta Lake table
+// rather than otf's own, so Delta readers (Spark, delta-rs, DuckDB,
+// ...) can read it directly:
+//
+// https://github.com/delta-io/delta/blob/master/PROTOCOL.md
+//
+// Transaction n commits as _delta_log/<n>.json, Delta's version n,
+// holding the protocol, metaData, add and remove actions Delta
+// readers replay. The otf log entry (see logentry.go) goes in the
+// commitInfo action, which Delta readers ignore and otf replays
+// exactly as its own log.
+//
+// Delta readers only read Parquet, so every dataobject also gets a
+// Parquet copy (see parquet.go) at the root of the store named after
+// it, which is what add actions point to. Deleting rows from a
+// dataobject rewrites its copy without them. Untyped columns (see
+// types.go) are Delta strings holding JSON, timestamp columns RFC
+// 3339 strings. Partitioned tables aren't partitioned as far as
+// Delta knows.
+//
+// A Delta table is one table, so a store with a Delta log holds only
+// one, and Delta only follows renamed or dropped columns with column
+// mapping, so columns can only be added. Delta checkpoints aren't
+// written, though otf's are (see checkpoint.go). Vacuum (see
+// vacuum.go) leaves Parquet copies be but purging (see purge.go)
+// deletes them along with their dataobjects.
+//
+// Every client of a store must use the same log format.
+
+const (
+	LOG_FORMAT_OTF   = "otf"
+	LOG_FORMAT_DELTA = "delta"
+)
+
+const DELTA_LOG_PREFIX = "_delta_log/"
+
+var errDeltaLog = fmt.Errorf("Unsupported By Delta Log")
+
+func withDeltaLog() clientOption {
+	return func(c *client) {
+		c.logFormat = LOG_FORMAT_DELTA
+	}
+}
+
+func deltaLogEntryName(id int) string {
+	return fmt.Sprintf("%s%020d.json", DELTA_LOG_PREFIX, id)
+}
+
+func (d *client) logPrefix() string {
+	if d.logFormat == LOG_FORMAT_DELTA {
+		return DELTA_LOG_PREFIX
+	}
+
+	return "_log_"
+}
+
+// The name transaction id commits as.
+func (d *client) logName(id int) string {
+	if d.logFormat == LOG_FORMAT_DELTA {
+		return deltaLogEntryName(id)
+	}
+	if d.branch != "" {
+		return branchLogPrefix(d.branch) + logEntryName(id)
+	}
+
+	return logEntryName(id)
+}
+
+// The names of every committed log entry in order, leaving out
+// anything else under the log's prefix, e.g. Delta checkpoints
+// written by other tools, and entries since coalesced (see
+// coalesce.go). On a branch, main's entries from before it started
+// followed by its own, see branch.go.
+func (d *client) listLog() ([]string, error) {
+	names, err := d.os.listPrefix(d.context(), d.logPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	names, err = dropCoalesced(slices.DeleteFunc(names, func(name string) bool {
+		_, ok := parseLogEntryId(name)
+		return !ok
+	}))
+	if err != nil || d.branch == "" {
+		return names, err
+	}
+
+	return d.listBranchedLog(names)
+}
+
+// The index in names of the log entry of transaction id, or -1.
+func logEntryIndex(names []string, id int) int {
+	return slices.IndexFunc(names, func(name string) bool {
+		return logEntryId(name) == id
+	})
+}
+
+type deltaAction struct {
+	CommitInfo *deltaCommitInfo `json:"commitInfo,omitempty"`
+	Protocol   *deltaProtocol   `json:"protocol,omitempty"`
+	MetaData   *deltaMetaData   `json:"metaData,omitempty"`
+	Add        *deltaAdd        `json:"add,omitempty"`
+	Remove     *deltaRemove     `json:"remove,omitempty"`
+}
+
+type deltaCommitInfo struct {
+	// Milliseconds since the epoch, like every Delta timestamp.
+	Timestamp  int64           `json:"timestamp"`
+	Operation  string          `json:"operation"`
+	EngineInfo string          `json:"engineInfo"`
+	Otf        json.RawMessage `json:"otf,omitempty"`
+}
+
+type deltaProtocol struct {
+	MinReaderVersion int `json:"minReaderVersion"`
+	MinWriterVersion int `json:"minWriterVersion"`
+}
+
+type deltaFormat struct {
+	Provider string            `json:"provider"`
+	Options  map[string]string `json:"options"`
+}
+
+type deltaMetaData struct {
+	Id               string            `json:"id"`
+	Name             string            `json:"name"`
+	Format           deltaFormat       `json:"format"`
+	SchemaString     string            `json:"schemaString"`
+	PartitionColumns []string          `json:"partitionColumns"`
+	Configuration    map[string]string `json:"configuration"`
+	CreatedTime      int64             `json:"createdTime"`
+}
+
+type deltaAdd struct {
+	Path             string            `json:"path"`
+	PartitionValues  map[string]string `json:"partitionValues"`
+	Size             int64             `json:"size"`
+	ModificationTime int64             `json:"modificationTime"`
+	DataChange       bool              `json:"dataChange"`
+	Stats            string            `json:"stats,omitempty"`
+}
+
+type deltaRemove struct {
+	Path              string `json:"path"`
+	DeletionTimestamp int64  `json:"deletionTimestamp"`
+	DataChange        bool   `json:"dataChange"`
+}
+
+type deltaSchemaField struct {
+	Name     string         `json:"name"`
+	Type     string         `json:"type"`
+	Nullable bool           `json:"nullable"`
+	Metadata map[string]any `json:"metadata"`
+}
+
+type deltaSchema struct {
+	Type   string             `json:"type"`
+	Fields []deltaSchemaField `json:"fields"`
+}
+
+// The Parquet and Delta types of a column of type t.
+func deltaColumnType(t string) (arrowType, string) {
+	switch t {
+	case COLUMN_INT:
+		return arrowInt64, "long"
+	case COLUMN_FLOAT:
+		return arrowFloat64, "double"
+	case COLUMN_BOOL:
+		return arrowBool, "boolean"
+	}
+
+	return arrowUtf8, "string"
+}
+
+// The Parquet fields and Delta schema of the latest version of
+// table's schema.
+func (tx *transaction) deltaSchema(table string) ([]arrowField, deltaSchema) {
+	history := tx.schemas[table]
+	latest := history[len(history)-1]
+
+	var fields []arrowField
+	schema := deltaSchema{Type: "struct"}
+	for i, column := range latest.Columns {
+		typ := COLUMN_ANY
+		if latest.Types != nil {
+			typ = latest.Types[i]
+		}
+
+		at, dt := deltaColumnType(typ)
+		fields = append(fields, arrowField{column, at})
+		schema.Fields = append(schema.Fields, deltaSchemaField{column, dt, true, map[string]any{}})
+	}
+
+	return fields, schema
+}
+
+// Where the Parquet copy of the dataobject name is once deleted rows
+// have been deleted from it, relative to the root of the store.
+func deltaDataPath(name string, deleted int) string {
+	if deleted == 0 {
+		return name + ".parquet"
+	}
+
+	return fmt.Sprintf("%s-%d.parquet", name, deleted)
+}
+
+// Writes the Parquet copy of rows of table to path.
+func (d *client) writeDeltaFile(table, path string, rows *batch) error {
+	fields, _ := d.tx.deltaSchema(table)
+	copied := &batch{Columns: make([][]any, len(rows.Columns)), Len: rows.Len}
+	for i, column := range rows.Columns {
+		copied.Columns[i] = make([]any, len(column))
+		for j, v := range column {
+			if t, ok := v.(time.Time); ok {
+				v = t.UTC().Format(time.RFC3339Nano)
+			}
+			copied.Columns[i][j] = v
+		}
+	}
+
+	bytes, err := encodeParquetFile(fields, []*batch{copied})
+	if err != nil {
+		return err
+	}
+
+	err = d.os.putIfAbsent(d.context(), path, bytes)
+	if err != nil {
+		return err
+	}
+
+	d.tx.written = append(d.tx.written, path)
+	d.tx.deltaFiles[path] = int64(len(bytes))
+	return nil
+}
+
+// Deletes every Parquet copy of the dataobject name.
+func (d *client) deleteDeltaFiles(name string) error {
+	paths, err := d.os.listPrefix(d.context(), name)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		suffix := strings.TrimPrefix(path, name)
+		if suffix != ".parquet" && !(strings.HasPrefix(suffix, "-") && strings.HasSuffix(suffix, ".parquet")) {
+			continue
+		}
+
+		err = d.os.delete(d.context(), path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The Delta actions for the transaction's changes, besides
+// commitInfo. Writes the Parquet copies of dataobjects it deleted
+// rows from.
+func (d *client) deltaActions(at time.Time) ([]deltaAction, error) {
+	now := at.UnixMilli()
+	var actions []deltaAction
+	for _, table := range sortedKeys(d.tx.Actions) {
+		current := d.tx.Actions[table]
+		previous := d.tx.previousActions[table]
+
+		_, committed := d.tx.tableVersions[table]
+		changed := false
+		dataobjects := map[string]*DataobjectAction{}
+		added := map[string]bool{}
+		removed := map[string]bool{}
+		// Dataobjects changed, in the order first changed.
+		var names []string
+		for _, action := range previous {
+			if action.AddDataobject != nil {
+				dataobjects[action.AddDataobject.Name] = action.AddDataobject
+			}
+		}
+		for _, action := range current {
+			name := ""
+			switch {
+			case action.ChangeMetadata != nil:
+				changed = true
+			case action.AddDataobject != nil:
+				name = action.AddDataobject.Name
+				dataobjects[name] = action.AddDataobject
+				added[name] = true
+			case action.DeleteRows != nil:
+				name = action.DeleteRows.Name
+			case action.RemoveDataobject != nil:
+				name = action.RemoveDataobject.Name
+				removed[name] = true
+			}
+			if name != "" && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+
+		if !committed {
+			actions = append(actions, deltaAction{Protocol: &deltaProtocol{1, 2}})
+		}
+		if changed {
+			_, schema := d.tx.deltaSchema(table)
+			schemaString, err := json.Marshal(schema)
+			if err != nil {
+				return nil, err
+			}
+
+			actions = append(actions, deltaAction{MetaData: &deltaMetaData{
+				Id:               "otf-" + table,
+				Name:             table,
+				Format:           deltaFormat{"parquet", map[string]string{}},
+				SchemaString:     string(schemaString),
+				PartitionColumns: []string{},
+				Configuration:    map[string]string{},
+				CreatedTime:      now,
+			}})
+		}
+
+		before := deletedRows(previous)
+		after := deletedRows(append(slices.Clone(previous), current...))
+		for _, name := range names {
+			do := dataobjects[name]
+			oldPath := deltaDataPath(name, len(before[do]))
+			newPath := deltaDataPath(name, len(after[do]))
+			if !added[name] && (removed[name] || newPath != oldPath) {
+				actions = append(actions, deltaAction{Remove: &deltaRemove{deltaURI(oldPath), now, true}})
+			}
+			if removed[name] {
+				continue
+			}
+
+			rows := do.Rows - len(after[do])
+			if newPath != oldPath && rows > 0 {
+				o, err := d.readDataobject(do)
+				if err != nil {
+					return nil, err
+				}
+
+				err = d.writeDeltaFile(table, newPath, o.without(after[do]))
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			if rows > 0 {
+				actions = append(actions, deltaAction{Add: &deltaAdd{
+					Path:             deltaURI(newPath),
+					PartitionValues:  map[string]string{},
+					Size:             d.tx.deltaFiles[newPath],
+					ModificationTime: now,
+					DataChange:       true,
+					Stats:            fmt.Sprintf(`{"numRecords":%d}`, rows),
+				}})
+			}
+		}
+	}
+
+	return actions, nil
+}
+
+// Delta paths are relative URIs.
+func deltaURI(path string) string {
+	return (&url.URL{Path: path}).EscapedPath()
+}
+
+// The Delta log entry wrapping the otf log entry entry.
+func (d *client) deltaLogEntry(entry []byte, actions []deltaAction) ([]byte, error) {
+	info := deltaAction{CommitInfo: &deltaCommitInfo{
+		Timestamp:  d.tx.CommitInfo.time().UnixMilli(),
+		Operation:  "WRITE",
+		EngineInfo: "otf",
+		Otf:        entry,
+	}}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	// Escaping would change the bytes of entry, which its checksum
+	// is of, see checksum.go.
+	enc.SetEscapeHTML(false)
+	for _, action := range append([]deltaAction{info}, actions...) {
+		err := enc.Encode(action)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// The otf log entry in a Delta log entry.
+func deltaOtfEntry(name string, entry []byte) ([]byte, error) {
+	for _, line := range bytes.Split(entry, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var action deltaAction
+		err := json.Unmarshal(line, &action)
+		if err != nil {
+			return nil, err
+		}
+
+		if action.CommitInfo != nil && len(action.CommitInfo.Otf) > 0 {
+			return action.CommitInfo.Otf, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s wasn't committed by otf", errDeltaLog, name)
+}
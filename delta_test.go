@@ -0,0 +1,128 @@
+package otf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func readDeltaEntry(store objectStorage, id int) []deltaAction {
+	raw, err := store.read(context.Background(), deltaLogEntryName(id))
+	assertEq(err, nil, "could not read delta entry")
+
+	var actions []deltaAction
+	for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\n") {
+		var action deltaAction
+		err = json.Unmarshal([]byte(line), &action)
+		assertEq(err, nil, "could not decode delta action")
+		actions = append(actions, action)
+	}
+
+	return actions
+}
+
+func TestDeltaLog(t *testing.T) {
+	store := newMemoryObjectStorage()
+	c := newClient(store, withDeltaLog())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b"}, withColumnTypes(map[string]string{"a": COLUMN_INT}))
+	assertEq(err, nil, "could not create x")
+	for i := 0; i < 3; i++ {
+		err = c.writeRow("x", []any{i, fmt.Sprint(i)})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	names, err := store.listPrefix(context.Background(), "_log_")
+	assertEq(err, nil, "could not list")
+	assertEq(len(names), 0, "wrote an otf log entry")
+
+	actions := readDeltaEntry(store, 0)
+	assertEq(len(actions), 4, "wrong number of actions")
+	assertEq(actions[0].CommitInfo.EngineInfo, "otf", "first action isn't commitInfo")
+	assertEq(actions[1].Protocol.MinReaderVersion, 1, "no protocol")
+	assertEq(actions[2].MetaData.SchemaString, `{"type":"struct","fields":[{"name":"a","type":"long","nullable":true,"metadata":{}},{"name":"b","type":"string","nullable":true,"metadata":{}}]}`, "wrong schema")
+	add := actions[3].Add
+	assertEq(add.Stats, `{"numRecords":3}`, "wrong stats")
+	parquet, err := store.read(context.Background(), add.Path)
+	assertEq(err, nil, "could not read parquet copy")
+	assert(bytes.HasPrefix(parquet, []byte("PAR1")), "copy isn't parquet")
+	assertEq(add.Size, int64(len(parquet)), "wrong size")
+
+	// Deleting rewrites the copy.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.deleteRows("x", where("a", OP_EQ, 1))
+	assertEq(err, nil, "could not delete")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	actions = readDeltaEntry(store, 1)
+	assertEq(len(actions), 3, "wrong number of actions")
+	assertEq(actions[1].Remove.Path, add.Path, "didn't remove the old copy")
+	assertEq(actions[2].Add.Path, strings.TrimSuffix(add.Path, ".parquet")+"-1.parquet", "wrong new copy")
+	assertEq(actions[2].Add.Stats, `{"numRecords":2}`, "wrong stats")
+
+	// And otf reads its own entries back.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	rows := scanAll(&c, "x")
+	assertEq(fmt.Sprint(rows), "[[0 0] [2 2]]", "wrong rows")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+}
+
+func TestDeltaLogLimits(t *testing.T) {
+	c := newClient(newMemoryObjectStorage(), withDeltaLog())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b"})
+	assertEq(err, nil, "could not create x")
+
+	err = c.createTable("y", []string{"a"})
+	assert(errors.Is(err, errDeltaLog), "created a second table")
+	err = c.dropColumn("x", "b")
+	assert(errors.Is(err, errDeltaLog), "dropped a column")
+	err = c.renameColumn("x", "b", "c")
+	assert(errors.Is(err, errDeltaLog), "renamed a column")
+	err = c.addColumn("x", "c")
+	assertEq(err, nil, "could not add a column")
+}
+
+func TestDeltaLogFileStorage(t *testing.T) {
+	store := newFileObjectStorage(t.TempDir())
+	c := newClient(store, withDeltaLog())
+	for i := 0; i < 2; i++ {
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+		if i == 0 {
+			err = c.createTable("x", []string{"a"})
+			assertEq(err, nil, "could not create x")
+		}
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+
+	// Left by other Delta writers.
+	err := store.putIfAbsent(context.Background(), DELTA_LOG_PREFIX+"_last_checkpoint", []byte(`{"version":1}`))
+	assertEq(err, nil, "could not write _last_checkpoint")
+
+	names, err := c.listLog()
+	assertEq(err, nil, "could not list log")
+	assertEq(fmt.Sprint(names), fmt.Sprintf("[%s %s]", deltaLogEntryName(0), deltaLogEntryName(1)), "wrong log")
+	assertEq(countRows(&c, "x"), 2, "wrong rows")
+
+	// Entries otf didn't commit can't be replayed.
+	err = store.putIfAbsent(context.Background(), deltaLogEntryName(2), []byte(`{"commitInfo":{"timestamp":0,"operation":"WRITE","engineInfo":"spark"}}`+"\n"))
+	assertEq(err, nil, "could not write entry")
+	err = c.newTx()
+	assert(errors.Is(err, errDeltaLog), "replayed a foreign entry")
+}
@@ -0,0 +1,212 @@
+package otf
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Stores in shared buckets can be encrypted client-side, so that
+// neither the log nor dataobjects are ever stored in plaintext and
+// whoever can read the bucket can't read the store without the keys
+// too. Every object is encrypted on its own with AES-GCM, with the
+// object's name as additional data so one can't be passed off as
+// another. Objects start with a header recording the id of the key
+// they were encrypted with:
+//
+//	OTFE, a version byte (1), the id's length as a byte, the id,
+//	the 12 byte nonce, then the sealed bytes
+//
+// Keys come from a key provider, e.g. a KMS. New objects are
+// encrypted with its current key and read back with whichever key
+// they record, so keys can be rotated without rewriting anything.
+// Reading an object that isn't encrypted, or was tampered with,
+// fails with errDecrypt.
+//
+// Dataobjects of tables stored elsewhere (see credentials.go) are
+// encrypted with the store's keys too.
+//
+// Range reads (see rowgroup.go) read, and decrypt, whole objects.
+// Listing and deleting aren't affected: names aren't encrypted.
+
+var errDecrypt = fmt.Errorf("Could Not Decrypt")
+
+const ENCRYPTION_MAGIC = "OTFE"
+
+// Where an encrypted store's keys come from. Keys are 16, 24 or 32
+// bytes, for AES-128, AES-192 or AES-256.
+type KeyProvider interface {
+	// The key new objects are encrypted with, and its id.
+	CurrentKey() (id string, key []byte, err error)
+	// The key with id, for objects encrypted with it.
+	Key(id string) ([]byte, error)
+}
+
+// Keys held in memory.
+type staticKeyProvider struct {
+	keys    map[string][]byte
+	current string
+}
+
+func (skp staticKeyProvider) CurrentKey() (string, []byte, error) {
+	key, err := skp.Key(skp.current)
+	return skp.current, key, err
+}
+
+func (skp staticKeyProvider) Key(id string) ([]byte, error) {
+	key, ok := skp.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: no key %q", errDecrypt, id)
+	}
+
+	return key, nil
+}
+
+// Reads keys from a file of lines of an id and a hex-encoded key,
+// separated by a space. The last one is current.
+func readKeyFile(name string) (staticKeyProvider, error) {
+	contents, err := os.ReadFile(name)
+	if err != nil {
+		return staticKeyProvider{}, err
+	}
+
+	skp := staticKeyProvider{keys: map[string][]byte{}}
+	for i, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(line, " ")
+		key, err := hex.DecodeString(strings.TrimSpace(encoded))
+		if !ok || err != nil {
+			return staticKeyProvider{}, fmt.Errorf("%s:%d: expected an id and a hex-encoded key", name, i+1)
+		}
+
+		skp.keys[id] = key
+		skp.current = id
+	}
+
+	if skp.current == "" {
+		return staticKeyProvider{}, fmt.Errorf("%s: no keys", name)
+	}
+
+	return skp, nil
+}
+
+type encryptedObjectStorage struct {
+	os   objectStorage
+	keys KeyProvider
+}
+
+func newEncryptedObjectStorage(os objectStorage, keys KeyProvider) *encryptedObjectStorage {
+	return &encryptedObjectStorage{os, keys}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func (eos *encryptedObjectStorage) encrypt(name string, plaintext []byte) ([]byte, error) {
+	id, key, err := eos.keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(id) > 255 {
+		return nil, fmt.Errorf("key id longer than 255 bytes: %s", id)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := append([]byte(ENCRYPTION_MAGIC), 1, byte(len(id)))
+	header = append(header, id...)
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	header = append(header, nonce...)
+	return gcm.Seal(header, nonce, plaintext, []byte(name)), nil
+}
+
+func (eos *encryptedObjectStorage) decrypt(name string, object []byte) ([]byte, error) {
+	n := len(ENCRYPTION_MAGIC) + 2
+	if len(object) < n || !bytes.HasPrefix(object, []byte(ENCRYPTION_MAGIC)) {
+		return nil, fmt.Errorf("%w: %s isn't encrypted", errDecrypt, name)
+	}
+	if version := object[n-2]; version != 1 {
+		return nil, fmt.Errorf("%w: %s is version %d", errDecrypt, name, version)
+	}
+
+	idLength := int(object[n-1])
+	if len(object) < n+idLength {
+		return nil, fmt.Errorf("%w: %s is truncated", errDecrypt, name)
+	}
+	key, err := eos.keys.Key(string(object[n : n+idLength]))
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	object = object[n+idLength:]
+	if len(object) < gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: %s is truncated", errDecrypt, name)
+	}
+
+	plaintext, err := gcm.Open(nil, object[:gcm.NonceSize()], object[gcm.NonceSize():], []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", errDecrypt, name, err)
+	}
+
+	return plaintext, nil
+}
+
+func (eos *encryptedObjectStorage) putIfAbsent(ctx context.Context, name string, bytes []byte) error {
+	object, err := eos.encrypt(name, bytes)
+	if err != nil {
+		return err
+	}
+
+	return eos.os.putIfAbsent(ctx, name, object)
+}
+
+func (eos *encryptedObjectStorage) listPrefix(ctx context.Context, prefix string) ([]string, error) {
+	return eos.os.listPrefix(ctx, prefix)
+}
+
+func (eos *encryptedObjectStorage) read(ctx context.Context, name string) ([]byte, error) {
+	object, err := eos.os.read(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return eos.decrypt(name, object)
+}
+
+func (eos *encryptedObjectStorage) delete(ctx context.Context, name string) error {
+	return eos.os.delete(ctx, name)
+}
+
+// Sizes are as stored, encrypted.
+func (eos *encryptedObjectStorage) stat(ctx context.Context, name string) (objectInfo, error) {
+	return statObject(ctx, eos.os, name)
+}
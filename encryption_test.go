@@ -0,0 +1,132 @@
+package otf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedStorage(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	k1 := bytes.Repeat([]byte{1}, 32)
+	k2 := bytes.Repeat([]byte{2}, 16)
+	keys := staticKeyProvider{map[string][]byte{"k1": k1}, "k1"}
+	c := newClient(newEncryptedObjectStorage(mos, keys))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.writeRow("x", []any{"plaintext"})
+	assertEq(err, nil, "could not write row")
+	err = c.flushRows("x")
+	assertEq(err, nil, "could not flush")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	for name, object := range mos.objects {
+		assert(bytes.HasPrefix(object, []byte(ENCRYPTION_MAGIC+"\x01\x02k1")), name+" isn't encrypted")
+		assert(!bytes.Contains(object, []byte("plaintext")), name+" is plaintext")
+	}
+
+	// Rotated keys still read objects written with old ones.
+	keys = staticKeyProvider{map[string][]byte{"k1": k1, "k2": k2}, "k2"}
+	c = newClient(newEncryptedObjectStorage(mos, keys))
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{"more"})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(scanAll(&c, "x")), 2, "rows")
+
+	ctx := context.Background()
+	entry := mos.objects[logEntryName(1)]
+	assert(bytes.HasPrefix(entry, []byte(ENCRYPTION_MAGIC+"\x01\x02k2")), "not encrypted with the current key")
+
+	withoutK1 := newEncryptedObjectStorage(mos, staticKeyProvider{map[string][]byte{"k2": k2}, "k2"})
+	_, err = withoutK1.read(ctx, logEntryName(0))
+	assert(errors.Is(err, errDecrypt), "read without the key")
+
+	// Objects can't be swapped for one another, tampered with or
+	// left unencrypted.
+	eos := newEncryptedObjectStorage(mos, keys)
+	mos.objects["swapped"] = entry
+	_, err = eos.read(ctx, "swapped")
+	assert(errors.Is(err, errDecrypt), "read a swapped object")
+	mos.objects["tampered"] = append(bytes.Clone(entry[:len(entry)-1]), entry[len(entry)-1]^1)
+	_, err = eos.read(ctx, "tampered")
+	assert(errors.Is(err, errDecrypt), "read a tampered object")
+	mos.objects["plain"] = []byte("{}")
+	_, err = eos.read(ctx, "plain")
+	assert(errors.Is(err, errDecrypt), "read a plaintext object")
+}
+
+func TestEncryptionKeysURL(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "keys")
+	err := os.WriteFile(keyFile, []byte(fmt.Sprintf("# rotated yearly\nold %x\nnew %x\n", bytes.Repeat([]byte{1}, 16), bytes.Repeat([]byte{2}, 16))), 0600)
+	assertEq(err, nil, "could not write keys")
+
+	storage, err := openObjectStorage(filepath.Join(dir, "store") + "?encryption_keys=" + keyFile)
+	assertEq(err, nil, "could not open storage")
+	eos, ok := storage.(*encryptedObjectStorage)
+	assert(ok, "storage isn't encrypted")
+	id, _, err := eos.keys.CurrentKey()
+	assertEq(err, nil, "no current key")
+	assertEq(id, "new", "current key")
+	assertEq(eos.os.(*fileObjectStorage).basedir, filepath.Join(dir, "store"), "path")
+
+	_, err = readKeyFile(filepath.Join(dir, "missing"))
+	assert(err != nil, "read a missing key file")
+}
+
+func TestEncryptedTableLocation(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "keys")
+	err := os.WriteFile(keyFile, []byte(fmt.Sprintf("own %x\n", bytes.Repeat([]byte{3}, 16))), 0600)
+	assertEq(err, nil, "could not write keys")
+	for _, table := range []string{"x", "y"} {
+		err = os.Mkdir(filepath.Join(dir, table), 0755)
+		assertEq(err, nil, "could not make dir")
+	}
+	provider := func(role string) (credentials, error) {
+		return credentials{Token: "t"}, nil
+	}
+
+	mos := newMemoryObjectStorage()
+	keys := staticKeyProvider{map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}, "k1"}
+	c := newClient(newEncryptedObjectStorage(mos, keys), withCredentialProvider(provider))
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	// Encrypted with the store's keys, or with keys of its own.
+	err = c.createTable("x", []string{"a"}, withTableLocation(filepath.Join(dir, "x"), ""))
+	assertEq(err, nil, "could not create x")
+	err = c.createTable("y", []string{"a"}, withTableLocation(filepath.Join(dir, "y")+"?encryption_keys="+keyFile, "team-b"))
+	assertEq(err, nil, "could not create y")
+	for _, table := range []string{"x", "y"} {
+		err = c.writeRow(table, []any{"plaintext"})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	for table, id := range map[string]string{"x": "\x02k1", "y": "\x03own"} {
+		located := newFileObjectStorage(filepath.Join(dir, table))
+		names, err := located.listPrefix(context.Background(), DATAOBJECT_PREFIX)
+		assertEq(err, nil, "could not list")
+		assertEq(len(names), 1, "located dataobjects")
+		object, err := located.read(context.Background(), names[0])
+		assertEq(err, nil, "could not read")
+		assert(bytes.HasPrefix(object, []byte(ENCRYPTION_MAGIC+"\x01"+id)), names[0]+" isn't encrypted")
+		assert(!bytes.Contains(object, []byte("plaintext")), names[0]+" is plaintext")
+
+		other := newClient(newEncryptedObjectStorage(mos, keys), withCredentialProvider(provider))
+		assertEq(countRows(&other, table), 1, "rows of "+table)
+	}
+}
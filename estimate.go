@@ -0,0 +1,240 @@
+package otf
+
+import (
+	"math"
+	"slices"
+	"time"
+)
+
+// Estimates how many rows of a table match a predicate from the
+// statistics of its dataobjects (and their row groups, see
+// rowgroup.go) alone, without reading them. Within a dataobject
+// values are assumed to be independent across columns and spread
+// evenly between each column's min and max, so:
+//
+//   - a range comparison matches the fraction of [min, max] it
+//     covers,
+//   - equality matches one of the distinct values [min, max] can
+//     hold, assuming integers if both are whole, otherwise one row,
+//   - AND multiplies, except that bounds on the same column make a
+//     range, and OR adds, less the overlap, and
+//   - anything else (string ranges, functions) matches
+//     DEFAULT_SELECTIVITY of rows.
+//
+// See calibrate.go for measuring how far off this is.
+
+const DEFAULT_SELECTIVITY = 1.0 / 3
+
+// Position of v on a line shared by values of its kind, or false
+// if there is no such line.
+func numericValue(v any) (float64, bool) {
+	if f, ok := toFloat64(v); ok {
+		return f, true
+	}
+
+	if _, ok := v.(time.Time); ok {
+		t, _ := toTime(v)
+		return float64(t.UnixNano()), true
+	}
+
+	return 0, false
+}
+
+// The fraction of rows described by stats that match p.
+func estimateSelectivity(stats map[string]columnStats, rows int, p *predicate) float64 {
+	if rows == 0 {
+		return 0
+	}
+
+	switch {
+	case p.And != nil:
+		// Bounds on the same column aren't independent: only the
+		// tightest in each direction counts and together they
+		// make a range.
+		s := 1.0
+		upper := map[string]float64{}
+		lower := map[string]float64{}
+		nonNull := map[string]float64{}
+		for _, c := range p.And {
+			if c.Call == nil && c.Op != OP_EQ && c.Op != OP_NE {
+				if cs, nn, ok := comparisonSelectivity(stats, rows, c); ok {
+					bounds := upper
+					if c.Op == OP_GT || c.Op == OP_GTE {
+						bounds = lower
+					}
+					if prev, seen := bounds[c.Column]; !seen || cs < prev {
+						bounds[c.Column] = cs
+					}
+					nonNull[c.Column] = nn
+					continue
+				}
+			}
+
+			s *= estimateSelectivity(stats, rows, c)
+		}
+
+		for column, nn := range nonNull {
+			up, ok := upper[column]
+			if !ok {
+				up = 1
+			}
+			lo, ok := lower[column]
+			if !ok {
+				lo = 1
+			}
+			s *= nn * max(up+lo-1, 0)
+		}
+		return s
+	case p.Or != nil:
+		s := 0.0
+		for _, c := range p.Or {
+			cs := estimateSelectivity(stats, rows, c)
+			s = s + cs - s*cs
+		}
+		return s
+	}
+
+	s, nonNull, ok := comparisonSelectivity(stats, rows, p)
+	if !ok {
+		return DEFAULT_SELECTIVITY
+	}
+
+	return nonNull * s
+}
+
+// For p comparing a column, the fraction of its non-null values
+// that match along with the fraction of rows where it isn't null.
+// False if there are no stats for the column.
+func comparisonSelectivity(stats map[string]columnStats, rows int, p *predicate) (float64, float64, bool) {
+	column, ok := stats[p.Column]
+	if p.Call != nil || !ok {
+		return 0, 0, false
+	}
+
+	// Null never matches.
+	if column.Nulls == rows {
+		return 0, 0, true
+	}
+	nonNull := float64(rows-column.Nulls) / float64(rows)
+	if p.Value == nil || column.Min == nil {
+		return DEFAULT_SELECTIVITY, nonNull, true
+	}
+
+	// Timestamps come back from JSON as strings.
+	lo, hi, v := column.Min, column.Max, p.Value
+	if _, ok := v.(time.Time); ok {
+		lo, _ = toTime(lo)
+		hi, _ = toTime(hi)
+	}
+
+	toLo, ok := compareValues(v, lo)
+	if !ok {
+		return 0, nonNull, true
+	}
+	toHi, _ := compareValues(v, hi)
+
+	eq := 0.0
+	if toLo >= 0 && toHi <= 0 {
+		eq = 1 / distinctValues(lo, hi, rows-column.Nulls)
+	}
+
+	// The fraction of [min, max] below v.
+	below := DEFAULT_SELECTIVITY
+	switch {
+	case toLo <= 0:
+		below = 0
+	case toHi > 0:
+		below = 1
+	default:
+		fv, vok := numericValue(v)
+		flo, lok := numericValue(lo)
+		fhi, hok := numericValue(hi)
+		if vok && lok && hok && fhi > flo {
+			below = (fv - flo) / (fhi - flo)
+		}
+	}
+
+	var s float64
+	switch p.Op {
+	case OP_EQ:
+		s = eq
+	case OP_NE:
+		s = 1 - eq
+	case OP_LT:
+		s = below
+	case OP_LTE:
+		s = below + eq
+	case OP_GT:
+		s = 1 - below - eq
+	case OP_GTE:
+		s = 1 - below
+	default:
+		s = DEFAULT_SELECTIVITY
+	}
+
+	return min(max(s, 0), 1), nonNull, true
+}
+
+// How many distinct values n values between lo and hi may take.
+func distinctValues(lo, hi any, n int) float64 {
+	flo, lok := numericValue(lo)
+	fhi, hok := numericValue(hi)
+	if lok && hok && flo == math.Trunc(flo) && fhi == math.Trunc(fhi) {
+		return max(min(float64(n), fhi-flo+1), 1)
+	}
+
+	if c, _ := compareValues(lo, hi); c == 0 {
+		return 1
+	}
+
+	return max(float64(n), 1)
+}
+
+// Estimates how many rows of table match p as of the current
+// transaction. Unflushed rows are counted exactly.
+func (d *client) estimateRows(table string, p *predicate) (float64, error) {
+	if d.tx == nil {
+		return 0, errNoTx
+	}
+
+	if _, ok := d.tx.tables[table]; !ok {
+		return 0, errNoTable
+	}
+
+	keep, err := p.bind(d, table)
+	if err != nil {
+		return 0, err
+	}
+
+	estimate := 0.0
+	if rows, ok := d.tx.unflushedData[table]; ok {
+		estimate += float64(rows.filter(keep).Len)
+	}
+
+	history := d.tx.schemas[table]
+	actions := liveActions(slices.Concat(d.tx.previousActions[table], d.tx.Actions[table]))
+	deleted := deletedRows(actions)
+	for _, action := range actions {
+		o := action.AddDataobject
+		if o == nil || o.Rows == 0 || !d.mayMatch(table, o, p) {
+			continue
+		}
+
+		matching := 0.0
+		if o.RowGroups != nil {
+			for _, group := range o.RowGroups {
+				stats := history.evolveStats(o.SchemaVersion, group.Stats, group.Rows)
+				matching += float64(group.Rows) * estimateSelectivity(stats, group.Rows, p)
+			}
+		} else {
+			stats := history.evolveStats(o.SchemaVersion, o.Stats, o.Rows)
+			matching = float64(o.Rows) * estimateSelectivity(stats, o.Rows, p)
+		}
+
+		// Deleted rows are as likely to match as any other.
+		live := float64(o.Rows - len(deleted[o]))
+		estimate += matching * live / float64(o.Rows)
+	}
+
+	return estimate, nil
+}
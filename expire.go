@@ -0,0 +1,202 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+)
+
+// Left alone the log grows by an entry per transaction forever.
+// Expiring snapshots trims it: every entry through a checkpoint (see
+// checkpoint.go) is replaced by one coalesced entry (see coalesce.go)
+// of the checkpoint, the store's state as of its last transaction,
+// and then deleted, along with older checkpoints.
+//
+// Replaying the expired entry gives the same state as replaying the
+// entries it replaced, so starting transactions is unaffected, but
+// only the latest of the expired versions can still be opened (see
+// timetravel.go) or read changes after (see cdc.go). Dataobjects only
+// the expired versions referenced, ones they added and removed
+// again, are no longer referenced by any entry and are left for
+// vacuum (see vacuum.go) to delete.
+//
+// What's retained is the latest keep transactions and every one
+// committed within retention: entries are expired through the latest
+// checkpoint before both. Only one expiry should run at a time.
+
+type snapshotRetention struct {
+	Keep      int
+	Retention time.Duration
+}
+
+// Expires snapshots after each checkpoint the client writes,
+// retaining the latest keep transactions and those committed within
+// retention.
+func withSnapshotRetention(keep int, retention time.Duration) clientOption {
+	return func(c *client) {
+		c.snapshotRetention = &snapshotRetention{keep, retention}
+	}
+}
+
+type expireResult struct {
+	// The expired entry written, and the last transaction it
+	// covers, or empty if there was nothing to expire.
+	Expired string
+	Through int
+	// Log entries and checkpoints deleted.
+	Deleted []string
+	// Keys of dataobjects only expired versions referenced.
+	Unreferenced []string
+}
+
+// Expires entries through the latest checkpoint both keep
+// transactions before the latest and committed more than retention
+// ago. Doesn't need a transaction.
+func (d *client) expireSnapshots(keep int, retention time.Duration) (*expireResult, error) {
+	if d.logFormat == LOG_FORMAT_DELTA {
+		return nil, fmt.Errorf("%w: a Delta log isn't expired", errDeltaLog)
+	}
+	if d.branch != "" {
+		return nil, fmt.Errorf("%w: only %s's log is expired", errBadRequest, MAIN_BRANCH)
+	}
+
+	err := d.checkLogWritable()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &expireResult{}
+	names, err := d.listLog()
+	if err != nil || len(names) == 0 {
+		return result, err
+	}
+
+	cutoff := time.Now().Add(-retention)
+	upTo := logEntryId(names[len(names)-1]) - max(keep, 0)
+
+	// Branches and tags need what they start after or name, see
+	// branch.go.
+	pinned, err := d.earliestPinned()
+	if err != nil {
+		return nil, err
+	}
+	if pinned != -1 {
+		upTo = min(upTo, pinned)
+	}
+	var cp *checkpoint
+	var last *logEntry
+	for {
+		cp, err = d.latestCheckpoint(upTo)
+		if err != nil || cp == nil {
+			return result, err
+		}
+
+		i := logEntryIndex(names, cp.Id)
+		if i == -1 {
+			// Already expired, or its entry is inside a
+			// coalesced one.
+			return result, nil
+		}
+
+		last, err = d.readLogEntry(names[i])
+		if err != nil {
+			return nil, err
+		}
+
+		if last.CommitInfo == nil || !last.CommitInfo.time().After(cutoff) {
+			names = names[:i+1]
+			break
+		}
+		upTo = cp.Id - 1
+	}
+
+	if len(names) == 1 {
+		// Nothing before it to expire.
+		return result, nil
+	}
+
+	// Dataobjects added by the entries being expired but no longer
+	// live as of the checkpoint.
+	live := map[string]bool{}
+	for _, actions := range cp.Actions {
+		for _, action := range liveActions(actions) {
+			if action.AddDataobject != nil {
+				for _, key := range dataobjectKeys(action.AddDataobject) {
+					live[key] = true
+				}
+			}
+		}
+	}
+	expired := &logEntry{
+		Version:       LOG_ENTRY_VERSION,
+		Id:            cp.Id,
+		CommitInfo:    last.CommitInfo,
+		Actions:       cp.Actions,
+		TableVersions: cp.TableVersions,
+	}
+	expired.carryVersion(cp.entry())
+	for _, name := range names {
+		entry, err := d.readLogEntry(name)
+		if err != nil {
+			return nil, err
+		}
+
+		expired.carryVersion(entry)
+		for _, actions := range entry.Actions {
+			for _, action := range actions {
+				if action.AddDataobject == nil {
+					continue
+				}
+
+				for _, key := range dataobjectKeys(action.AddDataobject) {
+					if !live[key] {
+						result.Unreferenced = append(result.Unreferenced, key)
+					}
+				}
+			}
+		}
+	}
+
+	first := logEntryId(names[0])
+	if f, _, ok := parseCoalescedName(names[0]); ok {
+		first = f
+	}
+
+	bytes, err := encodeLogEntry(expired)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Expired = coalescedLogEntryName(first, cp.Id)
+	result.Through = cp.Id
+	err = d.os.putIfAbsent(d.context(), result.Expired, bytes)
+	if err != nil && !errors.Is(err, fs.ErrExist) {
+		return nil, err
+	}
+
+	checkpoints, err := d.os.listPrefix(d.context(), "_checkpoint_")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range checkpoints {
+		if name < checkpointName(cp.Id) {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range names {
+		if name == result.Expired {
+			continue
+		}
+
+		err = d.os.delete(d.context(), name)
+		if err != nil {
+			return result, err
+		}
+		result.Deleted = append(result.Deleted, name)
+	}
+
+	d.debug("expire", "expired log entries and checkpoints", "objects", len(result.Deleted), "through", cp.Id)
+	return result, nil
+}
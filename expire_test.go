@@ -0,0 +1,115 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestExpireSnapshots(t *testing.T) {
+	c := newClient(newMemoryObjectStorage(), withCheckpointInterval(5))
+	commit := func(i int) {
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+		if i == 0 {
+			err = c.createTable("x", []string{"a"})
+			assertEq(err, nil, "could not create x")
+		}
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+		if i == 3 {
+			_, err = c.compact("x")
+			assertEq(err, nil, "could not compact")
+		}
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+	for i := 0; i < 12; i++ {
+		commit(i)
+	}
+
+	// Nothing is old enough.
+	result, err := c.expireSnapshots(0, time.Hour)
+	assertEq(err, nil, "could not expire")
+	assertEq(result.Expired, "", "expired recent snapshots")
+
+	// Checkpoints are at 4 and 9, keeping 3 leaves 9 out.
+	result, err = c.expireSnapshots(3, 0)
+	assertEq(err, nil, "could not expire")
+	assertEq(result.Expired, coalescedLogEntryName(0, 4), "expired entry")
+	assertEq(result.Through, 4, "expired through")
+	assertEq(len(result.Deleted), 5, "deleted")
+	assert(len(result.Unreferenced) > 0, "compacted dataobjects aren't unreferenced")
+	names, err := c.listLog()
+	assertEq(err, nil, "could not list log")
+	assertEq(names[0], coalescedLogEntryName(0, 4), "first log entry")
+	assertEq(len(names), 8, "log entries")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(scanAll(&c, "x")), 12, "rows")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	err = c.newTxAt(4)
+	assertEq(err, nil, "could not start tx at the expiry")
+	assertEq(len(scanAll(&c, "x")), 5, "rows as of tx 4")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+	err = c.newTxAt(2)
+	assert(errors.Is(err, errNoVersion), "opened an expired version")
+
+	// Vacuum deletes what only expired versions referenced.
+	vacuumed, err := c.vacuum(0, false)
+	assertEq(err, nil, "could not vacuum")
+	for _, key := range result.Unreferenced {
+		assert(slices.Contains(vacuumed.Deleted, key), "didn't vacuum "+key)
+	}
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(scanAll(&c, "x")), 12, "rows after vacuum")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+
+	// Expiring again widens the expired entry.
+	for i := 12; i < 15; i++ {
+		commit(i)
+	}
+	result, err = c.expireSnapshots(0, 0)
+	assertEq(err, nil, "could not expire")
+	assertEq(result.Expired, coalescedLogEntryName(0, 14), "expired entry")
+	names, err = c.listLog()
+	assertEq(err, nil, "could not list log")
+	assertEq(fmt.Sprint(names), fmt.Sprint([]string{coalescedLogEntryName(0, 14)}), "log entries")
+	checkpoints, err := c.os.listPrefix(c.context(), "_checkpoint_")
+	assertEq(err, nil, "could not list checkpoints")
+	assertEq(fmt.Sprint(checkpoints), fmt.Sprint([]string{checkpointName(14)}), "checkpoints")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(scanAll(&c, "x")), 15, "rows")
+	assertEq(c.tx.Id, 15, "tx id")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+}
+
+func TestSnapshotRetention(t *testing.T) {
+	c := newClient(newMemoryObjectStorage(), withCheckpointInterval(5), withSnapshotRetention(0, 0))
+	for i := 0; i < 10; i++ {
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+		if i == 0 {
+			err = c.createTable("x", []string{"a"})
+			assertEq(err, nil, "could not create x")
+		}
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+
+	names, err := c.listLog()
+	assertEq(err, nil, "could not list log")
+	assertEq(fmt.Sprint(names), fmt.Sprint([]string{coalescedLogEntryName(0, 9)}), "log entries")
+}
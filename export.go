@@ -0,0 +1,234 @@
+package otf
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Writes the rows of a scan to an io.Writer as CSV, JSON lines or a
+// Parquet file, the counterpart of import (see import.go), for
+// handing a table, or the columns and rows of it a scan picks, to
+// tools that know nothing about otf.
+//
+// CSV starts with a header of the scan's column names. Nulls are
+// empty fields, timestamps RFC 3339 and anything that isn't a
+// number, bool or string its JSON encoding. JSON lines have one
+// object per row mapping column names to values in column order.
+// Both are written as the scan goes. A Parquet file is written as
+// one row group (see parquet.go) so the scan is held in memory until
+// it ends, with column types inferred across all of it.
+
+const (
+	EXPORT_CSV     = IMPORT_CSV
+	EXPORT_JSONL   = IMPORT_JSONL
+	EXPORT_PARQUET = "parquet"
+)
+
+// The names of the columns a scan of table with o returns.
+func (d *client) scanColumnNames(table string, o scanOptions) []string {
+	columns := d.tx.tables[table]
+	if o.columns != nil {
+		columns = o.columns
+	}
+
+	names := append([]string{}, columns...)
+	for _, c := range o.computed {
+		names = append(names, c.name)
+	}
+
+	return names
+}
+
+// Scans table in the current transaction with opts, writing its rows
+// to w in format, one of EXPORT_CSV, EXPORT_JSONL or EXPORT_PARQUET.
+// Returns how many rows were written.
+func (d *client) export(table string, w io.Writer, format string, opts ...scanOption) (int, error) {
+	if d.tx == nil {
+		return 0, errNoTx
+	}
+
+	if _, ok := d.tx.tables[table]; !ok {
+		return 0, fmt.Errorf("%w: %s", errNoTable, table)
+	}
+
+	if format != EXPORT_CSV && format != EXPORT_JSONL && format != EXPORT_PARQUET {
+		return 0, fmt.Errorf("%w: %s", errUnknownFormat, format)
+	}
+
+	it, err := d.scan(table, opts...)
+	if err != nil {
+		return 0, err
+	}
+
+	var o scanOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	columns := d.scanColumnNames(table, o)
+
+	var n int
+	switch format {
+	case EXPORT_CSV:
+		n, err = exportCSV(it, columns, w)
+	case EXPORT_JSONL:
+		n, err = exportJSONLines(it, columns, w)
+	case EXPORT_PARQUET:
+		n, err = exportParquet(it, columns, w)
+	}
+
+	d.debug("export", "exported rows", "table", table, "rows", n)
+	return n, err
+}
+
+func exportCSV(it *scanIterator, columns []string, w io.Writer) (int, error) {
+	cw := csv.NewWriter(w)
+	err := cw.Write(columns)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	record := make([]string, len(columns))
+	for {
+		row, err := it.next()
+		if err != nil {
+			return n, err
+		}
+		if row == nil {
+			break
+		}
+
+		for i, v := range row {
+			record[i], err = csvField(v)
+			if err != nil {
+				return n, err
+			}
+		}
+
+		err = cw.Write(record)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	cw.Flush()
+	return n, cw.Error()
+}
+
+// v as csvValue would read it back.
+func csvField(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	}
+
+	bytes, err := json.Marshal(v)
+	return string(bytes), err
+}
+
+func exportJSONLines(it *scanIterator, columns []string, w io.Writer) (int, error) {
+	// Keys are encoded once, and objects by hand so their keys
+	// stay in column order.
+	keys := make([][]byte, len(columns))
+	for i, column := range columns {
+		key, err := json.Marshal(column)
+		if err != nil {
+			return 0, err
+		}
+		keys[i] = append(key, ':')
+	}
+
+	bw := bufio.NewWriter(w)
+	n := 0
+	var line []byte
+	for {
+		row, err := it.next()
+		if err != nil {
+			return n, err
+		}
+		if row == nil {
+			break
+		}
+
+		line = append(line[:0], '{')
+		for i, v := range row {
+			if i > 0 {
+				line = append(line, ',')
+			}
+			value, err := json.Marshal(v)
+			if err != nil {
+				return n, err
+			}
+			line = append(append(line, keys[i]...), value...)
+		}
+		line = append(line, '}', '\n')
+
+		_, err = bw.Write(line)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	return n, bw.Flush()
+}
+
+func exportParquet(it *scanIterator, columns []string, w io.Writer) (int, error) {
+	types := make([]arrowType, len(columns))
+	seen := make([]bool, len(columns))
+	var batches []*batch
+	n := 0
+	for {
+		b, err := it.nextBatch(DATAOBJECT_SIZE)
+		if err != nil {
+			return 0, err
+		}
+		if b == nil {
+			break
+		}
+
+		for i := range columns {
+			types[i], seen[i] = widenArrowType(types[i], seen[i], b.Columns[i])
+		}
+		batches = append(batches, b)
+		n += b.Len
+	}
+
+	fields := make([]arrowField, len(columns))
+	for i, name := range columns {
+		if !seen[i] {
+			types[i] = arrowUtf8
+		}
+		fields[i] = arrowField{name, types[i]}
+	}
+
+	bytes, err := encodeParquetFile(fields, batches)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = w.Write(bytes)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
@@ -0,0 +1,83 @@
+package otf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExport(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b", "c", "d"}, withColumnTypes(map[string]string{
+		"a": COLUMN_INT,
+		"c": COLUMN_TIMESTAMP,
+	}))
+	assertEq(err, nil, "could not create x")
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = c.writeRow("x", []any{1, "o,ne", at, 1.5})
+	assertEq(err, nil, "could not write row")
+	err = c.writeRow("x", []any{2, nil, nil, []any{"d"}})
+	assertEq(err, nil, "could not write row")
+
+	var out bytes.Buffer
+	n, err := c.export("x", &out, EXPORT_CSV)
+	assertEq(err, nil, "could not export")
+	assertEq(n, 2, "rows exported")
+	assertEq(out.String(), "a,b,c,d\n1,\"o,ne\",2024-01-02T03:04:05Z,1.5\n2,,,\"[\"\"d\"\"]\"\n", "csv")
+
+	out.Reset()
+	n, err = c.export("x", &out, EXPORT_JSONL, withColumns("d", "a"), withFilter(where("a", OP_EQ, 2)))
+	assertEq(err, nil, "could not export")
+	assertEq(n, 1, "rows exported")
+	assertEq(out.String(), "{\"d\":[\"d\"],\"a\":2}\n", "json lines")
+
+	out.Reset()
+	n, err = c.export("x", &out, EXPORT_PARQUET, withColumns("a", "b"))
+	assertEq(err, nil, "could not export")
+	assertEq(n, 2, "rows exported")
+	assert(bytes.HasPrefix(out.Bytes(), []byte("PAR1")) && bytes.HasSuffix(out.Bytes(), []byte("PAR1")), "parquet magic")
+
+	_, err = c.export("x", &out, "xml")
+	assert(errors.Is(err, errUnknownFormat), "exported xml")
+	_, err = c.export("y", &out, EXPORT_CSV)
+	assert(errors.Is(err, errNoTable), "exported a missing table")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+}
+
+// What's exported imports back the same.
+func TestExportImport(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	types := withColumnTypes(map[string]string{"a": COLUMN_INT, "b": COLUMN_STRING, "c": COLUMN_BOOL})
+	err = c.createTable("x", []string{"a", "b", "c"}, types)
+	assertEq(err, nil, "could not create x")
+	err = c.createTable("y", []string{"a", "b", "c"}, types)
+	assertEq(err, nil, "could not create y")
+	for i := 0; i < 5; i++ {
+		err = c.writeRow("x", []any{i, fmt.Sprintf("row \"%d\"", i), i%3 == 0})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	for _, format := range []string{EXPORT_CSV, EXPORT_JSONL} {
+		err = c.newTx()
+		assertEq(err, nil, "could not start tx")
+		var out strings.Builder
+		_, err = c.export("x", &out, format)
+		assertEq(err, nil, "could not export")
+		n, err := c.importFrom("y", strings.NewReader(out.String()), format)
+		assertEq(err, nil, "could not import")
+		assertEq(n, 5, "rows imported")
+		assertEq(fmt.Sprint(scanAll(&c, "y")), fmt.Sprint(scanAll(&c, "x")), "rows")
+		err = c.abortTx()
+		assertEq(err, nil, "could not abort")
+	}
+}
@@ -0,0 +1,537 @@
+package otf
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Expressions are the one representation behind filters, computed
+// columns and anything else that evaluates values per row.
+// Predicates (see predicate.go) and function calls (see udf.go) are
+// converted into expressions before they are compiled.
+//
+// Evaluation follows SQL's three-valued logic: comparisons and
+// arithmetic involving null are null, AND/OR only become null when
+// the result really is unknown, and a filter only keeps rows it
+// evaluates to true for.
+//
+// Expressions are folded before being compiled, evaluating whatever
+// doesn't depend on a row once up front. The same folding lets a
+// dataobject's statistics simplify an expression: comparisons the
+// statistics decide are replaced by literals and if the whole
+// expression folds to false or null the dataobject can be skipped.
+
+const (
+	EXPR_COLUMN  = "column"
+	EXPR_LITERAL = "literal"
+	EXPR_OP      = "op"
+	EXPR_CALL    = "call"
+)
+
+// Besides the comparison ops in predicate.go.
+const (
+	OP_AND     = "AND"
+	OP_OR      = "OR"
+	OP_NOT     = "NOT"
+	OP_IS_NULL = "IS NULL"
+	OP_ADD     = "+"
+	OP_SUB     = "-"
+	OP_MUL     = "*"
+	OP_DIV     = "/"
+)
+
+var comparisonOps = []string{OP_EQ, OP_NE, OP_LT, OP_LTE, OP_GT, OP_GTE}
+
+type expr struct {
+	Kind   string
+	Column string  `json:",omitempty"`
+	Value  any     `json:",omitempty"`
+	Op     string  `json:",omitempty"`
+	Func   string  `json:",omitempty"`
+	Args   []*expr `json:",omitempty"`
+}
+
+func colExpr(name string) *expr {
+	return &expr{Kind: EXPR_COLUMN, Column: name}
+}
+
+func litExpr(v any) *expr {
+	return &expr{Kind: EXPR_LITERAL, Value: v}
+}
+
+func opExpr(op string, args ...*expr) *expr {
+	return &expr{Kind: EXPR_OP, Op: op, Args: args}
+}
+
+func callExpr(name string, args ...*expr) *expr {
+	return &expr{Kind: EXPR_CALL, Func: name, Args: args}
+}
+
+func (e *expr) isLiteral(v any) bool {
+	return e.Kind == EXPR_LITERAL && e.Value == v
+}
+
+func (e *expr) String() string {
+	switch e.Kind {
+	case EXPR_COLUMN:
+		return e.Column
+	case EXPR_LITERAL:
+		return formatLiteral(e.Value)
+	case EXPR_CALL:
+		var args []string
+		for _, arg := range e.Args {
+			args = append(args, arg.String())
+		}
+		return fmt.Sprintf("%s(%s)", e.Func, strings.Join(args, ", "))
+	}
+
+	switch e.Op {
+	case OP_NOT:
+		return "NOT " + e.Args[0].String()
+	case OP_IS_NULL:
+		return e.Args[0].String() + " IS NULL"
+	}
+
+	var args []string
+	for _, arg := range e.Args {
+		args = append(args, arg.String())
+	}
+	return "(" + strings.Join(args, " "+e.Op+" ") + ")"
+}
+
+func isNumber(v any) bool {
+	_, ok := toFloat64(v)
+	return ok
+}
+
+func isInteger(v any) bool {
+	switch v.(type) {
+	case int, int64:
+		return true
+	}
+
+	return false
+}
+
+// Applies op to already evaluated arguments.
+func evalOp(op string, args []any) any {
+	switch op {
+	case OP_AND, OP_OR:
+		// The value that decides the result on its own.
+		decisive := op == OP_OR
+		unknown := false
+		for _, arg := range args {
+			b, ok := arg.(bool)
+			if !ok {
+				unknown = true
+			} else if b == decisive {
+				return decisive
+			}
+		}
+		if unknown {
+			return nil
+		}
+		return !decisive
+	case OP_NOT:
+		if b, ok := args[0].(bool); ok {
+			return !b
+		}
+		return nil
+	case OP_IS_NULL:
+		return args[0] == nil
+	}
+
+	if args[0] == nil || args[1] == nil {
+		return nil
+	}
+
+	if slices.Contains(comparisonOps, op) {
+		c, ok := compareValues(args[0], args[1])
+		return ok && opMatches(op, c)
+	}
+
+	a, aok := toFloat64(args[0])
+	b, bok := toFloat64(args[1])
+	if !aok || !bok {
+		return nil
+	}
+
+	var result float64
+	switch op {
+	case OP_ADD:
+		result = a + b
+	case OP_SUB:
+		result = a - b
+	case OP_MUL:
+		result = a * b
+	case OP_DIV:
+		if b == 0 {
+			return nil
+		}
+		result = a / b
+	default:
+		panic(fmt.Sprintf("unknown op: %s", op))
+	}
+
+	// Integers stay integers, other than through division.
+	if isInteger(args[0]) && isInteger(args[1]) && op != OP_DIV {
+		return int64(result)
+	}
+
+	return result
+}
+
+// Calls f if every argument is of its declared type, otherwise null.
+// Fails if f returns a value not of its declared type.
+func evalCall(f *udf, args []any) (any, error) {
+	for i, arg := range args {
+		if arg == nil || !paramAccepts(f.ArgTypes[i], arg) {
+			return nil, nil
+		}
+	}
+
+	result := f.Fn(args)
+	if result != nil && !paramAccepts(f.ReturnType, result) {
+		return nil, fmt.Errorf("%s returned %T, declared %s", f.Name, result, f.ReturnType)
+	}
+	return result, nil
+}
+
+// Checks e's arguments against what its op takes or, if e is a call,
+// the function in functions it calls takes, returning the function.
+// Literal arguments other than null must be of their declared type.
+func (e *expr) check(functions map[string]*udf) (*udf, error) {
+	if e.Kind == EXPR_CALL {
+		f, ok := functions[e.Func]
+		if !ok {
+			return nil, fmt.Errorf("unknown function: %s", e.Func)
+		}
+
+		if len(e.Args) != len(f.ArgTypes) {
+			return nil, fmt.Errorf("%s expects %d arguments, got %d", f.Name, len(f.ArgTypes), len(e.Args))
+		}
+
+		for i, arg := range e.Args {
+			if _, isPlaceholder := arg.Value.(placeholder); arg.Kind == EXPR_LITERAL && !isPlaceholder && arg.Value != nil && !paramAccepts(f.ArgTypes[i], arg.Value) {
+				return nil, fmt.Errorf("argument %d of %s must be %s, got %T", i+1, f.Name, f.ArgTypes[i], arg.Value)
+			}
+		}
+
+		return f, nil
+	}
+
+	arity := 2
+	switch e.Op {
+	case OP_AND, OP_OR:
+		arity = len(e.Args)
+	case OP_NOT, OP_IS_NULL:
+		arity = 1
+	case OP_EQ, OP_NE, OP_LT, OP_LTE, OP_GT, OP_GTE, OP_ADD, OP_SUB, OP_MUL, OP_DIV:
+	default:
+		return nil, fmt.Errorf("unknown op: %s", e.Op)
+	}
+
+	if len(e.Args) != arity {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", e.Op, arity, len(e.Args))
+	}
+
+	return nil, nil
+}
+
+// Evaluates everything in e that doesn't depend on a row. Function
+// calls are only checked and folded when functions are given.
+// Placeholders are left alone.
+func (e *expr) fold(functions map[string]*udf) (*expr, error) {
+	if e.Kind != EXPR_OP && e.Kind != EXPR_CALL {
+		return e, nil
+	}
+
+	var f *udf
+	if e.Kind == EXPR_OP || functions != nil {
+		var err error
+		f, err = e.check(functions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	folded := *e
+	folded.Args = nil
+	constant := true
+	for _, arg := range e.Args {
+		arg, err := arg.fold(functions)
+		if err != nil {
+			return nil, err
+		}
+		folded.Args = append(folded.Args, arg)
+		if _, isPlaceholder := arg.Value.(placeholder); arg.Kind != EXPR_LITERAL || isPlaceholder {
+			constant = false
+		}
+	}
+
+	var values []any
+	for _, arg := range folded.Args {
+		values = append(values, arg.Value)
+	}
+
+	if e.Kind == EXPR_OP && constant {
+		return litExpr(evalOp(e.Op, values)), nil
+	}
+
+	if e.Kind == EXPR_CALL || (e.Op != OP_AND && e.Op != OP_OR && e.Op != OP_IS_NULL) {
+		// Null in, null out, whatever the other arguments are.
+		for _, arg := range folded.Args {
+			if arg.isLiteral(nil) {
+				return litExpr(nil), nil
+			}
+		}
+
+		if f != nil && constant {
+			result, err := evalCall(f, values)
+			if err != nil {
+				return nil, err
+			}
+			return litExpr(result), nil
+		}
+
+		return &folded, nil
+	}
+
+	// A literal that decides the result on its own short-circuits,
+	// one that doesn't can be dropped.
+	decisive := e.Op == OP_OR
+	var remaining []*expr
+	for _, arg := range folded.Args {
+		if arg.isLiteral(decisive) {
+			return litExpr(decisive), nil
+		}
+
+		if !arg.isLiteral(!decisive) {
+			remaining = append(remaining, arg)
+		}
+	}
+
+	switch len(remaining) {
+	case 0:
+		return litExpr(!decisive), nil
+	case 1:
+		return remaining[0], nil
+	}
+
+	folded.Args = remaining
+	return &folded, nil
+}
+
+// Resolves e against table as of d's transaction, returning a
+// function that, given values for any placeholders, evaluates e for
+// row i of a batch of that table.
+func (e *expr) compile(d *client, table string) (func(args []any) func(b *batch, i int) any, error) {
+	switch e.Kind {
+	case EXPR_COLUMN:
+		column := slices.Index(d.tx.tables[table], e.Column)
+		if column == -1 {
+			return nil, fmt.Errorf("%w: %s.%s", errNoColumn, table, e.Column)
+		}
+
+		return func([]any) func(*batch, int) any {
+			return func(b *batch, i int) any {
+				return b.Columns[column][i]
+			}
+		}, nil
+	case EXPR_LITERAL:
+		return func(args []any) func(*batch, int) any {
+			value := e.Value
+			if ph, ok := value.(placeholder); ok {
+				value = args[ph.Index-1]
+			}
+
+			return func(*batch, int) any {
+				return value
+			}
+		}, nil
+	}
+
+	var compiled []func([]any) func(*batch, int) any
+	for _, arg := range e.Args {
+		f, err := arg.compile(d, table)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, f)
+	}
+
+	f, err := e.check(d.functions)
+	if err != nil {
+		return nil, err
+	}
+
+	var apply func(values []any) any
+	if e.Kind == EXPR_CALL {
+		apply = func(values []any) any {
+			// A row can't fail, so a mistyped result is null.
+			result, err := evalCall(f, values)
+			if err != nil {
+				d.debug("udf", "dropped result", "err", err)
+			}
+			return result
+		}
+	} else {
+		apply = func(values []any) any {
+			return evalOp(e.Op, values)
+		}
+	}
+
+	return func(args []any) func(*batch, int) any {
+		var bound []func(*batch, int) any
+		for _, f := range compiled {
+			bound = append(bound, f(args))
+		}
+
+		return func(b *batch, i int) any {
+			values := make([]any, len(bound))
+			for j, f := range bound {
+				values[j] = f(b, i)
+			}
+
+			return apply(values)
+		}
+	}, nil
+}
+
+// Replaces comparisons of a column with a literal that stats decide
+// for every row of a dataobject with rows rows, then folds. Decided
+// comparisons only become true if no row is null, so the result
+// never claims less could match than really could.
+func (e *expr) simplifyWithStats(stats map[string]columnStats, rows int) *expr {
+	if e.Kind != EXPR_OP {
+		return e
+	}
+
+	simplified := *e
+	simplified.Args = nil
+	for _, arg := range e.Args {
+		simplified.Args = append(simplified.Args, arg.simplifyWithStats(stats, rows))
+	}
+
+	if e.Op == OP_IS_NULL && e.Args[0].Kind == EXPR_COLUMN {
+		if s, ok := stats[e.Args[0].Column]; ok {
+			switch s.Nulls {
+			case 0:
+				return litExpr(false)
+			case rows:
+				return litExpr(true)
+			}
+		}
+	}
+
+	if slices.Contains(comparisonOps, e.Op) {
+		if decided, ok := decideWithStats(e, stats, rows); ok {
+			return litExpr(decided)
+		}
+	}
+
+	folded, err := simplified.fold(nil)
+	if err != nil {
+		// Left for compile to reject.
+		return &simplified
+	}
+	return folded
+}
+
+// Whether e can't evaluate to true for any row, looking only at its
+// literals.
+func (e *expr) neverTrue() bool {
+	if e.Kind == EXPR_LITERAL {
+		return e.isLiteral(false) || e.isLiteral(nil)
+	}
+
+	if e.Kind != EXPR_OP || (e.Op != OP_AND && e.Op != OP_OR) {
+		return false
+	}
+
+	for _, arg := range e.Args {
+		if arg.neverTrue() == (e.Op == OP_AND) {
+			return e.Op == OP_AND
+		}
+	}
+
+	return e.Op == OP_OR
+}
+
+// Flips e.g. 5 < x into x > 5.
+var flippedOps = map[string]string{
+	OP_EQ:  OP_EQ,
+	OP_NE:  OP_NE,
+	OP_LT:  OP_GT,
+	OP_LTE: OP_GTE,
+	OP_GT:  OP_LT,
+	OP_GTE: OP_LTE,
+}
+
+func decideWithStats(e *expr, stats map[string]columnStats, rows int) (any, bool) {
+	column, literal, op := e.Args[0], e.Args[1], e.Op
+	if column.Kind == EXPR_LITERAL {
+		column, literal, op = literal, column, flippedOps[op]
+	}
+
+	if column.Kind != EXPR_COLUMN || literal.Kind != EXPR_LITERAL {
+		return nil, false
+	}
+	if _, isPlaceholder := literal.Value.(placeholder); isPlaceholder || literal.Value == nil {
+		return nil, false
+	}
+
+	s, ok := stats[column.Column]
+	if !ok {
+		return nil, false
+	}
+
+	if s.Nulls == rows {
+		return nil, true
+	}
+
+	if s.Min == nil {
+		return nil, false
+	}
+
+	toMin, ok := compareValues(literal.Value, s.Min)
+	if !ok {
+		// Mismatched kinds never match.
+		return false, true
+	}
+	toMax, _ := compareValues(literal.Value, s.Max)
+
+	// Whether no row matches, and whether every non-null one does.
+	var none, all bool
+	switch op {
+	case OP_EQ:
+		none = toMin < 0 || toMax > 0
+		all = toMin == 0 && toMax == 0
+	case OP_NE:
+		none = toMin == 0 && toMax == 0
+		all = toMin < 0 || toMax > 0
+	case OP_LT:
+		none = toMin <= 0
+		all = toMax > 0
+	case OP_LTE:
+		none = toMin < 0
+		all = toMax >= 0
+	case OP_GT:
+		none = toMax >= 0
+		all = toMin < 0
+	case OP_GTE:
+		none = toMax > 0
+		all = toMin <= 0
+	}
+
+	if none {
+		return false, true
+	}
+
+	if all && s.Nulls == 0 {
+		return true, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,123 @@
+package otf
+
+import (
+	"testing"
+)
+
+func TestExprFold(t *testing.T) {
+	functions := map[string]*udf{
+		"double": {Name: "double", ArgTypes: []string{PARAM_INT64}, ReturnType: PARAM_INT64, Fn: func(args []any) any {
+			return args[0].(int64) * 2
+		}},
+	}
+
+	for _, test := range []struct {
+		e        *expr
+		expected string
+	}{
+		{opExpr(OP_ADD, litExpr(int64(1)), litExpr(int64(2))), "3"},
+		{opExpr(OP_DIV, litExpr(int64(3)), litExpr(int64(2))), "1.5"},
+		{opExpr(OP_DIV, litExpr(1), litExpr(0)), "NULL"},
+		{opExpr(OP_GT, colExpr("a"), opExpr(OP_MUL, litExpr(int64(2)), litExpr(int64(3)))), "(a > 6)"},
+		{opExpr(OP_EQ, colExpr("a"), litExpr(nil)), "NULL"},
+		{opExpr(OP_IS_NULL, litExpr(nil)), "true"},
+		{opExpr(OP_AND, colExpr("a"), litExpr(true)), "a"},
+		{opExpr(OP_AND, colExpr("a"), litExpr(false)), "false"},
+		{opExpr(OP_OR, colExpr("a"), litExpr(true)), "true"},
+		{opExpr(OP_OR, colExpr("a"), litExpr(false), colExpr("b")), "(a OR b)"},
+		// Unknown stays unknown.
+		{opExpr(OP_AND, colExpr("a"), litExpr(nil)), "(a AND NULL)"},
+		{opExpr(OP_AND, litExpr(false), litExpr(nil)), "false"},
+		{opExpr(OP_OR, litExpr(false), litExpr(nil)), "NULL"},
+		{opExpr(OP_NOT, litExpr(nil)), "NULL"},
+		{callExpr("double", litExpr(int64(4))), "8"},
+		{callExpr("double", colExpr("a")), "double(a)"},
+		{callExpr("double", litExpr(nil)), "NULL"},
+		// Placeholders aren't known until the query runs.
+		{opExpr(OP_ADD, litExpr(param(1, PARAM_INT64)), litExpr(1)), "($1::int64 + 1)"},
+	} {
+		folded, err := test.e.fold(functions)
+		assertEq(err, nil, "could not fold "+test.e.String())
+		assertEq(folded.String(), test.expected, test.e.String())
+	}
+
+	// Calls are checked before they're folded.
+	functions["wrong"] = &udf{Name: "wrong", ArgTypes: []string{PARAM_INT64}, ReturnType: PARAM_INT64, Fn: func(args []any) any {
+		return "8"
+	}}
+	for _, e := range []*expr{
+		callExpr("double", litExpr(int64(4)), litExpr(int64(2))),
+		callExpr("double", litExpr("4")),
+		callExpr("double", litExpr("4"), litExpr(nil)),
+		callExpr("nope", litExpr(nil)),
+		opExpr(OP_ADD, litExpr(int64(1))),
+		callExpr("wrong", litExpr(int64(4))),
+	} {
+		_, err := e.fold(functions)
+		assert(err != nil, "folded "+e.String())
+	}
+}
+
+func TestExprCompile(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b"})
+	assertEq(err, nil, "could not create x")
+
+	b := newBatch(2)
+	b.appendRow([]any{int64(3), nil})
+	b.appendRow([]any{1.5, true})
+
+	compiled, err := opExpr(OP_MUL, colExpr("a"), litExpr(param(1, PARAM_INT64))).compile(&c, "x")
+	assertEq(err, nil, "could not compile")
+	eval := compiled([]any{int64(2)})
+	assertEq[any](eval(b, 0), int64(6), "int stays int")
+	assertEq[any](eval(b, 1), 3.0, "double")
+
+	compiled, err = opExpr(OP_OR, colExpr("b"), opExpr(OP_GT, colExpr("a"), litExpr(2))).compile(&c, "x")
+	assertEq(err, nil, "could not compile")
+	eval = compiled(nil)
+	assertEq[any](eval(b, 0), true, "null or true")
+	assertEq[any](eval(b, 1), true, "true or false")
+
+	_, err = opExpr(OP_NOT, colExpr("c")).compile(&c, "x")
+	assert(err != nil, "unknown column")
+	_, err = opExpr("%", colExpr("a"), litExpr(2)).compile(&c, "x")
+	assert(err != nil, "unknown op")
+	_, err = callExpr("nope", colExpr("a")).compile(&c, "x")
+	assert(err != nil, "unknown function")
+}
+
+func TestExprSimplifyWithStats(t *testing.T) {
+	stats := map[string]columnStats{
+		"a": {Min: 1, Max: 10},
+		"b": {Min: "m", Max: "p", Nulls: 2},
+		"c": {Nulls: 5},
+	}
+
+	for _, test := range []struct {
+		e        *expr
+		expected string
+	}{
+		{opExpr(OP_GT, colExpr("a"), litExpr(0)), "true"},
+		{opExpr(OP_GT, colExpr("a"), litExpr(10)), "false"},
+		{opExpr(OP_GT, colExpr("a"), litExpr(5)), "(a > 5)"},
+		// Flipped.
+		{opExpr(OP_LT, litExpr(10), colExpr("a")), "false"},
+		// Nulls keep a comparison from being true for every row.
+		{opExpr(OP_GTE, colExpr("b"), litExpr("a")), "(b >= 'a')"},
+		{opExpr(OP_EQ, colExpr("b"), litExpr("z")), "false"},
+		{opExpr(OP_EQ, colExpr("c"), litExpr(1)), "NULL"},
+		{opExpr(OP_IS_NULL, colExpr("a")), "false"},
+		{opExpr(OP_IS_NULL, colExpr("c")), "true"},
+		{opExpr(OP_AND, opExpr(OP_GT, colExpr("a"), litExpr(0)), opExpr(OP_LT, colExpr("a"), litExpr(5))), "(a < 5)"},
+		{opExpr(OP_OR, opExpr(OP_GT, colExpr("a"), litExpr(10)), opExpr(OP_EQ, colExpr("b"), litExpr("z"))), "false"},
+		{opExpr(OP_NOT, opExpr(OP_GT, colExpr("a"), litExpr(10))), "true"},
+	} {
+		assertEq(test.e.simplifyWithStats(stats, 5).String(), test.expected, test.e.String())
+	}
+
+	// Null rather than false, but no row could match either way.
+	assert(opExpr(OP_AND, opExpr(OP_GT, colExpr("a"), litExpr(5)), opExpr(OP_EQ, colExpr("c"), litExpr(1))).simplifyWithStats(stats, 5).neverTrue(), "never true")
+}
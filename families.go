@@ -0,0 +1,243 @@
+package otf
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// A table's columns can be grouped into families when it's created.
+// Each dataobject then stores every family's columns in an object of
+// its own next to the dataobject, which keeps the columns in no
+// family. Scans projecting only some columns (see withColumns) read
+// only the families holding those columns or ones they filter on, so
+// a table mixing small, often-read columns with large, rarely-read
+// payloads can keep the payloads out of most scans.
+//
+// Everything else (compaction, changes, exports) reads all of a
+// dataobject's families. Dataobjects of tables with families aren't
+// split into row groups (see rowgroup.go). Family columns can't be
+// dropped or renamed.
+
+var errFamilyColumn = fmt.Errorf("Family Column")
+
+// Groups of columns stored apart from the rest and each other.
+func withColumnFamilies(families ...[]string) tableOption {
+	return func(o *tableOptions) {
+		o.columnFamilies = families
+	}
+}
+
+func checkColumnFamilies(table string, columns []string, families [][]string) error {
+	seen := map[string]bool{}
+	for _, family := range families {
+		if len(family) == 0 {
+			return fmt.Errorf("%w: empty family in %s", errFamilyColumn, table)
+		}
+
+		for _, column := range family {
+			if !slices.Contains(columns, column) {
+				return fmt.Errorf("%w: %s.%s", errNoColumn, table, column)
+			}
+			if seen[column] {
+				return fmt.Errorf("%w: %s.%s is in more than one family", errFamilyColumn, table, column)
+			}
+			seen[column] = true
+		}
+	}
+
+	return nil
+}
+
+// One family's object of a dataobject.
+type dataobjectFamily struct {
+	// Positions of its columns in the table as of the
+	// dataobject's schema version.
+	Columns []int
+	Bytes   int64
+	// See checksum.go.
+	Checksum string `json:",omitempty"`
+}
+
+func familyKey(key string, i int) string {
+	return fmt.Sprintf("%s.f%d", key, i)
+}
+
+// Keys of all of action's objects.
+func dataobjectKeys(action *DataobjectAction) []string {
+	key := dataobjectKey(action.Table, action.Name)
+	keys := []string{key}
+	for i := range action.Families {
+		keys = append(keys, familyKey(key, i))
+	}
+
+	return keys
+}
+
+// Keys of the family objects of the dataobject at key, for when only
+// its name is known.
+func familyKeys(ctx context.Context, storage objectStorage, key string) ([]string, error) {
+	return storage.listPrefix(ctx, key+".f")
+}
+
+// Encodes the columns of df in no family as the dataobject, and
+// each family's columns as an object of its own.
+func (d *client) encodeFamilies(codec string, columnCodecs []string, df dataobject) ([]byte, []dataobjectFamily, [][]byte, error) {
+	encode := func(positions []int) ([]byte, error) {
+		var codecs []string
+		if columnCodecs != nil {
+			for _, i := range positions {
+				codecs = append(codecs, columnCodecs[i])
+			}
+		}
+
+		return d.encodeDataobject(codec, codecs, dataobject{
+			Table: df.Table,
+			Name:  df.Name,
+			batch: *df.batch.project(positions),
+		})
+	}
+
+	columns := d.tx.tables[df.Table]
+	var families []dataobjectFamily
+	var objects [][]byte
+	var inFamily []int
+	for _, family := range d.tx.columnFamilies[df.Table] {
+		var positions []int
+		for _, column := range family {
+			positions = append(positions, slices.Index(columns, column))
+		}
+		inFamily = append(inFamily, positions...)
+
+		bytes, err := encode(positions)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		families = append(families, dataobjectFamily{positions, int64(len(bytes)), checksum(bytes)})
+		objects = append(objects, bytes)
+	}
+
+	var rest []int
+	for i := range columns {
+		if !slices.Contains(inFamily, i) {
+			rest = append(rest, i)
+		}
+	}
+
+	bytes, err := encode(rest)
+	return bytes, families, objects, err
+}
+
+// Fills in rows, the columns of action in no family, with the
+// columns of its families. Families not in wanted, if not nil, are
+// left null.
+func (d *client) readFamilies(read func(key string) ([]byte, error), action *DataobjectAction, rows *batch, wanted []bool) (*batch, error) {
+	width := len(rows.Columns)
+	var inFamily []int
+	for _, family := range action.Families {
+		width += len(family.Columns)
+		inFamily = append(inFamily, family.Columns...)
+	}
+
+	full := &batch{Columns: make([][]any, width), Len: rows.Len}
+	rest := 0
+	for i := range full.Columns {
+		if !slices.Contains(inFamily, i) {
+			full.Columns[i] = rows.Columns[rest]
+			rest++
+		}
+	}
+
+	for i, family := range action.Families {
+		var columns [][]any
+		if wanted == nil || wanted[i] {
+			var err error
+			columns, err = d.readFamily(read, action, i)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for j, position := range family.Columns {
+			if columns != nil {
+				full.Columns[position] = columns[j]
+			} else {
+				full.Columns[position] = make([]any, rows.Len)
+			}
+		}
+	}
+
+	return full, nil
+}
+
+// The columns of action's ith family.
+func (d *client) readFamily(read func(key string) ([]byte, error), action *DataobjectAction, i int) ([][]any, error) {
+	key := familyKey(dataobjectKey(action.Table, action.Name), i)
+	bytes, err := read(key)
+	if err == nil {
+		err = verifyChecksum(key, bytes, action.Families[i].Checksum)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := decodeDataobject(action.Codec, bytes)
+	if err != nil {
+		return nil, err
+	}
+	return b.Columns, nil
+}
+
+// The table columns a scan needs, by position, or nil if it may
+// need any of them.
+func scanColumns(columns []string, projection []int, filter *predicate, computed bool) []int {
+	if projection == nil || computed {
+		return nil
+	}
+
+	needed := slices.Clone(projection)
+	if filter == nil {
+		return needed
+	}
+
+	// Calls may read any column.
+	filtered := filterColumns(columns, filter)
+	if filtered == nil {
+		return nil
+	}
+
+	return append(needed, filtered...)
+}
+
+// Which of action's families hold columns of needed, positions in
+// the table's latest schema. nil for all of them.
+func (d *client) wantedFamilies(action *DataobjectAction, needed []int) []bool {
+	if action.Families == nil || needed == nil {
+		return nil
+	}
+
+	mapping := d.tx.schemas[action.Table].mapping(action.SchemaVersion)
+	wanted := make([]bool, len(action.Families))
+	for _, i := range needed {
+		if mapping != nil {
+			i = mapping[i]
+		}
+		for j, family := range action.Families {
+			wanted[j] = wanted[j] || slices.Contains(family.Columns, i)
+		}
+	}
+
+	return wanted
+}
+
+// Bytes of action's families a scan doesn't read.
+func skippedFamilyBytes(action *DataobjectAction, wanted []bool) int64 {
+	var skipped int64
+	for i, family := range action.Families {
+		if wanted != nil && !wanted[i] {
+			skipped += family.Bytes
+		}
+	}
+
+	return skipped
+}
@@ -0,0 +1,124 @@
+package otf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Counts reads of family objects.
+type familyReads struct {
+	*memoryObjectStorage
+	mu    sync.Mutex
+	reads int
+}
+
+func (fr *familyReads) read(ctx context.Context, name string) ([]byte, error) {
+	if strings.Contains(name, ".f") {
+		fr.mu.Lock()
+		fr.reads++
+		fr.mu.Unlock()
+	}
+
+	return fr.memoryObjectStorage.read(ctx, name)
+}
+
+func TestColumnFamilies(t *testing.T) {
+	fr := &familyReads{memoryObjectStorage: newMemoryObjectStorage()}
+	c := newClient(fr)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"id", "payload", "hot", "blob"}, withColumnFamilies([]string{"blob", "payload"}))
+	assertEq(err, nil, "could not create x")
+	for i := 0; i < 3; i++ {
+		err = c.writeRow("x", []any{i, strings.Repeat("p", 100), i % 2, strings.Repeat("b", 100)})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	keys, err := fr.listPrefix(context.Background(), DATAOBJECT_PREFIX)
+	assertEq(err, nil, "could not list")
+	assertEq(len(keys), 2, "objects per dataobject")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(fmt.Sprint(scanAll(&c, "x", withColumns("hot", "id"))), "[[0 0] [1 1] [0 2]]", "hot rows")
+	assertEq(fr.reads, 0, "read the family")
+
+	// Filtering on a family column reads it.
+	assertEq(fmt.Sprint(scanAll(&c, "x", withColumns("id"), withFilter(where("blob", OP_EQ, strings.Repeat("b", 100))))), "[[0] [1] [2]]", "filtered rows")
+	assertEq(fr.reads, 1, "family reads")
+
+	rows := scanAll(&c, "x")
+	assertEq(len(rows), 3, "rows")
+	assertEq(fmt.Sprint(rows[2][:3]), fmt.Sprint([]any{2, strings.Repeat("p", 100), 0}), "row")
+	assertEq(fr.reads, 2, "family reads")
+
+	// Old dataobjects are read in the latest schema.
+	err = c.addColumn("x", "new")
+	assertEq(err, nil, "could not add column")
+	assertEq(fmt.Sprint(scanAll(&c, "x", withColumns("new", "payload"))[0]), "[<nil> "+strings.Repeat("p", 100)+"]", "evolved row")
+
+	err = c.dropColumn("x", "blob")
+	assert(errors.Is(err, errFamilyColumn), "dropped a family column")
+	err = c.createTable("y", []string{"a", "b"}, withColumnFamilies([]string{"a"}, []string{"a", "b"}))
+	assert(errors.Is(err, errFamilyColumn), "created a column in two families")
+	err = c.createTable("y", []string{"a", "b"}, withColumnFamilies([]string{"c"}))
+	assert(errors.Is(err, errNoColumn), "created a family of an unknown column")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+}
+
+func TestColumnFamiliesAreVacuumed(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b"}, withColumnFamilies([]string{"b"}))
+	assertEq(err, nil, "could not create x")
+	for i := 0; i < 2; i++ {
+		err = c.writeRow("x", []any{i, "b"})
+		assertEq(err, nil, "could not write row")
+		err = c.flushRows("x")
+		assertEq(err, nil, "could not flush")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	result, err := c.vacuum(0, false)
+	assertEq(err, nil, "could not vacuum")
+	assertEq(len(result.Deleted), 0, "vacuumed live families")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.compact("x")
+	assertEq(err, nil, "could not compact")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	result, err = c.vacuum(0, false)
+	assertEq(err, nil, "could not vacuum")
+	assertEq(len(result.Deleted), 4, "vacuumed compacted dataobjects and their families")
+	keys, err := mos.listPrefix(context.Background(), DATAOBJECT_PREFIX)
+	assertEq(err, nil, "could not list")
+	assertEq(len(keys), 2, "objects left")
+	assert(slices.ContainsFunc(keys, func(key string) bool { return strings.HasSuffix(key, ".f0") }), "compacted family")
+
+	// Aborting deletes families written.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{3, "b"})
+	assertEq(err, nil, "could not write row")
+	err = c.flushRows("x")
+	assertEq(err, nil, "could not flush")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+	keys, err = mos.listPrefix(context.Background(), DATAOBJECT_PREFIX)
+	assertEq(err, nil, "could not list")
+	assertEq(len(keys), 2, "objects left after abort")
+}
@@ -0,0 +1,348 @@
+package otf
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Tables can declare foreign keys: that the values of some of their
+// columns are values of columns of another table. They're checked
+// when a transaction commits, against the data as the transaction
+// sees it, so against rows it wrote itself too:
+//
+//   - rows the transaction added to the table must have a match in
+//     the referenced table, unless any of their foreign key's values
+//     is null;
+//   - rows the transaction deleted from a referenced table must not
+//     leave rows of the table without a match.
+//
+// Looking up the values added scans the referenced table filtering
+// on them, so its stats and bloom filters (see bloomindex.go) skip
+// dataobjects without them; past FOREIGN_KEY_LOOKUPS distinct values
+// it's scanned whole instead. Deleting from a referenced table scans
+// both tables whole. Checking reads the referenced table, so a
+// concurrent write to it conflicts (see conflict.go) rather than
+// slip a violation in.
+//
+// An enforced foreign key fails the commit with errForeignKey on a
+// violation. An advisory one only counts violations, in the commit's
+// event (see alerts.go), and a deferred one isn't checked at commit
+// at all: checkForeignKeys checks a table's rows whenever asked, e.g.
+// from a nightly job.
+
+const (
+	FK_ENFORCED = ""
+	FK_ADVISORY = "advisory"
+	FK_DEFERRED = "deferred"
+
+	FOREIGN_KEY_LOOKUPS = 1000
+)
+
+var (
+	errForeignKey       = fmt.Errorf("Foreign Key Violation")
+	errForeignKeyColumn = fmt.Errorf("Foreign Key Column")
+)
+
+type ForeignKey struct {
+	Columns []string
+	// The table and its columns, in the same order, that Columns'
+	// values must be found in.
+	References        string
+	ReferencedColumns []string
+	// FK_ENFORCED, FK_ADVISORY or FK_DEFERRED.
+	Enforcement string `json:",omitempty"`
+}
+
+func (fk ForeignKey) String() string {
+	return fmt.Sprintf("(%s) references %s(%s)", strings.Join(fk.Columns, ", "), fk.References, strings.Join(fk.ReferencedColumns, ", "))
+}
+
+// Rows of a table without a match for a foreign key.
+type foreignKeyViolation struct {
+	Table      string
+	ForeignKey ForeignKey
+	// Distinct values of the foreign key's columns not found.
+	Values [][]any
+}
+
+func (v foreignKeyViolation) err() error {
+	return fmt.Errorf("%w: %s%s: no %v", errForeignKey, v.Table, v.ForeignKey, v.Values[0])
+}
+
+// Declares fk on the table.
+func withForeignKey(fk ForeignKey) tableOption {
+	return func(o *tableOptions) {
+		o.foreignKeys = append(o.foreignKeys, fk)
+	}
+}
+
+func (d *client) checkForeignKeyDeclarations(table string, columns []string, fks []ForeignKey) error {
+	for _, fk := range fks {
+		for _, column := range fk.Columns {
+			if !slices.Contains(columns, column) {
+				return fmt.Errorf("%w: %s.%s", errNoColumn, table, column)
+			}
+		}
+
+		referenced := columns
+		if fk.References != table {
+			var ok bool
+			referenced, ok = d.tx.tables[fk.References]
+			if !ok {
+				return fmt.Errorf("%w: %s", errNoTable, fk.References)
+			}
+		}
+		for _, column := range fk.ReferencedColumns {
+			if !slices.Contains(referenced, column) {
+				return fmt.Errorf("%w: %s.%s", errNoColumn, fk.References, column)
+			}
+		}
+
+		if len(fk.Columns) == 0 || len(fk.Columns) != len(fk.ReferencedColumns) {
+			return fmt.Errorf("%w: %s%s doesn't pair up columns", errForeignKeyColumn, table, fk)
+		}
+		if fk.Enforcement != FK_ENFORCED && fk.Enforcement != FK_ADVISORY && fk.Enforcement != FK_DEFERRED {
+			return fmt.Errorf("%w: unknown enforcement %q", errForeignKeyColumn, fk.Enforcement)
+		}
+	}
+
+	return nil
+}
+
+// Whether column of table is in a foreign key, or referenced by one.
+func (tx *transaction) foreignKeyColumn(table, column string) bool {
+	for child, fks := range tx.foreignKeys {
+		for _, fk := range fks {
+			if child == table && slices.Contains(fk.Columns, column) {
+				return true
+			}
+			if fk.References == table && slices.Contains(fk.ReferencedColumns, column) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// What values is looked up by, false if any is null.
+func foreignKeyValue(values []any) (string, bool) {
+	var key strings.Builder
+	for _, v := range values {
+		if v == nil {
+			return "", false
+		}
+
+		// Flushed ints come back as floats.
+		k, ok := bloomKey(v)
+		if !ok {
+			k = fmt.Sprintf("?%v", v)
+		}
+		fmt.Fprintf(&key, "%d:%s", len(k), k)
+	}
+
+	return key.String(), true
+}
+
+// Distinct values of columns of table's rows, of only the rows the
+// transaction added if added.
+func (d *client) foreignKeyValues(table string, columns []string, added bool) (map[string][]any, error) {
+	values := map[string][]any{}
+	collect := func(row []any) {
+		if key, ok := foreignKeyValue(row); ok {
+			values[key] = row
+		}
+	}
+
+	if !added {
+		it, err := d.scan(table, withColumns(columns...), withoutRowFilter())
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			row, err := it.next()
+			if err != nil || row == nil {
+				return values, err
+			}
+			collect(row)
+		}
+	}
+
+	var positions []int
+	for _, column := range columns {
+		positions = append(positions, slices.Index(d.tx.tables[table], column))
+	}
+
+	actions := liveActions(slices.Concat(d.tx.previousActions[table], d.tx.Actions[table]))
+	deleted := deletedRows(actions)
+	for _, action := range d.tx.Actions[table] {
+		do := action.AddDataobject
+		if do == nil || !slices.Contains(actions, action) {
+			continue
+		}
+
+		o, err := d.readDataobject(do)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < o.Len; i++ {
+			if deleted[do][i] {
+				continue
+			}
+
+			row := make([]any, len(positions))
+			for j, position := range positions {
+				row[j] = o.Columns[position][i]
+			}
+			collect(row)
+		}
+	}
+
+	return values, nil
+}
+
+// Scan options reading columns of rows with any of values, or of
+// every row past FOREIGN_KEY_LOOKUPS values, whoever the client acts
+// as.
+func keyLookup(columns []string, values map[string][]any) []scanOption {
+	opts := []scanOption{withColumns(columns...), withoutRowFilter()}
+	if filter := keyFilter(columns, values); filter != nil {
+		opts = append(opts, withFilter(filter))
+	}
+
+	return opts
+}
+
+// A filter on rows with any of values of columns, nil past
+// FOREIGN_KEY_LOOKUPS values.
+func keyFilter(columns []string, values map[string][]any) *predicate {
+	if len(values) > FOREIGN_KEY_LOOKUPS {
+		return nil
+	}
+
+	var matches []*predicate
+	for _, key := range sortedKeys(values) {
+		var eqs []*predicate
+		for i, column := range columns {
+			eqs = append(eqs, where(column, OP_EQ, values[key][i]))
+		}
+		matches = append(matches, and(eqs...))
+	}
+	return or(matches...)
+}
+
+// Which of values aren't values of columns of table.
+func (d *client) missingForeignKeyValues(table string, columns []string, values map[string][]any) ([][]any, error) {
+	it, err := d.scan(table, keyLookup(columns, values)...)
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]bool{}
+	for {
+		row, err := it.next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+
+		if key, ok := foreignKeyValue(row); ok {
+			found[key] = true
+		}
+	}
+
+	var missing [][]any
+	for _, key := range sortedKeys(values) {
+		if !found[key] {
+			missing = append(missing, values[key])
+		}
+	}
+
+	return missing, nil
+}
+
+// Checks table's rows, only those the transaction added if added,
+// against fk.
+func (d *client) checkForeignKey(table string, fk ForeignKey, added bool) (*foreignKeyViolation, error) {
+	values, err := d.foreignKeyValues(table, fk.Columns, added)
+	if err != nil || len(values) == 0 {
+		return nil, err
+	}
+
+	missing, err := d.missingForeignKeyValues(fk.References, fk.ReferencedColumns, values)
+	if err != nil || len(missing) == 0 {
+		return nil, err
+	}
+
+	return &foreignKeyViolation{Table: table, ForeignKey: fk, Values: missing}, nil
+}
+
+// Violations of every foreign key of table by any of its rows, as of
+// the transaction, however they're enforced.
+func (d *client) checkForeignKeys(table string) ([]foreignKeyViolation, error) {
+	if d.tx == nil {
+		return nil, errNoTx
+	}
+
+	if _, ok := d.tx.tables[table]; !ok {
+		return nil, errNoTable
+	}
+
+	var violations []foreignKeyViolation
+	for _, fk := range d.tx.foreignKeys[table] {
+		v, err := d.checkForeignKey(table, fk, false)
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			violations = append(violations, *v)
+		}
+	}
+
+	return violations, nil
+}
+
+// Checks the foreign keys the transaction's writes could violate,
+// failing on a violation of an enforced one and returning how many
+// advisory ones each table violated.
+func (d *client) checkForeignKeysAtCommit() (map[string]int, error) {
+	advisory := map[string]int{}
+	for _, table := range sortedKeys(d.tx.foreignKeys) {
+		for _, fk := range d.tx.foreignKeys[table] {
+			if fk.Enforcement == FK_DEFERRED {
+				continue
+			}
+
+			addedRows := slices.ContainsFunc(d.tx.Actions[table], func(action Action) bool {
+				return action.AddDataobject != nil
+			})
+			deletedReferenced := slices.ContainsFunc(d.tx.Actions[fk.References], func(action Action) bool {
+				return action.DeleteRows != nil || action.RemoveDataobject != nil
+			})
+			if !addedRows && !deletedReferenced {
+				continue
+			}
+
+			v, err := d.checkForeignKey(table, fk, !deletedReferenced)
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				continue
+			}
+
+			if fk.Enforcement == FK_ENFORCED {
+				return nil, v.err()
+			}
+			d.warn("foreignkey", "advisory violation", "err", v.err())
+			advisory[table] += len(v.Values)
+		}
+	}
+
+	return advisory, nil
+}
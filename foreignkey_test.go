@@ -0,0 +1,122 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// Creates users(id) and orders(id, user) referencing it with
+// enforcement, with users 1 and 2.
+func newForeignKeyClient(enforcement string, opts ...clientOption) client {
+	c := newClient(newMemoryObjectStorage(), opts...)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("users", []string{"id"})
+	assertEq(err, nil, "could not create users")
+	err = c.createTable("orders", []string{"id", "user"}, withForeignKey(ForeignKey{
+		Columns:           []string{"user"},
+		References:        "users",
+		ReferencedColumns: []string{"id"},
+		Enforcement:       enforcement,
+	}))
+	assertEq(err, nil, "could not create orders")
+	for _, id := range []int{1, 2} {
+		err = c.writeRow("users", []any{id})
+		assertEq(err, nil, "could not write user")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	return c
+}
+
+func TestForeignKey(t *testing.T) {
+	c := newForeignKeyClient(FK_ENFORCED)
+
+	// Matched in the snapshot, in the transaction, or null.
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("users", []any{3})
+	assertEq(err, nil, "could not write user")
+	for _, row := range [][]any{{1, 1}, {2, 3}, {3, nil}} {
+		err = c.writeRow("orders", row)
+		assertEq(err, nil, "could not write order")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("orders", []any{4, 9})
+	assertEq(err, nil, "could not write order")
+	err = c.commitTx()
+	assert(errors.Is(err, errForeignKey), "committed an order of a missing user")
+	assertEq(err.Error(), "Foreign Key Violation: orders(user) references users(id): no [9]", "error")
+
+	// Deleting a referenced row.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.deleteRows("users", where("id", OP_EQ, 1))
+	assertEq(err, nil, "could not delete")
+	err = c.commitTx()
+	assert(errors.Is(err, errForeignKey), "committed deleting a referenced user")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.deleteRows("users", where("id", OP_EQ, 2))
+	assertEq(err, nil, "could not delete")
+	err = c.commitTx()
+	assertEq(err, nil, "could not delete an unreferenced user")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.dropColumn("users", "id")
+	assert(errors.Is(err, errForeignKeyColumn), "dropped a referenced column")
+	err = c.renameColumn("orders", "user", "customer")
+	assert(errors.Is(err, errForeignKeyColumn), "renamed a foreign key column")
+	err = c.createTable("returns", []string{"order"}, withForeignKey(ForeignKey{
+		Columns:           []string{"order"},
+		References:        "purchases",
+		ReferencedColumns: []string{"id"},
+	}))
+	assert(errors.Is(err, errNoTable), "referenced a missing table")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+}
+
+func TestForeignKeyAdvisory(t *testing.T) {
+	var violations []int
+	c := newForeignKeyClient(FK_ADVISORY, withCommitHook(func(event *commitEvent) {
+		violations = append(violations, event.Tables["orders"].ForeignKeyViolations)
+	}))
+
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	for _, row := range [][]any{{1, 1}, {2, 8}, {3, 9}, {4, 9}} {
+		err = c.writeRow("orders", row)
+		assertEq(err, nil, "could not write order")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	assertEq(fmt.Sprint(violations), "[0 2]", "violations")
+}
+
+func TestForeignKeyDeferred(t *testing.T) {
+	c := newForeignKeyClient(FK_DEFERRED)
+
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("orders", []any{1, 9})
+	assertEq(err, nil, "could not write order")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	violations, err := c.checkForeignKeys("orders")
+	assertEq(err, nil, "could not check")
+	assertEq(len(violations), 1, "violations")
+	assertEq(fmt.Sprint(violations[0].Values), "[[9]]", "missing values")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+}
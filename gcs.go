@@ -0,0 +1,241 @@
+package otf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+type gcsConfig struct {
+	Bucket string
+	// Optional, all object names are stored under this prefix.
+	Prefix string
+	// Optional, e.g. a fake-gcs-server. Defaults to
+	// $STORAGE_EMULATOR_HOST if set, otherwise Google.
+	Endpoint string
+	// Returns an OAuth2 access token for each request. Defaults
+	// to $GOOGLE_OAUTH_ACCESS_TOKEN. Leave the token empty for
+	// unauthenticated access (emulators).
+	Token func() (string, error)
+
+	// Optional, each request is logged here at debug level.
+	Logger *slog.Logger
+}
+
+// GCS writes can be made conditional on the object's generation;
+// generation 0 means "does not exist yet" which is exactly
+// putIfAbsent.
+type gcsObjectStorage struct {
+	cfg    gcsConfig
+	client *http.Client
+}
+
+func newGCSObjectStorage(cfg gcsConfig) *gcsObjectStorage {
+	assert(cfg.Bucket != "", "gcs bucket is required")
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = os.Getenv("STORAGE_EMULATOR_HOST")
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://storage.googleapis.com"
+	}
+	if !strings.Contains(cfg.Endpoint, "://") {
+		// STORAGE_EMULATOR_HOST is conventionally host:port.
+		cfg.Endpoint = "http://" + cfg.Endpoint
+	}
+	if cfg.Token == nil {
+		cfg.Token = func() (string, error) {
+			return os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"), nil
+		}
+	}
+
+	return &gcsObjectStorage{cfg, http.DefaultClient}
+}
+
+func (gcs *gcsObjectStorage) do(ctx context.Context, method, path string, query url.Values, body []byte, headers map[string]string) (*http.Response, error) {
+	u := gcs.cfg.Endpoint + path + "?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := gcs.cfg.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	return logRequest(gcs.cfg.Logger, "gcs", gcs.client, req)
+}
+
+func gcsError(res *http.Response) error {
+	body, _ := io.ReadAll(res.Body)
+	return fmt.Errorf("gcs %s %s: %s: %s", res.Request.Method, res.Request.URL.Path, res.Status, body)
+}
+
+func (gcs *gcsObjectStorage) putIfAbsent(ctx context.Context, name string, bytes []byte) error {
+	query := url.Values{}
+	query.Set("uploadType", "media")
+	query.Set("name", gcs.cfg.Prefix+name)
+	query.Set("ifGenerationMatch", "0")
+
+	res, err := gcs.do(ctx, http.MethodPost, "/upload/storage/v1/b/"+url.PathEscape(gcs.cfg.Bucket)+"/o", query, bytes, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusPreconditionFailed {
+		return fmt.Errorf("%w: %s", fs.ErrExist, name)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return gcsError(res)
+	}
+
+	return nil
+}
+
+type gcsListResult struct {
+	Items []struct {
+		Name string `json:"name"`
+	} `json:"items"`
+	NextPageToken string `json:"nextPageToken"`
+}
+
+func (gcs *gcsObjectStorage) listPrefix(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("prefix", gcs.cfg.Prefix+prefix)
+		query.Set("fields", "items(name),nextPageToken")
+		if token != "" {
+			query.Set("pageToken", token)
+		}
+
+		res, err := gcs.do(ctx, http.MethodGet, "/storage/v1/b/"+url.PathEscape(gcs.cfg.Bucket)+"/o", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode != http.StatusOK {
+			err = gcsError(res)
+			res.Body.Close()
+			return nil, err
+		}
+
+		var result gcsListResult
+		err = json.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range result.Items {
+			names = append(names, strings.TrimPrefix(item.Name, gcs.cfg.Prefix))
+		}
+
+		if result.NextPageToken == "" {
+			break
+		}
+		token = result.NextPageToken
+	}
+
+	slices.Sort(names)
+	return names, nil
+}
+
+func (gcs *gcsObjectStorage) objectPath(name string) string {
+	return "/storage/v1/b/" + url.PathEscape(gcs.cfg.Bucket) + "/o/" + url.PathEscape(gcs.cfg.Prefix+name)
+}
+
+func (gcs *gcsObjectStorage) delete(ctx context.Context, name string) error {
+	res, err := gcs.do(ctx, http.MethodDelete, gcs.objectPath(name), url.Values{}, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", fs.ErrNotExist, name)
+	}
+
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+		return gcsError(res)
+	}
+
+	return nil
+}
+
+func (gcs *gcsObjectStorage) stat(ctx context.Context, name string) (objectInfo, error) {
+	res, err := gcs.do(ctx, http.MethodGet, gcs.objectPath(name), url.Values{}, nil, nil)
+	if err != nil {
+		return objectInfo{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return objectInfo{}, fmt.Errorf("%w: %s", fs.ErrNotExist, name)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return objectInfo{}, gcsError(res)
+	}
+
+	var metadata struct {
+		// GCS encodes 64-bit integers as strings.
+		Size    int64 `json:",string"`
+		Updated time.Time
+	}
+	err = json.NewDecoder(res.Body).Decode(&metadata)
+	if err != nil {
+		return objectInfo{}, err
+	}
+
+	return objectInfo{Size: metadata.Size, Modified: metadata.Updated}, nil
+}
+
+func (gcs *gcsObjectStorage) read(ctx context.Context, name string) ([]byte, error) {
+	return gcs.get(ctx, name, nil, http.StatusOK)
+}
+
+func (gcs *gcsObjectStorage) readRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	return gcs.get(ctx, name, map[string]string{
+		"Range": fmt.Sprintf("bytes=%d-%d", offset, offset+length-1),
+	}, http.StatusPartialContent)
+}
+
+func (gcs *gcsObjectStorage) get(ctx context.Context, name string, headers map[string]string, status int) ([]byte, error) {
+	query := url.Values{}
+	query.Set("alt", "media")
+	res, err := gcs.do(ctx, http.MethodGet, gcs.objectPath(name), query, nil, headers)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", fs.ErrNotExist, name)
+	}
+
+	if res.StatusCode != status {
+		return nil, gcsError(res)
+	}
+
+	return io.ReadAll(res.Body)
+}
@@ -0,0 +1,124 @@
+package otf
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Just enough of the GCS JSON API for gcsObjectStorage, in the
+// spirit of fake-gcs-server.
+type fakeGCS struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	// Forces pagination in listings.
+	pageSize int
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/bucket/o":
+		name := query.Get("name")
+		if _, ok := f.objects[name]; ok && query.Get("ifGenerationMatch") == "0" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		f.objects[name] = body
+		json.NewEncoder(w).Encode(map[string]string{"name": name})
+	case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/bucket/o":
+		var names []string
+		for name := range f.objects {
+			if strings.HasPrefix(name, query.Get("prefix")) && name > query.Get("pageToken") {
+				names = append(names, name)
+			}
+		}
+		slices.Sort(names)
+
+		var result gcsListResult
+		for i, name := range names {
+			if i == f.pageSize {
+				result.NextPageToken = names[i-1]
+				break
+			}
+			result.Items = append(result.Items, struct {
+				Name string `json:"name"`
+			}{name})
+		}
+		json.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/storage/v1/b/bucket/o/"):
+		name, _ := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), "/storage/v1/b/bucket/o/"))
+		body, ok := f.objects[name]
+		if !ok || query.Get("alt") != "media" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Write(body)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestGCSConcurrentTableWriters(t *testing.T) {
+	server := httptest.NewServer(&fakeGCS{objects: map[string][]byte{}, pageSize: 2})
+	defer server.Close()
+
+	gcs := newGCSObjectStorage(gcsConfig{
+		Bucket:   "bucket",
+		Prefix:   "db/",
+		Endpoint: server.URL,
+		Token:    func() (string, error) { return "token", nil },
+	})
+	c1Writer := newClient(gcs)
+	c2Writer := newClient(gcs)
+
+	err := c1Writer.newTx()
+	assertEq(err, nil, "could not start c1 tx")
+	err = c1Writer.createTable("x", []string{"a", "b"})
+	assertEq(err, nil, "could not create x")
+	err = c1Writer.commitTx()
+	assertEq(err, nil, "could not commit c1 tx")
+
+	// Enough commits that listing the log needs several pages.
+	for i := 0; i < 4; i++ {
+		err = c1Writer.newTx()
+		assertEq(err, nil, "could not start c1 tx")
+		err = c1Writer.writeRow("x", []any{"Joey", i})
+		assertEq(err, nil, "could not write row")
+		err = c1Writer.commitTx()
+		assertEq(err, nil, "could not commit c1 tx")
+	}
+
+	err = c2Writer.newTx()
+	assertEq(err, nil, "could not start c2 tx")
+	err = c1Writer.newTx()
+	assertEq(err, nil, "could not start c1 tx")
+	err = c1Writer.writeRow("x", []any{"Yue", 5})
+	assertEq(err, nil, "could not write row")
+	err = c1Writer.commitTx()
+	assertEq(err, nil, "could not commit c1 tx")
+
+	err = c2Writer.writeRow("x", []any{"Holly", 6})
+	assertEq(err, nil, "could not write row")
+	err = c2Writer.commitTx()
+	assert(err != nil, "concurrent commit must fail")
+
+	assertEq(countRows(&c2Writer, "x"), 5, "rows in x")
+}
@@ -1,3 +1,5 @@
 module github.com/eatonphil/otf
 
 go 1.22.3
+
+require github.com/klauspost/compress v1.17.11
@@ -0,0 +1,671 @@
+package otf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// `otf serve` also speaks gRPC, the service in otf.proto, on the
+// same address as its HTTP/JSON API (see server.go) and sharing its
+// transactions. gRPC runs over HTTP/2, which the server only offers
+// over TLS. Messages are encoded by proto.go rather than generated
+// code, and grpcClient is a client for it in the same vein.
+//
+// Scan is server-streaming: rows are sent in batches of
+// GRPC_SCAN_BATCH as the scan reads them, so neither side holds
+// more than a batch at a time.
+
+const GRPC_SCAN_BATCH = 1024
+
+// Status codes, see
+// https://grpc.github.io/grpc/core/md_doc_statuscodes.html.
+const (
+	GRPC_OK                  = 0
+	GRPC_INVALID_ARGUMENT    = 3
+	GRPC_NOT_FOUND           = 5
+	GRPC_ALREADY_EXISTS      = 6
+	GRPC_FAILED_PRECONDITION = 9
+	GRPC_ABORTED             = 10
+	GRPC_UNIMPLEMENTED       = 12
+	GRPC_INTERNAL            = 13
+	GRPC_UNAVAILABLE         = 14
+	GRPC_UNAUTHENTICATED     = 16
+)
+
+type grpcStatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *grpcStatusError) Error() string {
+	return fmt.Sprintf("grpc status %d: %s", e.Code, e.Message)
+}
+
+// So callers can check for conflicts with errors.Is as they would
+// with a local client.
+func (e *grpcStatusError) Unwrap() error {
+	if e.Code == GRPC_ABORTED {
+		return errConflict
+	}
+
+	return nil
+}
+
+func grpcCode(err error) int {
+	var status *grpcStatusError
+	switch {
+	case errors.As(err, &status):
+		return status.Code
+	case errors.Is(err, errConflict):
+		return GRPC_ABORTED
+	case errors.Is(err, errUnknownTx), errors.Is(err, errNoTable), errors.Is(err, errNoColumn), errors.Is(err, errNoVersion):
+		return GRPC_NOT_FOUND
+	case errors.Is(err, errTableExists), errors.Is(err, errColumnExists):
+		return GRPC_ALREADY_EXISTS
+	case errors.Is(err, errHistoricalTx), errors.Is(err, errReadOnlyTx):
+		return GRPC_FAILED_PRECONDITION
+	case errors.Is(err, errUnsafeStorage):
+		return GRPC_UNAVAILABLE
+	case errors.Is(err, errBadRequest), errors.Is(err, errProto), errors.Is(err, errInvalidRow),
+		errors.Is(err, errTypeMismatch), errors.Is(err, errPartitionColumn):
+		return GRPC_INVALID_ARGUMENT
+	}
+
+	return GRPC_INTERNAL
+}
+
+func isGRPC(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// Reads one length-prefixed message, io.EOF if there are no more.
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	_, err := io.ReadFull(r, prefix[:])
+	if err != nil {
+		return nil, err
+	}
+
+	if prefix[0] != 0 {
+		return nil, fmt.Errorf("%w: compressed messages aren't supported", errProto)
+	}
+
+	msg := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+	_, err = io.ReadFull(r, msg)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return msg, err
+}
+
+func grpcFrame(msg []byte) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+// A method handler gets the request message and sends response
+// messages, one unless it streams.
+type grpcMethod func(s *server, identity string, req []byte, send func([]byte) error) error
+
+var grpcMethods = map[string]grpcMethod{
+	"/otf.Otf/BeginTx":     (*server).grpcBeginTx,
+	"/otf.Otf/CreateTable": (*server).grpcCreateTable,
+	"/otf.Otf/WriteRows":   (*server).grpcWriteRows,
+	"/otf.Otf/Scan":        (*server).grpcScan,
+	"/otf.Otf/Commit":      (*server).grpcCommit,
+	"/otf.Otf/Abort":       (*server).grpcAbort,
+}
+
+func (s *server) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+	w.WriteHeader(http.StatusOK)
+
+	err := func() error {
+		identity, ok := s.identify(r)
+		if !ok {
+			return &grpcStatusError{GRPC_UNAUTHENTICATED, "unauthorized"}
+		}
+
+		method, ok := grpcMethods[r.URL.Path]
+		if !ok {
+			return &grpcStatusError{GRPC_UNIMPLEMENTED, r.URL.Path}
+		}
+
+		req, err := readGRPCMessage(r.Body)
+		if err != nil {
+			return fmt.Errorf("%w: %s", errBadRequest, err)
+		}
+
+		return method(s, identity, req, func(msg []byte) error {
+			_, err := w.Write(grpcFrame(msg))
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			return err
+		})
+	}()
+
+	code := GRPC_OK
+	if err != nil {
+		code = grpcCode(err)
+		logTo(s.logger, slog.LevelDebug, "grpc", "call failed", "method", r.URL.Path, "code", code, "err", err)
+		w.Header().Set("Grpc-Message", url.PathEscape(err.Error()))
+	}
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+}
+
+// The string field of the request, e.g. its transaction.
+func protoStringField(req []byte, num int) (string, error) {
+	s := ""
+	err := protoFields(req, func(f protoField) error {
+		if f.num == num {
+			s = f.string()
+		}
+		return nil
+	})
+
+	return s, err
+}
+
+func (s *server) grpcBeginTx(identity string, req []byte, send func([]byte) error) error {
+	var at *int
+	err := protoFields(req, func(f protoField) error {
+		if f.num == 1 {
+			id := int(int64(f.varint))
+			at = &id
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	tx, id, err := s.begin(identity, at, false)
+	if err != nil {
+		return err
+	}
+
+	var res protoEncoder
+	res.string(1, tx)
+	res.int(2, int64(id))
+	return send(res.b)
+}
+
+func (s *server) grpcCreateTable(identity string, req []byte, send func([]byte) error) error {
+	var tx, table string
+	var columns, partitionBy []string
+	types := map[string]string{}
+	err := protoFields(req, func(f protoField) error {
+		switch f.num {
+		case 1:
+			tx = f.string()
+		case 2:
+			table = f.string()
+		case 3:
+			columns = append(columns, f.string())
+		case 4:
+			var column, typ string
+			err := protoFields(f.bytes, func(entry protoField) error {
+				if entry.num == 1 {
+					column = entry.string()
+				} else if entry.num == 2 {
+					typ = entry.string()
+				}
+				return nil
+			})
+			types[column] = typ
+			return err
+		case 5:
+			partitionBy = append(partitionBy, f.string())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.useTx(identity, tx, func(c *client) error {
+		opts := []tableOption{withPartitionColumns(partitionBy...)}
+		if len(types) > 0 {
+			opts = append(opts, withColumnTypes(types))
+		}
+		return c.createTable(table, columns, opts...)
+	})
+	if err != nil {
+		return err
+	}
+
+	return send(nil)
+}
+
+func (s *server) grpcWriteRows(identity string, req []byte, send func([]byte) error) error {
+	var tx, table string
+	var rows [][]any
+	err := protoFields(req, func(f protoField) error {
+		switch f.num {
+		case 1:
+			tx = f.string()
+		case 2:
+			table = f.string()
+		case 3:
+			row, err := decodeProtoRow(f.bytes)
+			rows = append(rows, row)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.useTx(identity, tx, func(c *client) error {
+		for i, row := range rows {
+			err := c.writeRow(table, row)
+			if err != nil {
+				// Rows before it stay written.
+				return fmt.Errorf("row %d: %w", i, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var res protoEncoder
+	res.int(1, int64(len(rows)))
+	return send(res.b)
+}
+
+func (s *server) grpcScan(identity string, req []byte, send func([]byte) error) error {
+	var tx, table string
+	var columns []string
+	limit := 0
+	err := protoFields(req, func(f protoField) error {
+		switch f.num {
+		case 1:
+			tx = f.string()
+		case 2:
+			table = f.string()
+		case 3:
+			columns = append(columns, f.string())
+		case 4:
+			limit = int(int64(f.varint))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.useTx(identity, tx, func(c *client) error {
+		if _, ok := c.tx.tables[table]; !ok {
+			return fmt.Errorf("%w: %s", errNoTable, table)
+		}
+
+		var opts []scanOption
+		if columns != nil {
+			opts = append(opts, withColumns(columns...))
+		} else {
+			columns = c.tx.tables[table]
+		}
+		it, err := c.scan(table, opts...)
+		if err != nil {
+			return err
+		}
+
+		// The first response always goes out, with the
+		// columns, even if there are no rows.
+		var res protoEncoder
+		res.strings(1, columns)
+		batched, sent := 0, 0
+		for limit <= 0 || sent < limit {
+			row, err := it.next()
+			if err != nil {
+				return err
+			}
+			if row == nil {
+				break
+			}
+
+			b, err := encodeProtoRow(row)
+			if err != nil {
+				return err
+			}
+			res.bytes(2, b)
+			batched++
+			sent++
+
+			if batched == GRPC_SCAN_BATCH {
+				err = send(res.b)
+				if err != nil {
+					return err
+				}
+				res = protoEncoder{}
+				batched = 0
+			}
+		}
+
+		if batched > 0 || sent == 0 {
+			return send(res.b)
+		}
+		return nil
+	})
+}
+
+func (s *server) grpcCommit(identity string, req []byte, send func([]byte) error) error {
+	tx, err := protoStringField(req, 1)
+	if err != nil {
+		return err
+	}
+
+	err = s.canary.checkSafe()
+	if err != nil {
+		return err
+	}
+
+	var id int
+	err = s.useTx(identity, tx, func(c *client) error {
+		id = c.tx.Id
+		return c.commitTx()
+	})
+	if err != nil {
+		return err
+	}
+
+	var res protoEncoder
+	res.int(1, int64(id))
+	return send(res.b)
+}
+
+func (s *server) grpcAbort(identity string, req []byte, send func([]byte) error) error {
+	tx, err := protoStringField(req, 1)
+	if err != nil {
+		return err
+	}
+
+	err = s.useTx(identity, tx, func(c *client) error {
+		return c.abortTx()
+	})
+	if err != nil {
+		return err
+	}
+
+	return send(nil)
+}
+
+// Calls the service at baseURL. hc must speak HTTP/2, e.g. an
+// http.Client whose transport has ForceAttemptHTTP2 set and TLS
+// configured.
+type grpcClient struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+func newGRPCClient(baseURL, token string, hc *http.Client) *grpcClient {
+	return &grpcClient{strings.TrimSuffix(baseURL, "/"), token, hc}
+}
+
+// Responses of a call, as they arrive.
+type grpcResponses struct {
+	res *http.Response
+}
+
+// The next response message, nil once there are no more and the
+// call succeeded.
+func (gr *grpcResponses) next() ([]byte, error) {
+	msg, err := readGRPCMessage(gr.res.Body)
+	if err == nil {
+		return msg, nil
+	}
+	gr.res.Body.Close()
+	if err != io.EOF {
+		return nil, err
+	}
+
+	// Trailers, or headers if the call failed before responding.
+	status := gr.res.Trailer.Get("Grpc-Status")
+	message := gr.res.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status = gr.res.Header.Get("Grpc-Status")
+		message = gr.res.Header.Get("Grpc-Message")
+	}
+
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return nil, fmt.Errorf("%w: no grpc status", errProto)
+	}
+	if code != GRPC_OK {
+		message, _ = url.PathUnescape(message)
+		return nil, &grpcStatusError{code, message}
+	}
+
+	return nil, nil
+}
+
+func (gc *grpcClient) call(method string, req []byte) (*grpcResponses, error) {
+	httpReq, err := http.NewRequest("POST", gc.baseURL+"/otf.Otf/"+method, bytes.NewReader(grpcFrame(req)))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/grpc")
+	httpReq.Header.Set("TE", "trailers")
+	if gc.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+gc.token)
+	}
+
+	res, err := gc.http.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("grpc call %s: HTTP %s", method, res.Status)
+	}
+
+	return &grpcResponses{res}, nil
+}
+
+// Calls a method with a single response.
+func (gc *grpcClient) unary(method string, req []byte) ([]byte, error) {
+	responses, err := gc.call(method, req)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := responses.next()
+	if err != nil {
+		return nil, err
+	}
+
+	// Reads the status.
+	_, err = responses.next()
+	return res, err
+}
+
+// Begins a transaction, as of the committed transaction at if not
+// nil, returning the id to refer to it by and its transaction id.
+func (gc *grpcClient) beginTx(at *int) (string, int, error) {
+	var req protoEncoder
+	if at != nil {
+		req.int(1, int64(*at))
+	}
+
+	res, err := gc.unary("BeginTx", req.b)
+	if err != nil {
+		return "", 0, err
+	}
+
+	var tx string
+	var id int
+	err = protoFields(res, func(f protoField) error {
+		switch f.num {
+		case 1:
+			tx = f.string()
+		case 2:
+			id = int(int64(f.varint))
+		}
+		return nil
+	})
+
+	return tx, id, err
+}
+
+func (gc *grpcClient) createTable(tx, table string, columns []string, types map[string]string, partitionBy []string) error {
+	var req protoEncoder
+	req.string(1, tx)
+	req.string(2, table)
+	req.strings(3, columns)
+	for column, typ := range types {
+		var entry protoEncoder
+		entry.string(1, column)
+		entry.string(2, typ)
+		req.bytes(4, entry.b)
+	}
+	req.strings(5, partitionBy)
+
+	_, err := gc.unary("CreateTable", req.b)
+	return err
+}
+
+func (gc *grpcClient) writeRows(tx, table string, rows [][]any) (int, error) {
+	var req protoEncoder
+	req.string(1, tx)
+	req.string(2, table)
+	for _, row := range rows {
+		b, err := encodeProtoRow(row)
+		if err != nil {
+			return 0, err
+		}
+		req.bytes(3, b)
+	}
+
+	res, err := gc.unary("WriteRows", req.b)
+	if err != nil {
+		return 0, err
+	}
+
+	written := 0
+	err = protoFields(res, func(f protoField) error {
+		if f.num == 1 {
+			written = int(int64(f.varint))
+		}
+		return nil
+	})
+
+	return written, err
+}
+
+// Rows of a streaming scan.
+type grpcScanIterator struct {
+	responses *grpcResponses
+	columns   []string
+	// The rest of the latest response's rows.
+	rows [][]any
+	done bool
+}
+
+// Scans columns of table, all if nil, stopping after limit rows if
+// it's positive. The iterator must be read to the end, or the call
+// is left open.
+func (gc *grpcClient) scan(tx, table string, columns []string, limit int) (*grpcScanIterator, error) {
+	var req protoEncoder
+	req.string(1, tx)
+	req.string(2, table)
+	req.strings(3, columns)
+	if limit > 0 {
+		req.int(4, int64(limit))
+	}
+
+	responses, err := gc.call("Scan", req.b)
+	if err != nil {
+		return nil, err
+	}
+
+	it := &grpcScanIterator{responses: responses}
+	// The first response has the columns.
+	err = it.receive()
+	if err != nil {
+		return nil, err
+	}
+
+	return it, nil
+}
+
+func (it *grpcScanIterator) receive() error {
+	res, err := it.responses.next()
+	if err != nil {
+		return err
+	}
+	if res == nil {
+		it.done = true
+		return nil
+	}
+
+	return protoFields(res, func(f protoField) error {
+		switch f.num {
+		case 1:
+			it.columns = append(it.columns, f.string())
+		case 2:
+			row, err := decodeProtoRow(f.bytes)
+			it.rows = append(it.rows, row)
+			return err
+		}
+		return nil
+	})
+}
+
+// Returns (nil, nil) when done.
+func (it *grpcScanIterator) next() ([]any, error) {
+	for len(it.rows) == 0 {
+		if it.done {
+			return nil, nil
+		}
+
+		err := it.receive()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	row := it.rows[0]
+	it.rows = it.rows[1:]
+	return row, nil
+}
+
+func (gc *grpcClient) commit(tx string) (int, error) {
+	var req protoEncoder
+	req.string(1, tx)
+	res, err := gc.unary("Commit", req.b)
+	if err != nil {
+		return 0, err
+	}
+
+	id := 0
+	err = protoFields(res, func(f protoField) error {
+		if f.num == 1 {
+			id = int(int64(f.varint))
+		}
+		return nil
+	})
+
+	return id, err
+}
+
+func (gc *grpcClient) abort(tx string) error {
+	var req protoEncoder
+	req.string(1, tx)
+	_, err := gc.unary("Abort", req.b)
+	return err
+}
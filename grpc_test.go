@@ -0,0 +1,104 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newGRPCTestServer(s *server) (*httptest.Server, *grpcClient) {
+	srv := httptest.NewUnstartedServer(s)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	return srv, newGRPCClient(srv.URL, s.token, srv.Client())
+}
+
+func TestGRPC(t *testing.T) {
+	srv, gc := newGRPCTestServer(newServer(newMemoryObjectStorage(), "secret"))
+	defer srv.Close()
+
+	tx, _, err := gc.beginTx(nil)
+	assertEq(err, nil, "could not begin")
+	err = gc.createTable(tx, "x", []string{"a", "b", "c"}, map[string]string{"c": COLUMN_TIMESTAMP}, nil)
+	assertEq(err, nil, "could not create x")
+	err = gc.createTable(tx, "x", []string{"a"}, nil, nil)
+	assertEq(grpcCode(err), GRPC_ALREADY_EXISTS, fmt.Sprint("created x again: ", err))
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+	var rows [][]any
+	for i := 0; i < GRPC_SCAN_BATCH+10; i++ {
+		rows = append(rows, []any{i, fmt.Sprint(i), at})
+	}
+	rows[1] = []any{-1.5, nil, nil}
+	written, err := gc.writeRows(tx, "x", rows)
+	assertEq(err, nil, "could not write rows")
+	assertEq(written, len(rows), "rows written")
+	id, err := gc.commit(tx)
+	assertEq(err, nil, "could not commit")
+	assertEq(id, 0, "committed as")
+
+	tx, _, err = gc.beginTx(nil)
+	assertEq(err, nil, "could not begin")
+	it, err := gc.scan(tx, "x", nil, 0)
+	assertEq(err, nil, "could not scan")
+	assertEq(fmt.Sprint(it.columns), "[a b c]", "columns")
+	var scanned [][]any
+	for {
+		row, err := it.next()
+		assertEq(err, nil, "could not read row")
+		if row == nil {
+			break
+		}
+		scanned = append(scanned, row)
+	}
+	assertEq(len(scanned), len(rows), "rows scanned")
+	assertEq(fmt.Sprint(scanned[:3]), fmt.Sprint(rows[:3]), "first rows")
+
+	it, err = gc.scan(tx, "x", []string{"b"}, 2)
+	assertEq(err, nil, "could not scan")
+	row, err := it.next()
+	assertEq(err, nil, "could not read row")
+	assertEq(fmt.Sprint(row), "[0]", "projected row")
+	row, err = it.next()
+	assertEq(err, nil, "could not read row")
+	assertEq(fmt.Sprint(row), "[<nil>]", "projected row")
+	row, err = it.next()
+	assertEq(err, nil, "could not finish")
+	assert(row == nil, "limited")
+
+	_, err = gc.scan(tx, "y", nil, 0)
+	assertEq(grpcCode(err), GRPC_NOT_FOUND, "scanned missing table")
+	err = gc.abort(tx)
+	assertEq(err, nil, "could not abort")
+	err = gc.abort(tx)
+	assertEq(grpcCode(err), GRPC_NOT_FOUND, "aborted twice")
+}
+
+func TestGRPCConflict(t *testing.T) {
+	srv, gc := newGRPCTestServer(newServer(newMemoryObjectStorage(), ""))
+	defer srv.Close()
+
+	tx1, _, err := gc.beginTx(nil)
+	assertEq(err, nil, "could not begin")
+	tx2, _, err := gc.beginTx(nil)
+	assertEq(err, nil, "could not begin")
+	for _, tx := range []string{tx1, tx2} {
+		err = gc.createTable(tx, "x", []string{"a"}, nil, nil)
+		assertEq(err, nil, "could not create x")
+	}
+	_, err = gc.commit(tx1)
+	assertEq(err, nil, "could not commit")
+	_, err = gc.commit(tx2)
+	assert(errors.Is(err, errConflict), "conflict")
+}
+
+func TestGRPCUnauthenticated(t *testing.T) {
+	srv, gc := newGRPCTestServer(newServer(newMemoryObjectStorage(), "secret"))
+	defer srv.Close()
+
+	gc.token = "wrong"
+	_, _, err := gc.beginTx(nil)
+	assertEq(grpcCode(err), GRPC_UNAUTHENTICATED, "status")
+}
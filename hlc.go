@@ -0,0 +1,105 @@
+package otf
+
+import (
+	"errors"
+	"io/fs"
+	"time"
+)
+
+// Log ids order commits, and a commitClock decides the time each
+// one records. The default records the committing client's wall
+// clock, which with several writers on skewed clocks can go
+// backwards from one log entry to the next, while time travel (see
+// timetravel.go) assumes it doesn't.
+//
+// A hybrid logical clock (HLC) instead also records an HLC
+// timestamp: the later of the wall clock and the previous entry's
+// HLC time, with a logical counter breaking ties. HLC times then
+// strictly increase with log ids whatever the clocks say, and stay
+// close to real time as long as most clocks are roughly right.
+// Opening a transaction as of a time compares against HLC times
+// where they're recorded. The wall clock is still recorded as
+// Timestamp.
+
+type HLCTimestamp struct {
+	Physical time.Time
+	Logical  int `json:",omitempty"`
+}
+
+func (a HLCTimestamp) after(b HLCTimestamp) bool {
+	if a.Physical.Equal(b.Physical) {
+		return a.Logical > b.Logical
+	}
+
+	return a.Physical.After(b.Physical)
+}
+
+// The time the commit is ordered by.
+func (ci *CommitInfo) time() time.Time {
+	if ci.HLC != nil {
+		return ci.HLC.Physical
+	}
+
+	return ci.Timestamp
+}
+
+type commitClock interface {
+	// Commit info for the entry after previous, which returns nil
+	// if there is none.
+	commitInfo(previous func() (*CommitInfo, error)) (*CommitInfo, error)
+}
+
+func withCommitClock(clock commitClock) clientOption {
+	return func(c *client) {
+		c.clock = clock
+	}
+}
+
+// The default.
+type wallClock struct{}
+
+func (wallClock) commitInfo(func() (*CommitInfo, error)) (*CommitInfo, error) {
+	return &CommitInfo{Timestamp: time.Now().UTC()}, nil
+}
+
+type hybridLogicalClock struct{}
+
+func (hybridLogicalClock) commitInfo(previous func() (*CommitInfo, error)) (*CommitInfo, error) {
+	now := time.Now().UTC()
+	info := &CommitInfo{Timestamp: now, HLC: &HLCTimestamp{Physical: now}}
+
+	prev, err := previous()
+	if err != nil || prev == nil {
+		return info, err
+	}
+
+	last := HLCTimestamp{Physical: prev.Timestamp}
+	if prev.HLC != nil {
+		last = *prev.HLC
+	}
+	if !info.HLC.after(last) {
+		info.HLC = &HLCTimestamp{Physical: last.Physical, Logical: last.Logical + 1}
+	}
+
+	return info, nil
+}
+
+// The commit info of the entry before the one the transaction
+// commits as, nil if it's the first.
+func (d *client) previousCommit() (*CommitInfo, error) {
+	if d.tx.lastCommit != nil || d.tx.Id == 0 {
+		return d.tx.lastCommit, nil
+	}
+
+	// Replay started from a checkpoint past every entry.
+	prev, err := d.readLogEntry(d.logName(d.tx.Id - 1))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	d.tx.lastCommit = prev.CommitInfo
+	return prev.CommitInfo, nil
+}
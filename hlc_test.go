@@ -0,0 +1,89 @@
+package otf
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHybridLogicalClock(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos, withCommitClock(hybridLogicalClock{}))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	created := time.Now()
+
+	// A writer whose clock is an hour fast.
+	skewed := time.Now().Add(time.Hour).UTC()
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c.flushRows("x")
+	assertEq(err, nil, "could not flush")
+	c.tx.CommitInfo = &CommitInfo{Timestamp: skewed}
+	bytes, err := encodeLogEntry(c.tx.logEntry())
+	assertEq(err, nil, "could not marshal")
+	err = mos.putIfAbsent(context.Background(), logEntryName(c.tx.Id), bytes)
+	assertEq(err, nil, "could not commit")
+	c.tx = nil
+
+	for i := 2; i <= 3; i++ {
+		err = c.newTx()
+		assertEq(err, nil, "could not start tx")
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+
+		entry, err := c.readLogEntry(logEntryName(i))
+		assertEq(err, nil, "could not read entry")
+		assert(entry.CommitInfo.Timestamp.Before(skewed), "wall clock recorded")
+		assert(entry.CommitInfo.HLC.Physical.Equal(skewed), "hlc caught up with the fast clock")
+		assertEq(entry.CommitInfo.HLC.Logical, i-1, "logical counter")
+	}
+
+	// Nothing after creating x was committed by now, as far as the
+	// clocks agree.
+	err = c.newTxAsOf(created)
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(scanAll(&c, "x")), 0, "rows as of creation")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+
+	err = c.newTxAsOf(skewed.Add(time.Second))
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(scanAll(&c, "x")), 3, "rows")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+}
+
+func TestHybridLogicalClockRebase(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c1 := newClient(mos, withCommitClock(hybridLogicalClock{}))
+	c2 := newClient(mos, withCommitClock(hybridLogicalClock{}))
+	err := c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c2.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c1.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c2.createTable("y", []string{"a"})
+	assertEq(err, nil, "could not create y")
+	err = c1.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Commits as the entry after c1's.
+	err = c2.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	first, err := c1.readLogEntry(logEntryName(0))
+	assertEq(err, nil, "could not read entry")
+	second, err := c1.readLogEntry(logEntryName(1))
+	assertEq(err, nil, "could not read entry")
+	assert(second.CommitInfo.HLC.after(*first.CommitInfo.HLC), "hlc increases")
+}
@@ -0,0 +1,140 @@
+package otf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var errReadOnly = fmt.Errorf("Read Only Storage")
+
+// Reads a published bundle (see publish.go) over plain HTTP(S),
+// e.g. from a CDN. Read-only.
+type httpObjectStorage struct {
+	baseURL string
+	client  *http.Client
+
+	// Loaded on first use.
+	mu          sync.Mutex
+	manifest    *bundleManifest
+	manifestErr error
+	objects     map[string]bundleObject
+}
+
+func newHTTPObjectStorage(baseURL string) *httpObjectStorage {
+	return &httpObjectStorage{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  http.DefaultClient,
+	}
+}
+
+func (hos *httpObjectStorage) get(ctx context.Context, name string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hos.baseURL+"/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := hos.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", fs.ErrNotExist, name)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http GET %s: %s", res.Request.URL, res.Status)
+	}
+
+	return io.ReadAll(res.Body)
+}
+
+func (hos *httpObjectStorage) loadManifest(ctx context.Context) error {
+	hos.mu.Lock()
+	defer hos.mu.Unlock()
+	if hos.manifest != nil || hos.manifestErr != nil {
+		return hos.manifestErr
+	}
+
+	bytes, err := hos.get(ctx, BUNDLE_MANIFEST)
+	if err != nil {
+		// Only this caller gave up, the next one tries again.
+		if ctx.Err() == nil {
+			hos.manifestErr = err
+		}
+		return err
+	}
+
+	var m bundleManifest
+	err = json.Unmarshal(bytes, &m)
+	if err == nil && m.Format != BUNDLE_FORMAT {
+		err = fmt.Errorf("not an otf bundle: %s", hos.baseURL)
+	}
+	if err != nil {
+		hos.manifestErr = err
+		return err
+	}
+
+	hos.manifest = &m
+	hos.objects = map[string]bundleObject{}
+	for _, o := range m.Objects {
+		hos.objects[o.Name] = o
+	}
+	return nil
+}
+
+func (hos *httpObjectStorage) putIfAbsent(ctx context.Context, name string, bytes []byte) error {
+	return errReadOnly
+}
+
+func (hos *httpObjectStorage) delete(ctx context.Context, name string) error {
+	return errReadOnly
+}
+
+func (hos *httpObjectStorage) listPrefix(ctx context.Context, prefix string) ([]string, error) {
+	err := hos.loadManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range hos.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+	return names, nil
+}
+
+func (hos *httpObjectStorage) read(ctx context.Context, name string) ([]byte, error) {
+	err := hos.loadManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	o, ok := hos.objects[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", fs.ErrNotExist, name)
+	}
+
+	bytes, err := hos.get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Encoding == "gzip" {
+		return gunzipBytes(bytes)
+	}
+
+	return bytes, nil
+}
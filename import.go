@@ -0,0 +1,205 @@
+package otf
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+// Bulk loads CSV or JSON lines into an existing table, streaming the
+// input so it needn't fit in memory. Rows go through writeRow, so
+// they're checked against the table's schema and flushed into
+// dataobjects every DATAOBJECT_SIZE rows as they're read.
+//
+// CSV input starts with a header naming the table columns in the
+// order the fields are in. Fields are strings, so they're parsed as
+// their column's type if it has one, with empty fields null.
+// Untyped columns keep fields as strings.
+//
+// JSON lines input has one row per line, an object mapping column
+// names to values or an array of values in column order, the same as
+// the elements insert takes (see cli.go).
+//
+// Columns missing from either are null, unknown columns are an error.
+
+const (
+	IMPORT_CSV   = "csv"
+	IMPORT_JSONL = "jsonl"
+)
+
+var errUnknownFormat = fmt.Errorf("Unknown Format")
+
+type importResult struct {
+	// Rows written, not counting ones skipped.
+	Rows int
+	// Rows of the input read so far, skipped ones included, which
+	// is how many to skip to carry on after a failure.
+	Read int
+}
+
+// Writes rows read from r in format to table in the current
+// transaction, returning how many were written. Rows before a
+// failing one have still been written to the transaction.
+func (d *client) importFrom(table string, r io.Reader, format string) (int, error) {
+	result, err := d.importContext(context.Background(), table, r, format, 0)
+	if result == nil {
+		return 0, err
+	}
+
+	return result.Rows, err
+}
+
+// Like importFrom but skips the first skip rows of r, and stops
+// before the next row once ctx is done, see bulk.go.
+func (d *client) importContext(ctx context.Context, table string, r io.Reader, format string, skip int) (*importResult, error) {
+	if d.tx == nil {
+		return nil, errNoTx
+	}
+
+	columns, ok := d.tx.tables[table]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errNoTable, table)
+	}
+
+	result := &importResult{}
+	write := func(row []any) error {
+		result.Read++
+		if result.Read <= skip {
+			return nil
+		}
+
+		if ctx.Err() != nil {
+			result.Read--
+			return incomplete(ctx, 1, "or more rows")
+		}
+
+		err := d.writeRow(table, row)
+		if err != nil {
+			result.Read--
+			return err
+		}
+
+		result.Rows++
+		return nil
+	}
+
+	var err error
+	switch format {
+	case IMPORT_CSV:
+		err = d.importCSV(table, columns, r, write)
+	case IMPORT_JSONL:
+		err = d.importJSONLines(columns, r, write)
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnknownFormat, format)
+	}
+
+	d.debug("import", "imported rows", "table", table, "rows", result.Rows)
+	return result, err
+}
+
+func (d *client) importCSV(table string, columns []string, r io.Reader, write func([]any) error) error {
+	cr := csv.NewReader(r)
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Position of each field's column.
+	positions := make([]int, len(header))
+	for i, name := range header {
+		positions[i] = slices.Index(columns, name)
+		if positions[i] == -1 {
+			return fmt.Errorf("%w: %s", errNoColumn, name)
+		}
+	}
+
+	types := make([]string, len(columns))
+	if history := d.tx.schemas[table]; history[len(history)-1].Types != nil {
+		types = history[len(history)-1].Types
+	}
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		row := make([]any, len(columns))
+		for i, field := range record {
+			row[positions[i]] = csvValue(types[positions[i]], field)
+		}
+
+		err = write(row)
+		if err != nil {
+			line, _ := cr.FieldPos(0)
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+}
+
+// field as typ, or as is for writeRow to reject if it isn't one.
+func csvValue(typ, field string) any {
+	if typ == COLUMN_ANY || typ == COLUMN_STRING {
+		return field
+	}
+
+	if field == "" {
+		return nil
+	}
+
+	var v any
+	var err error
+	switch typ {
+	case COLUMN_INT:
+		v, err = strconv.ParseInt(field, 10, 64)
+	case COLUMN_FLOAT:
+		v, err = strconv.ParseFloat(field, 64)
+	case COLUMN_BOOL:
+		v, err = strconv.ParseBool(field)
+	default:
+		// Timestamps are parsed by writeRow.
+		return field
+	}
+	if err != nil {
+		return field
+	}
+
+	return v
+}
+
+func (d *client) importJSONLines(columns []string, r io.Reader, write func([]any) error) error {
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		bs, err := br.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+
+		if len(bytes.TrimSpace(bs)) > 0 {
+			row, rowErr := decodeJSONRow(bs, columns)
+			if rowErr == nil {
+				rowErr = write(row)
+			}
+			if rowErr != nil {
+				return fmt.Errorf("line %d: %w", line, rowErr)
+			}
+		}
+
+		if err != nil {
+			return nil
+		}
+	}
+}
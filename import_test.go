@@ -0,0 +1,88 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestImportCSV(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b", "c", "d"}, withColumnTypes(map[string]string{
+		"a": COLUMN_INT,
+		"b": COLUMN_FLOAT,
+		"c": COLUMN_BOOL,
+	}))
+	assertEq(err, nil, "could not create x")
+
+	n, err := c.importFrom("x", strings.NewReader("d,c,a,b\none,true,1,1.5\n\"t,w,o\",,2,\n"), IMPORT_CSV)
+	assertEq(err, nil, "could not import")
+	assertEq(n, 2, "rows imported")
+	assertEq(fmt.Sprint(scanAll(&c, "x")), "[[1 1.5 true one] [2 <nil> <nil> t,w,o]]", "rows")
+
+	// Columns left out are null.
+	n, err = c.importFrom("x", strings.NewReader("a\n3\n"), IMPORT_CSV)
+	assertEq(err, nil, "could not import")
+	assertEq(n, 1, "rows imported")
+
+	_, err = c.importFrom("x", strings.NewReader("a,e\n4,5\n"), IMPORT_CSV)
+	assert(errors.Is(err, errNoColumn), "imported unknown column")
+
+	n, err = c.importFrom("x", strings.NewReader("a\n4\nfour\n"), IMPORT_CSV)
+	assert(errors.Is(err, errTypeMismatch), "imported a string into an int column")
+	assert(strings.HasPrefix(err.Error(), "line 3: "), err.Error())
+	assertEq(n, 1, "rows imported before the bad one")
+
+	_, err = c.importFrom("x", strings.NewReader(""), "xml")
+	assert(errors.Is(err, errUnknownFormat), "imported xml")
+	_, err = c.importFrom("y", strings.NewReader(""), IMPORT_CSV)
+	assert(errors.Is(err, errNoTable), "imported into a missing table")
+}
+
+func TestImportJSONLines(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b"})
+	assertEq(err, nil, "could not create x")
+
+	var lines strings.Builder
+	for i := 0; i < DATAOBJECT_SIZE*2+1; i++ {
+		if i%2 == 0 {
+			fmt.Fprintf(&lines, "{\"b\": \"row %d\", \"a\": %d}\n", i, i)
+		} else {
+			fmt.Fprintf(&lines, "[%d, \"row %d\"]\n\n", i, i)
+		}
+	}
+
+	n, err := c.importFrom("x", strings.NewReader(strings.TrimSuffix(lines.String(), "\n")), IMPORT_JSONL)
+	assertEq(err, nil, "could not import")
+	assertEq(n, DATAOBJECT_SIZE*2+1, "rows imported")
+	rows := scanAll(&c, "x")
+	assertEq(len(rows), n, "rows")
+	sum := 0.0
+	for _, row := range rows {
+		assertEq(row[1], any(fmt.Sprintf("row %v", row[0])), "row")
+		a, _ := toFloat64(row[0])
+		sum += a
+	}
+	assertEq(sum, float64(n*(n-1)/2), "sum of a")
+
+	// Flushed while importing.
+	adds := 0
+	for _, action := range c.tx.Actions["x"] {
+		if action.AddDataobject != nil {
+			adds++
+		}
+	}
+	assertEq(adds, 2, "dataobjects written")
+
+	_, err = c.importFrom("x", strings.NewReader("{\"a\": 1}\n{\"c\": 1}\n"), IMPORT_JSONL)
+	assert(errors.Is(err, errNoColumn), "imported unknown column")
+	assert(strings.HasPrefix(err.Error(), "line 2: "), err.Error())
+	_, err = c.importFrom("x", strings.NewReader("{\"a\": "), IMPORT_JSONL)
+	assert(err != nil, "imported bad JSON")
+}
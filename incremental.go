@@ -0,0 +1,88 @@
+package otf
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Batch pipelines that run periodically only want the rows added
+// since their last run rather than a full scan. An incremental scan
+// reads only the dataobjects added to a table by transactions
+// between two versions, as the table was at the later one: rows
+// deleted by then aren't returned and rows are in its schema then.
+//
+// Like Iceberg's incremental append scans only transactions that
+// appended to the table count. Those that also removed dataobjects
+// from it, compaction and updates, rewrite rows that were already
+// there and are skipped; tableChangesSince (see sync.go) and
+// changesSince (see cdc.go) see updates as deletes and inserts.
+
+// Scans the rows of table added by transactions after fromTx, -1
+// for all of them, up to and including toTx. Needs no transaction.
+func (d *client) scanIncremental(table string, fromTx, toTx int, opts ...scanOption) (*scanIterator, error) {
+	if fromTx > toTx {
+		return nil, fmt.Errorf("%w: %d is after %d", errNoVersion, fromTx, toTx)
+	}
+
+	names, err := d.listLog()
+	if err != nil {
+		return nil, err
+	}
+
+	end := logEntryIndex(names, toTx)
+	if end == -1 {
+		return nil, fmt.Errorf("%w: %d", errNoVersion, toTx)
+	}
+
+	added := map[string]bool{}
+	for _, name := range names[:end+1] {
+		if logEntryId(name) <= fromTx {
+			continue
+		}
+		if err := checkNotCoalesced(name, fromTx); err != nil {
+			return nil, err
+		}
+
+		entry, err := d.readLogEntry(name)
+		if err != nil {
+			return nil, err
+		}
+
+		actions := entry.Actions[table]
+		if slices.ContainsFunc(actions, func(a Action) bool { return a.RemoveDataobject != nil }) {
+			continue
+		}
+
+		for _, action := range actions {
+			if action.AddDataobject != nil {
+				added[action.AddDataobject.Name] = true
+			}
+		}
+	}
+
+	// Scans in a transaction of its own, as of toTx and without
+	// the table cache, which only holds the latest version.
+	at := *d
+	at.tx = nil
+	at.cache = nil
+	err = at.replayLogAt(names[:end+1])
+	if err != nil {
+		return nil, err
+	}
+
+	it, err := at.scan(table, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	it.dataobjects = slices.DeleteFunc(it.dataobjects, func(action *DataobjectAction) bool {
+		return !added[action.Name]
+	})
+	it.pruning = scanCost{}
+	for _, action := range it.dataobjects {
+		it.pruning.Dataobjects++
+		it.pruning.Bytes += action.Bytes
+	}
+
+	return it, nil
+}
@@ -0,0 +1,79 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func scanIncrementalAll(c *client, table string, fromTx, toTx int, opts ...scanOption) [][]any {
+	it, err := c.scanIncremental(table, fromTx, toTx, opts...)
+	assertEq(err, nil, "could not scan incrementally")
+
+	var rows [][]any
+	for {
+		row, err := it.next()
+		assertEq(err, nil, "could not iterate scan")
+		if row == nil {
+			return rows
+		}
+
+		rows = append(rows, row)
+	}
+}
+
+func TestScanIncremental(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	// 0 creates x, 1 to 3 append a row each.
+	for i := 0; i < 4; i++ {
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+		if i == 0 {
+			err = c.createTable("x", []string{"a"})
+			assertEq(err, nil, "could not create x")
+		} else {
+			err = c.writeRow("x", []any{i})
+			assertEq(err, nil, "could not write row")
+		}
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+
+	assertEq(fmt.Sprint(scanIncrementalAll(&c, "x", 1, 3)), "[[2] [3]]", "wrong rows")
+	assertEq(fmt.Sprint(scanIncrementalAll(&c, "x", -1, 2)), "[[1] [2]]", "wrong rows")
+	assertEq(fmt.Sprint(scanIncrementalAll(&c, "x", 3, 3)), "[]", "wrong rows")
+	assertEq(fmt.Sprint(scanIncrementalAll(&c, "x", 0, 3, withFilter(where("a", OP_EQ, 3)))), "[[3]]", "wrong filtered rows")
+
+	// 4 deletes a row and adds a column, 5 appends, 6 compacts.
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.deleteRows("x", where("a", OP_EQ, 2))
+	assertEq(err, nil, "could not delete")
+	err = c.addColumn("x", "b")
+	assertEq(err, nil, "could not add column")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{4, "b"})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	result, err := c.compact("x")
+	assertEq(err, nil, "could not compact")
+	assertEq(result.Rows, 3, "didn't compact")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// As of 5, deleted rows are gone and rows are in the new schema.
+	assertEq(fmt.Sprint(scanIncrementalAll(&c, "x", 0, 5)), "[[1 <nil>] [3 <nil>] [4 b]]", "wrong rows as of 5")
+	// Compaction rewrote every row, none of them new.
+	assertEq(fmt.Sprint(scanIncrementalAll(&c, "x", 5, 6)), "[]", "compacted rows are new")
+
+	_, err = c.scanIncremental("x", 0, 7)
+	assert(errors.Is(err, errNoVersion), "scanned a missing version")
+	_, err = c.scanIncremental("x", 3, 2)
+	assert(errors.Is(err, errNoVersion), "scanned backwards")
+}
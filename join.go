@@ -0,0 +1,443 @@
+package otf
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Equi-joins two tables within the current transaction.
+//
+// When either side is small enough for the table cache (see
+// tablecache.go) it is broadcast: its cached rows are built into a
+// hash table once and the other side is streamed past it. Otherwise
+// both sides are spilled into JOIN_PARTITIONS temporary files by hash
+// of the join key and each pair of partitions is joined in memory
+// in turn, so neither side has to fit in memory at once.
+//
+// Output rows are the left table's columns followed by the right
+// table's, whichever side is built. Null keys and keys that aren't
+// numbers, strings or booleans never match. Spilled rows are gob
+// encoded so values come back the same type they went in, as they
+// do from a broadcast join.
+//
+// Joins run from SQL (see sql.go), where EXPLAIN shows the plan.
+
+const (
+	JOIN_BROADCAST = "broadcast hash join"
+	JOIN_EXTERNAL  = "external hash join"
+)
+
+const JOIN_PARTITIONS = 16
+
+type joinSpec struct {
+	Left        string
+	LeftColumn  string
+	Right       string
+	RightColumn string
+}
+
+type joinPlan struct {
+	spec     joinSpec
+	Strategy string
+
+	// Which side the hash table is built from. Always the cached
+	// side of a broadcast join.
+	BuildLeft bool
+	// Committed rows of the build side, for broadcast joins.
+	BuildRows int
+
+	leftColumn  int
+	rightColumn int
+}
+
+func (d *client) planJoin(spec joinSpec) (*joinPlan, error) {
+	if d.tx == nil {
+		return nil, errNoTx
+	}
+
+	plan := &joinPlan{spec: spec, Strategy: JOIN_EXTERNAL}
+	var err error
+	plan.leftColumn, err = d.joinColumn(spec.Left, spec.LeftColumn)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.rightColumn, err = d.joinColumn(spec.Right, spec.RightColumn)
+	if err != nil {
+		return nil, err
+	}
+
+	if d.cache == nil {
+		return plan, nil
+	}
+
+	// Prefer building the right side, as is conventional.
+	if rows, ok := d.cache.cacheable(d, spec.Right); ok {
+		plan.Strategy = JOIN_BROADCAST
+		plan.BuildRows = rows
+	} else if rows, ok := d.cache.cacheable(d, spec.Left); ok {
+		plan.Strategy = JOIN_BROADCAST
+		plan.BuildLeft = true
+		plan.BuildRows = rows
+	}
+
+	return plan, nil
+}
+
+func (d *client) joinColumn(table, column string) (int, error) {
+	positions, err := d.columnPositions(table, []string{column})
+	if err != nil {
+		return 0, err
+	}
+
+	return positions[0], nil
+}
+
+func (p *joinPlan) sides() (build, probe string) {
+	if p.BuildLeft {
+		return p.spec.Left, p.spec.Right
+	}
+
+	return p.spec.Right, p.spec.Left
+}
+
+func (p *joinPlan) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s on %s.%s = %s.%s\n", p.Strategy, p.spec.Left, p.spec.LeftColumn, p.spec.Right, p.spec.RightColumn)
+
+	build, probe := p.sides()
+	if p.Strategy == JOIN_BROADCAST {
+		fmt.Fprintf(&b, "  build: %s (cached, %d rows)\n", build, p.BuildRows)
+		fmt.Fprintf(&b, "  probe: %s (scan)\n", probe)
+	} else {
+		fmt.Fprintf(&b, "  partitions: %d\n", JOIN_PARTITIONS)
+		fmt.Fprintf(&b, "  build: %s (scan, spilled)\n", build)
+		fmt.Fprintf(&b, "  probe: %s (scan, spilled)\n", probe)
+	}
+
+	return b.String()
+}
+
+// Describes how join would run spec without running it.
+func (d *client) explainJoin(spec joinSpec) (string, error) {
+	plan, err := d.planJoin(spec)
+	if err != nil {
+		return "", err
+	}
+
+	return plan.String(), nil
+}
+
+// Normalizes v so that equal values of different representations
+// (e.g. 1 and 1.0) hash the same. Returns false if v can't be
+// joined on.
+func joinKey(v any) (any, bool) {
+	if f, ok := toFloat64(v); ok {
+		return f, true
+	}
+
+	switch v.(type) {
+	case string, bool:
+		return v, true
+	}
+
+	return nil, false
+}
+
+type joinIterator struct {
+	pending [][]any
+	// Returns the next rows, or nil when there are none left.
+	more func() ([][]any, error)
+	// Releases what the join holds, if anything.
+	release func()
+}
+
+// returns (nil, nil) when done
+func (ji *joinIterator) next() ([]any, error) {
+	for len(ji.pending) == 0 {
+		rows, err := ji.more()
+		if err != nil || rows == nil {
+			ji.close()
+			return nil, err
+		}
+
+		ji.pending = rows
+	}
+
+	row := ji.pending[0]
+	ji.pending = ji.pending[1:]
+	return row, nil
+}
+
+// Releases the join's temporary files, for when it isn't read to
+// the end. Safe to call more than once.
+func (ji *joinIterator) close() {
+	if ji.release != nil {
+		ji.release()
+		ji.release = nil
+	}
+}
+
+type joinHashTable map[any][][]any
+
+func (p *joinPlan) buildColumn() int {
+	if p.BuildLeft {
+		return p.leftColumn
+	}
+
+	return p.rightColumn
+}
+
+func (p *joinPlan) probeColumn() int {
+	if p.BuildLeft {
+		return p.rightColumn
+	}
+
+	return p.leftColumn
+}
+
+func (jht joinHashTable) add(row []any, column int) {
+	if key, ok := joinKey(row[column]); ok {
+		jht[key] = append(jht[key], row)
+	}
+}
+
+// Joins one probe row against the hash table, in left-then-right
+// column order.
+func (p *joinPlan) probe(jht joinHashTable, row []any) [][]any {
+	key, ok := joinKey(row[p.probeColumn()])
+	if !ok {
+		return nil
+	}
+
+	var out [][]any
+	for _, match := range jht[key] {
+		left, right := row, match
+		if p.BuildLeft {
+			left, right = match, row
+		}
+
+		out = append(out, append(append([]any{}, left...), right...))
+	}
+
+	return out
+}
+
+func (d *client) join(spec joinSpec) (*joinIterator, error) {
+	plan, err := d.planJoin(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	d.debug("join", "planned", "plan", strings.TrimSpace(plan.String()))
+	if plan.Strategy == JOIN_BROADCAST {
+		return d.broadcastJoin(plan)
+	}
+
+	return d.externalJoin(plan)
+}
+
+func (d *client) broadcastJoin(plan *joinPlan) (*joinIterator, error) {
+	build, probe := plan.sides()
+
+	// Served from the table cache.
+	it, err := d.scan(build)
+	if err != nil {
+		return nil, err
+	}
+
+	jht := joinHashTable{}
+	for {
+		row, err := it.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if row == nil {
+			break
+		}
+
+		jht.add(row, plan.buildColumn())
+	}
+
+	it, err = d.scan(probe)
+	if err != nil {
+		return nil, err
+	}
+
+	return &joinIterator{more: func() ([][]any, error) {
+		for {
+			b, err := it.nextBatch(DATAOBJECT_SIZE)
+			if err != nil || b == nil {
+				return nil, err
+			}
+
+			var out [][]any
+			for i := 0; i < b.Len; i++ {
+				out = append(out, plan.probe(jht, b.row(i))...)
+			}
+
+			if len(out) > 0 {
+				return out, nil
+			}
+		}
+	}}, nil
+}
+
+func joinPartition(v any) int {
+	key, _ := joinKey(v)
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%T:%v", key, key)
+	return int(h.Sum32() % JOIN_PARTITIONS)
+}
+
+// Values rows can hold that gob needs to be told about.
+func init() {
+	gob.Register([]any{})
+	gob.Register(map[string]any{})
+	gob.Register(time.Time{})
+}
+
+// Writes the joinable rows of table into JOIN_PARTITIONS files in
+// dir, gob encoded one after another.
+func (d *client) spillJoinSide(dir, side, table string, column int) ([]string, error) {
+	var paths []string
+	var files []*os.File
+	var writers []*bufio.Writer
+	var encoders []*gob.Encoder
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for i := 0; i < JOIN_PARTITIONS; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("%s_%02d", side, i))
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, path)
+		files = append(files, f)
+		writers = append(writers, bufio.NewWriter(f))
+		encoders = append(encoders, gob.NewEncoder(writers[i]))
+	}
+
+	it, err := d.scan(table)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		row, err := it.next()
+		if err != nil {
+			return nil, err
+		}
+
+		if row == nil {
+			break
+		}
+
+		if _, ok := joinKey(row[column]); !ok {
+			continue
+		}
+
+		err = encoders[joinPartition(row[column])].Encode(row)
+		if err != nil {
+			return nil, fmt.Errorf("could not spill row of %s: %w", table, err)
+		}
+	}
+
+	for _, w := range writers {
+		err = w.Flush()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return paths, nil
+}
+
+func readJoinPartition(path string, each func(row []any)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := gob.NewDecoder(bufio.NewReader(f))
+	for {
+		var row []any
+		err = dec.Decode(&row)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		each(row)
+	}
+}
+
+// Temporary files are removed once the iterator is exhausted, fails
+// or is closed.
+func (d *client) externalJoin(plan *joinPlan) (*joinIterator, error) {
+	dir, err := os.MkdirTemp("", "otf-join")
+	if err != nil {
+		return nil, err
+	}
+
+	build, probe := plan.sides()
+	buildPaths, err := d.spillJoinSide(dir, "build", build, plan.buildColumn())
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	probePaths, err := d.spillJoinSide(dir, "probe", probe, plan.probeColumn())
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	partition := 0
+	return &joinIterator{more: func() ([][]any, error) {
+		for partition < JOIN_PARTITIONS {
+			i := partition
+			partition++
+
+			jht := joinHashTable{}
+			err := readJoinPartition(buildPaths[i], func(row []any) {
+				jht.add(row, plan.buildColumn())
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			var out [][]any
+			err = readJoinPartition(probePaths[i], func(row []any) {
+				out = append(out, plan.probe(jht, row)...)
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			if len(out) > 0 {
+				return out, nil
+			}
+		}
+
+		return nil, nil
+	}, release: func() {
+		os.RemoveAll(dir)
+	}}, nil
+}
@@ -0,0 +1,119 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestJoinStrategies(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	writer := newClient(mos)
+	err := writer.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = writer.createTable("dim", []string{"id", "name"})
+	assertEq(err, nil, "could not create dim")
+	err = writer.createTable("fact", []string{"dim_id", "amount"})
+	assertEq(err, nil, "could not create fact")
+	for _, row := range [][]any{{1, "Joey"}, {2, "Yue"}, {nil, "Nobody"}} {
+		err = writer.writeRow("dim", row)
+		assertEq(err, nil, "could not write row")
+	}
+	for i := 0; i < 100; i++ {
+		err = writer.writeRow("fact", []any{i % 4, i})
+		assertEq(err, nil, "could not write row")
+	}
+	err = writer.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	for _, test := range []struct {
+		c        client
+		spec     joinSpec
+		strategy string
+	}{
+		{newClient(mos, withTableCache(10)), joinSpec{"fact", "dim_id", "dim", "id"}, JOIN_BROADCAST},
+		// dim is on the left but still gets built.
+		{newClient(mos, withTableCache(10)), joinSpec{"dim", "id", "fact", "dim_id"}, JOIN_BROADCAST},
+		{newClient(mos), joinSpec{"fact", "dim_id", "dim", "id"}, JOIN_EXTERNAL},
+	} {
+		c := test.c
+		err = c.newTx()
+		assertEq(err, nil, "could not start tx")
+
+		explained, err := c.explainJoin(test.spec)
+		assertEq(err, nil, "could not explain")
+		assert(strings.HasPrefix(explained, test.strategy), "strategy: "+explained)
+		assert(strings.Contains(explained, "build: dim"), "build side: "+explained)
+
+		it, err := c.join(test.spec)
+		assertEq(err, nil, "could not join")
+		rows := 0
+		for {
+			row, err := it.next()
+			assertEq(err, nil, "could not iterate join")
+			if row == nil {
+				break
+			}
+
+			// Keys are the first column of both tables.
+			assertEq(len(row), 4, "joined columns")
+			assertEq(row[0], row[2], "joined on key")
+			rows++
+		}
+		assertEq(rows, 50, "joined rows")
+
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+}
+
+func TestJoinSQL(t *testing.T) {
+	// Spilled partitions go here.
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	c := newClient(newMemoryObjectStorage())
+	for _, statement := range []string{
+		"CREATE TABLE dim (id INT, name STRING)",
+		"CREATE TABLE fact (dim_id INT, amount)",
+		"INSERT INTO dim VALUES (1, 'Joey'), (2, 'Yue')",
+		"INSERT INTO fact VALUES (1, 10), (2, 20), (2, 21), (3, 30)",
+	} {
+		_, err := c.execSQL(statement)
+		assertEq(err, nil, "could not run "+statement)
+	}
+
+	result, err := c.execSQL("EXPLAIN SELECT * FROM fact JOIN dim ON dim.id = fact.dim_id")
+	assertEq(err, nil, "could not explain")
+	assertEq(fmt.Sprint(result.Rows[0]), "[external hash join on fact.dim_id = dim.id]", "plan")
+
+	result, err = c.execSQL("SELECT * FROM fact JOIN dim ON fact.dim_id = dim.id")
+	assertEq(err, nil, "could not join")
+	assertEq(strings.Join(result.Columns, ","), "fact.dim_id,fact.amount,dim.id,dim.name", "columns")
+	assertEq(len(result.Rows), 3, "joined rows")
+	// Spilled values come back as they went in.
+	for _, row := range result.Rows {
+		_, ok := row[0].(int64)
+		assert(ok, fmt.Sprintf("spilled int came back as %T", row[0]))
+	}
+
+	// Stopping early still removes the spilled partitions.
+	result, err = c.execSQL("SELECT * FROM fact JOIN dim ON fact.dim_id = dim.id LIMIT 1")
+	assertEq(err, nil, "could not join")
+	assertEq(len(result.Rows), 1, "limited rows")
+	entries, err := os.ReadDir(tmp)
+	assertEq(err, nil, "could not list temporary files")
+	assertEq(len(entries), 0, "temporary files left")
+
+	for _, statement := range []string{
+		"SELECT dim_id FROM fact JOIN dim ON fact.dim_id = dim.id",
+		"SELECT * FROM fact JOIN dim ON fact.dim_id = fact.amount",
+		"EXPLAIN SELECT * FROM fact",
+		"INSERT INTO dim SELECT * FROM fact JOIN dim ON fact.dim_id = dim.id",
+	} {
+		_, err = c.execSQL(statement)
+		assert(errors.Is(err, errSQLSyntax), "expected syntax error: "+statement)
+	}
+}
@@ -0,0 +1,344 @@
+package otf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// Dataobjects, and each of their row groups (see rowgroup.go), hold
+// their rows column by column. A scan whose filter is on only some
+// of a table's columns decodes just those columns of each dataobject
+// first and evaluates the filter against them, then decodes the
+// other columns only for the rows that matched. A selective filter
+// on a couple of columns of a wide table then leaves most values of
+// most dataobjects undecoded, and a dataobject with no matching row
+// decodes none of its other columns.
+//
+// Dataobjects with column families (see families.go) are read the
+// same way a family at a time: the columns in no family and the
+// families holding the filter's columns first, and the scan's other
+// families only if some row matched. A selective filter on small
+// columns then keeps large ones out of most of the scan, even
+// without a projection. Family objects are read whole.
+//
+// Filters calling functions (see udf.go) may read any column, so
+// scans with one decode every column as before, as do scans with
+// columns masked (see catalogview.go), which filter after masking.
+
+// Positions of the columns filter is on, nil if it calls functions
+// or is on columns not in columns.
+func filterColumns(columns []string, filter *predicate) []int {
+	positions := []int{}
+	var walk func(p *predicate) bool
+	walk = func(p *predicate) bool {
+		if p.Call != nil {
+			return false
+		}
+		if p.Column != "" {
+			i := slices.Index(columns, p.Column)
+			if i == -1 {
+				return false
+			}
+			positions = append(positions, i)
+		}
+		for _, q := range slices.Concat(p.And, p.Or) {
+			if !walk(q) {
+				return false
+			}
+		}
+		return true
+	}
+	if !walk(filter) {
+		return nil
+	}
+
+	return positions
+}
+
+// How a scan decodes a dataobject: which of its columns first, as
+// stored, or of a dataobject with families which of them it reads
+// first and after, and the filter to evaluate against them.
+type lateRead struct {
+	first       []bool
+	firstFamily []bool
+	restFamily  []bool
+	keep        func(*batch, int) bool
+}
+
+// How the scan decodes action, reading its wanted families, nil to
+// decode all of its columns at once.
+func (si *scanIterator) lateRead(action *DataobjectAction, wanted []bool) *lateRead {
+	if si.filtered == nil || si.masked != nil {
+		return nil
+	}
+
+	if action.Families != nil {
+		first := si.d.wantedFamilies(action, si.filtered)
+		rest := make([]bool, len(action.Families))
+		later := false
+		for i := range action.Families {
+			rest[i] = (wanted == nil || wanted[i]) && !first[i]
+			later = later || rest[i]
+		}
+		if !later {
+			return nil
+		}
+
+		return &lateRead{firstFamily: first, restFamily: rest, keep: si.keep}
+	}
+
+	history := si.d.tx.schemas[si.table]
+	mapping := history.mapping(action.SchemaVersion)
+	stored := len(si.d.tx.tables[si.table])
+	if mapping != nil {
+		stored = len(history[action.SchemaVersion].Columns)
+	}
+
+	first := make([]bool, stored)
+	later := stored
+	for _, i := range si.filtered {
+		if mapping != nil {
+			i = mapping[i]
+		}
+		if i != -1 && !first[i] {
+			first[i] = true
+			later--
+		}
+	}
+	if later == 0 {
+		return nil
+	}
+
+	return &lateRead{first: first, keep: si.keep}
+}
+
+type lateColumns struct {
+	Columns []json.RawMessage
+	Len     int
+}
+
+// Decodes the rows of a dataobject or row group as late says, see
+// above, leaving out rows that don't match and deleted ones. offset
+// is the position of its first row in the dataobject. convert, if
+// not nil, is applied to rows before evaluating the filter, but not
+// to the rows returned.
+func decodeLate(codec string, bytes []byte, late *lateRead, offset int, deleted map[int]bool, convert func(*batch) *batch) (*batch, error) {
+	n, width, decode, err := lateDecoder(codec, bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := &batch{Columns: make([][]any, width), Len: n}
+	for i := range stored.Columns {
+		if i < len(late.first) && late.first[i] {
+			stored.Columns[i], err = decode(i, nil)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			stored.Columns[i] = make([]any, n)
+		}
+	}
+
+	rows := stored
+	if convert != nil {
+		rows = convert(rows)
+	}
+	var matched []int
+	for i := 0; i < rows.Len; i++ {
+		if !deleted[offset+i] && late.keep(rows, i) {
+			matched = append(matched, i)
+		}
+	}
+
+	out := &batch{Columns: make([][]any, width), Len: len(matched)}
+	if len(matched) == 0 {
+		return out, nil
+	}
+
+	for i := range out.Columns {
+		if i < len(late.first) && late.first[i] {
+			for _, j := range matched {
+				out.Columns[i] = append(out.Columns[i], stored.Columns[i][j])
+			}
+			continue
+		}
+
+		out.Columns[i], err = decode(i, matched)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+// The number of rows and columns of a dataobject or row group
+// encoded as bytes, and a function decoding a column's values at
+// the given rows, all of them if nil.
+func lateDecoder(codec string, bytes []byte) (int, int, func(i int, rows []int) ([]any, error), error) {
+	if codec == CODEC_COLUMNAR {
+		// Columns are encoded whole, so decoded whole too.
+		n, columns, err := columnarColumns(bytes)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+
+		return n, len(columns), func(i int, rows []int) ([]any, error) {
+			column, err := columns[i]()
+			if err != nil || rows == nil {
+				return column, err
+			}
+
+			var values []any
+			for _, j := range rows {
+				values = append(values, column[j])
+			}
+			return values, nil
+		}, nil
+	}
+
+	bytes, err := decompressBytes(codec, bytes)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	var raw lateColumns
+	err = json.Unmarshal(bytes, &raw)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	return raw.Len, len(raw.Columns), func(i int, rows []int) ([]any, error) {
+		var values []json.RawMessage
+		err := json.Unmarshal(raw.Columns[i], &values)
+		if err == nil && len(values) != raw.Len {
+			err = fmt.Errorf("%w: column %d has %d rows, not %d", io.ErrUnexpectedEOF, i, len(values), raw.Len)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if rows == nil {
+			rows = make([]int, len(values))
+			for j := range rows {
+				rows[j] = j
+			}
+		}
+
+		column := make([]any, 0, len(rows))
+		for _, j := range rows {
+			var v any
+			err = json.Unmarshal(values[j], &v)
+			if err != nil {
+				return nil, err
+			}
+			column = append(column, v)
+		}
+		return column, nil
+	}, nil
+}
+
+// Reads all of action's rows from storage as late says, converting
+// them with convert if not nil.
+func (d *client) readDataobjectLate(ctx context.Context, storage objectStorage, action *DataobjectAction, late *lateRead, deleted map[int]bool, convert func(*batch) *batch) (*batch, error) {
+	key := dataobjectKey(action.Table, action.Name)
+	bytes, err := storage.read(ctx, key)
+	if err == nil {
+		err = verifyChecksum(key, bytes, action.Checksum)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rows *batch
+	if action.RowGroups == nil {
+		rows, err = decodeLate(action.Codec, bytes, late, 0, deleted, convert)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		offset := 0
+		for _, group := range action.RowGroups {
+			if group.Offset+group.Length > int64(len(bytes)) {
+				return nil, fmt.Errorf("%w: row group of %s", io.ErrUnexpectedEOF, action.Name)
+			}
+
+			b, err := decodeLate(action.Codec, bytes[group.Offset:group.Offset+group.Length], late, offset, deleted, convert)
+			if err != nil {
+				return nil, err
+			}
+			offset += group.Rows
+
+			if rows == nil {
+				rows = newBatch(len(b.Columns))
+			}
+			rows.concat(b)
+		}
+	}
+
+	if convert != nil {
+		rows = convert(rows)
+	}
+	return rows, nil
+}
+
+// Reads action's rows a family at a time as late says, see above,
+// the families read after from rest, without deleted rows. When no
+// row matches returns none, and the bytes of the families it didn't
+// read.
+func (d *client) readFamiliesLate(ctx context.Context, storage, rest objectStorage, action *DataobjectAction, late *lateRead, deleted map[int]bool, convert func(*batch) *batch) (*batch, int64, error) {
+	stored, err := d.readDataobjectRows(ctx, storage, action, late.firstFamily)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows := stored
+	if convert != nil {
+		rows = convert(rows)
+	}
+	matched := false
+	for i := 0; i < rows.Len && !matched; i++ {
+		matched = !deleted[i] && late.keep(rows, i)
+	}
+	if !matched {
+		var unread int64
+		for i, family := range action.Families {
+			if late.restFamily[i] {
+				unread += family.Bytes
+			}
+		}
+		return newBatch(len(rows.Columns)), unread, nil
+	}
+
+	// A copy, as the rows read may be in the dataobject cache.
+	full := &batch{Columns: slices.Clone(stored.Columns), Len: stored.Len}
+	read := func(key string) ([]byte, error) {
+		return rest.read(ctx, key)
+	}
+	for i, family := range action.Families {
+		if !late.restFamily[i] {
+			continue
+		}
+
+		columns, err := d.readFamily(read, action, i)
+		if err != nil {
+			return nil, 0, err
+		}
+		for j, position := range family.Columns {
+			full.Columns[position] = columns[j]
+		}
+	}
+
+	rows = full
+	if convert != nil {
+		rows = convert(rows)
+	}
+	if deleted != nil {
+		rows = rows.without(deleted)
+	}
+	return rows, 0, nil
+}
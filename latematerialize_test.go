@@ -0,0 +1,129 @@
+package otf
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDecodeLate(t *testing.T) {
+	// 1e999 doesn't fit a float64, so decoding it fails.
+	bytes := []byte(`{"Table":"x","Name":"a","Columns":[[1,2,3],[1e999,"b",1e999]],"Len":3}`)
+	late := &lateRead{first: []bool{true, false}, keep: func(b *batch, i int) bool {
+		return b.Columns[0][i] == 2.0
+	}}
+	rows, err := decodeLate(CODEC_NONE, bytes, late, 0, nil, nil)
+	assertEq(err, nil, "could not decode")
+	assertEq(fmt.Sprint(rows.Columns), "[[2] [b]]", "matched rows")
+
+	// Nor are deleted rows.
+	rows, err = decodeLate(CODEC_NONE, bytes, late, 10, map[int]bool{11: true}, nil)
+	assertEq(err, nil, "could not decode")
+	assertEq(rows.Len, 0, "rows when the match is deleted")
+
+	late.first = []bool{false, true}
+	_, err = decodeLate(CODEC_NONE, bytes, late, 0, nil, nil)
+	assert(err != nil, "expected decoding the second column first to fail")
+}
+
+func TestLateMaterialization(t *testing.T) {
+	c := newClient(newMemoryObjectStorage(), withRowGroupSize(10))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"id", "hot", "payload"})
+	assertEq(err, nil, "could not create x")
+	for i := 0; i < 25; i++ {
+		err = c.writeRow("x", []any{i, i % 7, fmt.Sprint("payload ", i)})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.deleteRows("x", where("id", OP_EQ, 7))
+	assertEq(err, nil, "could not delete")
+	err = c.addColumn("x", "added")
+	assertEq(err, nil, "could not add column")
+	err = c.writeRow("x", []any{30, 0, "payload 30", "new"})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	rows := scanAll(&c, "x", withFilter(where("hot", OP_EQ, 0)))
+	assertEq(fmt.Sprint(rows), "[[0 0 payload 0 <nil>] [14 0 payload 14 <nil>] [21 0 payload 21 <nil>] [30 0 payload 30 new]]", "rows")
+	rows = scanAll(&c, "x", withColumns("payload"), withFilter(and(where("hot", OP_EQ, 3), where("id", OP_GT, 5))))
+	assertEq(fmt.Sprint(rows), "[[payload 10] [payload 17] [payload 24]]", "projected rows")
+	// Only the last row group.
+	rows = scanAll(&c, "x", withColumns("id"), withFilter(and(where("id", OP_GTE, 20), where("hot", OP_LT, 2))))
+	assertEq(fmt.Sprint(rows), "[[21] [22] [30]]", "rows of one row group")
+	rows = scanAll(&c, "x", withFilter(where("added", OP_EQ, "new")))
+	assertEq(len(rows), 1, "rows of an added column")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+}
+
+func TestLateMaterializationOfFamilies(t *testing.T) {
+	fr := &familyReads{memoryObjectStorage: newMemoryObjectStorage()}
+	c := newClient(fr)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"id", "hot", "payload", "blob"}, withColumnFamilies([]string{"payload"}, []string{"blob"}))
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Statistics can't rule any of them out for hot = 1.
+	for _, hots := range [][]int{{0, 2}, {1, 3}, {1, 2}} {
+		err = c.newTx()
+		assertEq(err, nil, "could not start tx")
+		for _, hot := range hots {
+			err = c.writeRow("x", []any{hot * 10, hot, fmt.Sprint(strings.Repeat("p", 100), hot), strings.Repeat("b", 100)})
+			assertEq(err, nil, "could not write row")
+		}
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	it, err := c.scan("x", withFilter(where("hot", OP_EQ, 1)))
+	assertEq(err, nil, "could not scan")
+	var ids []any
+	for {
+		row, err := it.next()
+		assertEq(err, nil, "could not read row")
+		if row == nil {
+			break
+		}
+		ids = append(ids, row[0])
+		assertEq(row[3], any(strings.Repeat("b", 100)), "blob")
+	}
+	assertEq(fmt.Sprint(ids), "[10 10]", "matching rows")
+	// Both families of the two dataobjects with a match.
+	assertEq(fr.reads, 4, "family reads")
+	cost := it.cost()
+	assert(cost.BytesAfterPruning < cost.Bytes, "counted families not read")
+
+	// Deleted rows don't count as matches.
+	_, err = c.deleteRows("x", where("id", OP_EQ, 30))
+	assertEq(err, nil, "could not delete")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	fr.reads = 0
+	assertEq(fmt.Sprint(scanAll(&c, "x", withColumns("id", "payload"), withFilter(where("hot", OP_EQ, 3)))), "[]", "deleted row")
+	assertEq(fr.reads, 0, "family reads of deleted rows")
+
+	// Filtering on a family column reads that family first, and the
+	// other only for the two dataobjects with a match.
+	rows := scanAll(&c, "x", withFilter(where("payload", OP_EQ, fmt.Sprint(strings.Repeat("p", 100), 1))))
+	assertEq(len(rows), 2, "rows matching payload")
+	assertEq(fr.reads, 5, "family reads filtering on a family")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+}
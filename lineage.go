@@ -0,0 +1,104 @@
+package otf
+
+import (
+	"fmt"
+	"time"
+)
+
+// Tables derived from others, e.g. by INSERT ... SELECT or CREATE
+// TABLE ... AS SELECT (see sql.go), record where their rows came
+// from: a LineageAction in the derived table's actions, committed
+// along with the rows, names each source table with the version it
+// was read at and which source columns each derived column was
+// computed from. Like a purge receipt (see purge.go) it changes
+// nothing on replay. lineage reads them back for governance tooling,
+// which can follow sources' own lineage to trace a column further.
+
+type LineageSource struct {
+	Table string
+	// The last committed transaction that changed the table when it
+	// was read.
+	Version int
+	// Whether the deriving transaction had changed it too, so rows
+	// read weren't all committed yet.
+	Uncommitted bool `json:",omitempty"`
+}
+
+type ColumnLineage struct {
+	Column string
+	// Source columns as table.column.
+	From []string
+}
+
+type LineageAction struct {
+	Table   string
+	Sources []LineageSource
+	Columns []ColumnLineage
+	// What derived the rows, e.g. a SQL statement.
+	Query string `json:",omitempty"`
+}
+
+// Records that table's rows written in this transaction were derived
+// by query from sources, as of what the transaction sees of them.
+func (d *client) recordLineage(table string, sources []string, columns []ColumnLineage, query string) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+
+	if _, ok := d.tx.tables[table]; !ok {
+		return errNoTable
+	}
+
+	lineage := &LineageAction{Table: table, Columns: columns, Query: query}
+	for _, source := range sources {
+		if _, ok := d.tx.tables[source]; !ok {
+			return fmt.Errorf("%w: %s", errNoTable, source)
+		}
+
+		lineage.Sources = append(lineage.Sources, LineageSource{
+			Table:       source,
+			Version:     d.tx.tableVersions[source],
+			Uncommitted: len(d.tx.Actions[source]) > 0 || d.tx.unflushedLen(source) > 0,
+		})
+	}
+
+	d.tx.Actions[table] = append(d.tx.Actions[table], Action{Lineage: lineage})
+	d.debug("lineage", "recorded", "table", table, "sources", sources)
+	return nil
+}
+
+type lineageRecord struct {
+	TxId      int
+	Committed time.Time
+	LineageAction
+}
+
+// Every derivation of table's rows committed, in order.
+func (d *client) lineage(table string) ([]lineageRecord, error) {
+	names, err := d.listLog()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []lineageRecord
+	for _, name := range names {
+		tx, err := d.readLogEntry(name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, action := range tx.Actions[table] {
+			if action.Lineage == nil {
+				continue
+			}
+
+			r := lineageRecord{TxId: tx.Id, LineageAction: *action.Lineage}
+			if tx.CommitInfo != nil {
+				r.Committed = tx.CommitInfo.Timestamp
+			}
+			records = append(records, r)
+		}
+	}
+
+	return records, nil
+}
@@ -0,0 +1,65 @@
+package otf
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLineage(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	_, err := c.execSQL("CREATE TABLE x (a INT, b STRING)")
+	assertEq(err, nil, "could not create x")
+	_, err = c.execSQL("INSERT INTO x VALUES (1, 'one'), (2, 'two'), (3, 'three')")
+	assertEq(err, nil, "could not insert")
+
+	result, err := c.execSQL("CREATE TABLE y AS SELECT b, a FROM x WHERE a > $1", 1)
+	assertEq(err, nil, "could not create y")
+	assertEq(result.RowsAffected, 2, "rows")
+
+	_, err = c.execSQL("CREATE TABLE z (c, d)")
+	assertEq(err, nil, "could not create z")
+	_, err = c.execSQL("INSERT INTO z (d, c) SELECT a, b FROM y")
+	assertEq(err, nil, "could not insert into z")
+
+	result, err = c.execSQL("SELECT c, d FROM z")
+	assertEq(err, nil, "could not select")
+	assertEq(fmt.Sprint(result.Rows), "[[two 2] [three 3]]", "rows of z")
+
+	records, err := c.lineage("y")
+	assertEq(err, nil, "could not read lineage")
+	assertEq(len(records), 1, "derivations of y")
+	assertEq(records[0].TxId, 2, "tx")
+	assertEq(len(records[0].Sources), 1, "sources")
+	assertEq(records[0].Sources[0], LineageSource{Table: "x", Version: 1}, "source")
+	assertEq(fmt.Sprint(records[0].Columns), "[{b [x.b]} {a [x.a]}]", "columns")
+	assertEq(records[0].Query, "CREATE TABLE y AS SELECT b, a FROM x WHERE a > $1", "query")
+	assert(!records[0].Committed.IsZero(), "commit time")
+
+	records, err = c.lineage("z")
+	assertEq(err, nil, "could not read lineage")
+	assertEq(len(records), 1, "derivations of z")
+	assertEq(records[0].Sources[0], LineageSource{Table: "y", Version: 2}, "source")
+	assertEq(fmt.Sprint(records[0].Columns), "[{d [y.a]} {c [y.b]}]", "columns")
+
+	// Derived from rows the transaction wrote itself.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{4, "four"})
+	assertEq(err, nil, "could not write row")
+	_, err = c.execSQL("INSERT INTO y SELECT b, a FROM x WHERE a = 4")
+	assertEq(err, nil, "could not insert into y")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	records, err = c.lineage("y")
+	assertEq(err, nil, "could not read lineage")
+	assertEq(len(records), 2, "derivations of y")
+	assertEq(records[1].Sources[0], LineageSource{Table: "x", Version: 1, Uncommitted: true}, "source")
+
+	records, err = c.lineage("x")
+	assertEq(err, nil, "could not read lineage")
+	assertEq(len(records), 0, "derivations of x")
+
+	_, err = c.execSQL("INSERT INTO z SELECT a FROM x")
+	assert(err != nil, "inserted too few columns")
+}
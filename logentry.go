@@ -0,0 +1,379 @@
+package otf
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// The log entry a transaction commits as is written here rather than
+// by json.Marshal on the transaction: reflecting over every action's
+// struct graph and writing out the fields it doesn't set was most of
+// the CPU commitTx spent. Only set fields are written and the
+// dataobject actions most entries are made of are encoded by hand;
+// anything else falls back to json.Marshal. The output is plain JSON
+// in the same shape, so entries are read with json.Unmarshal. See
+// testdata/logentry.json for the format.
+//
+// logEntry and the action types are the format; the transaction
+// struct is only ever in memory. Entries record the version of the
+// format they were written with, so that clients refuse entries from
+// a later version they'd misread rather than silently dropping what
+// they don't know. Entries from before versions were recorded are
+// version 0, which version 1 only differs from in leaving out unset
+// fields.
+
+const LOG_ENTRY_VERSION = 1
+
+var errLogVersion = fmt.Errorf("Unsupported Log Version")
+
+type logEntry struct {
+	Version int
+	Id      int
+	// See timetravel.go.
+	CommitInfo *CommitInfo `json:",omitempty"`
+	// Mapping table name to the actions on it.
+	Actions map[string][]Action
+	// The last transaction to change each table, for entries
+	// coalescing several, see coalesce.go.
+	TableVersions map[string]int `json:",omitempty"`
+	// What entries of later versions use, see compat.go.
+	Features []string `json:",omitempty"`
+}
+
+func (t *transaction) logEntry() *logEntry {
+	return &logEntry{Version: LOG_ENTRY_VERSION, Id: t.Id, CommitInfo: t.CommitInfo, Actions: t.Actions}
+}
+
+func decodeLogEntry(name string, bytes []byte) (*logEntry, error) {
+	err := verifyLogChecksum(name, bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var entry logEntry
+	err = json.Unmarshal(bytes, &entry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkLogVersion(name, &entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
+
+type logEncoder struct {
+	b []byte
+	// Whether the next field of the current object follows another.
+	comma bool
+}
+
+func (e *logEncoder) open() {
+	e.b = append(e.b, '{')
+	e.comma = false
+}
+
+func (e *logEncoder) close() {
+	e.b = append(e.b, '}')
+	e.comma = true
+}
+
+func (e *logEncoder) key(k string) {
+	if e.comma {
+		e.b = append(e.b, ',')
+	}
+	e.string(k)
+	e.b = append(e.b, ':')
+	e.comma = true
+}
+
+func (e *logEncoder) string(s string) {
+	if !utf8.ValidString(s) {
+		// Leave replacing invalid bytes to encoding/json.
+		bytes, _ := json.Marshal(s)
+		e.b = append(e.b, bytes...)
+		return
+	}
+
+	e.b = append(e.b, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			e.b = append(e.b, '\\', c)
+		case c == '\n':
+			e.b = append(e.b, '\\', 'n')
+		case c < 0x20:
+			e.b = fmt.Appendf(e.b, "\\u%04x", c)
+		default:
+			e.b = append(e.b, c)
+		}
+	}
+	e.b = append(e.b, '"')
+}
+
+func (e *logEncoder) strings(ss []string) {
+	e.b = append(e.b, '[')
+	for i, s := range ss {
+		if i > 0 {
+			e.b = append(e.b, ',')
+		}
+		e.string(s)
+	}
+	e.b = append(e.b, ']')
+}
+
+func (e *logEncoder) int(i int64) {
+	e.b = strconv.AppendInt(e.b, i, 10)
+}
+
+// Row values, as found in stats and partitions.
+func (e *logEncoder) value(v any) error {
+	switch v := v.(type) {
+	case nil:
+		e.b = append(e.b, "null"...)
+	case bool:
+		e.b = strconv.AppendBool(e.b, v)
+	case int:
+		e.int(int64(v))
+	case int64:
+		e.int(v)
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("could not encode log entry: unsupported value %v", v)
+		}
+		e.b = strconv.AppendFloat(e.b, v, 'g', -1, 64)
+	case string:
+		e.string(v)
+	default:
+		return e.marshal(v)
+	}
+
+	return nil
+}
+
+func (e *logEncoder) marshal(v any) error {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	e.b = append(e.b, bytes...)
+	return nil
+}
+
+func (e *logEncoder) stats(stats map[string]columnStats) error {
+	e.open()
+	for _, column := range sortedKeys(stats) {
+		s := stats[column]
+		e.key(column)
+		e.open()
+		if s.Min != nil {
+			e.key("Min")
+			if err := e.value(s.Min); err != nil {
+				return err
+			}
+		}
+		if s.Max != nil {
+			e.key("Max")
+			if err := e.value(s.Max); err != nil {
+				return err
+			}
+		}
+		if s.Nulls != 0 {
+			e.key("Nulls")
+			e.int(int64(s.Nulls))
+		}
+		e.close()
+	}
+	e.close()
+	return nil
+}
+
+func (e *logEncoder) bloom(bf *bloomFilter) {
+	e.open()
+	e.key("Bits")
+	e.b = append(e.b, '"')
+	e.b = base64.StdEncoding.AppendEncode(e.b, bf.Bits)
+	e.b = append(e.b, '"')
+	e.key("Hashes")
+	e.int(int64(bf.Hashes))
+	e.close()
+}
+
+func (e *logEncoder) dataobject(do *DataobjectAction) error {
+	e.open()
+	e.key("Name")
+	e.string(do.Name)
+	e.key("Table")
+	e.string(do.Table)
+	e.key("Rows")
+	e.int(int64(do.Rows))
+	if do.Codec != "" {
+		e.key("Codec")
+		e.string(do.Codec)
+	}
+	if len(do.Stats) > 0 {
+		e.key("Stats")
+		if err := e.stats(do.Stats); err != nil {
+			return err
+		}
+	}
+	if len(do.Partition) > 0 {
+		e.key("Partition")
+		e.open()
+		for _, column := range sortedKeys(do.Partition) {
+			e.key(column)
+			if err := e.value(do.Partition[column]); err != nil {
+				return err
+			}
+		}
+		e.close()
+	}
+	if do.SchemaVersion != 0 {
+		e.key("SchemaVersion")
+		e.int(int64(do.SchemaVersion))
+	}
+	if len(do.RowGroups) > 0 {
+		e.key("RowGroups")
+		if err := e.marshal(do.RowGroups); err != nil {
+			return err
+		}
+	}
+	if do.Bytes != 0 {
+		e.key("Bytes")
+		e.int(do.Bytes)
+	}
+	if do.Checksum != "" {
+		e.key("Checksum")
+		e.string(do.Checksum)
+	}
+	if do.Created != nil {
+		e.key("Created")
+		if err := e.time(*do.Created); err != nil {
+			return err
+		}
+	}
+	if len(do.Transforms) > 0 {
+		e.key("Transforms")
+		e.strings(do.Transforms)
+	}
+	if do.KeyFilter != nil {
+		e.key("KeyFilter")
+		e.bloom(do.KeyFilter)
+	}
+	if len(do.BloomFilters) > 0 {
+		e.key("BloomFilters")
+		e.open()
+		for _, column := range sortedKeys(do.BloomFilters) {
+			e.key(column)
+			e.bloom(do.BloomFilters[column])
+		}
+		e.close()
+	}
+	if len(do.Families) > 0 {
+		e.key("Families")
+		if err := e.marshal(do.Families); err != nil {
+			return err
+		}
+	}
+	if do.TxId != 0 {
+		e.key("TxId")
+		e.int(int64(do.TxId))
+	}
+	if do.Committed != nil {
+		e.key("Committed")
+		if err := e.time(*do.Committed); err != nil {
+			return err
+		}
+	}
+	e.close()
+	return nil
+}
+
+// Writes t as encoding/json would.
+func (e *logEncoder) time(t time.Time) error {
+	if y := t.Year(); y < 0 || y > 9999 {
+		return e.marshal(t)
+	}
+
+	e.b = append(e.b, '"')
+	e.b = t.AppendFormat(e.b, time.RFC3339Nano)
+	e.b = append(e.b, '"')
+	return nil
+}
+
+func (e *logEncoder) action(action Action) error {
+	if action.AddDataobject != nil && action == (Action{AddDataobject: action.AddDataobject}) {
+		e.open()
+		e.key("AddDataobject")
+		err := e.dataobject(action.AddDataobject)
+		e.close()
+		return err
+	}
+
+	err := e.marshal(action)
+	e.comma = true
+	return err
+}
+
+func encodeLogEntry(entry *logEntry) ([]byte, error) {
+	e := logEncoder{b: make([]byte, 0, 1024)}
+	e.open()
+	e.key("Version")
+	e.int(int64(entry.Version))
+	e.key("Id")
+	e.int(int64(entry.Id))
+	if entry.CommitInfo != nil {
+		e.key("CommitInfo")
+		if err := e.marshal(entry.CommitInfo); err != nil {
+			return nil, err
+		}
+	}
+	if entry.TableVersions != nil {
+		e.key("TableVersions")
+		if err := e.marshal(entry.TableVersions); err != nil {
+			return nil, err
+		}
+	}
+	e.key("Actions")
+	if entry.Actions == nil {
+		e.b = append(e.b, "null"...)
+	} else {
+		e.open()
+		for _, table := range sortedKeys(entry.Actions) {
+			e.key(table)
+			e.b = append(e.b, '[')
+			for i, action := range entry.Actions[table] {
+				if i > 0 {
+					e.b = append(e.b, ',')
+				}
+				if err := e.action(action); err != nil {
+					return nil, err
+				}
+			}
+			e.b = append(e.b, ']')
+		}
+		e.close()
+	}
+
+	// In place of closing it, see checksum.go.
+	return appendLogChecksum(e.b), nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,165 @@
+package otf
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+func goldenLogEntry() *logEntry {
+	created := time.Date(2024, 5, 1, 12, 30, 0, 500, time.UTC)
+	return &logEntry{
+		Version: LOG_ENTRY_VERSION,
+		Id:      7,
+		CommitInfo: &CommitInfo{
+			Timestamp: created,
+			HLC:       &HLCTimestamp{created, 2},
+		},
+		Actions: map[string][]Action{
+			"y": {{DeleteRows: &DeleteAction{"y", "y_1", []int{0, 2}}}},
+			"x": {
+				{ChangeMetadata: &ChangeMetadataAction{
+					Table:            "x",
+					Columns:          []string{"a", "b", "c"},
+					PartitionColumns: []string{"c"},
+				}},
+				{AddDataobject: &DataobjectAction{
+					Name:  "x_1",
+					Table: "x",
+					Rows:  3,
+					Codec: "zstd",
+					Stats: map[string]columnStats{
+						"a": {Min: 1, Max: 2.5, Nulls: 1},
+						"b": {Min: "a\"\n\x01", Max: "\xff"},
+						"c": {Nulls: 3},
+					},
+					Partition:     map[string]any{"c": nil},
+					SchemaVersion: 2,
+					RowGroups:     []rowGroup{{Offset: 0, Length: 10, Rows: 3, Checksum: "89abcdef"}},
+					Bytes:         10,
+					Checksum:      "0123abcd",
+					Created:       &created,
+					Transforms:    []string{"b:hash"},
+					KeyFilter:     &bloomFilter{[]byte{1, 2, 3}, 2},
+					BloomFilters:  map[string]*bloomFilter{"b": {[]byte{4}, 1}},
+					Families:      []dataobjectFamily{{Columns: []int{1}, Bytes: 4, Checksum: "01234567"}},
+					TxId:          7,
+					Committed:     &created,
+				}},
+				{AddDataobject: &DataobjectAction{Name: "x_2", Table: "x", Rows: 1}},
+			},
+		},
+	}
+}
+
+func TestLogEntryGoldenFormat(t *testing.T) {
+	bytes, err := encodeLogEntry(goldenLogEntry())
+	assertEq(err, nil, "could not encode")
+
+	golden, err := os.ReadFile("testdata/logentry.json")
+	assertEq(err, nil, "could not read golden entry")
+	assertEq(string(bytes), string(golden[:len(golden)-1]), "format changed")
+}
+
+// Reads back the same as if written by encoding/json.
+func TestLogEntryDecodesAsMarshaled(t *testing.T) {
+	entry := goldenLogEntry()
+	bytes, err := encodeLogEntry(entry)
+	assertEq(err, nil, "could not encode")
+	marshaled, err := json.Marshal(entry)
+	assertEq(err, nil, "could not marshal")
+
+	var fromEncoded, fromMarshaled logEntry
+	err = json.Unmarshal(bytes, &fromEncoded)
+	assertEq(err, nil, "could not decode encoded")
+	err = json.Unmarshal(marshaled, &fromMarshaled)
+	assertEq(err, nil, "could not decode marshaled")
+
+	a, err := json.Marshal(fromEncoded)
+	assertEq(err, nil, "could not marshal")
+	b, err := json.Marshal(fromMarshaled)
+	assertEq(err, nil, "could not marshal")
+	assertEq(string(a), string(b), "decoded differently")
+
+	_, err = encodeLogEntry(&logEntry{Actions: map[string][]Action{"x": {{AddDataobject: &DataobjectAction{
+		Stats: map[string]columnStats{"a": {Min: math.NaN()}},
+	}}}}})
+	assert(err != nil, "encoded NaN")
+}
+
+func TestLogEntryLaterVersion(t *testing.T) {
+	store := newMemoryObjectStorage()
+	err := store.putIfAbsent(context.Background(), logEntryName(0), []byte(`{"Version":2,"Id":0,"Actions":{}}`))
+	assertEq(err, nil, "could not write entry")
+
+	c := newClient(store)
+	err = c.newTx()
+	assert(errors.Is(err, errLogVersion), "read a later version")
+}
+
+// Entries written before versions were recorded and every unset
+// field was left out.
+func TestLogEntryReadsOldFormat(t *testing.T) {
+	store := newMemoryObjectStorage()
+	old := `{"Id":1,"Actions":{"x":[{"AddDataobject":null,"ChangeMetadata":{"Table":"x","Columns":["a"]}},{"AddDataobject":{"Name":"x_1","Table":"x","Rows":1,"Stats":{"a":{"Min":1,"Max":1,"Nulls":0}}},"ChangeMetadata":null}]}}`
+	err := store.putIfAbsent(context.Background(), logEntryName(1), []byte(old))
+	assertEq(err, nil, "could not write entry")
+
+	c := newClient(store)
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(fmt.Sprint(c.tx.tables["x"]), "[a]", "wrong columns")
+	actions := c.tx.previousActions["x"]
+	assertEq(actions[len(actions)-1].AddDataobject.Name, "x_1", "wrong dataobject")
+}
+
+func BenchmarkCommit(b *testing.B) {
+	c := newClient(newMemoryObjectStorage(), withCheckpointInterval(0))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b", "c"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		err = c.newTx()
+		assertEq(err, nil, "could not start tx")
+		for j := 0; j < 10; j++ {
+			err = c.writeRow("x", []any{j, fmt.Sprint(j), nil})
+			assertEq(err, nil, "could not write row")
+			err = c.flushRows("x")
+			assertEq(err, nil, "could not flush")
+		}
+		b.StartTimer()
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+}
+
+func BenchmarkMarshalLogEntry(b *testing.B) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b", "c"})
+	assertEq(err, nil, "could not create x")
+	for j := 0; j < 10; j++ {
+		err = c.writeRow("x", []any{j, fmt.Sprint(j), nil})
+		assertEq(err, nil, "could not write row")
+		err = c.flushRows("x")
+		assertEq(err, nil, "could not flush")
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err = encodeLogEntry(c.tx.logEntry())
+		assertEq(err, nil, "could not marshal")
+	}
+}
@@ -0,0 +1,79 @@
+package otf
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Clients log to the *slog.Logger given with withLogger, and
+// otherwise to nothing. Each record has the component logging it,
+// e.g. "checkpoint", the client's transaction, if any, as tx, and
+// whatever else is at hand, like the table. Progress is logged at
+// debug level, and failures a client carries on after, e.g. failing
+// to checkpoint after a commit, at warn level. Errors it returns
+// aren't logged.
+//
+// S3 and GCS storage log each request at debug level to the Logger
+// in their config, if any, and composite storage falling back to a
+// replica to its logger. Servers log to the logger of their
+// clients' options, and commit hooks to that of the client
+// committing. `otf --debug` logs everything as text to stderr.
+
+var discardLogger = slog.New(discardHandler{})
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+func withLogger(l *slog.Logger) clientOption {
+	return func(c *client) {
+		c.logger = l
+	}
+}
+
+// Logs msg at level to l, if l logs anything at level, with the
+// component and args.
+func logTo(l *slog.Logger, level slog.Level, component, msg string, args ...any) {
+	if l == nil || !l.Enabled(context.Background(), level) {
+		return
+	}
+
+	l.Log(context.Background(), level, msg, append([]any{"component", component}, args...)...)
+}
+
+// Does req with hc, logging it to l, if not nil, as component.
+func logRequest(l *slog.Logger, component string, hc *http.Client, req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	res, err := hc.Do(req)
+	if err != nil {
+		logTo(l, slog.LevelDebug, component, "request failed", "method", req.Method, "path", req.URL.Path, "err", err)
+		return nil, err
+	}
+
+	logTo(l, slog.LevelDebug, component, "request", "method", req.Method, "path", req.URL.Path, "status", res.StatusCode, "duration", time.Since(start))
+	return res, nil
+}
+
+func (d *client) log(level slog.Level, component, msg string, args []any) {
+	if d.logger == nil || !d.logger.Enabled(context.Background(), level) {
+		return
+	}
+
+	if d.tx != nil {
+		args = append(args, "tx", d.tx.Id)
+	}
+	logTo(d.logger, level, component, msg, args...)
+}
+
+func (d *client) debug(component, msg string, args ...any) {
+	d.log(slog.LevelDebug, component, msg, args)
+}
+
+func (d *client) warn(component, msg string, args ...any) {
+	d.log(slog.LevelWarn, component, msg, args)
+}
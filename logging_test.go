@@ -0,0 +1,92 @@
+package otf
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Decodes the JSON records logged to buf.
+func logRecords(buf *bytes.Buffer) []map[string]any {
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var record map[string]any
+		err := json.Unmarshal([]byte(line), &record)
+		assertEq(err, nil, "could not decode log record")
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	mirror := filepath.Join(t.TempDir(), "missing", "log.jsonl")
+	c := newClient(newMemoryObjectStorage(), withLogger(logger), withLogMirror(mirror))
+
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	for i := 0; i < 3; i++ {
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	id := c.tx.Id
+	n, err := c.deleteRows("x", where("a", OP_LT, 2))
+	assertEq(err, nil, "could not delete")
+	assertEq(n, 2, "deleted rows")
+
+	var deleted, mirrored map[string]any
+	for _, record := range logRecords(&buf) {
+		switch record["component"] {
+		case "delete":
+			deleted = record
+		case "mirror":
+			mirrored = record
+		}
+	}
+
+	assert(deleted != nil, "delete not logged")
+	assertEq(deleted["level"], "DEBUG", "delete level")
+	assertEq(deleted["table"], "x", "delete table")
+	assertEq(deleted["rows"], any(float64(2)), "delete rows")
+	assertEq(deleted["tx"], any(float64(id)), "delete tx")
+
+	// The mirror's directory doesn't exist, which the commit
+	// carries on after.
+	assert(mirrored != nil, "mirror failure not logged")
+	assertEq(mirrored["level"], "WARN", "mirror level")
+	assert(strings.Contains(mirrored["err"].(string), "no such file"), "mirror error")
+
+	// Nothing below warn is logged with a warn level logger.
+	buf.Reset()
+	c.logger = slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	_, err = c.deleteRows("x", nil)
+	assertEq(err, nil, "could not delete")
+	assertEq(buf.Len(), 0, "logged below warn")
+}
+
+func TestLoggerDefault(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	assertEq(c.logger, discardLogger, "default logger")
+
+	// Clients not made with newClient log nothing rather than
+	// panicking.
+	var d client
+	d.debug("test", "nothing")
+	logTo(nil, slog.LevelWarn, "test", "nothing")
+}
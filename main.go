@@ -1,13 +1,21 @@
-package main
+package otf
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"net/url"
 	"os"
 	"path"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func assert(b bool, msg string) {
@@ -22,17 +30,6 @@ func assertEq[C comparable](a C, b C, prefix string) {
 	}
 }
 
-var DEBUG = slices.Contains(os.Args, "--debug")
-
-func debug(a ...any) {
-	if !DEBUG {
-		return
-	}
-
-	args := append([]any{"[DEBUG]"}, a...)
-	fmt.Println(args...)
-}
-
 // https://datatracker.ietf.org/doc/html/rfc4122#section-4.4
 func uuidv4() string {
 	f, err := os.Open("/dev/random")
@@ -65,9 +62,11 @@ func uuidv4() string {
 
 type objectStorage interface {
 	// Must be atomic
-	putIfAbsent(name string, bytes []byte) error
-	listPrefix(prefix string) ([]string, error)
-	read(name string) ([]byte, error)
+	putIfAbsent(ctx context.Context, name string, bytes []byte) error
+	listPrefix(ctx context.Context, prefix string) ([]string, error)
+	read(ctx context.Context, name string) ([]byte, error)
+	// Only used to garbage-collect dataobjects, see vacuum.go.
+	delete(ctx context.Context, name string) error
 }
 
 type fileObjectStorage struct {
@@ -78,7 +77,7 @@ func newFileObjectStorage(basedir string) *fileObjectStorage {
 	return &fileObjectStorage{basedir}
 }
 
-func (fos *fileObjectStorage) putIfAbsent(name string, bytes []byte) error {
+func (fos *fileObjectStorage) putIfAbsent(ctx context.Context, name string, bytes []byte) error {
 	tmpfilename := path.Join(fos.basedir, uuidv4())
 	f, err := os.OpenFile(tmpfilename, os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
@@ -114,7 +113,10 @@ func (fos *fileObjectStorage) putIfAbsent(name string, bytes []byte) error {
 	}
 
 	filename := path.Join(fos.basedir, name)
-	err = os.Link(tmpfilename, filename)
+	err = os.MkdirAll(path.Dir(filename), 0755)
+	if err == nil {
+		err = os.Link(tmpfilename, filename)
+	}
 	if err != nil {
 		removeErr := os.Remove(tmpfilename)
 		assert(removeErr == nil, "could not remove")
@@ -124,9 +126,14 @@ func (fos *fileObjectStorage) putIfAbsent(name string, bytes []byte) error {
 	return nil
 }
 
-func (fos *fileObjectStorage) listPrefix(prefix string) ([]string, error) {
-	dir := path.Join(fos.basedir)
+func (fos *fileObjectStorage) listPrefix(ctx context.Context, prefix string) ([]string, error) {
+	// Names with slashes are in subdirectories.
+	subdir, prefix := path.Split(prefix)
+	dir := path.Join(fos.basedir, subdir)
 	f, err := os.Open(dir)
+	if subdir != "" && errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -141,55 +148,347 @@ func (fos *fileObjectStorage) listPrefix(prefix string) ([]string, error) {
 
 		for _, n := range names {
 			if prefix == "" || strings.HasPrefix(n, prefix) {
-				files = append(files, n)
+				files = append(files, subdir+n)
 			}
 		}
 	}
 	err = f.Close()
+
+	// Callers rely on names coming back in lexicographic order
+	// (e.g. newTx to find the latest transaction id).
+	slices.Sort(files)
 	return files, err
 }
 
-func (fos *fileObjectStorage) read(name string) ([]byte, error) {
+func (fos *fileObjectStorage) read(ctx context.Context, name string) ([]byte, error) {
 	filename := path.Join(fos.basedir, name)
 	return os.ReadFile(filename)
 }
 
+func (fos *fileObjectStorage) delete(ctx context.Context, name string) error {
+	return os.Remove(path.Join(fos.basedir, name))
+}
+
+func (fos *fileObjectStorage) stat(ctx context.Context, name string) (objectInfo, error) {
+	info, err := os.Stat(path.Join(fos.basedir, name))
+	if err != nil {
+		return objectInfo{}, err
+	}
+
+	return objectInfo{Size: info.Size(), Modified: info.ModTime()}, nil
+}
+
+func (fos *fileObjectStorage) readRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	f, err := os.Open(path.Join(fos.basedir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	bytes := make([]byte, length)
+	_, err = f.ReadAt(bytes, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes, nil
+}
+
+// Picks a backend from a URL such as file:///var/lib/otf,
+// s3://bucket/some/prefix/, gs://bucket/some/prefix/ or, for
+// published bundles, https://cdn.example.com/some/bundle/.
+//
+// S3 URLs take options as query parameters: requester_pays=true,
+// storage_class, sse (AES256 or aws:kms) and kms_key_id, see
+// s3Config. Any URL can take encryption_keys, a file of keys to
+// encrypt the store with, see encryption.go.
+func openObjectStorage(rawURL string) (objectStorage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if q := u.Query(); q.Has("encryption_keys") {
+		keys, err := readKeyFile(q.Get("encryption_keys"))
+		if err != nil {
+			return nil, err
+		}
+
+		q.Del("encryption_keys")
+		u.RawQuery = q.Encode()
+		os, err := openObjectStorage(u.String())
+		if err != nil {
+			return nil, err
+		}
+
+		return newEncryptedObjectStorage(os, keys), nil
+	}
+
+	switch u.Scheme {
+	case "", "file":
+		return newFileObjectStorage(u.Path), nil
+	case "s3":
+		q := u.Query()
+		sse := q.Get("sse")
+		if sse != "" && sse != S3_SSE_S3 && sse != S3_SSE_KMS {
+			return nil, fmt.Errorf("unknown s3 server-side encryption: %s", sse)
+		}
+		if q.Has("kms_key_id") && sse != S3_SSE_KMS {
+			return nil, fmt.Errorf("s3 kms_key_id requires sse=%s", S3_SSE_KMS)
+		}
+
+		return newS3ObjectStorage(s3Config{
+			Bucket:               u.Host,
+			Prefix:               strings.TrimPrefix(u.Path, "/"),
+			Endpoint:             os.Getenv("AWS_ENDPOINT_URL"),
+			PathStyle:            os.Getenv("AWS_ENDPOINT_URL") != "",
+			RequesterPays:        q.Get("requester_pays") == "true",
+			StorageClass:         q.Get("storage_class"),
+			ServerSideEncryption: sse,
+			KMSKeyId:             q.Get("kms_key_id"),
+		}), nil
+	case "gs":
+		return newGCSObjectStorage(gcsConfig{
+			Bucket: u.Host,
+			Prefix: strings.TrimPrefix(u.Path, "/"),
+		}), nil
+	case "http", "https":
+		return newHTTPObjectStorage(rawURL), nil
+	}
+
+	return nil, fmt.Errorf("unsupported storage: %s", rawURL)
+}
+
 type DataobjectAction struct {
 	Name  string
 	Table string
+	Rows  int
+	// See compression.go.
+	Codec string `json:",omitempty"`
+	// Mapping column name to statistics, see stats.go.
+	Stats map[string]columnStats `json:",omitempty"`
+	// Mapping partition column name to the value all rows share,
+	// see partition.go.
+	Partition map[string]any `json:",omitempty"`
+	// The version of the table's schema rows were written with,
+	// see schema.go.
+	SchemaVersion int `json:",omitempty"`
+	// Set for dataobjects split into row groups, see rowgroup.go.
+	RowGroups []rowGroup `json:",omitempty"`
+	// Size as stored, see cost.go.
+	Bytes int64 `json:",omitempty"`
+	// Of the object as stored, see checksum.go.
+	Checksum string `json:",omitempty"`
+	// When its rows were first written, and the column transforms
+	// already applied to them, see compact.go.
+	Created    *time.Time `json:",omitempty"`
+	Transforms []string   `json:",omitempty"`
+	// Of the rows' keys, for tables with a dedupe window, see
+	// dedupe.go.
+	KeyFilter *bloomFilter `json:",omitempty"`
+	// Mapping column name to a filter of its values, for tables
+	// with bloom filter columns, see bloomindex.go.
+	BloomFilters map[string]*bloomFilter `json:",omitempty"`
+	// Columns stored in objects of their own, for tables with
+	// column families, see families.go.
+	Families []dataobjectFamily `json:",omitempty"`
+	// The transaction that added it and when it committed, see
+	// metadatacolumns.go.
+	TxId      int        `json:",omitempty"`
+	Committed *time.Time `json:",omitempty"`
 }
 
 type ChangeMetadataAction struct {
 	Table   string
 	Columns []string
+	// See partition.go.
+	PartitionColumns []string `json:",omitempty"`
+	// How the schema changed, if it did, see schema.go.
+	Op            string `json:",omitempty"`
+	Column        string `json:",omitempty"`
+	SchemaVersion int    `json:",omitempty"`
+	ColumnIds     []int  `json:",omitempty"`
+	// See types.go.
+	ColumnTypes []string `json:",omitempty"`
+	// Where the table's dataobjects are stored if not with the
+	// log, see credentials.go.
+	Location *TableLocation `json:",omitempty"`
+	// See compliance.go.
+	ComplianceWindow time.Duration `json:",omitempty"`
+	// See dedupe.go.
+	Dedupe *DedupeConfig `json:",omitempty"`
+	// See bloomindex.go.
+	BloomColumns []string `json:",omitempty"`
+	// See cluster.go.
+	SortOrder *SortOrder `json:",omitempty"`
+	// Mapping column name to its codec, see columncodec.go.
+	ColumnCodecs map[string]string `json:",omitempty"`
+	// See families.go.
+	ColumnFamilies [][]string `json:",omitempty"`
+	// See foreignkey.go.
+	ForeignKeys []ForeignKey `json:",omitempty"`
+	// See constraints.go.
+	NotNull    []string `json:",omitempty"`
+	PrimaryKey []string `json:",omitempty"`
+	// See rowsecurity.go.
+	RowPolicies []RowPolicy `json:",omitempty"`
+	// Mapping column name to its default, and to where its sequence
+	// got to, see defaults.go.
+	ColumnDefaults map[string]ColumnDefault `json:",omitempty"`
+	Sequences      map[string]int           `json:",omitempty"`
 }
 
 // an enum, only one field will be non-nil
 type Action struct {
-	AddDataobject  *DataobjectAction
-	ChangeMetadata *ChangeMetadataAction
-	// TODO: Support object removal.
-	// DeleteDataobject *DataobjectAction
+	AddDataobject  *DataobjectAction     `json:",omitempty"`
+	ChangeMetadata *ChangeMetadataAction `json:",omitempty"`
+	// See delete.go.
+	DeleteRows *DeleteAction `json:",omitempty"`
+	// See update.go.
+	RemoveDataobject *RemoveAction `json:",omitempty"`
+	// A receipt, see purge.go.
+	Purge *PurgeAction `json:",omitempty"`
+	// Where the rows came from, see lineage.go.
+	Lineage *LineageAction `json:",omitempty"`
 }
 
 const DATAOBJECT_SIZE int = 64 * 1024
 
+// A transaction's state in memory. What it commits as is a logEntry,
+// see logentry.go.
 type transaction struct {
-	Id int
+	Id int `json:"-"`
+
+	// When the transaction committed, see timetravel.go.
+	CommitInfo *CommitInfo `json:"-"`
+
+	// Opened at a past version, so can't commit writes.
+	historical bool
+	// Opened with newReadTx, so can't write, see readtx.go.
+	readOnly bool
+	// Opened through a catalog view, so can't commit writes, and
+	// mapping table name to the columns it masks, see
+	// catalogview.go.
+	readOnlyCatalog bool
+	masked          map[string][]string
+	// Why the log is read-only for this package, see compat.go.
+	restrictions []string
 
 	// Both are mapping table name to a list of actions on the table.
 	previousActions map[string][]Action
-	Actions         map[string][]Action
+	Actions         map[string][]Action `json:"-"`
 
 	// Mapping tables to column names.
 	tables map[string][]string
 
+	// Mapping partitioned tables to their partition columns.
+	partitions map[string][]string
+
+	// Mapping tables stored apart from the log to where, see
+	// credentials.go.
+	locations map[string]*TableLocation
+
+	// Mapping tables to their compliance window, see
+	// compliance.go.
+	complianceWindows map[string]time.Duration
+
+	// Mapping deduped tables to how, and to what deduping them
+	// in this transaction found so far, see dedupe.go.
+	dedupe       map[string]*DedupeConfig
+	dedupeStates map[string]*dedupeState
+
+	// Mapping tables to the columns their dataobjects keep bloom
+	// filters of, see bloomindex.go.
+	bloomColumns map[string][]string
+
+	// Mapping tables to the order their rows are written in, see
+	// cluster.go.
+	sortOrders map[string]*SortOrder
+
+	// Mapping tables to the codecs of their columns, see
+	// columncodec.go.
+	columnCodecs map[string]map[string]string
+
+	// Mapping tables to the groups of columns their dataobjects
+	// store apart, see families.go.
+	columnFamilies map[string][][]string
+
+	// Mapping tables to their foreign keys, see foreignkey.go.
+	foreignKeys map[string][]ForeignKey
+
+	// Mapping tables to their NOT NULL columns and primary keys, see
+	// constraints.go.
+	notNull     map[string][]string
+	primaryKeys map[string][]string
+
+	// Mapping tables to their row policies, see rowsecurity.go.
+	rowPolicies map[string][]RowPolicy
+
+	// Mapping tables to their columns' defaults and to the last
+	// values of their sequences, the tables whose sequences the
+	// transaction took values from, and the time it fills in, see
+	// defaults.go.
+	columnDefaults map[string]map[string]ColumnDefault
+	sequences      map[string]map[string]int
+	sequencesTaken []string
+	now            time.Time
+
+	// Mapping tables to every version of their schema, see
+	// schema.go.
+	schemas map[string]schemaHistory
+
 	// Mapping table name to unflushed/in-memory rows. When rows
 	// are flushed, the dataobject that contains them is added to
-	// `tx.actions` above and `tx.unflushedDataPointer[table]` is
-	// reset to `0`.
-	unflushedData        map[string]*[DATAOBJECT_SIZE][]any
-	unflushedDataPointer map[string]int
+	// `tx.actions` above and `tx.unflushedData[table]` is reset
+	// to an empty batch.
+	unflushedData map[string]*batch
+
+	// Mapping table name to the number of rows written in this
+	// transaction.
+	rowsWritten map[string]int
+
+	// Mapping table name to the id of the last committed
+	// transaction that changed it.
+	tableVersions map[string]int
+
+	// Keys of dataobjects this transaction created, deleted if it
+	// aborts.
+	written []string
+
+	// Tables whose rows this transaction read, see conflict.go.
+	reads map[string]bool
+
+	// Mapping tables to the committed dataobjects this
+	// transaction read from them, if it validates reads, see
+	// readset.go.
+	readDataobjects map[string]map[string]bool
+
+	// Mapping tables to the filters of this transaction's scans of
+	// them, if it's serializable, see serializable.go.
+	readPredicates map[string][]readPredicate
+
+	// What starting the transaction read, see cost.go.
+	openCost readCost
+
+	// Purges whose dataobjects are deleted once the transaction
+	// commits, see purge.go.
+	purged []*PurgeAction
+
+	// The commit info of the entry before Id, if known, see
+	// hlc.go.
+	lastCommit *CommitInfo
+
+	// Mapping the Parquet copies of dataobjects written for a
+	// Delta log to their size, see delta.go.
+	deltaFiles map[string]int64
+}
+
+func (tx *transaction) unflushedLen(table string) int {
+	if rows, ok := tx.unflushedData[table]; ok {
+		return rows.Len
+	}
+
+	return 0
 }
 
 type client struct {
@@ -198,10 +497,146 @@ type client struct {
 	// client at a time. All reads and writes must be within a
 	// transaction.
 	tx *transaction
+	// What the current transaction's object storage calls are made
+	// with, see context.go.
+	ctx context.Context
+
+	// Record per-table commit statistics, see commitstats.go.
+	collectStats bool
+
+	// How new dataobjects are named, see naming.go.
+	naming namingStrategy
+
+	// How new dataobjects are compressed, see compression.go.
+	codec string
+
+	// Small tables kept in memory across transactions, see
+	// tablecache.go.
+	cache *tableCache
+	// Decoded dataobjects, possibly shared with other clients, see
+	// dataobjectcache.go.
+	dataobjectCache *dataobjectCache
+
+	// Hooks for skipping dataobjects in filtered scans, see
+	// predicate.go.
+	dataobjectFilters []dataobjectFilter
+
+	// Limits how many dataobjects are decoded at once, see
+	// decode.go.
+	decoders chan struct{}
+	// Downloads of dataobjects read ahead, or nil if they take a
+	// decoder, see prefetch.go.
+	fetchers chan struct{}
+
+	// Local file committed log entries are appended to, see
+	// mirror.go.
+	logMirror string
+
+	// Commits between checkpoints, see checkpoint.go.
+	checkpointInterval int
+	// See expire.go.
+	snapshotRetention *snapshotRetention
+
+	// Who the client acts as, and hooks restricting what rows it
+	// sees, see rowsecurity.go.
+	identity   string
+	rowFilters []rowFilter
+
+	// Rows per row group of large dataobjects, see rowgroup.go.
+	rowGroupSize int
+
+	// How often and how patiently to retry commits that lost the
+	// race for their log entry, see conflict.go.
+	commitRetries int
+	commitBackoff time.Duration
+
+	// Plans by query text, see prepare.go.
+	prepared map[string]*preparedQuery
+
+	// User-defined functions by name, see udf.go.
+	functions map[string]*udf
+
+	// Acquires credentials for tables stored elsewhere and the
+	// storage opened with them, see credentials.go.
+	credentials credentialProvider
+	located     *locatedStorages
+	// The store's keys, if it's encrypted, which tables stored
+	// elsewhere are encrypted with too, see encryption.go.
+	keys KeyProvider
+
+	// Column transforms compaction applies to aging rows, by
+	// table, see compact.go.
+	transforms map[string][]columnTransform
+
+	// Decides commit times, see hlc.go.
+	clock commitClock
+
+	// One of LOG_FORMAT_OTF or LOG_FORMAT_DELTA, see delta.go.
+	logFormat string
+
+	// The branch transactions see and commit to, empty for main,
+	// see branch.go.
+	branch string
+
+	// Where tables are registered, and the URL of the store
+	// they're registered as held by, see restcatalog.go.
+	catalog      *restCatalog
+	catalogStore string
+
+	// Whether transactions validate the dataobjects they read
+	// rather than the tables, see readset.go.
+	readValidation bool
+
+	// Whether transactions also validate their scans against rows
+	// committed since they started, see serializable.go.
+	serializable bool
+
+	// Called after each commit, see alerts.go.
+	commitHooks []commitHook
+
+	// Whether to verify the storage before creating a table, and
+	// whether it has been, see storageverify.go.
+	verifyStorage   bool
+	storageVerified bool
+
+	// What the client reports its work to, see metrics.go.
+	metrics Metrics
+
+	// What the client logs to, see logging.go.
+	logger *slog.Logger
+
+	// Fraction of plans from manifests cross-checked against the
+	// log, see manifestverify.go.
+	manifestVerification float64
 }
 
-func newClient(os objectStorage) client {
-	return client{os, nil}
+type clientOption func(*client)
+
+func newClient(os objectStorage, opts ...clientOption) client {
+	c := client{
+		os:                 os,
+		naming:             uuidNaming{},
+		decoders:           defaultDecoders(),
+		checkpointInterval: CHECKPOINT_INTERVAL,
+		rowGroupSize:       ROW_GROUP_SIZE,
+		commitRetries:      COMMIT_RETRIES,
+		commitBackoff:      COMMIT_BACKOFF,
+		located:            newLocatedStorages(),
+		clock:              wallClock{},
+		logFormat:          LOG_FORMAT_OTF,
+		logger:             discardLogger,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if eos, ok := c.os.(*encryptedObjectStorage); ok {
+		c.keys = eos.keys
+	}
+	if c.metrics != nil {
+		c.os = &instrumentedObjectStorage{c.os, c.metrics}
+	}
+
+	return c
 }
 
 var (
@@ -209,34 +644,180 @@ var (
 	errNoTx        = fmt.Errorf("No Transaction")
 	errTableExists = fmt.Errorf("Table Exists")
 	errNoTable     = fmt.Errorf("No Such Table")
+	errInvalidRow  = fmt.Errorf("Invalid Row")
+	errNoColumn    = fmt.Errorf("No Such Column")
+	errConflict    = fmt.Errorf("Transaction Conflict")
 )
 
+func logEntryName(id int) string {
+	return fmt.Sprintf("_log_%020d", id)
+}
+
+func logEntryId(name string) int {
+	id, ok := parseLogEntryId(name)
+	assert(ok, fmt.Sprintf("invalid log entry name: %s", name))
+	return id
+}
+
+// The id of the log entry named name in either log format, see
+// delta.go.
+func parseLogEntryId(name string) (int, bool) {
+	// Coalesced entries go by their last transaction, see
+	// coalesce.go.
+	if _, last, ok := parseCoalescedName(name); ok {
+		return last, true
+	}
+
+	// Branches' entries are numbered like main's, see branch.go.
+	if rest, ok := strings.CutPrefix(name, BRANCH_LOG_PREFIX); ok {
+		_, name, _ = strings.Cut(rest, "/")
+	}
+
+	digits, ok := strings.CutPrefix(name, "_log_")
+	if !ok {
+		digits, ok = strings.CutPrefix(name, DELTA_LOG_PREFIX)
+		digits, _ = strings.CutSuffix(digits, ".json")
+	}
+	if !ok || len(digits) != 20 {
+		return 0, false
+	}
+
+	id, err := strconv.Atoi(digits)
+	return id, err == nil
+}
+
+func (d *client) readLogEntry(name string) (*logEntry, error) {
+	bytes, err := d.os.read(d.context(), name)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeStoredLogEntry(name, bytes)
+}
+
+// Decodes the object name of the log, in either format.
+func decodeStoredLogEntry(name string, bytes []byte) (*logEntry, error) {
+	var err error
+	if strings.HasPrefix(name, DELTA_LOG_PREFIX) {
+		bytes, err = deltaOtfEntry(name, bytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return decodeLogEntry(name, bytes)
+}
+
 func (d *client) newTx() error {
+	return d.newTxContext(context.Background())
+}
+
+// Starts a transaction whose object storage calls, including
+// reading the log to start it, are made with ctx.
+func (d *client) newTxContext(ctx context.Context) error {
 	if d.tx != nil {
 		return errExistingTx
 	}
 
-	logPrefix := "_log_"
-	txLogFilenames, err := d.os.listPrefix(logPrefix)
+	d.ctx = ctx
+	err := d.openTx(false)
+	if err != nil {
+		d.ctx = nil
+	}
+	return err
+}
+
+// Starts the latest transaction, one that can't write if readOnly,
+// see readtx.go.
+func (d *client) openTx(readOnly bool) error {
+	metered, m := d.metered()
+	txLogFilenames, err := metered.listLog()
+	if err != nil {
+		return err
+	}
+
+	err = metered.replayLog(txLogFilenames)
 	if err != nil {
 		return err
 	}
 
+	if d.catalog != nil {
+		err = metered.resolveTableLocations()
+		if err != nil {
+			return err
+		}
+	}
+
+	d.tx = metered.tx
+	d.tx.openCost = m.total()
+	d.tx.readOnly = readOnly
+	if !readOnly {
+		d.tx.allocateWrites()
+	}
+	if d.readValidation {
+		d.tx.readDataobjects = map[string]map[string]bool{}
+	}
+	if d.serializable {
+		d.tx.readPredicates = map[string][]readPredicate{}
+	}
+	return nil
+}
+
+// Starts a transaction as of the given log entries, in order,
+// without what it needs to write.
+func (d *client) replayLog(txLogFilenames []string) error {
 	tx := &transaction{}
 	tx.previousActions = map[string][]Action{}
-	tx.Actions = map[string][]Action{}
 	tx.tables = map[string][]string{}
-	tx.unflushedData = map[string]*[DATAOBJECT_SIZE][]any{}
-	tx.unflushedDataPointer = map[string]int{}
-
-	for _, txLogFilename := range txLogFilenames {
-		bytes, err := d.os.read(txLogFilename)
+	tx.partitions = map[string][]string{}
+	tx.locations = map[string]*TableLocation{}
+	tx.complianceWindows = map[string]time.Duration{}
+	tx.dedupe = map[string]*DedupeConfig{}
+	tx.bloomColumns = map[string][]string{}
+	tx.sortOrders = map[string]*SortOrder{}
+	tx.columnCodecs = map[string]map[string]string{}
+	tx.columnFamilies = map[string][][]string{}
+	tx.foreignKeys = map[string][]ForeignKey{}
+	tx.notNull = map[string][]string{}
+	tx.primaryKeys = map[string][]string{}
+	tx.rowPolicies = map[string][]RowPolicy{}
+	tx.columnDefaults = map[string]map[string]ColumnDefault{}
+	tx.sequences = map[string]map[string]int{}
+	tx.schemas = map[string]schemaHistory{}
+	tx.tableVersions = map[string]int{}
+	tx.masked = map[string][]string{}
+
+	// Start from the latest checkpoint, if any, rather than from
+	// the beginning, see checkpoint.go. Checkpoints are of main, so
+	// on a branch only ones from before it started apply, see
+	// branch.go.
+	mainNames := slices.DeleteFunc(slices.Clone(txLogFilenames), isBranchLogEntry)
+	if len(mainNames) > 0 {
+		cp, err := d.latestCheckpoint(logEntryId(mainNames[len(mainNames)-1]))
 		if err != nil {
 			return err
 		}
 
-		var oldTx transaction
-		err = json.Unmarshal(bytes, &oldTx)
+		if cp != nil {
+			if restriction := cp.entry().restriction(); restriction != "" {
+				d.warn("compat", "read-only", "reason", restriction)
+				tx.restrictions = append(tx.restrictions, restriction)
+			}
+
+			tx.Id = cp.Id + 1
+			for table, actions := range cp.Actions {
+				tx.replay(table, actions)
+				tx.tableVersions[table] = cp.TableVersions[table]
+			}
+
+			txLogFilenames = slices.DeleteFunc(slices.Clone(txLogFilenames), func(name string) bool {
+				return logEntryId(name) <= cp.Id
+			})
+		}
+	}
+
+	for _, txLogFilename := range txLogFilenames {
+		oldTx, err := d.readLogEntry(txLogFilename)
 		if err != nil {
 			return err
 		}
@@ -247,233 +828,1033 @@ func (d *client) newTx() error {
 		// 1 greater than the most recent transaction ID we
 		// see on disk.
 		tx.Id = oldTx.Id + 1
+		tx.lastCommit = oldTx.CommitInfo
+		if restriction := oldTx.restriction(); restriction != "" {
+			d.warn("compat", "read-only", "reason", restriction)
+			tx.restrictions = append(tx.restrictions, restriction)
+		}
 
 		for table, actions := range oldTx.Actions {
-			for _, action := range actions {
-				if action.AddDataobject != nil {
-					tx.previousActions[table] = append(tx.previousActions[table], action)
-				} else if action.ChangeMetadata != nil {
-					// Store the latest version of
-					// each table in memory for
-					// easy lookup.
-					mtd := action.ChangeMetadata
-					tx.tables[table] = mtd.Columns
-				} else {
-					panic(fmt.Sprintf("unsupported action: %v", action))
-				}
+			tx.tableVersions[table] = oldTx.Id
+			if version, ok := oldTx.TableVersions[table]; ok {
+				tx.tableVersions[table] = version
 			}
+			tx.replay(table, actions)
 		}
 	}
 
+	// Removed dataobjects are of no more use to anyone.
+	for table, actions := range tx.previousActions {
+		tx.previousActions[table] = liveActions(actions)
+	}
+
 	d.tx = tx
 	return nil
 }
 
-func (d *client) createTable(table string, columns []string) error {
-	if d.tx == nil {
-		return errNoTx
-	}
-
-	if _, exists := d.tx.tables[table]; exists {
-		return errTableExists
+// Applies committed actions on table to tx's view of it.
+func (tx *transaction) replay(table string, actions []Action) {
+	for _, action := range actions {
+		if action.AddDataobject != nil || action.DeleteRows != nil || action.RemoveDataobject != nil {
+			tx.previousActions[table] = append(tx.previousActions[table], action)
+		} else if action.ChangeMetadata != nil {
+			// Store the latest version of each table in
+			// memory for easy lookup.
+			mtd := action.ChangeMetadata
+			tx.tables[table] = mtd.Columns
+			tx.partitions[table] = mtd.PartitionColumns
+			tx.schemas[table] = tx.schemas[table].record(mtd)
+			if mtd.Location != nil {
+				tx.locations[table] = mtd.Location
+			}
+			tx.complianceWindows[table] = max(tx.complianceWindows[table], mtd.ComplianceWindow)
+			if mtd.Dedupe != nil {
+				tx.dedupe[table] = mtd.Dedupe
+			}
+			if mtd.BloomColumns != nil {
+				tx.bloomColumns[table] = mtd.BloomColumns
+			}
+			if mtd.SortOrder != nil {
+				tx.sortOrders[table] = mtd.SortOrder
+			}
+			if mtd.ColumnCodecs != nil {
+				tx.columnCodecs[table] = mtd.ColumnCodecs
+			}
+			if mtd.ColumnFamilies != nil {
+				tx.columnFamilies[table] = mtd.ColumnFamilies
+			}
+			if mtd.ForeignKeys != nil {
+				tx.foreignKeys[table] = mtd.ForeignKeys
+			}
+			if mtd.NotNull != nil {
+				tx.notNull[table] = mtd.NotNull
+			}
+			if mtd.PrimaryKey != nil {
+				tx.primaryKeys[table] = mtd.PrimaryKey
+			}
+			if mtd.Op == SCHEMA_SET_ROW_POLICIES || mtd.RowPolicies != nil {
+				tx.rowPolicies[table] = mtd.RowPolicies
+			}
+			if mtd.ColumnDefaults != nil {
+				tx.columnDefaults[table] = mtd.ColumnDefaults
+			}
+			if mtd.Sequences != nil {
+				tx.sequences[table] = maps.Clone(mtd.Sequences)
+			}
+		} else if action.Purge != nil || action.Lineage != nil {
+			// Only a record, the actions around it did the work.
+		} else {
+			panic(fmt.Sprintf("unsupported action: %v", action))
+		}
 	}
-
-	// Store it in the in-memory mapping.
-	d.tx.tables[table] = columns
-
-	// And also add it to the action history for future transactions.
-	d.tx.Actions[table] = append(d.tx.Actions[table], Action{
-		ChangeMetadata: &ChangeMetadataAction{
-			Table:   table,
-			Columns: columns,
-		},
-	})
-
-	return nil
 }
 
-func (d *client) writeRow(table string, row []any) error {
-	if d.tx == nil {
-		return errNoTx
+// Checks row fits table's schema, returning it with values coerced
+// to their column's type (see types.go).
+func (d *client) checkRow(table string, row []any) ([]any, error) {
+	columns := d.tx.tables[table]
+	if len(row) != len(columns) {
+		return nil, fmt.Errorf("%w: expected %d columns, got %d", errInvalidRow, len(columns), len(row))
 	}
 
-	if _, ok := d.tx.tables[table]; !ok {
-		return errNoTable
+	if history := d.tx.schemas[table]; history[len(history)-1].Types != nil {
+		row = slices.Clone(row)
+		err := coerceRow(table, columns, history[len(history)-1].Types, row)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	// Try to find an unflushed/in-memory dataobject for this table
-	pointer, ok := d.tx.unflushedDataPointer[table]
-	if !ok {
-		d.tx.unflushedDataPointer[table] = 0
-		d.tx.unflushedData[table] = &[DATAOBJECT_SIZE][]any{}
+	err := checkPartitionValues(table, columns, d.tx.partitions[table], row)
+	if err != nil {
+		return nil, err
 	}
 
-	if pointer == DATAOBJECT_SIZE {
-		d.flushRows(table)
-		pointer = 0
+	err = d.tx.checkNotNull(table, row)
+	if err != nil {
+		return nil, err
 	}
 
-	d.tx.unflushedData[table][pointer] = row
-	d.tx.unflushedDataPointer[table]++
-	return nil
+	return row, nil
 }
 
-type dataobject struct {
-	Table string
-	Name  string
-	Data  [DATAOBJECT_SIZE][]any
-	Len   int
+type tableOptions struct {
+	partitionColumns []string
+	columnTypes      map[string]string
+	location         *TableLocation
+	complianceWindow time.Duration
+	dedupe           *DedupeConfig
+	bloomColumns     []string
+	sortOrder        *SortOrder
+	columnCodecs     map[string]string
+	columnFamilies   [][]string
+	foreignKeys      []ForeignKey
+	notNull          []string
+	primaryKey       []string
+	rowPolicies      []RowPolicy
+	columnDefaults   map[string]ColumnDefault
 }
 
-func (d *client) flushRows(table string) error {
-	if d.tx == nil {
-		return errNoTx
-	}
+type tableOption func(*tableOptions)
 
-	// First write out dataobject if there is anything to write out.
-	pointer, exists := d.tx.unflushedDataPointer[table]
-	if !exists || pointer == 0 {
-		return nil
+func (d *client) createTable(table string, columns []string, opts ...tableOption) error {
+	if err := d.checkWritable(); err != nil {
+		return err
 	}
 
-	df := dataobject{
-		Table: table,
-		Name:  uuidv4(),
-		Data:  *d.tx.unflushedData[table],
-		Len:   pointer,
+	if _, exists := d.tx.tables[table]; exists {
+		return errTableExists
 	}
-	bytes, err := json.Marshal(df)
-	if err != nil {
-		return err
+
+	if d.logFormat == LOG_FORMAT_DELTA && len(d.tx.tables) > 0 {
+		return fmt.Errorf("%w: a Delta table is one table", errDeltaLog)
 	}
 
-	err = d.os.putIfAbsent(fmt.Sprintf("_table_%s_%s", table, df.Name), bytes)
-	if err != nil {
-		return err
+	var o tableOptions
+	for _, opt := range opts {
+		opt(&o)
 	}
 
-	// Record the newly written data file.
-	d.tx.Actions[table] = append(d.tx.Actions[table], Action{
-		AddDataobject: &DataobjectAction{
-			Table: table,
-			Name:  df.Name,
-		},
-	})
+	for _, column := range o.partitionColumns {
+		if !slices.Contains(columns, column) {
+			return fmt.Errorf("%w: %s.%s", errNoColumn, table, column)
+		}
+	}
 
-	// Reset in-memory pointer.
-	d.tx.unflushedDataPointer[table] = 0
-	return nil
-}
+	if o.dedupe != nil {
+		for _, column := range o.dedupe.Columns {
+			if !slices.Contains(columns, column) {
+				return fmt.Errorf("%w: %s.%s", errNoColumn, table, column)
+			}
+		}
+	}
 
-func (d *client) scan(table string) (*scanIterator, error) {
-	if d.tx == nil {
-		return nil, errNoTx
+	for _, column := range o.bloomColumns {
+		if !slices.Contains(columns, column) {
+			return fmt.Errorf("%w: %s.%s", errNoColumn, table, column)
+		}
 	}
 
-	var dataobjects []string
-	allActions := append(d.tx.previousActions[table], d.tx.Actions[table]...)
-	for _, action := range allActions {
-		if action.AddDataobject != nil {
-			dataobjects = append(dataobjects, action.AddDataobject.Name)
+	if o.sortOrder != nil {
+		if len(o.sortOrder.Columns) == 0 {
+			return fmt.Errorf("%w: no columns to sort %s by", errSortColumn, table)
+		}
+		for _, column := range o.sortOrder.Columns {
+			if !slices.Contains(columns, column) {
+				return fmt.Errorf("%w: %s.%s", errNoColumn, table, column)
+			}
 		}
 	}
 
-	var unflushedRows [DATAOBJECT_SIZE][]any
-	if data, ok := d.tx.unflushedData[table]; ok {
-		unflushedRows = *data
+	err := checkColumnCodecs(table, columns, o.columnCodecs)
+	if err != nil {
+		return err
 	}
 
-	return &scanIterator{
-		unflushedRows:    unflushedRows,
-		unflushedRowsLen: d.tx.unflushedDataPointer[table],
-		d:                d,
-		table:            table,
-		dataobjects:      dataobjects,
-	}, nil
-}
+	err = checkColumnFamilies(table, columns, o.columnFamilies)
+	if err != nil {
+		return err
+	}
 
-type scanIterator struct {
-	d     *client
-	table string
+	err = d.checkForeignKeyDeclarations(table, columns, o.foreignKeys)
+	if err != nil {
+		return err
+	}
 
-	// First we iterate through unflushed rows.
-	unflushedRows       [DATAOBJECT_SIZE][]any
-	unflushedRowsLen    int
-	unflushedRowPointer int
+	err = checkConstraintDeclarations(table, columns, o.notNull, o.primaryKey)
+	if err != nil {
+		return err
+	}
 
-	// Then we move through each dataobject.
-	dataobjects        []string
-	dataobjectsPointer int
+	err = d.checkRowPolicies(table, columns, o.rowPolicies)
+	if err != nil {
+		return err
+	}
 
-	// And within each dataobject we iterate through rows.
-	dataobject           *dataobject
-	dataobjectRowPointer int
-}
+	types, err := orderedColumnTypes(table, columns, o.columnTypes)
+	if err != nil {
+		return err
+	}
 
-func (d *client) readDataobject(table, name string) (*dataobject, error) {
-	bytes, err := d.os.read(fmt.Sprintf("_table_%s_%s", table, name))
+	err = checkColumnDefaults(table, columns, types, o.columnDefaults)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	err = d.checkStorageVerified()
+	if err != nil {
+		return err
+	}
+
+	mtd := &ChangeMetadataAction{
+		Table:            table,
+		Columns:          columns,
+		PartitionColumns: o.partitionColumns,
+		ColumnTypes:      types,
+		Location:         o.location,
+		ComplianceWindow: o.complianceWindow,
+		Dedupe:           o.dedupe,
+		BloomColumns:     o.bloomColumns,
+		SortOrder:        o.sortOrder,
+		ColumnCodecs:     o.columnCodecs,
+		ColumnFamilies:   o.columnFamilies,
+		ForeignKeys:      o.foreignKeys,
+		NotNull:          o.notNull,
+		PrimaryKey:       o.primaryKey,
+		RowPolicies:      o.rowPolicies,
+		ColumnDefaults:   o.columnDefaults,
+	}
+
+	// Store it in the in-memory mapping.
+	d.tx.tables[table] = columns
+	d.tx.partitions[table] = o.partitionColumns
+	if o.location != nil {
+		d.tx.locations[table] = o.location
+	}
+	d.tx.complianceWindows[table] = o.complianceWindow
+	if o.dedupe != nil {
+		d.tx.dedupe[table] = o.dedupe
+	}
+	if o.bloomColumns != nil {
+		d.tx.bloomColumns[table] = o.bloomColumns
+	}
+	if o.sortOrder != nil {
+		d.tx.sortOrders[table] = o.sortOrder
+	}
+	if o.columnCodecs != nil {
+		d.tx.columnCodecs[table] = o.columnCodecs
+	}
+	if o.columnFamilies != nil {
+		d.tx.columnFamilies[table] = o.columnFamilies
+	}
+	if o.foreignKeys != nil {
+		d.tx.foreignKeys[table] = o.foreignKeys
+	}
+	if o.notNull != nil {
+		d.tx.notNull[table] = o.notNull
+	}
+	if o.primaryKey != nil {
+		d.tx.primaryKeys[table] = o.primaryKey
+	}
+	if o.rowPolicies != nil {
+		d.tx.rowPolicies[table] = o.rowPolicies
+	}
+	if o.columnDefaults != nil {
+		d.tx.columnDefaults[table] = o.columnDefaults
+	}
+	d.tx.schemas[table] = d.tx.schemas[table].record(mtd)
+
+	// And also add it to the action history for future transactions.
+	d.tx.Actions[table] = append(d.tx.Actions[table], Action{
+		ChangeMetadata: mtd,
+	})
+
+	return nil
+}
+
+func (d *client) writeRow(table string, row []any) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+
+	columns, ok := d.tx.tables[table]
+	if !ok {
+		return errNoTable
+	}
+
+	row, err := d.fillDefaults(table, row)
+	if err != nil {
+		return err
+	}
+
+	row, err = d.checkRow(table, row)
+	if err != nil {
+		return err
+	}
+
+	if d.tx.dedupe[table] != nil {
+		duplicate, err := d.isDuplicate(table, row)
+		if err != nil || duplicate {
+			return err
+		}
+	}
+
+	// Try to find an unflushed/in-memory dataobject for this table
+	rows, ok := d.tx.unflushedData[table]
+	if !ok {
+		rows = newBatch(len(columns))
+		d.tx.unflushedData[table] = rows
+	}
+
+	if rows.Len == DATAOBJECT_SIZE {
+		err := d.flushRows(table)
+		if err != nil {
+			return err
+		}
+
+		rows = d.tx.unflushedData[table]
+	}
+
+	rows.appendRow(row)
+	d.tx.rowsWritten[table]++
+	return nil
+}
+
+// Rows stored column by column: Columns[i][j] is the value of the
+// ith column in the jth row.
+type batch struct {
+	Columns [][]any
+	Len     int
+}
+
+func newBatch(columns int) *batch {
+	return &batch{Columns: make([][]any, columns)}
+}
+
+func (b *batch) appendRow(row []any) {
+	for i, value := range row {
+		b.Columns[i] = append(b.Columns[i], value)
+	}
+	b.Len++
+}
+
+func (b *batch) row(i int) []any {
+	row := make([]any, len(b.Columns))
+	for j, column := range b.Columns {
+		row[j] = column[i]
+	}
+
+	return row
+}
+
+// Rows [from, to) of b, sharing b's memory.
+func (b *batch) slice(from, to int) *batch {
+	s := &batch{Columns: make([][]any, len(b.Columns)), Len: to - from}
+	for i, column := range b.Columns {
+		s.Columns[i] = column[from:to:to]
+	}
+
+	return s
+}
+
+// Only the given columns of b, in the given order, sharing b's
+// memory.
+func (b *batch) project(columns []int) *batch {
+	p := &batch{Columns: make([][]any, len(columns)), Len: b.Len}
+	for i, column := range columns {
+		p.Columns[i] = b.Columns[column]
+	}
+
+	return p
+}
+
+func dataobjectKey(table, name string) string {
+	return fmt.Sprintf("_table_%s_%s", table, name)
+}
+
+type dataobject struct {
+	Table string
+	Name  string
+	batch
+}
+
+func (d *client) flushRows(table string) error {
+	if d.tx == nil {
+		return errNoTx
+	}
+
+	// First write out dataobject if there is anything to write out.
+	rows, exists := d.tx.unflushedData[table]
+	if !exists || rows.Len == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	err := d.writeBatch(table, d.clusterRows(table, rows))
+	if err != nil {
+		return err
+	}
+	d.addMetric(METRIC_FLUSHES, 1)
+	d.addMetric(METRIC_FLUSHED_ROWS, float64(rows.Len))
+	d.observeSince(METRIC_FLUSH_SECONDS, start)
+
+	// Start a new in-memory dataobject. Not reusing the old one
+	// since scans may still be reading it.
+	d.tx.unflushedData[table] = newBatch(len(rows.Columns))
+	return nil
+}
+
+// Writes rows to as many dataobjects as table's partitioning calls
+// for.
+func (d *client) writeBatch(table string, rows *batch) error {
+	partitionColumns := d.tx.partitions[table]
+	if partitionColumns == nil {
+		return d.writeDataobject(table, rows, nil)
+	}
+
+	for _, p := range partitionBatch(d.tx.tables[table], partitionColumns, rows) {
+		err := d.writeDataobject(table, p.rows, p.values)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *client) writeDataobject(table string, rows *batch, partition map[string]any) error {
+	name, err := d.naming.dataobjectName(table, rows)
+	if err != nil {
+		return err
+	}
+	if partition != nil {
+		name = partitionPath(d.tx.partitions[table], partition) + name
+	}
+
+	df := dataobject{
+		Table: table,
+		Name:  name,
+		batch: *rows,
+	}
+	codec := d.codec
+	columnCodecs := d.columnCodecs(table)
+	if columnCodecs != nil {
+		codec = CODEC_COLUMNAR
+	}
+
+	var bytes []byte
+	var groups []rowGroup
+	var families []dataobjectFamily
+	var familyObjects [][]byte
+	if d.tx.columnFamilies[table] != nil {
+		bytes, families, familyObjects, err = d.encodeFamilies(codec, columnCodecs, df)
+	} else if rows.Len > d.rowGroupSize {
+		bytes, groups, err = encodeRowGroups(codec, columnCodecs, d.tx.tables[table], df, d.rowGroupSize)
+	} else {
+		bytes, err = d.encodeDataobject(codec, columnCodecs, df)
+	}
+	if err != nil {
+		return err
+	}
+
+	filter, err := d.dedupeFilter(table, rows)
+	if err != nil {
+		return err
+	}
+
+	storage, err := d.tableStorage(table)
+	if err != nil {
+		return err
+	}
+
+	key := dataobjectKey(table, df.Name)
+	err = d.putDataobject(storage, key, bytes)
+	if err != nil {
+		return err
+	}
+
+	size := int64(len(bytes))
+	for i, object := range familyObjects {
+		err = d.putDataobject(storage, familyKey(key, i), object)
+		if err != nil {
+			return err
+		}
+		size += int64(len(object))
+	}
+
+	if d.logFormat == LOG_FORMAT_DELTA {
+		err = d.writeDeltaFile(table, deltaDataPath(df.Name, 0), rows)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Record the newly written data file.
+	created := time.Now().UTC()
+	d.tx.Actions[table] = append(d.tx.Actions[table], Action{
+		AddDataobject: &DataobjectAction{
+			Table:         table,
+			Name:          df.Name,
+			Rows:          rows.Len,
+			Codec:         codec,
+			Stats:         batchStats(d.tx.tables[table], rows),
+			Partition:     partition,
+			SchemaVersion: d.tx.schemas[table].version(),
+			RowGroups:     groups,
+			Bytes:         size,
+			Checksum:      checksum(bytes),
+			Created:       &created,
+			KeyFilter:     filter,
+			BloomFilters:  d.bloomFilters(table, rows),
+			Families:      families,
+		},
+	})
+
+	return nil
+}
+
+// Encodes df as one object, without row groups.
+func (d *client) encodeDataobject(codec string, columnCodecs []string, df dataobject) ([]byte, error) {
+	if codec == CODEC_COLUMNAR {
+		return encodeColumnar(columnCodecs, &df.batch)
+	}
+
+	bytes, err := json.Marshal(df)
+	if err != nil {
+		return nil, err
+	}
+
+	return compressBytes(d.codec, bytes)
+}
+
+// Decodes an object written by encodeDataobject.
+func decodeDataobject(codec string, bytes []byte) (*batch, error) {
+	if codec == CODEC_COLUMNAR {
+		return decodeColumnar(bytes)
+	}
+
+	bytes, err := decompressBytes(codec, bytes)
+	if err != nil {
+		return nil, err
 	}
 
 	var do dataobject
 	err = json.Unmarshal(bytes, &do)
-	return &do, err
+	return &do.batch, err
 }
 
-// returns (nil, nil) when done
-func (si *scanIterator) next() ([]any, error) {
-	// Iterate through in-memory rows first.
-	if si.unflushedRowPointer < si.unflushedRowsLen {
-		row := si.unflushedRows[si.unflushedRowPointer]
-		si.unflushedRowPointer++
-		return row, nil
+func (d *client) putDataobject(storage objectStorage, key string, bytes []byte) error {
+	err := storage.putIfAbsent(d.context(), key, bytes)
+	if err == nil {
+		d.tx.written = append(d.tx.written, key)
+	} else if errors.Is(err, fs.ErrExist) {
+		// Deterministic naming strategies (e.g. content
+		// hashes) may legitimately produce a name that
+		// already exists. That's fine as long as it really is
+		// the same dataobject.
+		existing, readErr := storage.read(d.context(), key)
+		if readErr == nil && slices.Equal(existing, bytes) {
+			err = nil
+		}
 	}
 
-	// If we've gotten through all dataobjects on disk we're done.
-	if si.dataobjectsPointer == len(si.dataobjects) {
-		return nil, nil
+	return err
+}
+
+type scanOptions struct {
+	columns  []string
+	filter   *predicate
+	computed []computedColumn
+	// See metadatacolumns.go.
+	metadata []string
+	// Ignore the client's identity, see rowsecurity.go.
+	unrestricted bool
+}
+
+type scanOption func(*scanOptions)
+
+// Return only these columns, in this order, rather than every
+// column of the table.
+func withColumns(columns ...string) scanOption {
+	return func(o *scanOptions) {
+		o.columns = columns
+	}
+}
+
+// Return only rows matching p, see predicate.go.
+func withFilter(p *predicate) scanOption {
+	return func(o *scanOptions) {
+		o.filter = p
+	}
+}
+
+// Scan every row whatever the client's identity may see, for checks
+// that must see them all.
+func withoutRowFilter() scanOption {
+	return func(o *scanOptions) {
+		o.unrestricted = true
+	}
+}
+
+// Resolves column names to their positions in table.
+func (d *client) columnPositions(table string, columns []string) ([]int, error) {
+	tableColumns, ok := d.tx.tables[table]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errNoTable, table)
+	}
+
+	var positions []int
+	for _, column := range columns {
+		i := slices.Index(tableColumns, column)
+		if i == -1 {
+			return nil, fmt.Errorf("%w: %s.%s", errNoColumn, table, column)
+		}
+		positions = append(positions, i)
+	}
+
+	return positions, nil
+}
+
+func (d *client) scan(table string, opts ...scanOption) (*scanIterator, error) {
+	if d.tx == nil {
+		return nil, errNoTx
+	}
+
+	var o scanOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var projection []int
+	if o.columns != nil {
+		var err error
+		projection, err = d.columnPositions(table, o.columns)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var keep func(*batch, int) bool
+	if o.filter != nil {
+		if _, ok := d.tx.tables[table]; !ok {
+			return nil, fmt.Errorf("%w: %s", errNoTable, table)
+		}
+
+		err := d.checkUnmasked(table, o.filter)
+		if err != nil {
+			return nil, err
+		}
+
+		keep, err = o.filter.bind(d, table)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if si.dataobject == nil {
-		name := si.dataobjects[si.dataobjectsPointer]
-		o, err := si.d.readDataobject(si.table, name)
+	var computed []func(*batch, int) any
+	for _, c := range o.computed {
+		f, err := d.compileCall(table, c.call)
 		if err != nil {
 			return nil, err
 		}
+		computed = append(computed, f)
+	}
+
+	filter := o.filter
+	if !o.unrestricted {
+		var err error
+		filter, keep, err = d.restrictScan(table, filter, keep)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if o.metadata != nil {
+		err := checkMetadataColumns(table, o.metadata)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	it, err := d.newScanIterator(table, projection, filter, keep, o.metadata != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	it.computed = computed
+	if o.metadata != nil {
+		it.metadata = d.scanMetadata(table, o.metadata)
+	}
+	for _, column := range d.tx.masked[table] {
+		if i := slices.Index(d.tx.tables[table], column); i != -1 {
+			it.masked = append(it.masked, i)
+		}
+	}
+	it.needed = scanColumns(d.tx.tables[table], projection, filter, computed != nil)
+	return it, nil
+}
+
+// Scans table with its columns and filter already resolved, and
+// without the table cache if uncached.
+func (d *client) newScanIterator(table string, projection []int, filter *predicate, keep func(*batch, int) bool, uncached bool) (*scanIterator, error) {
+	d.tx.markRead(table)
+	d.tx.markPredicateRead(table, filter, keep)
+	metered, storage := d.metered()
+
+	// Committed rows may already be in memory, unless this
+	// transaction deleted some of them or changed the schema.
+	previousActions := d.tx.previousActions[table]
+	var cached *batch
+	if d.cache != nil && !uncached && !slices.ContainsFunc(d.tx.Actions[table], func(a Action) bool {
+		return a.DeleteRows != nil || a.RemoveDataobject != nil || a.ChangeMetadata != nil
+	}) {
+		var err error
+		cached, err = d.cache.get(metered, table)
+		if err != nil {
+			return nil, err
+		}
+
+		if cached != nil {
+			for _, action := range liveActions(previousActions) {
+				if action.AddDataobject != nil {
+					d.tx.markDataobjectRead(action.AddDataobject)
+				}
+			}
+			previousActions = nil
+		}
+	}
+
+	actions := liveActions(slices.Concat(previousActions, d.tx.Actions[table]))
+	var dataobjects []*DataobjectAction
+	var pruning scanCost
+	for _, action := range actions {
+		if action.AddDataobject != nil {
+			dataobjects = append(dataobjects, action.AddDataobject)
+			pruning.Dataobjects++
+			pruning.Bytes += action.AddDataobject.Bytes
+		}
+	}
+
+	deleted := deletedRows(actions)
 
-		si.dataobject = o
+	var filtered []int
+	if filter != nil {
+		filtered = filterColumns(d.tx.tables[table], filter)
 	}
 
-	if si.dataobjectRowPointer > si.dataobject.Len {
-		si.dataobjectsPointer++
-		si.dataobject = nil
-		si.dataobjectRowPointer = 0
-		return si.next()
+	// Only see rows written so far, not ones written while
+	// scanning.
+	var unflushed *batch
+	if rows, ok := d.tx.unflushedData[table]; ok && rows.Len > 0 {
+		unflushed = rows.slice(0, rows.Len)
+	}
+
+	return &scanIterator{
+		unflushed:   unflushed,
+		cached:      cached,
+		d:           metered,
+		ctx:         d.context(),
+		storage:     storage,
+		pruning:     pruning,
+		table:       table,
+		dataobjects: dataobjects,
+		projection:  projection,
+		filter:      filter,
+		keep:        keep,
+		filtered:    filtered,
+		deleted:     deleted,
+	}, nil
+}
+
+type scanIterator struct {
+	d     *client
+	table string
+	// What dataobjects are read with, see context.go.
+	ctx context.Context
+
+	// First we iterate through unflushed rows.
+	unflushed *batch
+
+	// Then committed rows from the table cache, if any.
+	cached *batch
+
+	// Then we move through each dataobject.
+	dataobjects        []*DataobjectAction
+	dataobjectsPointer int
+	// Dataobjects being read ahead, see decode.go.
+	pending []chan decodedDataobject
+
+	// And within the unflushed rows or each dataobject we
+	// iterate through rows.
+	current        *batch
+	currentPointer int
+
+	// Positions of the columns to return, or nil for all.
+	projection []int
+	// Positions of the columns read, or nil for all, see
+	// families.go.
+	needed []int
+
+	// Positions of columns returned as nulls, see catalogview.go.
+	masked []int
+
+	// Rows to return, or nil for all.
+	filter *predicate
+	keep   func(*batch, int) bool
+	// Positions of the columns filter is on, nil if unknown, see
+	// latematerialize.go.
+	filtered []int
+
+	// Mapping dataobjects to deleted rows, see delete.go.
+	deleted map[*DataobjectAction]map[int]bool
+
+	// Extra columns to add to each row, see udf.go.
+	computed []func(*batch, int) any
+	// And after them, see metadatacolumns.go.
+	metadata *scanMetadata
+
+	// What the scan read and skipped, see cost.go.
+	storage *meteredObjectStorage
+	pruning scanCost
+	// Whether the scan has been read to the end.
+	done bool
+}
+
+// Reads action's rows in the latest schema of its table as of d's
+// transaction, if any.
+func (d *client) readDataobject(action *DataobjectAction) (*dataobject, error) {
+	var convert func(*batch) *batch
+	if d.tx != nil {
+		convert = d.tx.schemas[action.Table].converter(action.SchemaVersion)
+		d.tx.markDataobjectRead(action)
 	}
 
-	row := si.dataobject.Data[si.dataobjectRowPointer]
-	si.dataobjectRowPointer++
+	return d.readDataobjectWith(d.context(), action, convert)
+}
+
+// Reads action's rows, converting them with convert if not nil (see
+// schemaHistory.converter).
+func (d *client) readDataobjectWith(ctx context.Context, action *DataobjectAction, convert func(*batch) *batch) (*dataobject, error) {
+	storage, err := d.tableStorage(action.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.readDataobjectFrom(ctx, storage, action, convert)
+}
+
+// Like readDataobjectWith but from the given storage, for reading
+// outside a transaction.
+func (d *client) readDataobjectFrom(ctx context.Context, storage objectStorage, action *DataobjectAction, convert func(*batch) *batch) (*dataobject, error) {
+	return d.readDataobjectFamilies(ctx, storage, action, nil, convert)
+}
+
+// Like readDataobjectFrom but only reads the wanted families of
+// action, all of them if nil, see families.go.
+func (d *client) readDataobjectFamilies(ctx context.Context, storage objectStorage, action *DataobjectAction, wanted []bool, convert func(*batch) *batch) (*dataobject, error) {
+	rows, err := d.readDataobjectRows(ctx, storage, action, wanted)
+	if err != nil {
+		return nil, err
+	}
+
+	if convert != nil {
+		rows = convert(rows)
+	}
+	return &dataobject{Table: action.Table, Name: action.Name, batch: *rows}, nil
+}
+
+// action's rows as stored, through the dataobject cache if any.
+func (d *client) readDataobjectRows(ctx context.Context, storage objectStorage, action *DataobjectAction, wanted []bool) (*batch, error) {
+	if d.dataobjectCache != nil {
+		if rows := d.dataobjectCache.get(action, wanted); rows != nil {
+			return rows, nil
+		}
+	}
+
+	read := func(key string) ([]byte, error) {
+		return storage.read(ctx, key)
+	}
+	key := dataobjectKey(action.Table, action.Name)
+	bytes, err := read(key)
+	if err == nil {
+		err = verifyChecksum(key, bytes, action.Checksum)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rows *batch
+	if action.RowGroups != nil {
+		rows, err = decodeRowGroups(action, bytes)
+	} else {
+		rows, err = decodeDataobject(action.Codec, bytes)
+	}
+	if err == nil && action.Families != nil {
+		rows, err = d.readFamilies(read, action, rows, wanted)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if d.dataobjectCache != nil {
+		for _, key := range d.dataobjectCache.put(action, wanted, rows) {
+			d.debug("dataobjectcache", "evicted", "key", key)
+		}
+	}
+	return rows, nil
+}
+
+// Makes sure si.current has rows left to read, moving on to the
+// next batch if needed. Returns false when there are no rows left.
+func (si *scanIterator) advance() (bool, error) {
+	for si.current == nil || si.currentPointer == si.current.Len {
+		si.currentPointer = 0
+		// Where the rows came from, nil if not flushed.
+		var source *DataobjectAction
+
+		// Iterate through in-memory rows first.
+		if si.unflushed != nil {
+			si.current = si.unflushed
+			si.unflushed = nil
+		} else if si.cached != nil {
+			si.current = si.cached
+			si.cached = nil
+		} else {
+			err := si.ctx.Err()
+			if err != nil {
+				return false, err
+			}
+
+			si.readAhead()
+			if len(si.pending) == 0 {
+				// If we've gotten through all dataobjects on disk we're done.
+				if !si.done {
+					si.done = true
+					si.d.debug("cost", "scanned", "table", si.table, "cost", si.cost().String())
+					si.d.recordScan(si.cost())
+				}
+				si.current = nil
+				return false, nil
+			}
+
+			decoded := <-si.pending[0]
+			si.pending = si.pending[1:]
+			if decoded.err != nil {
+				return false, decoded.err
+			}
+
+			si.current = decoded.rows
+			source = decoded.action
+			si.pruning.BytesAfterPruning -= decoded.unread
+		}
+
+		if si.masked != nil {
+			si.current = si.current.masked(si.masked)
+		}
+
+		if si.keep != nil {
+			si.current = si.current.filter(si.keep)
+		}
+
+		full := si.current
+		if si.projection != nil {
+			si.current = si.current.project(si.projection)
+		}
+
+		if si.computed != nil {
+			si.current = si.current.withComputed(full, si.computed)
+		}
+
+		if si.metadata != nil {
+			si.current = si.metadata.append(si.current, source)
+		}
+	}
+
+	return true, nil
+}
+
+// returns (nil, nil) when done
+func (si *scanIterator) next() ([]any, error) {
+	ok, err := si.advance()
+	if !ok || err != nil {
+		return nil, err
+	}
+
+	row := si.current.row(si.currentPointer)
+	si.currentPointer++
 	return row, nil
 }
 
+// Like next but returns up to max rows at once, column by column,
+// without copying them. Returns (nil, nil) when done.
+func (si *scanIterator) nextBatch(max int) (*batch, error) {
+	ok, err := si.advance()
+	if !ok || err != nil {
+		return nil, err
+	}
+
+	to := min(si.currentPointer+max, si.current.Len)
+	b := si.current.slice(si.currentPointer, to)
+	si.currentPointer = to
+	return b, nil
+}
+
 func (d *client) commitTx() error {
 	if d.tx == nil {
 		return errNoTx
 	}
+	// Committing always ends the transaction.
+	defer func() { d.ctx = nil }()
 
-	// Flush any outstanding data
-	for table := range d.tx.tables {
-		err := d.flushRows(table)
-		if err != nil {
-			d.tx = nil
-			return err
-		}
+	// Nothing to commit, see readtx.go.
+	if d.tx.readOnly {
+		d.tx = nil
+		return nil
 	}
 
 	wrote := false
-	for _, actions := range d.tx.Actions {
-		if len(actions) > 0 {
+	for table := range d.tx.tables {
+		if len(d.tx.Actions[table]) > 0 || d.tx.unflushedLen(table) > 0 {
 			wrote = true
 			break
 		}
@@ -484,22 +1865,201 @@ func (d *client) commitTx() error {
 		return nil
 	}
 
-	filename := fmt.Sprintf("_log_%020d", d.tx.Id)
+	if d.tx.historical {
+		d.discardTx()
+		return errHistoricalTx
+	}
+
+	if d.tx.readOnlyCatalog {
+		d.discardTx()
+		return errReadOnlyCatalog
+	}
+
+	if d.tx.restrictions != nil {
+		err := fmt.Errorf("%w: %s", errReadOnlyVersion, strings.Join(d.tx.restrictions, "; "))
+		d.discardTx()
+		return err
+	}
+
+	start := time.Now()
+
+	if d.collectStats {
+		err := d.writeCommitStats(true)
+		if err != nil {
+			d.discardTx()
+			return err
+		}
+	}
+
+	d.tx.recordSequences()
+
+	// Flush any outstanding data
+	for table := range d.tx.tables {
+		err := d.flushRows(table)
+		if err != nil {
+			d.discardTx()
+			return err
+		}
+	}
+
+	var delta []deltaAction
+	if d.logFormat == LOG_FORMAT_DELTA {
+		var err error
+		delta, err = d.deltaActions(time.Now())
+		if err != nil {
+			d.discardTx()
+			return err
+		}
+	}
+
+	err := d.checkPrimaryKeysAtCommit()
+	if err != nil {
+		d.discardTx()
+		return err
+	}
+
+	advisory, err := d.checkForeignKeysAtCommit()
+	if err != nil {
+		d.discardTx()
+		return err
+	}
+
+	var event *commitEvent
+	if len(d.commitHooks) > 0 {
+		event = d.tx.commitEvent()
+		event.logger = d.logger
+		for table, n := range advisory {
+			tc := event.Tables[table]
+			tc.ForeignKeyViolations = n
+			event.Tables[table] = tc
+		}
+	}
+
+	// Recorded if the commit conflicts, counting small
+	// dataobjects before the previous ones are unset.
+	var conflictStats [][]any
+	if d.collectStats {
+		conflictStats = d.commitStatsRows(false)
+	}
+
 	// We won't store previous actions, they will be recovered on
 	// new transactions. So unset them. Honestly not totally
 	// clear why.
 	d.tx.previousActions = nil
-	bytes, err := json.Marshal(d.tx)
-	if err != nil {
-		d.tx = nil
-		return err
+
+	var filename string
+	var entry, bytes []byte
+	tx := d.tx
+	for attempt := 0; ; attempt++ {
+		filename = d.logName(d.tx.Id)
+		d.tx.CommitInfo, err = d.clock.commitInfo(d.previousCommit)
+		if err != nil {
+			d.discardTx()
+			return err
+		}
+		d.tx.stampDataobjects()
+		entry, err = encodeLogEntry(d.tx.logEntry())
+		if err == nil {
+			bytes = entry
+			if d.logFormat == LOG_FORMAT_DELTA {
+				bytes, err = d.deltaLogEntry(entry, delta)
+			}
+		}
+		if err != nil {
+			d.discardTx()
+			return err
+		}
+
+		err = d.os.putIfAbsent(d.context(), filename, bytes)
+		if !errors.Is(err, fs.ErrExist) {
+			break
+		}
+
+		// Someone else committed first, see conflict.go.
+		d.addMetric(METRIC_COMMIT_CONFLICTS, 1)
+		if attempt == d.commitRetries {
+			err = fmt.Errorf("%w: %s already exists, gave up after %d retries", errConflict, filename, attempt)
+		} else {
+			time.Sleep(commitBackoff(d.commitBackoff, attempt))
+			err = d.rebase()
+		}
+		if err != nil {
+			d.discardTx()
+			if d.collectStats && errors.Is(err, errConflict) {
+				d.recordConflictStats(conflictStats)
+			}
+
+			return err
+		}
 	}
 
-	err = d.os.putIfAbsent(filename, bytes)
+	// Other errors may have happened after the log entry was
+	// written, so its dataobjects are left for vacuum (see
+	// vacuum.go) to decide about.
 	d.tx = nil
+	if err == nil {
+		d.addMetric(METRIC_COMMITS, 1)
+		d.observeSince(METRIC_COMMIT_SECONDS, start)
+	}
+
+	if err == nil && d.logMirror != "" {
+		d.mirrorLogEntry(filename, entry)
+	}
+
+	if err == nil && event != nil {
+		event.TxId = tx.Id
+		event.CommitInfo = tx.CommitInfo
+		for _, hook := range d.commitHooks {
+			hook(event)
+		}
+	}
+
+	if err == nil && len(tx.purged) > 0 {
+		err = d.deletePurged(tx)
+	}
+
+	if err == nil && d.catalog != nil {
+		err = d.registerTables(tx, filename)
+	}
+
+	if err == nil && d.checkpointInterval > 0 && (tx.Id+1)%d.checkpointInterval == 0 && d.branch == "" {
+		d.checkpointAfterCommit()
+	}
+
 	return err
 }
 
-func main() {
-	panic("unimplemented")
+// Ends the transaction without committing it. Dataobjects it
+// already wrote are deleted if possible and otherwise left for
+// vacuum, see vacuum.go.
+func (d *client) abortTx() error {
+	if d.tx == nil {
+		return errNoTx
+	}
+
+	d.discardTx()
+	return nil
+}
+
+// Ends the transaction, which must not have committed, best-effort
+// deleting the dataobjects it wrote. Unless names are deterministic:
+// another transaction may have found the same dataobject already
+// there and refer to it, so they're left for vacuum.
+func (d *client) discardTx() {
+	for _, key := range d.tx.written {
+		if d.naming.deterministic() {
+			break
+		}
+
+		storage, err := d.dataobjectStorage(key)
+		if err == nil {
+			err = storage.delete(d.context(), key)
+		}
+		if err != nil {
+			d.warn("abort", "could not delete dataobject", "key", key, "err", err)
+		}
+	}
+
+	d.tx = nil
+	d.ctx = nil
 }
@@ -1,6 +1,8 @@
-package main
+package otf
 
 import (
+	"context"
+	"errors"
 	"os"
 	"testing"
 )
@@ -21,36 +23,27 @@ func TestConcurrentTableWriters(t *testing.T) {
 	// Have c2Writer start up a transaction.
 	err = c2Writer.newTx()
 	assertEq(err, nil, "could not start first c2 tx")
-	debug("[c2] new tx")
 
 	// But then have c1Writer start a transaction and commit it first.
 	err = c1Writer.newTx()
 	assertEq(err, nil, "could not start first c1 tx")
-	debug("[c1] new tx")
 	err = c1Writer.createTable("x", []string{"a", "b"})
 	assertEq(err, nil, "could not create x")
-	debug("[c1] Created table")
 	err = c1Writer.writeRow("x", []any{"Joey", 1})
 	assertEq(err, nil, "could not write first row")
-	debug("[c1] Wrote row")
 	err = c1Writer.writeRow("x", []any{"Yue", 2})
 	assertEq(err, nil, "could not write second row")
-	debug("[c1] Wrote row")
 	err = c1Writer.commitTx()
 	assertEq(err, nil, "could not commit tx")
-	debug("[c1] Committed tx")
 
 	// Now go back to c2 and write data.
 	err = c2Writer.createTable("x", []string{"a", "b"})
 	assertEq(err, nil, "could not create x")
-	debug("[c2] Created table")
 	err = c2Writer.writeRow("x", []any{"Holly", 1})
 	assertEq(err, nil, "could not write first row")
-	debug("[c2] Wrote row")
 
 	err = c2Writer.commitTx()
 	assert(err != nil, "concurrent commit must fail")
-	debug("[c2] tx not committed")
 }
 
 func TestConcurrentReaderWithWriterReadsSnapshot(t *testing.T) {
@@ -69,51 +62,40 @@ func TestConcurrentReaderWithWriterReadsSnapshot(t *testing.T) {
 	// First create some data and commit the transaction.
 	err = c1Writer.newTx()
 	assertEq(err, nil, "could not start first c1 tx")
-	debug("[c1Writer] Started tx")
 	err = c1Writer.createTable("x", []string{"a", "b"})
 	assertEq(err, nil, "could not create x")
-	debug("[c1Writer] Created table")
 	err = c1Writer.writeRow("x", []any{"Joey", 1})
 	assertEq(err, nil, "could not write first row")
-	debug("[c1Writer] Wrote row")
 	err = c1Writer.writeRow("x", []any{"Yue", 2})
 	assertEq(err, nil, "could not write second row")
-	debug("[c1Writer] Wrote row")
 	err = c1Writer.commitTx()
 	assertEq(err, nil, "could not commit tx")
-	debug("[c1Writer] Committed tx")
 
 	// Now start a new transaction for more edits.
 	err = c1Writer.newTx()
 	assertEq(err, nil, "could not start second c1 tx")
-	debug("[c1Writer] Starting new write tx")
 
 	// Before we commit this second write-transaction, start a
 	// read transaction.
 	err = c2Reader.newTx()
 	assertEq(err, nil, "could not start c2 tx")
-	debug("[c2Reader] Started tx")
 
 	// Write and commit rows in c1.
 	err = c1Writer.writeRow("x", []any{"Ada", 3})
 	assertEq(err, nil, "could not write third row")
-	debug("[c1Writer] Wrote third row")
 
 	// Scan x in read-only transaction
 	it, err := c2Reader.scan("x")
 	assertEq(err, nil, "could not scan x")
-	debug("[c2Reader] Started scanning")
 	seen := 0
 	for {
 		row, err := it.next()
 		assertEq(err, nil, "could not iterate x scan")
 
 		if row == nil {
-			debug("[c2Reader] Done scanning")
 			break
 		}
 
-		debug("[c2Reader] Got row in reader tx", row)
 		if seen == 0 {
 			assertEq(row[0], "Joey", "row mismatch in c1")
 			assertEq(row[1], 1.0, "row mismatch in c1")
@@ -129,19 +111,15 @@ func TestConcurrentReaderWithWriterReadsSnapshot(t *testing.T) {
 	// Scan x in c1 write transaction
 	it, err = c1Writer.scan("x")
 	assertEq(err, nil, "could not scan x in c1")
-	debug("[c1Writer] Started scanning")
 	seen = 0
 	for {
 		row, err := it.next()
 		assertEq(err, nil, "could not iterate x scan in c1")
 
 		if row == nil {
-			debug("[c1Writer] Done scanning")
 			break
 		}
 
-		debug("[c1Writer] Got row in tx", row)
-
 		if seen == 0 {
 			assertEq(row[0], "Ada", "row mismatch in c1")
 			// Since this hasn't been serialized to JSON, it's still an int not a float.
@@ -161,10 +139,103 @@ func TestConcurrentReaderWithWriterReadsSnapshot(t *testing.T) {
 	// Writer committing should succeed.
 	err = c1Writer.commitTx()
 	assertEq(err, nil, "could not commit second tx")
-	debug("[c1Writer] Committed tx")
 
 	// Reader committing should succeed.
 	err = c2Reader.commitTx()
 	assertEq(err, nil, "could not commit read-only tx")
-	debug("[c2Reader] Committed tx")
+}
+
+func TestScanProjection(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b", "c"})
+	assertEq(err, nil, "could not create x")
+	err = c.writeRow("x", []any{"Joey", 1, true})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	// One flushed row and one unflushed.
+	err = c.writeRow("x", []any{"Yue", 2, false})
+	assertEq(err, nil, "could not write row")
+
+	it, err := c.scan("x", withColumns("c", "a"))
+	assertEq(err, nil, "could not scan")
+	var rows [][]any
+	for {
+		row, err := it.next()
+		assertEq(err, nil, "could not iterate scan")
+		if row == nil {
+			break
+		}
+
+		rows = append(rows, row)
+	}
+	assertEq(len(rows), 2, "rows")
+	assertEq(rows[0][0], false, "projected row")
+	assertEq(rows[0][1], "Yue", "projected row")
+	assertEq(rows[1][0], true, "projected row")
+	assertEq(rows[1][1], "Joey", "projected row")
+
+	_, err = c.scan("x", withColumns("d"))
+	assert(errors.Is(err, errNoColumn), "unknown column")
+	_, err = c.scan("y", withColumns("a"))
+	assert(errors.Is(err, errNoTable), "unknown table")
+}
+
+func TestAbortTx(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.abortTx()
+	assert(errors.Is(err, errNoTx), "expected no tx")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{2})
+	assertEq(err, nil, "could not write row")
+	err = c.flushRows("x")
+	assertEq(err, nil, "could not flush")
+	err = c.writeRow("x", []any{3})
+	assertEq(err, nil, "could not write row")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+	assert(c.tx == nil, "expected tx to end")
+
+	// Only the committed dataobject is left.
+	names, err := mos.listPrefix(context.Background(), DATAOBJECT_PREFIX)
+	assertEq(err, nil, "could not list")
+	assertEq(len(names), 1, "dataobjects")
+	assertEq(countRows(&c, "x"), 1, "rows")
+
+	// Losing a commit race cleans up too.
+	other := newClient(mos)
+	err = other.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{4})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	err = other.writeRow("x", []any{5})
+	assertEq(err, nil, "could not write row")
+	err = other.commitTx()
+	assert(errors.Is(err, errConflict), "expected conflict")
+	assert(other.tx == nil, "expected tx to end")
+
+	names, err = mos.listPrefix(context.Background(), DATAOBJECT_PREFIX)
+	assertEq(err, nil, "could not list")
+	assertEq(len(names), 2, "dataobjects")
 }
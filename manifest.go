@@ -0,0 +1,198 @@
+package otf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// A checkpoint (see checkpoint.go) holds every table's live actions,
+// but only for replaying. Planners and tools that just want a table's
+// dataobjects, their partitions, statistics and sizes, read its
+// manifest instead: one object summarizing them as of the last
+// transaction to change the table, named after it.
+//
+// Manifests are written along with checkpoints, for each table
+// changed since its latest manifest, and replace it: older manifests
+// of the table are deleted once the new one is written. Like
+// checkpoints they're only an optimization, so failing to write one
+// is only logged, and the table's manifest may be behind its latest
+// transaction. Its Id says which transaction it's as of. See
+// manifestverify.go for planning from manifests.
+//
+// Only main's log is checkpointed, so only main's tables have
+// manifests: reading, planning from or verifying one on a branch (see
+// branch.go) fails rather than mixing up the branch's log with main's
+// manifest.
+
+const MANIFEST_PREFIX = "_manifest_"
+
+type manifestDataobject struct {
+	Name      string
+	Partition map[string]any         `json:",omitempty"`
+	Stats     map[string]columnStats `json:",omitempty"`
+	Rows      int
+	// Of the rows, how many have been deleted, see delete.go.
+	Deleted int   `json:",omitempty"`
+	Bytes   int64 `json:",omitempty"`
+}
+
+type tableManifest struct {
+	Table string
+	// The last transaction to change the table.
+	Id               int
+	Columns          []string
+	PartitionColumns []string `json:",omitempty"`
+	Dataobjects      []manifestDataobject
+	// Totals of the dataobjects, not counting deleted rows.
+	Rows  int
+	Bytes int64
+}
+
+func manifestName(table string, id int) string {
+	return fmt.Sprintf("%s%s_%020d", MANIFEST_PREFIX, table, id)
+}
+
+// The transaction name is a manifest of table as of, if it is one.
+func parseManifestName(table, name string) (int, bool) {
+	suffix, ok := strings.CutPrefix(name, MANIFEST_PREFIX+table+"_")
+	if !ok || len(suffix) != 20 {
+		return 0, false
+	}
+
+	id, err := strconv.Atoi(suffix)
+	return id, err == nil
+}
+
+// table's manifest as of tx.
+func (tx *transaction) manifest(table string) *tableManifest {
+	m := &tableManifest{
+		Table:            table,
+		Id:               tx.tableVersions[table],
+		Columns:          tx.tables[table],
+		PartitionColumns: tx.partitions[table],
+	}
+
+	actions := tx.previousActions[table]
+	deleted := deletedRows(actions)
+	for _, action := range actions {
+		do := action.AddDataobject
+		if do == nil {
+			continue
+		}
+
+		md := manifestDataobject{
+			Name:      do.Name,
+			Partition: do.Partition,
+			Stats:     do.Stats,
+			Rows:      do.Rows,
+			Deleted:   len(deleted[do]),
+			Bytes:     do.Bytes,
+		}
+		m.Dataobjects = append(m.Dataobjects, md)
+		m.Rows += md.Rows - md.Deleted
+		m.Bytes += md.Bytes
+	}
+
+	return m
+}
+
+// Writes the manifests of tx's tables changed since their latest one,
+// deleting older ones.
+func (d *client) writeManifests(tx *transaction) error {
+	for _, table := range sortedKeys(tx.tables) {
+		// Created but never committed to since.
+		if _, ok := tx.tableVersions[table]; !ok {
+			continue
+		}
+
+		err := d.writeManifest(tx.manifest(table), false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Writes m, replacing any manifest as of the same transaction if
+// replace and otherwise leaving it, and deletes older ones.
+func (d *client) writeManifest(m *tableManifest, replace bool) error {
+	bytes, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	name := manifestName(m.Table, m.Id)
+	err = d.os.putIfAbsent(d.context(), name, bytes)
+	if errors.Is(err, fs.ErrExist) && replace {
+		err = d.os.delete(d.context(), name)
+		if err == nil {
+			err = d.os.putIfAbsent(d.context(), name, bytes)
+		}
+	}
+	if errors.Is(err, fs.ErrExist) {
+		// Unchanged since, and manifests as of the same transaction
+		// are the same, or written concurrently from the log.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	names, err := d.os.listPrefix(d.context(), MANIFEST_PREFIX+m.Table+"_")
+	if err != nil {
+		return err
+	}
+	for _, older := range names {
+		if id, ok := parseManifestName(m.Table, older); ok && id < m.Id {
+			err = d.os.delete(d.context(), older)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	d.debug("manifest", "wrote", "table", m.Table, "name", name)
+	return nil
+}
+
+// table's latest manifest, nil if it has none yet, failing with
+// errCorrupt if it can't be decoded. Doesn't need a transaction.
+func (d *client) readManifest(table string) (*tableManifest, error) {
+	if d.branch != "" {
+		return nil, fmt.Errorf("%w: only %s's tables have manifests", errBadRequest, MAIN_BRANCH)
+	}
+
+	names, err := d.os.listPrefix(d.context(), MANIFEST_PREFIX+table+"_")
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(names) - 1; i >= 0; i-- {
+		if _, ok := parseManifestName(table, names[i]); !ok {
+			continue
+		}
+
+		bytes, err := d.os.read(d.context(), names[i])
+		if errors.Is(err, fs.ErrNotExist) {
+			// Replaced since the listing, so read the new one.
+			return d.readManifest(table)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var m tableManifest
+		err = json.Unmarshal(bytes, &m)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", errCorrupt, names[i], err)
+		}
+		return &m, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,70 @@
+package otf
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos, withCheckpointInterval(2))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b"}, withPartitionColumns("b"))
+	assertEq(err, nil, "could not create x")
+	err = c.createTable("x_y", []string{"a"})
+	assertEq(err, nil, "could not create x_y")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	m, err := c.readManifest("x")
+	assertEq(err, nil, "could not read manifest")
+	assert(m == nil, "manifest before a checkpoint")
+
+	// Checkpointed after transaction 1.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	for _, row := range [][]any{{1, "p"}, {2, "p"}, {3, "q"}} {
+		err = c.writeRow("x", row)
+		assertEq(err, nil, "could not write")
+	}
+	err = c.writeRow("x_y", []any{1})
+	assertEq(err, nil, "could not write")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	m, err = c.readManifest("x")
+	assertEq(err, nil, "could not read manifest")
+	assertEq(m.Id, 1, "manifest id")
+	assertEq(len(m.Dataobjects), 2, "dataobjects")
+	assertEq(m.Rows, 3, "rows")
+	assert(m.Bytes > 0, "no bytes")
+	assertEq(fmt.Sprint(m.Dataobjects[0].Partition, m.Dataobjects[1].Partition), "map[b:p] map[b:q]", "partitions")
+	m, err = c.readManifest("x_y")
+	assertEq(err, nil, "could not read manifest")
+	assertEq(m.Rows, 1, "x_y rows")
+
+	// Only x changes before the checkpoint after transaction 3.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.deleteRows("x", where("a", OP_EQ, 1))
+	assertEq(err, nil, "could not delete")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{4, "q"})
+	assertEq(err, nil, "could not write")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	m, err = c.readManifest("x")
+	assertEq(err, nil, "could not read manifest")
+	assertEq(m.Id, 3, "manifest id")
+	assertEq(m.Rows, 3, "rows after a delete")
+	assertEq(m.Dataobjects[0].Deleted, 1, "deleted rows")
+	names, err := mos.listPrefix(context.Background(), MANIFEST_PREFIX)
+	assertEq(err, nil, "could not list")
+	assertEq(fmt.Sprint(names), fmt.Sprint([]string{manifestName("x", 3), manifestName("x_y", 1)}), "manifests")
+}
@@ -0,0 +1,194 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"slices"
+)
+
+// planTable plans a table from its manifest (see manifest.go) rather
+// than by replaying the log, one read rather than every entry since
+// the latest checkpoint, as of the manifest's transaction. So that a
+// manifest drifting from the log, through a bug or someone editing
+// the storage by hand, doesn't go unnoticed:
+//
+//   - a table without a manifest, or whose manifest can't be
+//     decoded, is planned by replaying the log instead, and its
+//     manifest written from the replay;
+//   - a fraction of plans, set with withManifestVerification, cross
+//     check the manifest against replaying the log as of its
+//     transaction. A manifest that differs is logged, counted as
+//     METRIC_MANIFEST_DRIFT, and replaced by the replayed one, which
+//     the plan then uses.
+//
+// `otf manifest --verify` cross-checks a table's manifest however
+// many plans would.
+
+// What cross-checking a manifest against the log found.
+type manifestCheck struct {
+	Table string
+	Id    int
+	// Dataobjects live as of Id but not in the manifest, in it but
+	// not live, and in both but with different row counts.
+	Missing []string `json:",omitempty"`
+	Extra   []string `json:",omitempty"`
+	Changed []string `json:",omitempty"`
+	// Whether the manifest was replaced by the replayed one.
+	Healed bool `json:",omitempty"`
+
+	// The manifest as the log has it.
+	replayed *tableManifest
+}
+
+func (mc *manifestCheck) drifted() bool {
+	return len(mc.Missing) > 0 || len(mc.Extra) > 0 || len(mc.Changed) > 0
+}
+
+// Cross-checks rate of plans from manifests, 1 for all of them, see
+// above.
+func withManifestVerification(rate float64) clientOption {
+	return func(c *client) {
+		c.manifestVerification = rate
+	}
+}
+
+// table's manifest as of the committed transaction id, or as of the
+// latest if id is -1, from replaying the log. Doesn't touch the
+// client's transaction.
+func (d *client) replayManifest(table string, id int) (*tableManifest, error) {
+	r := *d
+	r.tx = nil
+	err := r.newReadTxContext(d.context())
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := r.tx.tables[table]; !ok {
+		return nil, errNoTable
+	}
+
+	if id >= 0 && r.tx.tableVersions[table] != id {
+		r.tx = nil
+		err = r.newTxAt(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r.tx.manifest(table), nil
+}
+
+func compareManifests(m, replayed *tableManifest) *manifestCheck {
+	check := &manifestCheck{Table: m.Table, Id: m.Id, replayed: replayed}
+	live := map[string]manifestDataobject{}
+	for _, md := range replayed.Dataobjects {
+		live[md.Name] = md
+	}
+
+	listed := map[string]bool{}
+	for _, md := range m.Dataobjects {
+		listed[md.Name] = true
+		want, ok := live[md.Name]
+		switch {
+		case !ok:
+			check.Extra = append(check.Extra, md.Name)
+		case want.Rows != md.Rows || want.Deleted != md.Deleted:
+			check.Changed = append(check.Changed, md.Name)
+		}
+	}
+	for _, md := range replayed.Dataobjects {
+		if !listed[md.Name] {
+			check.Missing = append(check.Missing, md.Name)
+		}
+	}
+
+	slices.Sort(check.Extra)
+	slices.Sort(check.Changed)
+	slices.Sort(check.Missing)
+	return check
+}
+
+// Cross-checks m against the log, replacing it if it drifted.
+func (d *client) verifyManifest(m *tableManifest) (*manifestCheck, error) {
+	if d.branch != "" {
+		return nil, fmt.Errorf("%w: only %s's tables have manifests", errBadRequest, MAIN_BRANCH)
+	}
+
+	replayed, err := d.replayManifest(m.Table, m.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	check := compareManifests(m, replayed)
+	if !check.drifted() {
+		return check, nil
+	}
+
+	d.warn("manifest", "drifted from the log", "table", m.Table, "id", m.Id,
+		"missing", len(check.Missing), "extra", len(check.Extra), "changed", len(check.Changed))
+	d.addMetric(METRIC_MANIFEST_DRIFT, 1)
+	err = d.writeManifest(replayed, true)
+	if err != nil {
+		return nil, err
+	}
+
+	check.Healed = true
+	return check, nil
+}
+
+// Cross-checks table's latest manifest, failing with errNoVersion if
+// it has none yet.
+func (d *client) verifyLatestManifest(table string) (*manifestCheck, error) {
+	m, err := d.readManifest(table)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, fmt.Errorf("%w: no manifest of %s yet", errNoVersion, table)
+	}
+
+	return d.verifyManifest(m)
+}
+
+// table's dataobjects, from its manifest, see above. Doesn't need a
+// transaction.
+func (d *client) planTable(table string) (*tableManifest, error) {
+	m, err := d.readManifest(table)
+	if err != nil && !errors.Is(err, errCorrupt) {
+		return nil, err
+	}
+
+	if m == nil {
+		if err != nil {
+			d.warn("manifest", "falling back to the log", "table", table, "err", err)
+		}
+
+		replayed, err := d.replayManifest(table, -1)
+		if err != nil {
+			return nil, err
+		}
+
+		// Only an optimization, like writing it with a checkpoint.
+		err = d.writeManifest(replayed, true)
+		if err != nil {
+			d.warn("manifest", "could not write", "table", table, "err", err)
+		}
+		return replayed, nil
+	}
+
+	if d.manifestVerification <= 0 || rand.Float64() >= d.manifestVerification {
+		return m, nil
+	}
+
+	check, err := d.verifyManifest(m)
+	if errors.Is(err, errNoVersion) {
+		// The log as of the manifest has been expired, see
+		// expire.go, so there's nothing to check it against.
+		d.debug("manifest", "could not verify", "table", table, "err", err)
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return check.replayed, nil
+}
@@ -0,0 +1,122 @@
+package otf
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// Replaces the object name in mos with bytes.
+func overwrite(mos *memoryObjectStorage, name string, bytes []byte) {
+	err := mos.delete(context.Background(), name)
+	assertEq(err, nil, "could not delete")
+	err = mos.putIfAbsent(context.Background(), name, bytes)
+	assertEq(err, nil, "could not put")
+}
+
+func TestPlanTable(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos, withCheckpointInterval(2))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	for i := 0; i < 2; i++ {
+		err = c.newTx()
+		assertEq(err, nil, "could not start tx")
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write")
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+
+	// Checkpointed after transaction 1, so the manifest misses
+	// transaction 2's dataobject.
+	m, err := c.planTable("x")
+	assertEq(err, nil, "could not plan")
+	assertEq(m.Id, 1, "manifest id")
+	assertEq(m.Rows, 1, "rows")
+	check, err := c.verifyLatestManifest("x")
+	assertEq(err, nil, "could not verify")
+	assert(!check.drifted() && !check.Healed, "behind isn't drift")
+
+	// Drop the manifest's dataobject and make up another.
+	drifted := *m
+	drifted.Dataobjects = []manifestDataobject{{Name: "made-up", Rows: 10}}
+	bytes, err := json.Marshal(drifted)
+	assertEq(err, nil, "could not encode")
+	overwrite(mos, manifestName("x", 1), bytes)
+
+	// Trusted without verification.
+	m, err = c.planTable("x")
+	assertEq(err, nil, "could not plan")
+	assertEq(m.Dataobjects[0].Name, "made-up", "unverified plan")
+
+	pm := newPrometheusMetrics()
+	verified := newClient(mos, withManifestVerification(1), withMetrics(pm))
+	m, err = verified.planTable("x")
+	assertEq(err, nil, "could not plan")
+	assertEq(len(m.Dataobjects), 1, "dataobjects")
+	assert(m.Dataobjects[0].Name != "made-up", "verified plan drifted")
+	assertEq(pm.counters[METRIC_MANIFEST_DRIFT][""], 1, "drift metric")
+
+	// Healed.
+	healed, err := c.readManifest("x")
+	assertEq(err, nil, "could not read manifest")
+	assertEq(fmt.Sprint(healed), fmt.Sprint(m), "healed manifest")
+
+	overwrite(mos, manifestName("x", 1), bytes)
+	check, err = c.verifyLatestManifest("x")
+	assertEq(err, nil, "could not verify")
+	assertEq(fmt.Sprint(check.Extra), "[made-up]", "extra")
+	assertEq(fmt.Sprint(check.Missing), fmt.Sprint([]string{m.Dataobjects[0].Name}), "missing")
+	assert(check.Healed, "not healed")
+
+	// Corrupt, so planned from the latest version and rewritten.
+	overwrite(mos, manifestName("x", 1), []byte("{"))
+	_, err = c.readManifest("x")
+	assert(errors.Is(err, errCorrupt), "expected corrupt manifest")
+	m, err = c.planTable("x")
+	assertEq(err, nil, "could not plan")
+	assertEq(m.Id, 2, "replayed id")
+	assertEq(m.Rows, 2, "replayed rows")
+	healed, err = c.readManifest("x")
+	assertEq(err, nil, "could not read manifest")
+	assertEq(healed.Id, 2, "rewritten id")
+
+	_, err = c.planTable("y")
+	assert(errors.Is(err, errNoTable), "expected no table error")
+}
+
+func TestPlanTableOnBranch(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	writeTx(&c, "x", []any{"main"})
+	_, err = c.createBranch("dev", -1)
+	assertEq(err, nil, "could not branch")
+	dev := newClient(mos, withBranch("dev"))
+	writeTx(&dev, "x", []any{"dev"})
+
+	// The branch's log isn't main's manifest.
+	_, err = dev.planTable("x")
+	assert(errors.Is(err, errBadRequest), "expected plan on a branch to fail")
+	_, err = dev.verifyLatestManifest("x")
+	assert(errors.Is(err, errBadRequest), "expected verify on a branch to fail")
+	_, err = dev.writeCheckpoint()
+	assert(errors.Is(err, errBadRequest), "expected checkpoint on a branch to fail")
+
+	m, err := c.planTable("x")
+	assertEq(err, nil, "could not plan")
+	assertEq(m.Id, 1, "manifest id")
+	assertEq(len(m.Dataobjects), 1, "dataobjects")
+}
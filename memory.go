@@ -0,0 +1,107 @@
+package otf
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Keeps everything in process memory. Useful for tests and for
+// embedding otf where durability isn't needed. Safe to share
+// between clients in the same process.
+type memoryObjectStorage struct {
+	mu       sync.RWMutex
+	objects  map[string][]byte
+	modified map[string]time.Time
+}
+
+func newMemoryObjectStorage() *memoryObjectStorage {
+	return &memoryObjectStorage{objects: map[string][]byte{}, modified: map[string]time.Time{}}
+}
+
+func (mos *memoryObjectStorage) putIfAbsent(ctx context.Context, name string, bytes []byte) error {
+	mos.mu.Lock()
+	defer mos.mu.Unlock()
+
+	if _, exists := mos.objects[name]; exists {
+		return fmt.Errorf("%w: %s", fs.ErrExist, name)
+	}
+
+	// Callers may reuse their buffer.
+	mos.objects[name] = slices.Clone(bytes)
+	mos.modified[name] = time.Now()
+	return nil
+}
+
+func (mos *memoryObjectStorage) listPrefix(ctx context.Context, prefix string) ([]string, error) {
+	mos.mu.RLock()
+	defer mos.mu.RUnlock()
+
+	var names []string
+	for name := range mos.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+	return names, nil
+}
+
+func (mos *memoryObjectStorage) read(ctx context.Context, name string) ([]byte, error) {
+	mos.mu.RLock()
+	defer mos.mu.RUnlock()
+
+	bytes, ok := mos.objects[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", fs.ErrNotExist, name)
+	}
+
+	return slices.Clone(bytes), nil
+}
+
+func (mos *memoryObjectStorage) readRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	mos.mu.RLock()
+	defer mos.mu.RUnlock()
+
+	bytes, ok := mos.objects[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", fs.ErrNotExist, name)
+	}
+
+	if offset+length > int64(len(bytes)) {
+		return nil, fmt.Errorf("%w: %s is %d bytes, wanted %d at %d", io.ErrUnexpectedEOF, name, len(bytes), length, offset)
+	}
+
+	return slices.Clone(bytes[offset : offset+length]), nil
+}
+
+func (mos *memoryObjectStorage) delete(ctx context.Context, name string) error {
+	mos.mu.Lock()
+	defer mos.mu.Unlock()
+
+	if _, ok := mos.objects[name]; !ok {
+		return fmt.Errorf("%w: %s", fs.ErrNotExist, name)
+	}
+
+	delete(mos.objects, name)
+	delete(mos.modified, name)
+	return nil
+}
+
+func (mos *memoryObjectStorage) stat(ctx context.Context, name string) (objectInfo, error) {
+	mos.mu.RLock()
+	defer mos.mu.RUnlock()
+
+	bytes, ok := mos.objects[name]
+	if !ok {
+		return objectInfo{}, fmt.Errorf("%w: %s", fs.ErrNotExist, name)
+	}
+
+	return objectInfo{Size: int64(len(bytes)), Modified: mos.modified[name]}, nil
+}
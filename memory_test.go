@@ -0,0 +1,47 @@
+package otf
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"sync"
+	"testing"
+)
+
+func TestMemoryObjectStorageFirstWriterWins(t *testing.T) {
+	mos := newMemoryObjectStorage()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	winners := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := mos.putIfAbsent(context.Background(), "_log_1", []byte{byte(i)})
+			if err == nil {
+				mu.Lock()
+				winners++
+				mu.Unlock()
+			} else {
+				assert(errors.Is(err, fs.ErrExist), "expected exists error")
+			}
+		}(i)
+	}
+	wg.Wait()
+	assertEq(winners, 1, "winners")
+
+	err := mos.putIfAbsent(context.Background(), "_log_0", []byte("a"))
+	assertEq(err, nil, "could not put")
+	err = mos.putIfAbsent(context.Background(), "_table_x", []byte("b"))
+	assertEq(err, nil, "could not put")
+
+	names, err := mos.listPrefix(context.Background(), "_log_")
+	assertEq(err, nil, "could not list")
+	assertEq(len(names), 2, "listed names")
+	assertEq(names[0], "_log_0", "first name")
+	assertEq(names[1], "_log_1", "second name")
+
+	_, err = mos.read(context.Background(), "_log_2")
+	assert(errors.Is(err, fs.ErrNotExist), "expected not exists error")
+}
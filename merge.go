@@ -0,0 +1,159 @@
+package otf
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// Merging upserts rows by key: an incoming row replaces every
+// existing row with the same values in the key columns and is
+// inserted if there is none. Existing rows are replaced the same
+// way updates replace them (see update.go), so a merge commits or
+// conflicts as a whole.
+//
+// Like SQL a null key never matches, so rows with one are always
+// inserted. When incoming rows share a key the last one wins.
+
+// Identifies a row by the values of its key columns, comparing
+// numbers by value whatever their type.
+func mergeKey(row []any, keys []int) (string, bool) {
+	values := make([]any, len(keys))
+	for i, key := range keys {
+		v := row[key]
+		if v == nil {
+			return "", false
+		}
+
+		if f, ok := toFloat64(v); ok {
+			v = f
+		}
+		values[i] = v
+	}
+
+	bytes, err := json.Marshal(values)
+	if err != nil {
+		return "", false
+	}
+
+	return string(bytes), true
+}
+
+// Upserts rows into table keyed on keyColumns. Returns how many
+// rows were inserted and how many existing rows were updated.
+func (d *client) merge(table string, keyColumns []string, rows [][]any) (int, int, error) {
+	if err := d.checkWritable(); err != nil {
+		return 0, 0, err
+	}
+
+	columns, ok := d.tx.tables[table]
+	if !ok {
+		return 0, 0, errNoTable
+	}
+
+	if len(keyColumns) == 0 {
+		return 0, 0, fmt.Errorf("%w: no key columns", errNoColumn)
+	}
+
+	keys, err := d.columnPositions(table, keyColumns)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	incoming := map[string][]any{}
+	var order []string
+	var nullKeys [][]any
+	for _, row := range rows {
+		if len(row) != len(columns) {
+			return 0, 0, fmt.Errorf("%w: expected %d columns, got %d", errInvalidRow, len(columns), len(row))
+		}
+
+		key, ok := mergeKey(row, keys)
+		if !ok {
+			nullKeys = append(nullKeys, row)
+			continue
+		}
+
+		if _, seen := incoming[key]; !seen {
+			order = append(order, key)
+		}
+		incoming[key] = row
+	}
+
+	// Only dataobjects whose stats overlap the incoming keys can
+	// hold a match.
+	var bounds []*predicate
+	for i, key := range keys {
+		var lo, hi any
+		comparable := true
+		for _, row := range incoming {
+			if lo == nil {
+				lo, hi = row[key], row[key]
+				continue
+			}
+
+			toLo, ok := compareValues(row[key], lo)
+			toHi, _ := compareValues(row[key], hi)
+			comparable = comparable && ok
+			if toLo < 0 {
+				lo = row[key]
+			}
+			if toHi > 0 {
+				hi = row[key]
+			}
+		}
+
+		if lo != nil && comparable {
+			bounds = append(bounds, where(keyColumns[i], OP_GTE, lo), where(keyColumns[i], OP_LTE, hi))
+		}
+	}
+
+	var overlaps *predicate
+	if len(bounds) > 0 {
+		overlaps = and(bounds...)
+	}
+
+	updated := 0
+	matched := map[string]bool{}
+	if len(incoming) > 0 {
+		updated, err = d.rewriteRows(table, overlaps, func(b *batch, i int) ([]any, error) {
+			key, ok := mergeKey(b.row(i), keys)
+			if !ok {
+				return nil, nil
+			}
+
+			row, ok := incoming[key]
+			if ok {
+				matched[key] = true
+			}
+			return slices.Clone(row), nil
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	inserted := 0
+	for _, key := range order {
+		if matched[key] {
+			continue
+		}
+
+		err = d.writeRow(table, incoming[key])
+		if err != nil {
+			return 0, 0, err
+		}
+		inserted++
+	}
+
+	for _, row := range nullKeys {
+		err = d.writeRow(table, row)
+		if err != nil {
+			return 0, 0, err
+		}
+		inserted++
+	}
+
+	d.debug("merge", "merged rows", "table", table, "inserted", inserted, "updated", updated)
+	return inserted, updated, nil
+}
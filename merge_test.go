@@ -0,0 +1,69 @@
+package otf
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"id", "name"})
+	assertEq(err, nil, "could not create x")
+	for i := 0; i < 5; i++ {
+		err = c.writeRow("x", []any{i, "old"})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{10, "old"})
+	assertEq(err, nil, "could not write row")
+	inserted, updated, err := c.merge("x", []string{"id"}, [][]any{
+		{3, "new"},
+		{10, "new"},
+		{20, "first"},
+		{20, "new"},
+		{nil, "new"},
+	})
+	assertEq(err, nil, "could not merge")
+	assertEq(inserted, 2, "inserted rows")
+	assertEq(updated, 2, "updated rows")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Merging again updates rather than inserting.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	inserted, updated, err = c.merge("x", []string{"id"}, [][]any{{3, "new"}, {20, "new"}})
+	assertEq(err, nil, "could not merge")
+	assertEq(inserted, 0, "inserted rows")
+	assertEq(updated, 2, "updated rows")
+
+	it, err := c.scan("x")
+	assertEq(err, nil, "could not scan")
+	names := map[string]int{}
+	for {
+		row, err := it.next()
+		assertEq(err, nil, "could not read row")
+		if row == nil {
+			break
+		}
+		names[row[1].(string)]++
+	}
+	assertEq(names["old"], 4, "untouched rows")
+	assertEq(names["new"], 4, "merged rows")
+	assertEq(names["first"], 0, "last incoming row wins")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, _, err = c.merge("x", []string{"nope"}, nil)
+	assert(errors.Is(err, errNoColumn), "unknown key column")
+	_, _, err = c.merge("x", []string{"id"}, [][]any{{1}})
+	assert(errors.Is(err, errInvalidRow), "short row")
+}
@@ -0,0 +1,120 @@
+package otf
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Scans can return metadata columns after a row's own (and computed,
+// see udf.go) columns, to trace where it came from without reading
+// the log by hand:
+//
+//   - _tx_id, the transaction that added the row's dataobject;
+//   - _dataobject, the dataobject's name;
+//   - _commit_time, when that transaction committed.
+//
+// Rows rewritten by compaction, updates and so on come from the
+// transaction that rewrote them. Rows the scanning transaction wrote
+// have its id, the one it commits as if no one else commits first,
+// no commit time, and no dataobject until they're flushed.
+//
+// Each AddDataobject action records its transaction and commit time,
+// set as its log entry is written, so they survive checkpoints and
+// coalescing. Dataobjects committed before they were recorded have
+// nulls.
+
+const (
+	METADATA_TX_ID       = "_tx_id"
+	METADATA_DATAOBJECT  = "_dataobject"
+	METADATA_COMMIT_TIME = "_commit_time"
+)
+
+var metadataColumns = []string{METADATA_TX_ID, METADATA_DATAOBJECT, METADATA_COMMIT_TIME}
+
+// Return these metadata columns, in this order, after the others.
+func withMetadataColumns(columns ...string) scanOption {
+	return func(o *scanOptions) {
+		o.metadata = columns
+	}
+}
+
+func checkMetadataColumns(table string, columns []string) error {
+	for _, column := range columns {
+		if !slices.Contains(metadataColumns, column) {
+			return fmt.Errorf("%w: %s.%s is not a metadata column", errNoColumn, table, column)
+		}
+	}
+
+	return nil
+}
+
+// Records the transaction and its commit time on the dataobjects it
+// adds, before its log entry is written.
+func (tx *transaction) stampDataobjects() {
+	for _, actions := range tx.Actions {
+		for _, action := range actions {
+			if action.AddDataobject != nil {
+				action.AddDataobject.TxId = tx.Id
+				action.AddDataobject.Committed = &tx.CommitInfo.Timestamp
+			}
+		}
+	}
+}
+
+// What a scan needs to fill in metadata columns.
+type scanMetadata struct {
+	columns []string
+	// The scanning transaction and the dataobjects it added.
+	txId  int
+	added map[string]bool
+}
+
+func (d *client) scanMetadata(table string, columns []string) *scanMetadata {
+	sm := &scanMetadata{columns: columns, txId: d.tx.Id, added: map[string]bool{}}
+	for _, action := range d.tx.Actions[table] {
+		if action.AddDataobject != nil {
+			sm.added[action.AddDataobject.Name] = true
+		}
+	}
+
+	return sm
+}
+
+// b with the metadata columns of rows from source appended, nil for
+// rows not flushed yet.
+func (sm *scanMetadata) append(b *batch, source *DataobjectAction) *batch {
+	var txId, name, committed any
+	switch {
+	case source == nil:
+		txId = sm.txId
+	case sm.added[source.Name]:
+		txId, name = sm.txId, source.Name
+	default:
+		name = source.Name
+		// TxId is 0 both for transaction 0 and if unrecorded.
+		if source.Committed != nil {
+			txId, committed = source.TxId, *source.Committed
+		}
+	}
+
+	c := &batch{Columns: append([][]any{}, b.Columns...), Len: b.Len}
+	for _, column := range sm.columns {
+		var value any
+		switch column {
+		case METADATA_TX_ID:
+			value = txId
+		case METADATA_DATAOBJECT:
+			value = name
+		case METADATA_COMMIT_TIME:
+			value = committed
+		}
+
+		values := make([]any, b.Len)
+		for i := range values {
+			values[i] = value
+		}
+		c.Columns = append(c.Columns, values)
+	}
+
+	return c
+}
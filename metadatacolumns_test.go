@@ -0,0 +1,61 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMetadataColumns(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos, withCheckpointInterval(2), withTableCache(10))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	for _, a := range []int{1, 2} {
+		err = c.newTx()
+		assertEq(err, nil, "could not start tx")
+		err = c.writeRow("x", []any{a})
+		assertEq(err, nil, "could not write")
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	defer c.abortTx()
+	err = c.writeRow("x", []any{3})
+	assertEq(err, nil, "could not write")
+
+	_, err = c.scan("x", withMetadataColumns("_nope"))
+	assert(errors.Is(err, errNoColumn), "scanned an unknown metadata column")
+
+	rows := scanAll(&c, "x", withColumns("a"), withMetadataColumns(METADATA_TX_ID, METADATA_COMMIT_TIME, METADATA_DATAOBJECT))
+	assertEq(len(rows), 3, "rows")
+	assertEq(fmt.Sprint(rows[0][:2]), "[3 3]", "unflushed row")
+	assertEq(rows[0][2], nil, "unflushed commit time")
+	assertEq(rows[0][3], nil, "unflushed dataobject")
+
+	var last time.Time
+	for i, row := range rows[1:] {
+		assertEq(fmt.Sprint(row[:2]), fmt.Sprint([]any{i + 1, i + 1}), "committed row")
+		committed, ok := row[2].(time.Time)
+		assert(ok, "commit time isn't a time")
+		assert(!committed.Before(last), "commit times out of order")
+		last = committed
+		_, ok = row[3].(string)
+		assert(ok, "no dataobject")
+	}
+
+	// Recorded through the checkpoint after transaction 1.
+	reader := newClient(mos)
+	err = reader.newTx()
+	assertEq(err, nil, "could not start tx")
+	rows = scanAll(&reader, "x", withMetadataColumns(METADATA_TX_ID))
+	assertEq(fmt.Sprint(rows), "[[1 1] [2 2]]", "metadata after a checkpoint")
+}
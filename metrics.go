@@ -0,0 +1,272 @@
+package otf
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clients with withMetrics report what they're doing against storage
+// to a Metrics as counters and histograms:
+//
+//   - every storage request, by op (put, read, list, delete), its
+//     latency, errors, and bytes written and read;
+//   - scans read to the end, and the bytes and dataobjects they read
+//     and skipped (see cost.go);
+//   - commits, their latency, and the races for a log entry they
+//     lost (see conflict.go), whether or not a retry then won;
+//   - flushes of unflushed rows and their latency.
+//
+// A storage canary (see canary.go) reports its probes as well.
+// Labels are passed as pairs of name and value. Storage reached
+// through table locations (see credentials.go) isn't counted.
+//
+// prometheusMetrics keeps them in memory and serves them in
+// Prometheus' text format, at GET /metrics with `otf serve
+// --metrics`.
+
+const (
+	METRIC_STORAGE_REQUESTS      = "otf_storage_requests_total"
+	METRIC_STORAGE_ERRORS        = "otf_storage_errors_total"
+	METRIC_STORAGE_SECONDS       = "otf_storage_request_seconds"
+	METRIC_STORAGE_BYTES_WRITTEN = "otf_storage_written_bytes_total"
+	METRIC_STORAGE_BYTES_READ    = "otf_storage_read_bytes_total"
+
+	METRIC_SCANS                    = "otf_scans_total"
+	METRIC_SCAN_BYTES               = "otf_scan_bytes_total"
+	METRIC_SCAN_DATAOBJECTS         = "otf_scan_dataobjects_total"
+	METRIC_SCAN_DATAOBJECTS_SKIPPED = "otf_scan_dataobjects_skipped_total"
+
+	METRIC_COMMITS          = "otf_commits_total"
+	METRIC_COMMIT_CONFLICTS = "otf_commit_conflicts_total"
+	METRIC_COMMIT_SECONDS   = "otf_commit_seconds"
+
+	METRIC_FLUSHES        = "otf_flushes_total"
+	METRIC_FLUSHED_ROWS   = "otf_flushed_rows_total"
+	METRIC_FLUSH_SECONDS  = "otf_flush_seconds"
+	METRIC_CANARY_PROBES  = "otf_canary_probes_total"
+	METRIC_CANARY_FAILED  = "otf_canary_failures_total"
+	METRIC_CANARY_UNSAFE  = "otf_canary_unsafe_total"
+	METRIC_CANARY_SECONDS = "otf_canary_seconds"
+	METRIC_MANIFEST_DRIFT = "otf_manifest_drift_total"
+)
+
+type Metrics interface {
+	// Adds delta to a counter.
+	Add(name string, delta float64, labels ...string)
+	// Records an observation, in seconds for latencies, of a
+	// histogram.
+	Observe(name string, value float64, labels ...string)
+}
+
+func withMetrics(m Metrics) clientOption {
+	return func(c *client) {
+		c.metrics = m
+	}
+}
+
+func (d *client) addMetric(name string, delta float64, labels ...string) {
+	if d.metrics != nil {
+		d.metrics.Add(name, delta, labels...)
+	}
+}
+
+func (d *client) observeSince(name string, start time.Time, labels ...string) {
+	if d.metrics != nil {
+		d.metrics.Observe(name, time.Since(start).Seconds(), labels...)
+	}
+}
+
+// Reports each request to metrics.
+type instrumentedObjectStorage struct {
+	objectStorage
+	metrics Metrics
+}
+
+func (ios *instrumentedObjectStorage) request(op string, start time.Time, err error) {
+	ios.metrics.Add(METRIC_STORAGE_REQUESTS, 1, "op", op)
+	ios.metrics.Observe(METRIC_STORAGE_SECONDS, time.Since(start).Seconds(), "op", op)
+	if err != nil {
+		ios.metrics.Add(METRIC_STORAGE_ERRORS, 1, "op", op)
+	}
+}
+
+func (ios *instrumentedObjectStorage) putIfAbsent(ctx context.Context, name string, bytes []byte) error {
+	start := time.Now()
+	err := ios.objectStorage.putIfAbsent(ctx, name, bytes)
+	ios.request("put", start, err)
+	if err == nil {
+		ios.metrics.Add(METRIC_STORAGE_BYTES_WRITTEN, float64(len(bytes)))
+	}
+	return err
+}
+
+func (ios *instrumentedObjectStorage) listPrefix(ctx context.Context, prefix string) ([]string, error) {
+	start := time.Now()
+	names, err := ios.objectStorage.listPrefix(ctx, prefix)
+	ios.request("list", start, err)
+	return names, err
+}
+
+func (ios *instrumentedObjectStorage) read(ctx context.Context, name string) ([]byte, error) {
+	start := time.Now()
+	bytes, err := ios.objectStorage.read(ctx, name)
+	ios.request("read", start, err)
+	ios.metrics.Add(METRIC_STORAGE_BYTES_READ, float64(len(bytes)))
+	return bytes, err
+}
+
+func (ios *instrumentedObjectStorage) readRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	start := time.Now()
+	bytes, err := readRange(ctx, ios.objectStorage, name, offset, length)
+	ios.request("read", start, err)
+	ios.metrics.Add(METRIC_STORAGE_BYTES_READ, float64(len(bytes)))
+	return bytes, err
+}
+
+func (ios *instrumentedObjectStorage) stat(ctx context.Context, name string) (objectInfo, error) {
+	start := time.Now()
+	info, err := statObject(ctx, ios.objectStorage, name)
+	ios.request("stat", start, err)
+	return info, err
+}
+
+func (ios *instrumentedObjectStorage) delete(ctx context.Context, name string) error {
+	start := time.Now()
+	err := ios.objectStorage.delete(ctx, name)
+	ios.request("delete", start, err)
+	return err
+}
+
+func (d *client) recordScan(cost scanCost) {
+	d.addMetric(METRIC_SCANS, 1)
+	d.addMetric(METRIC_SCAN_BYTES, float64(cost.BytesFetched))
+	d.addMetric(METRIC_SCAN_DATAOBJECTS, float64(cost.Dataobjects))
+	d.addMetric(METRIC_SCAN_DATAOBJECTS_SKIPPED, float64(cost.DataobjectsSkipped))
+}
+
+// Upper bounds of histogram buckets, in seconds.
+var prometheusBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type prometheusHistogram struct {
+	buckets []int
+	count   int
+	sum     float64
+}
+
+// Keeps metrics in memory and serves them in Prometheus' text
+// exposition format. Safe for concurrent use.
+type prometheusMetrics struct {
+	mu         sync.Mutex
+	counters   map[string]map[string]float64
+	histograms map[string]map[string]*prometheusHistogram
+}
+
+func newPrometheusMetrics() *prometheusMetrics {
+	return &prometheusMetrics{
+		counters:   map[string]map[string]float64{},
+		histograms: map[string]map[string]*prometheusHistogram{},
+	}
+}
+
+// labels as Prometheus writes them, without braces.
+func prometheusLabels(labels []string) string {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, labels[i]+"="+strconv.Quote(labels[i+1]))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (pm *prometheusMetrics) Add(name string, delta float64, labels ...string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if pm.counters[name] == nil {
+		pm.counters[name] = map[string]float64{}
+	}
+	pm.counters[name][prometheusLabels(labels)] += delta
+}
+
+func (pm *prometheusMetrics) Observe(name string, value float64, labels ...string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if pm.histograms[name] == nil {
+		pm.histograms[name] = map[string]*prometheusHistogram{}
+	}
+	key := prometheusLabels(labels)
+	h, ok := pm.histograms[name][key]
+	if !ok {
+		h = &prometheusHistogram{buckets: make([]int, len(prometheusBuckets))}
+		pm.histograms[name][key] = h
+	}
+
+	for i, bound := range prometheusBuckets {
+		if value <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// Writes every metric, in order of name and labels.
+func (pm *prometheusMetrics) write(w io.Writer) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	series := func(name, labels string) string {
+		if labels == "" {
+			return name
+		}
+		return name + "{" + labels + "}"
+	}
+	withLabel := func(labels, label string) string {
+		if labels == "" {
+			return label
+		}
+		return labels + "," + label
+	}
+
+	var b strings.Builder
+	names := slices.Concat(sortedKeys(pm.counters), sortedKeys(pm.histograms))
+	slices.Sort(names)
+	for _, name := range names {
+		if counters, ok := pm.counters[name]; ok {
+			fmt.Fprintf(&b, "# TYPE %s counter\n", name)
+			for _, labels := range sortedKeys(counters) {
+				fmt.Fprintf(&b, "%s %s\n", series(name, labels), strconv.FormatFloat(counters[labels], 'g', -1, 64))
+			}
+			continue
+		}
+
+		fmt.Fprintf(&b, "# TYPE %s histogram\n", name)
+		for _, labels := range sortedKeys(pm.histograms[name]) {
+			h := pm.histograms[name][labels]
+			for i, bound := range prometheusBuckets {
+				le := fmt.Sprintf("le=%q", strconv.FormatFloat(bound, 'g', -1, 64))
+				fmt.Fprintf(&b, "%s %d\n", series(name+"_bucket", withLabel(labels, le)), h.buckets[i])
+			}
+			fmt.Fprintf(&b, "%s %d\n", series(name+"_bucket", withLabel(labels, `le="+Inf"`)), h.count)
+			fmt.Fprintf(&b, "%s %s\n", series(name+"_sum", labels), strconv.FormatFloat(h.sum, 'g', -1, 64))
+			fmt.Fprintf(&b, "%s %d\n", series(name+"_count", labels), h.count)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (pm *prometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	// A failed write means the scraper went away, nothing to tell it.
+	_ = pm.write(w)
+}
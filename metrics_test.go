@@ -0,0 +1,101 @@
+package otf
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	pm := newPrometheusMetrics()
+	c := newClient(mos, withMetrics(pm))
+	other := newClient(mos, withMetrics(pm))
+
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.createTable("y", []string{"a"})
+	assertEq(err, nil, "could not create y")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// other loses the race for the next log entry and retries.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = other.newTx()
+	assertEq(err, nil, "could not start tx")
+	for _, d := range []*client{&c, &other} {
+		table := "x"
+		if d == &other {
+			table = "y"
+		}
+		err = d.writeRow(table, []any{1})
+		assertEq(err, nil, "could not write")
+		err = d.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	rows := scanAll(&c, "x")
+	assertEq(len(rows), 1, "rows")
+
+	counter := func(name string, labels ...string) float64 {
+		pm.mu.Lock()
+		defer pm.mu.Unlock()
+		return pm.counters[name][prometheusLabels(labels)]
+	}
+	assertEq(counter(METRIC_COMMITS), 3.0, "commits")
+	assertEq(counter(METRIC_COMMIT_CONFLICTS), 1.0, "conflicts")
+	assertEq(counter(METRIC_FLUSHES), 2.0, "flushes")
+	assertEq(counter(METRIC_FLUSHED_ROWS), 2.0, "flushed rows")
+	assertEq(counter(METRIC_SCANS), 1.0, "scans")
+	assertEq(counter(METRIC_SCAN_DATAOBJECTS), 1.0, "scanned dataobjects")
+	assert(counter(METRIC_SCAN_BYTES) > 0, "no bytes scanned")
+	assert(counter(METRIC_STORAGE_REQUESTS, "op", "put") >= 6, "puts")
+	assertEq(counter(METRIC_STORAGE_ERRORS, "op", "put"), 1.0, "failed puts")
+	assert(counter(METRIC_STORAGE_BYTES_READ) >= counter(METRIC_SCAN_BYTES), "bytes read")
+
+	var b strings.Builder
+	err = pm.write(&b)
+	assertEq(err, nil, "could not write metrics")
+	out := b.String()
+	assert(strings.Contains(out, "# TYPE otf_commits_total counter\notf_commits_total 3\n"), out)
+	assert(strings.Contains(out, `otf_storage_requests_total{op="put"}`), out)
+	assert(strings.Contains(out, "# TYPE otf_flush_seconds histogram\n"), out)
+	assert(strings.Contains(out, "otf_flush_seconds_bucket{le=\"+Inf\"} 2\notf_flush_seconds_sum "), out)
+	assert(strings.Contains(out, "otf_flush_seconds_count 2\n"), out)
+}
+
+func TestMetricsServer(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	pm := newPrometheusMetrics()
+	s := newServer(mos, "secret", withMetrics(pm))
+	s.metrics = pm
+	s.canary = newStorageCanary(mos)
+	s.canary.metrics = pm
+	s.canary.probe(context.Background())
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	status, _ := post(srv, "secret", "/sql", map[string]any{"Statement": "CREATE TABLE x (a)"})
+	assertEq(status, http.StatusOK, "create status")
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/metrics", nil)
+	assertEq(err, nil, "could not build request")
+	req.Header.Set("Authorization", "Bearer secret")
+	res, err := srv.Client().Do(req)
+	assertEq(err, nil, "could not get")
+	defer res.Body.Close()
+	assertEq(res.StatusCode, http.StatusOK, "metrics status")
+	body, err := io.ReadAll(res.Body)
+	assertEq(err, nil, "could not read metrics")
+	assert(strings.Contains(string(body), "otf_commits_total 1\n"), string(body))
+	assert(strings.Contains(string(body), "otf_canary_probes_total 1\n"), string(body))
+	assert(strings.Contains(string(body), `otf_canary_seconds_count{step="put"} 1`), string(body))
+}
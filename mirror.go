@@ -0,0 +1,54 @@
+package otf
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Every log entry a client commits can also be appended to a local
+// file, one JSON object per line with the time it was committed, so
+// a table's activity can be followed with tail -f rather than by
+// polling object storage. Only commits made through the client are
+// mirrored. The mirror is best effort: failing to write it doesn't
+// fail the commit.
+
+type mirroredLogEntry struct {
+	Time  time.Time
+	Name  string
+	Entry json.RawMessage
+}
+
+// Appends committed log entries to the file at path, creating it if
+// needed.
+func withLogMirror(path string) clientOption {
+	return func(c *client) {
+		c.logMirror = path
+	}
+}
+
+func (d *client) mirrorLogEntry(name string, entry []byte) {
+	line, err := json.Marshal(mirroredLogEntry{time.Now().UTC(), name, entry})
+	if err == nil {
+		err = appendLine(d.logMirror, line)
+	}
+
+	if err != nil {
+		d.warn("mirror", "could not mirror log entry", "name", name, "path", d.logMirror, "err", err)
+	}
+}
+
+// Writes line in one write so concurrent appenders don't interleave.
+func appendLine(path string, line []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,60 @@
+package otf
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogMirror(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.ndjson")
+	mos := newMemoryObjectStorage()
+	c := newClient(mos, withLogMirror(path))
+
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+
+	// Loses the race, so isn't mirrored.
+	other := newClient(mos)
+	err = other.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = other.writeRow("x", []any{2})
+	assertEq(err, nil, "could not write row")
+	err = other.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.commitTx()
+	assert(err != nil, "expected conflict")
+
+	f, err := os.Open(path)
+	assertEq(err, nil, "could not open mirror")
+	defer f.Close()
+
+	var entries []mirroredLogEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry mirroredLogEntry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		assertEq(err, nil, "could not parse mirrored entry")
+		entries = append(entries, entry)
+	}
+
+	assertEq(len(entries), 1, "mirrored entries")
+	assertEq(entries[0].Name, logEntryName(0), "mirrored entry")
+	assert(!entries[0].Time.IsZero(), "mirrored entry time")
+
+	entry, err := decodeLogEntry(entries[0].Name, entries[0].Entry)
+	assertEq(err, nil, "could not parse log entry")
+	assertEq(entry.Actions["x"][0].ChangeMetadata.Columns[0], "a", "log entry")
+}
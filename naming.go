@@ -0,0 +1,88 @@
+package otf
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Decides the name of a new dataobject. The name ends up in the
+// dataobject's key (see dataobjectKey) and in its AddDataobject
+// action. Key distribution matters to object stores that partition
+// by key prefix, so it's worth choosing per deployment.
+type namingStrategy interface {
+	dataobjectName(table string, rows *batch) (string, error)
+	// Whether the same rows get the same name, so transactions may
+	// share a dataobject.
+	deterministic() bool
+}
+
+func withNaming(n namingStrategy) clientOption {
+	return func(c *client) {
+		c.naming = n
+	}
+}
+
+// The default.
+type uuidNaming struct{}
+
+func (uuidNaming) dataobjectName(string, *batch) (string, error) {
+	return uuidv4(), nil
+}
+
+func (uuidNaming) deterministic() bool {
+	return false
+}
+
+// Names sort in the order they were written, which makes listing
+// recent dataobjects cheap but concentrates writes on one key range.
+type timePrefixNaming struct{}
+
+func (timePrefixNaming) dataobjectName(string, *batch) (string, error) {
+	return fmt.Sprintf("%020d-%s", time.Now().UnixNano(), uuidv4()), nil
+}
+
+func (timePrefixNaming) deterministic() bool {
+	return false
+}
+
+// Prepends a few hex characters derived from the wrapped strategy's
+// name so that sequential names (e.g. timePrefixNaming) are spread
+// across the keyspace.
+type hashPrefixNaming struct {
+	inner namingStrategy
+}
+
+func (hpn hashPrefixNaming) dataobjectName(table string, rows *batch) (string, error) {
+	name, err := hpn.inner.dataobjectName(table, rows)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:2]) + "-" + name, nil
+}
+
+func (hpn hashPrefixNaming) deterministic() bool {
+	return hpn.inner.deterministic()
+}
+
+// Names dataobjects by the SHA-256 of their rows so identical
+// flushes share a single object.
+type contentHashNaming struct{}
+
+func (contentHashNaming) dataobjectName(table string, rows *batch) (string, error) {
+	bytes, err := json.Marshal(rows)
+	if err != nil {
+		return "", fmt.Errorf("could not hash rows of %s: %w", table, err)
+	}
+
+	sum := sha256.Sum256(bytes)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (contentHashNaming) deterministic() bool {
+	return true
+}
@@ -0,0 +1,88 @@
+package otf
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestNamingStrategies(t *testing.T) {
+	for _, naming := range []namingStrategy{
+		uuidNaming{},
+		timePrefixNaming{},
+		hashPrefixNaming{timePrefixNaming{}},
+		contentHashNaming{},
+	} {
+		mos := newMemoryObjectStorage()
+		c := newClient(mos, withNaming(naming))
+
+		// Write the same rows twice so content-addressed
+		// names collide.
+		for i := 0; i < 2; i++ {
+			err := c.newTx()
+			assertEq(err, nil, "could not start tx")
+			if i == 0 {
+				err = c.createTable("x", []string{"a"})
+				assertEq(err, nil, "could not create x")
+			}
+			err = c.writeRow("x", []any{"Joey"})
+			assertEq(err, nil, "could not write row")
+			err = c.commitTx()
+			assertEq(err, nil, "could not commit")
+		}
+
+		names, err := mos.listPrefix(context.Background(), "_table_x_")
+		assertEq(err, nil, "could not list")
+		if _, ok := naming.(contentHashNaming); ok {
+			assertEq(len(names), 1, "content-addressed dataobjects")
+			assertEq(len(strings.TrimPrefix(names[0], "_table_x_")), 64, "sha256 name")
+		} else {
+			assertEq(len(names), 2, "dataobjects")
+		}
+
+		assertEq(countRows(&c, "x"), 2, "rows in x")
+	}
+}
+
+func TestContentHashNamingAbort(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos, withNaming(contentHashNaming{}))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Both flush the same rows, so the second finds the first's
+	// dataobject and uses it.
+	var clients []client
+	for i := 0; i < 2; i++ {
+		clients = append(clients, newClient(mos, withNaming(contentHashNaming{})))
+		err = clients[i].newTx()
+		assertEq(err, nil, "could not start tx")
+		err = clients[i].writeRow("x", []any{"Joey"})
+		assertEq(err, nil, "could not write row")
+		err = clients[i].flushRows("x")
+		assertEq(err, nil, "could not flush")
+	}
+
+	err = clients[0].abortTx()
+	assertEq(err, nil, "could not abort")
+	err = clients[1].commitTx()
+	assertEq(err, nil, "could not commit")
+	assertEq(countRows(&c, "x"), 1, "rows in x")
+}
+
+func TestContentHashNamingUnencodableRows(t *testing.T) {
+	c := newClient(newMemoryObjectStorage(), withNaming(contentHashNaming{}))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.writeRow("x", []any{math.Inf(1)})
+	assertEq(err, nil, "could not write row")
+	err = c.flushRows("x")
+	assert(err != nil, "named rows JSON can't encode")
+}
@@ -0,0 +1,191 @@
+package otf
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// An event read from an application's outbox table. Offsets must be
+// strictly increasing in the order the source returns them.
+type outboxEvent struct {
+	Offset int64
+	Row    []any
+}
+
+type outboxSource interface {
+	// Returns up to limit events with an offset greater than
+	// after, ordered by offset. An empty result means the outbox
+	// has been drained for now.
+	readAfter(after int64, limit int) ([]outboxEvent, error)
+}
+
+type outboxProgress struct {
+	Table      string
+	LastOffset int64
+	Events     int
+	Batches    int
+}
+
+// Each committed batch also records the last outbox offset it
+// contains in this table, in the same transaction as the events
+// themselves. So either both the events and their offset are
+// visible or neither is, and a relay that restarts (or races with
+// another relay) picks up right after the last committed offset.
+//
+// The table keeps one row per relayed table, merged over (see
+// merge.go) by each batch, and offsets are decimal strings since
+// JSON numbers only hold integers up to 2^53 exactly. Tables created
+// before hold a row per batch with integer offsets, which are still
+// read, and which merging sets to the latest so they stop growing.
+const OUTBOX_OFFSETS_TABLE = "_outbox_offsets"
+
+var outboxOffsetsColumns = []string{"table", "offset"}
+
+// JSON round-trips turn integers into float64, so offsets read
+// back from tables created before columns were typed (see types.go)
+// need normalizing.
+func toInt64(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float64:
+		return int64(n), true
+	}
+
+	return 0, false
+}
+
+func (d *client) lastOutboxOffset(table string) (int64, error) {
+	if _, ok := d.tx.tables[OUTBOX_OFFSETS_TABLE]; !ok {
+		return -1, nil
+	}
+
+	it, err := d.scan(OUTBOX_OFFSETS_TABLE, withFilter(where("table", OP_EQ, table)))
+	if err != nil {
+		return -1, err
+	}
+
+	last := int64(-1)
+	for {
+		row, err := it.next()
+		if err != nil {
+			return -1, err
+		}
+
+		if row == nil {
+			break
+		}
+
+		offset, ok := toInt64(row[1])
+		if s, isString := row[1].(string); isString {
+			var err error
+			offset, err = strconv.ParseInt(s, 10, 64)
+			ok = err == nil
+		}
+		if !ok {
+			return -1, fmt.Errorf("invalid outbox offset of %s: %v", table, row[1])
+		}
+		last = max(last, offset)
+	}
+
+	return last, nil
+}
+
+// offset as the offsets table stores it.
+func (d *client) outboxOffsetValue(offset int64) any {
+	history := d.tx.schemas[OUTBOX_OFFSETS_TABLE]
+	types := history[len(history)-1].Types
+	column := slices.Index(d.tx.tables[OUTBOX_OFFSETS_TABLE], "offset")
+	if types != nil && types[column] == COLUMN_INT {
+		return offset
+	}
+
+	return strconv.FormatInt(offset, 10)
+}
+
+// Copies events from src into table, batchSize events per
+// transaction, until src has nothing newer than the last committed
+// offset. The table must already exist. Safe to call again after a
+// failure or concurrently with another relay for the same table:
+// a batch is never committed twice. progress, if not nil, is called
+// after every committed batch. Fails with errBadRequest, committing
+// nothing more, if batchSize isn't positive or src returns offsets
+// out of order.
+func (d *client) relayOutbox(src outboxSource, table string, batchSize int, progress func(outboxProgress)) (outboxProgress, error) {
+	p := outboxProgress{Table: table, LastOffset: -1}
+	if batchSize <= 0 {
+		return p, fmt.Errorf("%w: batch size must be positive: %d", errBadRequest, batchSize)
+	}
+
+	for {
+		err := d.newTx()
+		if err != nil {
+			return p, err
+		}
+
+		last, err := d.lastOutboxOffset(table)
+		if err != nil {
+			d.abortTx()
+			return p, err
+		}
+		p.LastOffset = last
+
+		events, err := src.readAfter(last, batchSize)
+		if err != nil {
+			d.abortTx()
+			return p, err
+		}
+
+		if len(events) == 0 {
+			// Nothing to do, read-only commit.
+			return p, d.commitTx()
+		}
+
+		if _, ok := d.tx.tables[OUTBOX_OFFSETS_TABLE]; !ok {
+			err = d.createTable(OUTBOX_OFFSETS_TABLE, outboxOffsetsColumns, withColumnTypes(map[string]string{
+				"table":  COLUMN_STRING,
+				"offset": COLUMN_STRING,
+			}))
+			if err != nil {
+				d.abortTx()
+				return p, err
+			}
+		}
+
+		for _, event := range events {
+			if event.Offset <= last {
+				d.abortTx()
+				return p, fmt.Errorf("%w: outbox offsets must increase: %d after %d", errBadRequest, event.Offset, last)
+			}
+			last = event.Offset
+
+			err = d.writeRow(table, event.Row)
+			if err != nil {
+				d.abortTx()
+				return p, err
+			}
+		}
+
+		_, _, err = d.merge(OUTBOX_OFFSETS_TABLE, []string{"table"}, [][]any{{table, d.outboxOffsetValue(last)}})
+		if err != nil {
+			d.abortTx()
+			return p, err
+		}
+
+		err = d.commitTx()
+		if err != nil {
+			return p, err
+		}
+
+		p.LastOffset = last
+		p.Events += len(events)
+		p.Batches++
+		d.debug("outbox", "relayed events", "table", table, "events", len(events), "offset", last)
+		if progress != nil {
+			progress(p)
+		}
+	}
+}
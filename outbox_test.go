@@ -0,0 +1,169 @@
+package otf
+
+import (
+	"errors"
+	"testing"
+)
+
+type sliceOutbox struct {
+	events []outboxEvent
+}
+
+func (so *sliceOutbox) readAfter(after int64, limit int) ([]outboxEvent, error) {
+	var events []outboxEvent
+	for _, e := range so.events {
+		if e.Offset > after && len(events) < limit {
+			events = append(events, e)
+		}
+	}
+
+	return events, nil
+}
+
+func countRows(c *client, table string) int {
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	it, err := c.scan(table)
+	assertEq(err, nil, "could not scan")
+
+	seen := 0
+	for {
+		row, err := it.next()
+		assertEq(err, nil, "could not iterate scan")
+		if row == nil {
+			break
+		}
+
+		seen++
+	}
+
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit read tx")
+	return seen
+}
+
+func TestOutboxRelayIsIdempotent(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c1 := newClient(mos)
+	c2 := newClient(mos)
+
+	err := c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c1.createTable("events", []string{"kind", "id"})
+	assertEq(err, nil, "could not create events")
+	err = c1.commitTx()
+	assertEq(err, nil, "could not commit tx")
+
+	src := &sliceOutbox{}
+	for i := 0; i < 5; i++ {
+		src.events = append(src.events, outboxEvent{Offset: int64(i * 10), Row: []any{"created", i}})
+	}
+
+	batches := 0
+	p, err := c1.relayOutbox(src, "events", 2, func(outboxProgress) { batches++ })
+	assertEq(err, nil, "could not relay")
+	assertEq(p.Events, 5, "relayed events")
+	assertEq(p.Batches, 3, "relayed batches")
+	assertEq(batches, 3, "progress callbacks")
+	assertEq(p.LastOffset, int64(40), "last offset")
+
+	// A second relay starting from scratch must not duplicate anything.
+	src.events = append(src.events, outboxEvent{Offset: 50, Row: []any{"created", 5}})
+	p, err = c2.relayOutbox(src, "events", 2, nil)
+	assertEq(err, nil, "could not relay")
+	assertEq(p.Events, 1, "relayed events on second relay")
+	assertEq(p.LastOffset, int64(50), "last offset on second relay")
+
+	assertEq(countRows(&c1, "events"), 6, "rows in events")
+}
+
+func TestOutboxLargeOffsets(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("events", []string{"id"})
+	assertEq(err, nil, "could not create events")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit tx")
+
+	// Past 2^53 consecutive offsets round to the same float64.
+	src := &sliceOutbox{}
+	for i := 0; i < 5; i++ {
+		src.events = append(src.events, outboxEvent{Offset: 1<<60 + int64(i), Row: []any{i}})
+	}
+
+	p, err := c.relayOutbox(src, "events", 2, nil)
+	assertEq(err, nil, "could not relay")
+	assertEq(p.Events, 5, "relayed events")
+	assertEq(p.LastOffset, int64(1<<60+4), "last offset")
+
+	p, err = c.relayOutbox(src, "events", 2, nil)
+	assertEq(err, nil, "could not relay")
+	assertEq(p.Events, 0, "relayed events again")
+	assertEq(countRows(&c, "events"), 5, "rows in events")
+	// One offset per table rather than per batch.
+	assertEq(countRows(&c, OUTBOX_OFFSETS_TABLE), 1, "rows in offsets")
+}
+
+func TestOutboxIntegerOffsets(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("events", []string{"id"})
+	assertEq(err, nil, "could not create events")
+	// As relays used to record offsets.
+	err = c.createTable(OUTBOX_OFFSETS_TABLE, outboxOffsetsColumns, withColumnTypes(map[string]string{
+		"table":  COLUMN_STRING,
+		"offset": COLUMN_INT,
+	}))
+	assertEq(err, nil, "could not create offsets")
+	for _, offset := range []int{10, 20} {
+		err = c.writeRow(OUTBOX_OFFSETS_TABLE, []any{"events", offset})
+		assertEq(err, nil, "could not write offset")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit tx")
+
+	src := &sliceOutbox{}
+	for i := 0; i <= 3; i++ {
+		src.events = append(src.events, outboxEvent{Offset: int64(i * 10), Row: []any{i}})
+	}
+
+	p, err := c.relayOutbox(src, "events", 2, nil)
+	assertEq(err, nil, "could not relay")
+	assertEq(p.Events, 1, "relayed events")
+	assertEq(p.LastOffset, int64(30), "last offset")
+	// Both old rows were merged over rather than a third added.
+	assertEq(countRows(&c, OUTBOX_OFFSETS_TABLE), 2, "rows in offsets")
+}
+
+func TestOutboxRelayRejectsBadInput(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("events", []string{"kind", "id"})
+	assertEq(err, nil, "could not create events")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit tx")
+
+	src := &sliceOutbox{events: []outboxEvent{
+		{Offset: 10, Row: []any{"created", 1}},
+		{Offset: 20, Row: []any{"created", 2}},
+	}}
+	_, err = c.relayOutbox(src, "events", 0, nil)
+	assert(errors.Is(err, errBadRequest), "expected bad batch size")
+
+	// Out of order within a batch, so none of it is committed.
+	src.events = append(src.events, outboxEvent{Offset: 15, Row: []any{"created", 3}})
+	_, err = c.relayOutbox(src, "events", 3, nil)
+	assert(errors.Is(err, errBadRequest), "expected offsets out of order")
+	assertEq(c.tx, (*transaction)(nil), "left a transaction open")
+	assertEq(countRows(&c, "events"), 0, "rows in events")
+
+	src.events = src.events[:2]
+	p, err := c.relayOutbox(src, "events", 3, nil)
+	assertEq(err, nil, "could not relay")
+	assertEq(p.Events, 2, "relayed events")
+}
@@ -0,0 +1,369 @@
+package otf
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Compacting a very large table in one transaction (see compact.go)
+// reads and rewrites every small dataobject before anything commits,
+// and a conflict or a crash along the way loses all of it. A parallel
+// compaction instead splits the work into jobs, each rewriting up to
+// COMPACT_JOB_DATAOBJECTS dataobjects of one partition, and commits
+// each job in its own transaction:
+//
+//   - the first call for a run plans its jobs and records them in a
+//     control table, so later calls for the same run, in this
+//     process or another, work through the same jobs;
+//   - jobs run parallelism at a time, each recording that it's done
+//     in the transaction committing its rewrites, so a run restarted
+//     after a crash or deploy skips the jobs already done, and no
+//     job is committed twice even if two processes race on it;
+//   - jobs validate reads (see readset.go), so they don't conflict
+//     with each other or with writes leaving their dataobjects
+//     alone. A job that does conflict, with a write to one of its
+//     dataobjects or another process doing the same job, starts over
+//     up to COMPACT_JOB_RETRIES times, leaving out dataobjects no
+//     longer live by then.
+//
+// The control table is partitioned by run and job so that jobs
+// recording they're done don't conflict either.
+
+const (
+	COMPACT_JOBS_TABLE      = "_compact_jobs"
+	COMPACT_JOB_DATAOBJECTS = 64
+	COMPACT_JOB_RETRIES     = 3
+)
+
+var compactJobColumns = []string{"run", "job", "table", "dataobjects", "done"}
+
+type compactJob struct {
+	Id          int
+	Table       string
+	Dataobjects []string
+}
+
+type parallelCompactResult struct {
+	Run  string
+	Jobs int
+	// Jobs already done when the call started, or by another
+	// process since, and jobs the call did.
+	Skipped int
+	Done    int
+	// Of the jobs the call did, as in compactResult.
+	Removed int
+	Added   int
+	Rows    int
+}
+
+// The jobs of run matching p, if not nil, as of the transaction,
+// and which of them are done.
+func (d *client) compactJobs(run string, p *predicate) ([]compactJob, map[int]bool, error) {
+	if _, ok := d.tx.tables[COMPACT_JOBS_TABLE]; !ok {
+		return nil, nil, nil
+	}
+
+	filter := where("run", OP_EQ, run)
+	if p != nil {
+		filter = and(filter, p)
+	}
+	it, err := d.scan(COMPACT_JOBS_TABLE, withFilter(filter))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var jobs []compactJob
+	done := map[int]bool{}
+	for {
+		row, err := it.next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if row == nil {
+			break
+		}
+
+		id, ok := toInt64(row[1])
+		assert(ok, fmt.Sprintf("invalid compaction job: %v", row[1]))
+		if row[4] == true {
+			done[int(id)] = true
+			continue
+		}
+
+		job := compactJob{Id: int(id), Table: row[2].(string)}
+		err = json.Unmarshal([]byte(row[3].(string)), &job.Dataobjects)
+		if err != nil {
+			return nil, nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	slices.SortFunc(jobs, func(a, b compactJob) int {
+		return a.Id - b.Id
+	})
+	return jobs, done, nil
+}
+
+// Splits compacting table as of the transaction into jobs, see
+// above.
+func (d *client) planCompactJobs(table string) ([]compactJob, error) {
+	// By transforms, as compaction only merges dataobjects with the
+	// same ones, and partition.
+	buckets := map[string][]compactInput{}
+	for key, inputs := range d.compactGroups(table, nil) {
+		for _, in := range inputs {
+			partition, err := json.Marshal(in.action.Partition)
+			if err != nil {
+				return nil, err
+			}
+
+			bucket := key + "/" + string(partition)
+			buckets[bucket] = append(buckets[bucket], in)
+		}
+	}
+
+	var jobs []compactJob
+	for _, bucket := range sortedKeys(buckets) {
+		inputs := buckets[bucket]
+		for from := 0; from < len(inputs); from += COMPACT_JOB_DATAOBJECTS {
+			chunk := inputs[from:min(from+COMPACT_JOB_DATAOBJECTS, len(inputs))]
+			if !chunk[0].rewritten(len(chunk)) {
+				continue
+			}
+
+			job := compactJob{Id: len(jobs), Table: table}
+			for _, in := range chunk {
+				job.Dataobjects = append(job.Dataobjects, in.action.Name)
+			}
+			jobs = append(jobs, job)
+		}
+	}
+
+	return jobs, nil
+}
+
+// The jobs of run, planning and recording them if it has none yet,
+// and which of them are done.
+func (d *client) planCompactRun(table, run string) ([]compactJob, map[int]bool, error) {
+	for attempt := 0; ; attempt++ {
+		err := d.newTx()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		jobs, done, err := d.compactJobs(run, nil)
+		if err != nil {
+			d.abortTx()
+			return nil, nil, err
+		}
+		if len(jobs) > 0 {
+			if jobs[0].Table != table {
+				d.abortTx()
+				return nil, nil, fmt.Errorf("%w: compaction run %s is of %s", errBadRequest, run, jobs[0].Table)
+			}
+
+			return jobs, done, d.commitTx()
+		}
+
+		if _, ok := d.tx.tables[table]; !ok {
+			d.abortTx()
+			return nil, nil, errNoTable
+		}
+
+		jobs, err = d.planCompactJobs(table)
+		if err != nil || len(jobs) == 0 {
+			d.abortTx()
+			return nil, nil, err
+		}
+
+		err = d.recordCompactJobs(run, jobs)
+		if err != nil {
+			d.abortTx()
+			return nil, nil, err
+		}
+
+		err = d.commitTx()
+		if errors.Is(err, errConflict) && attempt < COMPACT_JOB_RETRIES {
+			// Maybe planned concurrently, in which case the
+			// next attempt reads that plan.
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		d.debug("compact", "planned jobs", "table", table, "run", run, "jobs", len(jobs))
+		return jobs, map[int]bool{}, nil
+	}
+}
+
+func (d *client) recordCompactJobs(run string, jobs []compactJob) error {
+	if _, ok := d.tx.tables[COMPACT_JOBS_TABLE]; !ok {
+		err := d.createTable(COMPACT_JOBS_TABLE, compactJobColumns, withPartitionColumns("run", "job"), withColumnTypes(map[string]string{
+			"run":         COLUMN_STRING,
+			"job":         COLUMN_INT,
+			"table":       COLUMN_STRING,
+			"dataobjects": COLUMN_STRING,
+			"done":        COLUMN_BOOL,
+		}))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, job := range jobs {
+		names, err := json.Marshal(job.Dataobjects)
+		if err != nil {
+			return err
+		}
+
+		err = d.writeRow(COMPACT_JOBS_TABLE, []any{run, job.Id, job.Table, string(names), false})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Runs job of run in a transaction of its own, unless it's done,
+// reporting whether it was.
+func (d *client) runCompactJob(ctx context.Context, run string, job compactJob) (*compactResult, bool, error) {
+	only := map[string]bool{}
+	for _, name := range job.Dataobjects {
+		only[name] = true
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := d.newTxContext(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+
+		_, done, err := d.compactJobs(run, where("job", OP_EQ, job.Id))
+		if err != nil {
+			d.abortTx()
+			return nil, false, err
+		}
+		if done[job.Id] {
+			return nil, true, d.commitTx()
+		}
+
+		result, err := d.compactDataobjects(ctx, job.Table, only)
+		if err != nil {
+			// Including stopping part way, which leaves the
+			// whole job for the next run.
+			d.abortTx()
+			return nil, false, err
+		}
+
+		err = d.writeRow(COMPACT_JOBS_TABLE, []any{run, job.Id, job.Table, "", true})
+		if err != nil {
+			d.abortTx()
+			return nil, false, err
+		}
+
+		err = d.commitTx()
+		if errors.Is(err, errConflict) && attempt < COMPACT_JOB_RETRIES {
+			d.debug("compact", "retrying job", "run", run, "job", job.Id, "err", err)
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		d.debug("compact", "committed job", "run", run, "job", job.Id, "removed", len(result.Removed), "added", len(result.Added))
+		return result, false, nil
+	}
+}
+
+// Compacts table in jobs, see above, parallelism at a time, calling
+// progress, if not nil, after each job committed. Stops starting
+// jobs once ctx is done or a job fails, failing with errIncomplete
+// in the first case.
+func (d *client) compactParallel(ctx context.Context, table, run string, parallelism int, progress func(parallelCompactResult)) (parallelCompactResult, error) {
+	assert(parallelism > 0, "parallelism must be positive")
+
+	result := parallelCompactResult{Run: run}
+	jobs, done, err := d.planCompactRun(table, run)
+	if err != nil {
+		return result, err
+	}
+
+	result.Jobs = len(jobs)
+	pending := make(chan compactJob)
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Each job is its own transaction, so each worker
+			// its own client.
+			worker := *d
+			worker.tx = nil
+			worker.ctx = nil
+			worker.readValidation = true
+			for job := range pending {
+				r, skipped, err := worker.runCompactJob(ctx, run, job)
+
+				mu.Lock()
+				switch {
+				case err != nil:
+					errs = append(errs, fmt.Errorf("job %d: %w", job.Id, err))
+				case skipped:
+					result.Skipped++
+				default:
+					result.Done++
+					result.Removed += len(r.Removed)
+					result.Added += len(r.Added)
+					result.Rows += r.Rows
+					if progress != nil {
+						progress(result)
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	left := 0
+	for _, job := range jobs {
+		if done[job.Id] {
+			mu.Lock()
+			result.Skipped++
+			mu.Unlock()
+			continue
+		}
+
+		mu.Lock()
+		failed := len(errs) > 0
+		mu.Unlock()
+		if failed || ctx.Err() != nil {
+			left++
+			continue
+		}
+
+		select {
+		case pending <- job:
+		case <-ctx.Done():
+			left++
+		}
+	}
+	close(pending)
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return result, errors.Join(errs...)
+	}
+	if left > 0 {
+		return result, incomplete(ctx, left, "jobs")
+	}
+	return result, nil
+}
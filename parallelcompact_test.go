@@ -0,0 +1,132 @@
+package otf
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// Writes rows of x, partitioned by p, one transaction each so each
+// is a dataobject of its own.
+func writeSmallDataobjects(c *client, partitions []string, perPartition int) {
+	for i := 0; i < perPartition; i++ {
+		for _, p := range partitions {
+			err := c.newTx()
+			assertEq(err, nil, "could not start tx")
+			err = c.writeRow("x", []any{i, p})
+			assertEq(err, nil, "could not write")
+			err = c.commitTx()
+			assertEq(err, nil, "could not commit")
+		}
+	}
+}
+
+func TestCompactParallel(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "p"}, withPartitionColumns("p"))
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	writeSmallDataobjects(&c, []string{"p", "q", "r"}, 5)
+
+	var reported []parallelCompactResult
+	result, err := c.compactParallel(context.Background(), "x", "r1", 2, func(r parallelCompactResult) {
+		reported = append(reported, r)
+	})
+	assertEq(err, nil, "could not compact")
+	assertEq(result.Jobs, 3, "jobs")
+	assertEq(result.Done, 3, "done")
+	assertEq(result.Removed, 15, "removed")
+	assertEq(result.Added, 3, "added")
+	assertEq(result.Rows, 15, "rows")
+	assertEq(len(reported), 3, "progress calls")
+	assertEq(reported[2].Done, 3, "last progress")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(scanAll(&c, "x")), 15, "rows after compaction")
+	assertEq(len(liveActions(c.tx.previousActions["x"])), 3, "dataobjects after compaction")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// The same run again finds every job done.
+	result, err = c.compactParallel(context.Background(), "x", "r1", 2, nil)
+	assertEq(err, nil, "could not compact")
+	assertEq(result.Skipped, 3, "skipped")
+	assertEq(result.Done, 0, "done again")
+
+	_, err = c.compactParallel(context.Background(), "y", "r1", 2, nil)
+	assert(errors.Is(err, errBadRequest), "expected run of another table")
+
+	// A run that stopped after its first job picks up after it.
+	writeSmallDataobjects(&c, []string{"p", "q"}, 3)
+	jobs, _, err := c.planCompactRun("x", "r2")
+	assertEq(err, nil, "could not plan")
+	assertEq(len(jobs), 2, "planned jobs")
+	worker := c
+	worker.readValidation = true
+	_, skipped, err := worker.runCompactJob(context.Background(), "r2", jobs[0])
+	assertEq(err, nil, "could not run job")
+	assert(!skipped, "first job skipped")
+
+	result, err = c.compactParallel(context.Background(), "x", "r2", 4, nil)
+	assertEq(err, nil, "could not compact")
+	assertEq(result.Skipped, 1, "skipped after restart")
+	assertEq(result.Done, 1, "done after restart")
+
+	// Stopped before starting any.
+	writeSmallDataobjects(&c, []string{"p"}, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, err = c.compactParallel(ctx, "x", "r3", 2, nil)
+	assert(errors.Is(err, errIncomplete), "expected incomplete")
+	assertEq(result.Jobs, 1, "jobs when stopped")
+	assertEq(result.Done, 0, "done when stopped")
+}
+
+func TestCompactionsOfDifferentDataobjects(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "p"}, withPartitionColumns("p"))
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	writeSmallDataobjects(&c, []string{"p", "q"}, 2)
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	byPartition := map[string]map[string]bool{}
+	for _, action := range c.tx.previousActions["x"] {
+		p := action.AddDataobject.Partition["p"].(string)
+		if byPartition[p] == nil {
+			byPartition[p] = map[string]bool{}
+		}
+		byPartition[p][action.AddDataobject.Name] = true
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	var clients []*client
+	for _, p := range []string{"p", "q", "p"} {
+		d := newClient(mos, withReadValidation())
+		err = d.newTx()
+		assertEq(err, nil, "could not start tx")
+		_, err = d.compactDataobjects(context.Background(), "x", byPartition[p])
+		assertEq(err, nil, "could not compact")
+		clients = append(clients, &d)
+	}
+
+	// An append elsewhere doesn't conflict with them either.
+	writeSmallDataobjects(&c, []string{"r"}, 1)
+
+	err = clients[0].commitTx()
+	assertEq(err, nil, "could not commit p")
+	err = clients[1].commitTx()
+	assertEq(err, nil, "could not commit q")
+	err = clients[2].commitTx()
+	assert(errors.Is(err, errConflict), "expected compactions of the same dataobjects to conflict")
+}
@@ -0,0 +1,489 @@
+package otf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Materializes a table's snapshot as a directory of standard Parquet
+// files so it can be read by tools that know nothing about otf,
+// e.g. pandas.read_parquet(dir) or polars.read_parquet(dir + "/*.parquet").
+// The canonical table stays in otf; the directory is a disposable
+// copy.
+//
+// https://parquet.apache.org/docs/file-format/
+//
+// Each file holds one row group with one zstd-compressed, PLAIN
+// encoded data page per column. All columns are optional. Column
+// types are inferred across the whole snapshot the same way as for
+// Arrow streams.
+
+const SNAPSHOT_MANIFEST = "_manifest.json"
+
+const SNAPSHOT_FORMAT = "otf-parquet"
+
+// Rows per Parquet file.
+const PARQUET_FILE_ROWS = 8 * DATAOBJECT_SIZE
+
+type materializedColumn struct {
+	Name string
+	// One of int64, double, boolean or string.
+	Type string
+}
+
+type materializedFile struct {
+	Name string
+	Rows int
+}
+
+type snapshotManifest struct {
+	Format         string
+	Version        int
+	Table          string
+	Snapshot       int
+	MaterializedAt time.Time
+	Columns        []materializedColumn
+	Files          []materializedFile
+}
+
+// Thrift compact protocol, just enough of it for Parquet metadata.
+//
+// https://github.com/apache/thrift/blob/master/doc/specs/thrift-compact-protocol.md
+
+type thriftField struct {
+	Id    int16
+	Value any
+}
+
+// Values are bool, int32, int64, string, thriftStruct or thriftList.
+type thriftStruct []thriftField
+
+type thriftList []any
+
+const (
+	thriftTypeTrue   = 1
+	thriftTypeFalse  = 2
+	thriftTypeI32    = 5
+	thriftTypeI64    = 6
+	thriftTypeBinary = 8
+	thriftTypeList   = 9
+	thriftTypeStruct = 12
+)
+
+func thriftType(v any) byte {
+	switch n := v.(type) {
+	case bool:
+		if n {
+			return thriftTypeTrue
+		}
+		return thriftTypeFalse
+	case int32:
+		return thriftTypeI32
+	case int64:
+		return thriftTypeI64
+	case string:
+		return thriftTypeBinary
+	case thriftList:
+		return thriftTypeList
+	case thriftStruct:
+		return thriftTypeStruct
+	}
+
+	panic(fmt.Sprintf("unsupported thrift value: %T", v))
+}
+
+func thriftZigzag(v int64) uint64 {
+	return uint64((v << 1) ^ (v >> 63))
+}
+
+func (s thriftStruct) encode(buf []byte) []byte {
+	var last int16
+	for _, f := range s {
+		t := thriftType(f.Value)
+		if delta := f.Id - last; delta > 0 && delta <= 15 {
+			buf = append(buf, byte(delta)<<4|t)
+		} else {
+			buf = append(buf, t)
+			buf = binary.AppendUvarint(buf, thriftZigzag(int64(f.Id)))
+		}
+		last = f.Id
+
+		buf = thriftEncodeValue(buf, f.Value)
+	}
+
+	// Stop.
+	return append(buf, 0)
+}
+
+func thriftEncodeValue(buf []byte, v any) []byte {
+	switch n := v.(type) {
+	case bool:
+		// Struct fields hold booleans in their type. Lists of
+		// booleans aren't needed.
+		return buf
+	case int32:
+		return binary.AppendUvarint(buf, thriftZigzag(int64(n)))
+	case int64:
+		return binary.AppendUvarint(buf, thriftZigzag(n))
+	case string:
+		buf = binary.AppendUvarint(buf, uint64(len(n)))
+		return append(buf, n...)
+	case thriftStruct:
+		return n.encode(buf)
+	case thriftList:
+		var t byte = thriftTypeI32
+		if len(n) > 0 {
+			t = thriftType(n[0])
+		}
+		if len(n) < 15 {
+			buf = append(buf, byte(len(n))<<4|t)
+		} else {
+			buf = append(buf, 0xF0|t)
+			buf = binary.AppendUvarint(buf, uint64(len(n)))
+		}
+		for _, item := range n {
+			buf = thriftEncodeValue(buf, item)
+		}
+		return buf
+	}
+
+	panic(fmt.Sprintf("unsupported thrift value: %T", v))
+}
+
+// From parquet.thrift.
+const (
+	parquetBoolean   int32 = 0
+	parquetInt64     int32 = 2
+	parquetDouble    int32 = 5
+	parquetByteArray int32 = 6
+
+	parquetOptional int32 = 1
+
+	parquetConvertedUtf8 int32 = 0
+
+	parquetEncodingPlain int32 = 0
+	parquetEncodingRle   int32 = 3
+
+	parquetCodecZstd int32 = 6
+
+	parquetDataPage int32 = 0
+)
+
+func parquetPhysicalType(t arrowType) int32 {
+	switch t {
+	case arrowInt64:
+		return parquetInt64
+	case arrowFloat64:
+		return parquetDouble
+	case arrowBool:
+		return parquetBoolean
+	}
+
+	return parquetByteArray
+}
+
+func parquetTypeName(t arrowType) string {
+	switch t {
+	case arrowInt64:
+		return "int64"
+	case arrowFloat64:
+		return "double"
+	case arrowBool:
+		return "boolean"
+	}
+
+	return "string"
+}
+
+func parquetSchema(fields []arrowField) thriftList {
+	schema := thriftList{thriftStruct{
+		{4, "schema"},
+		{5, int32(len(fields))},
+	}}
+
+	for _, f := range fields {
+		element := thriftStruct{
+			{1, parquetPhysicalType(f.Type)},
+			{3, parquetOptional},
+			{4, f.Name},
+		}
+		if f.Type == arrowUtf8 {
+			element = append(element,
+				thriftField{6, parquetConvertedUtf8},
+				// LogicalType union, STRING.
+				thriftField{10, thriftStruct{{1, thriftStruct{}}}})
+		}
+
+		schema = append(schema, element)
+	}
+
+	return schema
+}
+
+// Encodes one column of the given batches as the body of a v1 data
+// page: definition levels followed by the PLAIN encoded non-null
+// values.
+func parquetPageBody(field arrowField, column int, batches []*batch) []byte {
+	var defined []bool
+	var values []byte
+	var bools []bool
+	for _, b := range batches {
+		for _, v := range b.Columns[column] {
+			value, ok := arrowValue(field.Type, v)
+			defined = append(defined, ok)
+			if !ok {
+				continue
+			}
+
+			switch field.Type {
+			case arrowInt64:
+				values = binary.LittleEndian.AppendUint64(values, uint64(value.(int64)))
+			case arrowFloat64:
+				values = binary.LittleEndian.AppendUint64(values, math.Float64bits(value.(float64)))
+			case arrowBool:
+				bools = append(bools, value.(bool))
+			case arrowUtf8:
+				values = binary.LittleEndian.AppendUint32(values, uint32(len(value.(string))))
+				values = append(values, value.(string)...)
+			}
+		}
+	}
+
+	if field.Type == arrowBool {
+		values = parquetBitPack(bools)
+	}
+
+	// Definition levels are a single bit-packed run of the
+	// RLE/bit-packing hybrid, prefixed by its length.
+	levels := binary.AppendUvarint(nil, uint64((len(defined)+7)/8)<<1|1)
+	levels = append(levels, parquetBitPack(defined)...)
+
+	body := binary.LittleEndian.AppendUint32(nil, uint32(len(levels)))
+	body = append(body, levels...)
+	return append(body, values...)
+}
+
+// LSB first, padded to a whole byte.
+func parquetBitPack(bits []bool) []byte {
+	packed := make([]byte, (len(bits)+7)/8)
+	for i, bit := range bits {
+		if bit {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+
+	return packed
+}
+
+func writeParquetFile(path string, fields []arrowField, batches []*batch) error {
+	bytes, err := encodeParquetFile(fields, batches)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, bytes, 0644)
+}
+
+func encodeParquetFile(fields []arrowField, batches []*batch) ([]byte, error) {
+	rows := 0
+	for _, b := range batches {
+		rows += b.Len
+	}
+
+	buf := bytes.NewBufferString("PAR1")
+	var rowGroups thriftList
+	var columns thriftList
+	var totalSize int64
+	for i, field := range fields {
+		if rows == 0 {
+			break
+		}
+
+		body := parquetPageBody(field, i, batches)
+		compressed, err := compressBytes(CODEC_ZSTD, body)
+		if err != nil {
+			return nil, err
+		}
+
+		header := thriftStruct{
+			{1, parquetDataPage},
+			{2, int32(len(body))},
+			{3, int32(len(compressed))},
+			{5, thriftStruct{
+				{1, int32(rows)},
+				{2, parquetEncodingPlain},
+				{3, parquetEncodingRle},
+				{4, parquetEncodingRle},
+			}},
+		}.encode(nil)
+
+		offset := int64(buf.Len())
+		buf.Write(header)
+		buf.Write(compressed)
+
+		uncompressedSize := int64(len(header) + len(body))
+		compressedSize := int64(len(header) + len(compressed))
+		totalSize += uncompressedSize
+		columns = append(columns, thriftStruct{
+			{2, offset},
+			{3, thriftStruct{
+				{1, parquetPhysicalType(field.Type)},
+				{2, thriftList{parquetEncodingPlain, parquetEncodingRle}},
+				{3, thriftList{field.Name}},
+				{4, parquetCodecZstd},
+				{5, int64(rows)},
+				{6, uncompressedSize},
+				{7, compressedSize},
+				{9, offset},
+			}},
+		})
+	}
+
+	if rows > 0 {
+		rowGroups = thriftList{thriftStruct{
+			{1, columns},
+			{2, totalSize},
+			{3, int64(rows)},
+		}}
+	}
+
+	footer := thriftStruct{
+		{1, int32(1)},
+		{2, parquetSchema(fields)},
+		{3, int64(rows)},
+		{4, rowGroups},
+		{6, "otf"},
+	}.encode(nil)
+
+	buf.Write(footer)
+	buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer))))
+	buf.WriteString("PAR1")
+
+	return buf.Bytes(), nil
+}
+
+// Writes the snapshot of table visible to a new transaction into
+// dir, which must be empty or not exist yet. The manifest is written
+// last so its presence means the directory is complete. Uses and
+// then ends its own transaction.
+func (d *client) materializeSnapshot(table, dir string) (*snapshotManifest, error) {
+	err := d.newTx()
+	if err != nil {
+		return nil, err
+	}
+	// Nothing gets written to d.
+	defer func() { d.tx = nil }()
+
+	columns, ok := d.tx.tables[table]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errNoTable, table)
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		return nil, fmt.Errorf("cannot materialize into non-empty directory: %s", dir)
+	}
+
+	// First pass to settle on column types, since every file must
+	// have the same schema.
+	types := make([]arrowType, len(columns))
+	seen := make([]bool, len(columns))
+	it, err := d.scan(table)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		b, err := it.nextBatch(DATAOBJECT_SIZE)
+		if err != nil {
+			return nil, err
+		}
+
+		if b == nil {
+			break
+		}
+
+		for i := range columns {
+			types[i], seen[i] = widenArrowType(types[i], seen[i], b.Columns[i])
+		}
+	}
+
+	manifest := &snapshotManifest{
+		Format:         SNAPSHOT_FORMAT,
+		Version:        1,
+		Table:          table,
+		Snapshot:       d.tx.Id - 1,
+		MaterializedAt: time.Now().UTC(),
+	}
+
+	fields := make([]arrowField, len(columns))
+	for i, name := range columns {
+		if !seen[i] {
+			types[i] = arrowUtf8
+		}
+
+		fields[i] = arrowField{name, types[i]}
+		manifest.Columns = append(manifest.Columns, materializedColumn{name, parquetTypeName(types[i])})
+	}
+
+	it, err = d.scan(table)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var batches []*batch
+		rows := 0
+		for rows < PARQUET_FILE_ROWS {
+			b, err := it.nextBatch(PARQUET_FILE_ROWS - rows)
+			if err != nil {
+				return nil, err
+			}
+
+			if b == nil {
+				break
+			}
+
+			batches = append(batches, b)
+			rows += b.Len
+		}
+
+		// An empty table still gets one (empty) file so readers
+		// see its schema.
+		if rows == 0 && len(manifest.Files) > 0 {
+			break
+		}
+
+		name := fmt.Sprintf("part-%05d.parquet", len(manifest.Files))
+		err = writeParquetFile(filepath.Join(dir, name), fields, batches)
+		if err != nil {
+			return nil, err
+		}
+
+		manifest.Files = append(manifest.Files, materializedFile{name, rows})
+		if rows == 0 {
+			break
+		}
+	}
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	d.debug("parquet", "materialized snapshot", "table", table, "snapshot", manifest.Snapshot, "files", len(manifest.Files))
+	return manifest, os.WriteFile(filepath.Join(dir, SNAPSHOT_MANIFEST), manifestBytes, 0644)
+}
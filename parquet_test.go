@@ -0,0 +1,78 @@
+package otf
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestThriftCompactEncoding(t *testing.T) {
+	encoded := thriftStruct{
+		{1, int32(1)},
+		{2, "ab"},
+		// Too big a jump for a delta.
+		{20, int64(-1)},
+		{21, true},
+		{22, thriftList{int32(3)}},
+	}.encode(nil)
+
+	assert(bytes.Equal(encoded, []byte{
+		0x15, 0x02,
+		0x18, 0x02, 'a', 'b',
+		0x06, 0x28, 0x01,
+		0x11,
+		0x19, 0x15, 0x06,
+		0x00,
+	}), "thrift encoding")
+}
+
+func TestMaterializeSnapshot(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"name", "age", "score", "active"})
+	assertEq(err, nil, "could not create x")
+	err = c.createTable("y", []string{"a"})
+	assertEq(err, nil, "could not create y")
+	for i := 0; i < PARQUET_FILE_ROWS+1; i++ {
+		err = c.writeRow("x", []any{"Joey", i, 1.5, i%2 == 0})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.writeRow("x", []any{nil, nil, 2, nil})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	dir := filepath.Join(t.TempDir(), "x")
+	manifest, err := c.materializeSnapshot("x", dir)
+	assertEq(err, nil, "could not materialize")
+	assertEq(manifest.Snapshot, 0, "snapshot")
+	assertEq(len(manifest.Files), 2, "files")
+	assertEq(manifest.Files[1].Rows, 2, "rows in last file")
+	assertEq(manifest.Columns[1].Type, "int64", "age type")
+	assertEq(manifest.Columns[2].Type, "double", "score type")
+	assertEq(manifest.Columns[3].Type, "boolean", "active type")
+
+	for _, f := range manifest.Files {
+		data, err := os.ReadFile(filepath.Join(dir, f.Name))
+		assertEq(err, nil, "could not read parquet file")
+		assert(bytes.HasPrefix(data, []byte("PAR1")) && bytes.HasSuffix(data, []byte("PAR1")), "parquet magic")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, SNAPSHOT_MANIFEST))
+	assertEq(err, nil, "could not read manifest")
+	var written snapshotManifest
+	err = json.Unmarshal(data, &written)
+	assertEq(err, nil, "could not parse manifest")
+	assertEq(written.Format, SNAPSHOT_FORMAT, "format")
+
+	// Not into a directory that's in use.
+	_, err = c.materializeSnapshot("x", dir)
+	assert(err != nil, "materialized into non-empty directory")
+
+	manifest, err = c.materializeSnapshot("y", filepath.Join(t.TempDir(), "y"))
+	assertEq(err, nil, "could not materialize empty table")
+	assertEq(len(manifest.Files), 1, "files for empty table")
+}
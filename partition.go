@@ -0,0 +1,142 @@
+package otf
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Partitioned tables keep rows with different values of their
+// partition columns in separate dataobjects. Each AddDataobject
+// action records the values its rows share, so filtered scans can
+// skip whole partitions, and dataobject names start with Hive-style
+// col=value segments so a partition's dataobjects are also grouped
+// together in storage:
+//
+//	_table_events_day=2024-01-01,region=eu_<name>
+//
+// Partitioning only decides where rows go. Rows are still written
+// and scanned the same way as for any other table. Every row must
+// have a value in each partition column and that value must be a
+// string, number, boolean or timestamp, since it becomes part of a
+// name.
+
+var errInvalidPartitionValue = fmt.Errorf("Invalid Partition Value")
+
+func withPartitionColumns(columns ...string) tableOption {
+	return func(o *tableOptions) {
+		o.partitionColumns = columns
+	}
+}
+
+// Formats a partition's values as a dataobject name prefix.
+// Slashes aren't used as separators since not every objectStorage
+// has directories.
+func partitionPath(columns []string, values map[string]any) string {
+	var segments []string
+	for _, column := range columns {
+		// Nulls are no longer written but may have been
+		// before.
+		value := "__NULL__"
+		if t, ok := values[column].(time.Time); ok {
+			value = url.QueryEscape(t.Format(time.RFC3339Nano))
+		} else if v := values[column]; v != nil {
+			value = url.QueryEscape(fmt.Sprint(v))
+		}
+
+		segments = append(segments, url.QueryEscape(column)+"="+value)
+	}
+
+	return strings.Join(segments, ",") + "_"
+}
+
+// Checks row has a usable value in each of partitionColumns.
+func checkPartitionValues(table string, columns, partitionColumns []string, row []any) error {
+	for _, column := range partitionColumns {
+		v := row[slices.Index(columns, column)]
+		switch v.(type) {
+		case nil:
+			return fmt.Errorf("%w: %s.%s is null", errInvalidPartitionValue, table, column)
+		case string, bool, time.Time,
+			int, int8, int16, int32, int64,
+			uint, uint8, uint16, uint32, uint64,
+			float32, float64:
+		default:
+			return fmt.Errorf("%w: %s.%s can't be %T", errInvalidPartitionValue, table, column, v)
+		}
+	}
+
+	return nil
+}
+
+type partitionedBatch struct {
+	values map[string]any
+	rows   *batch
+}
+
+// Splits b by the values of its partition columns, in order of
+// first appearance.
+func partitionBatch(columns, partitionColumns []string, b *batch) []partitionedBatch {
+	var positions []int
+	for _, column := range partitionColumns {
+		for i, c := range columns {
+			if c == column {
+				positions = append(positions, i)
+			}
+		}
+	}
+
+	var partitions []partitionedBatch
+	byKey := map[string]int{}
+	for i := 0; i < b.Len; i++ {
+		key := make([]any, len(positions))
+		for j, position := range positions {
+			key[j] = b.Columns[position][i]
+		}
+
+		// Values are JSON anyway. Normalizes 1 and 1.0 too.
+		keyBytes, err := json.Marshal(key)
+		assert(err == nil, fmt.Sprintf("could not marshal partition: %s", err))
+
+		p, ok := byKey[string(keyBytes)]
+		if !ok {
+			values := map[string]any{}
+			for j, column := range partitionColumns {
+				values[column] = key[j]
+			}
+
+			p = len(partitions)
+			byKey[string(keyBytes)] = p
+			partitions = append(partitions, partitionedBatch{values, newBatch(len(columns))})
+		}
+
+		partitions[p].rows.appendRow(b.row(i))
+	}
+
+	return partitions
+}
+
+// A partition's values as statistics, so filters are checked against
+// them the same way as against min/max statistics.
+func partitionStats(action *DataobjectAction) map[string]columnStats {
+	if action.Partition == nil {
+		return nil
+	}
+
+	stats := map[string]columnStats{}
+	for column, v := range action.Partition {
+		var s columnStats
+		if v == nil {
+			s.Nulls = action.Rows
+		} else if _, ok := compareValues(v, v); ok {
+			s.Min, s.Max = v, v
+		}
+
+		stats[column] = s
+	}
+
+	return stats
+}
@@ -0,0 +1,70 @@
+package otf
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPartitionedTable(t *testing.T) {
+	considered := 0
+	c := newClient(newMemoryObjectStorage(), withDataobjectFilter(func(string, *DataobjectAction, *predicate) bool {
+		considered++
+		return true
+	}))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+
+	err = c.createTable("bad", []string{"a"}, withPartitionColumns("b"))
+	assert(errors.Is(err, errNoColumn), "unknown partition column")
+
+	err = c.createTable("events", []string{"day", "region", "value"}, withPartitionColumns("day", "region"))
+	assertEq(err, nil, "could not create events")
+	for i := 0; i < 30; i++ {
+		region := "eu"
+		if i%2 == 0 {
+			region = "us/east"
+		}
+
+		err = c.writeRow("events", []any{i % 3, region, i})
+		assertEq(err, nil, "could not write row")
+	}
+	// Rows must have a value in each partition column.
+	err = c.writeRow("events", []any{nil, "eu", 30})
+	assert(errors.Is(err, errInvalidPartitionValue), "null partition value")
+	err = c.writeRow("events", []any{[]any{1}, "eu", 30})
+	assert(errors.Is(err, errInvalidPartitionValue), "list partition value")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(c.tx.partitions["events"]), 2, "partition columns")
+	actions := c.tx.previousActions["events"]
+	assertEq(len(actions), 6, "one dataobject per partition")
+	for _, action := range actions {
+		do := action.AddDataobject
+		assert(strings.HasPrefix(do.Name, "day="), "hive-style name: "+do.Name)
+		if do.Partition["region"] == "us/east" && do.Partition["day"] == 0.0 {
+			assert(strings.HasPrefix(do.Name, "day=0,region=us%2Feast_"), "escaped name: "+do.Name)
+			assertEq(do.Rows, 5, "rows in partition")
+		}
+	}
+
+	it, err := c.scan("events", withFilter(and(where("day", OP_EQ, 1), where("region", OP_EQ, "eu"))))
+	assertEq(err, nil, "could not scan")
+	rows := 0
+	for {
+		row, err := it.next()
+		assertEq(err, nil, "could not iterate scan")
+		if row == nil {
+			break
+		}
+
+		assertEq(row[0], 1.0, "day")
+		assertEq(row[1], "eu", "region")
+		rows++
+	}
+	assertEq(rows, 5, "rows in partition")
+	assertEq(considered, 1, "partitions read")
+}
@@ -0,0 +1,338 @@
+package otf
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Predicates filter rows in scan. A predicate is either a comparison
+// of a column against a literal or an AND/OR of other predicates.
+//
+// Comparisons follow SQL in that null never matches, not even
+// `!= x`. Numbers compare numerically regardless of whether they
+// went through JSON, strings compare bytewise, and booleans only
+// support = and !=. Values of different kinds don't match.
+
+const (
+	OP_EQ  = "="
+	OP_NE  = "!="
+	OP_LT  = "<"
+	OP_LTE = "<="
+	OP_GT  = ">"
+	OP_GTE = ">="
+)
+
+type predicate struct {
+	// Compares either a column or the result of a function, see
+	// udf.go.
+	Column string `json:",omitempty"`
+	Call   *call  `json:",omitempty"`
+	Op     string `json:",omitempty"`
+	Value  any    `json:",omitempty"`
+
+	And []*predicate `json:",omitempty"`
+	Or  []*predicate `json:",omitempty"`
+}
+
+func where(column, op string, value any) *predicate {
+	return &predicate{Column: column, Op: op, Value: value}
+}
+
+func and(ps ...*predicate) *predicate {
+	return &predicate{And: ps}
+}
+
+func or(ps ...*predicate) *predicate {
+	return &predicate{Or: ps}
+}
+
+func (p *predicate) String() string {
+	var parts []string
+	switch {
+	case p.And != nil:
+		for _, c := range p.And {
+			parts = append(parts, c.String())
+		}
+		return "(" + strings.Join(parts, " AND ") + ")"
+	case p.Or != nil:
+		for _, c := range p.Or {
+			parts = append(parts, c.String())
+		}
+		return "(" + strings.Join(parts, " OR ") + ")"
+	}
+
+	left := p.Column
+	if p.Call != nil {
+		left = p.Call.String()
+		if p.Op == "" {
+			return left
+		}
+	}
+
+	return fmt.Sprintf("%s %s %s", left, p.Op, formatLiteral(p.Value))
+}
+
+// SQL-ish, e.g. 'O”Brien', NULL or $1::int64.
+func formatLiteral(v any) string {
+	switch n := v.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return "'" + strings.ReplaceAll(n, "'", "''") + "'"
+	case time.Time:
+		return "'" + n.Format(time.RFC3339Nano) + "'"
+	case placeholder:
+		if n.Type == PARAM_ANY {
+			return fmt.Sprintf("$%d", n.Index)
+		}
+		return fmt.Sprintf("$%d::%s", n.Index, n.Type)
+	}
+
+	return fmt.Sprint(v)
+}
+
+func toFloat64(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+
+	return 0, false
+}
+
+// Orders a and b if they are of the same kind. Returns false if they
+// can't be compared.
+func compareValues(a, b any) (int, bool) {
+	// Timestamps come back from JSON as strings.
+	_, aTime := a.(time.Time)
+	_, bTime := b.(time.Time)
+	if aTime || bTime {
+		at, aok := toTime(a)
+		bt, bok := toTime(b)
+		if !aok || !bok {
+			return 0, false
+		}
+		return at.Compare(bt), true
+	}
+
+	if af, ok := toFloat64(a); ok {
+		bf, ok := toFloat64(b)
+		if !ok {
+			return 0, false
+		}
+
+		switch {
+		case af < bf:
+			return -1, true
+		case af > bf:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch av := a.(type) {
+	case string:
+		bv, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(av, bv), true
+	case bool:
+		bv, ok := b.(bool)
+		if !ok || av == bv {
+			return 0, ok
+		}
+		// Only equality is meaningful but order them anyway.
+		if !av {
+			return -1, true
+		}
+		return 1, true
+	}
+
+	return 0, false
+}
+
+// Whether c, the result of comparing a value to a literal, satisfies
+// op.
+func opMatches(op string, c int) bool {
+	switch op {
+	case OP_EQ:
+		return c == 0
+	case OP_NE:
+		return c != 0
+	case OP_LT:
+		return c < 0
+	case OP_LTE:
+		return c <= 0
+	case OP_GT:
+		return c > 0
+	case OP_GTE:
+		return c >= 0
+	}
+
+	panic(fmt.Sprintf("unknown op: %s", op))
+}
+
+// Resolves p's columns and functions against table as of d's
+// transaction, returning a function that tells whether row i of a
+// batch of that table matches. p must not have placeholders, see
+// prepare.go.
+func (p *predicate) bind(d *client, table string) (func(b *batch, i int) bool, error) {
+	if p.hasPlaceholders() {
+		return nil, fmt.Errorf("%w: filter has placeholders", errInvalidParameter)
+	}
+
+	compiled, err := p.compile(d, table)
+	if err != nil {
+		return nil, err
+	}
+
+	return compiled(nil), nil
+}
+
+// Like bind but leaves placeholders to be filled in from args each
+// time the result is called.
+func (p *predicate) compile(d *client, table string) (func(args []any) func(b *batch, i int) bool, error) {
+	err := p.validate(d)
+	if err != nil {
+		return nil, err
+	}
+
+	folded, err := p.expr().fold(d.functions)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := folded.compile(d, table)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(args []any) func(*batch, int) bool {
+		eval := compiled(args)
+		return func(b *batch, i int) bool {
+			return eval(b, i) == true
+		}
+	}, nil
+}
+
+// Checks what expressions don't: that ops exist and make sense for
+// their values.
+func (p *predicate) validate(d *client) error {
+	for _, c := range p.And {
+		if err := c.validate(d); err != nil {
+			return err
+		}
+	}
+	for _, c := range p.Or {
+		if err := c.validate(d); err != nil {
+			return err
+		}
+	}
+	if p.And != nil || p.Or != nil {
+		return nil
+	}
+
+	// A boolean function on its own.
+	if p.Call != nil && p.Op == "" {
+		if f, ok := d.functions[p.Call.Func]; ok && f.ReturnType != PARAM_BOOLEAN {
+			return fmt.Errorf("%s does not return a boolean", p.Call.Func)
+		}
+		return nil
+	}
+
+	if !slices.Contains(comparisonOps, p.Op) {
+		return fmt.Errorf("unknown op: %s", p.Op)
+	}
+
+	_, isBool := p.Value.(bool)
+	if ph, ok := p.Value.(placeholder); ok {
+		isBool = ph.Type == PARAM_BOOLEAN
+	}
+	if isBool && p.Op != OP_EQ && p.Op != OP_NE {
+		return fmt.Errorf("booleans only support %s and %s", OP_EQ, OP_NE)
+	}
+
+	return nil
+}
+
+func (p *predicate) expr() *expr {
+	if p.And != nil || p.Or != nil {
+		op, children := OP_AND, p.And
+		if p.Or != nil {
+			op, children = OP_OR, p.Or
+		}
+
+		var args []*expr
+		for _, c := range children {
+			args = append(args, c.expr())
+		}
+		return opExpr(op, args...)
+	}
+
+	left := colExpr(p.Column)
+	if p.Call != nil {
+		left = p.Call.expr()
+		if p.Op == "" {
+			return left
+		}
+	}
+
+	return opExpr(p.Op, left, litExpr(p.Value))
+}
+
+// The rows of b matching keep, sharing nothing with b's column
+// slices.
+func (b *batch) filter(keep func(b *batch, i int) bool) *batch {
+	f := newBatch(len(b.Columns))
+	for i := 0; i < b.Len; i++ {
+		if !keep(b, i) {
+			continue
+		}
+
+		for j, column := range b.Columns {
+			f.Columns[j] = append(f.Columns[j], column[i])
+		}
+		f.Len++
+	}
+
+	return f
+}
+
+// Hook for skipping whole dataobjects during a filtered scan, on top
+// of the built-in check of their min/max statistics. Must return
+// false only if no row of the dataobject can match p.
+type dataobjectFilter func(table string, action *DataobjectAction, p *predicate) bool
+
+func withDataobjectFilter(f dataobjectFilter) clientOption {
+	return func(c *client) {
+		c.dataobjectFilters = append(c.dataobjectFilters, f)
+	}
+}
+
+func (d *client) mayMatch(table string, action *DataobjectAction, p *predicate) bool {
+	if !statsMayMatch(d.tx.schemas[table].evolveStats(action.SchemaVersion, action.Stats, action.Rows), action.Rows, p) {
+		return false
+	}
+
+	if !statsMayMatch(partitionStats(action), action.Rows, p) {
+		return false
+	}
+
+	if action.BloomFilters != nil && !bloomMayMatch(action.BloomFilters, p) {
+		return false
+	}
+
+	for _, f := range d.dataobjectFilters {
+		if !f(table, action, p) {
+			return false
+		}
+	}
+
+	return true
+}
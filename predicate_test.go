@@ -0,0 +1,85 @@
+package otf
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCompareValues(t *testing.T) {
+	for _, test := range []struct {
+		a, b any
+		c    int
+		ok   bool
+	}{
+		{1, 2.0, -1, true},
+		{int64(3), 3, 0, true},
+		{"b", "a", 1, true},
+		{true, true, 0, true},
+		{nil, 1, 0, false},
+		{"1", 1, 0, false},
+	} {
+		c, ok := compareValues(test.a, test.b)
+		assertEq(ok, test.ok, "comparable")
+		assertEq(c, test.c, "comparison")
+	}
+}
+
+func TestFilteredScan(t *testing.T) {
+	var considered []string
+	c := newClient(newMemoryObjectStorage(), withDataobjectFilter(func(table string, action *DataobjectAction, p *predicate) bool {
+		considered = append(considered, action.Name)
+		// Pretend statistics say the first dataobject can't match.
+		return len(considered) > 1
+	}))
+
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"name", "age"})
+	assertEq(err, nil, "could not create x")
+	for _, row := range [][]any{{"Joey", 1}, {"Yue", 2}, {"Ada", nil}} {
+		err = c.writeRow("x", row)
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	for _, row := range [][]any{{"Joey", 30}, {"Ben", 40}} {
+		err = c.writeRow("x", row)
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	// Unflushed rows are filtered too but never skipped.
+	err = c.writeRow("x", []any{"Joey", 50})
+	assertEq(err, nil, "could not write row")
+
+	it, err := c.scan("x",
+		withFilter(or(where("name", OP_EQ, "Joey"), and(where("age", OP_GT, 1), where("age", OP_NE, 40)))),
+		withColumns("age"))
+	assertEq(err, nil, "could not scan")
+
+	var ages []any
+	for {
+		row, err := it.next()
+		assertEq(err, nil, "could not iterate scan")
+		if row == nil {
+			break
+		}
+		ages = append(ages, row[0])
+	}
+
+	assertEq(len(considered), 2, "dataobjects considered")
+	assertEq(len(ages), 2, "matching rows")
+	assertEq(ages[0], 50, "unflushed row")
+	assertEq(ages[1], 30.0, "flushed row")
+
+	_, err = c.scan("x", withFilter(where("height", OP_EQ, 1)))
+	assert(errors.Is(err, errNoColumn), "unknown column")
+	_, err = c.scan("x", withFilter(where("name", OP_LT, true)))
+	assert(err != nil, "ordering booleans")
+}
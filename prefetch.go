@@ -0,0 +1,79 @@
+package otf
+
+import (
+	"context"
+	"fmt"
+)
+
+// By default a dataobject being read ahead (see decode.go) holds a
+// decoder while it's downloaded as well as while it's decoded, so a
+// scan of remote storage waits on as many downloads at once as the
+// client has decoders. With prefetching, scans read further ahead
+// and download dataobjects without a decoder, up to a number at once
+// across the client's scans, so waiting on storage overlaps with
+// decoding and with the rows being consumed. Downloaded objects are
+// then decoded once a decoder is free.
+//
+// Dataobjects read by row group (see rowgroup.go) are still
+// downloaded range by range while decoding.
+
+// Scans read up to n dataobjects ahead, at least 1, downloading up
+// to n at once across the client's scans.
+func withPrefetch(n int) clientOption {
+	return func(c *client) {
+		c.fetchers = make(chan struct{}, max(n, 1))
+	}
+}
+
+// How many dataobjects each scan reads ahead.
+func (d *client) prefetchDepth() int {
+	if d.fetchers != nil {
+		return cap(d.fetchers)
+	}
+
+	return cap(d.decoders)
+}
+
+// Objects already downloaded, which are read as they were.
+type fetchedStorage struct {
+	objectStorage
+	objects map[string][]byte
+}
+
+func (fs fetchedStorage) read(ctx context.Context, name string) ([]byte, error) {
+	bytes, ok := fs.objects[name]
+	if !ok {
+		return nil, fmt.Errorf("not prefetched: %s", name)
+	}
+
+	return bytes, nil
+}
+
+// Downloads action's object and its wanted families, all of them if
+// nil, once a fetcher is free.
+func (d *client) fetchDataobject(ctx context.Context, storage objectStorage, action *DataobjectAction, wanted []bool) (objectStorage, error) {
+	select {
+	case d.fetchers <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-d.fetchers }()
+
+	keys := []string{dataobjectKey(action.Table, action.Name)}
+	for i := range action.Families {
+		if wanted == nil || wanted[i] {
+			keys = append(keys, familyKey(keys[0], i))
+		}
+	}
+
+	objects := map[string][]byte{}
+	for _, key := range keys {
+		bytes, err := storage.read(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		objects[key] = bytes
+	}
+
+	return fetchedStorage{storage, objects}, nil
+}
@@ -0,0 +1,235 @@
+package otf
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Prepared queries. A query's filter may use numbered placeholders
+// ($1, $2, ...) in place of literals, each with the type of value it
+// accepts. Preparing resolves and checks the query once and the
+// plan is cached on the client keyed by the query's canonical text,
+// so running many queries that differ only in their parameters
+// skips straight to scanning.
+//
+// There's no SQL parser yet; the canonical text is what one would
+// be keyed by.
+
+const (
+	// Accepts any value.
+	PARAM_ANY     = ""
+	PARAM_INT64   = "int64"
+	PARAM_DOUBLE  = "double"
+	PARAM_STRING  = "string"
+	PARAM_BOOLEAN = "boolean"
+)
+
+// Prepared plans kept per client before the cache is reset.
+const PREPARED_CACHE_SIZE = 256
+
+var errInvalidParameter = fmt.Errorf("Invalid Parameter")
+
+type placeholder struct {
+	// 1-based.
+	Index int
+	Type  string
+}
+
+func param(index int, typ string) placeholder {
+	return placeholder{index, typ}
+}
+
+// Whether v can be bound to a placeholder of type typ.
+func paramAccepts(typ string, v any) bool {
+	switch typ {
+	case PARAM_ANY:
+		return true
+	case PARAM_INT64:
+		f, ok := toFloat64(v)
+		return ok && f == math.Trunc(f)
+	case PARAM_DOUBLE:
+		_, ok := toFloat64(v)
+		return ok
+	case PARAM_STRING:
+		_, ok := v.(string)
+		return ok
+	case PARAM_BOOLEAN:
+		_, ok := v.(bool)
+		return ok
+	}
+
+	return false
+}
+
+func (p *predicate) walk(each func(*predicate)) {
+	each(p)
+	for _, c := range p.And {
+		c.walk(each)
+	}
+	for _, c := range p.Or {
+		c.walk(each)
+	}
+}
+
+func (p *predicate) hasPlaceholders() bool {
+	has := false
+	p.walk(func(c *predicate) {
+		if _, ok := c.Value.(placeholder); ok {
+			has = true
+		}
+	})
+
+	return has
+}
+
+// A copy of p with placeholders replaced by args.
+func (p *predicate) substitute(args []any) *predicate {
+	s := *p
+	if ph, ok := p.Value.(placeholder); ok {
+		s.Value = args[ph.Index-1]
+	}
+
+	s.And, s.Or = nil, nil
+	for _, c := range p.And {
+		s.And = append(s.And, c.substitute(args))
+	}
+	for _, c := range p.Or {
+		s.Or = append(s.Or, c.substitute(args))
+	}
+
+	return &s
+}
+
+type query struct {
+	Table string
+	// All columns if empty.
+	Columns []string
+	Filter  *predicate
+}
+
+func (q query) String() string {
+	columns := "*"
+	if len(q.Columns) > 0 {
+		columns = strings.Join(q.Columns, ", ")
+	}
+
+	text := fmt.Sprintf("SELECT %s FROM %s", columns, q.Table)
+	if q.Filter != nil {
+		text += " WHERE " + q.Filter.String()
+	}
+
+	return text
+}
+
+type preparedQuery struct {
+	Text string
+	// Types of $1, $2, ...
+	Params []string
+
+	q          query
+	schema     []string
+	projection []int
+	compiled   func(args []any) func(*batch, int) bool
+}
+
+// Returns the plan for q, from the client's cache if q has been
+// prepared before against the same table schema.
+func (d *client) prepare(q query) (*preparedQuery, error) {
+	if d.tx == nil {
+		return nil, errNoTx
+	}
+
+	schema, ok := d.tx.tables[q.Table]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errNoTable, q.Table)
+	}
+
+	text := q.String()
+	if pq, ok := d.prepared[text]; ok && strings.Join(pq.schema, "\x00") == strings.Join(schema, "\x00") {
+		return pq, nil
+	}
+
+	pq := &preparedQuery{Text: text, q: q, schema: schema}
+	if len(q.Columns) > 0 {
+		var err error
+		pq.projection, err = d.columnPositions(q.Table, q.Columns)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if q.Filter != nil {
+		types := map[int]string{}
+		var err error
+		q.Filter.walk(func(c *predicate) {
+			ph, ok := c.Value.(placeholder)
+			if !ok || err != nil {
+				return
+			}
+
+			if t, seen := types[ph.Index]; ph.Index < 1 || (seen && t != ph.Type) {
+				err = fmt.Errorf("%w: $%d", errInvalidParameter, ph.Index)
+			}
+			types[ph.Index] = ph.Type
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 1; i <= len(types); i++ {
+			t, ok := types[i]
+			if !ok {
+				return nil, fmt.Errorf("%w: $%d is never used", errInvalidParameter, i)
+			}
+			pq.Params = append(pq.Params, t)
+		}
+
+		pq.compiled, err = q.Filter.compile(d, q.Table)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if d.prepared == nil || len(d.prepared) >= PREPARED_CACHE_SIZE {
+		d.prepared = map[string]*preparedQuery{}
+	}
+	d.prepared[text] = pq
+
+	d.debug("prepare", "planned", "query", text)
+	return pq, nil
+}
+
+// Runs the prepared query with the given parameters in d's
+// transaction.
+func (pq *preparedQuery) scan(d *client, args ...any) (*scanIterator, error) {
+	if d.tx == nil {
+		return nil, errNoTx
+	}
+
+	if len(args) != len(pq.Params) {
+		return nil, fmt.Errorf("%w: expected %d parameters, got %d", errInvalidParameter, len(pq.Params), len(args))
+	}
+
+	for i, arg := range args {
+		if !paramAccepts(pq.Params[i], arg) {
+			return nil, fmt.Errorf("%w: $%d must be %s, got %T", errInvalidParameter, i+1, pq.Params[i], arg)
+		}
+	}
+
+	var filter *predicate
+	var keep func(*batch, int) bool
+	if pq.q.Filter != nil {
+		// Literal values are still needed for skipping
+		// dataobjects.
+		filter = pq.q.Filter.substitute(args)
+		keep = pq.compiled(args)
+	}
+
+	filter, keep, err := d.restrictScan(pq.q.Table, filter, keep)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.newScanIterator(pq.q.Table, pq.projection, filter, keep, false)
+}
@@ -0,0 +1,72 @@
+package otf
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPreparedQuery(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"name", "age"})
+	assertEq(err, nil, "could not create x")
+	for i, name := range []string{"Joey", "Yue", "Ada", "O'Brien"} {
+		err = c.writeRow("x", []any{name, i * 10})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+
+	q := query{
+		Table:   "x",
+		Columns: []string{"name"},
+		Filter:  or(where("age", OP_GTE, param(1, PARAM_INT64)), where("name", OP_EQ, param(2, PARAM_STRING))),
+	}
+	assertEq(q.String(), "SELECT name FROM x WHERE (age >= $1::int64 OR name = $2::string)", "canonical text")
+
+	pq, err := c.prepare(q)
+	assertEq(err, nil, "could not prepare")
+	again, err := c.prepare(q)
+	assertEq(err, nil, "could not prepare")
+	assert(pq == again, "plan reused")
+
+	for _, test := range []struct {
+		args  []any
+		names int
+	}{
+		{[]any{20, "Joey"}, 3},
+		{[]any{100, "O'Brien"}, 1},
+		{[]any{100.0, "Nobody"}, 0},
+	} {
+		it, err := pq.scan(&c, test.args...)
+		assertEq(err, nil, "could not scan")
+		names := 0
+		for {
+			row, err := it.next()
+			assertEq(err, nil, "could not iterate scan")
+			if row == nil {
+				break
+			}
+
+			assertEq(len(row), 1, "projected")
+			names++
+		}
+		assertEq(names, test.names, "matching rows")
+	}
+
+	_, err = pq.scan(&c, 1.5, "Joey")
+	assert(errors.Is(err, errInvalidParameter), "wrong parameter type")
+	_, err = pq.scan(&c, 1)
+	assert(errors.Is(err, errInvalidParameter), "missing parameter")
+
+	_, err = c.prepare(query{Table: "x", Filter: where("age", OP_EQ, param(2, PARAM_INT64))})
+	assert(errors.Is(err, errInvalidParameter), "gap in placeholders")
+
+	// Placeholders need binding.
+	_, err = c.scan("x", withFilter(where("age", OP_EQ, param(1, PARAM_INT64))))
+	assert(errors.Is(err, errInvalidParameter), "unbound placeholder")
+}
@@ -0,0 +1,203 @@
+package otf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Just enough of the protocol buffers wire format for the messages
+// of otf.proto, see grpc.go. Fields are encoded in field number
+// order and unknown fields are skipped when decoding.
+
+const (
+	PROTO_VARINT  = 0
+	PROTO_FIXED64 = 1
+	PROTO_BYTES   = 2
+	PROTO_FIXED32 = 5
+)
+
+var errProto = fmt.Errorf("Invalid Protobuf")
+
+type protoEncoder struct {
+	b []byte
+}
+
+func (e *protoEncoder) tag(field, wire int) {
+	e.b = binary.AppendUvarint(e.b, uint64(field<<3|wire))
+}
+
+func (e *protoEncoder) varint(field int, v uint64) {
+	e.tag(field, PROTO_VARINT)
+	e.b = binary.AppendUvarint(e.b, v)
+}
+
+func (e *protoEncoder) int(field int, v int64) {
+	e.varint(field, uint64(v))
+}
+
+func (e *protoEncoder) bool(field int, v bool) {
+	if v {
+		e.varint(field, 1)
+	} else {
+		e.varint(field, 0)
+	}
+}
+
+func (e *protoEncoder) double(field int, v float64) {
+	e.tag(field, PROTO_FIXED64)
+	e.b = binary.LittleEndian.AppendUint64(e.b, math.Float64bits(v))
+}
+
+func (e *protoEncoder) bytes(field int, b []byte) {
+	e.tag(field, PROTO_BYTES)
+	e.b = binary.AppendUvarint(e.b, uint64(len(b)))
+	e.b = append(e.b, b...)
+}
+
+func (e *protoEncoder) string(field int, s string) {
+	e.bytes(field, []byte(s))
+}
+
+func (e *protoEncoder) strings(field int, ss []string) {
+	for _, s := range ss {
+		e.string(field, s)
+	}
+}
+
+type protoField struct {
+	num  int
+	wire int
+	// Set for varint, fixed64 and fixed32 fields.
+	varint uint64
+	// Set for length-delimited fields.
+	bytes []byte
+}
+
+func (f protoField) string() string {
+	return string(f.bytes)
+}
+
+// Calls each for every field of the message in b.
+func protoFields(b []byte, each func(f protoField) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("%w: bad tag", errProto)
+		}
+		b = b[n:]
+
+		f := protoField{num: int(tag >> 3), wire: int(tag & 7)}
+		switch f.wire {
+		case PROTO_VARINT:
+			f.varint, n = binary.Uvarint(b)
+			if n <= 0 {
+				return fmt.Errorf("%w: bad varint", errProto)
+			}
+			b = b[n:]
+		case PROTO_FIXED64:
+			if len(b) < 8 {
+				return fmt.Errorf("%w: short fixed64", errProto)
+			}
+			f.varint = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case PROTO_FIXED32:
+			if len(b) < 4 {
+				return fmt.Errorf("%w: short fixed32", errProto)
+			}
+			f.varint = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		case PROTO_BYTES:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return fmt.Errorf("%w: bad length", errProto)
+			}
+			f.bytes = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			return fmt.Errorf("%w: unsupported wire type %d", errProto, f.wire)
+		}
+
+		err := each(f)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// A Value message, see otf.proto.
+func encodeProtoValue(v any) ([]byte, error) {
+	var e protoEncoder
+	switch v := v.(type) {
+	case nil:
+	case int:
+		e.int(2, int64(v))
+	case int64:
+		e.int(2, v)
+	case float64:
+		e.double(3, v)
+	case string:
+		e.string(4, v)
+	case bool:
+		e.bool(5, v)
+	case time.Time:
+		e.int(6, v.UnixNano())
+	default:
+		return nil, fmt.Errorf("%w: can't encode %T", errProto, v)
+	}
+
+	return e.b, nil
+}
+
+func decodeProtoValue(b []byte) (any, error) {
+	var v any
+	err := protoFields(b, func(f protoField) error {
+		switch f.num {
+		case 2:
+			v = int(int64(f.varint))
+		case 3:
+			v = math.Float64frombits(f.varint)
+		case 4:
+			v = f.string()
+		case 5:
+			v = f.varint != 0
+		case 6:
+			v = time.Unix(0, int64(f.varint)).UTC()
+		}
+		return nil
+	})
+
+	return v, err
+}
+
+// A Row message.
+func encodeProtoRow(row []any) ([]byte, error) {
+	var e protoEncoder
+	for _, v := range row {
+		b, err := encodeProtoValue(v)
+		if err != nil {
+			return nil, err
+		}
+		e.bytes(1, b)
+	}
+
+	return e.b, nil
+}
+
+func decodeProtoRow(b []byte) ([]any, error) {
+	row := []any{}
+	err := protoFields(b, func(f protoField) error {
+		if f.num != 1 {
+			return nil
+		}
+
+		v, err := decodeProtoValue(f.bytes)
+		row = append(row, v)
+		return err
+	})
+
+	return row, err
+}
@@ -0,0 +1,138 @@
+package otf
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// A published bundle is a read-only copy of one snapshot laid out
+// for static hosting (e.g. a CDN in front of a bucket):
+//
+//   - a single log entry holding the whole snapshot, so readers
+//     replay one object rather than the full history,
+//   - every live dataobject, gzip-compressed unless already
+//     compressed by its writer,
+//   - and this manifest describing all of the above. Static hosts
+//     can't list objects, so the manifest doubles as the listing
+//     for httpObjectStorage.
+const BUNDLE_MANIFEST = "_manifest.json"
+
+const BUNDLE_FORMAT = "otf-bundle"
+
+type bundleObject struct {
+	Name string
+	// "" or "gzip".
+	Encoding string
+	// Size once decoded.
+	Bytes int
+}
+
+type bundleManifest struct {
+	Format      string
+	Version     int
+	Snapshot    int
+	PublishedAt time.Time
+	Tables      map[string][]string
+	Objects     []bundleObject
+}
+
+// Publishes the latest snapshot visible to d into dst, which should
+// be empty. Uses and then ends its own transaction.
+func (d *client) publish(dst objectStorage) (*bundleManifest, error) {
+	err := d.newTx()
+	if err != nil {
+		return nil, err
+	}
+	// Nothing gets written to d.
+	defer func() { d.tx = nil }()
+
+	if d.tx.Id == 0 {
+		return nil, fmt.Errorf("nothing to publish: no transactions committed")
+	}
+
+	// The snapshot we're reading is the one before the id this
+	// transaction would commit at.
+	snapshot := &logEntry{Version: LOG_ENTRY_VERSION, Id: d.tx.Id - 1, Actions: map[string][]Action{}}
+	manifest := &bundleManifest{
+		Format:      BUNDLE_FORMAT,
+		Version:     1,
+		Snapshot:    snapshot.Id,
+		PublishedAt: time.Now().UTC(),
+		Tables:      map[string][]string{},
+	}
+
+	var tables []string
+	for table := range d.tx.tables {
+		tables = append(tables, table)
+	}
+	slices.Sort(tables)
+
+	for _, table := range tables {
+		columns := d.tx.tables[table]
+		manifest.Tables[table] = columns
+		for _, action := range d.tx.schemaActions(table) {
+			// The bundle has its own copy of the dataobjects.
+			action.ChangeMetadata.Location = nil
+			snapshot.Actions[table] = append(snapshot.Actions[table], action)
+		}
+
+		storage, err := d.tableStorage(table)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, action := range d.tx.previousActions[table] {
+			if action.DeleteRows != nil {
+				snapshot.Actions[table] = append(snapshot.Actions[table], action)
+				continue
+			}
+
+			for _, key := range dataobjectKeys(action.AddDataobject) {
+				raw, err := storage.read(d.context(), key)
+				if err != nil {
+					return nil, err
+				}
+
+				o := bundleObject{key, "", len(raw)}
+				if action.AddDataobject.Codec == CODEC_NONE {
+					raw, err = gzipBytes(raw)
+					if err != nil {
+						return nil, err
+					}
+					o.Encoding = "gzip"
+				}
+
+				err = dst.putIfAbsent(d.context(), key, raw)
+				if err != nil {
+					return nil, err
+				}
+
+				manifest.Objects = append(manifest.Objects, o)
+			}
+			snapshot.Actions[table] = append(snapshot.Actions[table], action)
+		}
+	}
+
+	logBytes, err := encodeLogEntry(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	logName := logEntryName(snapshot.Id)
+	err = dst.putIfAbsent(d.context(), logName, logBytes)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Objects = append(manifest.Objects, bundleObject{logName, "", len(logBytes)})
+
+	// Written last: a bundle without a manifest is incomplete.
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	d.debug("publish", "publishing snapshot", "snapshot", snapshot.Id, "objects", len(manifest.Objects))
+	return manifest, dst.putIfAbsent(d.context(), BUNDLE_MANIFEST, manifestBytes)
+}
@@ -0,0 +1,67 @@
+package otf
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Serves a storage's objects as static files.
+func staticHandler(os objectStorage) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bytes, err := os.read(context.Background(), strings.TrimPrefix(r.URL.Path, "/"))
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Write(bytes)
+	})
+}
+
+func TestPublishAndReadOverHTTP(t *testing.T) {
+	src := newMemoryObjectStorage()
+	c := newClient(src)
+
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b"})
+	assertEq(err, nil, "could not create x")
+	err = c.writeRow("x", []any{"Joey", 1})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{"Yue", 2})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	dst := newMemoryObjectStorage()
+	manifest, err := c.publish(dst)
+	assertEq(err, nil, "could not publish")
+	assertEq(manifest.Snapshot, 1, "published snapshot")
+	// Two dataobjects and the single log entry.
+	assertEq(len(manifest.Objects), 3, "published objects")
+
+	server := httptest.NewServer(staticHandler(dst))
+	defer server.Close()
+
+	hos, err := openObjectStorage(server.URL + "/")
+	assertEq(err, nil, "could not open bundle")
+	reader := newClient(hos)
+	assertEq(countRows(&reader, "x"), 2, "rows in published x")
+
+	// Bundles are read-only.
+	err = reader.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = reader.writeRow("x", []any{"Ada", 3})
+	assertEq(err, nil, "could not write row")
+	err = reader.commitTx()
+	assert(errors.Is(err, errReadOnly), "expected read-only error")
+}
@@ -0,0 +1,334 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Purging rows, e.g. to honor an erasure request, removes them for
+// good. Deleting rows (see delete.go) only hides them and updating
+// them (see update.go) leaves the old dataobjects for time travel.
+// A purge instead rewrites every dataobject holding a matching row
+// without it, including rows deleted before but still stored, and
+// records a PurgeAction in the log as a receipt of what it did.
+//
+// Dataobjects it replaced are normally left for vacuum (see
+// vacuum.go) like any other. With deleteNow they, and any older
+// dataobjects of the table holding matching rows that past
+// versions still refer to, are deleted as soon as the purge
+// commits. Time travel to versions before the purge then fails.
+// Tables with a compliance window (see compliance.go) can't have
+// data deleted early. Nor can data branches (see branch.go) share:
+// deleteNow fails while a branch or tag refers to a dataobject it
+// would delete, or a branch added rows to the table that a purge of
+// main can't reach, and on a branch, whose dataobjects main may
+// share.
+
+type PurgeAction struct {
+	Table     string
+	Predicate string
+	// Rows removed from the latest version.
+	Rows int
+	// Dataobjects rewritten, and the ones that replaced them.
+	Removed []string
+	Added   []string `json:",omitempty"`
+	// Dataobjects only past versions referred to holding matching
+	// rows.
+	Expired []string `json:",omitempty"`
+	// Whether Removed and Expired were deleted on commit.
+	Deleted bool `json:",omitempty"`
+}
+
+var (
+	errPurgeIncomplete = fmt.Errorf("Purge Incomplete")
+	errPurgeBranched   = fmt.Errorf("Purge Would Delete Branched Data")
+)
+
+// Every version of each of table's dataobjects ever committed, by
+// name.
+func (d *client) committedDataobjects(table string) (map[string]*DataobjectAction, error) {
+	names, err := d.listLog()
+	if err != nil {
+		return nil, err
+	}
+
+	dataobjects := map[string]*DataobjectAction{}
+	for _, name := range names {
+		tx, err := d.readLogEntry(name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, action := range tx.Actions[table] {
+			if action.AddDataobject != nil {
+				dataobjects[action.AddDataobject.Name] = action.AddDataobject
+			}
+		}
+	}
+
+	return dataobjects, nil
+}
+
+// Whether any row of the dataobject, deleted or not, matches. False
+// if it no longer exists.
+func (d *client) dataobjectMatches(action *DataobjectAction, matches func(*batch, int) bool) (bool, error) {
+	o, err := d.readDataobject(action)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for i := 0; i < o.Len; i++ {
+		if matches(&o.batch, i) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Removes the rows of table matching p from every dataobject, as of
+// the transaction, deleting the dataobjects replaced once it
+// commits if deleteNow.
+func (d *client) purge(table string, p *predicate, deleteNow bool) (*PurgeAction, error) {
+	if err := d.checkWritable(); err != nil {
+		return nil, err
+	}
+
+	if _, ok := d.tx.tables[table]; !ok {
+		return nil, errNoTable
+	}
+
+	if window := d.tx.complianceWindows[table]; deleteNow && window > 0 {
+		return nil, fmt.Errorf("%w: %s keeps data for %s", errComplianceWindow, table, window)
+	}
+
+	if deleteNow && d.branch != "" {
+		return nil, fmt.Errorf("%w: purge on main to delete data now", errPurgeBranched)
+	}
+
+	matches, err := p.bind(d, table)
+	if err != nil {
+		return nil, err
+	}
+
+	receipt := &PurgeAction{Table: table, Predicate: p.String(), Deleted: deleteNow}
+	live := map[string]bool{}
+	for _, action := range liveActions(slices.Concat(d.tx.previousActions[table], d.tx.Actions[table])) {
+		if action.AddDataobject != nil {
+			live[action.AddDataobject.Name] = true
+		}
+	}
+
+	result, err := d.copyOnWrite(table, p, func(b *batch, i int, deleted bool) ([]any, bool, error) {
+		// Rows deleted before are dropped too, matching or not,
+		// since they're already gone.
+		if !matches(b, i) {
+			if deleted {
+				return nil, false, nil
+			}
+			return b.row(i), false, nil
+		}
+
+		if !deleted {
+			receipt.Rows++
+		}
+		return nil, true, nil
+	}, func(result *rewriteResult) error {
+		if !deleteNow {
+			return nil
+		}
+
+		committed, err := d.committedDataobjects(table)
+		if err != nil {
+			return err
+		}
+
+		for name, action := range committed {
+			if live[name] {
+				continue
+			}
+
+			ok, err := d.dataobjectMatches(action, matches)
+			if err != nil {
+				return err
+			}
+			if ok {
+				receipt.Expired = append(receipt.Expired, name)
+			}
+		}
+		slices.Sort(receipt.Expired)
+
+		return d.checkUnbranched(table, slices.Concat(result.Removed, receipt.Expired))
+	})
+	if err != nil {
+		return nil, err
+	}
+	receipt.Removed = result.Removed
+	receipt.Added = result.Added
+
+	d.tx.Actions[table] = append(d.tx.Actions[table], Action{Purge: receipt})
+	if deleteNow {
+		d.tx.purged = append(d.tx.purged, receipt)
+	}
+
+	d.debug("purge", "removed rows", "table", table, "rows", receipt.Rows, "rewritten", len(receipt.Removed))
+	return receipt, nil
+}
+
+// Fails unless no branch or tag refers to the dataobjects of table
+// named, and no branch added a dataobject to table.
+func (d *client) checkUnbranched(table string, names []string) error {
+	referenced, added, err := d.branchReferences()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if referenced[dataobjectKey(table, name)] {
+			return fmt.Errorf("%w: a branch or tag refers to %s of %s", errPurgeBranched, name, table)
+		}
+	}
+
+	prefix := dataobjectKey(table, "")
+	for key := range added {
+		if strings.HasPrefix(key, prefix) {
+			return fmt.Errorf("%w: a branch added rows to %s", errPurgeBranched, table)
+		}
+	}
+
+	return nil
+}
+
+// Deletes the dataobjects purges in tx, which just committed,
+// asked to delete.
+func (d *client) deletePurged(tx *transaction) error {
+	for _, receipt := range tx.purged {
+		storage, err := d.storageAt(tx.locations[receipt.Table])
+		if err != nil {
+			return fmt.Errorf("%w: committed but could not delete: %s", errPurgeIncomplete, err)
+		}
+
+		for _, name := range slices.Concat(receipt.Removed, receipt.Expired) {
+			key := dataobjectKey(receipt.Table, name)
+			families, err := familyKeys(d.context(), storage, key)
+			if err != nil {
+				return fmt.Errorf("%w: committed but could not list families of %s: %s", errPurgeIncomplete, key, err)
+			}
+
+			for _, key := range append([]string{key}, families...) {
+				err = storage.delete(d.context(), key)
+				if err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return fmt.Errorf("%w: committed but could not delete %s: %s", errPurgeIncomplete, key, err)
+				}
+			}
+
+			if d.logFormat == LOG_FORMAT_DELTA {
+				err = d.deleteDeltaFiles(name)
+				if err != nil {
+					return fmt.Errorf("%w: committed but could not delete copies of %s: %s", errPurgeIncomplete, name, err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+type purgeReceipt struct {
+	TxId      int
+	Committed time.Time
+	PurgeAction
+}
+
+// Every purge of table committed, in order.
+func (d *client) purgeReceipts(table string) ([]purgeReceipt, error) {
+	names, err := d.listLog()
+	if err != nil {
+		return nil, err
+	}
+
+	var receipts []purgeReceipt
+	for _, name := range names {
+		tx, err := d.readLogEntry(name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, action := range tx.Actions[table] {
+			if action.Purge == nil {
+				continue
+			}
+
+			r := purgeReceipt{TxId: tx.Id, PurgeAction: *action.Purge}
+			if tx.CommitInfo != nil {
+				r.Committed = tx.CommitInfo.Timestamp
+			}
+			receipts = append(receipts, r)
+		}
+	}
+
+	return receipts, nil
+}
+
+// Checks, as of the current transaction, that what a purge of p
+// removed is gone: dataobjects it deleted no longer exist, and the
+// ones it added that are still live hold no rows matching p.
+func (d *client) verifyPurge(receipt *PurgeAction, p *predicate) error {
+	if d.tx == nil {
+		return errNoTx
+	}
+
+	matches, err := p.bind(d, receipt.Table)
+	if err != nil {
+		return err
+	}
+
+	storage, err := d.tableStorage(receipt.Table)
+	if err != nil {
+		return err
+	}
+
+	if receipt.Deleted {
+		for _, name := range slices.Concat(receipt.Removed, receipt.Expired) {
+			key := dataobjectKey(receipt.Table, name)
+			_, err := storage.read(d.context(), key)
+			if err == nil {
+				return fmt.Errorf("%w: %s still exists", errPurgeIncomplete, name)
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+
+			families, err := familyKeys(d.context(), storage, key)
+			if err != nil {
+				return err
+			}
+			if len(families) > 0 {
+				return fmt.Errorf("%w: families of %s still exist", errPurgeIncomplete, name)
+			}
+		}
+	}
+
+	for _, action := range d.tx.previousActions[receipt.Table] {
+		if action.AddDataobject == nil || !slices.Contains(receipt.Added, action.AddDataobject.Name) {
+			continue
+		}
+
+		ok, err := d.dataobjectMatches(action.AddDataobject, matches)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return fmt.Errorf("%w: %s holds matching rows", errPurgeIncomplete, action.AddDataobject.Name)
+		}
+	}
+
+	return nil
+}
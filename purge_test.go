@@ -0,0 +1,182 @@
+package otf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPurge(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	for i := 1; i <= 6; i++ {
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+		if i%3 == 0 {
+			err = c.flushRows("x")
+			assertEq(err, nil, "could not flush")
+		}
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Leaves 5 in a removed dataobject and 2 hidden in a live one.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.updateRows("x", where("a", OP_EQ, 5), map[string]*expr{"a": litExpr(50)})
+	assertEq(err, nil, "could not update")
+	_, err = c.deleteRows("x", where("a", OP_EQ, 2))
+	assertEq(err, nil, "could not delete")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	before, err := mos.listPrefix(context.Background(), DATAOBJECT_PREFIX)
+	assertEq(err, nil, "could not list")
+	assertEq(len(before), 3, "dataobjects")
+
+	p := or(where("a", OP_EQ, 2), where("a", OP_EQ, 5), where("a", OP_EQ, 6))
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	receipt, err := c.purge("x", p, true)
+	assertEq(err, nil, "could not purge")
+	assertEq(receipt.Rows, 1, "purged rows")
+	assertEq(len(receipt.Removed), 2, "rewritten dataobjects")
+	assertEq(len(receipt.Added), 2, "replacement dataobjects")
+	assertEq(len(receipt.Expired), 1, "expired dataobjects")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Only the replacements and nothing matching are left.
+	after, err := mos.listPrefix(context.Background(), DATAOBJECT_PREFIX)
+	assertEq(err, nil, "could not list")
+	assertEq(len(after), 2, "dataobjects")
+	for _, key := range before {
+		assert(!slices.Contains(after, key), "old dataobject left: "+key)
+	}
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(fmt.Sprint(scanAll(&c, "x")), "[[1] [3] [4] [50]]", "rows")
+	err = c.verifyPurge(receipt, p)
+	assertEq(err, nil, "could not verify purge")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+
+	// Versions before the purge are gone.
+	err = c.newTxAt(1)
+	assertEq(err, nil, "could not start tx")
+	it, err := c.scan("x")
+	if err == nil {
+		_, err = it.next()
+	}
+	assert(errors.Is(err, fs.ErrNotExist), fmt.Sprintf("expected missing dataobject, got %v", err))
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+
+	receipts, err := c.purgeReceipts("x")
+	assertEq(err, nil, "could not read receipts")
+	assertEq(len(receipts), 1, "receipts")
+	assertEq(receipts[0].TxId, 2, "receipt tx")
+	assertEq(receipts[0].Predicate, p.String(), "receipt predicate")
+	assert(!receipts[0].Committed.IsZero(), "receipt commit time")
+}
+
+func TestPurgeLeavesHistory(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.createTable("y", []string{"a"}, withComplianceWindow(time.Hour))
+	assertEq(err, nil, "could not create y")
+	for i := 1; i <= 3; i++ {
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.purge("y", where("a", OP_EQ, 1), true)
+	assert(errors.Is(err, errComplianceWindow), "purged within compliance window")
+	// Rows written in the transaction are purged too.
+	err = c.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	receipt, err := c.purge("x", where("a", OP_EQ, 1), false)
+	assertEq(err, nil, "could not purge")
+	assertEq(receipt.Rows, 2, "purged rows")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// The old dataobject is left for time travel and vacuum.
+	err = c.newTxAt(0)
+	assertEq(err, nil, "could not start tx")
+	assertEq(fmt.Sprint(scanAll(&c, "x")), "[[1] [2] [3]]", "rows before")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(fmt.Sprint(scanAll(&c, "x")), "[[2] [3]]", "rows")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+	_, err = mos.read(context.Background(), dataobjectKey("x", receipt.Removed[0]))
+	assertEq(err, nil, "old dataobject deleted")
+}
+
+func TestPurgeWithBranches(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	_, err = c.createBranch("dev", -1)
+	assertEq(err, nil, "could not create branch")
+	dev := newClient(mos, withBranch("dev"))
+	err = dev.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = dev.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = dev.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// dev shares main's dataobject and has its own matching row.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.purge("x", where("a", OP_EQ, 1), true)
+	assert(errors.Is(err, errPurgeBranched), fmt.Sprintf("purged data dev refers to: %v", err))
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+
+	err = dev.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = dev.purge("x", where("a", OP_EQ, 1), true)
+	assert(errors.Is(err, errPurgeBranched), "purged data main refers to")
+	assertEq(fmt.Sprint(scanAll(&dev, "x")), "[[1] [1]]", "dev rows")
+	err = dev.abortTx()
+	assertEq(err, nil, "could not abort")
+
+	err = c.deleteRef(REF_BRANCH, "dev")
+	assertEq(err, nil, "could not delete branch")
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.purge("x", where("a", OP_EQ, 1), true)
+	assertEq(err, nil, "could not purge")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+}
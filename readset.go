@@ -0,0 +1,85 @@
+package otf
+
+// By default a transaction that read a table conflicts with any
+// transaction committed since that changed it (see conflict.go).
+// For read-modify-write jobs over large tables that's coarse: any
+// append aborts every job that read any part of the table, and
+// tables only marked read by scans, deletes and the like say
+// nothing about which rows the job's writes depend on.
+//
+// With read validation a transaction instead records every committed
+// dataobject it reads, however it reads it (scans, the table cache,
+// lookups like dedupe.go's), and before committing checks that no
+// transaction committed since removed one of them, deleted rows from
+// one or changed the schema of a table it read from. Dataobjects
+// added since, which it didn't read, don't conflict; so like
+// snapshot isolation it doesn't prevent rows matching what it read
+// from appearing concurrently.
+//
+// Likewise a transaction that only rewrote dataobjects it read,
+// removing them and adding others, as compaction does, doesn't
+// conflict with transactions that wrote the same table but left
+// those dataobjects alone, so compactions of different dataobjects
+// of a table can commit concurrently (see parallelcompact.go).
+
+// Validates the dataobjects transactions read rather than the
+// tables, see above.
+func withReadValidation() clientOption {
+	return func(c *client) {
+		c.readValidation = true
+	}
+}
+
+// Records that the transaction read the dataobject action, if it
+// validates reads.
+func (tx *transaction) markDataobjectRead(action *DataobjectAction) {
+	if tx == nil || tx.readDataobjects == nil {
+		return
+	}
+
+	if tx.readDataobjects[action.Table] == nil {
+		tx.readDataobjects[action.Table] = map[string]bool{}
+	}
+	tx.readDataobjects[action.Table][action.Name] = true
+}
+
+// Whether tx's actions on table only rewrite dataobjects it read, if
+// it validates reads.
+func (tx *transaction) rewroteRead(table string) bool {
+	if tx.readDataobjects == nil {
+		return false
+	}
+
+	for _, action := range tx.Actions[table] {
+		switch {
+		case action.AddDataobject != nil:
+		case action.RemoveDataobject != nil && tx.readDataobjects[table][action.RemoveDataobject.Name]:
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// Whether committed actions on table removed or changed any
+// dataobject tx read from it.
+func (tx *transaction) readInvalidatedBy(table string, committed []Action) bool {
+	read := tx.readDataobjects[table]
+	if len(read) == 0 {
+		return false
+	}
+
+	for _, action := range committed {
+		switch {
+		case action.ChangeMetadata != nil:
+			return true
+		case action.RemoveDataobject != nil && read[action.RemoveDataobject.Name]:
+			return true
+		case action.DeleteRows != nil && read[action.DeleteRows.Name]:
+			return true
+		}
+	}
+
+	return false
+}
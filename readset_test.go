@@ -0,0 +1,53 @@
+package otf
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReadValidation(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c1 := newClient(mos, withReadValidation())
+	c2 := newClient(mos)
+	err := c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c1.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c1.createTable("y", []string{"a"})
+	assertEq(err, nil, "could not create y")
+	err = c1.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c1.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Appending to a table another transaction read doesn't
+	// conflict.
+	err = c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(scanAll(&c1, "x")), 1, "rows in x")
+	err = c1.writeRow("y", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c2.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c2.writeRow("x", []any{2})
+	assertEq(err, nil, "could not write row")
+	err = c2.commitTx()
+	assertEq(err, nil, "could not commit")
+	err = c1.commitTx()
+	assertEq(err, nil, "could not commit after rebasing")
+
+	// Deleting rows from a dataobject it read does.
+	err = c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(scanAll(&c1, "x")), 2, "rows in x")
+	err = c1.writeRow("y", []any{2})
+	assertEq(err, nil, "could not write row")
+	err = c2.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c2.deleteRows("x", where("a", OP_EQ, 1))
+	assertEq(err, nil, "could not delete")
+	err = c2.commitTx()
+	assertEq(err, nil, "could not commit")
+	err = c1.commitTx()
+	assert(errors.Is(err, errConflict), "expected conflict")
+}
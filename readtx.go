@@ -0,0 +1,56 @@
+package otf
+
+import (
+	"context"
+	"fmt"
+)
+
+// Read transactions see the store like any other but can't write:
+// creating tables, writing, deleting, updating, merging, purging,
+// compacting and changing a table's schema or metadata all fail
+// with errReadOnlyTx. Starting one doesn't allocate the state
+// writes need (pending actions, unflushed rows, dedupe state), and
+// committing one just ends it, without looking for anything to
+// commit or checking for conflicts.
+
+var errReadOnlyTx = fmt.Errorf("Read-Only Transaction")
+
+func (d *client) newReadTx() error {
+	return d.newReadTxContext(context.Background())
+}
+
+// Starts a read transaction whose object storage calls are made with
+// ctx.
+func (d *client) newReadTxContext(ctx context.Context) error {
+	if d.tx != nil {
+		return errExistingTx
+	}
+
+	d.ctx = ctx
+	err := d.openTx(true)
+	if err != nil {
+		d.ctx = nil
+	}
+	return err
+}
+
+// Allocates what the transaction needs to write.
+func (tx *transaction) allocateWrites() {
+	tx.Actions = map[string][]Action{}
+	tx.dedupeStates = map[string]*dedupeState{}
+	tx.unflushedData = map[string]*batch{}
+	tx.rowsWritten = map[string]int{}
+	tx.deltaFiles = map[string]int64{}
+}
+
+// Fails unless there's a transaction that can write.
+func (d *client) checkWritable() error {
+	if d.tx == nil {
+		return errNoTx
+	}
+	if d.tx.readOnly {
+		return errReadOnlyTx
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package otf
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadTx(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	for i := 0; i < 3; i++ {
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newReadTx()
+	assertEq(err, nil, "could not start read tx")
+	assert(c.tx.Actions == nil && c.tx.unflushedData == nil, "read tx allocated writes")
+	assertEq(len(scanAll(&c, "x")), 3, "rows")
+
+	err = c.newReadTx()
+	assert(errors.Is(err, errExistingTx), "expected existing tx error")
+
+	for _, write := range []func() error{
+		func() error { return c.createTable("y", []string{"a"}) },
+		func() error { return c.writeRow("x", []any{3}) },
+		func() error {
+			_, err := c.deleteRows("x", nil)
+			return err
+		},
+		func() error {
+			_, err := c.updateRows("x", nil, map[string]*expr{"a": litExpr(1)})
+			return err
+		},
+		func() error {
+			_, _, err := c.merge("x", []string{"a"}, [][]any{{1}})
+			return err
+		},
+		func() error { return c.addColumn("x", "b") },
+		func() error { return c.setRowPolicies("x", nil) },
+	} {
+		err = write()
+		assert(errors.Is(err, errReadOnlyTx), "expected read-only tx error")
+	}
+
+	// A concurrent commit doesn't conflict with it.
+	other := newClient(c.os)
+	err = other.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = other.deleteRows("x", nil)
+	assertEq(err, nil, "could not delete")
+	err = other.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit read tx")
+	assert(c.tx == nil, "commit didn't end read tx")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(scanAll(&c, "x")), 0, "rows after delete")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+}
+
+func TestReadTxServer(t *testing.T) {
+	s := newServer(newMemoryObjectStorage(), "secret")
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	status, out := post(srv, "secret", "/tx", map[string]any{"ReadOnly": true})
+	assertEq(status, http.StatusOK, "begin status")
+	tx := out["Tx"].(string)
+
+	status, out = post(srv, "secret", "/tx/"+tx+"/tables", map[string]any{"Table": "x", "Columns": []string{"a"}})
+	assertEq(status, http.StatusBadRequest, "create table status")
+	assertEq(out["Error"], any(errReadOnlyTx.Error()), "create table error")
+
+	status, _ = post(srv, "secret", "/tx/"+tx+"/commit", nil)
+	assertEq(status, http.StatusOK, "commit status")
+}
@@ -0,0 +1,283 @@
+package otf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Services sharing a bucket can find each other's tables through a
+// REST catalog speaking the Iceberg REST catalog protocol rather
+// than by listing storage:
+//
+// https://github.com/apache/iceberg/blob/main/open-api/rest-catalog-open-api.yaml
+//
+// A client given a catalog registers every table it commits to
+// under a namespace, with the log entry it was first registered at
+// as its metadata location. On each commit the table's properties
+// are updated with which store holds it, the id of its latest
+// snapshot and where its dataobjects are if not with the log (see
+// credentials.go). The snapshot is a hint for discovery; the log
+// stays the source of truth and the hint may briefly lag behind or,
+// with concurrent writers, move back.
+//
+// New transactions also resolve table locations from the catalog,
+// so a table's dataobjects can be moved to other storage by
+// updating its otf.location property. Tables the catalog doesn't
+// know about keep their location in the log.
+
+const (
+	CATALOG_STORE    = "otf.store"
+	CATALOG_SNAPSHOT = "otf.snapshot"
+	CATALOG_LOCATION = "otf.location"
+	CATALOG_ROLE     = "otf.role"
+)
+
+var (
+	errCatalog        = fmt.Errorf("Catalog Error")
+	errCatalogNoTable = fmt.Errorf("No Such Table In Catalog")
+)
+
+type restCatalog struct {
+	baseURL   string
+	namespace string
+	token     string
+	client    *http.Client
+}
+
+func newRESTCatalog(baseURL, namespace, token string) *restCatalog {
+	return &restCatalog{strings.TrimSuffix(baseURL, "/"), namespace, token, http.DefaultClient}
+}
+
+// Registers tables committed to in the store at the storage URL
+// store with cat, and resolves their locations from it.
+func withCatalog(cat *restCatalog, store string) clientOption {
+	return func(c *client) {
+		c.catalog = cat
+		c.catalogStore = store
+	}
+}
+
+// A table as the catalog knows it.
+type catalogTable struct {
+	Name string
+	// The storage URL of the store holding the table.
+	Store string
+	// The latest snapshot registered, -1 if unknown.
+	Snapshot         int
+	MetadataLocation string
+	// Nil if the table's dataobjects are with the log.
+	Location *TableLocation
+	// Set by catalog views, see catalogview.go.
+	Masked   []string
+	ReadOnly bool
+}
+
+type catalogIdentifier struct {
+	Namespace []string `json:"namespace"`
+	Name      string   `json:"name"`
+}
+
+type catalogLoadResult struct {
+	MetadataLocation string `json:"metadata-location"`
+	Metadata         struct {
+		Properties map[string]string `json:"properties"`
+	} `json:"metadata"`
+}
+
+type catalogUpdate struct {
+	Action  string            `json:"action"`
+	Updates map[string]string `json:"updates"`
+}
+
+type catalogCommit struct {
+	Identifier   catalogIdentifier `json:"identifier"`
+	Requirements []any             `json:"requirements"`
+	Updates      []catalogUpdate   `json:"updates"`
+}
+
+type catalogErrorResponse struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+func (cat *restCatalog) do(method, path string, body any, result any) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	u := cat.baseURL + "/v1/namespaces/" + url.PathEscape(cat.namespace) + path
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if cat.token != "" {
+		req.Header.Set("Authorization", "Bearer "+cat.token)
+	}
+
+	res, err := cat.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", errCatalogNoTable, path)
+	}
+	if res.StatusCode/100 != 2 {
+		var e catalogErrorResponse
+		b, _ := io.ReadAll(res.Body)
+		if json.Unmarshal(b, &e) == nil && e.Error.Message != "" {
+			return fmt.Errorf("%w: %s %s: %s: %s", errCatalog, method, path, e.Error.Type, e.Error.Message)
+		}
+		return fmt.Errorf("%w: %s %s: %s: %s", errCatalog, method, path, res.Status, b)
+	}
+
+	if result == nil || res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
+// Every table registered under the catalog's namespace.
+func (cat *restCatalog) listTables() ([]string, error) {
+	var result struct {
+		Identifiers []catalogIdentifier `json:"identifiers"`
+	}
+	err := cat.do(http.MethodGet, "/tables", nil, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, id := range result.Identifiers {
+		names = append(names, id.Name)
+	}
+	return names, nil
+}
+
+func (cat *restCatalog) loadTable(table string) (*catalogTable, error) {
+	var result catalogLoadResult
+	err := cat.do(http.MethodGet, "/tables/"+url.PathEscape(table), nil, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	properties := result.Metadata.Properties
+	t := &catalogTable{
+		Name:             table,
+		Store:            properties[CATALOG_STORE],
+		Snapshot:         -1,
+		MetadataLocation: result.MetadataLocation,
+	}
+	if snapshot, err := strconv.Atoi(properties[CATALOG_SNAPSHOT]); err == nil {
+		t.Snapshot = snapshot
+	}
+	if properties[CATALOG_LOCATION] != "" {
+		t.Location = &TableLocation{properties[CATALOG_LOCATION], properties[CATALOG_ROLE]}
+	}
+	if properties[CATALOG_MASKED] != "" {
+		t.Masked = strings.Split(properties[CATALOG_MASKED], ",")
+	}
+	t.ReadOnly = properties[CATALOG_READ_ONLY] == "true"
+
+	return t, nil
+}
+
+// Points table at its latest snapshot, registering it first if the
+// catalog doesn't know it yet.
+func (cat *restCatalog) updateTable(t catalogTable) error {
+	properties := map[string]string{
+		CATALOG_STORE:    t.Store,
+		CATALOG_SNAPSHOT: strconv.Itoa(t.Snapshot),
+	}
+	if t.Location != nil {
+		properties[CATALOG_LOCATION] = t.Location.URL
+		properties[CATALOG_ROLE] = t.Location.Role
+	}
+
+	commit := catalogCommit{
+		Identifier:   catalogIdentifier{[]string{cat.namespace}, t.Name},
+		Requirements: []any{},
+		Updates:      []catalogUpdate{{"set-properties", properties}},
+	}
+	path := "/tables/" + url.PathEscape(t.Name)
+	err := cat.do(http.MethodPost, path, commit, nil)
+	if !errors.Is(err, errCatalogNoTable) {
+		return err
+	}
+
+	register := map[string]string{"name": t.Name, "metadata-location": t.MetadataLocation}
+	err = cat.do(http.MethodPost, "/register", register, nil)
+	if err != nil {
+		return err
+	}
+
+	return cat.do(http.MethodPost, path, commit, nil)
+}
+
+// Registers the latest snapshot of every table tx changed, which
+// committed as the log entry name.
+func (d *client) registerTables(tx *transaction, name string) error {
+	for _, table := range sortedKeys(tx.Actions) {
+		if len(tx.Actions[table]) == 0 {
+			continue
+		}
+
+		err := d.catalog.updateTable(catalogTable{
+			Name:             table,
+			Store:            d.catalogStore,
+			Snapshot:         tx.Id,
+			MetadataLocation: strings.TrimSuffix(d.catalogStore, "/") + "/" + name,
+			Location:         tx.locations[table],
+		})
+		if err != nil {
+			return fmt.Errorf("committed but could not register %s: %w", table, err)
+		}
+	}
+
+	return nil
+}
+
+// Takes the location of each table the catalog knows from it, and
+// what a catalog view masks of it, see catalogview.go.
+func (d *client) resolveTableLocations() error {
+	for _, table := range sortedKeys(d.tx.tables) {
+		t, err := d.catalog.loadTable(table)
+		if errors.Is(err, errCatalogNoTable) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if t.Store != d.catalogStore {
+			continue
+		}
+		if t.Location != nil {
+			d.tx.locations[table] = t.Location
+		}
+		if t.Masked != nil {
+			d.tx.masked[table] = t.Masked
+		}
+		d.tx.readOnlyCatalog = d.tx.readOnlyCatalog || t.ReadOnly
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package otf
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// Just enough of an Iceberg REST catalog, for one namespace.
+type fakeCatalog struct {
+	mu     sync.Mutex
+	tables map[string]*catalogLoadResult
+	token  string
+}
+
+func newFakeCatalog(token string) (*fakeCatalog, *httptest.Server) {
+	fc := &fakeCatalog{tables: map[string]*catalogLoadResult{}, token: token}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/namespaces/ns/tables", func(w http.ResponseWriter, r *http.Request) {
+		var ids []catalogIdentifier
+		for _, name := range sortedKeys(fc.tables) {
+			ids = append(ids, catalogIdentifier{[]string{"ns"}, name})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"identifiers": ids})
+	})
+	mux.HandleFunc("POST /v1/namespaces/ns/register", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		if fc.tables[req["name"]] != nil {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		t := &catalogLoadResult{MetadataLocation: req["metadata-location"]}
+		t.Metadata.Properties = map[string]string{}
+		fc.tables[req["name"]] = t
+		json.NewEncoder(w).Encode(t)
+	})
+	mux.HandleFunc("GET /v1/namespaces/ns/tables/{table}", func(w http.ResponseWriter, r *http.Request) {
+		t := fc.tables[r.PathValue("table")]
+		if t == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(t)
+	})
+	mux.HandleFunc("POST /v1/namespaces/ns/tables/{table}", func(w http.ResponseWriter, r *http.Request) {
+		t := fc.tables[r.PathValue("table")]
+		if t == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{"message": "no such table", "type": "NoSuchTableException"}})
+			return
+		}
+		var commit catalogCommit
+		json.NewDecoder(r.Body).Decode(&commit)
+		for _, u := range commit.Updates {
+			for k, v := range u.Updates {
+				t.Metadata.Properties[k] = v
+			}
+		}
+		json.NewEncoder(w).Encode(t)
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fc.token != "" && r.Header.Get("Authorization") != "Bearer "+fc.token {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{"message": "bad token", "type": "NotAuthorizedException"}})
+			return
+		}
+		fc.mu.Lock()
+		defer fc.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	return fc, server
+}
+
+func TestRESTCatalogRegistersTables(t *testing.T) {
+	_, server := newFakeCatalog("secret")
+	defer server.Close()
+
+	cat := newRESTCatalog(server.URL, "ns", "secret")
+	c := newClient(newMemoryObjectStorage(), withCatalog(cat, "s3://bucket/store"))
+	for i := 0; i < 2; i++ {
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+		if i == 0 {
+			err = c.createTable("x", []string{"a"})
+			assertEq(err, nil, "could not create x")
+			err = c.createTable("y", []string{"a"})
+			assertEq(err, nil, "could not create y")
+		}
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+
+	names, err := cat.listTables()
+	assertEq(err, nil, "could not list tables")
+	assertEq(fmt.Sprint(names), "[x y]", "wrong tables")
+
+	x, err := cat.loadTable("x")
+	assertEq(err, nil, "could not load x")
+	assertEq(x.Store, "s3://bucket/store", "wrong store")
+	assertEq(x.Snapshot, 1, "wrong snapshot")
+	assertEq(x.MetadataLocation, "s3://bucket/store/"+logEntryName(0), "wrong metadata location")
+	assert(x.Location == nil, "x has a location")
+
+	// y didn't change in the second transaction.
+	y, err := cat.loadTable("y")
+	assertEq(err, nil, "could not load y")
+	assertEq(y.Snapshot, 0, "wrong snapshot")
+
+	_, err = cat.loadTable("z")
+	assert(errors.Is(err, errCatalogNoTable), "loaded a missing table")
+
+	_, err = newRESTCatalog(server.URL, "ns", "wrong").listTables()
+	assert(errors.Is(err, errCatalog), "listed with a bad token")
+}
+
+func TestRESTCatalogResolvesLocations(t *testing.T) {
+	fc, server := newFakeCatalog("")
+	defer server.Close()
+
+	cat := newRESTCatalog(server.URL, "ns", "")
+	c := newClient(newMemoryObjectStorage(), withCatalog(cat, "mem"))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Move x's dataobjects elsewhere.
+	dir := t.TempDir()
+	fc.tables["x"].Metadata.Properties[CATALOG_LOCATION] = dir
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(c.tx.locations["x"].URL, dir, "didn't resolve x's location")
+	err = c.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	names, err := newFileObjectStorage(dir).listPrefix(context.Background(), dataobjectKey("x", ""))
+	assertEq(err, nil, "could not list")
+	assertEq(len(names), 1, "dataobject not written to the new location")
+	assertEq(countRows(&c, "x"), 1, "wrong rows")
+
+	// And the location sticks on the next registration.
+	x, err := cat.loadTable("x")
+	assertEq(err, nil, "could not load x")
+	assertEq(x.Location.URL, dir, "location lost")
+	assertEq(x.Snapshot, 1, "wrong snapshot")
+}
@@ -0,0 +1,229 @@
+package otf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Dataobjects of more than ROW_GROUP_SIZE rows are written as row
+// groups: runs of consecutive rows, each encoded and compressed on
+// its own and stored back to back. The AddDataobject action records
+// where each group is in the dataobject along with its own
+// statistics, so a filtered scan can read only the groups that may
+// hold a matching row with range reads rather than read the whole
+// dataobject.
+//
+// Row positions, e.g. of deleted rows, are always relative to the
+// whole dataobject.
+
+const ROW_GROUP_SIZE = 8 * 1024
+
+type rowGroup struct {
+	// Byte range of the group in the dataobject.
+	Offset int64
+	Length int64
+	Rows   int
+	// See stats.go.
+	Stats map[string]columnStats `json:",omitempty"`
+	// Of the group's bytes, see checksum.go.
+	Checksum string `json:",omitempty"`
+}
+
+// Splits dataobjects into row groups of n rows, at least 1.
+func withRowGroupSize(n int) clientOption {
+	return func(c *client) {
+		c.rowGroupSize = max(n, 1)
+	}
+}
+
+// Object storage that can read part of an object without reading
+// all of it.
+type rangeReader interface {
+	readRange(ctx context.Context, name string, offset, length int64) ([]byte, error)
+}
+
+// Reads length bytes of name from offset, all of name if os can't
+// read part of it.
+func readRange(ctx context.Context, os objectStorage, name string, offset, length int64) ([]byte, error) {
+	if rr, ok := os.(rangeReader); ok {
+		return rr.readRange(ctx, name, offset, length)
+	}
+
+	bytes, err := os.read(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset+length > int64(len(bytes)) {
+		return nil, fmt.Errorf("%w: %s is %d bytes, wanted %d at %d", io.ErrUnexpectedEOF, name, len(bytes), length, offset)
+	}
+
+	return bytes[offset : offset+length], nil
+}
+
+// Encodes rows of df as row groups of size rows, returning the
+// dataobject's bytes and its groups. Columnar groups are encoded
+// with columnCodecs, see columncodec.go.
+func encodeRowGroups(codec string, columnCodecs []string, columns []string, df dataobject, size int) ([]byte, []rowGroup, error) {
+	var encoded []byte
+	var groups []rowGroup
+	for from := 0; from < df.Len; from += size {
+		rows := df.slice(from, min(from+size, df.Len))
+		var bytes []byte
+		var err error
+		if codec == CODEC_COLUMNAR {
+			bytes, err = encodeColumnar(columnCodecs, rows)
+		} else {
+			bytes, err = json.Marshal(dataobject{Table: df.Table, Name: df.Name, batch: *rows})
+			if err == nil {
+				bytes, err = compressBytes(codec, bytes)
+			}
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		groups = append(groups, rowGroup{
+			Offset:   int64(len(encoded)),
+			Length:   int64(len(bytes)),
+			Rows:     rows.Len,
+			Stats:    batchStats(columns, rows),
+			Checksum: checksum(bytes),
+		})
+		encoded = append(encoded, bytes...)
+	}
+
+	return encoded, groups, nil
+}
+
+func decodeRowGroup(codec string, bytes []byte) (*batch, error) {
+	if codec == CODEC_COLUMNAR {
+		return decodeColumnar(bytes)
+	}
+
+	bytes, err := decompressBytes(codec, bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var do dataobject
+	err = json.Unmarshal(bytes, &do)
+	if err != nil {
+		return nil, err
+	}
+
+	return &do.batch, nil
+}
+
+// Appends the rows of other to b.
+func (b *batch) concat(other *batch) {
+	for i := range b.Columns {
+		b.Columns[i] = append(b.Columns[i], other.Columns[i]...)
+	}
+	b.Len += other.Len
+}
+
+// The row groups of action that may hold a row matching p, empty if
+// none may and nil if every one may (including when action has
+// none).
+func (d *client) rowGroupsMayMatch(table string, action *DataobjectAction, p *predicate) []int {
+	if action.RowGroups == nil {
+		return nil
+	}
+
+	history := d.tx.schemas[table]
+	groups := []int{}
+	for i, group := range action.RowGroups {
+		stats := history.evolveStats(action.SchemaVersion, group.Stats, group.Rows)
+		if statsMayMatch(stats, group.Rows, p) {
+			groups = append(groups, i)
+		}
+	}
+
+	if len(groups) == len(action.RowGroups) {
+		return nil
+	}
+
+	return groups
+}
+
+// Reads only the given row groups of action, at least one,
+// dropping deleted rows from each, and converts them with convert
+// if not nil. Decodes them as late says unless it's nil, see
+// latematerialize.go.
+func (d *client) readRowGroups(ctx context.Context, action *DataobjectAction, groups []int, late *lateRead, deleted map[int]bool, convert func(*batch) *batch) (*batch, error) {
+	storage, err := d.tableStorage(action.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	key := dataobjectKey(action.Table, action.Name)
+	var rows *batch
+	for _, i := range groups {
+		group := action.RowGroups[i]
+		bytes, err := readRange(ctx, storage, key, group.Offset, group.Length)
+		if err == nil {
+			err = verifyChecksum(fmt.Sprintf("%s row group %d", key, i), bytes, group.Checksum)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		first := 0
+		for _, g := range action.RowGroups[:i] {
+			first += g.Rows
+		}
+
+		var b *batch
+		if late != nil {
+			b, err = decodeLate(action.Codec, bytes, late, first, deleted, convert)
+		} else {
+			b, err = decodeRowGroup(action.Codec, bytes)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if deleted != nil && late == nil {
+			b = b.filter(func(_ *batch, j int) bool {
+				return !deleted[first+j]
+			})
+		}
+
+		if rows == nil {
+			rows = newBatch(len(b.Columns))
+		}
+		rows.concat(b)
+	}
+
+	if convert != nil {
+		rows = convert(rows)
+	}
+
+	d.debug("rowgroup", "read row groups", "dataobject", action.Name, "read", len(groups), "of", len(action.RowGroups))
+	return rows, nil
+}
+
+// Reads every row group of a dataobject at once.
+func decodeRowGroups(action *DataobjectAction, bytes []byte) (*batch, error) {
+	var rows *batch
+	for _, group := range action.RowGroups {
+		if group.Offset+group.Length > int64(len(bytes)) {
+			return nil, fmt.Errorf("%w: row group of %s", io.ErrUnexpectedEOF, action.Name)
+		}
+
+		b, err := decodeRowGroup(action.Codec, bytes[group.Offset:group.Offset+group.Length])
+		if err != nil {
+			return nil, err
+		}
+
+		if rows == nil {
+			rows = newBatch(len(b.Columns))
+		}
+		rows.concat(b)
+	}
+
+	return rows, nil
+}
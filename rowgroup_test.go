@@ -0,0 +1,120 @@
+package otf
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+)
+
+// Counts whole and partial reads of dataobjects.
+type rangeReads struct {
+	*memoryObjectStorage
+	mu     sync.Mutex
+	reads  int
+	ranges int
+}
+
+func (rr *rangeReads) read(ctx context.Context, name string) ([]byte, error) {
+	if isDataobjectKey(name) {
+		rr.mu.Lock()
+		rr.reads++
+		rr.mu.Unlock()
+	}
+
+	return rr.memoryObjectStorage.read(ctx, name)
+}
+
+func (rr *rangeReads) readRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	rr.mu.Lock()
+	rr.ranges++
+	rr.mu.Unlock()
+
+	return rr.memoryObjectStorage.readRange(ctx, name, offset, length)
+}
+
+func TestRowGroups(t *testing.T) {
+	rr := &rangeReads{memoryObjectStorage: newMemoryObjectStorage()}
+	c := newClient(rr, withRowGroupSize(100), withCodec(CODEC_ZSTD))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"id", "name"})
+	assertEq(err, nil, "could not create x")
+	for i := 0; i < 1000; i++ {
+		err = c.writeRow("x", []any{i, "row"})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	actions := c.tx.previousActions["x"]
+	action := actions[len(actions)-1].AddDataobject
+	assertEq(action.Rows, 1000, "rows")
+	assertEq(len(action.RowGroups), 10, "row groups")
+	assertEq[any](action.RowGroups[3].Stats["id"].Min, float64(300), "min of group")
+
+	// Unfiltered scans read the whole dataobject.
+	rows := scanAll(&c, "x")
+	assertEq(len(rows), 1000, "rows")
+	for i, row := range rows {
+		assertEq[any](row[0], float64(i), "row")
+	}
+	assertEq(rr.reads, 1, "whole reads")
+	assertEq(rr.ranges, 0, "range reads")
+
+	// Filtered scans read only the groups that may match.
+	rows = scanAll(&c, "x", withFilter(or(where("id", OP_LT, 50), where("id", OP_GTE, 950))))
+	assertEq(len(rows), 100, "rows")
+	assertEq(rr.reads, 1, "whole reads")
+	assertEq(rr.ranges, 2, "range reads")
+
+	rows = scanAll(&c, "x", withFilter(where("id", OP_GT, 5000)))
+	assertEq(len(rows), 0, "rows")
+	assertEq(rr.ranges, 2, "range reads")
+
+	// Deleted positions are relative to the whole dataobject.
+	n, err := c.deleteRows("x", where("id", OP_EQ, 955))
+	assertEq(err, nil, "could not delete")
+	assertEq(n, 1, "deleted")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	rows = scanAll(&c, "x", withFilter(and(where("id", OP_GTE, 950), where("id", OP_LT, 960))))
+	assertEq(len(rows), 9, "rows")
+	for _, row := range rows {
+		assert(row[0] != float64(955), "deleted row")
+	}
+
+	// Small dataobjects aren't split.
+	err = c.writeRow("x", []any{1000, "row"})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	actions = c.tx.previousActions["x"]
+	assert(actions[len(actions)-1].AddDataobject.RowGroups == nil, "expected no row groups")
+	assertEq(len(scanAll(&c, "x")), 1000, "rows")
+}
+
+func TestFileReadRange(t *testing.T) {
+	dir, err := os.MkdirTemp("", "otf-rowgroup")
+	assertEq(err, nil, "could not create dir")
+	defer os.RemoveAll(dir)
+
+	fos := newFileObjectStorage(dir)
+	err = fos.putIfAbsent(context.Background(), "x", []byte("hello world"))
+	assertEq(err, nil, "could not write")
+
+	bytes, err := readRange(context.Background(), fos, "x", 6, 5)
+	assertEq(err, nil, "could not read range")
+	assertEq(string(bytes), "world", "range")
+
+	_, err = readRange(context.Background(), fos, "x", 6, 50)
+	assert(err != nil, "expected error reading past the end")
+}
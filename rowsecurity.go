@@ -0,0 +1,264 @@
+package otf
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Clients can act as an identity, e.g. the server's clients act as
+// whoever the request's token authenticates (see server.go). Every
+// scan such a client runs, its own and those of SQL, joins, exports
+// and so on, is restricted to rows the identity can see, and so are
+// the rows its updates and deletes match:
+//
+//   - tables can have row policies, stored in their metadata. Each
+//     applies to some identities, or all of them, and lets them see
+//     the rows matching its filter and whose identity column, if it
+//     has one, is the identity. An identity sees the rows any policy
+//     applying to it lets it see, and none if no policy applies to
+//     it. Tables without policies are unrestricted;
+//   - row filter hooks inject more predicates for an identity and a
+//     table, on top of the policies, e.g. from an external policy
+//     engine.
+//
+// A client without an identity, the server's own token included, is
+// unrestricted. Foreign key and primary key checks (see foreignkey.go
+// and constraints.go) see every row. Policies don't restrict what's
+// inserted.
+
+const SCHEMA_SET_ROW_POLICIES = "SetRowPolicies"
+
+var errRowPolicyColumn = fmt.Errorf("Row Policy Column")
+
+type RowPolicy struct {
+	Name string
+	// Identities the policy applies to, every one if empty.
+	Identities []string `json:",omitempty"`
+	// The rows visible, every one if nil.
+	Filter *predicate `json:",omitempty"`
+	// If set, only rows with the identity in this column are
+	// visible.
+	IdentityColumn string `json:",omitempty"`
+}
+
+// The rows policy lets identity see, nil for every one.
+func (policy RowPolicy) visible(identity string) *predicate {
+	var ps []*predicate
+	if policy.Filter != nil {
+		ps = append(ps, policy.Filter)
+	}
+	if policy.IdentityColumn != "" {
+		ps = append(ps, where(policy.IdentityColumn, OP_EQ, identity))
+	}
+
+	switch len(ps) {
+	case 0:
+		return nil
+	case 1:
+		return ps[0]
+	}
+	return and(ps...)
+}
+
+// Whether column of table is in any of its row policies.
+func (tx *transaction) rowPolicyColumn(table, column string) bool {
+	var walk func(p *predicate) bool
+	walk = func(p *predicate) bool {
+		return p.Column == column || slices.ContainsFunc(slices.Concat(p.And, p.Or), walk)
+	}
+
+	return slices.ContainsFunc(tx.rowPolicies[table], func(policy RowPolicy) bool {
+		return policy.IdentityColumn == column || (policy.Filter != nil && walk(policy.Filter))
+	})
+}
+
+// Returns a predicate rows of table must also match to be visible to
+// identity, nil for none, or fails to refuse the scan.
+type rowFilter func(identity, table string) (*predicate, error)
+
+func withRowFilter(f rowFilter) clientOption {
+	return func(c *client) {
+		c.rowFilters = append(c.rowFilters, f)
+	}
+}
+
+// Acts as identity, see above.
+func withIdentity(identity string) clientOption {
+	return func(c *client) {
+		c.identity = identity
+	}
+}
+
+// Declares the table's row policies.
+func withRowPolicies(policies ...RowPolicy) tableOption {
+	return func(o *tableOptions) {
+		o.rowPolicies = policies
+	}
+}
+
+func (d *client) checkRowPolicies(table string, columns []string, policies []RowPolicy) error {
+	for _, policy := range policies {
+		if policy.IdentityColumn != "" && !slices.Contains(columns, policy.IdentityColumn) {
+			return fmt.Errorf("%w: %s.%s", errNoColumn, table, policy.IdentityColumn)
+		}
+
+		if policy.Filter != nil {
+			err := policy.Filter.validate(d)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Replaces table's row policies, with none if policies is empty.
+func (d *client) setRowPolicies(table string, policies []RowPolicy) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+
+	history, ok := d.tx.schemas[table]
+	if !ok {
+		return errNoTable
+	}
+
+	latest := history[len(history)-1]
+	err := d.checkRowPolicies(table, latest.Columns, policies)
+	if err != nil {
+		return err
+	}
+
+	// The schema is unchanged, so recorded as the same version.
+	mtd := &ChangeMetadataAction{
+		Table:            table,
+		Columns:          latest.Columns,
+		PartitionColumns: d.tx.partitions[table],
+		Op:               SCHEMA_SET_ROW_POLICIES,
+		SchemaVersion:    history.version(),
+		ColumnTypes:      latest.Types,
+		RowPolicies:      policies,
+	}
+	if history.version() > 0 {
+		mtd.ColumnIds = latest.Ids
+	}
+	d.tx.rowPolicies[table] = policies
+	d.tx.schemas[table] = history.record(mtd)
+	d.tx.Actions[table] = append(d.tx.Actions[table], Action{ChangeMetadata: mtd})
+
+	d.debug("rowsecurity", "set row policies", "table", table, "policies", len(policies))
+	return nil
+}
+
+// What rows of table must match to be visible to the client's
+// identity, nil if every one is.
+func (d *client) rowFilter(table string) (*predicate, error) {
+	if d.identity == "" {
+		return nil, nil
+	}
+
+	var filters []*predicate
+	if policies := d.tx.rowPolicies[table]; len(policies) > 0 {
+		// An empty OR matches nothing.
+		visible := &predicate{Or: []*predicate{}}
+		for _, policy := range policies {
+			if len(policy.Identities) > 0 && !slices.Contains(policy.Identities, d.identity) {
+				continue
+			}
+
+			p := policy.visible(d.identity)
+			if p == nil {
+				visible = nil
+				break
+			}
+			visible.Or = append(visible.Or, p)
+		}
+
+		if visible != nil {
+			filters = append(filters, visible)
+		}
+	}
+
+	for _, f := range d.rowFilters {
+		p, err := f(d.identity, table)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			filters = append(filters, p)
+		}
+	}
+
+	switch len(filters) {
+	case 0:
+		return nil, nil
+	case 1:
+		return filters[0], nil
+	}
+	return and(filters...), nil
+}
+
+// Restricts p, matching every row if nil, to rows of table visible to
+// the client's identity.
+func (d *client) restrictRows(table string, p *predicate) (*predicate, error) {
+	visible, err := d.rowFilter(table)
+	if err != nil || visible == nil {
+		return p, err
+	}
+
+	if p == nil {
+		return visible, nil
+	}
+	return and(p, visible), nil
+}
+
+// Restricts a scan's filter, and keep, its compiled form, to rows of
+// table visible to the client's identity.
+func (d *client) restrictScan(table string, filter *predicate, keep func(*batch, int) bool) (*predicate, func(*batch, int) bool, error) {
+	visible, err := d.rowFilter(table)
+	if err != nil || visible == nil {
+		return filter, keep, err
+	}
+
+	matches, err := visible.bind(d, table)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if filter == nil {
+		return visible, matches, nil
+	}
+	return and(filter, visible), func(b *batch, i int) bool {
+		return keep(b, i) && matches(b, i)
+	}, nil
+}
+
+// Reads a file of lines of a token and the identity it authenticates,
+// separated by a space.
+func readIdentityFile(name string) (map[string]string, error) {
+	contents, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	identities := map[string]string{}
+	for i, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		token, identity, ok := strings.Cut(line, " ")
+		identity = strings.TrimSpace(identity)
+		if !ok || identity == "" {
+			return nil, fmt.Errorf("%s:%d: expected a token and an identity", name, i+1)
+		}
+
+		identities[token] = identity
+	}
+
+	return identities, nil
+}
@@ -0,0 +1,125 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Creates orders(id, tenant, amount), visible to each tenant by
+// tenant and whole to auditors, with orders of tenants a and b.
+func newRowSecurityStorage() *memoryObjectStorage {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("orders", []string{"id", "tenant", "amount"}, withRowPolicies(
+		RowPolicy{Name: "tenants", IdentityColumn: "tenant"},
+		RowPolicy{Name: "auditors", Identities: []string{"auditor"}},
+	))
+	assertEq(err, nil, "could not create orders")
+	err = c.createTable("products", []string{"id"})
+	assertEq(err, nil, "could not create products")
+	for _, row := range [][]any{{1, "a", 5}, {2, "a", 50}, {3, "b", 7}} {
+		err = c.writeRow("orders", row)
+		assertEq(err, nil, "could not write order")
+	}
+	err = c.writeRow("products", []any{1})
+	assertEq(err, nil, "could not write product")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	return mos
+}
+
+func TestRowPolicies(t *testing.T) {
+	mos := newRowSecurityStorage()
+	visible := func(identity string, opts ...scanOption) string {
+		c := newClient(mos, withIdentity(identity))
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+		defer c.abortTx()
+		return fmt.Sprint(scanAll(&c, "orders", opts...))
+	}
+
+	assertEq(visible(""), "[[1 a 5] [2 a 50] [3 b 7]]", "rows without an identity")
+	assertEq(visible("a"), "[[1 a 5] [2 a 50]]", "rows of a")
+	assertEq(visible("a", withFilter(where("amount", OP_GT, 10))), "[[2 a 50]]", "filtered rows of a")
+	assertEq(visible("c"), "[]", "rows of c")
+	assertEq(visible("auditor"), "[[1 a 5] [2 a 50] [3 b 7]]", "rows of an auditor")
+
+	c := newClient(mos, withIdentity("b"))
+	result, err := c.execSQL("SELECT id FROM orders WHERE amount > $1", 0)
+	assertEq(err, nil, "could not select")
+	assertEq(fmt.Sprint(result.Rows), "[[3]]", "selected rows of b")
+	result, err = c.execSQL("SELECT * FROM products")
+	assertEq(err, nil, "could not select")
+	assertEq(len(result.Rows), 1, "rows of a table without policies")
+
+	// Deletes and updates only match visible rows.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	n, err := c.deleteRows("orders", where("amount", OP_GT, 0))
+	assertEq(err, nil, "could not delete")
+	assertEq(n, 1, "deleted rows of b")
+	n, err = c.updateRows("orders", where("id", OP_EQ, 1), map[string]*expr{"amount": litExpr(0)})
+	assertEq(err, nil, "could not update")
+	assertEq(n, 0, "updated rows of a")
+	err = c.dropColumn("orders", "tenant")
+	assert(errors.Is(err, errRowPolicyColumn), "dropped a policy column")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	assertEq(visible(""), "[[1 a 5] [2 a 50]]", "rows after b's delete")
+
+	// Without policies the table is unrestricted.
+	c = newClient(mos)
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.setRowPolicies("orders", nil)
+	assertEq(err, nil, "could not drop policies")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	assertEq(visible("c"), "[[1 a 5] [2 a 50]]", "rows of c without policies")
+}
+
+func TestRowFilter(t *testing.T) {
+	mos := newRowSecurityStorage()
+	c := newClient(mos, withIdentity("a"), withRowFilter(func(identity, table string) (*predicate, error) {
+		if table == "products" {
+			return nil, fmt.Errorf("%s can't read %s", identity, table)
+		}
+		return where("amount", OP_LT, 10), nil
+	}))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(fmt.Sprint(scanAll(&c, "orders")), "[[1 a 5]]", "rows of a under 10")
+	_, err = c.scan("products")
+	assert(err != nil, "scanned a refused table")
+	err = c.abortTx()
+	assertEq(err, nil, "could not abort")
+}
+
+func TestServerIdentities(t *testing.T) {
+	s := newServer(newRowSecurityStorage(), "secret")
+	s.identities = map[string]string{"token-a": "a", "token-b": "b"}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	status, _ := post(srv, "wrong", "/tx", nil)
+	assertEq(status, http.StatusUnauthorized, "status without token")
+
+	_, out := post(srv, "token-a", "/tx", nil)
+	tx := out["Tx"].(string)
+	status, out = post(srv, "token-a", "/tx/"+tx+"/tables/orders/scan", map[string]any{"Columns": []string{"id"}})
+	assertEq(status, http.StatusOK, fmt.Sprint("scan: ", out))
+	assertEq(fmt.Sprint(out["Rows"]), "[[1] [2]]", "rows of a")
+	status, _ = post(srv, "token-b", "/tx/"+tx+"/tables/orders/scan", nil)
+	assertEq(status, http.StatusNotFound, "used a's transaction as b")
+
+	status, out = post(srv, "token-b", "/sql", map[string]any{"Statement": "SELECT id FROM orders"})
+	assertEq(status, http.StatusOK, fmt.Sprint("select: ", out))
+	assertEq(fmt.Sprint(out["Rows"]), "[[3]]", "rows of b")
+	_, out = post(srv, "secret", "/sql", map[string]any{"Statement": "SELECT id FROM orders"})
+	assertEq(len(out["Rows"].([]any)), 3, "rows with the server's token")
+}
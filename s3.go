@@ -0,0 +1,376 @@
+package otf
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+type s3Config struct {
+	Bucket string
+	// Optional, all object names are stored under this prefix.
+	Prefix string
+	Region string
+	// Optional, e.g. http://localhost:9000 for MinIO. Defaults
+	// to AWS.
+	Endpoint string
+	// Address buckets as endpoint/bucket rather than
+	// bucket.endpoint. Most S3-compatible servers want this.
+	PathStyle bool
+
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Bill requests to the requester rather than the bucket owner,
+	// which requester-pays buckets require.
+	RequesterPays bool
+
+	// Storage class of objects written, e.g. STANDARD_IA, or empty
+	// for the bucket's default. StorageClassFor, if set, picks one
+	// per object instead (empty for StorageClass), e.g. a colder
+	// class for dataobjects of old partitions.
+	StorageClass    string
+	StorageClassFor func(name string) string
+
+	// Server-side encryption of objects written, S3_SSE_S3 or
+	// S3_SSE_KMS, or empty for the bucket's default. KMSKeyId is
+	// optional for SSE-KMS, defaulting to the account's AWS
+	// managed key.
+	ServerSideEncryption string
+	KMSKeyId             string
+
+	// Optional, each request is logged here at debug level.
+	Logger *slog.Logger
+}
+
+const (
+	S3_SSE_S3  = "AES256"
+	S3_SSE_KMS = "aws:kms"
+)
+
+// Fills in region and credentials from the usual AWS environment
+// variables when they aren't set explicitly.
+func (cfg s3Config) withEnvDefaults() s3Config {
+	if cfg.Region == "" {
+		cfg.Region = os.Getenv("AWS_REGION")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.AccessKeyId == "" {
+		cfg.AccessKeyId = os.Getenv("AWS_ACCESS_KEY_ID")
+		cfg.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		cfg.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+
+	return cfg
+}
+
+// S3 supports conditional writes with If-None-Match: * so
+// putIfAbsent is atomic here just as on the filesystem.
+type s3ObjectStorage struct {
+	cfg    s3Config
+	client *http.Client
+}
+
+func newS3ObjectStorage(cfg s3Config) *s3ObjectStorage {
+	cfg = cfg.withEnvDefaults()
+	assert(cfg.Bucket != "", "s3 bucket is required")
+	assert(cfg.ServerSideEncryption == "" || cfg.ServerSideEncryption == S3_SSE_S3 || cfg.ServerSideEncryption == S3_SSE_KMS,
+		fmt.Sprintf("unknown s3 server-side encryption: %s", cfg.ServerSideEncryption))
+	assert(cfg.KMSKeyId == "" || cfg.ServerSideEncryption == S3_SSE_KMS, "s3 KMS key requires SSE-KMS")
+	return &s3ObjectStorage{cfg, http.DefaultClient}
+}
+
+func (s3 *s3ObjectStorage) key(name string) string {
+	return s3.cfg.Prefix + name
+}
+
+func (s3 *s3ObjectStorage) url(key string, query url.Values) string {
+	endpoint := s3.cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", s3.cfg.Region)
+	}
+
+	u, err := url.Parse(endpoint)
+	assert(err == nil, fmt.Sprintf("invalid s3 endpoint: %s", err))
+	if s3.cfg.PathStyle {
+		u.Path = "/" + s3.cfg.Bucket + "/" + key
+	} else {
+		u.Host = s3.cfg.Bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (s3 *s3ObjectStorage) do(ctx context.Context, method, key string, query url.Values, body []byte, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s3.url(key, query), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if s3.cfg.RequesterPays {
+		req.Header.Set("X-Amz-Request-Payer", "requester")
+	}
+	s3.sign(req, body, time.Now().UTC())
+
+	return logRequest(s3.cfg.Logger, "s3", s3.client, req)
+}
+
+func s3Error(res *http.Response) error {
+	body, _ := io.ReadAll(res.Body)
+	return fmt.Errorf("s3 %s %s: %s: %s", res.Request.Method, res.Request.URL.Path, res.Status, body)
+}
+
+func (s3 *s3ObjectStorage) putIfAbsent(ctx context.Context, name string, bytes []byte) error {
+	headers := map[string]string{
+		"If-None-Match": "*",
+	}
+
+	storageClass := s3.cfg.StorageClass
+	if s3.cfg.StorageClassFor != nil {
+		storageClass = cmp.Or(s3.cfg.StorageClassFor(name), storageClass)
+	}
+	if storageClass != "" {
+		headers["X-Amz-Storage-Class"] = storageClass
+	}
+
+	if s3.cfg.ServerSideEncryption != "" {
+		headers["X-Amz-Server-Side-Encryption"] = s3.cfg.ServerSideEncryption
+	}
+	if s3.cfg.KMSKeyId != "" {
+		headers["X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"] = s3.cfg.KMSKeyId
+	}
+
+	res, err := s3.do(ctx, http.MethodPut, s3.key(name), nil, bytes, headers)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		// 409 is returned when a concurrent conditional
+		// write to the same key is in flight. Either way,
+		// somebody else got there first.
+		return fmt.Errorf("%w: %s", fs.ErrExist, name)
+	}
+
+	return s3Error(res)
+}
+
+type s3ListBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s3 *s3ObjectStorage) listPrefix(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", s3.key(prefix))
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		res, err := s3.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode != http.StatusOK {
+			err = s3Error(res)
+			res.Body.Close()
+			return nil, err
+		}
+
+		var result s3ListBucketResult
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range result.Contents {
+			names = append(names, strings.TrimPrefix(c.Key, s3.cfg.Prefix))
+		}
+
+		if !result.IsTruncated {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	// S3 already lists in lexicographic order but don't rely on
+	// every compatible server doing so.
+	slices.Sort(names)
+	return names, nil
+}
+
+func (s3 *s3ObjectStorage) read(ctx context.Context, name string) ([]byte, error) {
+	res, err := s3.do(ctx, http.MethodGet, s3.key(name), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", fs.ErrNotExist, name)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, s3Error(res)
+	}
+
+	return io.ReadAll(res.Body)
+}
+
+func (s3 *s3ObjectStorage) readRange(ctx context.Context, name string, offset, length int64) ([]byte, error) {
+	res, err := s3.do(ctx, http.MethodGet, s3.key(name), nil, nil, map[string]string{
+		"Range": fmt.Sprintf("bytes=%d-%d", offset, offset+length-1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", fs.ErrNotExist, name)
+	}
+
+	if res.StatusCode != http.StatusPartialContent {
+		return nil, s3Error(res)
+	}
+
+	return io.ReadAll(res.Body)
+}
+
+func (s3 *s3ObjectStorage) delete(ctx context.Context, name string) error {
+	res, err := s3.do(ctx, http.MethodDelete, s3.key(name), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusOK {
+		return s3Error(res)
+	}
+
+	return nil
+}
+
+func (s3 *s3ObjectStorage) stat(ctx context.Context, name string) (objectInfo, error) {
+	res, err := s3.do(ctx, http.MethodHead, s3.key(name), nil, nil, nil)
+	if err != nil {
+		return objectInfo{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return objectInfo{}, fmt.Errorf("%w: %s", fs.ErrNotExist, name)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return objectInfo{}, s3Error(res)
+	}
+
+	// Unparseable means unknown, i.e. old enough.
+	modified, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	return objectInfo{Size: res.ContentLength, Modified: modified}, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+func (s3 *s3ObjectStorage) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s3.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s3.cfg.SessionToken)
+	}
+
+	if s3.cfg.AccessKeyId == "" {
+		// Anonymous access, e.g. public buckets or local
+		// test servers.
+		return
+	}
+
+	var signedHeaders []string
+	for k := range req.Header {
+		signedHeaders = append(signedHeaders, strings.ToLower(k))
+	}
+	slices.Sort(signedHeaders)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range signedHeaders {
+		canonicalHeaders.WriteString(k + ":" + strings.TrimSpace(req.Header.Get(k)) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		// Encode() sorts by key as required.
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s3.cfg.Region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s3.cfg.SecretAccessKey), date)
+	key = hmacSHA256(key, s3.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s3.cfg.AccessKeyId, scope, strings.Join(signedHeaders, ";"), signature))
+}
@@ -0,0 +1,174 @@
+package otf
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Just enough of the S3 API for s3ObjectStorage, with path-style
+// addressing.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	// Headers objects were put with.
+	headers map[string]http.Header
+	// Refuse requests not paid for by the requester.
+	requesterPays bool
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if f.requesterPays && r.Header.Get("X-Amz-Request-Payer") != "requester" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	_, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	switch {
+	case r.Method == http.MethodPut:
+		if _, ok := f.objects[key]; ok && r.Header.Get("If-None-Match") == "*" {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		f.objects[key] = body
+		if f.headers != nil {
+			f.headers[key] = r.Header.Clone()
+		}
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		var result s3ListBucketResult
+		var keys []string
+		for k := range f.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			result.Contents = append(result.Contents, struct{ Key string }{k})
+		}
+		xml.NewEncoder(w).Encode(result)
+	case r.Method == http.MethodGet:
+		body, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Write(body)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3ConcurrentTableWriters(t *testing.T) {
+	server := httptest.NewServer(&fakeS3{objects: map[string][]byte{}})
+	defer server.Close()
+
+	s3 := newS3ObjectStorage(s3Config{
+		Bucket:          "bucket",
+		Prefix:          "db/",
+		Endpoint:        server.URL,
+		PathStyle:       true,
+		AccessKeyId:     "id",
+		SecretAccessKey: "secret",
+	})
+	c1Writer := newClient(s3)
+	c2Writer := newClient(s3)
+
+	err := c2Writer.newTx()
+	assertEq(err, nil, "could not start c2 tx")
+
+	err = c1Writer.newTx()
+	assertEq(err, nil, "could not start c1 tx")
+	err = c1Writer.createTable("x", []string{"a", "b"})
+	assertEq(err, nil, "could not create x")
+	err = c1Writer.writeRow("x", []any{"Joey", 1})
+	assertEq(err, nil, "could not write row")
+	err = c1Writer.commitTx()
+	assertEq(err, nil, "could not commit c1 tx")
+
+	err = c2Writer.createTable("x", []string{"a", "b"})
+	assertEq(err, nil, "could not create x")
+	err = c2Writer.commitTx()
+	assert(err != nil, "concurrent commit must fail")
+
+	assertEq(countRows(&c2Writer, "x"), 1, "rows in x")
+}
+
+func TestS3Options(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}, headers: map[string]http.Header{}, requesterPays: true}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	cfg := s3Config{
+		Bucket:          "bucket",
+		Endpoint:        server.URL,
+		PathStyle:       true,
+		AccessKeyId:     "id",
+		SecretAccessKey: "secret",
+	}
+
+	// Requester-pays buckets refuse anyone else.
+	c := newClient(newS3ObjectStorage(cfg))
+	err := c.newTx()
+	assert(err != nil, "expected requester-pays bucket to refuse")
+
+	cfg.RequesterPays = true
+	cfg.StorageClass = "STANDARD_IA"
+	cfg.StorageClassFor = func(name string) string {
+		if strings.HasPrefix(name, "_log_") {
+			return "STANDARD"
+		}
+		return ""
+	}
+	cfg.ServerSideEncryption = S3_SSE_KMS
+	cfg.KMSKeyId = "key"
+	c = newClient(newS3ObjectStorage(cfg))
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	assertEq(countRows(&c, "x"), 1, "rows in x")
+
+	for key, headers := range fake.headers {
+		class := "STANDARD_IA"
+		if strings.HasPrefix(key, "_log_") {
+			class = "STANDARD"
+		}
+		assertEq(headers.Get("X-Amz-Storage-Class"), class, "storage class of "+key)
+		assertEq(headers.Get("X-Amz-Server-Side-Encryption"), S3_SSE_KMS, "encryption of "+key)
+		assertEq(headers.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"), "key", "key of "+key)
+	}
+	assertEq(len(fake.headers), 2, "objects")
+
+	storage, err := openObjectStorage("s3://bucket/prefix/?requester_pays=true&storage_class=GLACIER_IR&sse=AES256")
+	assertEq(err, nil, "could not open storage")
+	opened := storage.(*s3ObjectStorage).cfg
+	assert(opened.RequesterPays, "expected requester pays")
+	assertEq(opened.StorageClass, "GLACIER_IR", "storage class")
+	assertEq(opened.ServerSideEncryption, S3_SSE_S3, "encryption")
+
+	_, err = openObjectStorage("s3://bucket/?sse=nope")
+	assert(err != nil, "expected unknown encryption to fail")
+	_, err = openObjectStorage("s3://bucket/?sse=AES256&kms_key_id=key")
+	assert(err != nil, "expected key without SSE-KMS to fail")
+}
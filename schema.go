@@ -0,0 +1,331 @@
+package otf
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Columns can be added, dropped and renamed after a table is
+// created. Each change is a ChangeMetadata action recording the
+// table's new columns as a new schema version, along with ids that
+// follow each column across renames. Dataobjects record the schema
+// version they were written with and are never rewritten for a
+// schema change. Instead they are projected into the latest schema
+// when read: dropped columns are left out and columns added since
+// are null.
+//
+// Partition columns can't be dropped or renamed, nor can dedupe key
+// columns (see dedupe.go), bloom filter columns (see bloomindex.go)
+// or sort columns (see cluster.go), nor columns in foreign keys (see
+// foreignkey.go), constrained (see constraints.go), in row policies
+// (see rowsecurity.go) or with or in defaults (see defaults.go).
+
+const (
+	SCHEMA_ADD_COLUMN    = "AddColumn"
+	SCHEMA_DROP_COLUMN   = "DropColumn"
+	SCHEMA_RENAME_COLUMN = "RenameColumn"
+)
+
+var (
+	errColumnExists    = fmt.Errorf("Column Exists")
+	errPartitionColumn = fmt.Errorf("Partition Column")
+)
+
+type tableSchema struct {
+	Columns []string
+	Ids     []int
+	// See types.go, nil if no column is typed.
+	Types []string
+}
+
+// A table's schemas by version.
+type schemaHistory []tableSchema
+
+func (h schemaHistory) record(mtd *ChangeMetadataAction) schemaHistory {
+	ids := mtd.ColumnIds
+	if ids == nil {
+		// As created.
+		for i := range mtd.Columns {
+			ids = append(ids, i)
+		}
+	}
+
+	return append(h[:min(mtd.SchemaVersion, len(h))], tableSchema{mtd.Columns, ids, mtd.ColumnTypes})
+}
+
+func (h schemaHistory) version() int {
+	return len(h) - 1
+}
+
+// For each column of the latest schema, its position in rows
+// written with the given version or -1 if it didn't exist then. nil
+// if nothing changed since.
+func (h schemaHistory) mapping(version int) []int {
+	if version >= h.version() {
+		return nil
+	}
+
+	var mapping []int
+	for _, id := range h[len(h)-1].Ids {
+		mapping = append(mapping, slices.Index(h[version].Ids, id))
+	}
+
+	return mapping
+}
+
+// Returns a function converting rows written with the given version
+// into the latest schema and its types, or nil if there's nothing to
+// convert.
+func (h schemaHistory) converter(version int) func(*batch) *batch {
+	mapping := h.mapping(version)
+	var types []string
+	if len(h) > 0 {
+		types = h[len(h)-1].Types
+	}
+
+	if mapping == nil && types == nil {
+		return nil
+	}
+
+	return func(b *batch) *batch {
+		return b.evolve(mapping).coerce(types)
+	}
+}
+
+// row, written with the given version, in the latest schema and its
+// types.
+func (h schemaHistory) convertRow(version int, row []any) []any {
+	convert := h.converter(version)
+	if convert == nil {
+		return row
+	}
+
+	b := newBatch(len(row))
+	b.appendRow(row)
+	return convert(b).row(0)
+}
+
+// b as rows of the latest schema, given mapping (see
+// schemaHistory.mapping).
+func (b *batch) evolve(mapping []int) *batch {
+	if mapping == nil {
+		return b
+	}
+
+	evolved := &batch{Len: b.Len}
+	for _, from := range mapping {
+		if from == -1 {
+			evolved.Columns = append(evolved.Columns, make([]any, b.Len))
+		} else {
+			evolved.Columns = append(evolved.Columns, b.Columns[from])
+		}
+	}
+
+	return evolved
+}
+
+// Stats of rows written with the given version (of a dataobject or
+// one of its row groups) keyed by the columns of the latest schema.
+func (h schemaHistory) evolveStats(version int, written map[string]columnStats, rows int) map[string]columnStats {
+	mapping := h.mapping(version)
+	if mapping == nil || written == nil {
+		return written
+	}
+
+	stats := map[string]columnStats{}
+	for i, from := range mapping {
+		column := h[len(h)-1].Columns[i]
+		if from == -1 {
+			stats[column] = columnStats{Nulls: rows}
+		} else if s, ok := written[h[version].Columns[from]]; ok {
+			stats[column] = s
+		}
+	}
+
+	return stats
+}
+
+// ChangeMetadata actions recreating every version of table's
+// schema, since dataobjects may have been written with any of them.
+func (tx *transaction) schemaActions(table string) []Action {
+	var actions []Action
+	for version, schema := range tx.schemas[table] {
+		mtd := &ChangeMetadataAction{
+			Table:            table,
+			Columns:          schema.Columns,
+			PartitionColumns: tx.partitions[table],
+			SchemaVersion:    version,
+			ColumnTypes:      schema.Types,
+			Location:         tx.locations[table],
+			ComplianceWindow: tx.complianceWindows[table],
+			Dedupe:           tx.dedupe[table],
+			BloomColumns:     tx.bloomColumns[table],
+			SortOrder:        tx.sortOrders[table],
+			ColumnCodecs:     tx.columnCodecs[table],
+			ColumnFamilies:   tx.columnFamilies[table],
+			ForeignKeys:      tx.foreignKeys[table],
+			NotNull:          tx.notNull[table],
+			PrimaryKey:       tx.primaryKeys[table],
+			RowPolicies:      tx.rowPolicies[table],
+			ColumnDefaults:   tx.columnDefaults[table],
+			Sequences:        tx.sequences[table],
+		}
+		if version > 0 {
+			mtd.ColumnIds = schema.Ids
+		}
+
+		actions = append(actions, Action{ChangeMetadata: mtd})
+	}
+
+	return actions
+}
+
+// Records a new version of table's schema.
+func (d *client) alterTable(table, op, column string, schema tableSchema) error {
+	if err := d.checkWritable(); err != nil {
+		return err
+	}
+	if d.logFormat == LOG_FORMAT_DELTA && op != SCHEMA_ADD_COLUMN {
+		return fmt.Errorf("%w: %s", errDeltaLog, op)
+	}
+
+	// Rows written so far belong to the old schema.
+	err := d.flushRows(table)
+	if err != nil {
+		return err
+	}
+	d.tx.unflushedData[table] = newBatch(len(schema.Columns))
+
+	mtd := &ChangeMetadataAction{
+		Table:            table,
+		Columns:          schema.Columns,
+		PartitionColumns: d.tx.partitions[table],
+		Op:               op,
+		Column:           column,
+		SchemaVersion:    d.tx.schemas[table].version() + 1,
+		ColumnIds:        schema.Ids,
+		ColumnTypes:      schema.Types,
+	}
+	d.tx.tables[table] = schema.Columns
+	d.tx.schemas[table] = d.tx.schemas[table].record(mtd)
+	d.tx.Actions[table] = append(d.tx.Actions[table], Action{ChangeMetadata: mtd})
+
+	d.debug("schema", "altered table", "table", table, "op", op, "column", column)
+	return nil
+}
+
+// The latest schema of table, checking column is or isn't in it.
+func (d *client) currentSchema(table, column string, exists bool) (tableSchema, int, error) {
+	if d.tx == nil {
+		return tableSchema{}, 0, errNoTx
+	}
+
+	history, ok := d.tx.schemas[table]
+	if !ok {
+		return tableSchema{}, 0, errNoTable
+	}
+
+	latest := history[len(history)-1]
+	i := slices.Index(latest.Columns, column)
+	if exists && i == -1 {
+		return tableSchema{}, 0, fmt.Errorf("%w: %s.%s", errNoColumn, table, column)
+	}
+	if !exists && i != -1 {
+		return tableSchema{}, 0, fmt.Errorf("%w: %s.%s", errColumnExists, table, column)
+	}
+
+	if exists && slices.Contains(d.tx.partitions[table], column) {
+		return tableSchema{}, 0, fmt.Errorf("%w: %s.%s", errPartitionColumn, table, column)
+	}
+
+	if dedupe := d.tx.dedupe[table]; exists && dedupe != nil && slices.Contains(dedupe.Columns, column) {
+		return tableSchema{}, 0, fmt.Errorf("%w: %s.%s", errDedupeColumn, table, column)
+	}
+
+	if exists && slices.Contains(d.tx.bloomColumns[table], column) {
+		return tableSchema{}, 0, fmt.Errorf("%w: %s.%s", errBloomColumn, table, column)
+	}
+
+	if order := d.tx.sortOrders[table]; exists && order != nil && slices.Contains(order.Columns, column) {
+		return tableSchema{}, 0, fmt.Errorf("%w: %s.%s", errSortColumn, table, column)
+	}
+
+	if exists && slices.ContainsFunc(d.tx.columnFamilies[table], func(family []string) bool {
+		return slices.Contains(family, column)
+	}) {
+		return tableSchema{}, 0, fmt.Errorf("%w: %s.%s", errFamilyColumn, table, column)
+	}
+
+	if exists && d.tx.foreignKeyColumn(table, column) {
+		return tableSchema{}, 0, fmt.Errorf("%w: %s.%s", errForeignKeyColumn, table, column)
+	}
+
+	if exists && d.tx.constraintColumn(table, column) {
+		return tableSchema{}, 0, fmt.Errorf("%w: %s.%s", errConstraintColumn, table, column)
+	}
+
+	if exists && d.tx.rowPolicyColumn(table, column) {
+		return tableSchema{}, 0, fmt.Errorf("%w: %s.%s", errRowPolicyColumn, table, column)
+	}
+
+	if exists && d.tx.defaultColumn(table, column) {
+		return tableSchema{}, 0, fmt.Errorf("%w: %s.%s", errColumnDefault, table, column)
+	}
+
+	return tableSchema{slices.Clone(latest.Columns), slices.Clone(latest.Ids), slices.Clone(latest.Types)}, i, nil
+}
+
+// Adds a column to the end of table. Existing rows are null in it.
+func (d *client) addColumn(table, column string) error {
+	schema, _, err := d.currentSchema(table, column, false)
+	if err != nil {
+		return err
+	}
+
+	// Ids are never reused, even for dropped columns.
+	id := 0
+	for _, s := range d.tx.schemas[table] {
+		for _, existing := range s.Ids {
+			id = max(id, existing+1)
+		}
+	}
+
+	schema.Columns = append(schema.Columns, column)
+	schema.Ids = append(schema.Ids, id)
+	if schema.Types != nil {
+		schema.Types = append(schema.Types, COLUMN_ANY)
+	}
+	return d.alterTable(table, SCHEMA_ADD_COLUMN, column, schema)
+}
+
+func (d *client) dropColumn(table, column string) error {
+	schema, i, err := d.currentSchema(table, column, true)
+	if err != nil {
+		return err
+	}
+
+	if len(schema.Columns) == 1 {
+		return fmt.Errorf("cannot drop the only column of %s", table)
+	}
+
+	schema.Columns = slices.Delete(schema.Columns, i, i+1)
+	schema.Ids = slices.Delete(schema.Ids, i, i+1)
+	if schema.Types != nil {
+		schema.Types = slices.Delete(schema.Types, i, i+1)
+	}
+	return d.alterTable(table, SCHEMA_DROP_COLUMN, column, schema)
+}
+
+func (d *client) renameColumn(table, column, newName string) error {
+	schema, i, err := d.currentSchema(table, column, true)
+	if err != nil {
+		return err
+	}
+
+	if slices.Contains(schema.Columns, newName) {
+		return fmt.Errorf("%w: %s.%s", errColumnExists, table, newName)
+	}
+
+	schema.Columns[i] = newName
+	return d.alterTable(table, SCHEMA_RENAME_COLUMN, column, schema)
+}
@@ -0,0 +1,116 @@
+package otf
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func scanAll(c *client, table string, opts ...scanOption) [][]any {
+	it, err := c.scan(table, opts...)
+	assertEq(err, nil, "could not scan")
+
+	var rows [][]any
+	for {
+		row, err := it.next()
+		assertEq(err, nil, "could not read row")
+		if row == nil {
+			return rows
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestSchemaEvolution(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a", "b"})
+	assertEq(err, nil, "could not create x")
+	err = c.writeRow("x", []any{1, "one"})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{2, "two"})
+	assertEq(err, nil, "could not write row")
+	err = c.addColumn("x", "c")
+	assertEq(err, nil, "could not add column")
+	err = c.writeRow("x", []any{3, "three", true})
+	assertEq(err, nil, "could not write row")
+	err = c.renameColumn("x", "a", "n")
+	assertEq(err, nil, "could not rename column")
+	err = c.dropColumn("x", "b")
+	assertEq(err, nil, "could not drop column")
+	// Not the same column as the one dropped.
+	err = c.addColumn("x", "b")
+	assertEq(err, nil, "could not add column")
+	err = c.writeRow("x", []any{4, false, "four"})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(c.tx.schemas["x"]), 5, "schema versions")
+	rows := scanAll(&c, "x", withColumns("n", "c", "b"))
+	assertEq(len(rows), 4, "rows")
+	for _, row := range rows {
+		n := row[0].(float64)
+		assertEq[any](row[1], map[float64]any{1: nil, 2: nil, 3: true, 4: false}[n], "c")
+		assertEq[any](row[2], map[float64]any{4: "four"}[n], "b")
+	}
+
+	// Stats of old dataobjects are by their old column names.
+	rows = scanAll(&c, "x", withFilter(where("n", OP_LT, 3)))
+	assertEq(len(rows), 2, "rows with n < 3")
+	rows = scanAll(&c, "x", withFilter(where("b", OP_EQ, "one")))
+	assertEq(len(rows), 0, "rows with the new b")
+
+	err = c.addColumn("x", "n")
+	assert(errors.Is(err, errColumnExists), "existing column")
+	err = c.dropColumn("x", "a")
+	assert(errors.Is(err, errNoColumn), "dropped column")
+	err = c.renameColumn("x", "c", "b")
+	assert(errors.Is(err, errColumnExists), "rename onto existing column")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	changes, err := c.tableChangesSince("x", -1)
+	assertEq(err, nil, "could not read changes")
+	assertEq(len(changes[0][0].Row), 3, "changes in latest schema")
+	assertEq[any](changes[0][0].Row[0], 1.0, "change")
+
+	// Snapshots keep every schema version.
+	dst := newMemoryObjectStorage()
+	_, err = c.publish(dst)
+	assertEq(err, nil, "could not publish")
+	server := httptest.NewServer(staticHandler(dst))
+	defer server.Close()
+
+	hos, err := openObjectStorage(server.URL + "/")
+	assertEq(err, nil, "could not open bundle")
+	published := newClient(hos)
+	err = published.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq(len(scanAll(&published, "x", withFilter(where("c", OP_EQ, true)))), 1, "published rows")
+}
+
+func TestSchemaEvolutionPartitionColumns(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"day", "a"}, withPartitionColumns("day"))
+	assertEq(err, nil, "could not create x")
+	err = c.dropColumn("x", "day")
+	assert(errors.Is(err, errPartitionColumn), "drop partition column")
+	err = c.renameColumn("x", "day", "date")
+	assert(errors.Is(err, errPartitionColumn), "rename partition column")
+	err = c.dropColumn("x", "a")
+	assertEq(err, nil, "could not drop column")
+	err = c.dropColumn("y", "a")
+	assert(errors.Is(err, errNoTable), "no table")
+}
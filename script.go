@@ -0,0 +1,310 @@
+package otf
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Scripts run several SQL statements (see sql.go) in order, e.g. an
+// operational runbook run with `otf script`. Statements end with a
+// semicolon, and -- starts a comment running to the end of the line.
+// On top of single statements scripts have:
+//
+//   - transactions: statements between BEGIN and COMMIT or ROLLBACK
+//     run in one transaction, and every other statement in one of its
+//     own, as with execSQL. A script that ends with a transaction
+//     still open rolls it back and fails;
+//   - variables: `SET name = value` sets name to a literal, another
+//     variable, or the first column of the first row of a SELECT
+//     (NULL if it has none), and :name anywhere a value can go in a
+//     later statement stands for its value. Variables can also be
+//     set before the script starts, see withScriptVar;
+//   - error modes: by default a script stops at the first statement
+//     that fails, rolling back its open transaction if any. With
+//     withContinueOnError it goes on to the next statement instead,
+//     except that a failure inside a transaction rolls it back and
+//     skips the rest of its statements, through its COMMIT or
+//     ROLLBACK, like databases that abort a transaction on error.
+//
+// Scripts run with a context (see context.go), and stop before the
+// next statement once it's done, rolling back an open transaction.
+
+const (
+	SCRIPT_ON_ERROR_STOP     = "stop"
+	SCRIPT_ON_ERROR_CONTINUE = "continue"
+)
+
+var errScript = fmt.Errorf("Script Failed")
+
+type scriptOptions struct {
+	onError string
+	vars    map[string]any
+}
+
+type scriptOption func(*scriptOptions)
+
+// Runs the rest of a script after a statement fails, see above.
+func withContinueOnError() scriptOption {
+	return func(o *scriptOptions) {
+		o.onError = SCRIPT_ON_ERROR_CONTINUE
+	}
+}
+
+// Sets the variable name to value before the script starts.
+func withScriptVar(name string, value any) scriptOption {
+	return func(o *scriptOptions) {
+		o.vars[name] = value
+	}
+}
+
+// A statement of a script and how running it went.
+type scriptStatement struct {
+	// The line the statement starts on, from 1.
+	Line int
+	Text string
+	// Set if it ran without error.
+	Result *sqlResult `json:",omitempty"`
+	Error  string     `json:",omitempty"`
+	// Whether it didn't run because an earlier statement of its
+	// transaction failed.
+	Skipped bool `json:",omitempty"`
+}
+
+type scriptResult struct {
+	// Statements run, failed or skipped so far, in order.
+	Statements []scriptStatement
+	// Statements that failed.
+	Failed int
+	// Variables as of the end of the script.
+	Vars map[string]any
+}
+
+// Splits text into statements, leaving out comments and empty ones.
+func splitSQLScript(text string) []scriptStatement {
+	var statements []scriptStatement
+	var b strings.Builder
+	rs := []rune(text)
+	line, start := 1, 0
+	flush := func() {
+		if start != 0 {
+			statements = append(statements, scriptStatement{Line: start, Text: strings.TrimSpace(b.String())})
+		}
+		b.Reset()
+		start = 0
+	}
+
+	var quote rune
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		if r == '\n' {
+			line++
+		}
+		if start == 0 && !unicode.IsSpace(r) && r != ';' && !(r == '-' && i+1 < len(rs) && rs[i+1] == '-') {
+			start = line
+		}
+
+		switch {
+		case quote != 0:
+			// A doubled quote closes and reopens it.
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '-' && i+1 < len(rs) && rs[i+1] == '-':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+			if i < len(rs) {
+				line++
+				b.WriteRune('\n')
+			}
+			continue
+		case r == ';':
+			flush()
+			continue
+		}
+		b.WriteRune(r)
+	}
+	flush()
+
+	return statements
+}
+
+// Replaces each :name in text outside quotes with a placeholder,
+// returning the values they stand for in order.
+func bindScriptVars(text string, vars map[string]any) (string, []any, error) {
+	var b strings.Builder
+	var args []any
+	seen := map[string]int{}
+	rs := []rune(text)
+	var quote rune
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == ':' && i+1 < len(rs) && (unicode.IsLetter(rs[i+1]) || rs[i+1] == '_'):
+			j := i + 1
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_') {
+				j++
+			}
+
+			name := string(rs[i+1 : j])
+			value, ok := vars[name]
+			if !ok {
+				return "", nil, fmt.Errorf("%w: unknown variable :%s", errInvalidParameter, name)
+			}
+			if _, ok := seen[name]; !ok {
+				args = append(args, value)
+				seen[name] = len(args)
+			}
+			fmt.Fprintf(&b, "$%d", seen[name])
+			i = j - 1
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String(), args, nil
+}
+
+// Runs one statement of a script, or sets a variable, see above.
+func (d *client) execScriptStatement(ctx context.Context, text string, vars map[string]any) (*sqlResult, error) {
+	query, args, err := bindScriptVars(text, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := lexSQL(query)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &sqlParser{tokens: tokens}
+	if !p.acceptKeyword("SET") {
+		return d.execSQLContext(ctx, query, args...)
+	}
+
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	err = p.expectSymbol("=")
+	if err != nil {
+		return nil, err
+	}
+
+	if p.isKeyword(p.peek(), "SELECT") {
+		_, rest, _ := strings.Cut(query, "=")
+		result, err := d.execSQLContext(ctx, rest, args...)
+		if err != nil {
+			return nil, err
+		}
+
+		vars[name] = nil
+		if len(result.Rows) > 0 && len(result.Rows[0]) > 0 {
+			vars[name] = result.Rows[0][0]
+		}
+		return &sqlResult{}, nil
+	}
+
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	p.acceptSymbol(";")
+	if p.peek().Kind != SQL_EOF {
+		return nil, p.unexpected()
+	}
+	if ph, ok := v.(placeholder); ok {
+		v = args[ph.Index-1]
+	}
+
+	vars[name] = v
+	return &sqlResult{}, nil
+}
+
+// Runs the statements of text in order, see above. Fails, along with
+// what ran so far, if a statement fails and the script stops, with
+// errScript if it continued past failures, or with errIncomplete if
+// ctx ended first.
+func (d *client) execScript(ctx context.Context, text string, opts ...scriptOption) (*scriptResult, error) {
+	o := scriptOptions{onError: SCRIPT_ON_ERROR_STOP, vars: map[string]any{}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	statements := splitSQLScript(text)
+	result := &scriptResult{Vars: o.vars}
+	inTx := false
+	// Whether the open transaction failed and its statements are
+	// skipped until its end.
+	aborted := false
+	for i, s := range statements {
+		if ctx.Err() != nil {
+			if inTx && !aborted {
+				d.abortTx()
+			}
+			return result, incomplete(ctx, len(statements)-i, "statements")
+		}
+
+		kind := strings.ToUpper(strings.Fields(s.Text)[0])
+		ends := kind == "COMMIT" || kind == "ROLLBACK"
+
+		if aborted {
+			s.Skipped = true
+			result.Statements = append(result.Statements, s)
+			if ends {
+				inTx, aborted = false, false
+			}
+			continue
+		}
+
+		r, err := d.execScriptStatement(ctx, s.Text, o.vars)
+		if err == nil {
+			s.Result = r
+			result.Statements = append(result.Statements, s)
+			switch {
+			case kind == "BEGIN":
+				inTx = true
+			case ends:
+				inTx = false
+			}
+			continue
+		}
+
+		s.Error = err.Error()
+		result.Statements = append(result.Statements, s)
+		result.Failed++
+		d.debug("sql", "script statement failed", "line", s.Line, "err", err)
+
+		// A failed COMMIT already ended the transaction.
+		if inTx && !ends && d.tx != nil {
+			d.abortTx()
+		}
+		if o.onError == SCRIPT_ON_ERROR_STOP {
+			return result, fmt.Errorf("line %d: %w", s.Line, err)
+		}
+		aborted = inTx && !ends
+		inTx = inTx && !ends
+	}
+
+	if inTx {
+		if !aborted {
+			d.abortTx()
+		}
+		result.Failed++
+		return result, fmt.Errorf("%w: transaction still open at the end, rolled back", errScript)
+	}
+	if result.Failed > 0 {
+		return result, fmt.Errorf("%w: %d statements failed", errScript, result.Failed)
+	}
+	return result, nil
+}
@@ -0,0 +1,89 @@
+package otf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSplitSQLScript(t *testing.T) {
+	statements := splitSQLScript(`-- a runbook
+BEGIN;
+INSERT INTO x VALUES (1, 'a;b'); -- trailing
+INSERT INTO x
+  VALUES (2, 'it''s -- not a comment');
+;
+COMMIT`)
+	assertEq(len(statements), 4, "statements")
+	assertEq(statements[0].Text, "BEGIN", "first")
+	assertEq(statements[0].Line, 2, "first line")
+	assertEq(statements[1].Text, "INSERT INTO x VALUES (1, 'a;b')", "second")
+	assertEq(statements[2].Line, 4, "third line")
+	assertEq(statements[2].Text, "INSERT INTO x\n  VALUES (2, 'it''s -- not a comment')", "third")
+	assertEq(statements[3].Line, 7, "last line")
+}
+
+func TestExecScript(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	result, err := c.execScript(context.Background(), `
+CREATE TABLE x (a INT, b STRING);
+BEGIN;
+INSERT INTO x VALUES (1, 'one'), (2, 'two');
+SET b = SELECT b FROM x WHERE a = :a;
+SET c = :b;
+INSERT INTO x VALUES (3, :c);
+COMMIT;
+SELECT a FROM x WHERE b = :c;
+`, withScriptVar("a", 2))
+	assertEq(err, nil, "could not run script")
+	assertEq(len(result.Statements), 8, "statements")
+	assertEq(result.Vars["b"], any("two"), "variable from select")
+	assertEq(fmt.Sprint(result.Statements[7].Result.Rows), "[[2] [3]]", "rows")
+
+	// Stops at the failure, rolling back its transaction.
+	result, err = c.execScript(context.Background(), `
+BEGIN;
+INSERT INTO x VALUES (4, 'four');
+INSERT INTO y VALUES (5);
+COMMIT;
+INSERT INTO x VALUES (6, 'six');
+`)
+	assert(errors.Is(err, errNoTable), "expected no table error")
+	assert(strings.HasPrefix(err.Error(), "line 4: "), "error line: "+err.Error())
+	assertEq(len(result.Statements), 3, "statements run")
+	assert(c.tx == nil, "transaction left open")
+	sel, err := c.execSQL("SELECT a FROM x")
+	assertEq(err, nil, "could not select")
+	assertEq(len(sel.Rows), 3, "rows after stopping")
+
+	// Continuing skips the rest of the failed transaction.
+	result, err = c.execScript(context.Background(), `
+BEGIN;
+INSERT INTO y VALUES (5);
+INSERT INTO x VALUES (4, 'four');
+COMMIT;
+INSERT INTO x VALUES (6, :unknown);
+INSERT INTO x VALUES (7, 'seven');
+`, withContinueOnError())
+	assert(errors.Is(err, errScript), "expected script error")
+	assertEq(result.Failed, 2, "failed")
+	assert(result.Statements[2].Skipped && result.Statements[3].Skipped, "expected skipped")
+	assert(strings.Contains(result.Statements[4].Error, "unknown variable :unknown"), "variable error: "+result.Statements[4].Error)
+	sel, err = c.execSQL("SELECT a FROM x")
+	assertEq(err, nil, "could not select")
+	assertEq(len(sel.Rows), 4, "rows after continuing")
+
+	_, err = c.execScript(context.Background(), "BEGIN; INSERT INTO x VALUES (8, 'eight')")
+	assert(errors.Is(err, errScript), "expected open transaction error")
+	assert(c.tx == nil, "transaction left open")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.execScript(ctx, "INSERT INTO x VALUES (9, 'nine')")
+	assert(errors.Is(err, errIncomplete), "expected incomplete")
+	sel, err = c.execSQL("SELECT a FROM x")
+	assertEq(err, nil, "could not select")
+	assertEq(len(sel.Rows), 4, "rows at the end")
+}
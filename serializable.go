@@ -0,0 +1,106 @@
+package otf
+
+import (
+	"fmt"
+)
+
+// Snapshot isolation, even with read validation (see readset.go),
+// only checks that what a transaction read wasn't changed since it
+// started, not that rows it would have read weren't added. Two
+// read-modify-write transactions can each read a range, see the
+// other's insert missing, and both commit: write skew. For example
+// two transactions each checking that at least one doctor is on
+// call before taking themselves off.
+//
+// Serializable transactions also record the filter of every scan,
+// by table, and before committing after others check that none of
+// the dataobjects those added to a table they scanned has a row
+// matching one of its filters. Dataobjects are checked against
+// their statistics first (see predicate.go's mayMatch), and only
+// read when those can't rule a filter out. A scan without a filter
+// conflicts with any rows added to its table.
+//
+// Together with read validation, which they imply, that fails any
+// transaction whose scans would have returned different rows had it
+// started after the transactions committed since, so committing it
+// after them is the same as if it had.
+
+// Validates transactions' scans against rows committed since they
+// started as well as their reads, see above.
+func withSerializable() clientOption {
+	return func(c *client) {
+		c.readValidation = true
+		c.serializable = true
+	}
+}
+
+// A scan of a table by a serializable transaction.
+type readPredicate struct {
+	// Nil when the scan had no filter.
+	filter *predicate
+	keep   func(*batch, int) bool
+}
+
+// Records that the transaction scanned table for rows matching
+// filter, if it's serializable.
+func (tx *transaction) markPredicateRead(table string, filter *predicate, keep func(*batch, int) bool) {
+	if tx.readPredicates == nil {
+		return
+	}
+
+	tx.readPredicates[table] = append(tx.readPredicates[table], readPredicate{filter, keep})
+}
+
+// The table on which committed added rows the transaction's scans
+// would have returned, if any.
+func (d *client) phantomIn(committed *logEntry) (string, bool, error) {
+	for table, scans := range d.tx.readPredicates {
+		for _, action := range committed.Actions[table] {
+			if action.AddDataobject == nil {
+				continue
+			}
+
+			matched, err := d.scansMatch(table, action.AddDataobject, scans)
+			if err != nil {
+				return "", false, err
+			}
+			if matched {
+				return table, true, nil
+			}
+		}
+	}
+
+	return "", false, nil
+}
+
+// Whether action has a row matching any of scans.
+func (d *client) scansMatch(table string, action *DataobjectAction, scans []readPredicate) (bool, error) {
+	var candidates []readPredicate
+	for _, scan := range scans {
+		if scan.filter == nil {
+			return action.Rows > 0, nil
+		}
+		if d.mayMatch(table, action, scan.filter) {
+			candidates = append(candidates, scan)
+		}
+	}
+	if len(candidates) == 0 {
+		return false, nil
+	}
+
+	convert := d.tx.schemas[table].converter(action.SchemaVersion)
+	o, err := d.readDataobjectWith(d.context(), action, convert)
+	if err != nil {
+		return false, fmt.Errorf("could not validate scans of %s: %w", table, err)
+	}
+
+	for i := 0; i < o.Len; i++ {
+		for _, scan := range candidates {
+			if scan.keep(&o.batch, i) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,81 @@
+package otf
+
+import (
+	"errors"
+	"testing"
+)
+
+// Starts a transaction on a client of mos with opts that books room
+// at slot if scanning finds it free.
+func book(mos *memoryObjectStorage, room, slot int, opts ...clientOption) *client {
+	c := newClient(mos, opts...)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	it, err := c.scan("bookings", withFilter(and(where("room", OP_EQ, room), where("slot", OP_EQ, slot))))
+	assertEq(err, nil, "could not scan")
+	row, err := it.next()
+	assertEq(err, nil, "could not read")
+	assert(row == nil, "already booked")
+	err = c.writeRow("bookings", []any{room, slot})
+	assertEq(err, nil, "could not book")
+	return &c
+}
+
+func TestSerializable(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("bookings", []string{"room", "slot"})
+	assertEq(err, nil, "could not create bookings")
+	err = c.writeRow("bookings", []any{1, 8})
+	assertEq(err, nil, "could not write")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Write skew: both see the slot free and both book it.
+	a := book(mos, 1, 9, withReadValidation())
+	b := book(mos, 1, 9, withReadValidation())
+	err = a.commitTx()
+	assertEq(err, nil, "could not commit a")
+	err = b.commitTx()
+	assertEq(err, nil, "snapshot isolation allows write skew")
+
+	a = book(mos, 2, 9, withSerializable())
+	b = book(mos, 2, 9, withSerializable())
+	err = a.commitTx()
+	assertEq(err, nil, "could not commit a")
+	err = b.commitTx()
+	assert(errors.Is(err, errConflict), "expected serializable conflict")
+
+	// Rows outside the range, whether ruled out by statistics or
+	// only by reading them, don't conflict.
+	a = book(mos, 3, 9, withSerializable())
+	b = book(mos, 3, 10, withSerializable())
+	other := newClient(mos)
+	err = other.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = other.writeRow("bookings", []any{3, 8})
+	assertEq(err, nil, "could not write")
+	err = other.writeRow("bookings", []any{3, 11})
+	assertEq(err, nil, "could not write")
+	err = other.commitTx()
+	assertEq(err, nil, "could not commit other")
+	err = a.commitTx()
+	assertEq(err, nil, "could not commit a")
+	err = b.commitTx()
+	assertEq(err, nil, "could not commit b")
+
+	// A scan without a filter conflicts with any rows added.
+	d := newClient(mos, withSerializable())
+	err = d.newTx()
+	assertEq(err, nil, "could not start tx")
+	_ = scanAll(&d, "bookings")
+	err = d.writeRow("bookings", []any{4, 9})
+	assertEq(err, nil, "could not write")
+	a = book(mos, 5, 9)
+	err = a.commitTx()
+	assertEq(err, nil, "could not commit a")
+	err = d.commitTx()
+	assert(errors.Is(err, errConflict), "expected conflict with unfiltered scan")
+}
@@ -0,0 +1,512 @@
+package otf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// `otf serve` exposes a store over an HTTP/JSON API, so processes
+// can share one server holding the storage (and its credentials)
+// rather than each opening the storage themselves. Requests and
+// responses are JSON:
+//
+//	POST /tx                          begin, {"At": id} for a read-only
+//	                                  transaction at a committed version,
+//	                                  {"ReadOnly": true} for one that
+//	                                  can't write, see readtx.go
+//	POST /tx/{tx}/commit
+//	POST /tx/{tx}/abort
+//	POST /tx/{tx}/tables              {"Table", "Columns", "Types", "PartitionBy"}
+//	POST /tx/{tx}/tables/{table}/rows {"Rows"}, as for otf insert
+//	POST /tx/{tx}/tables/{table}/scan {"Columns", "Limit"}, or with
+//	                                  "Spool" a page at a time, see spool.go
+//	POST /tx/{tx}/sql                 {"Statement", "Args"}, see sql.go
+//	POST /sql                         the same in a transaction of its own
+//	GET  /canary                      what probing the storage found, see
+//	                                  canary.go
+//	GET  /metrics                     what the server's clients did, in
+//	                                  Prometheus' format, see metrics.go
+//
+// Each transaction gets a client of its own so any number can be
+// open at once, isolated from each other as clients always are.
+// Transactions left idle for SERVE_TX_TIMEOUT are aborted. Errors
+// are {"Error": message} with a 4xx status for the caller's
+// mistakes, 409 for conflicts and 500 otherwise. If the server has a
+// token, requests must carry it as "Authorization: Bearer <token>".
+//
+// A server can also have tokens that authenticate identities. Their
+// transactions are restricted to the rows the identity can see (see
+// rowsecurity.go) and can only be used with a token of the same
+// identity. The server's own token is unrestricted.
+//
+// The server also speaks gRPC, see grpc.go.
+
+const SERVE_TX_TIMEOUT = 5 * time.Minute
+
+var errUnknownTx = fmt.Errorf("Unknown Transaction")
+
+// A transaction opened through the server.
+type serverTx struct {
+	mu       sync.Mutex
+	c        client
+	identity string
+	lastUsed time.Time
+}
+
+type server struct {
+	os    objectStorage
+	opts  []clientOption
+	token string
+	// Mapping tokens to the identities they authenticate.
+	identities map[string]string
+	timeout    time.Duration
+	mux        *http.ServeMux
+
+	mu  sync.Mutex
+	txs map[string]*serverTx
+
+	// Scans being downloaded a page at a time, see spool.go.
+	spools       spools
+	spoolTimeout time.Duration
+
+	// Probes the storage, if not nil, see canary.go.
+	canary *storageCanary
+
+	// Served at GET /metrics if not nil, see metrics.go.
+	metrics *prometheusMetrics
+
+	// That of the clients' options, see logging.go.
+	logger *slog.Logger
+}
+
+func newServer(os objectStorage, token string, opts ...clientOption) *server {
+	s := &server{
+		os:      os,
+		opts:    opts,
+		token:   token,
+		timeout: SERVE_TX_TIMEOUT,
+		mux:     http.NewServeMux(),
+		txs:     map[string]*serverTx{},
+
+		spools:       spools{m: map[string]*spool{}},
+		spoolTimeout: SERVE_SPOOL_TIMEOUT,
+		logger:       newClient(os, opts...).logger,
+	}
+
+	s.mux.HandleFunc("POST /tx", s.handleBegin)
+	s.mux.HandleFunc("POST /tx/{tx}/commit", s.withTx(s.handleCommit))
+	s.mux.HandleFunc("POST /tx/{tx}/abort", s.withTx(s.handleAbort))
+	s.mux.HandleFunc("POST /tx/{tx}/tables", s.withTx(s.handleCreateTable))
+	s.mux.HandleFunc("POST /tx/{tx}/tables/{table}/rows", s.withTx(s.handleWriteRows))
+	s.mux.HandleFunc("POST /tx/{tx}/tables/{table}/scan", s.withTx(s.handleScan))
+	s.mux.HandleFunc("POST /tx/{tx}/sql", s.withTx(s.execSQL))
+	s.mux.HandleFunc("POST /sql", s.handleSQLAlone)
+	s.mux.HandleFunc("POST /spool/{token}", s.handleSpool)
+	s.mux.HandleFunc("GET /canary", s.handleCanary)
+	s.mux.HandleFunc("GET /metrics", s.handleMetrics)
+	return s
+}
+
+// The identity the request's token authenticates, empty for the
+// server's own token, and whether it's authorized at all.
+func (s *server) identify(r *http.Request) (string, bool) {
+	token, bearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if identity, ok := s.identities[token]; ok && bearer {
+		return identity, true
+	}
+
+	if s.token == "" {
+		// Without a token only identities need authenticating.
+		return "", len(s.identities) == 0
+	}
+	return "", bearer && token == s.token
+}
+
+type identityKey struct{}
+
+// The identity ServeHTTP authenticated r as.
+func requestIdentity(r *http.Request) string {
+	identity, _ := r.Context().Value(identityKey{}).(string)
+	return identity
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if isGRPC(r) {
+		// See grpc.go.
+		s.serveGRPC(w, r)
+		return
+	}
+
+	identity, ok := s.identify(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"Error": "unauthorized"})
+		return
+	}
+
+	s.mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, identity)))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+var errBadRequest = fmt.Errorf("Bad Request")
+
+func (s *server) writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, errConflict):
+		status = http.StatusConflict
+	case errors.Is(err, errUnsafeStorage):
+		status = http.StatusServiceUnavailable
+	case errors.Is(err, errUnknownTx), errors.Is(err, errUnknownSpool), errors.Is(err, errNoTable), errors.Is(err, errNoColumn), errors.Is(err, errNoVersion):
+		status = http.StatusNotFound
+	case errors.Is(err, errBadRequest), errors.Is(err, errTableExists), errors.Is(err, errInvalidRow),
+		errors.Is(err, errTypeMismatch), errors.Is(err, errHistoricalTx), errors.Is(err, errReadOnlyTx), errors.Is(err, errSQLSyntax),
+		errors.Is(err, errInvalidParameter), errors.Is(err, errPartitionColumn), errors.Is(err, errColumnExists):
+		status = http.StatusBadRequest
+	}
+
+	logTo(s.logger, slog.LevelDebug, "server", "request failed", "status", status, "err", err)
+	writeJSON(w, status, map[string]string{"Error": err.Error()})
+}
+
+// Decodes the request body, if any, into v, with numbers as
+// json.Number.
+func readJSON(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	err := dec.Decode(v)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: %s", errBadRequest, err)
+	}
+
+	return nil
+}
+
+// Aborts transactions idle for longer than the timeout, other than
+// ones in use.
+func (s *server) expireTxs() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, stx := range s.txs {
+		if !stx.mu.TryLock() {
+			continue
+		}
+
+		if time.Since(stx.lastUsed) > s.timeout {
+			logTo(s.logger, slog.LevelDebug, "server", "aborting idle transaction", "id", id)
+			stx.c.abortTx()
+			delete(s.txs, id)
+		}
+		stx.mu.Unlock()
+	}
+}
+
+func (s *server) handleBegin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		At       *int
+		ReadOnly bool
+	}
+	err := readJSON(r, &req)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	id, txId, err := s.begin(requestIdentity(r), req.At, req.ReadOnly)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"Tx": id, "Id": txId})
+}
+
+// A client acting as identity.
+func (s *server) newClient(identity string) client {
+	return newClient(s.os, append(slices.Clone(s.opts), withIdentity(identity))...)
+}
+
+// Opens a transaction for identity, as of the committed transaction
+// at if not nil, and that can't write if readOnly, returning the id
+// to refer to it by and its transaction id.
+func (s *server) begin(identity string, at *int, readOnly bool) (string, int, error) {
+	s.expireTxs()
+
+	stx := &serverTx{c: s.newClient(identity), identity: identity, lastUsed: time.Now()}
+	var err error
+	switch {
+	case at != nil:
+		err = stx.c.newTxAt(*at)
+	case readOnly:
+		err = stx.c.newReadTx()
+	default:
+		err = stx.c.newTx()
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	id := uuidv4()
+	s.mu.Lock()
+	s.txs[id] = stx
+	s.mu.Unlock()
+
+	return id, stx.c.tx.Id, nil
+}
+
+// Runs f with transaction id's client, locked, forgetting the
+// transaction once it ends. Only identity, the one that opened it,
+// can use it.
+func (s *server) useTx(identity, id string, f func(*client) error) error {
+	s.mu.Lock()
+	stx, ok := s.txs[id]
+	s.mu.Unlock()
+	if !ok || stx.identity != identity {
+		return fmt.Errorf("%w: %s", errUnknownTx, id)
+	}
+
+	stx.mu.Lock()
+	defer stx.mu.Unlock()
+	if stx.c.tx == nil {
+		// Ended by a request that raced this one.
+		return fmt.Errorf("%w: %s", errUnknownTx, id)
+	}
+
+	stx.lastUsed = time.Now()
+	defer func() {
+		if stx.c.tx == nil {
+			s.mu.Lock()
+			delete(s.txs, id)
+			s.mu.Unlock()
+		}
+	}()
+
+	return f(&stx.c)
+}
+
+// Runs handle with the request's transaction, see useTx.
+func (s *server) withTx(handle func(http.ResponseWriter, *http.Request, *client)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := s.useTx(requestIdentity(r), r.PathValue("tx"), func(c *client) error {
+			handle(w, r, c)
+			return nil
+		})
+		if err != nil {
+			s.writeError(w, err)
+		}
+	}
+}
+
+func (s *server) handleCommit(w http.ResponseWriter, r *http.Request, c *client) {
+	err := s.canary.checkSafe()
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	id := c.tx.Id
+	err = c.commitTx()
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"Id": id})
+}
+
+func (s *server) handleAbort(w http.ResponseWriter, r *http.Request, c *client) {
+	err := c.abortTx()
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{})
+}
+
+func (s *server) handleCreateTable(w http.ResponseWriter, r *http.Request, c *client) {
+	var req struct {
+		Table       string
+		Columns     []string
+		Types       map[string]string
+		PartitionBy []string
+	}
+	err := readJSON(r, &req)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	opts := []tableOption{withPartitionColumns(req.PartitionBy...)}
+	if len(req.Types) > 0 {
+		opts = append(opts, withColumnTypes(req.Types))
+	}
+	err = c.createTable(req.Table, req.Columns, opts...)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{})
+}
+
+func (s *server) handleWriteRows(w http.ResponseWriter, r *http.Request, c *client) {
+	table := r.PathValue("table")
+	columns, ok := c.tx.tables[table]
+	if !ok {
+		s.writeError(w, fmt.Errorf("%w: %s", errNoTable, table))
+		return
+	}
+
+	var req struct {
+		Rows json.RawMessage
+	}
+	err := readJSON(r, &req)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	rows, err := decodeJSONRows(bytes.NewReader(req.Rows), columns)
+	if err != nil {
+		s.writeError(w, fmt.Errorf("%w: %s", errBadRequest, err))
+		return
+	}
+
+	for i, row := range rows {
+		err = c.writeRow(table, row)
+		if err != nil {
+			// Rows before it stay written.
+			s.writeError(w, fmt.Errorf("row %d: %w", i, err))
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"Rows": len(rows)})
+}
+
+func (s *server) handleScan(w http.ResponseWriter, r *http.Request, c *client) {
+	table := r.PathValue("table")
+	if _, ok := c.tx.tables[table]; !ok {
+		s.writeError(w, fmt.Errorf("%w: %s", errNoTable, table))
+		return
+	}
+
+	var req struct {
+		Columns  []string
+		Limit    int
+		Spool    bool
+		PageRows int
+	}
+	err := readJSON(r, &req)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	var opts []scanOption
+	if req.Columns != nil {
+		opts = append(opts, withColumns(req.Columns...))
+	}
+	it, err := c.scan(table, opts...)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	columns := req.Columns
+	if columns == nil {
+		columns = c.tx.tables[table]
+	}
+
+	if req.Spool {
+		page, err := s.spoolScan(it, req.Limit, req.PageRows)
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+
+		out := map[string]any{"Columns": columns, "Rows": page.Rows}
+		if page.Next != "" {
+			out["Next"] = page.Next
+		}
+		writeJSON(w, http.StatusOK, out)
+		return
+	}
+
+	rows := [][]any{}
+	for req.Limit <= 0 || len(rows) < req.Limit {
+		row, err := it.next()
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		if row == nil {
+			break
+		}
+		rows = append(rows, row)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{"Columns": columns, "Rows": rows})
+}
+
+func (s *server) execSQL(w http.ResponseWriter, r *http.Request, c *client) {
+	var req struct {
+		Statement string
+		Args      []any
+	}
+	err := readJSON(r, &req)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	for i := range req.Args {
+		req.Args[i] = jsonValue(req.Args[i])
+	}
+	result, err := c.execSQL(req.Statement, req.Args...)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *server) handleSQLAlone(w http.ResponseWriter, r *http.Request) {
+	c := s.newClient(requestIdentity(r))
+	s.execSQL(w, r, &c)
+}
+
+func (s *server) handleCanary(w http.ResponseWriter, r *http.Request) {
+	if s.canary == nil {
+		s.writeError(w, fmt.Errorf("%w: not probing storage, see serve --canary", errBadRequest))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.canary.status())
+}
+
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if s.metrics == nil {
+		s.writeError(w, fmt.Errorf("%w: not collecting metrics, see serve --metrics", errBadRequest))
+		return
+	}
+
+	s.metrics.ServeHTTP(w, r)
+}
@@ -0,0 +1,153 @@
+package otf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Posts body as JSON and decodes the response into a map.
+func post(srv *httptest.Server, token, path string, body any) (int, map[string]any) {
+	b, err := json.Marshal(body)
+	assertEq(err, nil, "could not encode request")
+	req, err := http.NewRequest("POST", srv.URL+path, bytes.NewReader(b))
+	assertEq(err, nil, "could not build request")
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := srv.Client().Do(req)
+	assertEq(err, nil, "could not post")
+	defer res.Body.Close()
+
+	var out map[string]any
+	err = json.NewDecoder(res.Body).Decode(&out)
+	assertEq(err, nil, "could not decode response")
+	return res.StatusCode, out
+}
+
+func TestServer(t *testing.T) {
+	srv := httptest.NewServer(newServer(newMemoryObjectStorage(), "secret"))
+	defer srv.Close()
+
+	status, _ := post(srv, "wrong", "/tx", nil)
+	assertEq(status, http.StatusUnauthorized, "status without token")
+
+	status, out := post(srv, "secret", "/tx", nil)
+	assertEq(status, http.StatusOK, "status")
+	tx := out["Tx"].(string)
+	status, out = post(srv, "secret", "/tx/"+tx+"/tables", map[string]any{"Table": "x", "Columns": []string{"a", "b"}})
+	assertEq(status, http.StatusOK, fmt.Sprint("create table: ", out))
+	status, out = post(srv, "secret", "/tx/"+tx+"/tables/x/rows", map[string]any{"Rows": []any{[]any{1, "one"}, map[string]any{"a": 2}}})
+	assertEq(status, http.StatusOK, fmt.Sprint("write rows: ", out))
+	assertEq(out["Rows"], any(2.0), "rows written")
+	status, _ = post(srv, "secret", "/tx/"+tx+"/commit", nil)
+	assertEq(status, http.StatusOK, "commit")
+
+	status, _ = post(srv, "secret", "/tx/"+tx+"/commit", nil)
+	assertEq(status, http.StatusNotFound, "commit again")
+
+	// Two transactions at once, the second to commit conflicts.
+	_, out = post(srv, "secret", "/tx", nil)
+	tx1 := out["Tx"].(string)
+	_, out = post(srv, "secret", "/tx", nil)
+	tx2 := out["Tx"].(string)
+	for _, tx := range []string{tx1, tx2} {
+		status, out = post(srv, "secret", "/tx/"+tx+"/sql", map[string]any{"Statement": "DELETE FROM x WHERE a = $1", "Args": []any{1}})
+		assertEq(status, http.StatusOK, fmt.Sprint("delete: ", out))
+	}
+	status, _ = post(srv, "secret", "/tx/"+tx1+"/commit", nil)
+	assertEq(status, http.StatusOK, "commit")
+	status, _ = post(srv, "secret", "/tx/"+tx2+"/commit", nil)
+	assertEq(status, http.StatusConflict, "conflicting commit")
+
+	_, out = post(srv, "secret", "/tx", nil)
+	tx = out["Tx"].(string)
+	status, out = post(srv, "secret", "/tx/"+tx+"/tables/x/scan", map[string]any{"Columns": []string{"b", "a"}})
+	assertEq(status, http.StatusOK, "scan")
+	assertEq(fmt.Sprint(out["Columns"], out["Rows"]), "[b a] [[<nil> 2]]", "scanned rows")
+	status, _ = post(srv, "secret", "/tx/"+tx+"/tables/y/scan", nil)
+	assertEq(status, http.StatusNotFound, "scan missing table")
+	status, _ = post(srv, "secret", "/tx/"+tx+"/abort", nil)
+	assertEq(status, http.StatusOK, "abort")
+
+	status, out = post(srv, "secret", "/sql", map[string]any{"Statement": "SELECT a FROM x"})
+	assertEq(status, http.StatusOK, "sql")
+	assertEq(fmt.Sprint(out["Rows"]), "[[2]]", "selected rows")
+	status, _ = post(srv, "secret", "/sql", map[string]any{"Statement": "SELECT FROM"})
+	assertEq(status, http.StatusBadRequest, "bad sql")
+}
+
+func TestServerExpiresIdleTxs(t *testing.T) {
+	s := newServer(newMemoryObjectStorage(), "")
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	_, out := post(srv, "", "/tx", nil)
+	tx := out["Tx"].(string)
+	s.timeout = 0
+	post(srv, "", "/tx", nil)
+	status, _ := post(srv, "", "/tx/"+tx+"/abort", nil)
+	assertEq(status, http.StatusNotFound, "expired tx")
+}
+
+func TestServerSpoolsScans(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	s := newServer(mos, "")
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	_, out := post(srv, "", "/tx", nil)
+	tx := out["Tx"].(string)
+	status, out := post(srv, "", "/tx/"+tx+"/tables", map[string]any{"Table": "x", "Columns": []string{"a"}})
+	assertEq(status, http.StatusOK, fmt.Sprint("create table: ", out))
+	var rows []any
+	for i := 0; i < 5; i++ {
+		rows = append(rows, []any{i})
+	}
+	status, out = post(srv, "", "/tx/"+tx+"/tables/x/rows", map[string]any{"Rows": rows})
+	assertEq(status, http.StatusOK, fmt.Sprint("write rows: ", out))
+	status, _ = post(srv, "", "/tx/"+tx+"/commit", nil)
+	assertEq(status, http.StatusOK, "commit")
+
+	_, out = post(srv, "", "/tx", nil)
+	tx = out["Tx"].(string)
+	status, out = post(srv, "", "/tx/"+tx+"/tables/x/scan", map[string]any{"Spool": true, "PageRows": 2})
+	assertEq(status, http.StatusOK, fmt.Sprint("scan: ", out))
+	// The rest of the pages outlive the transaction.
+	status, _ = post(srv, "", "/tx/"+tx+"/abort", nil)
+	assertEq(status, http.StatusOK, "abort")
+
+	pages := []string{fmt.Sprint(out["Rows"])}
+	for out["Next"] != nil {
+		status, out = post(srv, "", "/spool/"+out["Next"].(string), nil)
+		assertEq(status, http.StatusOK, fmt.Sprint("page: ", out))
+		pages = append(pages, fmt.Sprint(out["Rows"]))
+	}
+	assertEq(fmt.Sprint(pages), "[[[0] [1]] [[2] [3]] [[4]]]", "wrong pages")
+
+	// Finished spools are deleted.
+	names, err := mos.listPrefix(context.Background(), SPOOL_PREFIX)
+	assertEq(err, nil, "could not list")
+	assertEq(len(names), 0, "spool left behind")
+
+	// As are abandoned ones, once they expire.
+	_, out = post(srv, "", "/tx", nil)
+	tx = out["Tx"].(string)
+	_, out = post(srv, "", "/tx/"+tx+"/tables/x/scan", map[string]any{"Spool": true, "PageRows": 2, "Limit": 4})
+	next := out["Next"].(string)
+	names, err = mos.listPrefix(context.Background(), SPOOL_PREFIX)
+	assertEq(err, nil, "could not list")
+	assertEq(len(names), 1, "wrong spooled pages")
+	s.spoolTimeout = 0
+	status, _ = post(srv, "", "/spool/"+next, nil)
+	assertEq(status, http.StatusNotFound, "expired spool")
+	names, err = mos.listPrefix(context.Background(), SPOOL_PREFIX)
+	assertEq(err, nil, "could not list")
+	assertEq(len(names), 0, "expired spool left behind")
+
+	// Small scans fit on one page.
+	_, out = post(srv, "", "/tx/"+tx+"/tables/x/scan", map[string]any{"Spool": true})
+	assertEq(fmt.Sprint(out["Rows"], out["Next"]), "[[0] [1] [2] [3] [4]] <nil>", "wrong page")
+}
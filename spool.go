@@ -0,0 +1,192 @@
+package otf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A server scan (see server.go) normally answers with every row at
+// once, and its transaction stays open, holding its snapshot and the
+// rows in memory, for as long as the client takes to download them.
+// Asked to spool, the server instead reads the whole scan up front
+// into pages of rows stored under _spool/ in the store and answers
+// with the first page and a token for the next, so the client can
+// end the transaction right away and fetch the rest a page at a
+// time, however slowly:
+//
+//	POST /spool/{token}  {"Rows", "Next"}, without Next on the last page
+//
+// A spool is deleted once its last page is fetched, or
+// SERVE_SPOOL_TIMEOUT after it was written if never finished.
+
+const (
+	SPOOL_PREFIX        = "_spool/"
+	SPOOL_PAGE_ROWS     = 10_000
+	SERVE_SPOOL_TIMEOUT = time.Hour
+)
+
+var errUnknownSpool = fmt.Errorf("Unknown Spool")
+
+type spool struct {
+	created time.Time
+	pages   int
+}
+
+type spools struct {
+	mu sync.Mutex
+	m  map[string]*spool
+}
+
+type spoolPage struct {
+	Rows [][]any
+	Next string `json:",omitempty"`
+}
+
+func spoolKey(id string, page int) string {
+	return fmt.Sprintf("%s%s_%010d", SPOOL_PREFIX, id, page)
+}
+
+func spoolToken(id string, page int) string {
+	return id + "." + strconv.Itoa(page)
+}
+
+// Reads it, up to limit rows if limit > 0, into pages of pageRows
+// rows, returning the first.
+func (s *server) spoolScan(it *scanIterator, limit, pageRows int) (*spoolPage, error) {
+	if pageRows <= 0 {
+		pageRows = SPOOL_PAGE_ROWS
+	}
+
+	id := uuidv4()
+	var first *spoolPage
+	pages := 0
+	rows := [][]any{}
+	flush := func() error {
+		if pages == 0 {
+			// Returned rather than stored.
+			first = &spoolPage{Rows: rows}
+		} else {
+			bytes, err := json.Marshal(spoolPage{Rows: rows})
+			if err != nil {
+				return err
+			}
+
+			err = s.os.putIfAbsent(context.Background(), spoolKey(id, pages), bytes)
+			if err != nil {
+				return err
+			}
+		}
+
+		pages++
+		rows = [][]any{}
+		return nil
+	}
+
+	for read := 0; limit <= 0 || read < limit; read++ {
+		row, err := it.next()
+		if err == nil && row != nil && len(rows) == pageRows {
+			err = flush()
+		}
+		if err != nil {
+			s.deleteSpool(id, pages)
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+
+		rows = append(rows, row)
+	}
+
+	err := flush()
+	if err != nil {
+		s.deleteSpool(id, pages)
+		return nil, err
+	}
+
+	if pages > 1 {
+		first.Next = spoolToken(id, 1)
+		s.spools.mu.Lock()
+		s.spools.m[id] = &spool{time.Now(), pages}
+		s.spools.mu.Unlock()
+	}
+
+	return first, nil
+}
+
+// Deletes the first pages of spool id, the first of which is never
+// stored.
+func (s *server) deleteSpool(id string, pages int) {
+	for page := 1; page < pages; page++ {
+		err := s.os.delete(context.Background(), spoolKey(id, page))
+		if err != nil {
+			logTo(s.logger, slog.LevelWarn, "server", "could not delete spool page", "spool", id, "err", err)
+		}
+	}
+}
+
+// Deletes spools not finished within the timeout.
+func (s *server) expireSpools() {
+	s.spools.mu.Lock()
+	defer s.spools.mu.Unlock()
+
+	for id, sp := range s.spools.m {
+		if time.Since(sp.created) > s.spoolTimeout {
+			logTo(s.logger, slog.LevelDebug, "server", "deleting expired spool", "spool", id)
+			s.deleteSpool(id, sp.pages)
+			delete(s.spools.m, id)
+		}
+	}
+}
+
+func (s *server) readSpool(token string) (*spoolPage, error) {
+	s.expireSpools()
+
+	id, pageStr, _ := strings.Cut(token, ".")
+	page, err := strconv.Atoi(pageStr)
+	s.spools.mu.Lock()
+	sp, ok := s.spools.m[id]
+	s.spools.mu.Unlock()
+	if err != nil || !ok || page < 1 || page >= sp.pages {
+		return nil, fmt.Errorf("%w: %s", errUnknownSpool, token)
+	}
+
+	bytes, err := s.os.read(context.Background(), spoolKey(id, page))
+	if err != nil {
+		return nil, err
+	}
+
+	var p spoolPage
+	err = json.Unmarshal(bytes, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	if page+1 < sp.pages {
+		p.Next = spoolToken(id, page+1)
+	} else {
+		s.spools.mu.Lock()
+		delete(s.spools.m, id)
+		s.spools.mu.Unlock()
+		s.deleteSpool(id, sp.pages)
+	}
+
+	return &p, nil
+}
+
+func (s *server) handleSpool(w http.ResponseWriter, r *http.Request) {
+	page, err := s.readSpool(r.PathValue("token"))
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,1000 @@
+package otf
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A minimal SQL frontend over the client. It supports:
+//
+//	CREATE TABLE t (a [type] [NOT NULL] [PRIMARY KEY] [DEFAULT v | DEFAULT NOW() | AUTOINCREMENT], ...)
+//	CREATE TABLE t AS SELECT ...
+//	INSERT INTO t [(a, ...)] VALUES (v | DEFAULT, ...), ...
+//	INSERT INTO t [(a, ...)] SELECT ...
+//	SELECT * | a, ... FROM t [WHERE ...] [LIMIT n]
+//	SELECT * FROM t JOIN u ON t.a = u.b [LIMIT n]
+//	EXPLAIN SELECT * FROM t JOIN u ON t.a = u.b
+//	DELETE FROM t [WHERE ...]
+//	BEGIN, COMMIT and ROLLBACK
+//
+// Types are those of types.go (INT, FLOAT, STRING, BOOL, TIMESTAMP
+// and their usual aliases); untyped columns take any value. Columns
+// marked PRIMARY KEY make up the table's primary key, in order (see
+// constraints.go). Columns an INSERT leaves out get their defaults
+// (see defaults.go). WHERE
+// takes comparisons of a column to a literal or a $n placeholder,
+// combined with AND, OR and parentheses. SELECTs go through prepare
+// (see prepare.go) so repeating a query with different parameters
+// reuses its plan.
+//
+// Joins (see join.go) return the columns of both tables, named
+// table.column. EXPLAIN returns a join's plan, a line per row.
+//
+// Tables written by INSERT ... SELECT or created AS SELECT record the
+// statement's lineage (see lineage.go).
+//
+// Statements run in the client's transaction if one is open,
+// otherwise each runs in a transaction of its own, committed if it
+// succeeds. Several can run as a script, see script.go.
+
+var errSQLSyntax = fmt.Errorf("Syntax Error")
+
+type sqlResult struct {
+	// Set for SELECT.
+	Columns []string
+	Rows    [][]any
+	// Rows inserted or deleted.
+	RowsAffected int
+}
+
+const (
+	SQL_IDENT  = "ident"
+	SQL_NUMBER = "number"
+	SQL_STRING = "string"
+	SQL_PARAM  = "param"
+	SQL_SYMBOL = "symbol"
+	SQL_EOF    = "eof"
+)
+
+type sqlToken struct {
+	Kind string
+	Text string
+}
+
+func lexSQL(text string) ([]sqlToken, error) {
+	var tokens []sqlToken
+	rs := []rune(text)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		start := i
+		switch {
+		case unicode.IsSpace(r):
+			i++
+			continue
+		case unicode.IsLetter(r) || r == '_':
+			for i < len(rs) && (unicode.IsLetter(rs[i]) || unicode.IsDigit(rs[i]) || rs[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, sqlToken{SQL_IDENT, string(rs[start:i])})
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			for i < len(rs) && (unicode.IsDigit(rs[i]) || rs[i] == '.' || rs[i] == 'e' || rs[i] == 'E') {
+				i++
+			}
+			tokens = append(tokens, sqlToken{SQL_NUMBER, string(rs[start:i])})
+		case r == '\'' || r == '"':
+			// Strings in single quotes, identifiers in double
+			// quotes, either escaping its quote by doubling it.
+			var b strings.Builder
+			i++
+			for {
+				if i == len(rs) {
+					return nil, fmt.Errorf("%w: unterminated %c", errSQLSyntax, r)
+				}
+				if rs[i] == r {
+					if i+1 < len(rs) && rs[i+1] == r {
+						b.WriteRune(r)
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				b.WriteRune(rs[i])
+				i++
+			}
+			kind := SQL_STRING
+			if r == '"' {
+				kind = SQL_IDENT
+			}
+			tokens = append(tokens, sqlToken{kind, b.String()})
+		case r == '$':
+			i++
+			for i < len(rs) && unicode.IsDigit(rs[i]) {
+				i++
+			}
+			if i == start+1 {
+				return nil, fmt.Errorf("%w: $ without a number", errSQLSyntax)
+			}
+			tokens = append(tokens, sqlToken{SQL_PARAM, string(rs[start+1 : i])})
+		default:
+			i++
+			// Two character operators.
+			if i < len(rs) && slices.Contains([]string{"<=", ">=", "!=", "<>"}, string(rs[start:i+1])) {
+				i++
+			}
+			if !strings.Contains("(),*;=<>!-.", string(rs[start:i])[:1]) {
+				return nil, fmt.Errorf("%w: unexpected %q", errSQLSyntax, string(rs[start:i]))
+			}
+			tokens = append(tokens, sqlToken{SQL_SYMBOL, string(rs[start:i])})
+		}
+	}
+
+	return append(tokens, sqlToken{Kind: SQL_EOF}), nil
+}
+
+type sqlParser struct {
+	tokens []sqlToken
+	pos    int
+	// The highest $n seen.
+	params int
+}
+
+func (p *sqlParser) peek() sqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *sqlParser) next() sqlToken {
+	t := p.tokens[p.pos]
+	if t.Kind != SQL_EOF {
+		p.pos++
+	}
+	return t
+}
+
+// Puts back t, just returned by next.
+func (p *sqlParser) unread(t sqlToken) {
+	if t.Kind != SQL_EOF {
+		p.pos--
+	}
+}
+
+func (p *sqlParser) isKeyword(t sqlToken, keyword string) bool {
+	return t.Kind == SQL_IDENT && strings.EqualFold(t.Text, keyword)
+}
+
+// Consumes the next token if it's keyword.
+func (p *sqlParser) acceptKeyword(keyword string) bool {
+	if p.isKeyword(p.peek(), keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) acceptSymbol(symbol string) bool {
+	if t := p.peek(); t.Kind == SQL_SYMBOL && t.Text == symbol {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) unexpected() error {
+	t := p.peek()
+	if t.Kind == SQL_EOF {
+		return fmt.Errorf("%w: unexpected end of statement", errSQLSyntax)
+	}
+	return fmt.Errorf("%w: unexpected %q", errSQLSyntax, t.Text)
+}
+
+func (p *sqlParser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		return p.unexpected()
+	}
+	return nil
+}
+
+func (p *sqlParser) expectSymbol(symbol string) error {
+	if !p.acceptSymbol(symbol) {
+		return p.unexpected()
+	}
+	return nil
+}
+
+func (p *sqlParser) ident() (string, error) {
+	t := p.peek()
+	if t.Kind != SQL_IDENT {
+		return "", p.unexpected()
+	}
+	p.pos++
+	return t.Text, nil
+}
+
+// A parenthesized, comma separated list of what each parses.
+func (p *sqlParser) list(each func() error) error {
+	err := p.expectSymbol("(")
+	if err != nil {
+		return err
+	}
+
+	for {
+		err = each()
+		if err != nil {
+			return err
+		}
+		if p.acceptSymbol(")") {
+			return nil
+		}
+		err = p.expectSymbol(",")
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// A literal or placeholder.
+func (p *sqlParser) value() (any, error) {
+	negative := p.acceptSymbol("-")
+	t := p.next()
+	switch {
+	case t.Kind == SQL_NUMBER:
+		if i, err := strconv.Atoi(t.Text); err == nil {
+			if negative {
+				i = -i
+			}
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(t.Text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid number %s", errSQLSyntax, t.Text)
+		}
+		if negative {
+			f = -f
+		}
+		return f, nil
+	case negative:
+		// Only numbers can be.
+	case t.Kind == SQL_STRING:
+		return t.Text, nil
+	case t.Kind == SQL_PARAM:
+		n, _ := strconv.Atoi(t.Text)
+		if n == 0 {
+			return nil, fmt.Errorf("%w: placeholders start at $1", errSQLSyntax)
+		}
+		p.params = max(p.params, n)
+		return param(n, PARAM_ANY), nil
+	case p.isKeyword(t, "NULL"):
+		return nil, nil
+	case p.isKeyword(t, "TRUE"):
+		return true, nil
+	case p.isKeyword(t, "FALSE"):
+		return false, nil
+	}
+
+	p.unread(t)
+	return nil, p.unexpected()
+}
+
+var sqlComparisons = map[string]string{
+	"=":  OP_EQ,
+	"!=": OP_NE,
+	"<>": OP_NE,
+	"<":  OP_LT,
+	"<=": OP_LTE,
+	">":  OP_GT,
+	">=": OP_GTE,
+}
+
+// The same comparison with its sides swapped, e.g. 1 < a is a > 1.
+var flippedComparisons = map[string]string{
+	OP_EQ:  OP_EQ,
+	OP_NE:  OP_NE,
+	OP_LT:  OP_GT,
+	OP_LTE: OP_GTE,
+	OP_GT:  OP_LT,
+	OP_GTE: OP_LTE,
+}
+
+// OR binds looser than AND.
+func (p *sqlParser) predicate() (*predicate, error) {
+	var ors []*predicate
+	for {
+		var ands []*predicate
+		for {
+			c, err := p.comparison()
+			if err != nil {
+				return nil, err
+			}
+			ands = append(ands, c)
+			if !p.acceptKeyword("AND") {
+				break
+			}
+		}
+
+		if len(ands) == 1 {
+			ors = append(ors, ands[0])
+		} else {
+			ors = append(ors, and(ands...))
+		}
+		if !p.acceptKeyword("OR") {
+			break
+		}
+	}
+
+	if len(ors) == 1 {
+		return ors[0], nil
+	}
+	return or(ors...), nil
+}
+
+func (p *sqlParser) comparison() (*predicate, error) {
+	if p.acceptSymbol("(") {
+		c, err := p.predicate()
+		if err != nil {
+			return nil, err
+		}
+		return c, p.expectSymbol(")")
+	}
+
+	flipped := p.peek().Kind != SQL_IDENT || p.isKeyword(p.peek(), "NULL") || p.isKeyword(p.peek(), "TRUE") || p.isKeyword(p.peek(), "FALSE")
+	var column string
+	var value any
+	var err error
+	if flipped {
+		value, err = p.value()
+	} else {
+		column, err = p.ident()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.next()
+	op, ok := sqlComparisons[t.Text]
+	if t.Kind != SQL_SYMBOL || !ok {
+		p.unread(t)
+		return nil, p.unexpected()
+	}
+
+	if flipped {
+		column, err = p.ident()
+		op = flippedComparisons[op]
+	} else {
+		value, err = p.value()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return where(column, op, value), nil
+}
+
+type sqlStatement struct {
+	Kind  string
+	Table string
+	// CREATE TABLE's columns and their types, INSERT's columns (all
+	// in order if empty) or SELECT's (all if empty).
+	Columns []string
+	Types   map[string]string
+	// CREATE TABLE's constraints and defaults.
+	NotNull    []string
+	PrimaryKey []string
+	Defaults   map[string]ColumnDefault
+	// INSERT's rows.
+	Rows   [][]any
+	Filter *predicate
+	// -1 if none.
+	Limit int
+	// The SELECT of INSERT ... SELECT and CREATE TABLE ... AS.
+	Select *sqlStatement
+	// A SELECT's join, and whether it's only explained.
+	Join    *joinSpec
+	Explain bool
+	Params  int
+	Text    string
+}
+
+// Keywords that can follow a column in CREATE TABLE.
+var sqlColumnConstraints = []string{"NOT", "PRIMARY", "AUTOINCREMENT", "DEFAULT"}
+
+var sqlTypes = map[string]string{
+	"INT":       COLUMN_INT,
+	"INTEGER":   COLUMN_INT,
+	"BIGINT":    COLUMN_INT,
+	"FLOAT":     COLUMN_FLOAT,
+	"DOUBLE":    COLUMN_FLOAT,
+	"REAL":      COLUMN_FLOAT,
+	"STRING":    COLUMN_STRING,
+	"TEXT":      COLUMN_STRING,
+	"VARCHAR":   COLUMN_STRING,
+	"BOOL":      COLUMN_BOOL,
+	"BOOLEAN":   COLUMN_BOOL,
+	"TIMESTAMP": COLUMN_TIMESTAMP,
+}
+
+func parseSQL(text string) (*sqlStatement, error) {
+	tokens, err := lexSQL(text)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &sqlParser{tokens: tokens}
+	s := &sqlStatement{Limit: -1}
+	first := p.next()
+	switch {
+	case p.isKeyword(first, "BEGIN"), p.isKeyword(first, "COMMIT"), p.isKeyword(first, "ROLLBACK"):
+		s.Kind = strings.ToUpper(first.Text)
+	case p.isKeyword(first, "CREATE"):
+		s.Kind = "CREATE TABLE"
+		err = p.parseCreateTable(s)
+	case p.isKeyword(first, "INSERT"):
+		s.Kind = "INSERT"
+		err = p.parseInsert(s)
+	case p.isKeyword(first, "SELECT"):
+		s.Kind = "SELECT"
+		err = p.parseSelect(s)
+	case p.isKeyword(first, "EXPLAIN"):
+		s.Kind = "SELECT"
+		s.Explain = true
+		err = p.expectKeyword("SELECT")
+		if err == nil {
+			err = p.parseSelect(s)
+		}
+		if err == nil && s.Join == nil {
+			err = fmt.Errorf("%w: EXPLAIN only describes joins", errSQLSyntax)
+		}
+	case p.isKeyword(first, "DELETE"):
+		s.Kind = "DELETE"
+		err = p.parseDelete(s)
+	default:
+		p.unread(first)
+		err = p.unexpected()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.acceptSymbol(";")
+	if p.peek().Kind != SQL_EOF {
+		return nil, p.unexpected()
+	}
+
+	s.Params = p.params
+	s.Text = text
+	return s, nil
+}
+
+func (p *sqlParser) parseCreateTable(s *sqlStatement) error {
+	err := p.expectKeyword("TABLE")
+	if err != nil {
+		return err
+	}
+
+	s.Table, err = p.ident()
+	if err != nil {
+		return err
+	}
+
+	if p.acceptKeyword("AS") {
+		return p.parseSubselect(s)
+	}
+
+	s.Types = map[string]string{}
+	s.Defaults = map[string]ColumnDefault{}
+	return p.list(func() error {
+		column, err := p.ident()
+		if err != nil {
+			return err
+		}
+		s.Columns = append(s.Columns, column)
+
+		if t := p.peek(); t.Kind == SQL_IDENT && !slices.ContainsFunc(sqlColumnConstraints, func(keyword string) bool {
+			return p.isKeyword(t, keyword)
+		}) {
+			typ, ok := sqlTypes[strings.ToUpper(t.Text)]
+			if !ok {
+				return fmt.Errorf("%w: unknown type %s", errSQLSyntax, t.Text)
+			}
+			p.pos++
+			s.Types[column] = typ
+		}
+
+		for {
+			switch {
+			case p.acceptKeyword("NOT"):
+				err = p.expectKeyword("NULL")
+				s.NotNull = append(s.NotNull, column)
+			case p.acceptKeyword("PRIMARY"):
+				err = p.expectKeyword("KEY")
+				s.PrimaryKey = append(s.PrimaryKey, column)
+			case p.acceptKeyword("AUTOINCREMENT"):
+				s.Defaults[column] = ColumnDefault{Sequence: true}
+			case p.acceptKeyword("DEFAULT"):
+				var def ColumnDefault
+				if p.acceptKeyword("NOW") {
+					def.Now = true
+					err = p.expectSymbol("(")
+					if err == nil {
+						err = p.expectSymbol(")")
+					}
+				} else if p.acceptKeyword("CURRENT_TIMESTAMP") {
+					def.Now = true
+				} else {
+					def.Value, err = p.value()
+				}
+				s.Defaults[column] = def
+			default:
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
+
+func (p *sqlParser) parseInsert(s *sqlStatement) error {
+	err := p.expectKeyword("INTO")
+	if err != nil {
+		return err
+	}
+
+	s.Table, err = p.ident()
+	if err != nil {
+		return err
+	}
+
+	if p.peek().Kind == SQL_SYMBOL && p.peek().Text == "(" {
+		err = p.list(func() error {
+			column, err := p.ident()
+			s.Columns = append(s.Columns, column)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.isKeyword(p.peek(), "SELECT") {
+		return p.parseSubselect(s)
+	}
+
+	err = p.expectKeyword("VALUES")
+	if err != nil {
+		return err
+	}
+
+	for {
+		var row []any
+		err = p.list(func() error {
+			if p.acceptKeyword("DEFAULT") {
+				row = append(row, useDefault)
+				return nil
+			}
+
+			v, err := p.value()
+			row = append(row, v)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		s.Rows = append(s.Rows, row)
+
+		if !p.acceptSymbol(",") {
+			return nil
+		}
+	}
+}
+
+func (p *sqlParser) parseWhere(s *sqlStatement) error {
+	if !p.acceptKeyword("WHERE") {
+		return nil
+	}
+
+	var err error
+	s.Filter, err = p.predicate()
+	return err
+}
+
+func (p *sqlParser) parseSelect(s *sqlStatement) error {
+	if !p.acceptSymbol("*") {
+		for {
+			column, err := p.ident()
+			if err != nil {
+				return err
+			}
+			s.Columns = append(s.Columns, column)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+
+	err := p.expectKeyword("FROM")
+	if err != nil {
+		return err
+	}
+
+	s.Table, err = p.ident()
+	if err != nil {
+		return err
+	}
+
+	if p.acceptKeyword("JOIN") {
+		if s.Columns != nil {
+			return fmt.Errorf("%w: only SELECT * can join", errSQLSyntax)
+		}
+
+		err = p.parseJoin(s)
+	} else {
+		err = p.parseWhere(s)
+	}
+	if err != nil {
+		return err
+	}
+
+	if p.acceptKeyword("LIMIT") {
+		t := p.next()
+		n, err := strconv.Atoi(t.Text)
+		if t.Kind != SQL_NUMBER || err != nil || n < 0 {
+			p.unread(t)
+			return p.unexpected()
+		}
+		s.Limit = n
+	}
+
+	return nil
+}
+
+// u ON t.a = u.b, after SELECT * FROM t JOIN, in either order.
+func (p *sqlParser) parseJoin(s *sqlStatement) error {
+	right, err := p.ident()
+	if err != nil {
+		return err
+	}
+
+	err = p.expectKeyword("ON")
+	if err != nil {
+		return err
+	}
+
+	var sides [2][2]string
+	for i := range sides {
+		if i == 1 {
+			err = p.expectSymbol("=")
+			if err != nil {
+				return err
+			}
+		}
+
+		sides[i][0], err = p.ident()
+		if err == nil {
+			err = p.expectSymbol(".")
+		}
+		if err == nil {
+			sides[i][1], err = p.ident()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if sides[0][0] == right {
+		sides[0], sides[1] = sides[1], sides[0]
+	}
+	if sides[0][0] != s.Table || sides[1][0] != right {
+		return fmt.Errorf("%w: ON must compare a column of %s with one of %s", errSQLSyntax, s.Table, right)
+	}
+
+	s.Join = &joinSpec{s.Table, sides[0][1], right, sides[1][1]}
+	return nil
+}
+
+func (p *sqlParser) parseSubselect(s *sqlStatement) error {
+	err := p.expectKeyword("SELECT")
+	if err != nil {
+		return err
+	}
+
+	s.Select = &sqlStatement{Kind: "SELECT", Limit: -1}
+	err = p.parseSelect(s.Select)
+	if err == nil && s.Select.Join != nil {
+		return fmt.Errorf("%w: can't write the rows of a join", errSQLSyntax)
+	}
+	return err
+}
+
+func (p *sqlParser) parseDelete(s *sqlStatement) error {
+	err := p.expectKeyword("FROM")
+	if err != nil {
+		return err
+	}
+
+	s.Table, err = p.ident()
+	if err != nil {
+		return err
+	}
+
+	return p.parseWhere(s)
+}
+
+// Runs one SQL statement with args filling in its placeholders.
+func (d *client) execSQL(text string, args ...any) (*sqlResult, error) {
+	return d.execSQLContext(context.Background(), text, args...)
+}
+
+// Like execSQL but transactions it starts make their storage calls
+// with ctx, see context.go.
+func (d *client) execSQLContext(ctx context.Context, text string, args ...any) (*sqlResult, error) {
+	s, err := parseSQL(text)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) != s.Params {
+		return nil, fmt.Errorf("%w: expected %d parameters, got %d", errInvalidParameter, s.Params, len(args))
+	}
+
+	switch s.Kind {
+	case "BEGIN":
+		return &sqlResult{}, d.newTxContext(ctx)
+	case "COMMIT":
+		return &sqlResult{}, d.commitTx()
+	case "ROLLBACK":
+		return &sqlResult{}, d.abortTx()
+	}
+
+	if d.tx != nil {
+		return d.execStatement(s, args)
+	}
+
+	err = d.newTxContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := d.execStatement(s, args)
+	if err != nil {
+		d.abortTx()
+		return nil, err
+	}
+
+	return result, d.commitTx()
+}
+
+func (d *client) execStatement(s *sqlStatement, args []any) (*sqlResult, error) {
+	switch s.Kind {
+	case "CREATE TABLE":
+		if s.Select != nil {
+			return d.execCreateTableAs(s, args)
+		}
+
+		var opts []tableOption
+		if len(s.Types) > 0 {
+			opts = append(opts, withColumnTypes(s.Types))
+		}
+		if s.NotNull != nil {
+			opts = append(opts, withNotNull(s.NotNull...))
+		}
+		if s.PrimaryKey != nil {
+			opts = append(opts, withPrimaryKey(s.PrimaryKey...))
+		}
+		if len(s.Defaults) > 0 {
+			opts = append(opts, withColumnDefaults(s.Defaults))
+		}
+		return &sqlResult{}, d.createTable(s.Table, s.Columns, opts...)
+	case "INSERT":
+		if s.Select != nil {
+			return d.execInsertSelect(s, args)
+		}
+		return d.execInsert(s, args)
+	case "SELECT":
+		return d.execSelect(s, args)
+	case "DELETE":
+		filter := s.Filter
+		if filter != nil {
+			filter = filter.substitute(args)
+		}
+
+		n, err := d.deleteRows(s.Table, filter)
+		return &sqlResult{RowsAffected: n}, err
+	}
+
+	panic(fmt.Sprintf("unknown statement: %s", s.Kind))
+}
+
+func (d *client) execInsert(s *sqlStatement, args []any) (*sqlResult, error) {
+	columns, ok := d.tx.tables[s.Table]
+	if !ok {
+		return nil, errNoTable
+	}
+
+	// Where each of the statement's columns goes in a row.
+	positions := make([]int, len(s.Columns))
+	for i, column := range s.Columns {
+		positions[i] = slices.Index(columns, column)
+		if positions[i] == -1 {
+			return nil, fmt.Errorf("%w: %s.%s", errNoColumn, s.Table, column)
+		}
+	}
+
+	for _, values := range s.Rows {
+		for i, v := range values {
+			if ph, ok := v.(placeholder); ok {
+				values[i] = args[ph.Index-1]
+			}
+		}
+
+		row := values
+		if s.Columns != nil {
+			if len(values) != len(s.Columns) {
+				return nil, fmt.Errorf("%w: expected %d values, got %d", errInvalidRow, len(s.Columns), len(values))
+			}
+
+			row = make([]any, len(columns))
+			for i := range row {
+				row[i] = useDefault
+			}
+			for i, v := range values {
+				row[positions[i]] = v
+			}
+		}
+
+		err := d.writeRow(s.Table, row)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &sqlResult{RowsAffected: len(s.Rows)}, nil
+}
+
+func (d *client) execSelect(s *sqlStatement, args []any) (*sqlResult, error) {
+	if s.Join != nil {
+		return d.execJoin(s)
+	}
+
+	pq, err := d.prepare(query{s.Table, s.Columns, s.Filter})
+	if err != nil {
+		return nil, err
+	}
+
+	it, err := pq.scan(d, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &sqlResult{Columns: s.Columns}
+	if result.Columns == nil {
+		result.Columns = slices.Clone(d.tx.tables[s.Table])
+	}
+	for s.Limit == -1 || len(result.Rows) < s.Limit {
+		row, err := it.next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	return result, nil
+}
+
+func (d *client) execJoin(s *sqlStatement) (*sqlResult, error) {
+	if s.Explain {
+		explained, err := d.explainJoin(*s.Join)
+		if err != nil {
+			return nil, err
+		}
+
+		result := &sqlResult{Columns: []string{"plan"}}
+		for _, line := range strings.Split(strings.TrimSpace(explained), "\n") {
+			result.Rows = append(result.Rows, []any{line})
+		}
+		return result, nil
+	}
+
+	it, err := d.join(*s.Join)
+	if err != nil {
+		return nil, err
+	}
+	defer it.close()
+
+	result := &sqlResult{}
+	for _, table := range []string{s.Join.Left, s.Join.Right} {
+		for _, column := range d.tx.tables[table] {
+			result.Columns = append(result.Columns, table+"."+column)
+		}
+	}
+	for s.Limit == -1 || len(result.Rows) < s.Limit {
+		row, err := it.next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil {
+			break
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	return result, nil
+}
+
+// Creates s.Table with the columns, and their types, of s's SELECT
+// and fills it with the SELECT's rows.
+func (d *client) execCreateTableAs(s *sqlStatement, args []any) (*sqlResult, error) {
+	source, ok := d.tx.tables[s.Select.Table]
+	if !ok {
+		return nil, errNoTable
+	}
+
+	columns := s.Select.Columns
+	if columns == nil {
+		columns = source
+	}
+
+	history := d.tx.schemas[s.Select.Table]
+	sourceTypes := history[len(history)-1].Types
+	types := map[string]string{}
+	for _, column := range columns {
+		i := slices.Index(source, column)
+		if i == -1 {
+			return nil, fmt.Errorf("%w: %s.%s", errNoColumn, s.Select.Table, column)
+		}
+		if sourceTypes != nil && sourceTypes[i] != COLUMN_ANY {
+			types[column] = sourceTypes[i]
+		}
+	}
+
+	var opts []tableOption
+	if len(types) > 0 {
+		opts = append(opts, withColumnTypes(types))
+	}
+	err := d.createTable(s.Table, slices.Clone(columns), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.execInsertSelect(s, args)
+}
+
+// Writes the rows of s's SELECT to s.Table, recording where they came
+// from.
+func (d *client) execInsertSelect(s *sqlStatement, args []any) (*sqlResult, error) {
+	columns := s.Columns
+	if columns == nil {
+		var ok bool
+		columns, ok = d.tx.tables[s.Table]
+		if !ok {
+			return nil, errNoTable
+		}
+	}
+
+	selected, err := d.execSelect(s.Select, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(selected.Columns) != len(columns) {
+		return nil, fmt.Errorf("%w: expected %d columns, got %d", errInvalidRow, len(columns), len(selected.Columns))
+	}
+
+	result, err := d.execInsert(&sqlStatement{Table: s.Table, Columns: columns, Rows: selected.Rows}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var lineage []ColumnLineage
+	for i, column := range columns {
+		lineage = append(lineage, ColumnLineage{column, []string{s.Select.Table + "." + selected.Columns[i]}})
+	}
+
+	return result, d.recordLineage(s.Table, []string{s.Select.Table}, lineage, s.Text)
+}
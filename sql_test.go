@@ -0,0 +1,69 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSQL(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	exec := func(text string, args ...any) *sqlResult {
+		result, err := c.execSQL(text, args...)
+		assertEq(err, nil, "could not run "+text)
+		return result
+	}
+
+	exec("CREATE TABLE people (id INT, name TEXT, \"zip code\")")
+	result := exec("INSERT INTO people VALUES (1, 'Ann', '02139'), (2, 'O''Brien', NULL)")
+	assertEq(result.RowsAffected, 2, "inserted")
+	exec("insert into people (name, id) values ($1, $2);", "Cy", 3)
+	exec("INSERT INTO people VALUES (-4, 'Dee', '10001')")
+
+	result = exec("SELECT * FROM people")
+	assertEq(fmt.Sprint(result.Columns), "[id name zip code]", "columns")
+	assertEq(fmt.Sprint(result.Rows), "[[1 Ann 02139] [2 O'Brien <nil>] [3 Cy <nil>] [-4 Dee 10001]]", "rows")
+
+	result = exec("SELECT name FROM people WHERE id >= 2 AND (name = 'Cy' OR 3 > id) LIMIT 5")
+	assertEq(fmt.Sprint(result.Rows), "[[O'Brien] [Cy]]", "filtered rows")
+
+	result = exec("SELECT id FROM people WHERE id < $1 LIMIT 2", 10)
+	assertEq(fmt.Sprint(result.Rows), "[[1] [2]]", "limited rows")
+
+	// Within an explicit transaction.
+	exec("BEGIN")
+	result = exec("DELETE FROM people WHERE id = 2 OR id = -4")
+	assertEq(result.RowsAffected, 2, "deleted")
+	exec("ROLLBACK")
+	result = exec("SELECT id FROM people WHERE id <> 1")
+	assertEq(len(result.Rows), 3, "rows after rollback")
+
+	exec("BEGIN")
+	exec("DELETE FROM people WHERE id = 2")
+	exec("COMMIT")
+	result = exec("DELETE FROM people")
+	assertEq(result.RowsAffected, 3, "deleted everything")
+	result = exec("SELECT * FROM people")
+	assertEq(len(result.Rows), 0, "rows left")
+
+	for _, bad := range []string{
+		"SELECT FROM people",
+		"SELECT * FROM people WHERE",
+		"SELECT * FROM people LIMIT -1",
+		"CREATE TABLE t (a BLOB)",
+		"INSERT INTO people VALUES (1, 'unterminated)",
+		"UPDATE people SET id = 1",
+		"SELECT * FROM people; SELECT",
+	} {
+		_, err := c.execSQL(bad)
+		assert(errors.Is(err, errSQLSyntax), fmt.Sprintf("expected syntax error for %s, got %v", bad, err))
+	}
+
+	_, err := c.execSQL("SELECT * FROM people WHERE id = $1")
+	assert(errors.Is(err, errInvalidParameter), "missing parameter")
+	_, err = c.execSQL("INSERT INTO people (id, nope) VALUES (1, 2)")
+	assert(errors.Is(err, errNoColumn), "unknown column")
+	_, err = c.execSQL("INSERT INTO people VALUES ('x', 'y', 'z')")
+	assert(errors.Is(err, errTypeMismatch), "mistyped value")
+	assertEq(c.tx, (*transaction)(nil), "transaction left open")
+}
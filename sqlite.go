@@ -0,0 +1,235 @@
+package otf
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Moves tables between otf and SQLite databases through
+// database/sql, so callers bring their own driver (mattn/go-sqlite3,
+// modernc.org/sqlite, ...) the same as for postgresSyncDestination.
+//
+// SQLite columns are untyped so only column names are carried over.
+// BLOBs are imported as strings. On export, whole numbers are
+// written as integers since JSON doesn't distinguish them from
+// floats.
+
+const sqliteTablesSQL = `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`
+
+func sqliteCreateTableSQL(table string, columns []string) string {
+	var cols []string
+	for _, c := range columns {
+		cols = append(cols, quoteIdent(c))
+	}
+
+	return fmt.Sprintf("CREATE TABLE %s (%s)", quoteIdent(table), strings.Join(cols, ", "))
+}
+
+func sqliteInsertSQL(table string, columns []string) string {
+	var cols, params []string
+	for _, c := range columns {
+		cols = append(cols, quoteIdent(c))
+		params = append(params, "?")
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quoteIdent(table), strings.Join(cols, ", "), strings.Join(params, ", "))
+}
+
+// Imports every table in db (or only the ones given) into new otf
+// tables of the same name, in a single transaction. Uses and commits
+// its own transaction. Returns the number of rows imported per table.
+func (d *client) importSQLite(db *sql.DB, tables ...string) (map[string]int, error) {
+	if len(tables) == 0 {
+		rows, err := db.Query(sqliteTablesSQL)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var name string
+			err = rows.Scan(&name)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			tables = append(tables, name)
+		}
+
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	err := d.newTx()
+	if err != nil {
+		return nil, err
+	}
+
+	imported := map[string]int{}
+	for _, table := range tables {
+		imported[table], err = d.importSQLiteTable(db, table)
+		if err != nil {
+			d.abortTx()
+			return nil, err
+		}
+	}
+
+	return imported, d.commitTx()
+}
+
+func (d *client) importSQLiteTable(db *sql.DB, table string) (int, error) {
+	rows, err := db.Query("SELECT * FROM " + quoteIdent(table))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+
+	err = d.createTable(table, columns)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for rows.Next() {
+		row := make([]any, len(columns))
+		ptrs := make([]any, len(columns))
+		for i := range row {
+			ptrs[i] = &row[i]
+		}
+
+		err = rows.Scan(ptrs...)
+		if err != nil {
+			return n, err
+		}
+
+		for i, v := range row {
+			if b, ok := v.([]byte); ok {
+				row[i] = string(b)
+			}
+		}
+
+		err = d.writeRow(table, row)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	d.debug("sqlite", "imported rows", "table", table, "rows", n)
+	return n, rows.Err()
+}
+
+func sqliteValue(v any) (any, error) {
+	switch n := v.(type) {
+	case nil, bool, string, int, int64:
+		return v, nil
+	case float64:
+		if n == math.Trunc(n) && math.Abs(n) < 1<<53 {
+			return int64(n), nil
+		}
+		return n, nil
+	}
+
+	// Nested JSON values.
+	bytes, err := json.Marshal(v)
+	return string(bytes), err
+}
+
+// Exports the latest snapshot of every table (or only the ones
+// given) into db, which must not already have tables of the same
+// names. Internal tables (prefixed with _) are skipped unless asked
+// for. Uses and then ends its own transaction.
+func (d *client) exportSQLite(db *sql.DB, tables ...string) (map[string]int, error) {
+	err := d.newTx()
+	if err != nil {
+		return nil, err
+	}
+	// Nothing gets written to d.
+	defer func() { d.tx = nil }()
+
+	if len(tables) == 0 {
+		for table := range d.tx.tables {
+			if !strings.HasPrefix(table, "_") {
+				tables = append(tables, table)
+			}
+		}
+		slices.Sort(tables)
+	}
+
+	sqlTx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer sqlTx.Rollback()
+
+	exported := map[string]int{}
+	for _, table := range tables {
+		exported[table], err = d.exportSQLiteTable(sqlTx, table)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return exported, sqlTx.Commit()
+}
+
+func (d *client) exportSQLiteTable(sqlTx *sql.Tx, table string) (int, error) {
+	columns, ok := d.tx.tables[table]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", errNoTable, table)
+	}
+
+	_, err := sqlTx.Exec(sqliteCreateTableSQL(table, columns))
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err := sqlTx.Prepare(sqliteInsertSQL(table, columns))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	it, err := d.scan(table)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for {
+		row, err := it.next()
+		if err != nil {
+			return n, err
+		}
+
+		if row == nil {
+			break
+		}
+
+		for i := range row {
+			row[i], err = sqliteValue(row[i])
+			if err != nil {
+				return n, err
+			}
+		}
+
+		_, err = stmt.Exec(row...)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	d.debug("sqlite", "exported rows", "table", table, "rows", n)
+	return n, nil
+}
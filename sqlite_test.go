@@ -0,0 +1,33 @@
+package otf
+
+import (
+	"testing"
+)
+
+func TestSQLiteSQL(t *testing.T) {
+	assertEq(
+		sqliteCreateTableSQL("users", []string{"id", `na"me`}),
+		`CREATE TABLE "users" ("id", "na""me")`,
+		"create table")
+	assertEq(
+		sqliteInsertSQL("users", []string{"id", "name"}),
+		`INSERT INTO "users" ("id", "name") VALUES (?, ?)`,
+		"insert")
+}
+
+func TestSQLiteValue(t *testing.T) {
+	for _, test := range []struct {
+		in  any
+		out any
+	}{
+		{nil, nil},
+		{"Joey", "Joey"},
+		{float64(3), int64(3)},
+		{1.5, 1.5},
+		{map[string]any{"a": 1.0}, `{"a":1}`},
+	} {
+		out, err := sqliteValue(test.in)
+		assertEq(err, nil, "could not convert")
+		assertEq(out, test.out, "converted value")
+	}
+}
@@ -0,0 +1,74 @@
+package otf
+
+// Per-column statistics recorded in each AddDataobject action when
+// it is flushed. Filtered scans use them to skip dataobjects that
+// can't contain a matching row without reading them.
+//
+// Min and Max are only recorded when every non-null value in the
+// column is of the same kind (numbers, strings or booleans), since
+// only then is there an order to take them from.
+type columnStats struct {
+	Min   any `json:",omitempty"`
+	Max   any `json:",omitempty"`
+	Nulls int `json:",omitempty"`
+}
+
+func batchStats(columns []string, b *batch) map[string]columnStats {
+	stats := map[string]columnStats{}
+	for i, name := range columns {
+		var s columnStats
+		comparable := true
+		for _, v := range b.Columns[i] {
+			if v == nil {
+				s.Nulls++
+				continue
+			}
+
+			if !comparable {
+				continue
+			}
+
+			if s.Min == nil {
+				if _, ok := compareValues(v, v); !ok {
+					comparable = false
+					continue
+				}
+
+				s.Min, s.Max = v, v
+				continue
+			}
+
+			c, ok := compareValues(v, s.Min)
+			if !ok {
+				comparable = false
+				continue
+			}
+			if c < 0 {
+				s.Min = v
+			}
+
+			if c, _ := compareValues(v, s.Max); c > 0 {
+				s.Max = v
+			}
+		}
+
+		if !comparable {
+			s.Min, s.Max = nil, nil
+		}
+
+		stats[name] = s
+	}
+
+	return stats
+}
+
+// Whether a dataobject of the given number of rows and statistics
+// could hold a row matching p. Errs on the side of true when there
+// is nothing to go on.
+func statsMayMatch(stats map[string]columnStats, rows int, p *predicate) bool {
+	if stats == nil {
+		return true
+	}
+
+	return !p.expr().simplifyWithStats(stats, rows).neverTrue()
+}
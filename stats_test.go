@@ -0,0 +1,82 @@
+package otf
+
+import (
+	"testing"
+)
+
+func TestBatchStats(t *testing.T) {
+	b := newBatch(3)
+	b.appendRow([]any{3, "b", nil})
+	b.appendRow([]any{1.5, nil, nil})
+	b.appendRow([]any{2, 1, nil})
+
+	stats := batchStats([]string{"a", "b", "c"}, b)
+	assertEq(stats["a"].Min, 1.5, "min")
+	assertEq(stats["a"].Max, 3, "max")
+	assertEq(stats["a"].Nulls, 0, "nulls")
+	// Mixed kinds have no order.
+	assertEq(stats["b"].Min, nil, "min")
+	assertEq(stats["b"].Nulls, 1, "nulls")
+	assertEq(stats["c"].Nulls, 3, "nulls")
+
+	for _, test := range []struct {
+		p     *predicate
+		match bool
+	}{
+		{where("a", OP_EQ, 2), true},
+		{where("a", OP_EQ, 4), false},
+		{where("a", OP_LT, 1.5), false},
+		{where("a", OP_LTE, 1.5), true},
+		{where("a", OP_GT, 3), false},
+		{where("a", OP_GTE, 3), true},
+		{where("a", OP_EQ, "x"), false},
+		{where("b", OP_EQ, "x"), true},
+		{where("c", OP_EQ, 1), false},
+		{and(where("a", OP_EQ, 2), where("c", OP_EQ, 1)), false},
+		{or(where("a", OP_EQ, 2), where("c", OP_EQ, 1)), true},
+	} {
+		assertEq(statsMayMatch(stats, b.Len, test.p), test.match, test.p.String())
+	}
+}
+
+func TestScanSkipsDataobjectsByStats(t *testing.T) {
+	// Only called for dataobjects that got past their statistics.
+	read := 0
+	c := newClient(newMemoryObjectStorage(), withDataobjectFilter(func(string, *DataobjectAction, *predicate) bool {
+		read++
+		return true
+	}))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"day", "value"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	for day := 0; day < 5; day++ {
+		err = c.newTx()
+		assertEq(err, nil, "could not start tx")
+		for i := 0; i < 10; i++ {
+			err = c.writeRow("x", []any{day, i})
+			assertEq(err, nil, "could not write row")
+		}
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	it, err := c.scan("x", withFilter(where("day", OP_GTE, 3)))
+	assertEq(err, nil, "could not scan")
+	rows := 0
+	for {
+		row, err := it.next()
+		assertEq(err, nil, "could not iterate scan")
+		if row == nil {
+			break
+		}
+		rows++
+	}
+	assertEq(read, 2, "dataobjects read")
+	assertEq(rows, 20, "matching rows")
+}
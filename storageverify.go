@@ -0,0 +1,203 @@
+package otf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// A canary probe (see canary.go) only ever races itself. Verifying a
+// backend races it against itself on purpose, probing what commits
+// rely on with concurrent calls:
+//
+//   - putIfAbsent atomicity: of several concurrent puts of one name
+//     exactly one succeeds, the others fail with fs.ErrExist, and the
+//     object read back is the winner's;
+//   - read-after-write: an object put is read back straight after,
+//     by the goroutine that put it, while others are putting theirs;
+//   - listing consistency: once the puts are done, listing their
+//     prefix returns every one of them, in order.
+//
+// Probe objects are named CANARY_PREFIX, an id for the run and a
+// suffix, and deleted afterwards. `otf doctor` verifies the backend
+// along with its probes, and clients with withStorageVerification
+// (`otf create-table` is one) verify it before creating their first
+// table, refusing with errUnsafeStorage if it fails.
+
+// Concurrent calls of each check.
+const STORAGE_VERIFY_CONCURRENCY = 8
+
+type storageCheck struct {
+	Name string
+	// What the check found wrong, empty if nothing.
+	Error string `json:",omitempty"`
+}
+
+type storageVerification struct {
+	Checks []storageCheck
+}
+
+func (sv storageVerification) err() error {
+	for _, check := range sv.Checks {
+		if check.Error != "" {
+			return fmt.Errorf("%w: %s: %s", errUnsafeStorage, check.Name, check.Error)
+		}
+	}
+
+	return nil
+}
+
+// Runs each check with concurrency calls at once, see above,
+// logging to logger, if not nil, probe objects it can't clean up.
+func verifyStorage(ctx context.Context, os objectStorage, concurrency int, logger *slog.Logger) storageVerification {
+	prefix := CANARY_PREFIX + uuidv4() + "_"
+	var mu sync.Mutex
+	var written []string
+	put := func(name string, body []byte) error {
+		err := os.putIfAbsent(ctx, name, body)
+		if err == nil {
+			mu.Lock()
+			written = append(written, name)
+			mu.Unlock()
+		}
+		return err
+	}
+	defer func() {
+		for _, name := range written {
+			err := os.delete(ctx, name)
+			if err != nil {
+				logTo(logger, slog.LevelWarn, "verify", "could not delete probe object", "name", name, "err", err)
+			}
+		}
+	}()
+
+	// Runs f(0) through f(concurrency-1) at once and returns their
+	// errors.
+	race := func(f func(i int) error) []error {
+		errs := make([]error, concurrency)
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := range concurrency {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				errs[i] = f(i)
+			}()
+		}
+		close(start)
+		wg.Wait()
+		return errs
+	}
+
+	var sv storageVerification
+	check := func(name string, f func() string) {
+		sv.Checks = append(sv.Checks, storageCheck{name, f()})
+	}
+
+	check("putIfAbsent atomicity", func() string {
+		name := prefix + "atomic"
+		errs := race(func(i int) error {
+			return put(name, []byte(strconv.Itoa(i)))
+		})
+
+		winner := -1
+		for i, err := range errs {
+			switch {
+			case err == nil && winner != -1:
+				return fmt.Sprintf("puts %d and %d of %s both succeeded", winner, i, name)
+			case err == nil:
+				winner = i
+			case !errors.Is(err, fs.ErrExist):
+				return fmt.Sprintf("put %d of %s: %s, expected fs.ErrExist", i, name, err)
+			}
+		}
+		if winner == -1 {
+			return fmt.Sprintf("no put of %s succeeded", name)
+		}
+
+		read, err := os.read(ctx, name)
+		if err != nil {
+			return fmt.Sprintf("read %s: %s", name, err)
+		}
+		if string(read) != strconv.Itoa(winner) {
+			return fmt.Sprintf("read %q from %s, put %d won", read, name, winner)
+		}
+		return ""
+	})
+
+	var names []string
+	for i := range concurrency {
+		names = append(names, fmt.Sprintf("%sobject_%04d", prefix, i))
+	}
+	check("read-after-write", func() string {
+		errs := race(func(i int) error {
+			body := []byte(names[i])
+			err := put(names[i], body)
+			if err != nil {
+				return fmt.Errorf("put %s: %w", names[i], err)
+			}
+
+			read, err := os.read(ctx, names[i])
+			if err != nil {
+				return fmt.Errorf("read %s straight after putting it: %w", names[i], err)
+			}
+			if !bytes.Equal(read, body) {
+				return fmt.Errorf("read %q from %s straight after putting %q", read, names[i], body)
+			}
+			return nil
+		})
+
+		if err := errors.Join(errs...); err != nil {
+			return err.Error()
+		}
+		return ""
+	})
+
+	check("listing consistency", func() string {
+		listed, err := os.listPrefix(ctx, prefix+"object_")
+		if err != nil {
+			return fmt.Sprintf("list: %s", err)
+		}
+		if !slices.IsSorted(listed) {
+			return fmt.Sprintf("listed %d names out of order", len(listed))
+		}
+		for _, name := range names {
+			if !slices.Contains(listed, name) {
+				return fmt.Sprintf("listing after the puts doesn't have %s", name)
+			}
+		}
+		return ""
+	})
+
+	return sv
+}
+
+// Verifies the storage before creating the client's first table, see
+// above.
+func withStorageVerification() clientOption {
+	return func(c *client) {
+		c.verifyStorage = true
+	}
+}
+
+func (d *client) checkStorageVerified() error {
+	if !d.verifyStorage || d.storageVerified {
+		return nil
+	}
+
+	sv := verifyStorage(d.context(), d.os, STORAGE_VERIFY_CONCURRENCY, d.logger)
+	err := sv.err()
+	if err != nil {
+		return err
+	}
+
+	d.storageVerified = true
+	return nil
+}
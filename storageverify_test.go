@@ -0,0 +1,73 @@
+package otf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Reads of an object fail the first time, and listings leave out the
+// latest object.
+type eventualStorage struct {
+	*memoryObjectStorage
+	seen sync.Map
+}
+
+func (es *eventualStorage) read(ctx context.Context, name string) ([]byte, error) {
+	if _, seen := es.seen.LoadOrStore(name, true); !seen {
+		return nil, fmt.Errorf("%w: %s", fs.ErrNotExist, name)
+	}
+
+	return es.memoryObjectStorage.read(ctx, name)
+}
+
+func (es *eventualStorage) listPrefix(ctx context.Context, prefix string) ([]string, error) {
+	names, err := es.memoryObjectStorage.listPrefix(ctx, prefix)
+	if len(names) > 0 {
+		names = names[:len(names)-1]
+	}
+	return names, err
+}
+
+func TestVerifyStorage(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	sv := verifyStorage(context.Background(), mos, 4, nil)
+	assertEq(sv.err(), nil, "verify memory storage")
+	assertEq(len(sv.Checks), 3, "checks")
+	names, err := mos.listPrefix(context.Background(), CANARY_PREFIX)
+	assertEq(err, nil, "could not list")
+	assertEq(len(names), 0, "verify objects left behind")
+
+	sv = verifyStorage(context.Background(), unconditionalStorage{newMemoryObjectStorage()}, 4, nil)
+	assert(errors.Is(sv.err(), errUnsafeStorage), "verified unconditional storage")
+	assert(strings.Contains(sv.Checks[0].Error, "both succeeded"), "atomicity: "+sv.Checks[0].Error)
+
+	sv = verifyStorage(context.Background(), &eventualStorage{memoryObjectStorage: newMemoryObjectStorage()}, 4, nil)
+	assert(errors.Is(sv.err(), errUnsafeStorage), "verified eventual storage")
+	assert(sv.Checks[1].Error != "", "read-after-write passed")
+	assert(sv.Checks[2].Error != "", "listing consistency passed")
+}
+
+func TestStorageVerificationOnCreateTable(t *testing.T) {
+	c := newClient(unconditionalStorage{newMemoryObjectStorage()}, withStorageVerification())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assert(errors.Is(err, errUnsafeStorage), "created a table on unsafe storage")
+	c.abortTx()
+
+	c = newClient(newMemoryObjectStorage(), withStorageVerification())
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	assert(c.storageVerified, "not verified")
+	err = c.createTable("y", []string{"a"})
+	assertEq(err, nil, "could not create y")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+}
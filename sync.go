@@ -0,0 +1,349 @@
+package otf
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	CHANGE_INSERT = "insert"
+	CHANGE_DELETE = "delete"
+)
+
+type tableChange struct {
+	TxId int
+	Op   string
+	Row  []any
+}
+
+// Returns the changes to table made by each committed transaction
+// after the given one, grouped by transaction in commit order, see
+// cdc.go. Transactions that didn't touch table are skipped.
+func (d *client) tableChangesSince(table string, after int) ([][]tableChange, error) {
+	it, err := d.changesSince(after)
+	if err != nil {
+		return nil, err
+	}
+	it.only = table
+
+	var txs [][]rowChange
+	for {
+		changes, err := it.nextTx()
+		if err != nil {
+			return nil, err
+		}
+		if changes == nil {
+			break
+		}
+
+		txs = append(txs, changes)
+	}
+
+	// Rows are returned in the latest schema, see schema.go.
+	history := it.table(table).history
+	changes := make([][]tableChange, len(txs))
+	for i, txChanges := range txs {
+		for _, change := range txChanges {
+			row := history.convertRow(change.version, change.Row)
+			changes[i] = append(changes[i], tableChange{change.TxId, change.Op, row})
+		}
+	}
+
+	return changes, nil
+}
+
+// Somewhere to replicate a table's changes to. Implementations
+// must apply a transaction's changes and record its id atomically
+// so that every source transaction is applied exactly once even if
+// a sync is interrupted and retried.
+type syncDestination interface {
+	// The id of the last source transaction applied, or -1.
+	lastApplied(table string) (int, error)
+	apply(table string, txId int, changes []tableChange) error
+}
+
+// Applies every change to table not yet seen by dst. Returns the
+// number of source transactions applied.
+func (d *client) syncTable(table string, dst syncDestination) (int, error) {
+	last, err := dst.lastApplied(table)
+	if err != nil {
+		return 0, err
+	}
+
+	changes, err := d.tableChangesSince(table, last)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, txChanges := range changes {
+		err = dst.apply(table, txChanges[0].TxId, txChanges)
+		if err != nil {
+			return i, err
+		}
+
+		d.debug("sync", "applied changes", "table", table, "changes", len(txChanges), "from", txChanges[0].TxId)
+	}
+
+	return len(changes), nil
+}
+
+// Replicates into a table of another otf store, recording applied
+// transaction ids in that store's SYNC_VERSIONS_TABLE in the same
+// transaction as the changes. Each delete deletes one row with the
+// same values, so updates and compactions, which delete rows and
+// insert them again, replicate too.
+type otfSyncDestination struct {
+	c     *client
+	table string
+}
+
+const SYNC_VERSIONS_TABLE = "_sync_versions"
+
+var syncVersionsColumns = []string{"source_table", "tx_id"}
+
+// Equal for rows with equal values, comparing numbers by value
+// whatever their type.
+func syncRowKey(row []any) string {
+	values := make([]any, len(row))
+	for i, v := range row {
+		if f, ok := toFloat64(v); ok {
+			v = f
+		}
+		values[i] = v
+	}
+
+	bytes, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Sprintf("%#v", values)
+	}
+	return string(bytes)
+}
+
+func (osd *otfSyncDestination) lastApplied(table string) (int, error) {
+	err := osd.c.newTx()
+	if err != nil {
+		return -1, err
+	}
+	defer func() { osd.c.tx = nil }()
+
+	last := int64(-1)
+	if _, ok := osd.c.tx.tables[SYNC_VERSIONS_TABLE]; !ok {
+		return -1, nil
+	}
+
+	it, err := osd.c.scan(SYNC_VERSIONS_TABLE)
+	if err != nil {
+		return -1, err
+	}
+
+	for {
+		row, err := it.next()
+		if err != nil {
+			return -1, err
+		}
+
+		if row == nil {
+			break
+		}
+
+		if id, ok := toInt64(row[1]); ok && row[0] == table {
+			last = max(last, id)
+		}
+	}
+
+	return int(last), nil
+}
+
+func (osd *otfSyncDestination) apply(table string, txId int, changes []tableChange) error {
+	err := osd.c.newTx()
+	if err != nil {
+		return err
+	}
+
+	if _, ok := osd.c.tx.tables[SYNC_VERSIONS_TABLE]; !ok {
+		err = osd.c.createTable(SYNC_VERSIONS_TABLE, syncVersionsColumns, withColumnTypes(map[string]string{
+			"source_table": COLUMN_STRING,
+			"tx_id":        COLUMN_INT,
+		}))
+		if err != nil {
+			osd.c.tx = nil
+			return err
+		}
+	}
+
+	for i := 0; i < len(changes); {
+		switch changes[i].Op {
+		case CHANGE_INSERT:
+			err = osd.c.writeRow(osd.table, changes[i].Row)
+			i++
+		case CHANGE_DELETE:
+			// A run of deletes, applied in one pass. Rows have
+			// no identity beyond their values, so each deletes
+			// one row with the same values.
+			pending := map[string]int{}
+			n := 0
+			for ; i < len(changes) && changes[i].Op == CHANGE_DELETE; i++ {
+				pending[syncRowKey(changes[i].Row)]++
+				n++
+			}
+
+			var deleted int
+			deleted, err = osd.c.deleteRowsFunc(osd.table, nil, func(b *batch, i int) bool {
+				key := syncRowKey(b.row(i))
+				if pending[key] == 0 {
+					return false
+				}
+
+				pending[key]--
+				return true
+			})
+			if err == nil && deleted < n {
+				osd.c.warn("sync", "rows to delete not found", "table", osd.table, "tx", txId, "missing", n-deleted)
+			}
+		default:
+			err = fmt.Errorf("unsupported change for otf destination: %s", changes[i].Op)
+		}
+		if err != nil {
+			osd.c.tx = nil
+			return err
+		}
+	}
+
+	err = osd.c.writeRow(SYNC_VERSIONS_TABLE, []any{table, txId})
+	if err != nil {
+		osd.c.tx = nil
+		return err
+	}
+
+	return osd.c.commitTx()
+}
+
+// Replicates into a Postgres table through database/sql (bring
+// your own driver). Inserts become upserts on the key columns so
+// rows can be corrected by later changes, deletes delete by key.
+// Applied transaction ids are kept in otf_sync_state, updated in
+// the same SQL transaction as the changes.
+type postgresSyncDestination struct {
+	db      *sql.DB
+	table   string
+	columns []string
+	keys    []string
+	// Of the key columns in columns.
+	positions []int
+}
+
+// Replicates into table of db, whose rows have columns and are keyed
+// on keys, failing with errNoColumn if a key isn't one of columns.
+func newPostgresSyncDestination(db *sql.DB, table string, columns, keys []string) (*postgresSyncDestination, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: no key columns for %s", errBadRequest, table)
+	}
+
+	positions := make([]int, len(keys))
+	for i, k := range keys {
+		positions[i] = slices.Index(columns, k)
+		if positions[i] == -1 {
+			return nil, fmt.Errorf("%w: %s.%s", errNoColumn, table, k)
+		}
+	}
+
+	return &postgresSyncDestination{db, table, columns, keys, positions}, nil
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func postgresUpsertSQL(table string, columns, keys []string) string {
+	var cols, params, updates, conflict []string
+	for i, c := range columns {
+		cols = append(cols, quoteIdent(c))
+		params = append(params, fmt.Sprintf("$%d", i+1))
+		updates = append(updates, fmt.Sprintf("%s = EXCLUDED.%s", quoteIdent(c), quoteIdent(c)))
+	}
+	for _, k := range keys {
+		conflict = append(conflict, quoteIdent(k))
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s) ON CONFLICT (%s) DO UPDATE SET %s",
+		quoteIdent(table), strings.Join(cols, ", "), strings.Join(params, ", "),
+		strings.Join(conflict, ", "), strings.Join(updates, ", "))
+}
+
+func postgresDeleteSQL(table string, keys []string) string {
+	var conds []string
+	for i, k := range keys {
+		conds = append(conds, fmt.Sprintf("%s = $%d", quoteIdent(k), i+1))
+	}
+
+	return fmt.Sprintf("DELETE FROM %s WHERE %s", quoteIdent(table), strings.Join(conds, " AND "))
+}
+
+const postgresSyncStateSQL = `CREATE TABLE IF NOT EXISTS otf_sync_state (
+	source_table TEXT PRIMARY KEY,
+	tx_id BIGINT NOT NULL
+)`
+
+func (psd *postgresSyncDestination) lastApplied(table string) (int, error) {
+	_, err := psd.db.Exec(postgresSyncStateSQL)
+	if err != nil {
+		return -1, err
+	}
+
+	var last int
+	err = psd.db.QueryRow("SELECT tx_id FROM otf_sync_state WHERE source_table = $1", table).Scan(&last)
+	if err == sql.ErrNoRows {
+		return -1, nil
+	}
+
+	return last, err
+}
+
+func (psd *postgresSyncDestination) keyValues(row []any) []any {
+	values := make([]any, len(psd.positions))
+	for i, position := range psd.positions {
+		values[i] = row[position]
+	}
+
+	return values
+}
+
+func (psd *postgresSyncDestination) apply(table string, txId int, changes []tableChange) error {
+	tx, err := psd.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	upsert := postgresUpsertSQL(psd.table, psd.columns, psd.keys)
+	del := postgresDeleteSQL(psd.table, psd.keys)
+	for _, change := range changes {
+		if len(change.Row) != len(psd.columns) {
+			return fmt.Errorf("%w: %d values for %d columns of %s", errBadRequest, len(change.Row), len(psd.columns), psd.table)
+		}
+
+		switch change.Op {
+		case CHANGE_INSERT:
+			_, err = tx.Exec(upsert, change.Row...)
+		case CHANGE_DELETE:
+			_, err = tx.Exec(del, psd.keyValues(change.Row)...)
+		default:
+			err = fmt.Errorf("unsupported change: %s", change.Op)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO otf_sync_state (source_table, tx_id) VALUES ($1, $2)
+ON CONFLICT (source_table) DO UPDATE SET tx_id = EXCLUDED.tx_id`, table, txId)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
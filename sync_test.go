@@ -0,0 +1,324 @@
+package otf
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSyncToOtfIsExactlyOnce(t *testing.T) {
+	src := newClient(newMemoryObjectStorage())
+	dstClient := newClient(newMemoryObjectStorage())
+	dst := &otfSyncDestination{&dstClient, "x_copy"}
+
+	err := dstClient.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = dstClient.createTable("x_copy", []string{"a", "b"})
+	assertEq(err, nil, "could not create x_copy")
+	err = dstClient.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = src.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = src.createTable("x", []string{"a", "b"})
+	assertEq(err, nil, "could not create x")
+	err = src.createTable("y", []string{"a"})
+	assertEq(err, nil, "could not create y")
+	err = src.writeRow("x", []any{"Joey", 1})
+	assertEq(err, nil, "could not write row")
+	err = src.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Doesn't touch x so has nothing to sync.
+	err = src.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = src.writeRow("y", []any{"Yue"})
+	assertEq(err, nil, "could not write row")
+	err = src.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	applied, err := src.syncTable("x", dst)
+	assertEq(err, nil, "could not sync")
+	assertEq(applied, 1, "applied transactions")
+
+	err = src.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = src.writeRow("x", []any{"Ada", 3})
+	assertEq(err, nil, "could not write row")
+	err = src.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	applied, err = src.syncTable("x", dst)
+	assertEq(err, nil, "could not sync")
+	assertEq(applied, 1, "applied transactions")
+
+	// Nothing new.
+	applied, err = src.syncTable("x", dst)
+	assertEq(err, nil, "could not sync")
+	assertEq(applied, 0, "applied transactions")
+
+	assertEq(countRows(&dstClient, "x_copy"), 2, "rows in x_copy")
+}
+
+func TestSyncDeletesToOtf(t *testing.T) {
+	src := newClient(newMemoryObjectStorage())
+	dstClient := newClient(newMemoryObjectStorage())
+	dst := &otfSyncDestination{&dstClient, "x_copy"}
+
+	err := dstClient.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = dstClient.createTable("x_copy", []string{"a", "b"})
+	assertEq(err, nil, "could not create x_copy")
+	err = dstClient.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = src.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = src.createTable("x", []string{"a", "b"})
+	assertEq(err, nil, "could not create x")
+	err = src.commitTx()
+	assertEq(err, nil, "could not commit")
+	writeTx(&src, "x", []any{"Joey", 1}, []any{"Joey", 1}, []any{"Ada", 2})
+	writeTx(&src, "x", []any{"Joey", 1})
+
+	synced := func(op string, rows int) {
+		_, err := src.syncTable("x", dst)
+		assertEq(err, nil, "could not sync "+op)
+		assertEq(countRows(&src, "x"), rows, "rows in x after "+op)
+		assertEq(countRows(&dstClient, "x_copy"), rows, "rows in x_copy after "+op)
+	}
+	synced("insert", 4)
+
+	err = src.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = src.deleteRows("x", where("a", OP_EQ, "Ada"))
+	assertEq(err, nil, "could not delete")
+	err = src.commitTx()
+	assertEq(err, nil, "could not commit")
+	synced("delete", 3)
+
+	// Removes every row and adds them back, so one delete mustn't
+	// delete every copy of a row.
+	err = src.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = src.compact("x")
+	assertEq(err, nil, "could not compact")
+	err = src.commitTx()
+	assertEq(err, nil, "could not commit")
+	synced("compaction", 3)
+
+	err = src.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = src.updateRows("x", where("a", OP_EQ, "Joey"), map[string]*expr{"b": litExpr(5)})
+	assertEq(err, nil, "could not update")
+	err = src.commitTx()
+	assertEq(err, nil, "could not commit")
+	synced("update", 3)
+
+	err = dstClient.newTx()
+	assertEq(err, nil, "could not start tx")
+	for _, row := range scanAll(&dstClient, "x_copy") {
+		assertEq(fmt.Sprint(row[1]), "5", "updated row")
+	}
+	err = dstClient.abortTx()
+	assertEq(err, nil, "could not abort")
+}
+
+func TestPostgresSyncSQL(t *testing.T) {
+	assertEq(
+		postgresUpsertSQL("users", []string{"id", "name"}, []string{"id"}),
+		`INSERT INTO "users" ("id", "name") VALUES ($1, $2) ON CONFLICT ("id") DO UPDATE SET "id" = EXCLUDED."id", "name" = EXCLUDED."name"`,
+		"upsert")
+	assertEq(
+		postgresDeleteSQL("users", []string{"id", "region"}),
+		`DELETE FROM "users" WHERE "id" = $1 AND "region" = $2`,
+		"delete")
+}
+
+// Just enough of Postgres through database/sql for
+// postgresSyncDestination: a table keyed on its first column and
+// otf_sync_state, changed when a transaction commits. Statements
+// containing fail fail.
+type fakePostgres struct {
+	rows  map[string][]driver.Value
+	state map[string]int64
+	fail  string
+
+	pending []func()
+}
+
+func (fp *fakePostgres) Connect(context.Context) (driver.Conn, error) {
+	return fp, nil
+}
+
+func (fp *fakePostgres) Driver() driver.Driver {
+	return nil
+}
+
+func (fp *fakePostgres) Prepare(query string) (driver.Stmt, error) {
+	return fakePostgresStmt{fp, query}, nil
+}
+
+func (fp *fakePostgres) Close() error {
+	return nil
+}
+
+func (fp *fakePostgres) Begin() (driver.Tx, error) {
+	fp.pending = nil
+	return fp, nil
+}
+
+func (fp *fakePostgres) Commit() error {
+	for _, apply := range fp.pending {
+		apply()
+	}
+	fp.pending = nil
+	return nil
+}
+
+func (fp *fakePostgres) Rollback() error {
+	fp.pending = nil
+	return nil
+}
+
+type fakePostgresStmt struct {
+	fp    *fakePostgres
+	query string
+}
+
+func (fs fakePostgresStmt) Close() error {
+	return nil
+}
+
+func (fs fakePostgresStmt) NumInput() int {
+	return -1
+}
+
+func (fs fakePostgresStmt) Exec(args []driver.Value) (driver.Result, error) {
+	fp := fs.fp
+	if fp.fail != "" && strings.Contains(fs.query, fp.fail) {
+		return nil, fmt.Errorf("failed: %s", fs.query)
+	}
+
+	switch {
+	case strings.HasPrefix(fs.query, "CREATE TABLE"):
+	case strings.HasPrefix(fs.query, "INSERT INTO otf_sync_state"):
+		fp.pending = append(fp.pending, func() { fp.state[args[0].(string)] = args[1].(int64) })
+	case strings.HasPrefix(fs.query, "INSERT INTO"):
+		fp.pending = append(fp.pending, func() { fp.rows[fmt.Sprint(args[0])] = args })
+	case strings.HasPrefix(fs.query, "DELETE FROM"):
+		fp.pending = append(fp.pending, func() { delete(fp.rows, fmt.Sprint(args[0])) })
+	default:
+		return nil, fmt.Errorf("unexpected statement: %s", fs.query)
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+func (fs fakePostgresStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if !strings.HasPrefix(fs.query, "SELECT tx_id FROM otf_sync_state") {
+		return nil, fmt.Errorf("unexpected query: %s", fs.query)
+	}
+
+	rows := &fakePostgresRows{}
+	if id, ok := fs.fp.state[args[0].(string)]; ok {
+		rows.ids = append(rows.ids, id)
+	}
+	return rows, nil
+}
+
+type fakePostgresRows struct {
+	ids []int64
+}
+
+func (fr *fakePostgresRows) Columns() []string {
+	return []string{"tx_id"}
+}
+
+func (fr *fakePostgresRows) Close() error {
+	return nil
+}
+
+func (fr *fakePostgresRows) Next(dest []driver.Value) error {
+	if len(fr.ids) == 0 {
+		return io.EOF
+	}
+
+	dest[0] = fr.ids[0]
+	fr.ids = fr.ids[1:]
+	return nil
+}
+
+func TestSyncToPostgres(t *testing.T) {
+	fp := &fakePostgres{rows: map[string][]driver.Value{}, state: map[string]int64{}}
+	db := sql.OpenDB(fp)
+	defer db.Close()
+	// Driven by one connection at a time.
+	db.SetMaxOpenConns(1)
+
+	_, err := newPostgresSyncDestination(db, "users", []string{"id", "name"}, []string{"nope"})
+	assert(errors.Is(err, errNoColumn), "expected unknown key column")
+	_, err = newPostgresSyncDestination(db, "users", []string{"id", "name"}, nil)
+	assert(errors.Is(err, errBadRequest), "expected no key columns")
+	dst, err := newPostgresSyncDestination(db, "users", []string{"id", "name"}, []string{"id"})
+	assertEq(err, nil, "could not make destination")
+
+	src := newClient(newMemoryObjectStorage())
+	err = src.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = src.createTable("x", []string{"id", "name"})
+	assertEq(err, nil, "could not create x")
+	err = src.commitTx()
+	assertEq(err, nil, "could not commit")
+	writeTx(&src, "x", []any{1, "Joey"}, []any{2, "Yue"})
+
+	last, err := dst.lastApplied("x")
+	assertEq(err, nil, "could not read last applied")
+	assertEq(last, -1, "applied before syncing")
+	applied, err := src.syncTable("x", dst)
+	assertEq(err, nil, "could not sync")
+	assertEq(applied, 1, "applied transactions")
+	assertEq(len(fp.rows), 2, "rows in users")
+	last, err = dst.lastApplied("x")
+	assertEq(err, nil, "could not read last applied")
+	assertEq(last, 1, "applied after syncing")
+
+	// Updating deletes the old row and upserts the new one.
+	err = src.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = src.updateRows("x", where("id", OP_EQ, 1), map[string]*expr{"name": litExpr("Ada")})
+	assertEq(err, nil, "could not update")
+	_, err = src.deleteRows("x", where("id", OP_EQ, 2))
+	assertEq(err, nil, "could not delete")
+	err = src.commitTx()
+	assertEq(err, nil, "could not commit")
+	applied, err = src.syncTable("x", dst)
+	assertEq(err, nil, "could not sync")
+	assertEq(applied, 1, "applied transactions")
+	assertEq(len(fp.rows), 1, "rows in users")
+	assertEq(fp.rows["1"][1], any("Ada"), "updated row")
+
+	// Changes and the transaction id applied together or not at
+	// all.
+	writeTx(&src, "x", []any{3, "Sam"})
+	fp.fail = "INSERT INTO otf_sync_state"
+	_, err = src.syncTable("x", dst)
+	assert(err != nil, "expected sync to fail")
+	assertEq(len(fp.rows), 1, "rows in users after failing")
+	fp.fail = ""
+	applied, err = src.syncTable("x", dst)
+	assertEq(err, nil, "could not sync")
+	assertEq(applied, 1, "applied transactions")
+	assertEq(len(fp.rows), 2, "rows in users")
+
+	// Rows of another shape are refused, not indexed into.
+	narrow, err := newPostgresSyncDestination(db, "users", []string{"id", "name", "email"}, []string{"email"})
+	assertEq(err, nil, "could not make destination")
+	err = narrow.apply("x", 5, []tableChange{{5, CHANGE_DELETE, []any{1, "Ada"}}})
+	assert(errors.Is(err, errBadRequest), "expected too few values")
+}
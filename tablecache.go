@@ -0,0 +1,116 @@
+package otf
+
+import (
+	"sync"
+)
+
+// Small tables, dimension tables mostly, get read over and over by
+// lookups and joins. With a table cache the client keeps every
+// committed row of tables at or below maxRows in memory across
+// transactions so repeated scans don't go back to storage.
+//
+// Whether a table is small enough comes from the row counts already
+// recorded in its AddDataobject actions, so nothing is read to find
+// out. Entries are tagged with the id of the last transaction that
+// changed the table and are replaced once a newer transaction sees a
+// different one. They're kept per branch (see branch.go), whose
+// entries continue main's numbering, so a client checking out
+// another branch doesn't see the last one's rows.
+type tableCache struct {
+	maxRows int
+
+	mu      sync.Mutex
+	entries map[tableCacheKey]tableCacheEntry
+
+	hits   int
+	misses int
+}
+
+type tableCacheKey struct {
+	branch string
+	table  string
+}
+
+type tableCacheEntry struct {
+	version int
+	rows    *batch
+}
+
+func withTableCache(maxRows int) clientOption {
+	return func(c *client) {
+		c.cache = &tableCache{maxRows: maxRows, entries: map[tableCacheKey]tableCacheEntry{}}
+	}
+}
+
+// Whether table's committed rows as of d's transaction are small
+// enough to cache, and how many there are.
+func (tc *tableCache) cacheable(d *client, table string) (int, bool) {
+	// Nothing committed yet.
+	if _, ok := d.tx.tableVersions[table]; !ok {
+		return 0, false
+	}
+
+	rows := 0
+	for _, action := range d.tx.previousActions[table] {
+		if action.AddDataobject != nil {
+			rows += action.AddDataobject.Rows
+		}
+	}
+
+	return rows, rows <= tc.maxRows
+}
+
+// Returns the committed rows of table as of d's transaction, or nil
+// if the table is too big to cache. Rows must not be modified.
+func (tc *tableCache) get(d *client, table string) (*batch, error) {
+	if _, ok := tc.cacheable(d, table); !ok {
+		return nil, nil
+	}
+
+	version := d.tx.tableVersions[table]
+	key := tableCacheKey{d.branch, table}
+
+	tc.mu.Lock()
+	entry, ok := tc.entries[key]
+	if ok && entry.version == version {
+		tc.hits++
+		tc.mu.Unlock()
+		return entry.rows, nil
+	}
+	tc.misses++
+	tc.mu.Unlock()
+
+	all := newBatch(len(d.tx.tables[table]))
+	deleted := deletedRows(d.tx.previousActions[table])
+	for _, action := range d.tx.previousActions[table] {
+		if action.AddDataobject == nil {
+			continue
+		}
+
+		o, err := d.readDataobject(action.AddDataobject)
+		if err != nil {
+			return nil, err
+		}
+
+		rows := &o.batch
+		if deleted, ok := deleted[action.AddDataobject]; ok {
+			rows = rows.without(deleted)
+		}
+
+		for i := range all.Columns {
+			all.Columns[i] = append(all.Columns[i], rows.Columns[i]...)
+		}
+		all.Len += rows.Len
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	// A concurrent transaction may have cached a newer version
+	// in the meantime.
+	if current, ok := tc.entries[key]; !ok || current.version < version {
+		tc.entries[key] = tableCacheEntry{version, all}
+		d.debug("tablecache", "cached rows", "branch", d.branch, "table", table, "rows", all.Len, "version", version)
+	}
+
+	return all, nil
+}
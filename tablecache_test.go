@@ -0,0 +1,109 @@
+package otf
+
+import (
+	"testing"
+)
+
+func TestTableCacheInvalidatesOnNewVersion(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	writer := newClient(mos)
+	reader := newClient(mos, withTableCache(10))
+
+	err := writer.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = writer.createTable("dim", []string{"id", "name"})
+	assertEq(err, nil, "could not create dim")
+	err = writer.createTable("fact", []string{"id"})
+	assertEq(err, nil, "could not create fact")
+	err = writer.writeRow("dim", []any{1, "Joey"})
+	assertEq(err, nil, "could not write row")
+	for i := 0; i < 11; i++ {
+		err = writer.writeRow("fact", []any{i})
+		assertEq(err, nil, "could not write row")
+	}
+	err = writer.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	assertEq(countRows(&reader, "dim"), 1, "rows in dim")
+	assertEq(countRows(&reader, "dim"), 1, "rows in dim")
+	assertEq(reader.cache.misses, 1, "misses")
+	assertEq(reader.cache.hits, 1, "hits")
+
+	// Too big to cache.
+	assertEq(countRows(&reader, "fact"), 11, "rows in fact")
+	assertEq(reader.cache.misses, 1, "misses")
+
+	// Writes to other tables don't invalidate dim.
+	err = writer.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = writer.writeRow("fact", []any{11})
+	assertEq(err, nil, "could not write row")
+	err = writer.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	assertEq(countRows(&reader, "dim"), 1, "rows in dim")
+	assertEq(reader.cache.hits, 2, "hits")
+
+	err = writer.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = writer.writeRow("dim", []any{2, "Yue"})
+	assertEq(err, nil, "could not write row")
+	err = writer.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	assertEq(countRows(&reader, "dim"), 2, "rows in dim")
+	assertEq(reader.cache.misses, 2, "misses")
+
+	// Uncommitted rows are seen on top of cached ones.
+	err = reader.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = reader.writeRow("dim", []any{3, "Ada"})
+	assertEq(err, nil, "could not write row")
+	it, err := reader.scan("dim", withColumns("name"))
+	assertEq(err, nil, "could not scan")
+	var names []any
+	for {
+		row, err := it.next()
+		assertEq(err, nil, "could not iterate scan")
+		if row == nil {
+			break
+		}
+		names = append(names, row[0])
+	}
+	assertEq(len(names), 3, "rows in dim")
+	assertEq(names[0], "Ada", "unflushed row first")
+	assertEq(reader.cache.hits, 3, "hits")
+}
+
+func TestTableCacheBranches(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos, withTableCache(10))
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	_, err = c.createBranch("dev", -1)
+	assertEq(err, nil, "could not branch")
+
+	// Both branches change x in the transaction after the fork.
+	writeTx(&c, "x", []any{"main"})
+	err = c.checkout("dev")
+	assertEq(err, nil, "could not checkout dev")
+	writeTx(&c, "x", []any{"dev"})
+
+	for _, branch := range []string{"dev", MAIN_BRANCH, "dev"} {
+		err = c.checkout(branch)
+		assertEq(err, nil, "could not checkout")
+		err = c.newTx()
+		assertEq(err, nil, "could not start tx")
+		rows := scanAll(&c, "x")
+		assertEq(len(rows), 1, "rows on "+branch)
+		assertEq(rows[0][0], any(branch), "row on "+branch)
+		err = c.abortTx()
+		assertEq(err, nil, "could not abort")
+	}
+	assertEq(c.cache.misses, 2, "misses")
+	assertEq(c.cache.hits, 1, "hits")
+}
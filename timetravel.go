@@ -0,0 +1,97 @@
+package otf
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Transactions can be opened at a past version of the store, seeing
+// exactly the data as of a given committed transaction or as of a
+// point in time. They can be scanned like any other transaction but
+// committing writes from one fails.
+
+type CommitInfo struct {
+	Timestamp time.Time
+	// See hlc.go.
+	HLC *HLCTimestamp `json:",omitempty"`
+}
+
+var (
+	errNoVersion    = fmt.Errorf("No Such Version")
+	errHistoricalTx = fmt.Errorf("Historical Transaction")
+)
+
+// Starts a transaction seeing the store as of the committed
+// transaction txId.
+func (d *client) newTxAt(txId int) error {
+	if d.tx != nil {
+		return errExistingTx
+	}
+
+	names, err := d.listLog()
+	if err != nil {
+		return err
+	}
+
+	i := logEntryIndex(names, txId)
+	if i == -1 {
+		return fmt.Errorf("%w: %d", errNoVersion, txId)
+	}
+
+	return d.replayLogAt(names[:i+1])
+}
+
+// Starts a transaction seeing the store as of the latest
+// transaction committed at or before t. Commit times are assumed to
+// increase with transaction ids, which a hybrid logical clock (see
+// hlc.go) ensures, so the log is binary searched rather than read
+// in full.
+func (d *client) newTxAsOf(t time.Time) error {
+	if d.tx != nil {
+		return errExistingTx
+	}
+
+	names, err := d.listLog()
+	if err != nil {
+		return err
+	}
+
+	// The first entry committed after t.
+	var searchErr error
+	after := sort.Search(len(names), func(i int) bool {
+		if searchErr != nil {
+			return true
+		}
+
+		tx, err := d.readLogEntry(names[i])
+		if err != nil {
+			searchErr = err
+			return true
+		}
+
+		// Entries from before commit times were recorded have
+		// none.
+		return tx.CommitInfo != nil && tx.CommitInfo.time().After(t)
+	})
+	if searchErr != nil {
+		return searchErr
+	}
+
+	if after == 0 {
+		return fmt.Errorf("%w: nothing committed by %s", errNoVersion, t)
+	}
+
+	return d.replayLogAt(names[:after])
+}
+
+func (d *client) replayLogAt(names []string) error {
+	err := d.replayLog(names)
+	if err != nil {
+		return err
+	}
+
+	d.tx.historical = true
+	d.tx.allocateWrites()
+	return nil
+}
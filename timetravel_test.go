@@ -0,0 +1,105 @@
+package otf
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTimeTravel(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	var times []time.Time
+	for i := 0; i < 3; i++ {
+		err := c.newTx()
+		assertEq(err, nil, "could not start tx")
+		if i == 0 {
+			err = c.createTable("x", []string{"a"})
+			assertEq(err, nil, "could not create x")
+		} else {
+			_, err = c.deleteRows("x", where("a", OP_EQ, i-1))
+			assertEq(err, nil, "could not delete")
+		}
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+
+		times = append(times, time.Now().UTC())
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	for i := 0; i < 3; i++ {
+		err := c.newTxAt(i)
+		assertEq(err, nil, "could not start tx")
+		rows := scanAll(&c, "x")
+		assertEq(len(rows), 1, "rows")
+		assertEq[any](rows[0][0], float64(i), "row as of version")
+
+		// Reading is fine, writing isn't.
+		err = c.writeRow("x", []any{10})
+		assertEq(err, nil, "could not write row")
+		err = c.commitTx()
+		assert(errors.Is(err, errHistoricalTx), "expected historical tx error")
+
+		err = c.newTxAsOf(times[i])
+		assertEq(err, nil, "could not start tx")
+		rows = scanAll(&c, "x")
+		assertEq[any](rows[0][0], float64(i), "row as of time")
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+
+	err := c.newTxAt(3)
+	assert(errors.Is(err, errNoVersion), "expected no such version")
+	err = c.newTxAsOf(times[0].Add(-time.Hour))
+	assert(errors.Is(err, errNoVersion), "expected no such version")
+}
+
+// Counts log entries read.
+type countingReads struct {
+	objectStorage
+	reads int
+}
+
+func (cr *countingReads) read(ctx context.Context, name string) ([]byte, error) {
+	cr.reads++
+	return cr.objectStorage.read(ctx, name)
+}
+
+func TestNewTxAsOfSearches(t *testing.T) {
+	cr := &countingReads{objectStorage: newMemoryObjectStorage()}
+	c := newClient(cr)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Pretend the rest were committed a minute apart.
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= 64; i++ {
+		err = c.newTx()
+		assertEq(err, nil, "could not start tx")
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+		err = c.flushRows("x")
+		assertEq(err, nil, "could not flush")
+
+		c.tx.CommitInfo = &CommitInfo{Timestamp: start.Add(time.Duration(i) * time.Minute)}
+		bytes, err := encodeLogEntry(c.tx.logEntry())
+		assertEq(err, nil, "could not marshal")
+		err = cr.putIfAbsent(context.Background(), logEntryName(c.tx.Id), bytes)
+		assertEq(err, nil, "could not commit")
+		c.tx = nil
+	}
+
+	cr.reads = 0
+	err = c.newTxAsOf(start.Add(30*time.Minute + time.Second))
+	assertEq(err, nil, "could not start tx")
+	// Searching plus replaying the 31 entries.
+	assert(cr.reads <= 7+31, "read too much")
+	rows := scanAll(&c, "x")
+	assertEq(len(rows), 30, "rows as of time")
+}
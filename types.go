@@ -0,0 +1,201 @@
+package otf
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// Columns may be given a type when a table is created. writeRow
+// checks values against their column's type and coerces them to one
+// representation, and reading a dataobject coerces them back, so a
+// value comes out of a scan the same type it went in rather than
+// whatever JSON made of it.
+//
+//   - int: int64, from any integer or whole float64 up to 2^53 in
+//     magnitude, past which JSON numbers as decoded skip integers.
+//   - float: float64, from any number.
+//   - string: string.
+//   - bool: bool.
+//   - timestamp: time.Time in UTC, from a time.Time or an RFC 3339
+//     string. Stored as an RFC 3339 string.
+//
+// Null is allowed in a column of any type. Untyped columns take any
+// value as is.
+
+const (
+	COLUMN_ANY       = ""
+	COLUMN_INT       = "int"
+	COLUMN_FLOAT     = "float"
+	COLUMN_STRING    = "string"
+	COLUMN_BOOL      = "bool"
+	COLUMN_TIMESTAMP = "timestamp"
+)
+
+var columnTypes = []string{COLUMN_ANY, COLUMN_INT, COLUMN_FLOAT, COLUMN_STRING, COLUMN_BOOL, COLUMN_TIMESTAMP}
+
+var errTypeMismatch = fmt.Errorf("Type Mismatch")
+
+// Returned by writeRow for a value that isn't of its column's type.
+type columnTypeError struct {
+	Table  string
+	Column string
+	Type   string
+	Value  any
+}
+
+func (e *columnTypeError) Error() string {
+	return fmt.Sprintf("%s: %s.%s is %s, got %T", errTypeMismatch, e.Table, e.Column, e.Type, e.Value)
+}
+
+func (e *columnTypeError) Unwrap() error {
+	return errTypeMismatch
+}
+
+// Mapping column name to type, columns not given are untyped.
+func withColumnTypes(types map[string]string) tableOption {
+	return func(o *tableOptions) {
+		o.columnTypes = types
+	}
+}
+
+// Types in the order of columns, or nil if none are typed.
+func orderedColumnTypes(table string, columns []string, types map[string]string) ([]string, error) {
+	if len(types) == 0 {
+		return nil, nil
+	}
+
+	var ordered []string
+	for column, typ := range types {
+		if !slices.Contains(columns, column) {
+			return nil, fmt.Errorf("%w: %s.%s", errNoColumn, table, column)
+		}
+		if !slices.Contains(columnTypes, typ) {
+			return nil, fmt.Errorf("unknown type %s for %s.%s", typ, table, column)
+		}
+	}
+
+	for _, column := range columns {
+		ordered = append(ordered, types[column])
+	}
+
+	return ordered, nil
+}
+
+func toTime(v any) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t.UTC(), true
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, t)
+		return parsed.UTC(), err == nil
+	}
+
+	return time.Time{}, false
+}
+
+// v as typ, or false if it isn't one.
+func coerceValue(typ string, v any) (any, bool) {
+	if v == nil || typ == COLUMN_ANY {
+		return v, true
+	}
+
+	switch typ {
+	case COLUMN_INT:
+		var i int64
+		switch n := v.(type) {
+		case int:
+			i = int64(n)
+		case int8:
+			i = int64(n)
+		case int16:
+			i = int64(n)
+		case int32:
+			i = int64(n)
+		case int64:
+			i = n
+		case uint8:
+			i = int64(n)
+		case uint16:
+			i = int64(n)
+		case uint32:
+			i = int64(n)
+		case float64:
+			if n == math.Trunc(n) && math.Abs(n) <= 1<<53 {
+				return int64(n), true
+			}
+			return nil, false
+		default:
+			return nil, false
+		}
+
+		// Larger ones wouldn't survive being stored as a JSON
+		// number.
+		if i < -1<<53 || i > 1<<53 {
+			return nil, false
+		}
+		return i, true
+	case COLUMN_FLOAT:
+		switch n := v.(type) {
+		case float32:
+			return float64(n), true
+		default:
+			if f, ok := toFloat64(v); ok {
+				return f, true
+			}
+		}
+	case COLUMN_STRING:
+		if _, ok := v.(string); ok {
+			return v, true
+		}
+	case COLUMN_BOOL:
+		if _, ok := v.(bool); ok {
+			return v, true
+		}
+	case COLUMN_TIMESTAMP:
+		return toTime(v)
+	}
+
+	return nil, false
+}
+
+// Coerces row in place to types, see coerceValue.
+func coerceRow(table string, columns, types []string, row []any) error {
+	for i, typ := range types {
+		v, ok := coerceValue(typ, row[i])
+		if !ok {
+			return &columnTypeError{table, columns[i], typ, row[i]}
+		}
+		row[i] = v
+	}
+
+	return nil
+}
+
+// Coerces values read back from a dataobject to types. Values that
+// don't coerce, e.g. written before a column was typed, are left as
+// they are.
+func (b *batch) coerce(types []string) *batch {
+	if types == nil {
+		return b
+	}
+
+	coerced := &batch{Columns: make([][]any, len(b.Columns)), Len: b.Len}
+	for i, values := range b.Columns {
+		if types[i] == COLUMN_ANY || types[i] == COLUMN_STRING || types[i] == COLUMN_BOOL {
+			coerced.Columns[i] = values
+			continue
+		}
+
+		coerced.Columns[i] = make([]any, len(values))
+		for j, v := range values {
+			if c, ok := coerceValue(types[i], v); ok {
+				v = c
+			}
+			coerced.Columns[i][j] = v
+		}
+	}
+
+	return coerced
+}
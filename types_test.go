@@ -0,0 +1,97 @@
+package otf
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestColumnTypes(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"id", "price", "name", "ok", "at", "other"}, withColumnTypes(map[string]string{
+		"id":    COLUMN_INT,
+		"price": COLUMN_FLOAT,
+		"name":  COLUMN_STRING,
+		"ok":    COLUMN_BOOL,
+		"at":    COLUMN_TIMESTAMP,
+	}))
+	assertEq(err, nil, "could not create x")
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 6, time.FixedZone("", 3600))
+	err = c.writeRow("x", []any{1, 2, "a", true, at, 1})
+	assertEq(err, nil, "could not write row")
+	err = c.writeRow("x", []any{2.0, 2.5, "b", false, "2024-01-02T03:04:05Z", nil})
+	assertEq(err, nil, "could not write row")
+	err = c.writeRow("x", []any{nil, nil, nil, nil, nil, nil})
+	assertEq(err, nil, "could not write row")
+
+	for _, row := range [][]any{
+		{2.5, 1.0, "a", true, at, nil},
+		// Would read back as a float64 losing precision.
+		{int64(1<<60 + 1), 1.0, "a", true, at, nil},
+		{1, "1", "a", true, at, nil},
+		{1, 1.0, 1, true, at, nil},
+		{1, 1.0, "a", "true", at, nil},
+		{1, 1.0, "a", true, "yesterday", nil},
+	} {
+		err = c.writeRow("x", row)
+		assert(errors.Is(err, errTypeMismatch), "expected type mismatch")
+		var typeErr *columnTypeError
+		assert(errors.As(err, &typeErr), "expected column type error")
+	}
+
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// The same types come back out after the JSON round-trip.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	rows := scanAll(&c, "x")
+	assertEq(len(rows), 3, "rows")
+	assertEq[any](rows[0][0], int64(1), "int")
+	assertEq[any](rows[0][1], 2.0, "float")
+	assertEq[any](rows[0][2], "a", "string")
+	assertEq[any](rows[0][3], true, "bool")
+	assertEq[any](rows[0][4], at.UTC(), "timestamp")
+	// Untyped columns are as JSON left them.
+	assertEq[any](rows[0][5], 1.0, "untyped")
+	assertEq[any](rows[1][0], int64(2), "int")
+	assertEq[any](rows[2][0], nil, "null")
+
+	rows = scanAll(&c, "x", withFilter(where("at", OP_GT, time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC))))
+	assertEq(len(rows), 1, "rows after")
+
+	err = c.createTable("y", []string{"a"}, withColumnTypes(map[string]string{"b": COLUMN_INT}))
+	assert(errors.Is(err, errNoColumn), "unknown column")
+	err = c.createTable("y", []string{"a"}, withColumnTypes(map[string]string{"a": "decimal"}))
+	assert(err != nil, "unknown type")
+}
+
+func TestColumnTypesOnRewrite(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"id", "n"}, withColumnTypes(map[string]string{"id": COLUMN_INT, "n": COLUMN_INT}))
+	assertEq(err, nil, "could not create x")
+	err = c.writeRow("x", []any{1, 1})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.updateRows("x", where("id", OP_EQ, 1), map[string]*expr{"n": litExpr("one")})
+	assert(errors.Is(err, errTypeMismatch), "expected update type mismatch")
+	_, _, err = c.merge("x", []string{"id"}, [][]any{{1, 2.5}})
+	assert(errors.Is(err, errTypeMismatch), "expected merge type mismatch")
+
+	// And coerced.
+	_, err = c.updateRows("x", where("id", OP_EQ, 1), map[string]*expr{"n": litExpr(2.0)})
+	assertEq(err, nil, "could not update")
+	rows := scanAll(&c, "x")
+	assertEq[any](rows[0][1], int64(2), "updated")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+}
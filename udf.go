@@ -0,0 +1,138 @@
+package otf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// User-defined scalar functions. Once registered on a client they
+// can be called in filters, either compared against a literal or on
+// their own if they return a boolean, and computed as extra columns
+// in scans. So domain logic runs inside the scan rather than on
+// rows after the fact.
+//
+// Arguments and return values use the same types as placeholders
+// (see prepare.go). Like SQL, if an argument is null or not of its
+// declared type the result is null and the function isn't called.
+// A result not of the declared type is null too, or an error when
+// the call is constant and folded.
+// Calls are compiled as expressions, see expr.go.
+
+type udf struct {
+	Name       string
+	ArgTypes   []string
+	ReturnType string
+	Fn         func(args []any) any
+}
+
+var errFunctionExists = fmt.Errorf("Function Exists")
+
+func (d *client) registerFunction(f udf) error {
+	if _, ok := d.functions[f.Name]; ok {
+		return fmt.Errorf("%w: %s", errFunctionExists, f.Name)
+	}
+
+	if d.functions == nil {
+		d.functions = map[string]*udf{}
+	}
+	d.functions[f.Name] = &f
+	return nil
+}
+
+// Refers to a column in a call's arguments. Anything else is a
+// literal.
+type columnRef struct {
+	Column string
+}
+
+func col(name string) columnRef {
+	return columnRef{name}
+}
+
+type call struct {
+	Func string
+	Args []any
+}
+
+func fn(name string, args ...any) *call {
+	return &call{name, args}
+}
+
+func (c *call) String() string {
+	var args []string
+	for _, arg := range c.Args {
+		if ref, ok := arg.(columnRef); ok {
+			args = append(args, ref.Column)
+		} else {
+			args = append(args, formatLiteral(arg))
+		}
+	}
+
+	return fmt.Sprintf("%s(%s)", c.Func, strings.Join(args, ", "))
+}
+
+// Compares the result of c against value.
+func whereCall(c *call, op string, value any) *predicate {
+	return &predicate{Call: c, Op: op, Value: value}
+}
+
+// Matches rows c returns true for.
+func whereTrue(c *call) *predicate {
+	return &predicate{Call: c}
+}
+
+func (c *call) expr() *expr {
+	var args []*expr
+	for _, arg := range c.Args {
+		if ref, ok := arg.(columnRef); ok {
+			args = append(args, colExpr(ref.Column))
+		} else {
+			args = append(args, litExpr(arg))
+		}
+	}
+
+	return callExpr(c.Func, args...)
+}
+
+// Resolves c against table as of d's transaction, returning a
+// function that evaluates it for row i of a batch of that table.
+func (d *client) compileCall(table string, c *call) (func(b *batch, i int) any, error) {
+	folded, err := c.expr().fold(d.functions)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := folded.compile(d, table)
+	if err != nil {
+		return nil, err
+	}
+
+	return compiled(nil), nil
+}
+
+type computedColumn struct {
+	name string
+	call *call
+}
+
+// Adds a column computed by c to each row, after any projected
+// columns.
+func withComputed(name string, c *call) scanOption {
+	return func(o *scanOptions) {
+		o.computed = append(o.computed, computedColumn{name, c})
+	}
+}
+
+// b with the results of computed appended as columns, evaluated
+// against full, the unprojected rows b came from.
+func (b *batch) withComputed(full *batch, computed []func(*batch, int) any) *batch {
+	c := &batch{Columns: append([][]any{}, b.Columns...), Len: b.Len}
+	for _, f := range computed {
+		values := make([]any, b.Len)
+		for i := range values {
+			values[i] = f(full, i)
+		}
+		c.Columns = append(c.Columns, values)
+	}
+
+	return c
+}
@@ -0,0 +1,116 @@
+package otf
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUserDefinedFunctions(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.registerFunction(udf{
+		Name:       "lower",
+		ArgTypes:   []string{PARAM_STRING},
+		ReturnType: PARAM_STRING,
+		Fn: func(args []any) any {
+			return strings.ToLower(args[0].(string))
+		},
+	})
+	assertEq(err, nil, "could not register lower")
+	err = c.registerFunction(udf{
+		Name:       "divisible",
+		ArgTypes:   []string{PARAM_INT64, PARAM_INT64},
+		ReturnType: PARAM_BOOLEAN,
+		Fn: func(args []any) any {
+			a, _ := toInt64(args[0])
+			b, _ := toInt64(args[1])
+			return a%b == 0
+		},
+	})
+	assertEq(err, nil, "could not register divisible")
+	err = c.registerFunction(udf{Name: "lower"})
+	assert(errors.Is(err, errFunctionExists), "duplicate function")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"name", "age"})
+	assertEq(err, nil, "could not create x")
+	for _, row := range [][]any{{"JOEY", 10}, {"Yue", 15}, {"joey", 20}, {nil, 30}} {
+		err = c.writeRow("x", row)
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	filter := and(whereCall(fn("lower", col("name")), OP_EQ, "joey"), whereTrue(fn("divisible", col("age"), 10)))
+	assertEq(filter.String(), "(lower(name) = 'joey' AND divisible(age, 10))", "filter text")
+	it, err := c.scan("x", withFilter(filter), withColumns("age"), withComputed("lower_name", fn("lower", col("name"))))
+	assertEq(err, nil, "could not scan")
+
+	var rows [][]any
+	for {
+		row, err := it.next()
+		assertEq(err, nil, "could not iterate scan")
+		if row == nil {
+			break
+		}
+		rows = append(rows, row)
+	}
+	assertEq(len(rows), 2, "matching rows")
+	assertEq(rows[0][0], 10.0, "age")
+	assertEq(rows[0][1], "joey", "computed column")
+
+	_, err = c.scan("x", withFilter(whereTrue(fn("lower", col("name")))))
+	assert(err != nil, "non-boolean function as filter")
+	_, err = c.scan("x", withFilter(whereTrue(fn("divisible", col("age"), "2"))))
+	assert(err != nil, "wrong literal type")
+	// Constant calls too, which are folded before the scan.
+	_, err = c.scan("x", withFilter(whereTrue(fn("divisible", 10, 2, 3))))
+	assert(err != nil, "too many arguments")
+	_, err = c.scan("x", withFilter(whereTrue(fn("divisible", 10, "2"))))
+	assert(err != nil, "wrong constant literal type")
+	_, err = c.scan("x", withComputed("y", fn("upper", col("name"))))
+	assert(err != nil, "unknown function")
+}
+
+func TestUserDefinedFunctionWrongResult(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.registerFunction(udf{
+		Name:       "half",
+		ArgTypes:   []string{PARAM_INT64},
+		ReturnType: PARAM_INT64,
+		Fn: func(args []any) any {
+			n, _ := toInt64(args[0])
+			return float64(n) / 2
+		},
+	})
+	assertEq(err, nil, "could not register half")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	for _, a := range []int{3, 4} {
+		err = c.writeRow("x", []any{a})
+		assertEq(err, nil, "could not write row")
+	}
+
+	it, err := c.scan("x", withComputed("half", fn("half", col("a"))))
+	assertEq(err, nil, "could not scan")
+	var halves []any
+	for {
+		row, err := it.next()
+		assertEq(err, nil, "could not iterate scan")
+		if row == nil {
+			break
+		}
+		halves = append(halves, row[1])
+	}
+	assertEq(fmt.Sprint(halves), "[<nil> 2]", "halves")
+
+	_, err = c.scan("x", withComputed("half", fn("half", 3)))
+	assert(err != nil, "constant call returned a float")
+}
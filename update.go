@@ -0,0 +1,333 @@
+package otf
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Updating rows rewrites every dataobject holding a matching row
+// (copy-on-write): the rows left after any deletions are written to
+// a new dataobject with assignments applied and a RemoveAction drops
+// the old one. Both land in the same transaction so they commit
+// together or, on conflict, not at all.
+type RemoveAction struct {
+	Table string
+	Name  string
+}
+
+// actions without dataobjects that were removed later on, or the
+// rows deleted from them, or the removals themselves.
+func liveActions(actions []Action) []Action {
+	removedAt := map[string]int{}
+	for i, action := range actions {
+		if action.RemoveDataobject != nil {
+			removedAt[action.RemoveDataobject.Name] = i
+		}
+	}
+
+	if len(removedAt) == 0 {
+		return actions
+	}
+
+	var live []Action
+	for i, action := range actions {
+		name := ""
+		switch {
+		case action.RemoveDataobject != nil:
+			continue
+		case action.AddDataobject != nil:
+			name = action.AddDataobject.Name
+		case action.DeleteRows != nil:
+			name = action.DeleteRows.Name
+		}
+
+		// A content-addressed dataobject may be added again after
+		// being removed.
+		if at, ok := removedAt[name]; ok && i < at {
+			continue
+		}
+
+		live = append(live, action)
+	}
+
+	return live
+}
+
+// The dataobjects added by actions, grouped by name in the order
+// they were first added. Deterministic names (see naming.go) mean a
+// dataobject may be added more than once, and a RemoveAction drops
+// every add of it, so copying one on write rewrites all of them.
+func addsByName(actions []Action) [][]*DataobjectAction {
+	var adds [][]*DataobjectAction
+	at := map[string]int{}
+	for _, action := range actions {
+		do := action.AddDataobject
+		if do == nil {
+			continue
+		}
+
+		i, ok := at[do.Name]
+		if !ok {
+			i = len(adds)
+			at[do.Name] = i
+			adds = append(adds, nil)
+		}
+		adds[i] = append(adds[i], do)
+	}
+
+	return adds
+}
+
+// Moves the removals of actions before the rest, so that none drops a
+// dataobject added alongside it that happens to share the name of one
+// it replaces.
+func removalsFirst(actions []Action) {
+	slices.SortStableFunc(actions, func(a, b Action) int {
+		switch {
+		case a.RemoveDataobject != nil && b.RemoveDataobject == nil:
+			return -1
+		case a.RemoveDataobject == nil && b.RemoveDataobject != nil:
+			return 1
+		}
+		return 0
+	})
+}
+
+// Sets the columns in assignments on the rows of table matching p,
+// both ones committed before and ones written in this transaction.
+// Assignments are evaluated against the row before it is updated.
+// Returns how many rows were updated.
+func (d *client) updateRows(table string, p *predicate, assignments map[string]*expr) (int, error) {
+	if err := d.checkWritable(); err != nil {
+		return 0, err
+	}
+
+	columns, ok := d.tx.tables[table]
+	if !ok {
+		return 0, errNoTable
+	}
+
+	p, err := d.restrictRows(table, p)
+	if err != nil {
+		return 0, err
+	}
+
+	matches, err := p.bind(d, table)
+	if err != nil {
+		return 0, err
+	}
+
+	assign := map[int]func(*batch, int) any{}
+	for column, e := range assignments {
+		i := slices.Index(columns, column)
+		if i == -1 {
+			return 0, fmt.Errorf("%w: %s.%s", errNoColumn, table, column)
+		}
+		if d.tx.columnDefaults[table][column].Generated {
+			return 0, fmt.Errorf("%w: %s.%s is generated", errColumnDefault, table, column)
+		}
+
+		folded, err := e.fold(d.functions)
+		if err != nil {
+			return 0, err
+		}
+		compiled, err := folded.compile(d, table)
+		if err != nil {
+			return 0, err
+		}
+		assign[i] = compiled(nil)
+	}
+
+	// See defaults.go.
+	generated := d.tx.generatedExprColumns(table)
+	n, err := d.rewriteRows(table, p, func(b *batch, i int) ([]any, error) {
+		if !matches(b, i) {
+			return nil, nil
+		}
+
+		row := b.row(i)
+		for column, f := range assign {
+			row[column] = f(b, i)
+		}
+		return row, d.computeDefaults(table, row, generated)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	d.debug("update", "updated rows", "table", table, "rows", n)
+	return n, nil
+}
+
+// Replaces each row of table that rewrite returns a new row for,
+// copying on write any dataobject that holds one. New rows are
+// checked like written ones. Only dataobjects that may match p are
+// looked at, all of them if p is nil. Returns how many rows were
+// replaced.
+func (d *client) rewriteRows(table string, p *predicate, rewrite func(b *batch, i int) ([]any, error)) (int, error) {
+	result, err := d.copyOnWrite(table, p, func(b *batch, i int, deleted bool) ([]any, bool, error) {
+		if deleted {
+			return nil, false, nil
+		}
+
+		row, err := rewrite(b, i)
+		if err != nil {
+			return nil, false, err
+		}
+		if row == nil {
+			return b.row(i), false, nil
+		}
+		return row, true, nil
+	}, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.Rows, nil
+}
+
+// What copyOnWrite changed.
+type rewriteResult struct {
+	// Rows changed, including deleted ones dropped.
+	Rows int
+	// Dataobjects removed, and those added in their place.
+	Removed []string
+	Added   []string
+}
+
+// Copies on write every dataobject of table, and the rows written in
+// this transaction, holding a row that rewrite changes. rewrite is
+// called on each row, deleted ones too, and returns the row to keep
+// in its place or nil to drop it, and whether that changes it. Kept
+// rows that changed are checked like written ones. Only dataobjects
+// that may match p are looked at, all of them if p is nil.
+//
+// then, if not nil, is called with the result before the rows
+// written in this transaction are replaced, and failing it fails
+// the rewrite.
+func (d *client) copyOnWrite(table string, p *predicate, rewrite func(b *batch, i int, deleted bool) ([]any, bool, error), then func(*rewriteResult) error) (*rewriteResult, error) {
+	// The rows of b, those in deleted marked so, rewritten, and how
+	// many changed.
+	apply := func(b *batch, deleted map[int]bool) (*batch, int, error) {
+		rewritten := newBatch(len(b.Columns))
+		n := 0
+		for i := 0; i < b.Len; i++ {
+			row, changed, err := rewrite(b, i, deleted[i])
+			if err != nil {
+				return nil, 0, err
+			}
+
+			if changed {
+				n++
+			}
+			if row == nil {
+				continue
+			}
+			if changed {
+				row, err = d.checkRow(table, row)
+				if err != nil {
+					return nil, 0, err
+				}
+			}
+			rewritten.appendRow(row)
+		}
+
+		return rewritten, n, nil
+	}
+
+	result := &rewriteResult{}
+	var unflushed *batch
+	if rows, ok := d.tx.unflushedData[table]; ok && rows.Len > 0 {
+		var err error
+		unflushed, result.Rows, err = apply(rows, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	d.tx.markRead(table)
+
+	actions := liveActions(slices.Concat(d.tx.previousActions[table], d.tx.Actions[table]))
+	deleted := deletedRows(actions)
+	err := d.replaceDataobjects(table, func() error {
+		for _, adds := range addsByName(actions) {
+			if p != nil && !d.mayMatch(table, adds[0], p) {
+				continue
+			}
+
+			o, err := d.readDataobject(adds[0])
+			if err != nil {
+				return err
+			}
+
+			var rewrites []*batch
+			changed := 0
+			for _, do := range adds {
+				rewritten, n, err := apply(&o.batch, deleted[do])
+				if err != nil {
+					return err
+				}
+				rewrites = append(rewrites, rewritten)
+				changed += n
+			}
+			if changed == 0 {
+				continue
+			}
+
+			d.tx.Actions[table] = append(d.tx.Actions[table], Action{
+				RemoveDataobject: &RemoveAction{
+					Table: table,
+					Name:  adds[0].Name,
+				},
+			})
+			result.Removed = append(result.Removed, adds[0].Name)
+
+			for _, rewritten := range rewrites {
+				if rewritten.Len == 0 {
+					continue
+				}
+
+				added := len(d.tx.Actions[table])
+				err = d.writeBatch(table, rewritten)
+				if err != nil {
+					return err
+				}
+				for _, action := range d.tx.Actions[table][added:] {
+					result.Added = append(result.Added, action.AddDataobject.Name)
+				}
+			}
+			result.Rows += changed
+		}
+
+		if then != nil {
+			return then(result)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if unflushed != nil {
+		// Scans only ever hold slices of the old batch.
+		d.tx.unflushedData[table] = unflushed
+	}
+
+	return result, nil
+}
+
+// Runs rewrite, which adds actions to table replacing dataobjects,
+// then moves the removals first (see removalsFirst). On error the
+// transaction is left as it was, other than dataobjects written that
+// nothing refers to.
+func (d *client) replaceDataobjects(table string, rewrite func() error) error {
+	before := len(d.tx.Actions[table])
+	err := rewrite()
+	if err != nil {
+		d.tx.Actions[table] = d.tx.Actions[table][:before]
+		return err
+	}
+
+	removalsFirst(d.tx.Actions[table][before:])
+	return nil
+}
@@ -0,0 +1,171 @@
+package otf
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUpdateRows(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	cached := newClient(mos, withTableCache(100))
+
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"name", "age"})
+	assertEq(err, nil, "could not create x")
+	for i := 0; i < 10; i++ {
+		err = c.writeRow("x", []any{"Joey", i})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+	assertEq(countRows(&cached, "x"), 10, "rows in x")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.writeRow("x", []any{"Yue", 10})
+	assertEq(err, nil, "could not write row")
+	_, err = c.deleteRows("x", where("age", OP_EQ, 0))
+	assertEq(err, nil, "could not delete")
+	n, err := c.updateRows("x", where("age", OP_GTE, 8), map[string]*expr{
+		"name": litExpr("Old"),
+		"age":  opExpr(OP_ADD, colExpr("age"), litExpr(100)),
+	})
+	assertEq(err, nil, "could not update")
+	assertEq(n, 3, "updated rows")
+
+	// Updated rows are visible within the transaction.
+	it, err := c.scan("x", withFilter(where("name", OP_EQ, "Old")))
+	assertEq(err, nil, "could not scan")
+	ages := 0.0
+	for {
+		row, err := it.next()
+		assertEq(err, nil, "could not read row")
+		if row == nil {
+			break
+		}
+
+		age, _ := toFloat64(row[1])
+		ages += age
+	}
+	assertEq(ages, 327.0, "updated ages")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	assertEq(countRows(&c, "x"), 10, "rows in x")
+	assertEq(countRows(&cached, "x"), 10, "rows in cached x")
+
+	err = cached.newTx()
+	assertEq(err, nil, "could not start tx")
+	it, err = cached.scan("x", withFilter(where("age", OP_LT, 100)))
+	assertEq(err, nil, "could not scan")
+	n = 0
+	for {
+		row, err := it.next()
+		assertEq(err, nil, "could not read row")
+		if row == nil {
+			break
+		}
+		n++
+	}
+	assertEq(n, 7, "rows not updated")
+	err = cached.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// The old dataobject is gone and the remaining rows were
+	// rewritten, less the deleted one.
+	changes, err := c.tableChangesSince("x", 0)
+	assertEq(err, nil, "could not read changes")
+	assertEq(len(changes), 1, "transactions with changes")
+	deletes, inserts := 0, 0
+	for _, change := range changes[0] {
+		if change.Op == CHANGE_DELETE {
+			deletes++
+		} else {
+			inserts++
+		}
+	}
+	assertEq(deletes, 10, "deleted rows")
+	assertEq(inserts, 10, "inserted rows")
+
+	_, err = c.updateRows("x", where("age", OP_EQ, 1), map[string]*expr{"nope": litExpr(1)})
+	assert(err != nil, "update outside of tx")
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	_, err = c.updateRows("x", where("age", OP_EQ, 1), map[string]*expr{"nope": litExpr(1)})
+	assert(errors.Is(err, errNoColumn), "unknown column")
+}
+
+func TestUpdateRowsConflict(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c1 := newClient(mos)
+	c2 := newClient(mos)
+
+	err := c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c1.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c1.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c1.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	err = c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c2.newTx()
+	assertEq(err, nil, "could not start tx")
+
+	_, err = c1.updateRows("x", where("a", OP_EQ, 1), map[string]*expr{"a": litExpr(2)})
+	assertEq(err, nil, "could not update")
+	_, err = c2.updateRows("x", where("a", OP_EQ, 1), map[string]*expr{"a": litExpr(3)})
+	assertEq(err, nil, "could not update")
+
+	err = c1.commitTx()
+	assertEq(err, nil, "could not commit")
+	err = c2.commitTx()
+	assert(errors.Is(err, errConflict), "expected conflict")
+
+	err = c1.newTx()
+	assertEq(err, nil, "could not start tx")
+	it, err := c1.scan("x")
+	assertEq(err, nil, "could not scan")
+	row, err := it.next()
+	assertEq(err, nil, "could not read row")
+	assertEq(row[0], 2.0, "updated value")
+	row, err = it.next()
+	assertEq(err, nil, "could not read row")
+	assert(row == nil, "one row")
+}
+
+func TestUpdateRowsChecksRows(t *testing.T) {
+	c := newClient(newMemoryObjectStorage())
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"day", "a"}, withPartitionColumns("day"), withColumnTypes(map[string]string{"a": COLUMN_INT}))
+	assertEq(err, nil, "could not create x")
+	for i := 0; i < 4; i++ {
+		err = c.writeRow("x", []any{i % 2, i})
+		assertEq(err, nil, "could not write row")
+	}
+	err = c.flushRows("x")
+	assertEq(err, nil, "could not flush")
+	err = c.writeRow("x", []any{0, 4})
+	assertEq(err, nil, "could not write row")
+	actions := len(c.tx.Actions["x"])
+
+	_, err = c.updateRows("x", where("a", OP_GTE, 0), map[string]*expr{"day": litExpr(nil)})
+	assert(errors.Is(err, errInvalidPartitionValue), "null partition value")
+	_, err = c.updateRows("x", where("a", OP_GTE, 0), map[string]*expr{"a": litExpr("a")})
+	assert(errors.Is(err, errTypeMismatch), "type mismatch")
+	assertEq(len(c.tx.Actions["x"]), actions, "actions after failed updates")
+	assertEq(len(scanAll(&c, "x", withFilter(where("day", OP_EQ, 0)))), 3, "rows")
+
+	// Rows can move partitions.
+	n, err := c.updateRows("x", where("a", OP_EQ, 1), map[string]*expr{"day": litExpr(0), "a": litExpr(1.0)})
+	assertEq(err, nil, "could not update")
+	assertEq(n, 1, "updated rows")
+	rows := scanAll(&c, "x", withFilter(where("day", OP_EQ, 0)))
+	assertEq(len(rows), 4, "rows")
+	assertEq[any](rows[len(rows)-1][1], int64(1), "coerced value")
+}
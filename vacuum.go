@@ -0,0 +1,236 @@
+package otf
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Dataobjects are never deleted by the transactions that stop using
+// them, since past versions of the store (see timetravel.go) may
+// still read them. Vacuuming deletes dataobjects that no retained
+// version references:
+//
+//   - ones removed (see update.go) by a transaction committed before
+//     the retention period began, and
+//   - ones no committed transaction ever added, e.g. written by a
+//     transaction that then aborted or conflicted.
+//
+// A version is retained if it was the latest version at any time
+// during the retention period, so a transaction opened with
+// newTxAsOf for a time in the period can still read all of its
+// data. Unreferenced dataobjects are only deleted if they were
+// written before the period began, since they may belong to a
+// transaction that hasn't committed yet. Storage that can't tell
+// when an object was written (see objectInfo) has all of them
+// treated as old enough.
+//
+// Dataobjects of tables with a compliance window (see
+// compliance.go) are kept until they have been added for the
+// window, and reported as protected until then.
+//
+// Dataobjects of a branch's latest version or of a tagged version
+// (see branch.go) are referenced too, however old.
+
+type objectInfo struct {
+	Size int64
+	// Zero if unknown.
+	Modified time.Time
+}
+
+// Object storage that can tell an object's size and when it was
+// written without reading it.
+type objectStater interface {
+	stat(ctx context.Context, name string) (objectInfo, error)
+}
+
+func statObject(ctx context.Context, os objectStorage, name string) (objectInfo, error) {
+	if s, ok := os.(objectStater); ok {
+		return s.stat(ctx, name)
+	}
+
+	bytes, err := os.read(ctx, name)
+	if err != nil {
+		return objectInfo{}, err
+	}
+
+	return objectInfo{Size: int64(len(bytes))}, nil
+}
+
+type vacuumResult struct {
+	// Keys of dataobjects deleted, or that would be in a dry run.
+	Deleted []string
+	Bytes   int64
+	// Keys of unreferenced dataobjects kept because they're within
+	// their table's compliance window.
+	Protected []string
+	// Keys of unreferenced dataobjects not deleted because the
+	// vacuum was stopped or failed part way, see bulk.go.
+	Remaining []string
+}
+
+// Deletes dataobjects not referenced by any version of the store
+// retained for retention, or if dryRun only reports which would be
+// deleted. Doesn't need a transaction.
+func (d *client) vacuum(retention time.Duration, dryRun bool) (*vacuumResult, error) {
+	return d.vacuumContext(context.Background(), retention, dryRun)
+}
+
+// Like vacuum but makes its storage calls with ctx, and stops
+// deleting once it's done, see bulk.go. Within a transaction, reads
+// the log with the transaction's context.
+func (d *client) vacuumContext(ctx context.Context, retention time.Duration, dryRun bool) (*vacuumResult, error) {
+	if d.tx == nil {
+		d.ctx = ctx
+		defer func() {
+			d.ctx = nil
+		}()
+	}
+
+	if d.branch != "" {
+		return nil, fmt.Errorf("%w: vacuum from %s", errBadRequest, MAIN_BRANCH)
+	}
+
+	err := d.checkLogWritable()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-retention)
+
+	names, err := d.listLog()
+	if err != nil {
+		return nil, err
+	}
+
+	var log []*logEntry
+	for _, name := range names {
+		tx, err := d.readLogEntry(name)
+		if err != nil {
+			return nil, err
+		}
+		log = append(log, tx)
+	}
+
+	// Keys of dataobjects live as of the transaction being
+	// replayed, ever added, and referenced by a retained version.
+	live := map[string]bool{}
+	added := map[string]bool{}
+	referenced := map[string]bool{}
+	// When each dataobject was added and to which table, and each
+	// table's compliance window.
+	addedAt := map[string]time.Time{}
+	addedTo := map[string]string{}
+	windows := map[string]time.Duration{}
+	// Keys of each dataobject's objects, see families.go.
+	keysOf := map[string][]string{}
+	for i, tx := range log {
+		// Every version from the last one committed before the
+		// cutoff on is retained.
+		retained := i == len(log)-1 || (log[i+1].CommitInfo != nil && log[i+1].CommitInfo.time().After(cutoff))
+
+		for table, actions := range tx.Actions {
+			for _, action := range actions {
+				if action.AddDataobject != nil {
+					keys := dataobjectKeys(action.AddDataobject)
+					keysOf[keys[0]] = keys
+					for _, key := range keys {
+						live[key] = true
+						added[key] = true
+						addedTo[key] = table
+						if tx.CommitInfo != nil {
+							addedAt[key] = tx.CommitInfo.Timestamp
+						}
+					}
+				}
+				if action.ChangeMetadata != nil {
+					windows[table] = max(windows[table], action.ChangeMetadata.ComplianceWindow)
+				}
+				if action.RemoveDataobject != nil {
+					key := dataobjectKey(action.RemoveDataobject.Table, action.RemoveDataobject.Name)
+					delete(live, key)
+					for _, key := range keysOf[key] {
+						delete(live, key)
+					}
+				}
+			}
+		}
+
+		if retained {
+			for key := range live {
+				referenced[key] = true
+			}
+		}
+	}
+
+	// What branches and tags use, see branch.go.
+	branchReferenced, branchAdded, err := d.branchReferences()
+	if err != nil {
+		return nil, err
+	}
+	for key := range branchReferenced {
+		referenced[key] = true
+	}
+	for key := range branchAdded {
+		added[key] = true
+	}
+
+	keys, err := d.os.listPrefix(ctx, DATAOBJECT_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &vacuumResult{}
+	var unreferenced []string
+	var sizes []int64
+	for _, key := range keys {
+		if referenced[key] {
+			continue
+		}
+
+		info, err := statObject(ctx, d.os, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if !added[key] && info.Modified.After(cutoff) {
+			// Possibly part of a transaction in progress.
+			continue
+		}
+
+		if table, ok := addedTo[key]; ok && withinComplianceWindow(windows[table], addedAt[key], info.Modified) {
+			result.Protected = append(result.Protected, key)
+			continue
+		}
+
+		unreferenced = append(unreferenced, key)
+		sizes = append(sizes, info.Size)
+	}
+
+	for i, key := range unreferenced {
+		if !dryRun {
+			if ctx.Err() != nil {
+				result.Remaining = unreferenced[i:]
+				return result, incomplete(ctx, len(result.Remaining), "dataobjects")
+			}
+
+			err = d.os.delete(ctx, key)
+			if err != nil {
+				// Along with what was deleted so far.
+				result.Remaining = unreferenced[i:]
+				return result, err
+			}
+		}
+
+		result.Deleted = append(result.Deleted, key)
+		result.Bytes += sizes[i]
+	}
+
+	if dryRun {
+		d.debug("vacuum", "would delete dataobjects", "dataobjects", len(result.Deleted), "bytes", result.Bytes)
+		return result, nil
+	}
+
+	d.debug("vacuum", "deleted dataobjects", "dataobjects", len(result.Deleted), "bytes", result.Bytes)
+	return result, nil
+}
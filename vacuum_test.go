@@ -0,0 +1,85 @@
+package otf
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestVacuum(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.writeRow("x", []any{1})
+	assertEq(err, nil, "could not write row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Replaces the first dataobject.
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	n, err := c.updateRows("x", where("a", OP_EQ, 1), map[string]*expr{"a": litExpr(2)})
+	assertEq(err, nil, "could not update")
+	assertEq(n, 1, "updated")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Never commits.
+	aborted := newClient(mos)
+	err = aborted.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = aborted.writeRow("x", []any{3})
+	assertEq(err, nil, "could not write row")
+	err = aborted.flushRows("x")
+	assertEq(err, nil, "could not flush")
+
+	all, err := mos.listPrefix(context.Background(), DATAOBJECT_PREFIX)
+	assertEq(err, nil, "could not list")
+	assertEq(len(all), 3, "dataobjects")
+
+	// Everything is within the retention period.
+	result, err := c.vacuum(time.Hour, false)
+	assertEq(err, nil, "could not vacuum")
+	assertEq(len(result.Deleted), 0, "deleted")
+
+	result, err = c.vacuum(0, true)
+	assertEq(err, nil, "could not vacuum")
+	assertEq(len(result.Deleted), 2, "would delete")
+	var bytes int64
+	for _, key := range result.Deleted {
+		info, err := mos.stat(context.Background(), key)
+		assertEq(err, nil, "dry run deleted "+key)
+		bytes += info.Size
+	}
+	assertEq(result.Bytes, bytes, "reclaimable bytes")
+
+	result, err = c.vacuum(0, false)
+	assertEq(err, nil, "could not vacuum")
+	assertEq(len(result.Deleted), 2, "deleted")
+	assertEq(result.Bytes, bytes, "reclaimed bytes")
+
+	left, err := mos.listPrefix(context.Background(), DATAOBJECT_PREFIX)
+	assertEq(err, nil, "could not list")
+	assertEq(len(left), 1, "dataobjects")
+	assert(!slices.Contains(result.Deleted, left[0]), "deleted a live dataobject")
+
+	err = c.newTx()
+	assertEq(err, nil, "could not start tx")
+	assertEq[any](scanAll(&c, "x")[0][0], float64(2), "row")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// The first version's data is gone.
+	err = c.newTxAt(0)
+	assertEq(err, nil, "could not start tx")
+	it, err := c.scan("x")
+	assertEq(err, nil, "could not scan")
+	_, err = it.next()
+	assert(errors.Is(err, fs.ErrNotExist), "expected missing dataobject")
+}
@@ -0,0 +1,71 @@
+package otf
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// Watching a table tails the log for new commits (see cdc.go) and
+// hands over their changes to the table (see sync.go) as they
+// appear. Rows are in the table's schema as of the transaction that
+// changed them.
+
+const WATCH_INTERVAL = time.Second
+
+// Calls each with the changes to table of every transaction
+// committed after the given one, in commit order, polling every
+// interval once caught up, until stop is closed or each fails.
+func (d *client) watchTable(table string, after int, interval time.Duration, stop <-chan struct{}, each func([]tableChange) error) error {
+	it, err := d.tailChanges(after, interval, stop)
+	if err != nil {
+		return err
+	}
+	it.only = table
+
+	for {
+		changes, err := it.nextTx()
+		if err != nil {
+			return err
+		}
+		if changes == nil {
+			return nil
+		}
+
+		txChanges := make([]tableChange, len(changes))
+		for i, change := range changes {
+			txChanges[i] = tableChange{change.TxId, change.Op, change.Row}
+		}
+
+		err = each(txChanges)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Writes changes to table committed from now on to w, one JSON
+// object per line.
+func (d *client) tailTable(table string, w io.Writer, interval time.Duration, stop <-chan struct{}) error {
+	names, err := d.listLog()
+	if err != nil {
+		return err
+	}
+
+	after := -1
+	if len(names) > 0 {
+		after = logEntryId(names[len(names)-1])
+	}
+
+	encoder := json.NewEncoder(w)
+	return d.watchTable(table, after, interval, stop, func(changes []tableChange) error {
+		for _, change := range changes {
+			err := encoder.Encode(change)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
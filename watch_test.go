@@ -0,0 +1,119 @@
+package otf
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWatchTable(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.createTable("y", []string{"a"})
+	assertEq(err, nil, "could not create y")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	r, w := io.Pipe()
+	stop := make(chan struct{})
+	done := make(chan error)
+	watcher := newClient(mos)
+	go func() {
+		encoder := json.NewEncoder(w)
+		done <- watcher.watchTable("x", 0, time.Millisecond, stop, func(changes []tableChange) error {
+			for _, change := range changes {
+				encoder.Encode(change)
+			}
+			return nil
+		})
+	}()
+
+	for i := 1; i <= 3; i++ {
+		err = c.newTx()
+		assertEq(err, nil, "could not start tx")
+		err = c.writeRow("x", []any{i})
+		assertEq(err, nil, "could not write row")
+		err = c.writeRow("y", []any{i})
+		assertEq(err, nil, "could not write row")
+		err = c.commitTx()
+		assertEq(err, nil, "could not commit")
+	}
+
+	lines := bufio.NewScanner(r)
+	for i := 1; i <= 3; i++ {
+		assert(lines.Scan(), "expected a change")
+		var change tableChange
+		err = json.Unmarshal(lines.Bytes(), &change)
+		assertEq(err, nil, "could not parse change")
+		assertEq(change.TxId, i, "change tx")
+		assertEq(change.Op, CHANGE_INSERT, "change op")
+		assertEq[any](change.Row[0], float64(i), "change row")
+	}
+
+	close(stop)
+	go io.Copy(io.Discard, r)
+	assertEq(<-done, nil, "watch failed")
+}
+
+// Calls hook with the count of log listings so far before each.
+type listHookStorage struct {
+	*memoryObjectStorage
+	lists *int
+	hook  func(lists int)
+}
+
+func (ls listHookStorage) listPrefix(ctx context.Context, prefix string) ([]string, error) {
+	if prefix == "_log_" {
+		*ls.lists++
+		ls.hook(*ls.lists)
+	}
+
+	return ls.memoryObjectStorage.listPrefix(ctx, prefix)
+}
+
+func TestWatchTableCommitWhilePolling(t *testing.T) {
+	mos := newMemoryObjectStorage()
+	c := newClient(mos)
+	err := c.newTx()
+	assertEq(err, nil, "could not start tx")
+	err = c.createTable("x", []string{"a"})
+	assertEq(err, nil, "could not create x")
+	err = c.commitTx()
+	assertEq(err, nil, "could not commit")
+
+	// Commits between the poll's listing and the one reading
+	// changes, then stops after the next poll.
+	stop := make(chan struct{})
+	var lists int
+	watcher := newClient(listHookStorage{mos, &lists, func(lists int) {
+		switch lists {
+		case 2:
+			err := c.newTx()
+			assertEq(err, nil, "could not start tx")
+			err = c.writeRow("x", []any{1})
+			assertEq(err, nil, "could not write row")
+			err = c.commitTx()
+			assertEq(err, nil, "could not commit")
+		case 4:
+			close(stop)
+		}
+	}})
+
+	var txIds []int
+	err = watcher.watchTable("x", 0, time.Millisecond, stop, func(changes []tableChange) error {
+		for _, change := range changes {
+			txIds = append(txIds, change.TxId)
+		}
+		return nil
+	})
+	assertEq(err, nil, "watch failed")
+	assertEq(fmt.Sprint(txIds), "[1]", "changes seen")
+}